@@ -34,17 +34,35 @@ var AgentCmd = &cobra.Command{
 	Long:  `Setup the container-use MCP server according to the specified agent including Claude Code, Goose, Cursor, and others.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
-			return interactiveConfiguration()
+			return interactiveConfiguration(true)
 		}
-		agent, err := selectAgent(args[0])
-		if err != nil {
-			return err
-		}
-		return configureAgent(agent)
+		return Configure(args[0], true)
 	},
 }
 
-func interactiveConfiguration() error {
+// Configure sets up the container-use MCP server for the agent identified by agentKey, as well as
+// its rules if withRules is set. An empty agentKey lets the user select the agent interactively.
+func Configure(agentKey string, withRules bool) error {
+	if agentKey == "" {
+		return interactiveConfiguration(withRules)
+	}
+	agent, err := selectAgent(agentKey)
+	if err != nil {
+		return err
+	}
+	return configureAgent(agent, withRules)
+}
+
+// AgentKeys returns the keys of the agents that can be configured.
+func AgentKeys() []string {
+	keys := []string{}
+	for _, agent := range agents {
+		keys = append(keys, agent.Key)
+	}
+	return keys
+}
+
+func interactiveConfiguration(withRules bool) error {
 	selectedAgent, err := RunAgentSelector()
 	if err != nil {
 		// If the user quits, it's not an error, just exit gracefully.
@@ -58,7 +76,7 @@ func interactiveConfiguration() error {
 	if err != nil {
 		return err
 	}
-	return configureAgent(agent)
+	return configureAgent(agent, withRules)
 }
 
 type ConfigurableAgent interface {
@@ -73,20 +91,24 @@ type ConfigurableAgent interface {
 func selectAgent(agentKey string) (ConfigurableAgent, error) {
 	switch agentKey {
 	case "claude":
-		return &ConfigureClaude{}, nil
+		return NewConfigureClaude(), nil
 	case "goose":
-		return &ConfigureGoose{}, nil
+		return NewConfigureGoose(), nil
 	case "cursor":
-		return &ConfigureCursor{}, nil
+		return NewConfigureCursor(), nil
 	case "codex":
-		return &ConfigureCodex{}, nil
+		return NewConfigureCodex(), nil
 	case "amazonq":
-		return &ConfigureQ{}, nil
+		return NewConfigureQ(), nil
+	case "windsurf":
+		return NewConfigureWindsurf(), nil
+	case "zed":
+		return NewConfigureZed(), nil
 	}
 	return nil, fmt.Errorf("unknown agent: %s", agentKey)
 }
 
-func configureAgent(agent ConfigurableAgent) error {
+func configureAgent(agent ConfigurableAgent, withRules bool) error {
 	fmt.Printf("Configuring %s...\n", agent.name())
 
 	// Save MCP config
@@ -97,11 +119,13 @@ func configureAgent(agent ConfigurableAgent) error {
 	fmt.Printf("✓ Configured %s MCP configuration\n", agent.name())
 
 	// Save rules
-	err = agent.editRules()
-	if err != nil {
-		return err
+	if withRules {
+		err = agent.editRules()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Saved %s container-use rules\n", agent.name())
 	}
-	fmt.Printf("✓ Saved %s container-use rules\n", agent.name())
 
 	fmt.Printf("\n%s configuration complete!\n", agent.name())
 	return nil
@@ -32,15 +32,50 @@ func TestConfigureClaudeUpdateSettings(t *testing.T) {
   "permissions": {
     "allow": [
       "mcp__container-use__environment_open",
+      "mcp__container-use__environment_list",
+      "mcp__container-use__environment_select",
       "mcp__container-use__environment_create",
+      "mcp__container-use__environment_import",
+      "mcp__container-use__environment_create_from_pr",
+      "mcp__container-use__environment_clone",
+      "mcp__container-use__environment_fork",
       "mcp__container-use__environment_update",
+      "mcp__container-use__environment_setup_rerun",
+      "mcp__container-use__environment_build_log",
+      "mcp__container-use__environment_history",
+      "mcp__container-use__environment_diff",
+      "mcp__container-use__environment_sync",
+      "mcp__container-use__environment_conflicts_list",
+      "mcp__container-use__environment_merge_continue",
       "mcp__container-use__environment_run_cmd",
+      "mcp__container-use__environment_process_list",
+      "mcp__container-use__environment_process_logs",
+      "mcp__container-use__environment_process_kill",
+      "mcp__container-use__environment_wait",
+      "mcp__container-use__environment_port_forward",
+      "mcp__container-use__environment_job_start",
+      "mcp__container-use__environment_job_status",
+      "mcp__container-use__environment_job_wait",
+      "mcp__container-use__environment_job_cancel",
       "mcp__container-use__environment_file_read",
       "mcp__container-use__environment_file_list",
+      "mcp__container-use__environment_file_search",
+      "mcp__container-use__environment_file_glob",
+      "mcp__container-use__environment_repo_stats",
+      "mcp__container-use__environment_dependencies",
       "mcp__container-use__environment_file_write",
+      "mcp__container-use__environment_file_edit",
       "mcp__container-use__environment_file_delete",
+      "mcp__container-use__environment_file_move",
+      "mcp__container-use__environment_mkdir",
+      "mcp__container-use__environment_download",
+      "mcp__container-use__environment_flush",
       "mcp__container-use__environment_add_service",
-      "mcp__container-use__environment_checkpoint"
+      "mcp__container-use__environment_secrets_check",
+      "mcp__container-use__environment_checkpoint",
+      "mcp__container-use__environment_restore",
+      "mcp__container-use__environment_export",
+      "mcp__container-use__environment_publish"
     ]
   }
 }`
@@ -55,7 +90,7 @@ func TestConfigureCodexUpdateConfig(t *testing.T) {
 	contains := `[mcp_servers]
 [mcp_servers.container-use]
 args = ['stdio']
-auto_approve = ['environment_open', 'environment_create', 'environment_update', 'environment_run_cmd', 'environment_file_read', 'environment_file_list', 'environment_file_write', 'environment_file_delete', 'environment_add_service', 'environment_checkpoint']
+auto_approve = ['environment_open', 'environment_list', 'environment_select', 'environment_create', 'environment_import', 'environment_create_from_pr', 'environment_clone', 'environment_fork', 'environment_update', 'environment_setup_rerun', 'environment_build_log', 'environment_history', 'environment_diff', 'environment_sync', 'environment_conflicts_list', 'environment_merge_continue', 'environment_run_cmd', 'environment_process_list', 'environment_process_logs', 'environment_process_kill', 'environment_wait', 'environment_port_forward', 'environment_job_start', 'environment_job_status', 'environment_job_wait', 'environment_job_cancel', 'environment_file_read', 'environment_file_list', 'environment_file_search', 'environment_file_glob', 'environment_repo_stats', 'environment_dependencies', 'environment_file_write', 'environment_file_edit', 'environment_file_delete', 'environment_file_move', 'environment_mkdir', 'environment_download', 'environment_flush', 'environment_add_service', 'environment_secrets_check', 'environment_checkpoint', 'environment_restore', 'environment_export', 'environment_publish']
 `
 	editedConfig, err := codex.updateCodexConfig(config)
 	assert.NoError(t, err)
@@ -115,3 +150,60 @@ func TestConfigureCursorUpdateConfig(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, string(editedConfig), expect)
 }
+
+func TestConfigureWindsurfUpdateConfig(t *testing.T) {
+	windsurf := &ConfigureWindsurf{}
+	config := MCPServersConfig{MCPServers: map[string]MCPServer{
+		"other": {Command: "other-server"},
+	}}
+	expect := `{
+  "mcpServers": {
+    "container-use": {
+      "command": "container-use",
+      "args": [
+        "stdio"
+      ]
+    },
+    "other": {
+      "command": "other-server",
+      "args": null
+    }
+  }
+}`
+	editedConfig, err := windsurf.updateMcpConfig(config)
+	assert.NoError(t, err)
+	assert.Equal(t, expect, string(editedConfig))
+}
+
+func TestConfigureZedUpdateSettings(t *testing.T) {
+	zed := &ConfigureZed{}
+	settings := map[string]any{"tab_size": 2}
+	expect := `{
+  "context_servers": {
+    "container-use": {
+      "command": {
+        "args": [
+          "stdio"
+        ],
+        "env": {},
+        "path": "container-use"
+      },
+      "source": "custom"
+    }
+  },
+  "tab_size": 2
+}`
+	editedSettings, err := zed.updateZedSettings(settings)
+	assert.NoError(t, err)
+	assert.Equal(t, expect, string(editedSettings))
+}
+
+func TestSelectAgent(t *testing.T) {
+	for _, key := range AgentKeys() {
+		agent, err := selectAgent(key)
+		assert.NoError(t, err, key)
+		assert.NotEmpty(t, agent.name(), key)
+	}
+	_, err := selectAgent("unknown")
+	assert.Error(t, err)
+}
@@ -42,6 +42,16 @@ var agents = []Agent{
 		Name:        "Amazon Q Developer",
 		Description: "Amazon's agentic chat experience in your terminal",
 	},
+	{
+		Key:         "windsurf",
+		Name:        "Windsurf",
+		Description: "Codeium's agentic IDE",
+	},
+	{
+		Key:         "zed",
+		Name:        "Zed",
+		Description: "High-performance, multiplayer code editor with an agent panel",
+	},
 }
 
 // AgentSelectorModel represents the bubbletea model for agent selection
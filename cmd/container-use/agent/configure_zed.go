@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dagger/container-use/rules"
+)
+
+type ConfigureZed struct {
+	Name        string
+	Description string
+}
+
+func NewConfigureZed() *ConfigureZed {
+	return &ConfigureZed{
+		Name:        "Zed",
+		Description: "High-performance, multiplayer code editor with an agent panel",
+	}
+}
+
+// Return the agents full name
+func (a *ConfigureZed) name() string {
+	return a.Name
+}
+
+// Return a description of the agent
+func (a *ConfigureZed) description() string {
+	return a.Description
+}
+
+// Save the MCP config with container-use enabled, in the project settings
+func (a *ConfigureZed) editMcpConfig() error {
+	configPath := filepath.Join(".zed", "settings.json")
+
+	// Create directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Read existing config or create new. Settings with comments can't be parsed and must be edited by hand.
+	config := make(map[string]any)
+	if data, err := os.ReadFile(configPath); err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("failed to parse existing config %s, add the container-use context server by hand: %w", configPath, err)
+		}
+	}
+
+	data, err := a.updateZedSettings(config)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(configPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+func (a *ConfigureZed) updateZedSettings(config map[string]any) ([]byte, error) {
+	// Get context_servers map
+	var contextServers map[string]any
+	if servers, ok := config["context_servers"].(map[string]any); ok {
+		contextServers = servers
+	} else {
+		contextServers = make(map[string]any)
+		config["context_servers"] = contextServers
+	}
+
+	// Add container-use server
+	contextServers["container-use"] = map[string]any{
+		"source": "custom",
+		"command": map[string]any{
+			"path": ContainerUseBinary,
+			"args": []string{"stdio"},
+			"env":  map[string]string{},
+		},
+	}
+
+	// Write config back
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return data, nil
+}
+
+// Save the agent rules with the container-use prompt
+func (a *ConfigureZed) editRules() error {
+	return saveRulesFile(".rules", rules.AgentRules)
+}
+
+func (a *ConfigureZed) isInstalled() bool {
+	return true
+}
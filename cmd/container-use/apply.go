@@ -42,6 +42,7 @@ git commit -m "Add backend API implementation"`,
 		}
 
 		env := args[0]
+		warnPendingChanges(repo, env)
 
 		if err := repo.Apply(ctx, env, os.Stdout); err != nil {
 			return fmt.Errorf("failed to apply environment: %w", err)
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "Decide on the commands that require approval",
+	Long: `Commands matching the require_approval patterns of the command policy of the
+repository only run once approved.
+
+With approval.wait set in the policy, the tool call running such a command is
+parked until you approve or deny it with these commands. Otherwise it is
+rejected, and you approve the command ahead of the agent's next attempt with
+approvals approve <env> -- <command>.`,
+}
+
+var approvalsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the approvals of the repository",
+	Long:  `List the commands waiting for a decision, and those approved ahead of time that didn't run yet.`,
+	Args:  cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		repo, err := repository.Open(app.Context(), ".")
+		if err != nil {
+			return err
+		}
+		approvals, err := repo.Approvals()
+		if err != nil {
+			return err
+		}
+		if len(approvals) == 0 {
+			fmt.Println("No approvals.")
+			return nil
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tENVIRONMENT\tSTATUS\tREQUESTED\tCOMMAND")
+		for _, approval := range approvals {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", approval.ID, approval.EnvironmentID, approval.Status, humanize.Time(approval.RequestedAt), truncate(app, approval.Command, 60))
+		}
+		return tw.Flush()
+	},
+}
+
+var approvalsApproveCmd = &cobra.Command{
+	Use:   "approve <id> | <env> -- <command>...",
+	Short: "Approve a command",
+	Long: `Approve the parked command <id>, from approvals list, to let its tool call run it.
+
+Or approve a command ahead of time, to let the agent run it once in environment
+<env>. The command must be given exactly as the agent runs it: agents are told
+the command approving it when it is rejected.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Let a parked tool call run its command
+container-use approvals approve 3f9a1c2e
+
+# Let the agent deploy to staging once
+container-use approvals approve fancy-mallard -- './deploy.sh staging'`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		if app.ArgsLenAtDash() < 0 {
+			if len(args) != 1 {
+				return fmt.Errorf("expected the ID of an approval, or the environment, then -- and the command")
+			}
+			approval, err := repo.DecideApproval(args[0], true, "")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Approved %q in environment '%s'.\n", approval.Command, approval.EnvironmentID)
+			return nil
+		}
+
+		if app.ArgsLenAtDash() != 1 || len(args) < 2 {
+			return fmt.Errorf("expected the environment, then -- and the command")
+		}
+		envID, command := args[0], shellJoin(args[1:])
+		policy, err := repo.CommandPolicy()
+		if err != nil {
+			return err
+		}
+		var violation *environment.PolicyViolationError
+		if err := policy.Check(command); errors.As(err, &violation) && violation.Action == environment.PolicyDeny {
+			return fmt.Errorf("%q is denied by pattern %q of %s, approving it would have no effect", command, violation.Pattern, violation.Policy)
+		} else if err == nil {
+			fmt.Printf("Note: the command policy doesn't require approval for %q.\n", command)
+		}
+
+		if _, err := repo.ApproveCommand(ctx, envID, command); err != nil {
+			return err
+		}
+		fmt.Printf("Approved %q to run once in environment '%s'.\n", command, envID)
+		return nil
+	},
+}
+
+var approvalsDenyCmd = &cobra.Command{
+	Use:     "deny <id>",
+	Short:   "Deny a parked command",
+	Long:    `Deny the parked command <id>, from approvals list: its tool call fails, with the reason if given.`,
+	Args:    cobra.ExactArgs(1),
+	Example: `container-use approvals deny 3f9a1c2e --reason "Deploy from CI instead"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		repo, err := repository.Open(app.Context(), ".")
+		if err != nil {
+			return err
+		}
+		reason, _ := app.Flags().GetString("reason")
+		approval, err := repo.DecideApproval(args[0], false, reason)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Denied %q in environment '%s'.\n", approval.Command, approval.EnvironmentID)
+		return nil
+	},
+}
+
+func init() {
+	approvalsDenyCmd.Flags().StringP("reason", "r", "", "Why the command is denied, for the agent")
+	approvalsCmd.AddCommand(approvalsListCmd, approvalsApproveCmd, approvalsDenyCmd)
+	rootCmd.AddCommand(approvalsCmd)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var archiveCmd = &cobra.Command{
+	Use:   "archive <env>",
+	Short: "Move an environment to cold storage",
+	Long: `Save an environment's branch, history and notes to a compressed bundle,
+then remove its worktree and branch to keep managed storage small.
+The environment can be restored at any time with 'container-use unarchive'.
+
+Use --checkpoint to also push the environment's container to a registry
+before archiving it.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Archive an environment
+container-use archive fancy-mallard
+
+# Archive an environment and keep its container as an image
+container-use archive fancy-mallard --checkpoint registry.example.com/me/fancy-mallard:latest`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		checkpoint := ""
+		if destination, _ := app.Flags().GetString("checkpoint"); destination != "" {
+			dag, err := connectDagger(ctx, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer dag.Close()
+
+			env, err := repo.Get(ctx, dag, args[0])
+			if err != nil {
+				return err
+			}
+			checkpoint, err = env.Checkpoint(ctx, destination)
+			if err != nil {
+				return fmt.Errorf("failed to checkpoint environment: %w", err)
+			}
+			fmt.Printf("Checkpoint pushed to %s\n", checkpoint)
+		}
+
+		info, err := repo.Archive(ctx, args[0], checkpoint)
+		if err != nil {
+			return fmt.Errorf("failed to archive environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' archived to %s\n", info.ID, info.Bundle)
+		return nil
+	},
+}
+
+var unarchiveCmd = &cobra.Command{
+	Use:   "unarchive [<env>]",
+	Short: "Restore an archived environment",
+	Long: `Restore an environment moved to cold storage with 'container-use archive',
+including its history and notes.
+Without arguments, lists the archived environments.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: suggestArchives,
+	Example: `# List archived environments
+container-use unarchive
+
+# Restore an environment
+container-use unarchive fancy-mallard`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			archives, err := repo.Archives()
+			if err != nil {
+				return err
+			}
+			if len(archives) == 0 {
+				fmt.Println("No archived environments.")
+				return nil
+			}
+			for _, archive := range archives {
+				fmt.Printf("%s\t%s\tarchived %s\n", archive.ID, archive.Title, humanize.Time(archive.ArchivedAt))
+			}
+			return nil
+		}
+
+		info, err := repo.Unarchive(ctx, args[0])
+		if err != nil {
+			return fmt.Errorf("failed to restore environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' restored.\n", info.ID)
+		if info.Checkpoint != "" {
+			fmt.Printf("It was checkpointed to %s before being archived.\n", info.Checkpoint)
+		}
+		return nil
+	},
+}
+
+func suggestArchives(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	repo, err := repository.Open(cmd.Context(), ".")
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	archives, err := repo.Archives()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	ids := []string{}
+	for _, archive := range archives {
+		ids = append(ids, archive.ID)
+	}
+	return ids, cobra.ShellCompDirectiveKeepOrder
+}
+
+func init() {
+	archiveCmd.Flags().String("checkpoint", "", "Push the environment's container to this image reference before archiving")
+	rootCmd.AddCommand(archiveCmd, unarchiveCmd)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+// auditSummaryArguments are the arguments summarizing a tool call in the audit log, by order of preference.
+var auditSummaryArguments = []string{"command", "target_file", "path", "url", "process_id", "job_id", "explanation"}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit <env>",
+	Short: "Show the tool calls made on an environment",
+	Long: `Show every tool call made on an environment: which tool, when, by which client,
+how long it took and whether it succeeded, along with its arguments.
+
+The audit log is kept in git notes alongside the history of the environment.
+Arguments that look like secrets are redacted, and long ones are truncated.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Review what an agent did in an environment
+container-use audit fancy-mallard
+
+# List the commands that failed in the last hour, as JSON
+container-use audit fancy-mallard --tool environment_run_cmd --status error --since 1h --json`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		entries, err := repo.Audit(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		tool, _ := app.Flags().GetString("tool")
+		status, _ := app.Flags().GetString("status")
+		since, _ := app.Flags().GetDuration("since")
+		var after time.Time
+		if since > 0 {
+			after = time.Now().Add(-since)
+		}
+		entries = filterAudit(entries, tool, repository.AuditStatus(status), after)
+
+		if asJSON, _ := app.Flags().GetBool("json"); asJSON {
+			out, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("No tool calls recorded.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tTOOL\tSTATUS\tDURATION\tCLIENT\tDETAILS")
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.Time.Local().Format(time.DateTime),
+				entry.Tool,
+				entry.Status,
+				entry.Duration.Round(time.Millisecond),
+				cmp.Or(entry.Client, "-"),
+				truncate(app, auditSummary(entry), 60),
+			)
+		}
+		return tw.Flush()
+	},
+}
+
+// filterAudit returns the entries of the given tool and status made after after, where empty values
+// don't filter.
+func filterAudit(entries []*repository.AuditEntry, tool string, status repository.AuditStatus, after time.Time) []*repository.AuditEntry {
+	filtered := []*repository.AuditEntry{}
+	for _, entry := range entries {
+		if (tool == "" || entry.Tool == tool) &&
+			(status == "" || entry.Status == status) &&
+			!entry.Time.Before(after) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// auditSummary describes an audited tool call in a few words: its error if it failed, or its main argument.
+func auditSummary(entry *repository.AuditEntry) string {
+	if entry.Error != "" {
+		return strings.Join(strings.Fields(entry.Error), " ")
+	}
+	for _, name := range auditSummaryArguments {
+		if value, ok := entry.Arguments[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func init() {
+	auditCmd.Flags().String("tool", "", "Only show the calls of this tool")
+	auditCmd.Flags().String("status", "", "Only show the calls with this status: ok, error or failed")
+	auditCmd.Flags().Duration("since", 0, "Only show the calls made within this duration, e.g. 24h")
+	auditCmd.Flags().Bool("json", false, "Output the audit log as JSON")
+	auditCmd.Flags().Bool("no-trunc", false, "Don't truncate output")
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+)
+
+func TestFilterAudit(t *testing.T) {
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*repository.AuditEntry{
+		{Time: start, Tool: "environment_create", Status: repository.AuditOK},
+		{Time: start.Add(time.Hour), Tool: "environment_run_cmd", Status: repository.AuditError},
+		{Time: start.Add(2 * time.Hour), Tool: "environment_run_cmd", Status: repository.AuditOK},
+	}
+
+	tests := []struct {
+		name     string
+		tool     string
+		status   repository.AuditStatus
+		after    time.Time
+		expected int
+	}{
+		{name: "all", expected: 3},
+		{name: "by tool", tool: "environment_run_cmd", expected: 2},
+		{name: "by status", status: repository.AuditError, expected: 1},
+		{name: "by time", after: start.Add(time.Hour), expected: 2},
+		{name: "combined", tool: "environment_run_cmd", status: repository.AuditOK, after: start.Add(time.Hour), expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := filterAudit(entries, tt.tool, tt.status, tt.after); len(got) != tt.expected {
+				t.Errorf("filterAudit() returned %d entries, expected %d", len(got), tt.expected)
+			}
+		})
+	}
+}
+
+func TestAuditSummary(t *testing.T) {
+	tests := []struct {
+		entry    *repository.AuditEntry
+		expected string
+	}{
+		{&repository.AuditEntry{Arguments: map[string]any{"explanation": "Run tests", "command": "go test ./..."}}, "go test ./..."},
+		{&repository.AuditEntry{Arguments: map[string]any{"explanation": "Look around"}}, "Look around"},
+		{&repository.AuditEntry{Arguments: map[string]any{"command": "make"}, Error: "exit code 2:\nmake: *** no rule"}, "exit code 2: make: *** no rule"},
+		{&repository.AuditEntry{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := auditSummary(tt.entry); got != tt.expected {
+			t.Errorf("auditSummary() = %q, expected %q", got, tt.expected)
+		}
+	}
+}
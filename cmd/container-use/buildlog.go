@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var buildLogCmd = &cobra.Command{
+	Use:   "build-log <env>",
+	Short: "Show the setup output of an environment build",
+	Long: `Show the full output of the setup commands run when an environment was created or updated,
+including builds that failed. The most recent build is shown by default.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Show why the last build of an environment failed
+container-use build-log fancy-mallard
+
+# List the saved builds, then show the one before the last
+container-use build-log fancy-mallard --list
+container-use build-log fancy-mallard -n 1`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		if list, _ := app.Flags().GetBool("list"); list {
+			logs, err := repo.BuildLogs(args[0])
+			if err != nil {
+				return err
+			}
+			if len(logs) == 0 {
+				fmt.Println("No build logs.")
+				return nil
+			}
+			for i, log := range logs {
+				status := "ok"
+				if log.Failed {
+					status = "failed"
+				}
+				fmt.Printf("%d\t%s\t%s\n", i, status, humanize.Time(log.StartedAt))
+			}
+			return nil
+		}
+
+		n, _ := app.Flags().GetInt("revision")
+		info, log, err := repo.ReadBuildLog(args[0], n)
+		if err != nil {
+			return err
+		}
+		status := "succeeded"
+		if info.Failed {
+			status = "failed"
+		}
+		fmt.Fprintf(os.Stderr, "Build started %s %s\n", humanize.Time(info.StartedAt), status)
+		fmt.Print(log)
+		return nil
+	},
+}
+
+func init() {
+	buildLogCmd.Flags().IntP("revision", "n", 0, "Build to show: 0 for the most recent, 1 for the one before, and so on")
+	buildLogCmd.Flags().Bool("list", false, "List the saved builds")
+	rootCmd.AddCommand(buildLogCmd)
+}
@@ -35,6 +35,7 @@ container-use checkout fancy-mallard -b my-review-branch`,
 			return err
 		}
 
+		warnPendingChanges(repo, envID)
 		branch, err := repo.Checkout(ctx, envID, branchName)
 		if err != nil {
 			return err
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone <env> <target-repo>",
+	Short: "Create an environment in another repository from an existing one",
+	Long: `Create a new environment in another repository from the configuration of an
+existing environment: base image, setup commands, environment variables, secrets
+and services. The new environment starts from the HEAD of the target repository.
+
+With --with-container, the container of the existing environment is reused instead
+of being built again, so everything installed outside of the workdir carries over.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Reuse the setup of an environment in a sibling repository
+container-use clone fancy-mallard ../frontend
+
+# Skip the setup commands by reusing the container as well
+container-use clone fancy-mallard ../frontend --with-container --title "Frontend work"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		target, err := repository.Open(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", args[1], err)
+		}
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		source, err := repo.Get(ctx, dag, args[0])
+		if err != nil {
+			return err
+		}
+
+		title, _ := app.Flags().GetString("title")
+		if title == "" {
+			title = source.State.Title
+		}
+		withContainer, _ := app.Flags().GetBool("with-container")
+
+		env, err := target.Clone(ctx, dag, source, withContainer, title, fmt.Sprintf("Clone environment %s", source.ID))
+		if err != nil {
+			return fmt.Errorf("failed to clone environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' cloned as '%s' in %s.\n", source.ID, env.ID, args[1])
+		return nil
+	},
+}
+
+func init() {
+	cloneCmd.Flags().StringP("title", "t", "", "Title of the new environment (defaults to the title of the cloned environment)")
+	cloneCmd.Flags().Bool("with-container", false, "Reuse the container of the environment instead of building a new one")
+	rootCmd.AddCommand(cloneCmd)
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/dagger/container-use/cmd/container-use/agent"
@@ -67,13 +69,43 @@ var configShowCmd = &cobra.Command{
 			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 			defer tw.Flush()
 
-			fmt.Fprintf(tw, "Base Image:\t%s\n", config.BaseImage)
+			if config.Dockerfile != "" {
+				fmt.Fprintf(tw, "Dockerfile:\t%s\n", config.Dockerfile)
+				if config.BuildTarget != "" {
+					fmt.Fprintf(tw, "Build Target:\t%s\n", config.BuildTarget)
+				}
+				for _, arg := range config.BuildArgs {
+					fmt.Fprintf(tw, "Build Arg:\t%s\n", arg)
+				}
+			} else {
+				fmt.Fprintf(tw, "Base Image:\t%s\n", config.BaseImage)
+			}
+			if config.Platform != "" {
+				fmt.Fprintf(tw, "Platform:\t%s\n", config.Platform)
+			}
+			if config.User != "" {
+				fmt.Fprintf(tw, "User:\t%s\n", config.User)
+			}
+			if len(config.Entrypoint) > 0 {
+				fmt.Fprintf(tw, "Entrypoint:\t%s\n", strings.Join(config.Entrypoint, " "))
+			}
+			if config.DefaultCommand != "" {
+				fmt.Fprintf(tw, "Default Command:\t%s\n", config.DefaultCommand)
+			}
 			fmt.Fprintf(tw, "Workdir:\t%s\n", config.Workdir)
 
-			if len(config.SetupCommands) > 0 {
+			phases, err := config.Phases()
+			if err != nil {
+				return err
+			}
+			if len(phases) > 0 {
 				fmt.Fprintf(tw, "Setup Commands:\t\n")
-				for i, cmd := range config.SetupCommands {
-					fmt.Fprintf(tw, "  %d.\t%s\n", i+1, cmd)
+				i := 0
+				for _, phase := range phases {
+					for _, cmd := range phase.Commands {
+						i++
+						fmt.Fprintf(tw, "  %d.\t[%s] %s\n", i, phase.Name, cmd)
+					}
 				}
 			} else {
 				fmt.Fprintf(tw, "Setup Commands:\t(none)\n")
@@ -90,6 +122,47 @@ var configShowCmd = &cobra.Command{
 				fmt.Fprintf(tw, "Environment Variables:\t(none)\n")
 			}
 
+			if len(config.EnvFiles) > 0 {
+				fmt.Fprintf(tw, "Env Files:\t\n")
+				for i, path := range config.EnvFiles {
+					fmt.Fprintf(tw, "  %d.\t%s\n", i+1, path)
+				}
+			}
+
+			if len(config.Caches) > 0 {
+				fmt.Fprintf(tw, "Caches:\t\n")
+				for i, dir := range config.Caches {
+					fmt.Fprintf(tw, "  %d.\t%s\n", i+1, dir)
+				}
+			}
+
+			if len(config.Mounts) > 0 {
+				fmt.Fprintf(tw, "Mounts:\t\n")
+				for i, mount := range config.Mounts {
+					fmt.Fprintf(tw, "  %d.\t%s\n", i+1, mount)
+				}
+			}
+
+			if config.Docker {
+				fmt.Fprintf(tw, "Docker:\tenabled\n")
+			}
+			if config.Network.Enforced() {
+				fmt.Fprintf(tw, "Network:\t%s\n", formatNetworkPolicy(config.Network))
+			}
+			if config.ForwardSSHAgent {
+				fmt.Fprintf(tw, "SSH Agent:\tforwarded\n")
+			}
+			if len(config.GitCredentials) > 0 {
+				fmt.Fprintf(tw, "Git Credentials:\t%s\n", strings.Join(config.GitCredentials, ", "))
+			}
+
+			if len(config.Registries) > 0 {
+				fmt.Fprintf(tw, "Registries:\t\n")
+				for i, rc := range config.Registries {
+					fmt.Fprintf(tw, "  %d.\t%s (%s, %s)\n", i+1, rc.Address, rc.Username, rc.Password)
+				}
+			}
+
 			secretKeys := config.Secrets.Keys()
 			if len(secretKeys) > 0 {
 				fmt.Fprintf(tw, "Secrets:\t\n")
@@ -154,11 +227,258 @@ var configBaseImageResetCmd = &cobra.Command{
 	},
 }
 
+// Platform object commands
+var configPlatformCmd = &cobra.Command{
+	Use:   "platform",
+	Short: "Manage the platform of environments",
+	Long:  `Manage the platform new environments run on, by default the one of the host.`,
+}
+
+var configPlatformSetCmd = &cobra.Command{
+	Use:   "set <platform>",
+	Short: "Set the platform of environments",
+	Long: `Set the platform new environments run on: linux/amd64 or linux/arm64, e.g. linux/amd64 on
+Apple Silicon for dependencies only available for x86. Other platforms than the host's are emulated.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		platform := args[0]
+		if err := environment.ValidatePlatform(platform); err != nil {
+			return err
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Platform = platform
+			fmt.Printf("Platform set to: %s\n", platform)
+			return nil
+		})
+	},
+}
+
+var configPlatformGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the platform of environments",
+	Long:  `Display the platform new environments run on, if set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.Platform == "" {
+				fmt.Println("(host)")
+				return nil
+			}
+			fmt.Println(config.Platform)
+			return nil
+		})
+	},
+}
+
+var configPlatformResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Run environments on the platform of the host",
+	Long:  `Unset the platform, so that new environments run on the platform of the host.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Platform = ""
+			fmt.Println("Platform reset to the platform of the host")
+			return nil
+		})
+	},
+}
+
+// User commands
+var configUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Manage the user running commands in environments",
+	Long:  `Manage the user commands of new environments run as, by default the user of the image.`,
+}
+
+var configUserSetCmd = &cobra.Command{
+	Use:   "set <user>",
+	Short: "Set the user running commands",
+	Long: `Set the user commands of new environments run as, as a name or UID with an optional group
+(e.g., "node", "1000:1000"). Names must exist in the image. Setup commands still run as root,
+and the workdir is owned by the user.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user := args[0]
+		if err := environment.ValidateUser(user); err != nil {
+			return err
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.User = user
+			fmt.Printf("User set to: %s\n", user)
+			return nil
+		})
+	},
+}
+
+var configUserGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the user running commands",
+	Long:  `Display the user commands of new environments run as, if set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.User == "" {
+				fmt.Println("(image)")
+				return nil
+			}
+			fmt.Println(config.User)
+			return nil
+		})
+	},
+}
+
+var configUserResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Run commands as the user of the image",
+	Long:  `Unset the user, so that commands of new environments run as the user of the image.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.User = ""
+			fmt.Println("User reset to the user of the image")
+			return nil
+		})
+	},
+}
+
+// Default command commands
+var configDefaultCommandCmd = &cobra.Command{
+	Use:   "default-command",
+	Short: "Manage the default command of environments",
+	Long: `Manage the shell command run by commands without arguments and by containers started from
+checkpoints, by default the command of the image.`,
+}
+
+var configDefaultCommandSetCmd = &cobra.Command{
+	Use:   "set <command>",
+	Short: "Set the default command",
+	Long:  `Set the shell command run by commands without arguments and by containers started from checkpoints (e.g., "npm start").`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.DefaultCommand = args[0]
+			fmt.Printf("Default command set to: %s\n", args[0])
+			return nil
+		})
+	},
+}
+
+var configDefaultCommandGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the default command",
+	Long:  `Display the default command of new environments, if set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if config.DefaultCommand == "" {
+				fmt.Println("(image)")
+				return nil
+			}
+			fmt.Println(config.DefaultCommand)
+			return nil
+		})
+	},
+}
+
+var configDefaultCommandResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Use the default command of the image",
+	Long:  `Unset the default command, so that new environments use the one of their image.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.DefaultCommand = ""
+			fmt.Println("Default command reset to the command of the image")
+			return nil
+		})
+	},
+}
+
+// Entrypoint commands
+var configEntrypointCmd = &cobra.Command{
+	Use:   "entrypoint",
+	Short: "Manage the entrypoint of environments",
+	Long:  `Manage the entrypoint of new environments, by default the entrypoint of the image.`,
+}
+
+var configEntrypointSetCmd = &cobra.Command{
+	Use:   "set <arg>...",
+	Short: "Set the entrypoint",
+	Long:  `Set the entrypoint replacing the one of the image (e.g., "/usr/bin/tini --").`,
+	Args:  cobra.MinimumNArgs(1),
+	// Arguments of the entrypoint, such as --, are not flags of this command
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Entrypoint = args
+			fmt.Printf("Entrypoint set to: %s\n", strings.Join(args, " "))
+			return nil
+		})
+	},
+}
+
+var configEntrypointGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the entrypoint",
+	Long:  `Display the entrypoint of new environments, if set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Entrypoint) == 0 {
+				fmt.Println("(image)")
+				return nil
+			}
+			fmt.Println(strings.Join(config.Entrypoint, " "))
+			return nil
+		})
+	},
+}
+
+var configEntrypointResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Use the entrypoint of the image",
+	Long:  `Unset the entrypoint, so that new environments use the one of their image.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Entrypoint = nil
+			fmt.Println("Entrypoint reset to the entrypoint of the image")
+			return nil
+		})
+	},
+}
+
 // Setup command object commands
 var configSetupCommandCmd = &cobra.Command{
 	Use:   "setup-command",
 	Short: "Manage setup commands",
-	Long:  `Manage setup commands that are run when creating environments.`,
+	Long: `Manage setup commands that are run when creating environments.
+Commands can be grouped into named phases with --phase, run in this order: system, language, deps, project.
+Commands without a phase run before all phases.`,
+}
+
+// setupCommands returns the setup commands of phase, or the plain setup commands if phase is empty.
+func setupCommands(config *environment.EnvironmentConfig, phase string) []string {
+	if phase == "" {
+		return config.SetupCommands
+	}
+	return config.SetupPhases[phase]
+}
+
+func setSetupCommands(config *environment.EnvironmentConfig, phase string, commands []string) {
+	if phase == "" {
+		config.SetupCommands = commands
+		return
+	}
+	if config.SetupPhases == nil {
+		config.SetupPhases = environment.SetupPhases{}
+	}
+	if len(commands) == 0 {
+		delete(config.SetupPhases, phase)
+		return
+	}
+	config.SetupPhases[phase] = commands
+}
+
+func setupPhaseFlag(cmd *cobra.Command) (string, error) {
+	phase, _ := cmd.Flags().GetString("phase")
+	if phase != "" && !slices.Contains(environment.SetupPhaseNames, phase) {
+		return "", fmt.Errorf("unknown phase %q, expected one of %s", phase, strings.Join(environment.SetupPhaseNames, ", "))
+	}
+	return phase, nil
 }
 
 var configSetupCommandAddCmd = &cobra.Command{
@@ -168,8 +488,12 @@ var configSetupCommandAddCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		command := args[0]
+		phase, err := setupPhaseFlag(cmd)
+		if err != nil {
+			return err
+		}
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			config.SetupCommands = append(config.SetupCommands, command)
+			setSetupCommands(config, phase, append(setupCommands(config, phase), command))
 			fmt.Printf("Setup command added: %s\n", command)
 			return nil
 		})
@@ -183,10 +507,15 @@ var configSetupCommandRemoveCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		command := args[0]
+		phase, err := setupPhaseFlag(cmd)
+		if err != nil {
+			return err
+		}
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
 			found := false
-			newCommands := make([]string, 0, len(config.SetupCommands))
-			for _, existing := range config.SetupCommands {
+			existingCommands := setupCommands(config, phase)
+			newCommands := make([]string, 0, len(existingCommands))
+			for _, existing := range existingCommands {
 				if existing != command {
 					newCommands = append(newCommands, existing)
 				} else {
@@ -198,7 +527,7 @@ var configSetupCommandRemoveCmd = &cobra.Command{
 				return fmt.Errorf("setup command not found: %s", command)
 			}
 
-			config.SetupCommands = newCommands
+			setSetupCommands(config, phase, newCommands)
 			fmt.Printf("Setup command removed: %s\n", command)
 			return nil
 		})
@@ -208,16 +537,24 @@ var configSetupCommandRemoveCmd = &cobra.Command{
 var configSetupCommandListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all setup commands",
-	Long:  `List all setup commands that will be run when creating environments.`,
+	Long:  `List all setup commands that will be run when creating environments, by phase.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
-			if len(config.SetupCommands) == 0 {
+			phases, err := config.Phases()
+			if err != nil {
+				return err
+			}
+			if len(phases) == 0 {
 				fmt.Println("No setup commands configured")
 				return nil
 			}
 
-			for i, command := range config.SetupCommands {
-				fmt.Printf("%d. %s\n", i+1, command)
+			i := 0
+			for _, phase := range phases {
+				for _, command := range phase.Commands {
+					i++
+					fmt.Printf("%d. [%s] %s\n", i, phase.Name, command)
+				}
 			}
 			return nil
 		})
@@ -227,10 +564,20 @@ var configSetupCommandListCmd = &cobra.Command{
 var configSetupCommandClearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clear all setup commands",
-	Long:  `Remove all setup commands from the environment configuration.`,
+	Long:  `Remove all setup commands from the environment configuration, or only those of a phase with --phase.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		phase, err := setupPhaseFlag(cmd)
+		if err != nil {
+			return err
+		}
 		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if phase != "" {
+				setSetupCommands(config, phase, nil)
+				fmt.Printf("Setup commands of phase %s cleared\n", phase)
+				return nil
+			}
 			config.SetupCommands = []string{}
+			config.SetupPhases = nil
 			fmt.Println("All setup commands cleared")
 			return nil
 		})
@@ -311,6 +658,394 @@ var configEnvClearCmd = &cobra.Command{
 	},
 }
 
+// Mount object commands
+var configMountCmd = &cobra.Command{
+	Use:   "mount",
+	Short: "Manage host paths mounted in environments",
+	Long: `Manage host directories and files, such as datasets, package mirrors or toolchains, made available in environments.
+Environments get a snapshot of them: changes never reach the host. Only paths within the directories
+listed in [mounts] allow of the global config.toml (~/.config/container-use/config.toml) can be mounted.`,
+}
+
+var configMountAddCmd = &cobra.Command{
+	Use:   "add <host-path> <container-path>",
+	Short: "Mount a host path",
+	Long:  `Mount a host path in new environments (e.g., "~/datasets/imagenet /data/imagenet").`,
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mount := environment.Mount{Source: args[0], Target: args[1]}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Mounts = slices.DeleteFunc(config.Mounts, func(m environment.Mount) bool { return m.Target == mount.Target })
+			config.Mounts = append(config.Mounts, mount)
+			fmt.Printf("Mount added: %s\n", mount)
+			return nil
+		})
+	},
+}
+
+var configMountRemoveCmd = &cobra.Command{
+	Use:   "remove <container-path>",
+	Short: "Remove a mount",
+	Long:  `Stop mounting a host path at a path of the container.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			i := slices.IndexFunc(config.Mounts, func(m environment.Mount) bool { return m.Target == target })
+			if i < 0 {
+				return fmt.Errorf("mount not found: %s", target)
+			}
+			config.Mounts = slices.Delete(config.Mounts, i, i+1)
+			fmt.Printf("Mount removed: %s\n", target)
+			return nil
+		})
+	},
+}
+
+var configMountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all mounts",
+	Long:  `List the host paths mounted in new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Mounts) == 0 {
+				fmt.Println("No mounts configured")
+				return nil
+			}
+
+			for i, mount := range config.Mounts {
+				fmt.Printf("%d. %s\n", i+1, mount)
+			}
+			return nil
+		})
+	},
+}
+
+// Network policy commands
+var configNetworkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage the network policy of environments",
+	Long: `Restrict what the commands, setup commands, background processes and services of environments can reach:
+  full       no restriction (default)
+  allowlist  only the given host names, IP addresses and CIDR ranges, and the services of the environment
+  none       only the services of the environment`,
+}
+
+var configNetworkSetCmd = &cobra.Command{
+	Use:   "set <mode> [destination...]",
+	Short: "Set the network policy",
+	Long: `Set the network mode of new environments, and the destinations allowed in allowlist mode
+(e.g., "allowlist proxy.golang.org sum.golang.org 10.0.0.0/8", "none", "full").`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy := environment.NetworkPolicy{Mode: environment.NetworkMode(args[0]), Allow: args[1:]}
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		if policy.Mode == environment.NetworkAllowlist && len(policy.Allow) == 0 {
+			fmt.Println("Warning: no destination allowed, environments can only reach their services")
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Network = policy
+			if policy.Mode == environment.NetworkFull {
+				config.Network = environment.NetworkPolicy{}
+			}
+			fmt.Printf("Network policy set to: %s\n", formatNetworkPolicy(policy))
+			return nil
+		})
+	},
+}
+
+var configNetworkGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "Get the network policy",
+	Long:  `Display the network mode of new environments, and the destinations allowed in allowlist mode.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			fmt.Println(formatNetworkPolicy(config.Network))
+			return nil
+		})
+	},
+}
+
+func formatNetworkPolicy(policy environment.NetworkPolicy) string {
+	policy = policy.Active()
+	if policy.Mode != environment.NetworkAllowlist {
+		return string(policy.Mode)
+	}
+	return fmt.Sprintf("%s (%s)", policy.Mode, strings.Join(policy.Allow, ", "))
+}
+
+// Credential forwarding commands
+// Docker commands
+var configDockerCmd = &cobra.Command{
+	Use:   "docker",
+	Short: "Manage the Docker daemon of environments",
+	Long: `Run a Docker daemon next to environments, so that projects can build and run containers in
+their tests (e.g., "docker build", "docker compose up"). The daemon runs with root capabilities in its
+own container, isolated from the host and from its Docker daemon.`,
+}
+
+var configDockerEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Run a Docker daemon next to environments",
+	Long:  `Run a Docker daemon next to new environments, and install the Docker CLI in them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Docker = true
+			fmt.Println("Docker enabled")
+			return nil
+		})
+	},
+}
+
+var configDockerDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop running a Docker daemon next to environments",
+	Long:  `Stop running a Docker daemon next to new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Docker = false
+			fmt.Println("Docker disabled")
+			return nil
+		})
+	},
+}
+
+var configSSHAgentCmd = &cobra.Command{
+	Use:   "ssh-agent",
+	Short: "Manage forwarding of the host SSH agent",
+	Long: `Forward the SSH agent of the host into environments, so that setup commands can fetch private
+dependencies over SSH (e.g., "go mod download", "pip install git+ssh://..."). Keys never leave the host.`,
+}
+
+var configSSHAgentEnableCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Forward the host SSH agent",
+	Long:  `Forward the SSH agent of the host (SSH_AUTH_SOCK) into new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.ForwardSSHAgent = true
+			fmt.Println("SSH agent forwarding enabled")
+			return nil
+		})
+	},
+}
+
+var configSSHAgentDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Stop forwarding the host SSH agent",
+	Long:  `Stop forwarding the SSH agent of the host into new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.ForwardSSHAgent = false
+			fmt.Println("SSH agent forwarding disabled")
+			return nil
+		})
+	},
+}
+
+var configGitCredentialsCmd = &cobra.Command{
+	Use:   "git-credentials",
+	Short: "Manage forwarding of host git credentials",
+	Long: `Forward the git credentials of the host for some hosts into environments, so that setup commands can
+fetch private dependencies over HTTPS. Credentials are read from the git credential helper of the host
+(e.g., the one set up by "gh auth setup-git") and provided to environments as secrets.`,
+}
+
+var configGitCredentialsAddCmd = &cobra.Command{
+	Use:   "add <host>",
+	Short: "Forward the git credentials of a host",
+	Long:  `Forward the git credentials of a host (e.g., "github.com", "gitlab.example.com") into new environments.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host := args[0]
+		if strings.Contains(host, "/") {
+			return fmt.Errorf("expected a host name, without scheme or path, got %q", host)
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if slices.Contains(config.GitCredentials, host) {
+				return fmt.Errorf("git credentials already forwarded: %s", host)
+			}
+			config.GitCredentials = append(config.GitCredentials, host)
+			fmt.Printf("Git credentials forwarded: %s\n", host)
+			return nil
+		})
+	},
+}
+
+var configGitCredentialsRemoveCmd = &cobra.Command{
+	Use:   "remove <host>",
+	Short: "Stop forwarding the git credentials of a host",
+	Long:  `Stop forwarding the git credentials of a host into new environments.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		host := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			i := slices.Index(config.GitCredentials, host)
+			if i < 0 {
+				return fmt.Errorf("git credentials not forwarded: %s", host)
+			}
+			config.GitCredentials = slices.Delete(config.GitCredentials, i, i+1)
+			fmt.Printf("Git credentials no longer forwarded: %s\n", host)
+			return nil
+		})
+	},
+}
+
+var configGitCredentialsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the hosts whose git credentials are forwarded",
+	Long:  `List the hosts whose git credentials are forwarded into new environments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.GitCredentials) == 0 {
+				fmt.Println("No git credentials forwarded")
+				return nil
+			}
+
+			for i, host := range config.GitCredentials {
+				fmt.Printf("%d. %s\n", i+1, host)
+			}
+			return nil
+		})
+	},
+}
+
+// Registry object commands
+var configRegistryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Manage private registry credentials",
+	Long: `Manage the credentials of private registries that base images and service images are pulled from,
+and checkpoints pushed to. Registries without credentials are reached with those of the host (docker login).`,
+}
+
+var configRegistryAddCmd = &cobra.Command{
+	Use:   "add <address> <username> <password-reference>",
+	Short: "Add registry credentials",
+	Long: `Add or replace the credentials of a registry. The password is a secret reference, never the password itself
+(e.g., "ghcr.io me env://GHCR_TOKEN", "registry.example.com ci op://Engineering/Registry/password").`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		credentials := environment.RegistryCredentials{Address: args[0], Username: args[1], Password: args[2]}
+		if err := credentials.Validate(); err != nil {
+			return err
+		}
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			config.Registries = slices.DeleteFunc(config.Registries, func(rc environment.RegistryCredentials) bool {
+				return rc.Address == credentials.Address
+			})
+			config.Registries = append(config.Registries, credentials)
+			fmt.Printf("Registry credentials added: %s\n", credentials.Address)
+			return nil
+		})
+	},
+}
+
+var configRegistryRemoveCmd = &cobra.Command{
+	Use:   "remove <address>",
+	Short: "Remove registry credentials",
+	Long:  `Remove the credentials of a registry.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			i := slices.IndexFunc(config.Registries, func(rc environment.RegistryCredentials) bool { return rc.Address == address })
+			if i < 0 {
+				return fmt.Errorf("registry not found: %s", address)
+			}
+			config.Registries = slices.Delete(config.Registries, i, i+1)
+			fmt.Printf("Registry credentials removed: %s\n", address)
+			return nil
+		})
+	},
+}
+
+var configRegistryListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registry credentials",
+	Long:  `List the registries with credentials, and the secret references of their passwords.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.Registries) == 0 {
+				fmt.Println("No registry credentials configured")
+				return nil
+			}
+
+			for i, rc := range config.Registries {
+				fmt.Printf("%d. %s (%s, %s)\n", i+1, rc.Address, rc.Username, rc.Password)
+			}
+			return nil
+		})
+	},
+}
+
+// Env file object commands
+var configEnvFileCmd = &cobra.Command{
+	Use:   "env-file",
+	Short: "Manage .env files",
+	Long: `Manage .env files whose entries are loaded when creating environments.
+Files are loaded in order, later files overriding earlier ones. Variables set with "config env" and "config secret" take precedence.
+Relative paths are read from the repository and loaded as environment variables; absolute paths are read from the host and loaded as secrets.
+Missing files are skipped.`,
+}
+
+var configEnvFileAddCmd = &cobra.Command{
+	Use:   "add <path>",
+	Short: "Add a .env file",
+	Long:  `Add a .env file to be loaded when creating new environments (e.g., ".env", ".env.local", "~/.config/myapp/.env").`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if slices.Contains(config.EnvFiles, path) {
+				return fmt.Errorf("env file already configured: %s", path)
+			}
+			config.EnvFiles = append(config.EnvFiles, path)
+			fmt.Printf("Env file added: %s\n", path)
+			return nil
+		})
+	},
+}
+
+var configEnvFileRemoveCmd = &cobra.Command{
+	Use:   "remove <path>",
+	Short: "Remove a .env file",
+	Long:  `Stop loading a .env file when creating environments.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			i := slices.Index(config.EnvFiles, path)
+			if i < 0 {
+				return fmt.Errorf("env file not found: %s", path)
+			}
+			config.EnvFiles = slices.Delete(config.EnvFiles, i, i+1)
+			fmt.Printf("Env file removed: %s\n", path)
+			return nil
+		})
+	},
+}
+
+var configEnvFileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all .env files",
+	Long:  `List the .env files that will be loaded when creating environments, in load order.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			if len(config.EnvFiles) == 0 {
+				fmt.Println("No env files configured")
+				return nil
+			}
+
+			for i, path := range config.EnvFiles {
+				fmt.Printf("%d. %s\n", i+1, path)
+			}
+			return nil
+		})
+	},
+}
+
 // Secret object commands
 var configSecretCmd = &cobra.Command{
 	Use:   "secret",
@@ -334,6 +1069,32 @@ var configSecretSetCmd = &cobra.Command{
 	},
 }
 
+var configSecretImportCmd = &cobra.Command{
+	Use:   "import <pattern>...",
+	Short: "Import host environment variables as secrets",
+	Long: `Add a secret referencing each host environment variable whose name matches one of the patterns.
+Patterns use shell wildcards (e.g. "AWS_*"). Values are read from the host environment when
+environments are built, so they are never stored in the configuration.`,
+	Example: `# Make AWS credentials and npm settings available in new environments
+container-use config secret import 'AWS_*' 'npm_config_*'`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			names, err := config.ImportHostEnv(args)
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				return fmt.Errorf("no host environment variable matches %v", args)
+			}
+			for _, name := range names {
+				fmt.Printf("Secret set: %s=env://%s\n", name, name)
+			}
+			return nil
+		})
+	},
+}
+
 var configSecretUnsetCmd = &cobra.Command{
 	Use:   "unset <key>",
 	Short: "Unset a secret",
@@ -372,6 +1133,51 @@ var configSecretListCmd = &cobra.Command{
 	},
 }
 
+var configSecretCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that secrets can be resolved",
+	Long: `Resolve every configured secret without printing its value, and report the ones
+that are missing, empty or whose provider (1Password, Vault, ...) can't be reached.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withConfig(cmd, func(config *environment.EnvironmentConfig) error {
+			secrets := slices.Clone(config.Secrets)
+			for _, rc := range config.Registries {
+				// The password of registry credentials is a secret reference too
+				secrets = append(secrets, "registry:"+rc.Address+"="+rc.Password)
+			}
+			if len(secrets) == 0 {
+				fmt.Println("No secrets configured")
+				return nil
+			}
+
+			ctx := cmd.Context()
+			dag, err := connectDagger(ctx, os.Stderr)
+			if err != nil {
+				return err
+			}
+			defer dag.Close()
+
+			failed := 0
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tREFERENCE\tSTATUS")
+			for _, status := range environment.CheckSecrets(ctx, dag, secrets) {
+				result := "ok"
+				if !status.OK {
+					result = status.Error
+					failed++
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", status.Name, status.Reference, result)
+			}
+			tw.Flush()
+
+			if failed > 0 {
+				return fmt.Errorf("%d secret(s) could not be resolved", failed)
+			}
+			return nil
+		})
+	},
+}
+
 var configSecretClearCmd = &cobra.Command{
 	Use:   "clear",
 	Short: "Clear all secrets",
@@ -391,29 +1197,94 @@ func init() {
 	configBaseImageCmd.AddCommand(configBaseImageGetCmd)
 	configBaseImageCmd.AddCommand(configBaseImageResetCmd)
 
+	// Add platform commands
+	configPlatformCmd.AddCommand(configPlatformSetCmd)
+	configPlatformCmd.AddCommand(configPlatformGetCmd)
+	configPlatformCmd.AddCommand(configPlatformResetCmd)
+
+	// Add default command and entrypoint commands
+	configDefaultCommandCmd.AddCommand(configDefaultCommandSetCmd)
+	configDefaultCommandCmd.AddCommand(configDefaultCommandGetCmd)
+	configDefaultCommandCmd.AddCommand(configDefaultCommandResetCmd)
+	configEntrypointCmd.AddCommand(configEntrypointSetCmd)
+	configEntrypointCmd.AddCommand(configEntrypointGetCmd)
+	configEntrypointCmd.AddCommand(configEntrypointResetCmd)
+
+	// Add user commands
+	configUserCmd.AddCommand(configUserSetCmd)
+	configUserCmd.AddCommand(configUserGetCmd)
+	configUserCmd.AddCommand(configUserResetCmd)
+
 	// Add setup-command commands
 	configSetupCommandCmd.AddCommand(configSetupCommandAddCmd)
 	configSetupCommandCmd.AddCommand(configSetupCommandRemoveCmd)
 	configSetupCommandCmd.AddCommand(configSetupCommandListCmd)
 	configSetupCommandCmd.AddCommand(configSetupCommandClearCmd)
 
+	for _, cmd := range []*cobra.Command{configSetupCommandAddCmd, configSetupCommandRemoveCmd, configSetupCommandClearCmd} {
+		cmd.Flags().String("phase", "", "Setup phase: "+strings.Join(environment.SetupPhaseNames, ", "))
+	}
+
 	// Add env commands
 	configEnvCmd.AddCommand(configEnvSetCmd)
 	configEnvCmd.AddCommand(configEnvUnsetCmd)
 	configEnvCmd.AddCommand(configEnvListCmd)
 	configEnvCmd.AddCommand(configEnvClearCmd)
 
+	// Add env-file commands
+	configEnvFileCmd.AddCommand(configEnvFileAddCmd)
+	configEnvFileCmd.AddCommand(configEnvFileRemoveCmd)
+	configEnvFileCmd.AddCommand(configEnvFileListCmd)
+
+	// Add mount commands
+	configMountCmd.AddCommand(configMountAddCmd)
+	configMountCmd.AddCommand(configMountRemoveCmd)
+	configMountCmd.AddCommand(configMountListCmd)
+
+	// Add Docker commands
+	configDockerCmd.AddCommand(configDockerEnableCmd)
+	configDockerCmd.AddCommand(configDockerDisableCmd)
+
+	// Add network commands
+	configNetworkCmd.AddCommand(configNetworkSetCmd)
+	configNetworkCmd.AddCommand(configNetworkGetCmd)
+
+	// Add credential forwarding commands
+	configSSHAgentCmd.AddCommand(configSSHAgentEnableCmd)
+	configSSHAgentCmd.AddCommand(configSSHAgentDisableCmd)
+	configGitCredentialsCmd.AddCommand(configGitCredentialsAddCmd)
+	configGitCredentialsCmd.AddCommand(configGitCredentialsRemoveCmd)
+	configGitCredentialsCmd.AddCommand(configGitCredentialsListCmd)
+
+	// Add registry commands
+	configRegistryCmd.AddCommand(configRegistryAddCmd)
+	configRegistryCmd.AddCommand(configRegistryRemoveCmd)
+	configRegistryCmd.AddCommand(configRegistryListCmd)
+
 	// Add secret commands
 	configSecretCmd.AddCommand(configSecretSetCmd)
+	configSecretCmd.AddCommand(configSecretImportCmd)
+	configSecretCmd.AddCommand(configSecretCheckCmd)
 	configSecretCmd.AddCommand(configSecretUnsetCmd)
 	configSecretCmd.AddCommand(configSecretListCmd)
 	configSecretCmd.AddCommand(configSecretClearCmd)
 
 	// Add object commands to config
 	configCmd.AddCommand(configBaseImageCmd)
+	configCmd.AddCommand(configPlatformCmd)
+	configCmd.AddCommand(configUserCmd)
+	configCmd.AddCommand(configDefaultCommandCmd)
+	configCmd.AddCommand(configEntrypointCmd)
 	configCmd.AddCommand(configSetupCommandCmd)
 	configCmd.AddCommand(configEnvCmd)
+	configCmd.AddCommand(configEnvFileCmd)
 	configCmd.AddCommand(configSecretCmd)
+	configCmd.AddCommand(configRegistryCmd)
+	configCmd.AddCommand(configMountCmd)
+	configCmd.AddCommand(configDockerCmd)
+	configCmd.AddCommand(configNetworkCmd)
+	configCmd.AddCommand(configSSHAgentCmd)
+	configCmd.AddCommand(configGitCredentialsCmd)
 	configCmd.AddCommand(configShowCmd)
 
 	// Add agent command
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var cpCmd = &cobra.Command{
+	Use:   "cp <env>:<path> <host-path>",
+	Short: "Copy files out of an environment",
+	Long: `Copy a file or directory from an environment's container to the host.
+Unlike checkout, this includes files that are never committed to git,
+such as built binaries, archives or coverage reports.`,
+	Args: cobra.ExactArgs(2),
+	Example: `# Copy a built binary to the current directory
+container-use cp fancy-mallard:bin/app ./app
+
+# Copy an entire directory
+container-use cp fancy-mallard:/workdir/coverage ./coverage`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		envID, source, err := parseEnvPath(args[0])
+		if err != nil {
+			return err
+		}
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		exported, err := env.Download(ctx, source, args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Copied %s to %s\n", args[0], exported)
+		return nil
+	},
+}
+
+// parseEnvPath splits an `<env>:<path>` argument into its environment ID and path.
+func parseEnvPath(arg string) (string, string, error) {
+	envID, path, found := strings.Cut(arg, ":")
+	if !found || envID == "" || path == "" {
+		return "", "", fmt.Errorf("invalid source %q: expected <env>:<path>", arg)
+	}
+	return envID, path, nil
+}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseEnvPath(t *testing.T) {
+	tests := []struct {
+		name      string
+		arg       string
+		wantEnv   string
+		wantPath  string
+		wantError bool
+	}{
+		{
+			name:     "relative path",
+			arg:      "fancy-mallard:bin/app",
+			wantEnv:  "fancy-mallard",
+			wantPath: "bin/app",
+		},
+		{
+			name:     "absolute path",
+			arg:      "fancy-mallard:/workdir/coverage",
+			wantEnv:  "fancy-mallard",
+			wantPath: "/workdir/coverage",
+		},
+		{
+			name:      "missing separator",
+			arg:       "fancy-mallard",
+			wantError: true,
+		},
+		{
+			name:      "missing path",
+			arg:       "fancy-mallard:",
+			wantError: true,
+		},
+		{
+			name:      "missing environment",
+			arg:       ":bin/app",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envID, path, err := parseEnvPath(tt.arg)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("parseEnvPath(%q) expected error", tt.arg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEnvPath(%q) unexpected error: %v", tt.arg, err)
+			}
+			if envID != tt.wantEnv || path != tt.wantPath {
+				t.Errorf("parseEnvPath(%q) = %q, %q, want %q, %q", tt.arg, envID, path, tt.wantEnv, tt.wantPath)
+			}
+		})
+	}
+}
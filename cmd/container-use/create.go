@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var createCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a new environment",
+	Long: `Create a new environment in the current repository, as an agent would with
+environment_create, and print its ID for agents to work in.
+
+By default the environment starts from the checked out commit. With --ref it
+starts from another branch, tag or commit instead, e.g. to reproduce a bug in a
+release. Refs missing from the repository are fetched from its origin remote.
+With --pr it starts from the head of a pull request of the GitHub repository
+origin points to, e.g. to have an agent review or fix it.`,
+	Args: cobra.NoArgs,
+	Example: `# Reproduce a bug reported against a release
+container-use create --ref v1.4.2 --title "Reproduce the login crash"
+
+# Start from a teammate's branch with a preset toolchain
+container-use create --ref origin/feature/api --template go-1.23
+
+# Fix a pull request
+container-use create --pr 123`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		title, _ := app.Flags().GetString("title")
+		ref, _ := app.Flags().GetString("ref")
+		templateName, _ := app.Flags().GetString("template")
+		pr, _ := app.Flags().GetInt("pr")
+		if pr != 0 && ref != "" {
+			return fmt.Errorf("--ref and --pr can't be used together")
+		}
+
+		var template *environment.Template
+		if templateName != "" {
+			if template, err = repository.Template(templateName); err != nil {
+				return err
+			}
+		}
+		if title == "" && pr == 0 {
+			title = "New environment"
+			if ref != "" {
+				title = ref
+			}
+		}
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		if pr != 0 {
+			env, err := repo.CreateFromPullRequest(ctx, dag, pr, "", template, title, fmt.Sprintf("Create environment from pull request #%d", pr))
+			if err != nil {
+				return fmt.Errorf("failed to create environment: %w", err)
+			}
+			fmt.Printf("Environment '%s' created from %s.\n", env.ID, env.State.PullRequest.URL)
+			return nil
+		}
+
+		explanation := "Create environment " + title
+		if ref == "" {
+			ref = "HEAD"
+		} else {
+			explanation = fmt.Sprintf("Create environment %s from %s", title, ref)
+		}
+		env, err := repo.CreateFromRef(ctx, dag, ref, template, title, explanation)
+		if err != nil {
+			return fmt.Errorf("failed to create environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' created.\n", env.ID)
+		return nil
+	},
+}
+
+func init() {
+	createCmd.Flags().StringP("title", "t", "", "Title of the environment (defaults to the ref, or the title of the pull request)")
+	createCmd.Flags().String("ref", "", "Branch, tag or commit to start from (defaults to the checked out commit)")
+	createCmd.Flags().Int("pr", 0, "Number of the GitHub pull request to start from")
+	createCmd.Flags().String("template", "", "Preset to configure the environment with, instead of the configuration of the repository")
+	rootCmd.AddCommand(createCmd)
+}
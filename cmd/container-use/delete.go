@@ -11,7 +11,8 @@ var deleteCmd = &cobra.Command{
 	Use:   "delete [<env>...]",
 	Short: "Delete environments and start fresh",
 	Long: `Delete one or more environments and their associated resources.
-This permanently removes the environment's branch and container state.
+This permanently removes the environment's branch and container state,
+along with the local branches tracking it unless they have commits of their own.
 Use this when starting over with a different approach.
 
 Use --all to delete all environments at once.`,
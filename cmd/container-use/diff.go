@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/dagger/container-use/repository"
@@ -28,10 +29,19 @@ container-use diff backend-api`,
 			return err
 		}
 
+		warnPendingChanges(repo, args[0])
 		return repo.Diff(ctx, args[0], os.Stdout)
 	},
 }
 
+// warnPendingChanges tells the user that environment id has changes left pending by the lazy propagation,
+// which its branch doesn't have yet.
+func warnPendingChanges(repo *repository.Repository, id string) {
+	if pending, err := repo.HasPendingChanges(id); err == nil && pending {
+		fmt.Fprintf(os.Stderr, "Warning: %s has changes that are not committed yet, ask the agent to call environment_flush to include them\n", id)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(diffCmd)
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger/engineconn"
+	"github.com/dagger/container-use/repository"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// minGitVersion is the oldest git supporting everything container-use relies on, such as GIT_CONFIG_COUNT.
+var minGitVersion = [3]int{2, 31, 0}
+
+type checkStatus string
+
+const (
+	checkOK      checkStatus = "✓"
+	checkWarning checkStatus = "!"
+	checkFailed  checkStatus = "✗"
+	checkSkipped checkStatus = "-"
+)
+
+// checkResult is the outcome of a doctor check, along with how to fix it if it didn't pass.
+type checkResult struct {
+	Name   string
+	Status checkStatus
+	Detail string
+	Fix    string
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose problems with the container-use setup",
+	Long: `Check that everything container-use depends on is available and working:
+git, Docker, the Dagger engine, the container-use data directory and, when run
+in a repository, its container-use remote and worktrees.
+Each failed check comes with a suggested fix.`,
+	Args: cobra.NoArgs,
+	Example: `# Check the setup for the current repository
+container-use doctor`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		timeout, _ := app.Flags().GetDuration("timeout")
+
+		results := []checkResult{
+			checkGit(ctx),
+			checkDocker(ctx),
+			checkEngine(ctx, timeout),
+			checkDataDir(repository.DefaultBasePath()),
+		}
+		results = append(results, checkRepository(ctx)...)
+
+		failed := 0
+		for _, result := range results {
+			printCheck(os.Stdout, result)
+			if result.Status == checkFailed {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d checks failed", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func printCheck(w io.Writer, result checkResult) {
+	fmt.Fprintf(w, "%s %s: %s\n", result.Status, result.Name, result.Detail)
+	if result.Fix != "" && (result.Status == checkFailed || result.Status == checkWarning) {
+		fmt.Fprintf(w, "    fix: %s\n", result.Fix)
+	}
+}
+
+func checkGit(ctx context.Context) checkResult {
+	result := checkResult{Name: "git"}
+	out, err := exec.CommandContext(ctx, "git", "--version").Output()
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = fmt.Sprintf("git is not available: %s", err)
+		result.Fix = "install git and make sure it is in your PATH"
+		return result
+	}
+	version, ok := parseGitVersion(string(out))
+	if !ok {
+		result.Status = checkWarning
+		result.Detail = fmt.Sprintf("unknown version %q", strings.TrimSpace(string(out)))
+		return result
+	}
+	result.Detail = fmt.Sprintf("version %d.%d.%d", version[0], version[1], version[2])
+	if compareVersions(version, minGitVersion) < 0 {
+		result.Status = checkFailed
+		result.Fix = fmt.Sprintf("upgrade git to %d.%d or later", minGitVersion[0], minGitVersion[1])
+		return result
+	}
+	result.Status = checkOK
+	return result
+}
+
+// parseGitVersion parses the output of git --version, e.g. "git version 2.39.3 (Apple Git-145)".
+func parseGitVersion(output string) ([3]int, bool) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return [3]int{}, false
+	}
+	var version [3]int
+	for i, part := range strings.SplitN(fields[2], ".", 4) {
+		if i == len(version) {
+			break
+		}
+		// Ignore suffixes such as 2.45.windows or 2.46.0-rc1
+		digits := strings.IndexFunc(part, func(r rune) bool { return r < '0' || r > '9' })
+		if digits >= 0 {
+			part = part[:digits]
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			if i < 2 {
+				return [3]int{}, false
+			}
+			break
+		}
+		version[i] = n
+	}
+	return version, true
+}
+
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+func checkDocker(ctx context.Context) checkResult {
+	result := checkResult{Name: "docker"}
+	if engine := os.Getenv(sharedEngineEnv); engine != "" {
+		result.Status = checkSkipped
+		result.Detail = fmt.Sprintf("not needed, using the shared engine at %s", engine)
+		return result
+	}
+	if runnerHost := os.Getenv(daggerRunnerHostEnv); runnerHost != "" {
+		result.Status = checkSkipped
+		result.Detail = fmt.Sprintf("not needed, using the engine at %s", runnerHost)
+		return result
+	}
+
+	if _, err := exec.LookPath("docker"); err != nil {
+		result.Status = checkFailed
+		result.Detail = "the docker CLI is not installed"
+		result.Fix = "install Docker (https://docs.docker.com/get-docker/) or a compatible runtime providing the docker CLI"
+		return result
+	}
+	out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput()
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = fmt.Sprintf("the Docker daemon is not reachable: %s", strings.TrimSpace(string(out)))
+		result.Fix = "start Docker and try again"
+		if socket := dockerSocketPath(); socket != "" {
+			if _, statErr := os.Stat(socket); errors.Is(statErr, os.ErrPermission) || strings.Contains(string(out), "permission denied") {
+				result.Fix = fmt.Sprintf("make sure you can access %s, e.g. by joining the docker group, then log in again", socket)
+			}
+		}
+		return result
+	}
+	result.Status = checkOK
+	result.Detail = fmt.Sprintf("daemon version %s", strings.TrimSpace(string(out)))
+	return result
+}
+
+func checkEngine(ctx context.Context, timeout time.Duration) checkResult {
+	result := checkResult{Name: "dagger engine"}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dag, err := connectDagger(ctx, io.Discard)
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = err.Error()
+		result.Fix = "fix the docker check first, or set CONTAINER_USE_ENGINE to a running engine; `container-use version --remote` shows the engine in use"
+		return result
+	}
+	defer dag.Close()
+
+	engineVersion, err := dag.Version(ctx)
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = fmt.Sprintf("failed to query the engine: %s", err)
+		result.Fix = "restart the engine, e.g. with `container-use engine stop && container-use engine start` for a shared engine"
+		return result
+	}
+	result.Status = checkOK
+	result.Detail = fmt.Sprintf("version %s", engineVersion)
+	if skew, err := checkVersionSkew(engineVersion, engineconn.CLIVersion); err != nil {
+		result.Status = checkFailed
+		result.Detail += ", " + err.Error()
+	} else if skew != "" {
+		result.Status = checkWarning
+		result.Detail += ", " + skew
+	}
+	return result
+}
+
+// checkDataDir checks that container-use can store its data in dir.
+func checkDataDir(dir string) checkResult {
+	result := checkResult{Name: "data directory"}
+	fix := fmt.Sprintf("make %s writable by your user, or set CONTAINER_USE_CONFIG_DIR to a writable directory", dir)
+
+	dir, err := homedir.Expand(dir)
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = err.Error()
+		result.Fix = fix
+		return result
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		result.Status = checkFailed
+		result.Detail = err.Error()
+		result.Fix = fix
+		return result
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		result.Status = checkFailed
+		result.Detail = err.Error()
+		result.Fix = fix
+		return result
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	result.Status = checkOK
+	result.Detail = fmt.Sprintf("%s is writable", filepath.Clean(dir))
+	return result
+}
+
+// checkRepository checks the container-use setup of the repository in the current directory, if any.
+func checkRepository(ctx context.Context) []checkResult {
+	remote := checkResult{Name: "repository remote"}
+	if _, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output(); err != nil {
+		remote.Status = checkSkipped
+		remote.Detail = "not in a git repository"
+		return []checkResult{remote}
+	}
+
+	repo, err := repository.Open(ctx, ".")
+	if err != nil {
+		remote.Status = checkFailed
+		remote.Detail = err.Error()
+		remote.Fix = "check the permissions of the repository and of the data directory"
+		return []checkResult{remote}
+	}
+	if err := repo.CheckRemote(ctx); err != nil {
+		remote.Status = checkFailed
+		remote.Detail = err.Error()
+		remote.Fix = "run `git remote remove container-use`, the next container-use command sets it up again"
+	} else {
+		remote.Status = checkOK
+		remote.Detail = "container-use remote is set up"
+	}
+
+	worktrees := checkResult{Name: "worktrees"}
+	orphaned, err := repo.OrphanedWorktrees(ctx)
+	switch {
+	case err != nil:
+		worktrees.Status = checkFailed
+		worktrees.Detail = err.Error()
+	case len(orphaned) > 0:
+		worktrees.Status = checkWarning
+		worktrees.Detail = fmt.Sprintf("%d worktrees of deleted environments: %s", len(orphaned), strings.Join(orphaned, ", "))
+		worktrees.Fix = "run `container-use maintenance` to remove them"
+	default:
+		worktrees.Status = checkOK
+		worktrees.Detail = "no dangling worktrees"
+	}
+	return []checkResult{remote, worktrees}
+}
+
+func init() {
+	doctorCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the Dagger engine")
+	rootCmd.AddCommand(doctorCmd)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   [3]int
+		ok     bool
+	}{
+		{output: "git version 2.43.0\n", want: [3]int{2, 43, 0}, ok: true},
+		{output: "git version 2.39.3 (Apple Git-145)", want: [3]int{2, 39, 3}, ok: true},
+		{output: "git version 2.45.1.windows.1", want: [3]int{2, 45, 1}, ok: true},
+		{output: "git version 2.46.0-rc1", want: [3]int{2, 46, 0}, ok: true},
+		{output: "git version 2.30", want: [3]int{2, 30, 0}, ok: true},
+		{output: "hub version 2.14.2"},
+		{output: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseGitVersion(tt.output)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseGitVersion(%q) = %v, %v, want %v, %v", tt.output, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	if compareVersions([3]int{2, 30, 9}, minGitVersion) >= 0 {
+		t.Errorf("git 2.30.9 should be older than the minimum version")
+	}
+	if compareVersions([3]int{2, 31, 0}, minGitVersion) < 0 {
+		t.Errorf("git 2.31.0 should be supported")
+	}
+}
+
+func TestPrintCheck(t *testing.T) {
+	var buf bytes.Buffer
+	printCheck(&buf, checkResult{Name: "git", Status: checkFailed, Detail: "version 2.20.1", Fix: "upgrade git"})
+	printCheck(&buf, checkResult{Name: "docker", Status: checkOK, Detail: "daemon version 27.0.3", Fix: "ignored"})
+	want := "✗ git: version 2.20.1\n    fix: upgrade git\n✓ docker: daemon version 27.0.3\n"
+	if buf.String() != want {
+		t.Errorf("printCheck output = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"dagger.io/dagger"
+	"dagger.io/dagger/engineconn"
+	"github.com/dagger/container-use/environment"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// daggerRunnerHostEnv points the dagger SDK at a remote (or socket-mounted) engine instead of provisioning one through docker.
+	daggerRunnerHostEnv = "_EXPERIMENTAL_DAGGER_RUNNER_HOST"
+	// sharedEngineEnv points container-use at an engine shared by several users, such as one started with
+	// `container-use engine start`. It takes precedence over daggerRunnerHostEnv.
+	sharedEngineEnv     = "CONTAINER_USE_ENGINE"
+	defaultDockerSocket = "/var/run/docker.sock"
+)
+
+// runningInContainer reports whether container-use itself is running inside a container,
+// such as a devcontainer or GitHub Codespace.
+func runningInContainer() bool {
+	for _, env := range []string{"CODESPACES", "REMOTE_CONTAINERS", "DEVCONTAINER"} {
+		if os.Getenv(env) == "true" {
+			return true
+		}
+	}
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerSocketPath returns the path of the docker socket container-use will use to provision the engine.
+// It returns an empty string when DOCKER_HOST points to a non-unix (remote) daemon.
+func dockerSocketPath() string {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		return defaultDockerSocket
+	}
+	u, err := url.Parse(host)
+	if err != nil || u.Scheme != "unix" {
+		return ""
+	}
+	return u.Path
+}
+
+// checkContainerizedEngine makes sure an engine is reachable when running inside a container.
+// Without a mounted docker socket or a remote engine, dagger would fail with an obscure error.
+func checkContainerizedEngine() error {
+	if !runningInContainer() {
+		return nil
+	}
+	if runnerHost := os.Getenv(daggerRunnerHostEnv); runnerHost != "" {
+		slog.Info("Running in a container, using remote engine", "runner-host", runnerHost)
+		return nil
+	}
+	socket := dockerSocketPath()
+	if socket == "" {
+		slog.Info("Running in a container, using remote docker daemon", "docker-host", os.Getenv("DOCKER_HOST"))
+		return nil
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return fmt.Errorf(`container-use is running inside a container but no container engine is reachable (%s: %w).
+Either mount the docker socket into this container (e.g. the "docker-outside-of-docker" devcontainer feature),
+or point %s at a running dagger engine (e.g. tcp://engine:1234)`, socket, err, daggerRunnerHostEnv)
+	}
+	slog.Info("Running in a container, using mounted docker socket", "socket", socket)
+	return nil
+}
+
+// useSharedEngine points the dagger SDK at the shared engine configured with CONTAINER_USE_ENGINE, if any,
+// and makes sure this user is allowed to connect to it.
+func useSharedEngine() error {
+	engine := os.Getenv(sharedEngineEnv)
+	if engine == "" {
+		return nil
+	}
+	u, err := url.Parse(engine)
+	if err != nil || u.Scheme == "" {
+		return fmt.Errorf("invalid %s %q, expected an engine address such as unix:///run/container-use/engine.sock or tcp://engine:1234", sharedEngineEnv, engine)
+	}
+	if u.Scheme == "unix" {
+		if err := checkEngineSocket(u.Path); err != nil {
+			return err
+		}
+	}
+	slog.Info("Using shared engine", "engine", engine)
+	return os.Setenv(daggerRunnerHostEnv, engine)
+}
+
+// checkEngineSocket returns a helpful error if the engine socket at path can't be connected to.
+func checkEngineSocket(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		return conn.Close()
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		group := "the group owning it"
+		if info, statErr := os.Stat(path); statErr == nil {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				if g, err := user.LookupGroupId(strconv.Itoa(int(stat.Gid))); err == nil {
+					group = fmt.Sprintf("the %q group", g.Name)
+				}
+			}
+		}
+		return fmt.Errorf("permission denied on the shared engine socket %s: ask an administrator to add you to %s, then log in again", path, group)
+	}
+	return fmt.Errorf("the shared engine at %s is not reachable (%w): start it with `container-use engine start`", path, err)
+}
+
+// connectDagger connects to the dagger engine, printing helpful guidance for the most common failures.
+func connectDagger(ctx context.Context, logOutput io.Writer) (*dagger.Client, error) {
+	if err := useSharedEngine(); err != nil {
+		return nil, err
+	}
+	if err := checkContainerizedEngine(); err != nil {
+		return nil, err
+	}
+	// The session resolving op:// secrets inherits the environment of this process
+	if err := environment.LoadOnePasswordToken(); err != nil {
+		return nil, err
+	}
+
+	dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logOutput))
+	if err != nil {
+		if isDockerDaemonError(err) {
+			handleDockerDaemonError()
+		}
+		return nil, fmt.Errorf("failed to connect to dagger: %w", err)
+	}
+
+	// Engines provisioned by the SDK always match it, long-running remote ones may not
+	if runnerHost := os.Getenv(daggerRunnerHostEnv); runnerHost != "" {
+		engineVersion, err := dag.Version(ctx)
+		if err != nil {
+			dag.Close()
+			return nil, fmt.Errorf("failed to get the version of the engine at %s: %w", runnerHost, err)
+		}
+		skew, err := checkVersionSkew(engineVersion, engineconn.CLIVersion)
+		if err != nil {
+			dag.Close()
+			return nil, fmt.Errorf("the engine at %s is not compatible: %w", runnerHost, err)
+		}
+		if skew != "" {
+			slog.Warn("Engine version skew", "engine", runnerHost, "warning", skew)
+		}
+	}
+	return dag, nil
+}
+
+// checkVersionSkew compares the version of the engine with the version the SDK was built for. It returns
+// an error if the engine is too old to serve the SDK, and a warning if it is newer: newer engines
+// serve older clients, possibly with slight differences in behavior. Versions that can't be parsed,
+// such as development builds, are assumed to be compatible.
+func checkVersionSkew(engineVersion, sdkVersion string) (string, error) {
+	engine, ok := parseMinorVersion(engineVersion)
+	if !ok {
+		return "", nil
+	}
+	sdk, ok := parseMinorVersion(sdkVersion)
+	if !ok {
+		return "", nil
+	}
+	switch {
+	case engine[0] < sdk[0] || engine[0] == sdk[0] && engine[1] < sdk[1]:
+		return "", fmt.Errorf("engine %s is older than v%s, which container-use requires: upgrade the engine (e.g. `container-use engine stop && container-use engine start`)", engineVersion, sdkVersion)
+	case engine != sdk:
+		return fmt.Sprintf("engine %s is newer than v%s, which container-use was built for: upgrade container-use if you run into issues", engineVersion, sdkVersion), nil
+	}
+	return "", nil
+}
+
+// parseMinorVersion returns the major and minor numbers of a version such as v0.18.12.
+func parseMinorVersion(version string) ([2]int, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return [2]int{}, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return [2]int{}, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return [2]int{}, false
+	}
+	return [2]int{major, minor}, true
+}
+
+const (
+	sharedEngineContainer = "container-use-engine"
+	sharedEngineVolume    = "container-use-engine-cache"
+)
+
+var engineCmd = &cobra.Command{
+	Use:   "engine",
+	Short: "Manage an engine shared by several users",
+	Long: `Run a single engine for every user of a machine, such as a build farm or CI runner,
+so that images and layers are pulled and built once instead of once per user.
+Users point container-use at it with ` + sharedEngineEnv + `.`,
+}
+
+var engineStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start a shared engine",
+	Long: `Start an engine in docker, listening on a unix socket that members of a group can use.
+Its cache is kept in a docker volume across restarts. This usually requires root,
+to give the socket to the group.`,
+	Args: cobra.NoArgs,
+	Example: `# Share an engine with the members of the container-use group
+sudo container-use engine start --group container-use
+
+# Then, as each user
+export ` + sharedEngineEnv + `=unix:///run/container-use/engine.sock`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		socket, _ := app.Flags().GetString("socket")
+		groupName, _ := app.Flags().GetString("group")
+		if !filepath.IsAbs(socket) {
+			return fmt.Errorf("--socket must be an absolute path")
+		}
+
+		group, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("group %q not found, create it and add the users of the engine to it: %w", groupName, err)
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Dir(socket)
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, -1, gid); err != nil {
+			return fmt.Errorf("failed to give %s to group %s, try again as root: %w", dir, groupName, err)
+		}
+
+		image := "registry.dagger.io/engine:v" + engineconn.CLIVersion
+		run := exec.CommandContext(ctx, "docker", "run", "--detach",
+			"--name", sharedEngineContainer,
+			"--restart", "unless-stopped",
+			"--privileged",
+			"--volume", sharedEngineVolume+":/var/lib/dagger",
+			"--volume", dir+":"+dir,
+			image, "--addr", "unix://"+socket)
+		run.Stdout = os.Stdout
+		run.Stderr = os.Stderr
+		if err := run.Run(); err != nil {
+			return fmt.Errorf("failed to start the engine: %w", err)
+		}
+
+		// The socket is created by the engine, as root: hand it over to the group once it shows up
+		deadline := time.Now().Add(time.Minute)
+		for {
+			if _, err := os.Stat(socket); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("the engine didn't create %s, see `docker logs %s`", socket, sharedEngineContainer)
+			}
+			time.Sleep(time.Second)
+		}
+		if err := os.Chown(socket, -1, gid); err != nil {
+			return err
+		}
+		if err := os.Chmod(socket, 0660); err != nil {
+			return err
+		}
+
+		fmt.Printf("Engine %s started. Members of the %s group can use it with:\n\n  export %s=unix://%s\n", image, groupName, sharedEngineEnv, socket)
+		return nil
+	},
+}
+
+var engineStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the shared engine",
+	Long:  `Stop the shared engine. Its cache is kept for the next start.`,
+	Args:  cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		rm := exec.CommandContext(app.Context(), "docker", "rm", "--force", sharedEngineContainer)
+		rm.Stderr = os.Stderr
+		if err := rm.Run(); err != nil {
+			return fmt.Errorf("failed to stop the engine: %w", err)
+		}
+		fmt.Println("Engine stopped.")
+		return nil
+	},
+}
+
+func init() {
+	engineStartCmd.Flags().String("socket", "/run/container-use/engine.sock", "Path of the unix socket the engine listens on")
+	engineStartCmd.Flags().String("group", "container-use", "Group of the users allowed to use the engine")
+	engineCmd.AddCommand(engineStartCmd, engineStopCmd)
+	rootCmd.AddCommand(engineCmd)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDockerSocketPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerHost string
+		expected   string
+	}{
+		{
+			name:       "default socket",
+			dockerHost: "",
+			expected:   "/var/run/docker.sock",
+		},
+		{
+			name:       "custom unix socket",
+			dockerHost: "unix:///run/user/1000/docker.sock",
+			expected:   "/run/user/1000/docker.sock",
+		},
+		{
+			name:       "remote daemon",
+			dockerHost: "tcp://docker:2375",
+			expected:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DOCKER_HOST", tt.dockerHost)
+			if got := dockerSocketPath(); got != tt.expected {
+				t.Errorf("dockerSocketPath() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestUseSharedEngine(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "engine.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	tests := []struct {
+		name    string
+		engine  string
+		wantErr bool
+	}{
+		{name: "not configured", engine: ""},
+		{name: "reachable socket", engine: "unix://" + socket},
+		{name: "tcp engine", engine: "tcp://engine:1234"},
+		{name: "missing socket", engine: "unix:///nonexistent/engine.sock", wantErr: true},
+		{name: "not an address", engine: "/run/container-use/engine.sock", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(sharedEngineEnv, tt.engine)
+			t.Setenv(daggerRunnerHostEnv, "")
+			err := useSharedEngine()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("useSharedEngine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.engine != "" && os.Getenv(daggerRunnerHostEnv) != tt.engine {
+				t.Errorf("%s = %q, want %q", daggerRunnerHostEnv, os.Getenv(daggerRunnerHostEnv), tt.engine)
+			}
+		})
+	}
+}
+
+func TestCheckVersionSkew(t *testing.T) {
+	tests := []struct {
+		name        string
+		engine      string
+		wantWarning bool
+		wantErr     bool
+	}{
+		{name: "same version", engine: "v0.18.12"},
+		{name: "other patch release", engine: "v0.18.3"},
+		{name: "newer minor release", engine: "v0.19.0", wantWarning: true},
+		{name: "newer major release", engine: "v1.0.0", wantWarning: true},
+		{name: "older minor release", engine: "v0.17.2", wantErr: true},
+		{name: "development build", engine: "devel"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning, err := checkVersionSkew(tt.engine, "0.18.12")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkVersionSkew(%q) error = %v, want error %v", tt.engine, err, tt.wantErr)
+			}
+			if (warning != "") != tt.wantWarning {
+				t.Errorf("checkVersionSkew(%q) warning = %q, want warning %v", tt.engine, warning, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export <env> <path>",
+	Short: "Package an environment into a portable tarball",
+	Long: `Package an environment into a tarball that can be moved to another machine and
+brought back with 'container-use import'. The tarball contains the environment branch
+and its notes as a git bundle, and the container as an OCI image.
+
+The tarball is compressed according to its extension: .tar.gz or .tgz with gzip,
+.tar.zst with zstd (which must be installed), .tar uncompressed.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Hand an environment over to a teammate
+container-use export fancy-mallard fancy-mallard.tar.zst
+
+# On their machine, in a clone of the same repository
+container-use import fancy-mallard.tar.zst`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		if !repository.IsExportPath(args[1]) {
+			return fmt.Errorf("%s must end with .tar, .tar.gz, .tgz or .tar.zst", args[1])
+		}
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		if _, err := repo.Export(ctx, dag, args[0], args[1]); err != nil {
+			return fmt.Errorf("failed to export environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' exported to %s.\n", args[0], args[1])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+}
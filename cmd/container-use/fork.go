@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var forkCmd = &cobra.Command{
+	Use:   "fork <env>",
+	Short: "Create a copy of an environment, container included",
+	Long: `Create a new environment as a deep copy of an existing one: it continues the
+history of the environment, and its container is a snapshot of the current one,
+with installed dependencies, compiled artifacts and untracked files.
+
+Use it to branch an experiment off a fully warmed environment. The original
+environment is left untouched. Services are restarted in the copy, background
+processes are not carried over.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Try a risky refactoring on a copy of an environment
+container-use fork fancy-mallard --title "Try the new parser"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		title, _ := app.Flags().GetString("title")
+		env, err := repo.Fork(ctx, dag, args[0], title, fmt.Sprintf("Fork environment %s", args[0]))
+		if err != nil {
+			return fmt.Errorf("failed to fork environment: %w", err)
+		}
+
+		fmt.Printf("Environment '%s' forked as '%s'.\n", args[0], env.ID)
+		return nil
+	},
+}
+
+func init() {
+	forkCmd.Flags().StringP("title", "t", "", "Title of the new environment (defaults to the title of the forked environment)")
+	rootCmd.AddCommand(forkCmd)
+}
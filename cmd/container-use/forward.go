@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var forwardCmd = &cobra.Command{
+	Use:   "forward <env> <port>",
+	Short: "Forward a port of an environment to localhost",
+	Long: `Tunnel a port of an environment to a stable localhost port so you can click through what the agent built.
+The port is served by the environment's service exposing it or, with --command, by a command started in the environment.
+Without --command, the background command the agent last ran on that port is started again.
+Press Ctrl+C to stop forwarding.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Open the web app the agent started on port 3000 at http://localhost:3000
+container-use forward fancy-mallard 3000
+
+# Start the app yourself and serve it on another local port
+container-use forward fancy-mallard 8000 --command "python -m http.server 8000" --host-port 9000`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		port, err := strconv.Atoi(args[1])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port: %s", args[1])
+		}
+		hostPort, _ := app.Flags().GetInt("host-port")
+		command, _ := app.Flags().GetString("command")
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, args[0])
+		if err != nil {
+			return err
+		}
+
+		target := ""
+		if command == "" {
+			command = env.BackgroundCommand(port)
+		}
+		if command != "" {
+			fmt.Fprintf(os.Stderr, "Starting %q in %s...\n", command, env.ID)
+			process, err := env.RunBackground(ctx, command, "sh", []int{port}, environment.RunOpts{})
+			if err != nil {
+				return err
+			}
+			target = process.ID
+		}
+
+		forward, err := env.ForwardPort(ctx, port, hostPort, target)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Forwarding %s port %d to %s. Press Ctrl+C to stop.\n", env.ID, port, forward.Address)
+
+		<-ctx.Done()
+		return nil
+	},
+}
+
+func init() {
+	forwardCmd.Flags().Int("host-port", 0, "Local port to forward to (defaults to the same port)")
+	forwardCmd.Flags().String("command", "", "Command serving the port, started in the environment")
+	rootCmd.AddCommand(forwardCmd)
+}
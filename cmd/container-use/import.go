@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <branch|path>",
+	Short: "Turn an existing branch or an exported environment into an environment",
+	Long: `Adopt an existing git branch as a container-use environment.
+The environment starts from the tip of the branch and uses any container-use
+configuration committed on it, so agents can pick up where the branch left off.
+
+If the argument is a tarball created with 'container-use export', the environment
+is reconstituted from it instead, with its history, notes and container.`,
+	Args: cobra.ExactArgs(1),
+	Example: `# Continue work started on a feature branch
+container-use import feature/login
+
+# Give the environment a descriptive title
+container-use import wip-refactor --title "Finish the storage refactor"
+
+# Bring back an environment exported on another machine
+container-use import fancy-mallard.tar.zst`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		title, _ := app.Flags().GetString("title")
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		if _, err := os.Stat(args[0]); err == nil && repository.IsExportPath(args[0]) {
+			env, err := repo.ImportBundle(ctx, dag, args[0], fmt.Sprintf("Import %s", args[0]))
+			if err != nil {
+				return fmt.Errorf("failed to import environment: %w", err)
+			}
+			fmt.Printf("Environment '%s' imported from %s.\n", env.ID, args[0])
+			return nil
+		}
+
+		env, err := repo.Import(ctx, dag, args[0], title, fmt.Sprintf("Import branch %s", args[0]))
+		if err != nil {
+			return fmt.Errorf("failed to import branch: %w", err)
+		}
+
+		fmt.Printf("Branch '%s' imported as environment '%s'.\n", args[0], env.ID)
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().StringP("title", "t", "", "Title of the environment (defaults to the branch name)")
+	rootCmd.AddCommand(importCmd)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dagger/container-use/cmd/container-use/agent"
+	"github.com/spf13/cobra"
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up an agent to use container-use",
+	Long: fmt.Sprintf(`Add the container-use MCP server to the configuration of an agent, and install the
+container-use rules for the agent in the current repository.
+Supported agents: %s. Without --agent, the agent is selected interactively.`, strings.Join(agent.AgentKeys(), ", ")),
+	Args: cobra.NoArgs,
+	Example: `# Set up Claude Code in the current repository
+container-use init --agent claude
+
+# Set up Cursor without adding rules to the repository
+container-use init --agent cursor --rules=false`,
+	RunE: func(app *cobra.Command, args []string) error {
+		agentKey, _ := app.Flags().GetString("agent")
+		withRules, _ := app.Flags().GetBool("rules")
+		return agent.Configure(agentKey, withRules)
+	},
+}
+
+func init() {
+	initCmd.Flags().String("agent", "", "Agent to set up: "+strings.Join(agent.AgentKeys(), ", "))
+	initCmd.Flags().Bool("rules", true, "Install the container-use rules for the agent in the current repository")
+	initCmd.RegisterFlagCompletionFunc("agent", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return agent.AgentKeys(), cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(initCmd)
+}
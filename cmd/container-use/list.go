@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/dagger/container-use/repository"
@@ -13,7 +14,8 @@ import (
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all environments",
-	Long: `Display all active environments with their IDs, titles, and timestamps.
+	Long: `Display all active environments with their IDs, titles, timestamps, and how far
+they have diverged from the current branch.
 Use -q for environment IDs only, useful for scripting.`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
@@ -33,16 +35,50 @@ Use -q for environment IDs only, useful for scripting.`,
 		}
 
 		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED")
+		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tUPDATED\tCHANGES")
 
-		defer tw.Flush()
+		notices := []string{}
 		for _, envInfo := range envInfos {
-			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), humanize.Time(envInfo.State.CreatedAt), humanize.Time(envInfo.State.UpdatedAt))
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", envInfo.ID, truncate(app, envInfo.State.Title, 40), humanize.Time(envInfo.CreatedAt), humanize.Time(envInfo.UpdatedAt), describeChanges(envInfo))
+
+			staleness, err := repo.Staleness(ctx, envInfo.EnvironmentInfo)
+			if err != nil {
+				continue
+			}
+			for _, notice := range staleness.Notices(envInfo.ID) {
+				notices = append(notices, fmt.Sprintf("%s: %s", envInfo.ID, notice))
+			}
+		}
+		tw.Flush()
+
+		if len(notices) > 0 {
+			fmt.Println()
+			for _, notice := range notices {
+				fmt.Println(notice)
+			}
 		}
 		return nil
 	},
 }
 
+// describeChanges summarizes how far an environment diverged from the current branch.
+func describeChanges(envInfo *repository.EnvironmentSummary) string {
+	changes := []string{}
+	if envInfo.Ahead > 0 {
+		changes = append(changes, fmt.Sprintf("%d ahead", envInfo.Ahead))
+	}
+	if envInfo.Behind > 0 {
+		changes = append(changes, fmt.Sprintf("%d behind", envInfo.Behind))
+	}
+	if envInfo.Dirty {
+		changes = append(changes, "uncommitted")
+	}
+	if len(changes) == 0 {
+		return "-"
+	}
+	return strings.Join(changes, ", ")
+}
+
 func truncate(app *cobra.Command, s string, max int) string {
 	if noTrunc, _ := app.Flags().GetBool("no-trunc"); noTrunc {
 		return s
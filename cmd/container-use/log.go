@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/dagger/container-use/repository"
@@ -10,16 +12,21 @@ import (
 var logCmd = &cobra.Command{
 	Use:   "log <env>",
 	Short: "View what an agent did step-by-step",
-	Long: `Display the complete development history for an environment.
-Shows all commits made by the agent plus command execution notes.
-Use -p to include code patches in the output.`,
+	Long: `Display the complete development history for an environment, oldest first.
+Each commit made by the agent is shown with its explanation, followed by the
+operations that produced it: commands run and their output, files written,
+services added and configuration changes.
+Use -p to include code patches in the output, and --json for tooling.`,
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: suggestEnvironments,
 	Example: `# See what agent did
 container-use log fancy-mallard
 
 # Include code changes
-container-use log fancy-mallard -p`,
+container-use log fancy-mallard -p
+
+# Process the history with jq
+container-use log fancy-mallard --json | jq -r '.[].explanation'`,
 	RunE: func(app *cobra.Command, args []string) error {
 		ctx := app.Context()
 
@@ -31,11 +38,25 @@ container-use log fancy-mallard -p`,
 
 		patch, _ := app.Flags().GetBool("patch")
 
+		if asJSON, _ := app.Flags().GetBool("json"); asJSON {
+			entries, err := repo.History(ctx, args[0], patch)
+			if err != nil {
+				return err
+			}
+			out, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
 		return repo.Log(ctx, args[0], patch, os.Stdout)
 	},
 }
 
 func init() {
 	logCmd.Flags().BoolP("patch", "p", false, "Generate patch")
+	logCmd.Flags().Bool("json", false, "Output the history as JSON")
 	rootCmd.AddCommand(logCmd)
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+)
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Reclaim disk space used by container-use",
+	Long: `Compact the storage container-use manages for the current repository.
+Removes worktrees left behind by deleted environments, prunes unreachable
+objects and repacks the environment repository.
+
+Unreachable objects are only pruned once older than two weeks, git's default,
+so that maintenance is safe while agents are updating environments.`,
+	Args: cobra.NoArgs,
+	Example: `# Compact storage for the current repository
+container-use maintenance
+
+# Spend more time to produce smaller packs
+container-use maintenance --aggressive`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		aggressive, _ := app.Flags().GetBool("aggressive")
+
+		report, err := repo.Maintenance(ctx, aggressive)
+		if err != nil {
+			return fmt.Errorf("maintenance failed: %w", err)
+		}
+
+		for _, id := range report.PrunedWorktrees {
+			fmt.Printf("Removed orphaned worktree '%s'\n", id)
+		}
+		fmt.Printf("Reclaimed %s (%s → %s)\n",
+			humanize.IBytes(uint64(report.Reclaimed())),
+			humanize.IBytes(uint64(report.SizeBefore)),
+			humanize.IBytes(uint64(report.SizeAfter)))
+		return nil
+	},
+}
+
+func init() {
+	maintenanceCmd.Flags().Bool("aggressive", false, "Optimize the repository more aggressively at the expense of time")
+	rootCmd.AddCommand(maintenanceCmd)
+}
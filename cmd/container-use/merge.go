@@ -37,6 +37,7 @@ container-use merge --delete backend-api`,
 		}
 
 		env := args[0]
+		warnPendingChanges(repo, env)
 
 		if err := repo.Merge(ctx, env, os.Stdout); err != nil {
 			return fmt.Errorf("failed to merge environment: %w", err)
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/cmd/container-use/agent"
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+const (
+	configDirEnv       = "CONTAINER_USE_CONFIG_DIR"
+	worktreeStorageEnv = "CONTAINER_USE_WORKTREE_STORAGE"
+
+	// demoCommand is run in the demo environment to check that commands run end to end.
+	demoCommand = `echo "Hello from $(uname -sm)" && cat README.md`
+)
+
+var onboardCmd = &cobra.Command{
+	Use:   "onboard",
+	Short: "Set up container-use step by step",
+	Long: `Walk through the first-time setup of container-use:
+
+  1. check git, Docker and the Dagger engine,
+  2. choose where container-use stores its data,
+  3. configure an agent to use container-use,
+  4. create a demo environment in a scratch repository and run a command in it,
+     to validate the whole pipeline end to end.
+
+With --yes, every question gets its default answer and no agent is configured
+unless --agent is given.`,
+	Args: cobra.NoArgs,
+	Example: `# Set up container-use interactively
+container-use onboard
+
+# Validate the setup without asking anything, e.g. in a provisioning script
+container-use onboard --yes`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		assumeYes, _ := app.Flags().GetBool("yes")
+		agentKey, _ := app.Flags().GetString("agent")
+		keepDemo, _ := app.Flags().GetBool("keep-demo")
+		timeout, _ := app.Flags().GetDuration("timeout")
+
+		p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout, assumeYes: assumeYes}
+		fmt.Println("Welcome to container-use! This sets it up and checks that everything works.")
+
+		printStep(1, "Checking the engine")
+		checks := []checkResult{checkGit(ctx), checkDocker(ctx), checkEngine(ctx, timeout)}
+		if err := printChecks(os.Stdout, checks); err != nil {
+			return fmt.Errorf("%w: fix them, then run container-use onboard again", err)
+		}
+
+		printStep(2, "Choosing where to store data")
+		dataDir, err := p.ask("Directory for the data of container-use, such as the worktrees of environments", repository.DefaultBasePath())
+		if err != nil {
+			return err
+		}
+		if err := printChecks(os.Stdout, []checkResult{checkDataDir(dataDir)}); err != nil {
+			return err
+		}
+		storage, err := p.choose("Where to keep worktrees: on disk, in memory (tmpfs), or in memory backed by disk (overlay)",
+			[]string{"disk", "tmpfs", "overlay"}, cmp.Or(os.Getenv(worktreeStorageEnv), "disk"))
+		if err != nil {
+			return err
+		}
+		exports := storageSettings(dataDir, storage)
+		for _, export := range exports {
+			name, value, _ := strings.Cut(export, "=")
+			// The rest of the setup uses the settings right away
+			os.Setenv(name, value)
+		}
+		if len(exports) > 0 {
+			fmt.Println("Add these settings to your shell profile, and to the environment of your agents:")
+			for _, export := range exports {
+				fmt.Printf("    export %s\n", shellQuoteAssignment(export))
+			}
+		} else {
+			fmt.Println("Using the defaults, nothing to configure.")
+		}
+
+		printStep(3, "Configuring an agent")
+		configure := agentKey != ""
+		if !configure && !assumeYes {
+			if configure, err = p.confirm("Configure an agent to use container-use now?", true); err != nil {
+				return err
+			}
+		}
+		if configure {
+			// Rules belong to the repositories the agent works in, not to wherever onboarding runs
+			if err := agent.Configure(agentKey, false); err != nil {
+				return err
+			}
+			fmt.Println("Run `container-use init` in your repositories to add the container-use rules for your agent.")
+		} else {
+			fmt.Println("Skipped: run `container-use init` in a repository to configure an agent later.")
+		}
+
+		printStep(4, "Creating a demo environment")
+		if err := runDemo(ctx, os.Stdout, keepDemo); err != nil {
+			return fmt.Errorf("the demo failed, run `container-use doctor` to diagnose the setup: %w", err)
+		}
+
+		fmt.Println("\nAll set! Ask your agent to work in a container-use environment, then follow along with:")
+		fmt.Println("    container-use list          # environments of the current repository")
+		fmt.Println("    container-use watch         # live activity of the agents")
+		fmt.Println("    container-use log <env>     # history of an environment")
+		fmt.Println("    container-use checkout <env>  # review the work in your repository")
+		return nil
+	},
+}
+
+// runDemo creates an environment in a scratch repository and runs demoCommand in it, printing what it
+// does to w. The scratch repository is removed afterwards unless keep is set.
+func runDemo(ctx context.Context, w io.Writer, keep bool) error {
+	dir, err := os.MkdirTemp("", "container-use-demo-")
+	if err != nil {
+		return err
+	}
+	if err := initDemoRepository(ctx, dir); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	fmt.Fprintf(w, "Created a scratch repository in %s\n", dir)
+
+	repo, err := repository.Open(ctx, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	dag, err := connectDagger(ctx, logWriter)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	defer dag.Close()
+
+	start := time.Now()
+	env, err := repo.Create(ctx, dag, "Onboarding demo", "Create the onboarding demo environment")
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("failed to create the environment: %w", err)
+	}
+	fmt.Fprintf(w, "✓ Created environment %s in %s\n", env.ID, time.Since(start).Round(time.Second))
+
+	result, err := env.Run(ctx, demoCommand, "sh", environment.RunOpts{})
+	if err == nil && result.ExitCode != 0 {
+		err = fmt.Errorf("exit code %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	if err == nil {
+		err = repo.Update(ctx, env, runCommitMessage(demoCommand, "Run the onboarding demo command"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run a command in environment %s of %s: %w", env.ID, dir, err)
+	}
+	fmt.Fprintf(w, "✓ Ran %s:\n", demoCommand)
+	for line := range strings.Lines(result.Stdout) {
+		fmt.Fprintf(w, "    %s", line)
+	}
+
+	if keep {
+		fmt.Fprintf(w, "Kept the demo: `cd %s && container-use log %s` shows what happened.\n", dir, env.ID)
+		return nil
+	}
+	if err := repo.Delete(ctx, env.ID); err != nil {
+		return fmt.Errorf("failed to delete the demo environment: %w", err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "✓ Removed the demo environment and repository")
+	return nil
+}
+
+// initDemoRepository creates a git repository with a single commit in dir.
+func initDemoRepository(ctx context.Context, dir string) error {
+	readme := "# container-use demo\n\nThis repository was created by `container-use onboard`.\n"
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte(readme), 0644); err != nil {
+		return err
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "README.md"},
+		// Don't depend on the identity or signing setup of the user
+		{"-c", "user.name=container-use", "-c", "user.email=container-use@localhost", "-c", "commit.gpgsign=false", "commit", "--quiet", "-m", "Initial commit"},
+	} {
+		if _, err := repository.RunGitCommand(ctx, dir, args...); err != nil {
+			return fmt.Errorf("failed to create the demo repository: %w", err)
+		}
+	}
+	return nil
+}
+
+// storageSettings returns the NAME=value environment variables selecting dataDir and storage, for
+// those that aren't the defaults.
+func storageSettings(dataDir, storage string) []string {
+	settings := []string{}
+	if filepath.Clean(dataDir) != filepath.Clean(repository.DefaultBasePath()) || os.Getenv(configDirEnv) != "" {
+		settings = append(settings, configDirEnv+"="+dataDir)
+	}
+	if storage != "disk" || os.Getenv(worktreeStorageEnv) != "" {
+		settings = append(settings, worktreeStorageEnv+"="+storage)
+	}
+	return settings
+}
+
+// shellQuoteAssignment quotes the value of a NAME=value assignment for a shell, keeping a leading ~
+// unquoted so that it's expanded.
+func shellQuoteAssignment(assignment string) string {
+	name, value, _ := strings.Cut(assignment, "=")
+	if rest, ok := strings.CutPrefix(value, "~/"); ok {
+		return name + "=~/" + shellQuote(rest)
+	}
+	return name + "=" + shellQuote(value)
+}
+
+func printStep(n int, title string) {
+	fmt.Printf("\n[%d/4] %s\n", n, title)
+}
+
+// printChecks prints results to w, and returns an error if any failed.
+func printChecks(w io.Writer, results []checkResult) error {
+	failed := 0
+	for _, result := range results {
+		printCheck(w, result)
+		if result.Status == checkFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
+
+// prompter asks the questions of onboarding. With assumeYes, or once the input is exhausted, every
+// question gets its default answer.
+type prompter struct {
+	in        *bufio.Reader
+	out       io.Writer
+	assumeYes bool
+}
+
+// ask returns the answer to question, or def if the answer is empty.
+func (p *prompter) ask(question, def string) (string, error) {
+	if p.assumeYes {
+		fmt.Fprintf(p.out, "%s [%s]: %s\n", question, def, def)
+		return def, nil
+	}
+	fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	line, err := p.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if errors.Is(err, io.EOF) {
+		fmt.Fprintln(p.out)
+		p.assumeYes = true
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// choose asks question until the answer is one of options.
+func (p *prompter) choose(question string, options []string, def string) (string, error) {
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s)", question, strings.Join(options, "/")), def)
+		if err != nil {
+			return "", err
+		}
+		if slices.Contains(options, answer) {
+			return answer, nil
+		}
+		if p.assumeYes {
+			return "", fmt.Errorf("invalid answer %q, expected one of %s", answer, strings.Join(options, ", "))
+		}
+		fmt.Fprintf(p.out, "Please answer one of %s.\n", strings.Join(options, ", "))
+	}
+}
+
+// confirm asks a yes or no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	answer, err := p.choose(question, []string{"y", "n"}, defAnswer)
+	return answer == "y", err
+}
+
+func init() {
+	onboardCmd.Flags().BoolP("yes", "y", false, "Accept the default answer to every question")
+	onboardCmd.Flags().String("agent", "", "Agent to configure: "+strings.Join(agent.AgentKeys(), ", "))
+	onboardCmd.Flags().Bool("keep-demo", false, "Keep the demo repository and environment instead of removing them")
+	onboardCmd.Flags().Duration("timeout", 2*time.Minute, "How long to wait for the engine to respond")
+	onboardCmd.RegisterFlagCompletionFunc("agent", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return agent.AgentKeys(), cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.AddCommand(onboardCmd)
+}
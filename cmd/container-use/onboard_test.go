@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dagger/container-use/repository"
+)
+
+func TestPrompterChoose(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		assumeYes bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "answer", input: "tmpfs\n", want: "tmpfs"},
+		{name: "default", input: "\n", want: "disk"},
+		{name: "end of input", input: "", want: "disk"},
+		{name: "answer without newline", input: "overlay", want: "overlay"},
+		{name: "invalid then valid", input: "memory\noverlay\n", want: "overlay"},
+		{name: "invalid then end of input", input: "memory\n", want: "disk"},
+		{name: "assume yes", input: "tmpfs\n", assumeYes: true, want: "disk"},
+	}
+	for _, tt := range tests {
+		p := &prompter{in: bufio.NewReader(strings.NewReader(tt.input)), out: io.Discard, assumeYes: tt.assumeYes}
+		got, err := p.choose("Storage", []string{"disk", "tmpfs", "overlay"}, "disk")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: choose() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: choose() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// An invalid default, e.g. from the environment, can't be accepted without asking
+	p := &prompter{in: bufio.NewReader(strings.NewReader("")), out: io.Discard, assumeYes: true}
+	if got, err := p.choose("Storage", []string{"disk", "tmpfs", "overlay"}, "memory"); err == nil {
+		t.Errorf("choose() with an invalid default = %q, want an error", got)
+	}
+}
+
+func TestPrompterConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		def   bool
+		want  bool
+	}{
+		{input: "y\n", want: true},
+		{input: "n\n", def: true, want: false},
+		{input: "\n", def: true, want: true},
+		{input: "\n", def: false, want: false},
+	}
+	for _, tt := range tests {
+		p := &prompter{in: bufio.NewReader(strings.NewReader(tt.input)), out: io.Discard}
+		got, err := p.confirm("Configure?", tt.def)
+		if err != nil || got != tt.want {
+			t.Errorf("confirm(%q, %v) = %v, %v, want %v", tt.input, tt.def, got, err, tt.want)
+		}
+	}
+}
+
+func TestStorageSettings(t *testing.T) {
+	t.Setenv(configDirEnv, "")
+	t.Setenv(worktreeStorageEnv, "")
+	os.Unsetenv(configDirEnv)
+	os.Unsetenv(worktreeStorageEnv)
+
+	tests := []struct {
+		dataDir string
+		storage string
+		want    []string
+	}{
+		{dataDir: repository.DefaultBasePath(), storage: "disk", want: []string{}},
+		{dataDir: "/data/container-use", storage: "disk", want: []string{"CONTAINER_USE_CONFIG_DIR=/data/container-use"}},
+		{dataDir: repository.DefaultBasePath(), storage: "tmpfs", want: []string{"CONTAINER_USE_WORKTREE_STORAGE=tmpfs"}},
+	}
+	for _, tt := range tests {
+		if got := storageSettings(tt.dataDir, tt.storage); !slices.Equal(got, tt.want) {
+			t.Errorf("storageSettings(%q, %q) = %q, want %q", tt.dataDir, tt.storage, got, tt.want)
+		}
+	}
+}
+
+func TestShellQuoteAssignment(t *testing.T) {
+	tests := map[string]string{
+		"CONTAINER_USE_CONFIG_DIR=/data/cu":             "CONTAINER_USE_CONFIG_DIR=/data/cu",
+		"CONTAINER_USE_CONFIG_DIR=~/my data":            "CONTAINER_USE_CONFIG_DIR=~/'my data'",
+		"CONTAINER_USE_WORKTREE_STORAGE=overlay":        "CONTAINER_USE_WORKTREE_STORAGE=overlay",
+		"CONTAINER_USE_CONFIG_DIR=/path with/'quote'/x": `CONTAINER_USE_CONFIG_DIR='/path with/'\''quote'\''/x'`,
+	}
+	for assignment, want := range tests {
+		if got := shellQuoteAssignment(assignment); got != want {
+			t.Errorf("shellQuoteAssignment(%q) = %s, want %s", assignment, got, want)
+		}
+	}
+}
+
+func TestInitDemoRepository(t *testing.T) {
+	dir := t.TempDir()
+	if err := initDemoRepository(context.Background(), dir); err != nil {
+		t.Fatalf("initDemoRepository() error = %v", err)
+	}
+	out, err := repository.RunGitCommand(context.Background(), dir, "log", "--format=%s", "--", "README.md")
+	if err != nil || strings.TrimSpace(out) != "Initial commit" {
+		t.Errorf("git log = %q, %v, want the initial commit", out, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "README.md")); err != nil {
+		t.Errorf("README.md: %v", err)
+	}
+}
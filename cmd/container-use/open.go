@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var openCmd = &cobra.Command{
+	Use:   "open <env>",
+	Short: "Open an environment in your editor",
+	Long: `Open the worktree of an environment in your editor, to browse the agent's work
+without touching your own checkout. The editor is --editor, $VISUAL, $EDITOR or VS Code.
+
+With --checkout, the environment's branch is checked out in your repository first,
+like container-use checkout, and your repository is opened instead.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Open an environment in the default editor
+container-use open fancy-mallard
+
+# Open it in VS Code
+container-use open fancy-mallard --editor code
+
+# Check out the environment's branch and open the repository
+container-use open fancy-mallard --checkout
+
+# Print the path of the worktree
+cd "$(container-use open fancy-mallard --print)"`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		envID := args[0]
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		checkout, _ := app.Flags().GetBool("checkout")
+		var target string
+		if checkout {
+			branch, err := repo.Checkout(ctx, envID, "")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Switched to branch '%s'\n", branch)
+			target = repo.SourcePath()
+		} else {
+			target, err = repo.Worktree(ctx, envID)
+			if err != nil {
+				return err
+			}
+		}
+
+		if printPath, _ := app.Flags().GetBool("print"); printPath {
+			fmt.Println(target)
+			return nil
+		}
+
+		flag, _ := app.Flags().GetString("editor")
+		editor, err := editorCommand(flag, os.Getenv, exec.LookPath)
+		if err != nil {
+			return err
+		}
+		cmd := exec.CommandContext(ctx, editor[0], append(editor[1:], target)...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run %s: %w", strings.Join(editor, " "), err)
+		}
+		return nil
+	},
+}
+
+// editorCommand returns the command line of the editor to open environments with: flag, $VISUAL or
+// $EDITOR, which may include arguments, falling back to VS Code.
+func editorCommand(flag string, getenv func(string) string, lookPath func(string) (string, error)) ([]string, error) {
+	for _, editor := range []string{flag, getenv("VISUAL"), getenv("EDITOR")} {
+		if fields := strings.Fields(editor); len(fields) > 0 {
+			return fields, nil
+		}
+	}
+	if _, err := lookPath("code"); err == nil {
+		return []string{"code"}, nil
+	}
+	return nil, fmt.Errorf("no editor found: use --editor or set $EDITOR")
+}
+
+func init() {
+	openCmd.Flags().String("editor", "", "Editor command to open the environment with, e.g. code or \"vim -R\"")
+	openCmd.Flags().Bool("checkout", false, "Check out the environment's branch in your repository and open it instead")
+	openCmd.Flags().Bool("print", false, "Print the path to open instead of running the editor")
+	rootCmd.AddCommand(openCmd)
+}
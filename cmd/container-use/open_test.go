@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestEditorCommand(t *testing.T) {
+	noCode := func(string) (string, error) { return "", errors.New("not found") }
+	withCode := func(string) (string, error) { return "/usr/bin/code", nil }
+
+	tests := []struct {
+		name     string
+		flag     string
+		env      map[string]string
+		lookPath func(string) (string, error)
+		want     []string
+		wantErr  bool
+	}{
+		{name: "flag", flag: "code", env: map[string]string{"EDITOR": "vim"}, lookPath: noCode, want: []string{"code"}},
+		{name: "visual before editor", env: map[string]string{"VISUAL": "subl -n", "EDITOR": "vim"}, lookPath: noCode, want: []string{"subl", "-n"}},
+		{name: "editor", env: map[string]string{"EDITOR": "vim -R"}, lookPath: noCode, want: []string{"vim", "-R"}},
+		{name: "vs code fallback", lookPath: withCode, want: []string{"code"}},
+		{name: "no editor", env: map[string]string{"EDITOR": "  "}, lookPath: noCode, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := editorCommand(tt.flag, func(key string) string { return tt.env[key] }, tt.lookPath)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: editorCommand() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: editorCommand() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
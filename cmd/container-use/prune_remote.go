@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var pruneRemoteCmd = &cobra.Command{
+	Use:   "prune-remote",
+	Short: "Remove branches of deleted environments from your repository",
+	Long: `Clean up what deleted environments left in your repository: their refs under
+the container-use remote, and the local branches tracking them, such as those
+created by container-use checkout.
+
+Branches with commits that can't be found in any other branch are kept, so that
+work built on top of an environment is never lost. So is the current branch.
+container-use delete does this for the environments it deletes.`,
+	Args: cobra.NoArgs,
+	Example: `# See what would be removed
+container-use prune-remote --dry-run
+
+# Remove branches of deleted environments
+container-use prune-remote`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+		dryRun, _ := app.Flags().GetBool("dry-run")
+
+		report, err := repo.PruneRemote(ctx, dryRun)
+		if err != nil {
+			return fmt.Errorf("failed to prune: %w", err)
+		}
+
+		prefix := "Deleted"
+		if dryRun {
+			prefix = "Would delete"
+		}
+		for _, id := range report.PrunedRefs {
+			fmt.Printf("%s remote ref 'container-use/%s'\n", prefix, id)
+		}
+		for _, branch := range report.DeletedBranches {
+			fmt.Printf("%s branch '%s'\n", prefix, branch)
+		}
+		for _, skipped := range report.SkippedBranches {
+			fmt.Printf("Kept branch '%s': %s\n", skipped.Branch, skipped.Reason)
+		}
+		if len(report.PrunedRefs)+len(report.DeletedBranches)+len(report.SkippedBranches) == 0 {
+			fmt.Println("Nothing to prune.")
+		}
+		return nil
+	},
+}
+
+func init() {
+	pruneRemoteCmd.Flags().Bool("dry-run", false, "Show what would be removed without removing anything")
+	rootCmd.AddCommand(pruneRemoteCmd)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var publishCmd = &cobra.Command{
+	Use:   "publish <env>",
+	Short: "Push an environment's branch and open a draft pull request",
+	Long: `Push an environment's work to a remote of your repository, origin by default,
+as the branch cu-<env>. With --draft-pr, also open a draft pull request of it on
+GitHub, titled after the environment and describing its history, for review.
+
+The push is never forced. Pull requests are opened with GH_TOKEN, GITHUB_TOKEN
+or the login of the gh CLI. If the branch already has an open pull request, it
+is updated by the push and left as is.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Push the environment as cu-backend-api
+container-use publish backend-api
+
+# Push it to a fork as fix/login and open a draft pull request
+container-use publish backend-api --remote fork --branch fix/login --draft-pr`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		remote, _ := app.Flags().GetString("remote")
+		branch, _ := app.Flags().GetString("branch")
+		draftPR, _ := app.Flags().GetBool("draft-pr")
+		base, _ := app.Flags().GetString("base")
+
+		result, err := repo.Publish(ctx, args[0], repository.PublishOptions{
+			Remote:  remote,
+			Branch:  branch,
+			DraftPR: draftPR,
+			Base:    base,
+		})
+		if result != nil {
+			fmt.Printf("Pushed environment '%s' to %s as '%s'.\n", args[0], result.Remote, result.Branch)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to publish environment: %w", err)
+		}
+		switch {
+		case result.PullRequestExisted:
+			fmt.Printf("Updated pull request %s\n", result.PullRequestURL)
+		case result.PullRequestURL != "":
+			fmt.Printf("Opened draft pull request %s\n", result.PullRequestURL)
+		}
+		return nil
+	},
+}
+
+func init() {
+	publishCmd.Flags().String("remote", "origin", "Remote to push to")
+	publishCmd.Flags().String("branch", "", "Branch to push to (defaults to cu-<env>)")
+	publishCmd.Flags().Bool("draft-pr", false, "Open a draft GitHub pull request of the branch")
+	publishCmd.Flags().String("base", "", "Branch to open the pull request against (defaults to the default branch of the repository)")
+	rootCmd.AddCommand(publishCmd)
+}
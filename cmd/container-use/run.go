@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run <env> -- <command>...",
+	Short: "Run a command in an environment",
+	Long: `Run a command in a new container of an environment, like an agent would.
+Changes the command makes to the workdir are committed to the environment's branch,
+and container-use exits with the exit code of the command, so it can be used in scripts.
+
+A single argument is run by the shell as is, so it may use pipes or variables.
+Several arguments are quoted and run as a single command.`,
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Run the tests of an environment
+container-use run fancy-mallard -- go test ./...
+
+# Use shell features
+container-use run fancy-mallard -- 'npm ci && npm run build > build.log'
+
+# Fail a script if the environment doesn't build
+container-use run fancy-mallard -m "Check the build" -- make || exit 1`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+		if dash := app.ArgsLenAtDash(); dash != 1 {
+			return fmt.Errorf("expected the environment, then -- and the command")
+		}
+		envID, command := args[0], shellJoin(args[1:])
+
+		shell, _ := app.Flags().GetString("shell")
+		workdir, _ := app.Flags().GetString("workdir")
+		envs, _ := app.Flags().GetStringArray("env")
+		timeout, _ := app.Flags().GetDuration("timeout")
+		message, _ := app.Flags().GetString("message")
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		dag, err := connectDagger(ctx, logWriter)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		env, err := repo.Get(ctx, dag, envID)
+		if err != nil {
+			return err
+		}
+
+		result, runErr := env.Run(ctx, command, shell, environment.RunOpts{
+			Workdir: workdir,
+			Env:     envs,
+			Timeout: timeout,
+		})
+		if ctx.Err() != nil {
+			return fmt.Errorf("command cancelled: %w", runErr)
+		}
+		// Like environment_run_cmd, record the changes even if the command failed
+		if err := repo.Update(ctx, env, runCommitMessage(command, message)); err != nil {
+			return fmt.Errorf("failed to update the environment: %w", err)
+		}
+		if runErr != nil {
+			return runErr
+		}
+
+		fmt.Fprint(os.Stdout, result.Stdout)
+		fmt.Fprint(os.Stderr, result.Stderr)
+		if result.TimedOut {
+			fmt.Fprintf(os.Stderr, "command killed after %s\n", timeout)
+		}
+		if result.ExitCode != 0 {
+			// Exit right away rather than returning an error: the command already reported its failure
+			dag.Close()
+			os.Exit(result.ExitCode)
+		}
+		return nil
+	},
+}
+
+// runCommitMessage builds the message of the commit of a run, in the format of the commits of tool calls.
+func runCommitMessage(command, message string) string {
+	operation := "Run " + strings.Join(strings.Fields(command), " ")
+	if message == "" {
+		return operation + "\n\nTool: container-use run"
+	}
+	return fmt.Sprintf("%s\n\nOperation: %s\nTool: container-use run", message, operation)
+}
+
+// shellJoin returns args as a single shell command. A single argument is the command itself,
+// otherwise arguments are quoted as needed.
+func shellJoin(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+func init() {
+	runCmd.Flags().String("shell", "sh", "The shell interpreting the command")
+	runCmd.Flags().StringP("workdir", "w", "", "Directory to run the command in, absolute or relative to the environment workdir")
+	runCmd.Flags().StringArrayP("env", "e", nil, "Additional KEY=VALUE environment variable for the command, can be repeated")
+	runCmd.Flags().Duration("timeout", 0, "Kill the command if it runs longer than this")
+	runCmd.Flags().StringP("message", "m", "", "The message of the commit recording the changes of the command")
+	rootCmd.AddCommand(runCmd)
+}
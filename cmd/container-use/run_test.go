@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestShellJoin(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"npm ci && npm test"}, want: "npm ci && npm test"},
+		{args: []string{"go", "test", "./..."}, want: "go test ./..."},
+		{args: []string{"echo", "hello world"}, want: "echo 'hello world'"},
+		{args: []string{"echo", "it's", "$HOME"}, want: `echo 'it'\''s' '$HOME'`},
+		{args: []string{"printf", ""}, want: "printf ''"},
+	}
+	for _, tt := range tests {
+		if got := shellJoin(tt.args); got != tt.want {
+			t.Errorf("shellJoin(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRunCommitMessage(t *testing.T) {
+	if got, want := runCommitMessage("go  test\n./...", ""), "Run go test ./...\n\nTool: container-use run"; got != want {
+		t.Errorf("runCommitMessage() = %q, want %q", got, want)
+	}
+	if got, want := runCommitMessage("make", "Check the build"), "Check the build\n\nOperation: Run make\nTool: container-use run"; got != want {
+		t.Errorf("runCommitMessage() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/mcpserver"
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+// serveTokenEnv holds the bearer token clients of `serve` must send, so that it doesn't show up in process listings.
+const serveTokenEnv = "CONTAINER_USE_SERVE_TOKEN"
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start MCP server over HTTP for remote agents",
+	Long: `Start the Model Context Protocol server over HTTP, so that several agents, remote
+or local, or a hosted orchestrator can share a single container-use process.
+The streamable HTTP transport is served on /mcp, and the SSE transport on /sse for
+clients that don't support it yet.
+
+Clients authenticate with an "Authorization: Bearer <token>" header. The token is
+read from ` + serveTokenEnv + ` (or --token), and a random one is generated and
+printed when none is given.
+
+Requests from web pages of other origins, and requests naming another host than the
+listen address, are rejected so that websites can't reach the server. Add the names
+clients use to reach it through a proxy with --allowed-host.
+
+Changes to the [tools] and [mounts] sections of ~/.config/container-use/config.toml
+are applied without restarting, and recorded in audit.jsonl next to it. Tool flags
+keep overriding the configuration file.`,
+	Args: cobra.NoArgs,
+	Example: `# Serve on localhost only
+container-use serve --listen localhost:8080
+
+# Serve on all interfaces, requiring a token
+CONTAINER_USE_SERVE_TOKEN=$(openssl rand -hex 32) container-use serve --listen :8080`,
+	RunE: func(app *cobra.Command, _ []string) error {
+		ctx := app.Context()
+		listen, _ := app.Flags().GetString("listen")
+		token, _ := app.Flags().GetString("token")
+		if token == "" {
+			token = os.Getenv(serveTokenEnv)
+		}
+		if token == "" {
+			generated := make([]byte, 32)
+			if _, err := rand.Read(generated); err != nil {
+				return err
+			}
+			token = hex.EncodeToString(generated)
+			fmt.Fprintf(app.ErrOrStderr(), "No token given, clients must send this one:\n\n  Authorization: Bearer %s\n\n", token)
+		}
+		allowedHosts, _ := app.Flags().GetStringSlice("allowed-host")
+
+		return mcpserver.RunHTTPServer(ctx, connectToolsDagger, version, mcpserver.HTTPOptions{
+			Addr:         listen,
+			Token:        token,
+			AllowedHosts: allowedHosts,
+			ConfigPath:   repository.ConfigPath(repository.DefaultBasePath()),
+			Tools: func(config *repository.Config) mcpserver.ToolConfig {
+				return toolConfigWithFlags(app, config)
+			},
+		})
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("listen", "localhost:8080", "Address to listen on")
+	serveCmd.Flags().String("token", "", "Bearer token clients must send (defaults to $"+serveTokenEnv+", or a random token)")
+	serveCmd.Flags().StringSlice("allowed-host", nil, "Additional host names clients may reach the server with, e.g. behind a proxy")
+	addToolFlags(serveCmd)
+	rootCmd.AddCommand(serveCmd)
+}
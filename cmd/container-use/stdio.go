@@ -1,39 +1,85 @@
 package main
 
 import (
+	"context"
 	"log/slog"
-	"os"
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/mcpserver"
+	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
 
 var stdioCmd = &cobra.Command{
 	Use:   "stdio",
 	Short: "Start MCP server for agent integration",
-	Long:  `Start the Model Context Protocol server that enables AI agents to create and manage containerized environments. This is typically used by agents like Claude Code, Cursor, or VSCode.`,
+	Long: `Start the Model Context Protocol server that enables AI agents to create and manage containerized environments. This is typically used by agents like Claude Code, Cursor, or VSCode.
+
+The tools exposed can be limited with --read-only, --enable-tools and --disable-tools,
+or in the [tools] section of ~/.config/container-use/config.toml.`,
 	RunE: func(app *cobra.Command, _ []string) error {
 		ctx := app.Context()
+		toolConfig, err := loadToolConfig(app)
+		if err != nil {
+			return err
+		}
 
-		slog.Info("connecting to dagger")
+		return mcpserver.RunStdioServer(ctx, connectToolsDagger, version, toolConfig)
+	},
+}
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logWriter))
-		if err != nil {
-			slog.Error("Error starting dagger", "error", err)
+// connectToolsDagger connects the MCP server to the engine, once the first tool needing it is called.
+func connectToolsDagger(ctx context.Context) (*dagger.Client, error) {
+	dag, err := connectDagger(ctx, logWriter)
+	if err != nil {
+		slog.Error("Error starting dagger", "error", err)
+		return nil, err
+	}
+	return dag, nil
+}
 
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
+// addToolFlags adds the flags selecting the tools exposed by an MCP server command.
+func addToolFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("read-only", false, "Only expose the tools that don't change environments, e.g. for review-only access")
+	cmd.Flags().StringSlice("enable-tools", nil, "Only expose these tools")
+	cmd.Flags().StringSlice("disable-tools", nil, "Hide these tools")
+	for _, flag := range []string{"enable-tools", "disable-tools"} {
+		cmd.RegisterFlagCompletionFunc(flag, func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return mcpserver.ToolNames(), cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+}
 
-			os.Exit(1)
-		}
-		defer dag.Close()
+// loadToolConfig returns the tools section of the global configuration, overridden by the flags of addToolFlags.
+func loadToolConfig(app *cobra.Command) (mcpserver.ToolConfig, error) {
+	config, err := repository.LoadConfig(repository.ConfigPath(repository.DefaultBasePath()))
+	if err != nil {
+		return mcpserver.ToolConfig{}, err
+	}
+	toolConfig := toolConfigWithFlags(app, config)
+	// Fail before connecting to the engine
+	if _, err := toolConfig.Exposed(); err != nil {
+		return mcpserver.ToolConfig{}, err
+	}
+	return toolConfig, nil
+}
 
-		return mcpserver.RunStdioServer(ctx, dag)
-	},
+// toolConfigWithFlags returns the tools section of config, overridden by the flags of addToolFlags.
+func toolConfigWithFlags(app *cobra.Command, config *repository.Config) mcpserver.ToolConfig {
+	toolConfig := mcpserver.ToolConfig(config.Tools)
+	if readOnly, _ := app.Flags().GetBool("read-only"); readOnly {
+		toolConfig.ReadOnly = true
+	}
+	if app.Flags().Changed("enable-tools") {
+		toolConfig.Enable, _ = app.Flags().GetStringSlice("enable-tools")
+	}
+	if app.Flags().Changed("disable-tools") {
+		toolConfig.Disable, _ = app.Flags().GetStringSlice("disable-tools")
+	}
+	return toolConfig
 }
 
 func init() {
+	addToolFlags(stdioCmd)
 	rootCmd.AddCommand(stdioCmd)
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <env>",
+	Short: "Bring your latest commits into an environment",
+	Long: `Bring the commits of your current branch, or of --upstream, into an
+environment that fell behind it while an agent was working: they are merged into
+the environment's branch, or with --rebase the environment's commits are
+replayed on top of them. The environment's container is updated with the
+result, keeping installed dependencies.
+
+If both sides changed the same lines, the sync stops and the conflicting files
+are listed. They are left in the environment with conflict markers: once they
+are resolved there, complete the sync with --continue, or give up on it with
+--abort.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: suggestEnvironments,
+	Example: `# Merge your current branch into the environment
+container-use sync backend-api
+
+# Rebase the environment onto main
+container-use sync backend-api --upstream main --rebase
+
+# Complete the sync once its conflicts are resolved
+container-use sync backend-api --continue`,
+	RunE: func(app *cobra.Command, args []string) error {
+		ctx := app.Context()
+
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
+			return err
+		}
+
+		upstream, _ := app.Flags().GetString("upstream")
+		strategy := repository.SyncMerge
+		if rebase, _ := app.Flags().GetBool("rebase"); rebase {
+			strategy = repository.SyncRebase
+		}
+		continueSync, _ := app.Flags().GetBool("continue")
+		abortSync, _ := app.Flags().GetBool("abort")
+
+		dag, err := connectDagger(ctx, os.Stderr)
+		if err != nil {
+			return err
+		}
+		defer dag.Close()
+
+		var result *repository.SyncResult
+		switch {
+		case abortSync:
+			if err := repo.AbortSync(ctx, dag, args[0], "Abort sync"); err != nil {
+				return fmt.Errorf("failed to abort sync: %w", err)
+			}
+			fmt.Printf("Sync of environment '%s' aborted.\n", args[0])
+			return nil
+		case continueSync:
+			result, err = repo.ContinueSync(ctx, dag, args[0], "Resolve sync conflicts")
+			if err != nil {
+				return fmt.Errorf("failed to continue sync: %w", err)
+			}
+			upstream = "upstream"
+			if len(result.UpstreamCommit) >= 7 {
+				upstream = result.UpstreamCommit[:7]
+			}
+		default:
+			result, err = repo.Sync(ctx, dag, args[0], upstream, strategy, "Sync with upstream changes")
+			if err != nil {
+				return fmt.Errorf("failed to sync environment: %w", err)
+			}
+			upstream = result.Upstream
+		}
+		switch {
+		case result.UpToDate:
+			fmt.Printf("Environment '%s' is up to date with %s.\n", args[0], upstream)
+		case len(result.Conflicts) > 0:
+			fmt.Printf("Environment '%s' conflicts with %s, resolve these files then run 'container-use sync %s --continue':\n", args[0], upstream, args[0])
+			for _, conflict := range result.Conflicts {
+				state := "unresolved"
+				if conflict.Resolved {
+					state = "resolved"
+				}
+				fmt.Printf("  %s (%s): %s\n", conflict.Kind, state, conflict.Path)
+			}
+			return fmt.Errorf("failed to %s %s", result.Strategy, upstream)
+		default:
+			fmt.Printf("Environment '%s' synced with %s (%d files changed).\n", args[0], upstream, len(result.ChangedFiles))
+		}
+		return nil
+	},
+}
+
+func init() {
+	syncCmd.Flags().String("upstream", "", "Branch to sync with (defaults to the current branch)")
+	syncCmd.Flags().Bool("rebase", false, "Rebase the environment onto the upstream branch instead of merging it")
+	syncCmd.Flags().Bool("continue", false, "Complete the sync in progress once its conflicts are resolved")
+	syncCmd.Flags().Bool("abort", false, "Give up on the sync in progress")
+	syncCmd.MarkFlagsMutuallyExclusive("continue", "abort")
+	rootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "List the presets environments can be created from",
+	Long: `Templates are presets of environment configuration: a base image, setup commands,
+variables and instructions. Agents pick one when creating an environment, instead
+of working out the setup of the project.
+
+Built-in templates ship with container-use. Add your own, or override a built-in
+one, as YAML files in ~/.config/container-use/templates/<name>.yaml, using the keys
+of .container-use/environment.yaml plus a description.`,
+	Args: cobra.NoArgs,
+	RunE: func(app *cobra.Command, _ []string) error {
+		templates, err := repository.Templates()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tIMAGE\tSOURCE\tDESCRIPTION")
+		for _, template := range templates {
+			image := template.Config.BaseImage
+			if template.Config.Dockerfile != "" {
+				image = template.Config.Dockerfile
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", template.Name, image, template.Source, truncate(app, template.Description, 60))
+		}
+		return tw.Flush()
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show the configuration of a template",
+	Long:  `Print a template as YAML, e.g. to start a template of your own from it.`,
+	Args:  cobra.ExactArgs(1),
+	Example: `# Customize the Python template
+container-use templates show python-3.12-uv > ~/.config/container-use/templates/python-ml.yaml`,
+	RunE: func(_ *cobra.Command, args []string) error {
+		template, err := repository.Template(args[0])
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(template)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
+	},
+}
+
+func init() {
+	templatesCmd.Flags().Bool("no-trunc", false, "Don't truncate output")
+	templatesCmd.AddCommand(templatesShowCmd)
+	rootCmd.AddCommand(templatesCmd)
+}
@@ -7,7 +7,6 @@ import (
 	"os/exec"
 	"syscall"
 
-	"dagger.io/dagger"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
 )
@@ -44,12 +43,9 @@ container-use terminal backend-api`,
 			return syscall.Exec(daggerBin, append([]string{"dagger", "run"}, os.Args...), os.Environ())
 		}
 
-		dag, err := dagger.Connect(ctx, dagger.WithLogOutput(os.Stderr))
+		dag, err := connectDagger(ctx, os.Stderr)
 		if err != nil {
-			if isDockerDaemonError(err) {
-				handleDockerDaemonError()
-			}
-			return fmt.Errorf("failed to connect to dagger: %w", err)
+			return err
 		}
 		defer dag.Close()
 		env, err := repo.Get(ctx, dag, args[0])
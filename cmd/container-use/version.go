@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"runtime/debug"
 
+	"dagger.io/dagger"
+	"dagger.io/dagger/engineconn"
 	"github.com/spf13/cobra"
 )
 
@@ -26,7 +30,7 @@ var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print version information",
 	Long:  `Print the version, commit hash, and build date of the container-use binary.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(app *cobra.Command, args []string) error {
 		fmt.Printf("container-use version %s\n", version)
 		if commit != "unknown" {
 			fmt.Printf("commit: %s\n", commit)
@@ -34,10 +38,51 @@ var versionCmd = &cobra.Command{
 		if date != "unknown" {
 			fmt.Printf("built: %s\n", date)
 		}
+		fmt.Printf("engine: v%s\n", engineconn.CLIVersion)
+
+		if remote, _ := app.Flags().GetBool("remote"); !remote {
+			return nil
+		}
+		return printRemoteVersion(app.Context())
 	},
 }
 
+// printRemoteVersion prints the version of the engine container-use connects to, and whether it is compatible.
+func printRemoteVersion(ctx context.Context) error {
+	if err := useSharedEngine(); err != nil {
+		return err
+	}
+	runnerHost := os.Getenv(daggerRunnerHostEnv)
+	if runnerHost == "" {
+		runnerHost = "provisioned locally"
+	}
+
+	// Connect without the version check of connectDagger, to report the skew instead of failing
+	dag, err := dagger.Connect(ctx, dagger.WithLogOutput(logWriter))
+	if err != nil {
+		return fmt.Errorf("failed to connect to dagger: %w", err)
+	}
+	defer dag.Close()
+	engineVersion, err := dag.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get the engine version: %w", err)
+	}
+
+	fmt.Printf("\nremote engine: %s (%s)\n", engineVersion, runnerHost)
+	skew, err := checkVersionSkew(engineVersion, engineconn.CLIVersion)
+	switch {
+	case err != nil:
+		fmt.Printf("status: incompatible, %s\n", err)
+	case skew != "":
+		fmt.Printf("status: compatible, %s\n", skew)
+	default:
+		fmt.Println("status: compatible")
+	}
+	return nil
+}
+
 func init() {
+	versionCmd.Flags().Bool("remote", false, "Also show the version of the engine and whether it is compatible")
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -1,38 +1,258 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/dagger/container-use/repository"
 	"github.com/spf13/cobra"
-	watch "github.com/tiborvass/go-watch"
 )
 
+// watchHistory is the number of recent commits the activity feed is built from.
+const watchHistory = 100
+
 var watchCmd = &cobra.Command{
-	Use:   "watch",
-	Short: "Watch environment activity in real-time",
-	Long: `Continuously display environment activity as agents work.
-Shows new commits and environment changes updated every second.
-Press Ctrl+C to stop watching.`,
+	Use:   "watch [<env>...]",
+	Short: "Watch agent activity across environments",
+	Long: `Display a live feed of what agents do in the environments of this repository:
+the commands they run, the files they change and the commits they make, newest first.
+Give environment IDs to only watch those. Press q or Ctrl+C to stop watching.
+
+When the output is not a terminal, new activity is printed as it happens instead.`,
 	Example: `# Watch all environment activity
 container-use watch
 
-# Monitor agents while they work
-container-use watch`,
-	RunE: func(app *cobra.Command, _ []string) error {
+# Watch a single environment
+container-use watch fancy-mallard
+
+# Follow activity in a log file
+container-use watch > activity.log`,
+	ValidArgsFunction: suggestEnvironments,
+	RunE: func(app *cobra.Command, envIDs []string) error {
 		ctx := app.Context()
 
-		// Ensure we're in a git repository
-		if _, err := repository.Open(ctx, "."); err != nil {
+		repo, err := repository.Open(ctx, ".")
+		if err != nil {
 			return err
 		}
+		interval, _ := app.Flags().GetDuration("interval")
+		if interval <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
 
-		w := watch.Watcher{Interval: time.Second}
-		w.Watch(app.Context(), "git", "log", "--color=always", "--remotes=container-use", "--oneline", "--graph", "--decorate")
-		return nil
+		if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return streamActivity(ctx, repo, os.Stdout, envIDs, interval)
+		}
+		_, err = tea.NewProgram(
+			watchModel{ctx: ctx, repo: repo, envIDs: envIDs, interval: interval},
+			tea.WithAltScreen(),
+			tea.WithContext(ctx),
+		).Run()
+		return err
 	},
 }
 
+// activityLine is a line of the activity feed.
+type activityLine struct {
+	Time          time.Time
+	EnvironmentID string
+	Commit        string
+	Kind          string // commit, command or file
+	Text          string
+}
+
+// activityLines flattens commits into the lines of the feed: each commit is followed by the commands
+// run and the files changed to produce it. Commits of environments other than envIDs, if any, are skipped.
+func activityLines(commits []*repository.ActivityCommit, envIDs []string) []activityLine {
+	lines := []activityLine{}
+	for _, commit := range commits {
+		if len(envIDs) > 0 && !slices.Contains(envIDs, commit.EnvironmentID) {
+			continue
+		}
+		line := activityLine{Time: commit.Time, EnvironmentID: commit.EnvironmentID, Commit: commit.Commit}
+		lines = append(lines, withKind(line, "commit", commit.Message))
+		for _, command := range commit.Commands {
+			lines = append(lines, withKind(line, "command", "$ "+command))
+		}
+		for _, file := range commit.Files {
+			lines = append(lines, withKind(line, "file", file.Status+" "+file.Path))
+		}
+	}
+	return lines
+}
+
+func withKind(line activityLine, kind, text string) activityLine {
+	line.Kind = kind
+	line.Text = text
+	return line
+}
+
+// streamActivity prints new activity to w as it happens, starting with the most recent commits.
+func streamActivity(ctx context.Context, repo *repository.Repository, w io.Writer, envIDs []string, interval time.Duration) error {
+	seen := map[string]bool{}
+	first := true
+	for {
+		commits, err := repo.Activity(ctx, watchHistory)
+		if err != nil {
+			return err
+		}
+		if first && len(commits) > 10 {
+			for _, commit := range commits[10:] {
+				seen[commit.Commit] = true
+			}
+		}
+		first = false
+
+		// Print the oldest new commits first
+		for _, commit := range slices.Backward(commits) {
+			if seen[commit.Commit] {
+				continue
+			}
+			seen[commit.Commit] = true
+			for _, line := range activityLines([]*repository.ActivityCommit{commit}, envIDs) {
+				fmt.Fprintf(w, "%s  %s  %s\n", line.Time.Local().Format(time.TimeOnly), line.EnvironmentID, line.Text)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+type watchModel struct {
+	ctx      context.Context
+	repo     *repository.Repository
+	envIDs   []string
+	interval time.Duration
+
+	lines     []activityLine
+	err       error
+	updatedAt time.Time
+	width     int
+	height    int
+}
+
+type activityMsg struct {
+	commits []*repository.ActivityCommit
+	err     error
+}
+
+type watchTickMsg struct{}
+
+func (m watchModel) poll() tea.Msg {
+	commits, err := m.repo.Activity(m.ctx, watchHistory)
+	return activityMsg{commits: commits, err: err}
+}
+
+func (m watchModel) Init() tea.Cmd {
+	return m.poll
+}
+
+func (m watchModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case activityMsg:
+		m.err = msg.err
+		if msg.err == nil {
+			m.lines = activityLines(msg.commits, m.envIDs)
+			m.updatedAt = time.Now()
+		}
+		return m, tea.Tick(m.interval, func(time.Time) tea.Msg { return watchTickMsg{} })
+	case watchTickMsg:
+		return m, m.poll
+	}
+	return m, nil
+}
+
+var (
+	watchTitleStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FAFAFA")).
+			Background(lipgloss.Color("#7D56F4")).
+			Padding(0, 1).
+			Bold(true)
+	watchDimStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#626262"))
+	watchCommitStyle  = lipgloss.NewStyle().Bold(true)
+	watchCommandStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+	watchErrorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#F25D94"))
+	// watchEnvColors tell environments apart in the feed
+	watchEnvColors = []string{"#F25D94", "#7D56F4", "#04B575", "#FFB86C", "#8BE9FD", "#F1FA8C", "#FF5555", "#BD93F9"}
+)
+
+func envStyle(id string) lipgloss.Style {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(watchEnvColors[h.Sum32()%uint32(len(watchEnvColors))]))
+}
+
+func (m watchModel) View() string {
+	var s strings.Builder
+
+	environments := map[string]bool{}
+	for _, line := range m.lines {
+		environments[line.EnvironmentID] = true
+	}
+	s.WriteString(watchTitleStyle.Render("container-use watch"))
+	s.WriteString(watchDimStyle.Render(fmt.Sprintf("  %d environments, updated %s, q to quit", len(environments), m.updatedAt.Format(time.TimeOnly))))
+	s.WriteString("\n\n")
+	if m.err != nil {
+		s.WriteString(watchErrorStyle.Render("Error: "+m.err.Error()) + "\n\n")
+	}
+	if len(m.lines) == 0 {
+		s.WriteString(watchDimStyle.Render("No activity yet. Waiting for agents..."))
+		return s.String()
+	}
+
+	idWidth := 0
+	for id := range environments {
+		idWidth = max(idWidth, len(id))
+	}
+	available := len(m.lines)
+	if m.height > 0 {
+		available = max(m.height-4, 1)
+	}
+	for i, line := range m.lines {
+		if i >= available {
+			break
+		}
+		text := line.Text
+		switch line.Kind {
+		case "commit":
+			text = watchCommitStyle.Render("● " + text)
+		case "command":
+			text = watchCommandStyle.Render("  " + text)
+		default:
+			text = "  " + text
+		}
+		row := fmt.Sprintf("%s  %s  %s",
+			watchDimStyle.Render(line.Time.Local().Format(time.TimeOnly)),
+			envStyle(line.EnvironmentID).Render(fmt.Sprintf("%-*s", idWidth, line.EnvironmentID)),
+			text)
+		if m.width > 0 {
+			row = lipgloss.NewStyle().MaxWidth(m.width).Render(row)
+		}
+		s.WriteString(row + "\n")
+	}
+	return s.String()
+}
+
 func init() {
+	watchCmd.Flags().Duration("interval", time.Second, "How often to check for new activity")
 	rootCmd.AddCommand(watchCmd)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/repository"
+)
+
+func TestActivityLines(t *testing.T) {
+	commits := []*repository.ActivityCommit{
+		{
+			EnvironmentID: "fancy-mallard",
+			Commit:        "abc123",
+			Message:       "Add login form",
+			Commands:      []string{"npm test"},
+			Files:         []repository.FileChange{{Status: "A", Path: "src/login.tsx"}},
+		},
+		{EnvironmentID: "quiet-otter", Commit: "def456", Message: "Create environment"},
+	}
+
+	tests := []struct {
+		name     string
+		envIDs   []string
+		expected []string
+	}{
+		{
+			name:     "all environments",
+			expected: []string{"fancy-mallard commit Add login form", "fancy-mallard command $ npm test", "fancy-mallard file A src/login.tsx", "quiet-otter commit Create environment"},
+		},
+		{
+			name:     "filtered",
+			envIDs:   []string{"quiet-otter"},
+			expected: []string{"quiet-otter commit Create environment"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := activityLines(commits, tt.envIDs)
+			if len(lines) != len(tt.expected) {
+				t.Fatalf("activityLines() returned %d lines, want %d", len(lines), len(tt.expected))
+			}
+			for i, line := range lines {
+				if got := line.EnvironmentID + " " + line.Kind + " " + line.Text; got != tt.expected[i] {
+					t.Errorf("line %d = %q, want %q", i, got, tt.expected[i])
+				}
+			}
+		})
+	}
+}
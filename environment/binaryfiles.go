@@ -0,0 +1,59 @@
+package environment
+
+import "fmt"
+
+// BinaryFileMode is what happens to binary files, such as images or compiled artifacts, when committing
+// the changes of an environment.
+type BinaryFileMode string
+
+const (
+	// BinaryFilesSkip leaves binary files uncommitted, and tells the agent about them.
+	BinaryFilesSkip BinaryFileMode = "skip"
+	// BinaryFilesCommit commits binary files up to BinaryFileSettings.MaxSize like any other file. Bigger
+	// ones are skipped.
+	BinaryFilesCommit BinaryFileMode = "commit"
+	// BinaryFilesLFS commits binary files with Git LFS: the environment branch tracks them in .gitattributes
+	// and only holds pointers to their contents. git-lfs must be installed.
+	BinaryFilesLFS BinaryFileMode = "lfs"
+)
+
+// DefaultBinaryFileMaxSize is the size up to which binary files are committed with BinaryFilesCommit by
+// default.
+const DefaultBinaryFileMaxSize = 1 << 20
+
+// BinaryFileSettings configure how the binary files of environments are committed to their branch.
+type BinaryFileSettings struct {
+	// Mode is BinaryFilesSkip if empty.
+	Mode BinaryFileMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// MaxSize is the size in bytes above which binary files are skipped with BinaryFilesCommit,
+	// DefaultBinaryFileMaxSize if zero.
+	MaxSize int64 `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+}
+
+func (s BinaryFileSettings) validate() error {
+	switch s.Mode {
+	case "", BinaryFilesSkip, BinaryFilesCommit, BinaryFilesLFS:
+	default:
+		return fmt.Errorf("invalid binary files mode %q, expected one of skip, commit, lfs", s.Mode)
+	}
+	if s.MaxSize < 0 {
+		return fmt.Errorf("invalid binary files max size %d", s.MaxSize)
+	}
+	return nil
+}
+
+// Commits reports whether a binary file of size bytes is committed, with or without Git LFS.
+func (s BinaryFileSettings) Commits(size int64) bool {
+	switch s.Mode {
+	case BinaryFilesLFS:
+		return true
+	case BinaryFilesCommit:
+		maxSize := s.MaxSize
+		if maxSize == 0 {
+			maxSize = DefaultBinaryFileMaxSize
+		}
+		return size <= maxSize
+	default:
+		return false
+	}
+}
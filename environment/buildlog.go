@@ -0,0 +1,51 @@
+package environment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BuildLog is the full output of the setup commands of a build.
+type BuildLog struct {
+	StartedAt time.Time
+	Failed    bool
+	Output    string
+}
+
+// BuildError is returned when a setup command fails. It carries the log of the failed build.
+type BuildError struct {
+	Log *BuildLog
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// buildLogWriter accumulates the output of setup commands.
+type buildLogWriter struct {
+	strings.Builder
+}
+
+func (w *buildLogWriter) phase(name string) {
+	fmt.Fprintf(w, "=== phase %s ===\n", name)
+}
+
+func (w *buildLogWriter) command(command string, exitCode int, stdout, stderr string, duration time.Duration) {
+	fmt.Fprintf(w, "$ %s\n", command)
+	for _, output := range []string{stdout, stderr} {
+		if output == "" {
+			continue
+		}
+		w.WriteString(output)
+		if !strings.HasSuffix(output, "\n") {
+			w.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(w, "[exit code %d, %s]\n\n", exitCode, duration.Round(time.Millisecond))
+}
@@ -0,0 +1,51 @@
+package environment
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// validateCaches checks the cache directories of the configuration: absolute paths, outside of the
+// workdir, whose contents are committed rather than cached.
+func (config *EnvironmentConfig) validateCaches() error {
+	for _, dir := range config.Caches {
+		clean := path.Clean(dir)
+		switch {
+		case !path.IsAbs(dir):
+			return fmt.Errorf("cache %q must be an absolute path", dir)
+		case clean == "/":
+			return fmt.Errorf("cache %q can't be the root directory", dir)
+		case isWithin(clean, config.Workdir) || isWithin(config.Workdir, clean):
+			return fmt.Errorf("cache %q overlaps the workdir %s, whose files are tracked in git", dir, config.Workdir)
+		}
+	}
+	return nil
+}
+
+func isWithin(dir, parent string) bool {
+	return dir == parent || strings.HasPrefix(dir, strings.TrimSuffix(parent, "/")+"/")
+}
+
+// cacheVolume returns the volume caching dir. Volumes are keyed by path rather than by environment, so
+// that every environment, of every repository, shares the downloads of the same toolchain.
+func (env *Environment) cacheVolume(dir string) *dagger.CacheVolume {
+	key := strings.ReplaceAll(strings.Trim(path.Clean(dir), "/"), "/", "-")
+	return env.dag.CacheVolume(cacheVolumeName("cache", key))
+}
+
+// withCaches mounts the cache directories of the configuration in container.
+func (env *Environment) withCaches(container *dagger.Container) (*dagger.Container, error) {
+	if err := env.Config.validateCaches(); err != nil {
+		return nil, err
+	}
+	for _, dir := range env.Config.Caches {
+		container = container.WithMountedCache(path.Clean(dir), env.cacheVolume(dir), dagger.ContainerWithMountedCacheOpts{
+			Sharing: dagger.CacheSharingModeShared,
+			Owner:   env.Config.User,
+		})
+	}
+	return container, nil
+}
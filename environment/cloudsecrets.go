@@ -0,0 +1,246 @@
+package environment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gcpAccessTokenEnv is an OAuth access token for Google Cloud, as used by Terraform and the Google
+	// client libraries. Without it, a token is obtained from gcloud.
+	gcpAccessTokenEnv = "GOOGLE_OAUTH_ACCESS_TOKEN"
+
+	// azureTenantIDEnv, azureClientIDEnv and azureClientSecretEnv are the credentials of an Azure service
+	// principal, as used by the Azure SDKs. Without them, a token is obtained from the Azure CLI.
+	azureTenantIDEnv     = "AZURE_TENANT_ID"
+	azureClientIDEnv     = "AZURE_CLIENT_ID"
+	azureClientSecretEnv = "AZURE_CLIENT_SECRET"
+	azureKeyVaultScope   = "https://vault.azure.net/.default"
+	azureKeyVaultVersion = "7.4"
+
+	// cloudTokenTTL is how long access tokens obtained from CLIs are reused. They are valid for an hour.
+	cloudTokenTTL = 30 * time.Minute
+)
+
+var (
+	// gcpSecretManagerURL, azureLoginURL, azureKeyVaultURL and runCloudCLI are replaced in tests.
+	gcpSecretManagerURL = "https://secretmanager.googleapis.com/v1/"
+	azureLoginURL       = "https://login.microsoftonline.com/"
+	azureKeyVaultURL    = func(vault string) string { return fmt.Sprintf("https://%s.vault.azure.net/", vault) }
+	runCloudCLI         = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, name, args...).Output()
+	}
+
+	gcpToken   cachedToken
+	azureToken cachedToken
+)
+
+// cachedToken is an access token reused until it expires.
+type cachedToken struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// get returns the cached token, or the one fetch returns along with how long it's valid.
+func (c *cachedToken) get(fetch func() (string, time.Duration, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.expiresAt) {
+		return c.token, nil
+	}
+	token, ttl, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiresAt = token, time.Now().Add(ttl-ttl/10)
+	return token, nil
+}
+
+// CloudSecretError reports that a secret could not be read from a cloud secret manager.
+type CloudSecretError struct {
+	Reference string
+	Reason    string
+}
+
+func (e *CloudSecretError) Error() string {
+	return fmt.Sprintf("failed to read %s: %s", e.Reference, e.Reason)
+}
+
+// resolveGCPSecret reads the value of a gcp-sm://projects/<project>/secrets/<name>[/versions/<version>]
+// reference from Google Cloud Secret Manager, the latest version by default.
+func resolveGCPSecret(ctx context.Context, reference string) (string, error) {
+	fail := func(format string, args ...any) error {
+		return &CloudSecretError{Reference: reference, Reason: fmt.Sprintf(format, args...)}
+	}
+	name := strings.Trim(strings.TrimPrefix(reference, "gcp-sm://"), "/")
+	parts := strings.Split(name, "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		name += "/versions/latest"
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		parts = nil
+	}
+	if len(parts) == 0 || slices.Contains(parts, "") {
+		return "", fail("expected gcp-sm://projects/<project>/secrets/<name>[/versions/<version>]")
+	}
+
+	token := os.Getenv(gcpAccessTokenEnv)
+	var err error
+	if token == "" {
+		token, err = gcpToken.get(func() (string, time.Duration, error) {
+			token, err := cloudCLIToken(ctx, "gcloud", "auth", "print-access-token")
+			return token, cloudTokenTTL, err
+		})
+	}
+	if err != nil {
+		return "", fail("%s, set %s or sign in with `gcloud auth login`", err, gcpAccessTokenEnv)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := cloudRequest(ctx, http.MethodGet, gcpSecretManagerURL+name+":access", token, nil, &version); err != nil {
+		return "", fail("%s", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fail("invalid payload: %s", err)
+	}
+	return string(value), nil
+}
+
+// resolveAzureSecret reads the value of an azure-kv://<vault>/<secret>[/<version>] reference from Azure
+// Key Vault, the latest version by default.
+func resolveAzureSecret(ctx context.Context, reference string) (string, error) {
+	fail := func(format string, args ...any) error {
+		return &CloudSecretError{Reference: reference, Reason: fmt.Sprintf(format, args...)}
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(reference, "azure-kv://"), "/"), "/")
+	if (len(parts) != 2 && len(parts) != 3) || slices.Contains(parts, "") {
+		return "", fail("expected azure-kv://<vault>/<secret>[/<version>]")
+	}
+
+	token, err := azureToken.get(func() (string, time.Duration, error) {
+		tenant, client, secret := os.Getenv(azureTenantIDEnv), os.Getenv(azureClientIDEnv), os.Getenv(azureClientSecretEnv)
+		if tenant != "" && client != "" && secret != "" {
+			return azureClientCredentialsToken(ctx, tenant, client, secret)
+		}
+		token, err := cloudCLIToken(ctx, "az", "account", "get-access-token", "--resource", "https://vault.azure.net", "--query", "accessToken", "--output", "tsv")
+		return token, cloudTokenTTL, err
+	})
+	if err != nil {
+		return "", fail("%s, set %s, %s and %s or sign in with `az login`", err, azureTenantIDEnv, azureClientIDEnv, azureClientSecretEnv)
+	}
+
+	u := azureKeyVaultURL(parts[0]) + "secrets/" + strings.Join(parts[1:], "/") + "?api-version=" + azureKeyVaultVersion
+	var secret struct {
+		Value string `json:"value"`
+	}
+	if err := cloudRequest(ctx, http.MethodGet, u, token, nil, &secret); err != nil {
+		return "", fail("%s", err)
+	}
+	return secret.Value, nil
+}
+
+func azureClientCredentialsToken(ctx context.Context, tenant, client, secret string) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {client},
+		"client_secret": {secret},
+		"scope":         {azureKeyVaultScope},
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	u := azureLoginURL + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	if err := cloudRequest(ctx, http.MethodPost, u, "", form, &token); err != nil {
+		return "", 0, fmt.Errorf("service principal sign-in failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", 0, errors.New("service principal sign-in returned no token")
+	}
+	return token.AccessToken, time.Duration(token.ExpiresIn) * time.Second, nil
+}
+
+// cloudCLIToken returns the access token a cloud CLI prints.
+func cloudCLIToken(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	out, err := runCloudCLI(ctx, name, args...)
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("not authenticated, and the %s CLI is not installed", name)
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%s failed: %s", name, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return "", fmt.Errorf("%s returned no access token", name)
+	}
+	return token, nil
+}
+
+// cloudRequest calls a cloud API, sending form if not nil, and decodes the response into out.
+func cloudRequest(ctx context.Context, method, u, token string, form url.Values, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := secretsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Google, Azure Key Vault and Microsoft Entra all describe errors in a JSON object
+		var errs struct {
+			Error            json.RawMessage `json:"error"`
+			ErrorDescription string          `json:"error_description"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errs)
+		var detail struct {
+			Message string `json:"message"`
+		}
+		message := errs.ErrorDescription
+		if message == "" && json.Unmarshal(errs.Error, &detail) == nil {
+			message = detail.Message
+		}
+		if message == "" {
+			return fmt.Errorf("responded %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+		}
+		return fmt.Errorf("responded %d: %s", resp.StatusCode, message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,117 @@
+package environment
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCloud serves Google Cloud Secret Manager, Azure Key Vault and Microsoft Entra sign-ins for the
+// duration of the test, with cli standing in for gcloud and az.
+func fakeCloud(t *testing.T, cli func(name string, args ...string) ([]byte, error)) {
+	t.Helper()
+	reply := func(w http.ResponseWriter, status int, body any) {
+		w.WriteHeader(status)
+		require.NoError(t, json.NewEncoder(w).Encode(body))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login/tenant/oauth2/v2.0/token" {
+			require.NoError(t, r.ParseForm())
+			if r.PostForm.Get("client_secret") != "client-secret" {
+				reply(w, http.StatusUnauthorized, map[string]any{"error": "invalid_client", "error_description": "Invalid client secret provided."})
+				return
+			}
+			reply(w, http.StatusOK, map[string]any{"access_token": "azure-token", "expires_in": 3600})
+			return
+		}
+		token := r.Header.Get("Authorization")
+		switch {
+		case r.URL.Path == "/gcp/projects/acme/secrets/api-key/versions/latest:access" && token == "Bearer gcp-token",
+			r.URL.Path == "/gcp/projects/acme/secrets/api-key/versions/2:access" && token == "Bearer gcp-token":
+			reply(w, http.StatusOK, map[string]any{"payload": map[string]any{"data": base64.StdEncoding.EncodeToString([]byte("gcp-s3cr3t"))}})
+		case r.URL.Path == "/azure/acme-vault/secrets/db-password" && token == "Bearer azure-token":
+			assert.Equal(t, azureKeyVaultVersion, r.URL.Query().Get("api-version"))
+			reply(w, http.StatusOK, map[string]any{"value": "azure-s3cr3t"})
+		default:
+			reply(w, http.StatusNotFound, map[string]any{"error": map[string]any{"code": 404, "message": "Secret not found"}})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	origGCP, origLogin, origVault, origCLI := gcpSecretManagerURL, azureLoginURL, azureKeyVaultURL, runCloudCLI
+	t.Cleanup(func() {
+		gcpSecretManagerURL, azureLoginURL, azureKeyVaultURL, runCloudCLI = origGCP, origLogin, origVault, origCLI
+		gcpToken, azureToken = cachedToken{}, cachedToken{}
+	})
+	gcpSecretManagerURL = server.URL + "/gcp/"
+	azureLoginURL = server.URL + "/login/"
+	azureKeyVaultURL = func(vault string) string { return server.URL + "/azure/" + vault + "/" }
+	runCloudCLI = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		return cli(name, args...)
+	}
+}
+
+func TestResolveGCPSecret(t *testing.T) {
+	calls := 0
+	fakeCloud(t, func(name string, args ...string) ([]byte, error) {
+		calls++
+		assert.Equal(t, "gcloud", name)
+		return []byte("gcp-token\n"), nil
+	})
+	ctx := context.Background()
+	t.Setenv(gcpAccessTokenEnv, "")
+
+	value, err := resolveGCPSecret(ctx, "gcp-sm://projects/acme/secrets/api-key")
+	require.NoError(t, err)
+	assert.Equal(t, "gcp-s3cr3t", value)
+	value, err = resolveGCPSecret(ctx, "gcp-sm://projects/acme/secrets/api-key/versions/2")
+	require.NoError(t, err)
+	assert.Equal(t, "gcp-s3cr3t", value)
+	assert.Equal(t, 1, calls, "the access token is reused")
+
+	var cloudErr *CloudSecretError
+	_, err = resolveGCPSecret(ctx, "gcp-sm://projects/acme/secrets/missing")
+	require.ErrorAs(t, err, &cloudErr)
+	assert.Contains(t, err.Error(), "Secret not found")
+	for _, invalid := range []string{"gcp-sm://acme/api-key", "gcp-sm://projects/acme/secrets/", "gcp-sm://projects/acme/secrets/api-key/2"} {
+		_, err = resolveGCPSecret(ctx, invalid)
+		assert.ErrorContains(t, err, "expected gcp-sm://", invalid)
+	}
+
+	t.Setenv(gcpAccessTokenEnv, "wrong-token")
+	_, err = resolveGCPSecret(ctx, "gcp-sm://projects/acme/secrets/api-key")
+	assert.Error(t, err, "the access token of the environment takes precedence")
+}
+
+func TestResolveAzureSecret(t *testing.T) {
+	fakeCloud(t, func(name string, args ...string) ([]byte, error) {
+		return nil, exec.ErrNotFound
+	})
+	ctx := context.Background()
+	t.Setenv(azureTenantIDEnv, "tenant")
+	t.Setenv(azureClientIDEnv, "client")
+	t.Setenv(azureClientSecretEnv, "wrong")
+
+	_, err := resolveAzureSecret(ctx, "azure-kv://acme-vault/db-password")
+	assert.ErrorContains(t, err, "Invalid client secret provided.")
+
+	t.Setenv(azureClientSecretEnv, "client-secret")
+	value, err := resolveAzureSecret(ctx, "azure-kv://acme-vault/db-password")
+	require.NoError(t, err)
+	assert.Equal(t, "azure-s3cr3t", value)
+
+	_, err = resolveAzureSecret(ctx, "azure-kv://acme-vault")
+	assert.ErrorContains(t, err, "expected azure-kv://")
+
+	azureToken = cachedToken{}
+	t.Setenv(azureClientSecretEnv, "")
+	_, err = resolveAzureSecret(ctx, "azure-kv://acme-vault/db-password")
+	assert.ErrorContains(t, err, "the az CLI is not installed")
+}
@@ -1,11 +1,17 @@
 package environment
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path"
+	"slices"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -14,7 +20,9 @@ const (
 	configDir        = ".container-use"
 	instructionsFile = "AGENT.md"
 	environmentFile  = "environment.json"
-	lockFile         = "lock"
+	// environmentYAMLFile is the hand-written alternative to environmentFile, see LoadFrom.
+	environmentYAMLFile = "environment.yaml"
+	lockFile            = "lock"
 )
 
 func DefaultConfig() *EnvironmentConfig {
@@ -26,23 +34,66 @@ func DefaultConfig() *EnvironmentConfig {
 }
 
 type EnvironmentConfig struct {
-	Instructions  string         `json:"-"`
-	Workdir       string         `json:"workdir,omitempty"`
-	BaseImage     string         `json:"base_image,omitempty"`
-	SetupCommands []string       `json:"setup_commands,omitempty"`
-	Env           KVList         `json:"env,omitempty"`
-	Secrets       KVList         `json:"secrets,omitempty"`
-	Services      ServiceConfigs `json:"services,omitempty"`
-	Locked        bool
+	Instructions string `json:"-" yaml:"instructions,omitempty"`
+	Workdir      string `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	BaseImage    string `json:"base_image,omitempty" yaml:"base_image,omitempty"`
+	// Dockerfile, relative to the root of the repository, builds the image to use instead of BaseImage,
+	// with the repository as build context. BuildArgs (NAME=value) and BuildTarget apply to it.
+	Dockerfile    string         `json:"dockerfile,omitempty" yaml:"dockerfile,omitempty"`
+	BuildArgs     KVList         `json:"build_args,omitempty" yaml:"build_args,omitempty"`
+	BuildTarget   string         `json:"build_target,omitempty" yaml:"build_target,omitempty"`
+	Platform      string         `json:"platform,omitempty" yaml:"platform,omitempty"` // e.g. linux/amd64, see ValidatePlatform
+	SetupCommands []string       `json:"setup_commands,omitempty" yaml:"setup_commands,omitempty"`
+	SetupPhases   SetupPhases    `json:"setup_phases,omitempty" yaml:"setup_phases,omitempty"` // commands by phase, see SetupPhaseNames
+	Env           KVList         `json:"env,omitempty" yaml:"env,omitempty"`
+	Secrets       KVList         `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	EnvFiles      []string       `json:"env_files,omitempty" yaml:"env_files,omitempty"` // .env files, see containerWithEnvFiles
+	Caches        []string       `json:"caches,omitempty" yaml:"caches,omitempty"`       // directories shared across environments, see withCaches
+	Services      ServiceConfigs `json:"services,omitempty" yaml:"services,omitempty"`
+	Locked        bool           `yaml:"-"`
+
+	// User runs the commands of the environment, as a user name or UID with an optional group (e.g. node,
+	// 1000:1000). Setup commands run as root, see withUser. Empty keeps the user of the image.
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Docker runs a Docker daemon next to the environment, and installs the Docker CLI to use it, see
+	// startDocker.
+	Docker bool `json:"docker,omitempty" yaml:"docker,omitempty"`
+	// DefaultCommand is the shell command run by commands without arguments, and by containers started
+	// from checkpoints. Entrypoint, if set, replaces the entrypoint of the image. Empty values keep those
+	// of the image.
+	DefaultCommand string   `json:"default_command,omitempty" yaml:"default_command,omitempty"`
+	Entrypoint     []string `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	// Registries hold the credentials of private registries images are pulled from and pushed to.
+	Registries []RegistryCredentials `json:"registries,omitempty" yaml:"registries,omitempty"`
+	// ForwardSSHAgent forwards the SSH agent of the host, and GitCredentials lists the hosts whose git
+	// credentials are forwarded from the credential helper of the host. See withHostCredentials.
+	ForwardSSHAgent bool     `json:"forward_ssh_agent,omitempty" yaml:"forward_ssh_agent,omitempty"`
+	GitCredentials  []string `json:"git_credentials,omitempty" yaml:"git_credentials,omitempty"`
+	// Network restricts what commands of the environment can reach, see withNetworkPolicy.
+	Network NetworkPolicy `json:"network,omitzero" yaml:"network,omitempty"`
+	// Mounts are host paths made available in the environment, see withMounts.
+	Mounts []Mount `json:"mounts,omitempty" yaml:"mounts,omitempty"`
+	// AllowedMounts are the host directories Mounts may be read from. They come from the global
+	// configuration rather than the repository, so that agents can't widen them.
+	AllowedMounts []string `json:"-" yaml:"-"`
 }
 
 type ServiceConfig struct {
-	Name         string   `json:"name,omitempty"`
-	Image        string   `json:"image,omitempty"`
-	Command      string   `json:"command,omitempty"`
-	ExposedPorts []int    `json:"exposed_ports,omitempty"`
-	Env          []string `json:"env,omitempty"`
-	Secrets      []string `json:"secrets,omitempty"`
+	Name         string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Image        string   `json:"image,omitempty" yaml:"image,omitempty"`
+	Command      string   `json:"command,omitempty" yaml:"command,omitempty"`
+	ExposedPorts []int    `json:"exposed_ports,omitempty" yaml:"exposed_ports,omitempty"`
+	Env          []string `json:"env,omitempty" yaml:"env,omitempty"`
+	Secrets      []string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	// DependsOn lists services that must be started before this one. They are reachable from it by hostname.
+	DependsOn []string `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+	// Aliases are additional hostnames the service is reachable at.
+	Aliases []string `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+}
+
+// Hostnames returns the names the service is reachable at: its name followed by its aliases.
+func (sc *ServiceConfig) Hostnames() []string {
+	return append([]string{sc.Name}, sc.Aliases...)
 }
 
 type ServiceConfigs []*ServiceConfig
@@ -56,6 +107,71 @@ func (sc ServiceConfigs) Get(name string) *ServiceConfig {
 	return nil
 }
 
+// Aliases maps every service alias to the name of its service.
+func (sc ServiceConfigs) Aliases() map[string]string {
+	aliases := map[string]string{}
+	for _, cfg := range sc {
+		for _, alias := range cfg.Aliases {
+			aliases[alias] = cfg.Name
+		}
+	}
+	return aliases
+}
+
+// StartOrder returns the services sorted so that each one comes after its dependencies.
+// Services without ordering constraints keep their declaration order.
+func (sc ServiceConfigs) StartOrder() ([]*ServiceConfig, error) {
+	hostnames := map[string]string{}
+	for _, cfg := range sc {
+		for _, hostname := range cfg.Hostnames() {
+			if other, ok := hostnames[hostname]; ok {
+				return nil, fmt.Errorf("hostname %q is used by both services %s and %s", hostname, other, cfg.Name)
+			}
+			hostnames[hostname] = cfg.Name
+		}
+	}
+	for _, cfg := range sc {
+		for _, dep := range cfg.DependsOn {
+			if sc.Get(dep) == nil {
+				return nil, fmt.Errorf("service %s depends on unknown service %s", cfg.Name, dep)
+			}
+		}
+	}
+
+	ordered := make([]*ServiceConfig, 0, len(sc))
+	started := map[string]bool{}
+	for len(ordered) < len(sc) {
+		progress := false
+		for _, cfg := range sc {
+			if started[cfg.Name] {
+				continue
+			}
+			ready := true
+			for _, dep := range cfg.DependsOn {
+				if !started[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, cfg)
+				started[cfg.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			pending := []string{}
+			for _, cfg := range sc {
+				if !started[cfg.Name] {
+					pending = append(pending, cfg.Name)
+				}
+			}
+			return nil, fmt.Errorf("circular dependency between services %s", strings.Join(pending, ", "))
+		}
+	}
+	return ordered, nil
+}
+
 // KVList represents a list of key-value pairs in the format KEY=VALUE
 type KVList []string
 
@@ -116,6 +232,12 @@ func (kv KVList) Get(key string) string {
 
 func (config *EnvironmentConfig) Copy() *EnvironmentConfig {
 	copy := *config
+	copy.SetupPhases = config.SetupPhases.Copy()
+	copy.Registries = slices.Clone(config.Registries)
+	copy.Mounts = slices.Clone(config.Mounts)
+	copy.GitCredentials = slices.Clone(config.GitCredentials)
+	copy.Entrypoint = slices.Clone(config.Entrypoint)
+	copy.Network.Allow = slices.Clone(config.Network.Allow)
 	copy.Services = make(ServiceConfigs, len(config.Services))
 	for i, svc := range config.Services {
 		svcCopy := *svc
@@ -124,6 +246,38 @@ func (config *EnvironmentConfig) Copy() *EnvironmentConfig {
 	return &copy
 }
 
+// Commands returns the shell commands the configuration runs: its setup commands, default command and
+// entrypoint, and the commands of its services.
+func (config *EnvironmentConfig) Commands() []string {
+	commands := slices.Clone(config.SetupCommands)
+	for _, phase := range SetupPhaseNames {
+		commands = append(commands, config.SetupPhases[phase]...)
+	}
+	if config.DefaultCommand != "" {
+		commands = append(commands, config.DefaultCommand)
+	}
+	if command := config.EntrypointCommand(); command != "" {
+		commands = append(commands, command)
+	}
+	for _, service := range config.Services {
+		if service.Command != "" {
+			commands = append(commands, service.Command)
+		}
+	}
+	return commands
+}
+
+// EntrypointCommand returns the entrypoint of the configuration as a shell command, empty if it has none.
+func (config *EnvironmentConfig) EntrypointCommand() string {
+	words := make([]string, len(config.Entrypoint))
+	for i, word := range config.Entrypoint {
+		words[i] = shellQuote(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// Save writes the configuration to environment.json and AGENT.md in baseDir. A checked-in environment.yaml
+// is left as it is: it only seeds environments.
 func (config *EnvironmentConfig) Save(baseDir string) error {
 	configPath := path.Join(baseDir, configDir)
 	if err := os.MkdirAll(configPath, 0755); err != nil {
@@ -147,9 +301,20 @@ func (config *EnvironmentConfig) Save(baseDir string) error {
 }
 
 func (config *EnvironmentConfig) Load(baseDir string) error {
-	configPath := path.Join(baseDir, configDir)
+	return config.LoadFrom(func(name string) ([]byte, error) {
+		return os.ReadFile(path.Join(baseDir, name))
+	})
+}
 
-	instructions, err := os.ReadFile(path.Join(configPath, instructionsFile))
+// LoadFrom loads the configuration with readFile, which reads files given their path relative to
+// the root of the repository and returns an error satisfying os.IsNotExist for missing files.
+//
+// The configuration is read from .container-use/environment.json, or else from environment.yaml, which
+// repositories check in to give every new environment the same defaults: once saved, the configuration
+// of an environment lives in environment.json. Instructions set in environment.yaml take precedence over
+// AGENT.md.
+func (config *EnvironmentConfig) LoadFrom(readFile func(name string) ([]byte, error)) error {
+	instructions, err := readFile(path.Join(configDir, instructionsFile))
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
@@ -157,18 +322,53 @@ func (config *EnvironmentConfig) Load(baseDir string) error {
 		config.Instructions = string(instructions)
 	}
 
-	data, err := os.ReadFile(path.Join(configPath, environmentFile))
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	if err == nil {
+	data, err := readFile(path.Join(configDir, environmentFile))
+	switch {
+	case err == nil:
 		if err := json.Unmarshal(data, config); err != nil {
 			return err
 		}
+	case !os.IsNotExist(err):
+		return err
+	default:
+		yamlData, err := readFile(path.Join(configDir, environmentYAMLFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if err := config.loadYAML(yamlData); err != nil {
+				return fmt.Errorf("invalid %s: %w", path.Join(configDir, environmentYAMLFile), err)
+			}
+		}
 	}
-	if _, err := os.Stat(path.Join(baseDir, configDir, lockFile)); err == nil {
+	if _, err := readFile(path.Join(configDir, lockFile)); err == nil {
 		config.Locked = true
 	}
 
 	return nil
 }
+
+// hasConfigFile reports whether the repository at baseDir configures its environments, as opposed to
+// relying on the defaults.
+func hasConfigFile(baseDir string) bool {
+	for _, name := range []string{environmentFile, environmentYAMLFile} {
+		if _, err := os.Stat(path.Join(baseDir, configDir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (config *EnvironmentConfig) loadYAML(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	instructions := config.Instructions
+	config.Instructions = ""
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if config.Instructions == "" {
+		config.Instructions = instructions
+	}
+	return nil
+}
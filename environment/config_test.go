@@ -111,6 +111,60 @@ func TestEnvironmentConfig_Load(t *testing.T) {
 	}
 }
 
+func TestEnvironmentConfig_LoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	createInstructionsFile(t, dir, "From AGENT.md")
+	writeYAML := func(content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ".container-use", "environment.yaml"), []byte(content), 0644))
+	}
+
+	writeYAML(`base_image: golang:1.24
+setup_commands:
+  - go mod download
+env:
+  - CGO_ENABLED=0
+secrets:
+  - GITHUB_TOKEN=env://GITHUB_TOKEN
+services:
+  - name: postgres
+    image: postgres:16
+    exposed_ports: [5432]
+default_command: go run ./cmd/server
+entrypoint: [/usr/bin/tini, --]
+`)
+	config := DefaultConfig()
+	require.NoError(t, config.Load(dir))
+	assert.Equal(t, "golang:1.24", config.BaseImage)
+	assert.Equal(t, "/workdir", config.Workdir, "defaults apply to unset fields")
+	assert.Equal(t, []string{"go mod download"}, config.SetupCommands)
+	assert.Equal(t, KVList{"CGO_ENABLED=0"}, config.Env)
+	assert.Equal(t, KVList{"GITHUB_TOKEN=env://GITHUB_TOKEN"}, config.Secrets)
+	require.Len(t, config.Services, 1)
+	assert.Equal(t, []int{5432}, config.Services[0].ExposedPorts)
+	assert.Equal(t, "go run ./cmd/server", config.DefaultCommand)
+	assert.Equal(t, []string{"/usr/bin/tini", "--"}, config.Entrypoint)
+	assert.Equal(t, "From AGENT.md", config.Instructions)
+
+	writeYAML("instructions: Run go test ./...\n")
+	config = DefaultConfig()
+	require.NoError(t, config.Load(dir))
+	assert.Equal(t, "Run go test ./...", config.Instructions, "instructions in the YAML file take precedence")
+
+	writeYAML("base_img: golang\n")
+	assert.ErrorContains(t, DefaultConfig().Load(dir), "environment.yaml")
+
+	writeYAML("instructions: Run go test ./...\n")
+	config.SetupCommands = []string{"make deps"}
+	require.NoError(t, config.Save(dir))
+	yamlData, err := os.ReadFile(filepath.Join(dir, ".container-use", "environment.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "instructions: Run go test ./...\n", string(yamlData), "the YAML file is only a seed")
+	saved := DefaultConfig()
+	require.NoError(t, saved.Load(dir))
+	assert.Equal(t, []string{"make deps"}, saved.SetupCommands, "the saved configuration takes precedence")
+	assert.Equal(t, "Run go test ./...", saved.Instructions)
+}
+
 // Test helper functions
 func createInstructionsFile(t *testing.T, dir, content string) {
 	t.Helper()
@@ -128,3 +182,175 @@ func createConfigFile(t *testing.T, dir string, config *EnvironmentConfig) {
 	require.NoError(t, err)
 	require.NoError(t, os.WriteFile(filepath.Join(configDir, "environment.json"), data, 0644))
 }
+
+func TestServiceConfigs_StartOrder(t *testing.T) {
+	names := func(services []*ServiceConfig) []string {
+		result := []string{}
+		for _, service := range services {
+			result = append(result, service.Name)
+		}
+		return result
+	}
+
+	scenarios := []struct {
+		name        string
+		services    ServiceConfigs
+		expectOrder []string
+		expectError string
+	}{
+		{
+			name: "no_dependencies_keeps_declaration_order",
+			services: ServiceConfigs{
+				{Name: "redis"},
+				{Name: "postgres"},
+			},
+			expectOrder: []string{"redis", "postgres"},
+		},
+		{
+			name: "dependencies_first",
+			services: ServiceConfigs{
+				{Name: "api", DependsOn: []string{"postgres", "redis"}},
+				{Name: "worker", DependsOn: []string{"api"}},
+				{Name: "postgres"},
+				{Name: "redis"},
+			},
+			expectOrder: []string{"postgres", "redis", "api", "worker"},
+		},
+		{
+			name: "unknown_dependency",
+			services: ServiceConfigs{
+				{Name: "api", DependsOn: []string{"postgres"}},
+			},
+			expectError: "unknown service postgres",
+		},
+		{
+			name: "cycle",
+			services: ServiceConfigs{
+				{Name: "redis"},
+				{Name: "a", DependsOn: []string{"b"}},
+				{Name: "b", DependsOn: []string{"a"}},
+			},
+			expectError: "circular dependency between services a, b",
+		},
+		{
+			name: "duplicate_hostname",
+			services: ServiceConfigs{
+				{Name: "postgres", Aliases: []string{"db"}},
+				{Name: "mysql", Aliases: []string{"db"}},
+			},
+			expectError: `hostname "db" is used by both services postgres and mysql`,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			ordered, err := scenario.services.StartOrder()
+			if scenario.expectError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), scenario.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, scenario.expectOrder, names(ordered))
+		})
+	}
+}
+
+func TestServiceConfigs_Aliases(t *testing.T) {
+	services := ServiceConfigs{
+		{Name: "postgres", Aliases: []string{"db", "database"}},
+		{Name: "redis"},
+	}
+	assert.Equal(t, map[string]string{"db": "postgres", "database": "postgres"}, services.Aliases())
+	assert.Equal(t, []string{"postgres", "db", "database"}, services[0].Hostnames())
+}
+
+func TestEnvironmentConfig_Phases(t *testing.T) {
+	config := &EnvironmentConfig{
+		SetupCommands: []string{"echo legacy"},
+		SetupPhases: SetupPhases{
+			"project": {"make generate"},
+			"system":  {"apt-get update"},
+			"deps":    {},
+		},
+	}
+	phases, err := config.Phases()
+	require.NoError(t, err)
+	assert.Equal(t, []SetupPhase{
+		{Name: "setup", Commands: []string{"echo legacy"}},
+		{Name: "system", Commands: []string{"apt-get update"}},
+		{Name: "project", Commands: []string{"make generate"}},
+	}, phases)
+
+	config.SetupPhases["bogus"] = []string{"true"}
+	_, err = config.Phases()
+	assert.ErrorContains(t, err, `unknown setup phase "bogus"`)
+}
+
+func TestEnvironmentConfig_Commands(t *testing.T) {
+	config := &EnvironmentConfig{
+		SetupCommands:  []string{"echo legacy"},
+		SetupPhases:    SetupPhases{"project": {"make generate"}, "system": {"apt-get update"}},
+		DefaultCommand: "go run ./cmd/server",
+		Entrypoint:     []string{"/usr/bin/tini", "--"},
+		Services:       ServiceConfigs{{Name: "db", Image: "postgres:16"}, {Name: "worker", Command: "./worker --queue jobs"}},
+	}
+	assert.Equal(t, []string{
+		"echo legacy",
+		"apt-get update",
+		"make generate",
+		"go run ./cmd/server",
+		"'/usr/bin/tini' '--'",
+		"./worker --queue jobs",
+	}, config.Commands())
+}
+
+func TestEnvironmentConfig_ValidateCaches(t *testing.T) {
+	config := &EnvironmentConfig{Workdir: "/workdir", Caches: []string{"/go/pkg/mod", "/root/.cache/go-build/"}}
+	assert.NoError(t, config.validateCaches())
+
+	for _, dir := range []string{"go/pkg/mod", "/", "/workdir/node_modules", "/workdir", "/work/.."} {
+		config.Caches = []string{dir}
+		assert.Error(t, config.validateCaches(), dir)
+	}
+	config.Caches = []string{"/workdir-cache"}
+	assert.NoError(t, config.validateCaches(), "a sibling of the workdir doesn't overlap it")
+}
+
+func TestEnvironmentConfig_AppendedSetup(t *testing.T) {
+	old := &EnvironmentConfig{
+		BaseImage:     "python:3.12",
+		SetupCommands: []string{"apt-get update"},
+		SetupPhases:   SetupPhases{"deps": {"pip install -r requirements.txt"}},
+		Env:           KVList{"CI=true"},
+	}
+
+	config := old.Copy()
+	config.SetupPhases["deps"] = append(config.SetupPhases["deps"], "pip install pytest")
+	config.SetupPhases["project"] = []string{"make"}
+	config.Instructions = "Run pytest"
+	appended, ok := config.appendedSetup(old)
+	require.True(t, ok)
+	assert.Equal(t, []SetupPhase{
+		{Name: "deps", Commands: []string{"pip install pytest"}},
+		{Name: "project", Commands: []string{"make"}},
+	}, appended)
+
+	for name, change := range map[string]func(*EnvironmentConfig){
+		"no change": func(*EnvironmentConfig) {},
+		"base image": func(c *EnvironmentConfig) {
+			c.BaseImage = "python:3.13"
+			c.SetupCommands = append(c.SetupCommands, "true")
+		},
+		"env":                  func(c *EnvironmentConfig) { c.Env = nil; c.SetupCommands = append(c.SetupCommands, "true") },
+		"before a later phase": func(c *EnvironmentConfig) { c.SetupCommands = append(c.SetupCommands, "true") },
+		"new earlier phase":    func(c *EnvironmentConfig) { c.SetupPhases["system"] = []string{"true"} },
+		"removed command":      func(c *EnvironmentConfig) { c.SetupCommands = nil; c.SetupPhases["project"] = []string{"make"} },
+		"changed command":      func(c *EnvironmentConfig) { c.SetupPhases["deps"] = []string{"pip install .", "make"} },
+	} {
+		config := old.Copy()
+		change(config)
+		_, ok := config.appendedSetup(old)
+		assert.False(t, ok, name)
+	}
+}
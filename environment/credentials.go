@@ -0,0 +1,103 @@
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+const (
+	// sshAgentSocket is where the SSH agent of the host is reachable in environments.
+	sshAgentSocket = "/run/container-use/ssh-agent.sock"
+	// gitPasswordVariable is the prefix of the secret variables holding the passwords of GitCredentials.
+	gitPasswordVariable = "CONTAINER_USE_GIT_PASSWORD_"
+)
+
+// runGitCredentialFill runs `git credential fill` on the host with input. It is replaced in tests.
+var runGitCredentialFill = func(ctx context.Context, input string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(input)
+	// Never wait for a human to type a password
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, err
+}
+
+// hostGitCredential returns the username and password the git credential helper of the host has for
+// https://<host>.
+func hostGitCredential(ctx context.Context, host string) (string, string, error) {
+	out, err := runGitCredentialFill(ctx, "protocol=https\nhost="+host+"\n\n")
+	if err != nil {
+		return "", "", fmt.Errorf("no git credentials for %s on the host, store them with its credential helper (e.g. `gh auth setup-git`): %w", host, err)
+	}
+	var username, password string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "username":
+			username = value
+		case "password":
+			password = value
+		}
+	}
+	if password == "" {
+		return "", "", fmt.Errorf("no git credentials for %s on the host, store them with its credential helper (e.g. `gh auth setup-git`)", host)
+	}
+	return username, password, nil
+}
+
+// withHostCredentials forwards the SSH agent of the host and its git credentials to container, when the
+// configuration opts in, so that setup commands and agents can fetch private dependencies.
+func (env *Environment) withHostCredentials(ctx context.Context, container *dagger.Container) (*dagger.Container, error) {
+	if env.Config.ForwardSSHAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("forward_ssh_agent is set but no SSH agent runs on the host (SSH_AUTH_SOCK is not set), start one with `eval $(ssh-agent)` and add keys with `ssh-add`")
+		}
+		container = container.
+			WithUnixSocket(sshAgentSocket, env.dag.Host().UnixSocket(socket)).
+			WithEnvVariable("SSH_AUTH_SOCK", sshAgentSocket).
+			// The known hosts of the host aren't forwarded: trust hosts on first use, but not changed keys
+			WithEnvVariable("GIT_SSH_COMMAND", "ssh -o StrictHostKeyChecking=accept-new")
+	}
+
+	if len(env.Config.GitCredentials) == 0 {
+		return container, nil
+	}
+	container = container.WithEnvVariable("GIT_CONFIG_COUNT", strconv.Itoa(len(env.Config.GitCredentials)))
+	for i, host := range env.Config.GitCredentials {
+		username, password, err := hostGitCredential(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		variable := gitPasswordVariable + strconv.Itoa(i)
+		container = container.
+			WithSecretVariable(variable, env.dag.SetSecret("git-credentials-"+host, password)).
+			WithEnvVariable(fmt.Sprintf("GIT_CONFIG_KEY_%d", i), "credential.https://"+host+".helper").
+			WithEnvVariable(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i), gitCredentialHelper(username, variable))
+	}
+	return container, nil
+}
+
+// gitCredentialHelper returns a git credential helper answering with username and the password held by
+// the variable named passwordVariable.
+func gitCredentialHelper(username, passwordVariable string) string {
+	return fmt.Sprintf(`!f() { test "$1" = get || return 0; echo username=%s; echo "password=$%s"; }; f`, shellQuote(username), passwordVariable)
+}
@@ -0,0 +1,38 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostGitCredential(t *testing.T) {
+	original := runGitCredentialFill
+	t.Cleanup(func() { runGitCredentialFill = original })
+
+	var input string
+	runGitCredentialFill = func(_ context.Context, in string) ([]byte, error) {
+		input = in
+		return []byte("protocol=https\nhost=github.com\nusername=x-access-token\npassword=gho_s3cr3t\n"), nil
+	}
+	username, password, err := hostGitCredential(context.Background(), "github.com")
+	require.NoError(t, err)
+	assert.Equal(t, "protocol=https\nhost=github.com\n\n", input)
+	assert.Equal(t, "x-access-token", username)
+	assert.Equal(t, "gho_s3cr3t", password)
+
+	runGitCredentialFill = func(context.Context, string) ([]byte, error) {
+		return nil, errors.New("fatal: could not read Username for 'https://github.com': terminal prompts disabled")
+	}
+	_, _, err = hostGitCredential(context.Background(), "github.com")
+	assert.ErrorContains(t, err, "no git credentials for github.com")
+}
+
+func TestGitCredentialHelper(t *testing.T) {
+	assert.Equal(t,
+		`!f() { test "$1" = get || return 0; echo username='it'\''s me'; echo "password=$CONTAINER_USE_GIT_PASSWORD_0"; }; f`,
+		gitCredentialHelper("it's me", gitPasswordVariable+"0"))
+}
@@ -0,0 +1,657 @@
+package environment
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Ecosystems of the dependencies reported by Dependencies.
+const (
+	EcosystemGo    = "go"
+	EcosystemNpm   = "npm"
+	EcosystemPyPI  = "pypi"
+	EcosystemCargo = "cargo"
+)
+
+const defaultDependenciesMaxResults = 500
+
+// manifestFiles are the dependency manifests and lockfiles read by Dependencies.
+var manifestFiles = []string{
+	"go.mod",
+	"package.json", "package-lock.json", "yarn.lock", "pnpm-lock.yaml",
+	"pyproject.toml", "requirements.txt", "poetry.lock", "uv.lock",
+	"Cargo.toml", "Cargo.lock",
+}
+
+// Dependency is a module a project depends on.
+type Dependency struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+	// Version is the resolved version if a lockfile was found, otherwise the version constraint of the manifest.
+	Version string `json:"version"`
+	// Direct is set for dependencies declared by the project, as opposed to dependencies of dependencies.
+	Direct bool `json:"direct"`
+	Dev    bool `json:"dev,omitempty"`
+	// Manifest is the file the dependency was read from.
+	Manifest string `json:"manifest"`
+}
+
+// DependencyReport lists the dependencies of a project.
+type DependencyReport struct {
+	Manifests    []string     `json:"manifests"`
+	Dependencies []Dependency `json:"dependencies"`
+	// Truncated is the number of matching dependencies left out of the report.
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// DependencyQuery filters the dependencies returned by Dependencies.
+type DependencyQuery struct {
+	// Path is the directory to look for manifests in, recursively. Defaults to the workdir.
+	Path string
+	// Name keeps the dependencies whose name contains it, ignoring case.
+	Name       string
+	DirectOnly bool
+	// MaxResults caps the number of dependencies. Defaults to 500.
+	MaxResults int
+}
+
+// Dependencies extracts the dependencies of the Go, Node, Python and Rust projects found in the environment
+// from their manifests and lockfiles. Lockfiles are preferred since they resolve exact and transitive versions.
+func (env *Environment) Dependencies(ctx context.Context, query DependencyQuery) (*DependencyReport, error) {
+	dir := query.Path
+	if dir == "" {
+		dir = "."
+	}
+	container := env.container().WithWorkdir(dir)
+	stdout, err := container.WithExec(manifestFindArgs()).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find manifests: %w", err)
+	}
+
+	// Group the manifests by directory, since lockfiles are interpreted along with their manifest
+	projects := map[string]map[string]string{}
+	report := &DependencyReport{Manifests: []string{}, Dependencies: []Dependency{}}
+	for line := range strings.Lines(stdout) {
+		name := strings.TrimPrefix(strings.TrimSpace(line), "./")
+		if name == "" {
+			continue
+		}
+		contents, err := container.File(name).Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if projects[path.Dir(name)] == nil {
+			projects[path.Dir(name)] = map[string]string{}
+		}
+		projects[path.Dir(name)][path.Base(name)] = contents
+		report.Manifests = append(report.Manifests, name)
+	}
+	slices.Sort(report.Manifests)
+
+	for dir, files := range projects {
+		deps, err := parseProject(dir, files)
+		if err != nil {
+			return nil, err
+		}
+		report.Dependencies = append(report.Dependencies, deps...)
+	}
+
+	report.Dependencies = slices.DeleteFunc(report.Dependencies, func(dep Dependency) bool {
+		return (query.DirectOnly && !dep.Direct) ||
+			(query.Name != "" && !strings.Contains(strings.ToLower(dep.Name), strings.ToLower(query.Name)))
+	})
+	slices.SortFunc(report.Dependencies, func(a, b Dependency) int {
+		return cmp.Or(
+			cmp.Compare(a.Manifest, b.Manifest),
+			compareBool(b.Direct, a.Direct),
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Version, b.Version),
+		)
+	})
+
+	maxResults := query.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultDependenciesMaxResults
+	}
+	if len(report.Dependencies) > maxResults {
+		report.Truncated = len(report.Dependencies) - maxResults
+		report.Dependencies = report.Dependencies[:maxResults]
+	}
+	return report, nil
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// manifestFindArgs lists the manifest files, skipping dependency directories.
+func manifestFindArgs() []string {
+	args := []string{"find", ".", "-type", "d", "("}
+	for _, name := range append(slices.Clone(statsSkippedDirs), "vendor", "target") {
+		args = append(args, "-name", name, "-o")
+	}
+	args = append(args[:len(args)-1], ")", "-prune", "-o", "-type", "f", "(")
+	for _, name := range manifestFiles {
+		args = append(args, "-name", name, "-o")
+	}
+	return append(args[:len(args)-1], ")", "-print")
+}
+
+// parseProject returns the dependencies declared by the manifests of a directory, given their contents by file name.
+func parseProject(dir string, files map[string]string) ([]Dependency, error) {
+	type parser struct {
+		file  string
+		parse func() ([]Dependency, error)
+	}
+	parsers := []parser{}
+
+	if contents, ok := files["go.mod"]; ok {
+		parsers = append(parsers, parser{"go.mod", func() ([]Dependency, error) { return parseGoMod(contents), nil }})
+	}
+
+	if pkg, ok := files["package.json"]; ok {
+		switch {
+		case files["package-lock.json"] != "":
+			parsers = append(parsers, parser{"package-lock.json", func() ([]Dependency, error) { return parsePackageLock(files["package-lock.json"], pkg) }})
+		case files["pnpm-lock.yaml"] != "":
+			parsers = append(parsers, parser{"pnpm-lock.yaml", func() ([]Dependency, error) { return parsePnpmLock(files["pnpm-lock.yaml"], pkg) }})
+		case files["yarn.lock"] != "":
+			parsers = append(parsers, parser{"yarn.lock", func() ([]Dependency, error) { return parseYarnLock(files["yarn.lock"], pkg) }})
+		default:
+			parsers = append(parsers, parser{"package.json", func() ([]Dependency, error) { return parsePackageJSON(pkg) }})
+		}
+	}
+
+	pyproject := files["pyproject.toml"]
+	switch {
+	case files["poetry.lock"] != "":
+		parsers = append(parsers, parser{"poetry.lock", func() ([]Dependency, error) { return parsePythonLock(files["poetry.lock"], pyproject) }})
+	case files["uv.lock"] != "":
+		parsers = append(parsers, parser{"uv.lock", func() ([]Dependency, error) { return parsePythonLock(files["uv.lock"], pyproject) }})
+	case pyproject != "":
+		parsers = append(parsers, parser{"pyproject.toml", func() ([]Dependency, error) { return parsePyproject(pyproject) }})
+	}
+	if contents, ok := files["requirements.txt"]; ok {
+		parsers = append(parsers, parser{"requirements.txt", func() ([]Dependency, error) { return parseRequirements(contents), nil }})
+	}
+
+	if cargo, ok := files["Cargo.toml"]; ok {
+		if lock := files["Cargo.lock"]; lock != "" {
+			parsers = append(parsers, parser{"Cargo.lock", func() ([]Dependency, error) { return parseCargoLock(lock, cargo) }})
+		} else {
+			parsers = append(parsers, parser{"Cargo.toml", func() ([]Dependency, error) { return parseCargoToml(cargo) }})
+		}
+	}
+
+	deps := []Dependency{}
+	for _, p := range parsers {
+		manifest := path.Join(dir, p.file)
+		parsed, err := p.parse()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", manifest, err)
+		}
+		for _, dep := range parsed {
+			dep.Manifest = manifest
+			deps = append(deps, dep)
+		}
+	}
+	return deps, nil
+}
+
+// parseGoMod returns the requirements of a go.mod file. Requirements marked `// indirect` are transitive.
+func parseGoMod(contents string) []Dependency {
+	deps := []Dependency{}
+	inBlock := false
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "require (":
+			inBlock = true
+			continue
+		case inBlock && line == ")":
+			inBlock = false
+			continue
+		case strings.HasPrefix(line, "require "):
+			line = strings.TrimSpace(strings.TrimPrefix(line, "require "))
+		case !inBlock:
+			continue
+		}
+
+		requirement, comment, _ := strings.Cut(line, "//")
+		fields := strings.Fields(requirement)
+		if len(fields) != 2 {
+			continue
+		}
+		deps = append(deps, Dependency{
+			Ecosystem: EcosystemGo,
+			Name:      fields[0],
+			Version:   fields[1],
+			Direct:    !strings.Contains(comment, "indirect"),
+		})
+	}
+	return deps
+}
+
+type packageJSON struct {
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+}
+
+// direct returns the dependencies declared by package.json, and whether they are dev dependencies.
+func (pkg *packageJSON) direct() map[string]bool {
+	direct := map[string]bool{}
+	for _, deps := range []map[string]string{pkg.Dependencies, pkg.OptionalDependencies, pkg.PeerDependencies} {
+		for name := range deps {
+			direct[name] = false
+		}
+	}
+	for name := range pkg.DevDependencies {
+		if _, ok := direct[name]; !ok {
+			direct[name] = true
+		}
+	}
+	return direct
+}
+
+func readPackageJSON(contents string) (*packageJSON, error) {
+	pkg := &packageJSON{}
+	if err := json.Unmarshal([]byte(contents), pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// parsePackageJSON returns the dependencies declared by package.json, with their version ranges.
+func parsePackageJSON(contents string) ([]Dependency, error) {
+	pkg, err := readPackageJSON(contents)
+	if err != nil {
+		return nil, err
+	}
+	deps := []Dependency{}
+	for name, dev := range pkg.direct() {
+		version := cmp.Or(pkg.Dependencies[name], pkg.OptionalDependencies[name], pkg.PeerDependencies[name], pkg.DevDependencies[name])
+		deps = append(deps, Dependency{Ecosystem: EcosystemNpm, Name: name, Version: version, Direct: true, Dev: dev})
+	}
+	return deps, nil
+}
+
+// parsePackageLock returns the packages of a package-lock.json file (lockfile version 2 and later, or 1).
+func parsePackageLock(contents, pkgContents string) ([]Dependency, error) {
+	pkg, err := readPackageJSON(pkgContents)
+	if err != nil {
+		return nil, err
+	}
+	direct := pkg.direct()
+
+	type lockPackage struct {
+		Version string `json:"version"`
+		Dev     bool   `json:"dev"`
+		Link    bool   `json:"link"`
+	}
+	lock := struct {
+		Packages     map[string]lockPackage `json:"packages"`
+		Dependencies map[string]lockPackage `json:"dependencies"`
+	}{}
+	if err := json.Unmarshal([]byte(contents), &lock); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	if len(lock.Packages) > 0 {
+		for key, p := range lock.Packages {
+			// Keys are install paths, e.g. node_modules/a/node_modules/@scope/b
+			i := strings.LastIndex(key, "node_modules/")
+			if i < 0 || p.Link {
+				continue
+			}
+			name := key[i+len("node_modules/"):]
+			_, isDirect := direct[name]
+			isDirect = isDirect && key == "node_modules/"+name
+			deps = append(deps, Dependency{Ecosystem: EcosystemNpm, Name: name, Version: p.Version, Direct: isDirect, Dev: p.Dev})
+		}
+		return deps, nil
+	}
+	for name, p := range lock.Dependencies {
+		_, isDirect := direct[name]
+		deps = append(deps, Dependency{Ecosystem: EcosystemNpm, Name: name, Version: p.Version, Direct: isDirect, Dev: p.Dev})
+	}
+	return deps, nil
+}
+
+// parseYarnLock returns the packages of a yarn.lock file, in the classic or the berry format.
+func parseYarnLock(contents, pkgContents string) ([]Dependency, error) {
+	pkg, err := readPackageJSON(pkgContents)
+	if err != nil {
+		return nil, err
+	}
+	direct := pkg.direct()
+
+	deps := []Dependency{}
+	seen := map[string]bool{}
+	var names []string
+	for line := range strings.Lines(contents) {
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, ":") {
+			// Entry header, e.g. `"@babel/core@^7.0.0", "@babel/core@^7.1.0":` or `"lodash@npm:^4.17.21":`
+			names = names[:0]
+			for spec := range strings.SplitSeq(strings.TrimSuffix(line, ":"), ",") {
+				spec = strings.Trim(strings.TrimSpace(spec), `"`)
+				if at := strings.LastIndex(spec, "@"); at > 0 {
+					names = append(names, spec[:at])
+				}
+			}
+			continue
+		}
+		// The version of the entry, e.g. `  version "4.17.21"` or `  version: 4.17.21`
+		rest, ok := strings.CutPrefix(line, "  version")
+		if len(names) == 0 || !ok || (rest[0] != ' ' && rest[0] != ':') {
+			continue
+		}
+		version := strings.Trim(strings.TrimSpace(strings.TrimPrefix(rest, ":")), `"`)
+		for _, name := range names {
+			if name == "" || seen[name+"@"+version] || version == "0.0.0-use.local" {
+				continue
+			}
+			seen[name+"@"+version] = true
+			dev, isDirect := direct[name]
+			deps = append(deps, Dependency{Ecosystem: EcosystemNpm, Name: name, Version: version, Direct: isDirect, Dev: isDirect && dev})
+		}
+		names = names[:0]
+	}
+	return deps, nil
+}
+
+// pnpmPackageKey matches the keys of the packages of a pnpm lockfile: /name@version, /name/version or name@version,
+// optionally followed by peer dependencies in parentheses or after an underscore.
+var pnpmPackageKey = regexp.MustCompile(`^/?((?:@[^/]+/)?[^/@]+)[@/]([^(_]+)`)
+
+// parsePnpmLock returns the packages of a pnpm-lock.yaml file.
+func parsePnpmLock(contents, pkgContents string) ([]Dependency, error) {
+	pkg, err := readPackageJSON(pkgContents)
+	if err != nil {
+		return nil, err
+	}
+	direct := pkg.direct()
+
+	lock := struct {
+		Packages map[string]struct {
+			Dev bool `yaml:"dev"`
+		} `yaml:"packages"`
+	}{}
+	if err := yaml.Unmarshal([]byte(contents), &lock); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	for key, p := range lock.Packages {
+		m := pnpmPackageKey.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		dev, isDirect := direct[m[1]]
+		deps = append(deps, Dependency{Ecosystem: EcosystemNpm, Name: m[1], Version: m[2], Direct: isDirect, Dev: p.Dev || (isDirect && dev)})
+	}
+	return deps, nil
+}
+
+// pythonRequirement matches the name and version specifier of a PEP 508 requirement, e.g. `requests[socks]>=2.0; python_version>"3.8"`.
+var pythonRequirement = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9._-]*)\s*(?:\[[^\]]*\])?\s*(?:\(?\s*([<>=!~][^;()]*)\)?)?`)
+
+var pythonNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// normalizePythonName normalizes a Python distribution name as specified by PEP 503.
+func normalizePythonName(name string) string {
+	return strings.ToLower(pythonNameSeparators.ReplaceAllString(name, "-"))
+}
+
+func parsePythonRequirement(requirement string) (string, string, bool) {
+	m := pythonRequirement.FindStringSubmatch(strings.TrimSpace(requirement))
+	if m == nil {
+		return "", "", false
+	}
+	version := strings.TrimSpace(m[2])
+	return normalizePythonName(m[1]), strings.TrimPrefix(version, "=="), true
+}
+
+// parseRequirements returns the requirements of a requirements.txt file.
+func parseRequirements(contents string) []Dependency {
+	deps := []Dependency{}
+	for line := range strings.Lines(contents) {
+		line, _, _ = strings.Cut(line, "#")
+		line = strings.TrimSpace(line)
+		// Skip options (-r other.txt, --index-url ...) and direct references (git+https://...)
+		if line == "" || strings.HasPrefix(line, "-") || strings.Contains(line, "://") {
+			continue
+		}
+		if name, version, ok := parsePythonRequirement(line); ok {
+			deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: name, Version: version, Direct: true})
+		}
+	}
+	return deps
+}
+
+type pyproject struct {
+	Project struct {
+		Dependencies         []string            `toml:"dependencies"`
+		OptionalDependencies map[string][]string `toml:"optional-dependencies"`
+	} `toml:"project"`
+	DependencyGroups map[string][]any `toml:"dependency-groups"`
+	Tool             struct {
+		Poetry struct {
+			Dependencies    map[string]any `toml:"dependencies"`
+			DevDependencies map[string]any `toml:"dev-dependencies"`
+			Group           map[string]struct {
+				Dependencies map[string]any `toml:"dependencies"`
+			} `toml:"group"`
+		} `toml:"poetry"`
+	} `toml:"tool"`
+}
+
+// parsePyproject returns the dependencies declared by pyproject.toml, in the PEP 621 or the Poetry format,
+// with their version specifiers.
+func parsePyproject(contents string) ([]Dependency, error) {
+	project := &pyproject{}
+	if err := toml.Unmarshal([]byte(contents), project); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	addRequirement := func(requirement string, dev bool) {
+		if name, version, ok := parsePythonRequirement(requirement); ok {
+			deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: name, Version: version, Direct: true, Dev: dev})
+		}
+	}
+	addPoetry := func(dependencies map[string]any, dev bool) {
+		for name, spec := range dependencies {
+			if name == "python" {
+				continue
+			}
+			version := ""
+			switch spec := spec.(type) {
+			case string:
+				version = spec
+			case map[string]any:
+				version, _ = spec["version"].(string)
+			}
+			deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: normalizePythonName(name), Version: version, Direct: true, Dev: dev})
+		}
+	}
+
+	for _, requirement := range project.Project.Dependencies {
+		addRequirement(requirement, false)
+	}
+	for _, requirements := range project.Project.OptionalDependencies {
+		for _, requirement := range requirements {
+			addRequirement(requirement, false)
+		}
+	}
+	for _, requirements := range project.DependencyGroups {
+		for _, requirement := range requirements {
+			// Groups can also include other groups as {include-group = "name"}
+			if requirement, ok := requirement.(string); ok {
+				addRequirement(requirement, true)
+			}
+		}
+	}
+	addPoetry(project.Tool.Poetry.Dependencies, false)
+	addPoetry(project.Tool.Poetry.DevDependencies, true)
+	for name, group := range project.Tool.Poetry.Group {
+		addPoetry(group.Dependencies, name != "main")
+	}
+
+	// The same dependency can be declared in several places: keep the first declaration
+	seen := map[string]bool{}
+	return slices.DeleteFunc(deps, func(dep Dependency) bool {
+		if seen[dep.Name] {
+			return true
+		}
+		seen[dep.Name] = true
+		return false
+	}), nil
+}
+
+// parsePythonLock returns the packages of a poetry.lock or uv.lock file. Direct dependencies are those
+// declared by pyproject.toml.
+func parsePythonLock(contents, pyprojectContents string) ([]Dependency, error) {
+	direct := map[string]Dependency{}
+	if pyprojectContents != "" {
+		declared, err := parsePyproject(pyprojectContents)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range declared {
+			direct[dep.Name] = dep
+		}
+	}
+
+	lock := struct {
+		Package []struct {
+			Name    string `toml:"name"`
+			Version string `toml:"version"`
+			Source  struct {
+				Editable string `toml:"editable"`
+				Virtual  string `toml:"virtual"`
+			} `toml:"source"`
+		} `toml:"package"`
+	}{}
+	if err := toml.Unmarshal([]byte(contents), &lock); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	for _, p := range lock.Package {
+		// uv.lock lists the project itself
+		if p.Source.Editable == "." || p.Source.Virtual == "." {
+			continue
+		}
+		name := normalizePythonName(p.Name)
+		declared, isDirect := direct[name]
+		deps = append(deps, Dependency{Ecosystem: EcosystemPyPI, Name: name, Version: p.Version, Direct: isDirect, Dev: declared.Dev})
+	}
+	return deps, nil
+}
+
+type cargoToml struct {
+	Dependencies      map[string]any `toml:"dependencies"`
+	DevDependencies   map[string]any `toml:"dev-dependencies"`
+	BuildDependencies map[string]any `toml:"build-dependencies"`
+	Workspace         struct {
+		Dependencies map[string]any `toml:"dependencies"`
+	} `toml:"workspace"`
+}
+
+// parseCargoToml returns the dependencies declared by Cargo.toml, with their version requirements.
+func parseCargoToml(contents string) ([]Dependency, error) {
+	manifest := &cargoToml{}
+	if err := toml.Unmarshal([]byte(contents), manifest); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	seen := map[string]bool{}
+	for _, table := range []struct {
+		deps map[string]any
+		dev  bool
+	}{
+		{manifest.Dependencies, false},
+		{manifest.BuildDependencies, false},
+		{manifest.Workspace.Dependencies, false},
+		{manifest.DevDependencies, true},
+	} {
+		for name, spec := range table.deps {
+			version := ""
+			switch spec := spec.(type) {
+			case string:
+				version = spec
+			case map[string]any:
+				version, _ = spec["version"].(string)
+				// Renamed dependencies, e.g. `foo = { package = "bar" }`
+				if pkg, ok := spec["package"].(string); ok {
+					name = pkg
+				}
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			deps = append(deps, Dependency{Ecosystem: EcosystemCargo, Name: name, Version: version, Direct: true, Dev: table.dev})
+		}
+	}
+	return deps, nil
+}
+
+// parseCargoLock returns the crates of a Cargo.lock file. Crates of the workspace itself are skipped.
+func parseCargoLock(contents, cargoContents string) ([]Dependency, error) {
+	declared, err := parseCargoToml(cargoContents)
+	if err != nil {
+		return nil, err
+	}
+	direct := map[string]Dependency{}
+	for _, dep := range declared {
+		direct[dep.Name] = dep
+	}
+
+	lock := struct {
+		Package []struct {
+			Name    string `toml:"name"`
+			Version string `toml:"version"`
+			Source  string `toml:"source"`
+		} `toml:"package"`
+	}{}
+	if err := toml.Unmarshal([]byte(contents), &lock); err != nil {
+		return nil, err
+	}
+
+	deps := []Dependency{}
+	for _, p := range lock.Package {
+		if p.Source == "" {
+			continue
+		}
+		dep, isDirect := direct[p.Name]
+		deps = append(deps, Dependency{Ecosystem: EcosystemCargo, Name: p.Name, Version: p.Version, Direct: isDirect, Dev: dep.Dev})
+	}
+	return deps, nil
+}
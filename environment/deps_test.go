@@ -0,0 +1,290 @@
+package environment
+
+import (
+	"cmp"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedDeps sorts dependencies by name and version to compare them regardless of map iteration order.
+func sortedDeps(deps []Dependency) []Dependency {
+	slices.SortFunc(deps, func(a, b Dependency) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Version, b.Version))
+	})
+	return deps
+}
+
+func TestParseGoMod(t *testing.T) {
+	deps := parseGoMod(`module example.com/app
+
+go 1.24
+
+require github.com/spf13/cobra v1.9.1
+
+require (
+	dagger.io/dagger v0.18.12
+	github.com/google/uuid v1.6.0 // indirect
+)
+`)
+	assert.Equal(t, []Dependency{
+		{Ecosystem: EcosystemGo, Name: "github.com/spf13/cobra", Version: "v1.9.1", Direct: true},
+		{Ecosystem: EcosystemGo, Name: "dagger.io/dagger", Version: "v0.18.12", Direct: true},
+		{Ecosystem: EcosystemGo, Name: "github.com/google/uuid", Version: "v1.6.0", Direct: false},
+	}, deps)
+}
+
+const testPackageJSON = `{
+  "name": "app",
+  "dependencies": {"react": "^18.2.0", "@scope/ui": "1.0.0"},
+  "devDependencies": {"typescript": "~5.4.0"}
+}`
+
+func TestParseNode(t *testing.T) {
+	t.Run("package.json", func(t *testing.T) {
+		deps, err := parsePackageJSON(testPackageJSON)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemNpm, Name: "@scope/ui", Version: "1.0.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "^18.2.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "typescript", Version: "~5.4.0", Direct: true, Dev: true},
+		}, sortedDeps(deps))
+	})
+
+	t.Run("package-lock.json", func(t *testing.T) {
+		deps, err := parsePackageLock(`{
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "app"},
+    "node_modules/react": {"version": "18.2.0"},
+    "node_modules/@scope/ui": {"version": "1.0.0"},
+    "node_modules/@scope/ui/node_modules/react": {"version": "17.0.2"},
+    "node_modules/loose-envify": {"version": "1.4.0"},
+    "node_modules/typescript": {"version": "5.4.5", "dev": true},
+    "node_modules/local": {"resolved": "packages/local", "link": true}
+  }
+}`, testPackageJSON)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemNpm, Name: "@scope/ui", Version: "1.0.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "loose-envify", Version: "1.4.0"},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "17.0.2"},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "18.2.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "typescript", Version: "5.4.5", Direct: true, Dev: true},
+		}, sortedDeps(deps))
+	})
+
+	t.Run("yarn.lock classic", func(t *testing.T) {
+		deps, err := parseYarnLock(`# yarn lockfile v1
+
+"@scope/ui@1.0.0":
+  version "1.0.0"
+  dependencies:
+    react "^17.0.0"
+
+react@^17.0.0:
+  version "17.0.2"
+
+react@^18.2.0:
+  version "18.2.0"
+  resolved "https://registry.yarnpkg.com/react/-/react-18.2.0.tgz"
+
+typescript@~5.4.0:
+  version "5.4.5"
+`, testPackageJSON)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemNpm, Name: "@scope/ui", Version: "1.0.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "17.0.2", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "18.2.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "typescript", Version: "5.4.5", Direct: true, Dev: true},
+		}, sortedDeps(deps))
+	})
+
+	t.Run("yarn.lock berry", func(t *testing.T) {
+		deps, err := parseYarnLock(`__metadata:
+  version: 8
+  cacheKey: 10
+
+"app@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "app@workspace:."
+
+"react@npm:^18.2.0":
+  version: 18.2.0
+  resolution: "react@npm:18.2.0"
+`, testPackageJSON)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "18.2.0", Direct: true},
+		}, deps)
+	})
+
+	t.Run("pnpm-lock.yaml", func(t *testing.T) {
+		deps, err := parsePnpmLock(`lockfileVersion: '9.0'
+packages:
+  react@18.2.0:
+    resolution: {integrity: sha512-abc}
+  '@scope/ui@1.0.0(react@18.2.0)':
+    resolution: {integrity: sha512-def}
+  /typescript/5.4.5:
+    dev: true
+`, testPackageJSON)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemNpm, Name: "@scope/ui", Version: "1.0.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "react", Version: "18.2.0", Direct: true},
+			{Ecosystem: EcosystemNpm, Name: "typescript", Version: "5.4.5", Direct: true, Dev: true},
+		}, sortedDeps(deps))
+	})
+}
+
+func TestParsePython(t *testing.T) {
+	t.Run("requirements.txt", func(t *testing.T) {
+		deps := parseRequirements(`# Pinned
+Django==5.0.6
+requests[socks] >= 2.31 ; python_version > "3.8"
+-r dev.txt
+git+https://github.com/org/repo.git#egg=repo
+zope.interface
+`)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "django", Version: "5.0.6", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "requests", Version: ">= 2.31", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "zope-interface", Direct: true},
+		}, deps)
+	})
+
+	pyproject := `[project]
+name = "app"
+dependencies = ["fastapi>=0.110", "Pydantic_Core"]
+
+[dependency-groups]
+dev = ["pytest==8.2.0", {include-group = "lint"}]
+`
+
+	t.Run("pyproject.toml", func(t *testing.T) {
+		deps, err := parsePyproject(pyproject)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "fastapi", Version: ">=0.110", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "pydantic-core", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "pytest", Version: "8.2.0", Direct: true, Dev: true},
+		}, sortedDeps(deps))
+	})
+
+	t.Run("poetry", func(t *testing.T) {
+		deps, err := parsePyproject(`[tool.poetry.dependencies]
+python = "^3.11"
+flask = "^3.0"
+sqlalchemy = {version = "^2.0", extras = ["asyncio"]}
+
+[tool.poetry.group.dev.dependencies]
+black = "^24.0"
+`)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "black", Version: "^24.0", Direct: true, Dev: true},
+			{Ecosystem: EcosystemPyPI, Name: "flask", Version: "^3.0", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "sqlalchemy", Version: "^2.0", Direct: true},
+		}, sortedDeps(deps))
+	})
+
+	t.Run("uv.lock", func(t *testing.T) {
+		deps, err := parsePythonLock(`version = 1
+
+[[package]]
+name = "app"
+version = "0.1.0"
+source = { editable = "." }
+
+[[package]]
+name = "fastapi"
+version = "0.111.0"
+source = { registry = "https://pypi.org/simple" }
+
+[[package]]
+name = "pydantic-core"
+version = "2.18.2"
+source = { registry = "https://pypi.org/simple" }
+
+[[package]]
+name = "starlette"
+version = "0.37.2"
+source = { registry = "https://pypi.org/simple" }
+
+[[package]]
+name = "pytest"
+version = "8.2.0"
+source = { registry = "https://pypi.org/simple" }
+`, pyproject)
+		require.NoError(t, err)
+		assert.Equal(t, []Dependency{
+			{Ecosystem: EcosystemPyPI, Name: "fastapi", Version: "0.111.0", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "pydantic-core", Version: "2.18.2", Direct: true},
+			{Ecosystem: EcosystemPyPI, Name: "pytest", Version: "8.2.0", Direct: true, Dev: true},
+			{Ecosystem: EcosystemPyPI, Name: "starlette", Version: "0.37.2"},
+		}, sortedDeps(deps))
+	})
+}
+
+func TestParseCargo(t *testing.T) {
+	cargo := `[package]
+name = "app"
+version = "0.1.0"
+
+[dependencies]
+serde = { version = "1.0", features = ["derive"] }
+tokio = "1"
+json = { package = "serde_json", version = "1.0" }
+
+[dev-dependencies]
+insta = "1.39"
+`
+	deps, err := parseCargoToml(cargo)
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{
+		{Ecosystem: EcosystemCargo, Name: "insta", Version: "1.39", Direct: true, Dev: true},
+		{Ecosystem: EcosystemCargo, Name: "serde", Version: "1.0", Direct: true},
+		{Ecosystem: EcosystemCargo, Name: "serde_json", Version: "1.0", Direct: true},
+		{Ecosystem: EcosystemCargo, Name: "tokio", Version: "1", Direct: true},
+	}, sortedDeps(deps))
+
+	deps, err = parseCargoLock(`version = 3
+
+[[package]]
+name = "app"
+version = "0.1.0"
+
+[[package]]
+name = "serde"
+version = "1.0.203"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+
+[[package]]
+name = "serde_derive"
+version = "1.0.203"
+source = "registry+https://github.com/rust-lang/crates.io-index"
+`, cargo)
+	require.NoError(t, err)
+	assert.Equal(t, []Dependency{
+		{Ecosystem: EcosystemCargo, Name: "serde", Version: "1.0.203", Direct: true},
+		{Ecosystem: EcosystemCargo, Name: "serde_derive", Version: "1.0.203"},
+	}, deps)
+}
+
+func TestParseProject(t *testing.T) {
+	deps, err := parseProject("web", map[string]string{
+		"package.json":   testPackageJSON,
+		"pyproject.toml": "invalid = [",
+	})
+	assert.ErrorContains(t, err, "web/pyproject.toml")
+	assert.Nil(t, deps)
+
+	deps, err = parseProject(".", map[string]string{"go.mod": "module app\n\nrequire golang.org/x/mod v0.25.0\n"})
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	assert.Equal(t, "go.mod", deps[0].Manifest)
+}
@@ -0,0 +1,103 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"dagger.io/dagger"
+)
+
+const (
+	dockerDaemonImage = "docker:28-dind"
+	dockerCLIImage    = "docker:28-cli"
+	// dockerHostname is the name the Docker daemon of an environment is reachable at, as a service.
+	dockerHostname = "docker"
+	dockerPort     = 2375
+	// dockerPluginsDir is where the Docker CLI looks for plugins such as compose and buildx.
+	dockerPluginsDir = "/usr/local/libexec/docker/cli-plugins"
+)
+
+// validateDocker returns an error if the Docker daemon would clash with a service of the configuration, or
+// with its network policy: the daemon needs root capabilities, so it can't be kept behind a firewall.
+func (config *EnvironmentConfig) validateDocker() error {
+	if !config.Docker {
+		return nil
+	}
+	if config.Network.Enforced() {
+		return fmt.Errorf("the Docker daemon can't run under the %s network mode: it would reach the network regardless of the policy", config.Network.Mode)
+	}
+	for _, service := range config.Services {
+		if slices.Contains(service.Hostnames(), dockerHostname) {
+			return fmt.Errorf("service %s is reachable as %q, which is reserved for the Docker daemon of the environment", service.Name, dockerHostname)
+		}
+	}
+	return nil
+}
+
+// startDocker starts a Docker daemon for the environment, as a service named docker. The daemon runs
+// with root capabilities in its own container, isolated from the host: images and containers it holds
+// are lost when the environment is rebuilt.
+func (env *Environment) startDocker(ctx context.Context) (*Service, error) {
+	if err := env.Config.validateDocker(); err != nil {
+		return nil, err
+	}
+	container, err := env.withRegistryAuth(ctx, env.dag.Container())
+	if err != nil {
+		return nil, err
+	}
+	container = container.
+		From(dockerDaemonImage).
+		// Only reachable from the environment, TLS would only get in the way
+		WithEnvVariable("DOCKER_TLS_CERTDIR", "").
+		WithExposedPort(dockerPort, dagger.ContainerWithExposedPortOpts{
+			Protocol:    dagger.NetworkProtocolTcp,
+			Description: "Docker daemon",
+		})
+
+	startCtx, cancel := context.WithTimeout(ctx, serviceStartTimeout)
+	defer cancel()
+	svc, err := container.AsService(dagger.ContainerAsServiceOpts{
+		Args:                     []string{"dockerd", "--host=unix:///var/run/docker.sock", fmt.Sprintf("--host=tcp://0.0.0.0:%d", dockerPort), "--tls=false"},
+		UseEntrypoint:            true,
+		InsecureRootCapabilities: true,
+	}).Start(startCtx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("Docker daemon failed to start within %s timeout", serviceStartTimeout)
+		}
+		return nil, fmt.Errorf("failed to start the Docker daemon: %w", err)
+	}
+
+	docker := dockerService()
+	docker.svc = svc
+	return docker, nil
+}
+
+// dockerService describes the Docker daemon of an environment.
+func dockerService() *Service {
+	return &Service{
+		Config: &ServiceConfig{
+			Name:         dockerHostname,
+			Image:        dockerDaemonImage,
+			ExposedPorts: []int{dockerPort},
+		},
+		Endpoints: EndpointMappings{
+			dockerPort: {EnvironmentInternal: fmt.Sprintf("tcp://%s:%d", dockerHostname, dockerPort)},
+		},
+	}
+}
+
+// withDockerCLI installs the Docker CLI, with the compose and buildx plugins, in container and points it
+// to the Docker daemon of the environment. The binaries are static, so they run on any distribution.
+func (env *Environment) withDockerCLI(container *dagger.Container) *dagger.Container {
+	if !env.Config.Docker {
+		return container
+	}
+	cli := NewContainer(env.dag, env.Config.Platform).From(dockerCLIImage)
+	return container.
+		WithFile("/usr/local/bin/docker", cli.File("/usr/local/bin/docker")).
+		WithDirectory(dockerPluginsDir, cli.Directory(dockerPluginsDir)).
+		WithEnvVariable("DOCKER_HOST", fmt.Sprintf("tcp://%s:%d", dockerHostname, dockerPort))
+}
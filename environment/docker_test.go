@@ -0,0 +1,26 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvironmentConfig_ValidateDocker(t *testing.T) {
+	services := ServiceConfigs{
+		{Name: "db", Image: "postgres:16"},
+		{Name: "engine", Image: "registry:2", Aliases: []string{"docker"}},
+	}
+
+	config := &EnvironmentConfig{Services: services}
+	assert.NoError(t, config.validateDocker(), "the name is only reserved when Docker is enabled")
+
+	config.Docker = true
+	assert.ErrorContains(t, config.validateDocker(), `service engine is reachable as "docker"`)
+
+	config.Services = services[:1]
+	assert.NoError(t, config.validateDocker())
+
+	config.Network = NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"proxy.golang.org"}}
+	assert.ErrorContains(t, config.validateDocker(), "allowlist network mode")
+}
@@ -0,0 +1,236 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// EditHunk replaces Search with Replace in a file.
+// Search must match exactly once unless ReplaceAll is set.
+type EditHunk struct {
+	Search     string `json:"search"`
+	Replace    string `json:"replace"`
+	ReplaceAll bool   `json:"replace_all,omitempty"`
+}
+
+// EditError is returned when one or more hunks of an edit could not be applied.
+// The file is left untouched in that case.
+type EditError struct {
+	Failures []string
+}
+
+func (e *EditError) Error() string {
+	return fmt.Sprintf("%d hunk(s) failed to apply, the file was not modified:\n%s", len(e.Failures), strings.Join(e.Failures, "\n"))
+}
+
+// FileEdit applies search/replace hunks to targetFile.
+// Either all hunks apply or the file is left untouched and an *EditError lists the failing hunks.
+func (env *Environment) FileEdit(ctx context.Context, explanation, targetFile string, hunks []EditHunk) error {
+	return env.editFile(ctx, targetFile, func(contents string) (string, []string) {
+		return applySearchReplace(contents, hunks)
+	})
+}
+
+// FilePatch applies a unified diff to targetFile.
+// Either all hunks apply or the file is left untouched and an *EditError lists the failing hunks.
+func (env *Environment) FilePatch(ctx context.Context, explanation, targetFile, diff string) error {
+	return env.editFile(ctx, targetFile, func(contents string) (string, []string) {
+		return applyUnifiedDiff(contents, diff)
+	})
+}
+
+func (env *Environment) editFile(ctx context.Context, targetFile string, edit func(string) (string, []string)) error {
+	contents, err := env.container().File(targetFile).Contents(ctx)
+	if err != nil {
+		return err
+	}
+
+	edited, failures := edit(contents)
+	if len(failures) > 0 {
+		return &EditError{Failures: failures}
+	}
+
+	// Keep the mode of the file, e.g. so that scripts stay executable
+	permissions, err := env.filePermissions(ctx, targetFile)
+	if err != nil {
+		return err
+	}
+	if err := env.apply(ctx, env.container().WithNewFile(targetFile, edited, dagger.ContainerWithNewFileOpts{
+		Owner:       env.Config.User,
+		Permissions: permissions,
+	})); err != nil {
+		return fmt.Errorf("failed applying file edit, skipping git propagation: %w", err)
+	}
+	env.Notes.Add("Edit %s", targetFile)
+	return nil
+}
+
+// filePermissions returns the permission bits of file in the container.
+func (env *Environment) filePermissions(ctx context.Context, file string) (int, error) {
+	stdout, err := env.container().WithExec([]string{"stat", "-c", "%a", "--", file}).Stdout(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", file, err)
+	}
+	permissions, err := strconv.ParseInt(strings.TrimSpace(stdout), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected permissions of %s: %q", file, stdout)
+	}
+	return int(permissions), nil
+}
+
+func applySearchReplace(contents string, hunks []EditHunk) (string, []string) {
+	failures := []string{}
+	for i, hunk := range hunks {
+		if hunk.Search == "" {
+			failures = append(failures, fmt.Sprintf("hunk %d: search text is empty", i+1))
+			continue
+		}
+		count := strings.Count(contents, hunk.Search)
+		switch {
+		case count == 0:
+			failures = append(failures, fmt.Sprintf("hunk %d: search text not found", i+1))
+		case count > 1 && !hunk.ReplaceAll:
+			failures = append(failures, fmt.Sprintf("hunk %d: search text matches %d times, add more context or set replace_all", i+1, count))
+		case hunk.ReplaceAll:
+			contents = strings.ReplaceAll(contents, hunk.Search, hunk.Replace)
+		default:
+			contents = strings.Replace(contents, hunk.Search, hunk.Replace, 1)
+		}
+	}
+	return contents, failures
+}
+
+var hunkHeaderRegExp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+type diffHunk struct {
+	header   string
+	oldStart int
+	oldLines []string
+	newLines []string
+}
+
+// parseUnifiedDiff parses the hunks of a unified diff of a single file. Lines starting with --- or +++ are
+// file headers only outside of hunks: within a hunk, until the line counts of its header are used up, they
+// are removed or added lines whose content starts with -- or ++.
+func parseUnifiedDiff(diff string) ([]*diffHunk, error) {
+	hunks := []*diffHunk{}
+	var current *diffHunk
+	files := 0
+	// oldRemaining and newRemaining are the lines of the current hunk still expected by its header
+	oldRemaining, newRemaining := 0, 0
+	for line := range strings.SplitSeq(strings.TrimRight(diff, "\n"), "\n") {
+		inHunk := current != nil && (oldRemaining > 0 || newRemaining > 0)
+		switch {
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			files++
+			if files > 1 {
+				return nil, fmt.Errorf("diff modifies more than one file, send one diff per file")
+			}
+			current = nil
+		case !inHunk && strings.HasPrefix(line, "--- "):
+			current = nil
+		case strings.HasPrefix(line, "@@"):
+			m := hunkHeaderRegExp.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("malformed hunk header: %s", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			oldRemaining, newRemaining = hunkLineCount(m[2]), hunkLineCount(m[3])
+			current = &diffHunk{header: line, oldStart: start}
+			hunks = append(hunks, current)
+		case current == nil:
+			// Headers (diff, index) and anything else outside of a hunk
+		case strings.HasPrefix(line, "\\"):
+			// "\ No newline at end of file"
+		case strings.HasPrefix(line, "+"):
+			current.newLines = append(current.newLines, line[1:])
+			newRemaining--
+		case strings.HasPrefix(line, "-"):
+			current.oldLines = append(current.oldLines, line[1:])
+			oldRemaining--
+		default:
+			// Context line. Some tools strip the leading space of empty context lines.
+			text := strings.TrimPrefix(line, " ")
+			current.oldLines = append(current.oldLines, text)
+			current.newLines = append(current.newLines, text)
+			oldRemaining--
+			newRemaining--
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, fmt.Errorf("diff contains no hunks")
+	}
+	return hunks, nil
+}
+
+// hunkLineCount parses a line count of a hunk header, which is 1 when omitted.
+func hunkLineCount(count string) int {
+	if count == "" {
+		return 1
+	}
+	n, _ := strconv.Atoi(count)
+	return n
+}
+
+func applyUnifiedDiff(contents, diff string) (string, []string) {
+	hunks, err := parseUnifiedDiff(diff)
+	if err != nil {
+		return contents, []string{err.Error()}
+	}
+
+	lines := strings.Split(contents, "\n")
+	failures := []string{}
+	offset := 0
+	for i, hunk := range hunks {
+		expected := hunk.oldStart - 1 + offset
+		if len(hunk.oldLines) == 0 {
+			// Pure insertion: the start line is the line after which to insert
+			expected = hunk.oldStart + offset
+		}
+		pos := findLines(lines, hunk.oldLines, expected)
+		if pos < 0 {
+			failures = append(failures, fmt.Sprintf("hunk %d (%s): context does not match the file", i+1, hunk.header))
+			continue
+		}
+
+		updated := make([]string, 0, len(lines)-len(hunk.oldLines)+len(hunk.newLines))
+		updated = append(updated, lines[:pos]...)
+		updated = append(updated, hunk.newLines...)
+		updated = append(updated, lines[pos+len(hunk.oldLines):]...)
+		lines = updated
+		offset += len(hunk.newLines) - len(hunk.oldLines)
+	}
+
+	return strings.Join(lines, "\n"), failures
+}
+
+// findLines returns the index of the occurrence of needle in lines closest to expected, or -1.
+func findLines(lines, needle []string, expected int) int {
+	expected = min(max(expected, 0), len(lines))
+	matches := func(pos int) bool {
+		if pos < 0 || pos+len(needle) > len(lines) {
+			return false
+		}
+		for i, line := range needle {
+			if lines[pos+i] != line {
+				return false
+			}
+		}
+		return true
+	}
+
+	for delta := 0; delta <= len(lines); delta++ {
+		if matches(expected - delta) {
+			return expected - delta
+		}
+		if matches(expected + delta) {
+			return expected + delta
+		}
+	}
+	return -1
+}
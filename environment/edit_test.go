@@ -0,0 +1,109 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySearchReplace(t *testing.T) {
+	contents := "package main\n\nfunc main() {\n\tprintln(\"hello\")\n\tprintln(\"hello\")\n}\n"
+
+	t.Run("single_match", func(t *testing.T) {
+		edited, failures := applySearchReplace(contents, []EditHunk{
+			{Search: "package main", Replace: "package app"},
+		})
+		assert.Empty(t, failures)
+		assert.Contains(t, edited, "package app")
+	})
+
+	t.Run("ambiguous_match", func(t *testing.T) {
+		_, failures := applySearchReplace(contents, []EditHunk{
+			{Search: "println(\"hello\")", Replace: "println(\"bye\")"},
+		})
+		assert.Equal(t, []string{"hunk 1: search text matches 2 times, add more context or set replace_all"}, failures)
+	})
+
+	t.Run("replace_all", func(t *testing.T) {
+		edited, failures := applySearchReplace(contents, []EditHunk{
+			{Search: "hello", Replace: "bye", ReplaceAll: true},
+		})
+		assert.Empty(t, failures)
+		assert.NotContains(t, edited, "hello")
+	})
+
+	t.Run("reports_failing_hunks", func(t *testing.T) {
+		_, failures := applySearchReplace(contents, []EditHunk{
+			{Search: "package main", Replace: "package app"},
+			{Search: "does not exist", Replace: "x"},
+			{Search: "", Replace: "x"},
+		})
+		assert.Equal(t, []string{
+			"hunk 2: search text not found",
+			"hunk 3: search text is empty",
+		}, failures)
+	})
+}
+
+func TestApplyUnifiedDiff(t *testing.T) {
+	contents := "one\ntwo\nthree\nfour\nfive\nsix\n"
+
+	t.Run("applies_hunks", func(t *testing.T) {
+		diff := `--- a/numbers.txt
++++ b/numbers.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++TWO
+ three
+@@ -5,2 +5,3 @@
+ five
++five and a half
+ six
+`
+		edited, failures := applyUnifiedDiff(contents, diff)
+		assert.Empty(t, failures)
+		assert.Equal(t, "one\nTWO\nthree\nfour\nfive\nfive and a half\nsix\n", edited)
+	})
+
+	t.Run("tolerates_wrong_line_numbers", func(t *testing.T) {
+		diff := `@@ -1,2 +1,2 @@
+ four
+-five
++FIVE
+`
+		edited, failures := applyUnifiedDiff(contents, diff)
+		assert.Empty(t, failures)
+		assert.Equal(t, "one\ntwo\nthree\nfour\nFIVE\nsix\n", edited)
+	})
+
+	t.Run("reports_mismatched_context", func(t *testing.T) {
+		diff := `@@ -1,2 +1,2 @@
+ zero
+-one
++ONE
+`
+		_, failures := applyUnifiedDiff(contents, diff)
+		assert.Equal(t, []string{"hunk 1 (@@ -1,2 +1,2 @@): context does not match the file"}, failures)
+	})
+
+	t.Run("lines_starting_like_file_headers", func(t *testing.T) {
+		diff := `--- a/query.sql
++++ b/query.sql
+@@ -1,3 +1,3 @@
+ SELECT 1;
+--- old comment
++++ counter
+ SELECT 2;
+`
+		edited, failures := applyUnifiedDiff("SELECT 1;\n-- old comment\nSELECT 2;\n", diff)
+		assert.Empty(t, failures)
+		assert.Equal(t, "SELECT 1;\n++ counter\nSELECT 2;\n", edited)
+	})
+
+	t.Run("rejects_multiple_files", func(t *testing.T) {
+		diff := "+++ b/a.txt\n@@ -1 +1 @@\n-one\n+ONE\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-one\n+ONE\n"
+		_, failures := applyUnifiedDiff(contents, diff)
+		assert.Equal(t, []string{"diff modifies more than one file, send one diff per file"}, failures)
+	})
+}
@@ -0,0 +1,176 @@
+package environment
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/mitchellh/go-homedir"
+)
+
+// secretSchemas are the schemas of secret references that can appear as values in env files.
+var secretSchemas = []string{"env", "file", "op", "vault", "gcp-sm", "azure-kv"}
+
+// envEntry is a KEY=VALUE entry read from an env file.
+type envEntry struct {
+	Key   string
+	Value string
+}
+
+// parseEnvFile parses the contents of a .env file.
+// It supports comments, an optional "export" prefix, and single or double quoted values.
+// Variable expansion is not performed.
+func parseEnvFile(contents string) ([]envEntry, error) {
+	entries := []envEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && strings.LastIndexByte(value, '\'') > 0:
+			value = value[1:strings.LastIndexByte(value, '\'')]
+		case len(value) >= 2 && value[0] == '"' && strings.LastIndexByte(value, '"') > 0:
+			value = value[1:strings.LastIndexByte(value, '"')]
+			value = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(value)
+		default:
+			// Unquoted values end at an inline comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		entries = append(entries, envEntry{Key: key, Value: value})
+	}
+	return entries, scanner.Err()
+}
+
+// isSecretReference reports whether value is a secret reference such as env://NAME or op://vault/item/field.
+func isSecretReference(value string) bool {
+	schema, _, found := strings.Cut(value, "://")
+	if !found {
+		return false
+	}
+	for _, s := range secretSchemas {
+		if schema == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostEnvFile reports whether an env file path refers to the host rather than the repository.
+func isHostEnvFile(path string) bool {
+	return filepath.IsAbs(path) || strings.HasPrefix(path, "~")
+}
+
+// readEnvFile returns the contents of an env file, and false if it doesn't exist.
+// Relative paths are read from source, absolute paths (or ~/...) from the host.
+func readEnvFile(ctx context.Context, source *dagger.Directory, path string) (string, bool, error) {
+	if isHostEnvFile(path) {
+		hostPath, err := homedir.Expand(path)
+		if err != nil {
+			return "", false, err
+		}
+		data, err := os.ReadFile(hostPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		return string(data), true, nil
+	}
+
+	matches, err := source.Glob(ctx, path)
+	if err != nil {
+		return "", false, err
+	}
+	if len(matches) == 0 {
+		return "", false, nil
+	}
+	contents, err := source.File(path).Contents(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	return contents, true, nil
+}
+
+// containerWithEnvFiles loads the entries of the configured env files into container, in order, so
+// that later files override earlier ones. Missing files are skipped, which allows optional
+// overrides such as .env.local.
+//
+// Values that are secret references (e.g. env://TOKEN) are resolved as secrets. Entries of files
+// read from the host are loaded as secrets too, since they are not part of the repository.
+// Variables also set in the Env or Secrets of the configuration are skipped: those take precedence.
+func (env *Environment) containerWithEnvFiles(ctx context.Context, container *dagger.Container, source *dagger.Directory) (*dagger.Container, error) {
+	explicit := map[string]bool{}
+	for _, key := range env.Config.Env.Keys() {
+		explicit[key] = true
+	}
+	for _, key := range env.Config.Secrets.Keys() {
+		explicit[key] = true
+	}
+
+	type loaded struct {
+		value  string
+		secret bool
+		file   string
+	}
+	vars := map[string]loaded{}
+	order := []string{}
+	for _, path := range env.Config.EnvFiles {
+		contents, found, err := readEnvFile(ctx, source, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read env file %s: %w", path, err)
+		}
+		if !found {
+			continue
+		}
+		entries, err := parseEnvFile(contents)
+		if err != nil {
+			return nil, fmt.Errorf("invalid env file %s: %w", path, err)
+		}
+		for _, entry := range entries {
+			if explicit[entry.Key] {
+				continue
+			}
+			if _, ok := vars[entry.Key]; !ok {
+				order = append(order, entry.Key)
+			}
+			vars[entry.Key] = loaded{value: entry.Value, secret: isHostEnvFile(path), file: path}
+		}
+	}
+
+	for _, key := range order {
+		v := vars[key]
+		switch {
+		case isSecretReference(v.value):
+			secret, err := secretFromReference(ctx, env.dag, v.value)
+			if err != nil {
+				return nil, fmt.Errorf("env file %s: secret %s: %w", v.file, key, err)
+			}
+			container = container.WithSecretVariable(key, secret)
+		case v.secret:
+			container = container.WithSecretVariable(key, env.dag.SetSecret(fmt.Sprintf("envfile:%s:%s", v.file, key), v.value))
+		default:
+			container = container.WithEnvVariable(key, v.value)
+		}
+	}
+	return container, nil
+}
@@ -0,0 +1,74 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		contents    string
+		expected    []envEntry
+		expectError string
+	}{
+		{
+			name: "plain_values",
+			contents: `# Database
+DATABASE_URL=postgres://localhost/app
+
+export NODE_ENV=development
+EMPTY=
+`,
+			expected: []envEntry{
+				{Key: "DATABASE_URL", Value: "postgres://localhost/app"},
+				{Key: "NODE_ENV", Value: "development"},
+				{Key: "EMPTY", Value: ""},
+			},
+		},
+		{
+			name:     "inline_comment",
+			contents: "PORT=3000 # default port\nCOLOR=#fff",
+			expected: []envEntry{
+				{Key: "PORT", Value: "3000"},
+				{Key: "COLOR", Value: "#fff"},
+			},
+		},
+		{
+			name:     "quoted_values",
+			contents: "GREETING=\"hello # world\\n\"\nRAW='$HOME\\n'\nSPACED = \"a b\" # comment",
+			expected: []envEntry{
+				{Key: "GREETING", Value: "hello # world\n"},
+				{Key: "RAW", Value: `$HOME\n`},
+				{Key: "SPACED", Value: "a b"},
+			},
+		},
+		{
+			name:        "missing_equals",
+			contents:    "FOO=bar\nnot an entry",
+			expectError: "line 2",
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			entries, err := parseEnvFile(scenario.contents)
+			if scenario.expectError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), scenario.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, scenario.expected, entries)
+		})
+	}
+}
+
+func TestIsSecretReference(t *testing.T) {
+	assert.True(t, isSecretReference("env://TOKEN"))
+	assert.True(t, isSecretReference("op://vault/item/field"))
+	assert.False(t, isSecretReference("postgres://localhost/app"))
+	assert.False(t, isSecretReference("plain"))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -30,6 +31,28 @@ type Environment struct {
 	Services []*Service
 	Notes    Notes
 
+	// Warnings are the problems of the last build that didn't prevent it, such as secrets left unset
+	// because their provider is unavailable.
+	Warnings []string
+
+	// LastBuild is the log of the setup commands run by the last build of this environment, if any.
+	LastBuild *BuildLog
+
+	// SecretFindings are the credentials found in the changes of the environment when they were last
+	// saved to its repository.
+	SecretFindings []SecretFinding
+	// SkippedFiles are the binary files of the workdir left uncommitted when the environment was last saved
+	// to its repository, see BinaryFileSettings.
+	SkippedFiles []string
+
+	// ToolchainSuggestion is the configuration proposed when the environment was created from a
+	// repository without one, if its toolchain was detected.
+	ToolchainSuggestion *ToolchainSuggestion
+
+	// AppendedSetup are the setup commands the last UpdateConfig ran on top of the environment, when
+	// they were the only change to its configuration. It's nil if the environment was rebuilt instead.
+	AppendedSetup []string
+
 	mu sync.RWMutex
 }
 
@@ -39,7 +62,35 @@ func New(ctx context.Context, dag *dagger.Client, id, title, worktree string, in
 		return nil, err
 	}
 
-	env := &Environment{
+	env, err := NewWithConfig(ctx, dag, id, title, config, initialSourceDir)
+	if err != nil {
+		return nil, err
+	}
+	env.ToolchainSuggestion = SuggestToolchain(worktree)
+	return env, nil
+}
+
+// NewWithConfig creates an environment from an explicit configuration rather than
+// the one checked into the worktree.
+func NewWithConfig(ctx context.Context, dag *dagger.Client, id, title string, config *EnvironmentConfig, initialSourceDir *dagger.Directory) (*Environment, error) {
+	env := newEnvironment(dag, id, title, config)
+
+	container, err := env.buildBase(ctx, initialSourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Creating environment", "id", env.ID, "workdir", env.Config.Workdir)
+
+	if err := env.apply(ctx, container); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func newEnvironment(dag *dagger.Client, id, title string, config *EnvironmentConfig) *Environment {
+	return &Environment{
 		EnvironmentInfo: &EnvironmentInfo{
 			ID:     id,
 			Config: config,
@@ -51,19 +102,48 @@ func New(ctx context.Context, dag *dagger.Client, id, title, worktree string, in
 		},
 		dag: dag,
 	}
+}
 
-	container, err := env.buildBase(ctx, initialSourceDir)
+// Clone creates environment id with the configuration of env and sourceDir as its workdir, e.g. to
+// reuse a configured environment in another repository.
+// If withContainer is set, the container of env is reused instead of being built from the base image:
+// everything installed outside of the workdir carries over and setup commands don't run again.
+// The workdir itself is replaced by sourceDir.
+func (env *Environment) Clone(ctx context.Context, id, title string, sourceDir *dagger.Directory, withContainer bool) (*Environment, error) {
+	if !withContainer {
+		return NewWithConfig(ctx, env.dag, id, title, env.Config.Copy(), sourceDir)
+	}
+
+	clone := newEnvironment(env.dag, id, title, env.Config.Copy())
+	base := env.container().WithoutDirectory(env.Config.Workdir)
+	container, err := clone.build(ctx, base, sourceDir, false)
 	if err != nil {
 		return nil, err
 	}
+	if err := clone.apply(ctx, container); err != nil {
+		return nil, err
+	}
+	clone.Notes.Add("Clone the container of environment %s", env.ID)
+	return clone, nil
+}
 
-	slog.Info("Creating environment", "id", env.ID, "workdir", env.Config.Workdir)
+// Fork creates environment id as a deep copy of env, including everything in its container: installed
+// dependencies, and build artifacts and other untracked files of the workdir. The container is snapshotted
+// as an image first, so that the fork shares nothing with env but that image.
+// Services are started anew and background processes don't carry over.
+func (env *Environment) Fork(ctx context.Context, id, title string) (*Environment, error) {
+	snapshot := NewContainer(env.dag, env.Config.Platform).Import(env.container().AsTarball())
 
-	if err := env.apply(ctx, container); err != nil {
+	fork := newEnvironment(env.dag, id, title, env.Config.Copy())
+	container, err := fork.build(ctx, snapshot, snapshot.Directory(env.Config.Workdir), false)
+	if err != nil {
 		return nil, err
 	}
-
-	return env, nil
+	if err := fork.apply(ctx, container); err != nil {
+		return nil, err
+	}
+	fork.Notes.Add("Fork environment %s with its container", env.ID)
+	return fork, nil
 }
 
 func (env *Environment) Workdir() *dagger.Directory {
@@ -77,8 +157,8 @@ func (env *Environment) container() *dagger.Container {
 	return env.dag.LoadContainerFromID(dagger.ContainerID(env.State.Container))
 }
 
-func Load(ctx context.Context, dag *dagger.Client, id string, state []byte, worktree string) (*Environment, error) {
-	envInfo, err := LoadInfo(ctx, id, state, worktree)
+func Load(ctx context.Context, dag *dagger.Client, id string, state []byte, config *EnvironmentConfig) (*Environment, error) {
+	envInfo, err := LoadInfo(ctx, id, state, config)
 	if err != nil {
 		return nil, err
 	}
@@ -87,6 +167,7 @@ func Load(ctx context.Context, dag *dagger.Client, id string, state []byte, work
 		dag:             dag,
 		// Services: ?
 	}
+	processes.restore(id, envInfo.State.Processes)
 
 	return env, nil
 }
@@ -94,12 +175,7 @@ func Load(ctx context.Context, dag *dagger.Client, id string, state []byte, work
 // LoadInfo loads basic environment metadata without requiring dagger operations.
 // This is useful for operations that only need access to configuration and state
 // information without the overhead of initializing container operations.
-func LoadInfo(ctx context.Context, id string, state []byte, worktree string) (*EnvironmentInfo, error) {
-	config := DefaultConfig()
-	if err := config.Load(worktree); err != nil {
-		return nil, err
-	}
-
+func LoadInfo(ctx context.Context, id string, state []byte, config *EnvironmentConfig) (*EnvironmentInfo, error) {
 	envInfo := &EnvironmentInfo{
 		ID:     id,
 		Config: config,
@@ -116,7 +192,7 @@ func LoadInfo(ctx context.Context, id string, state []byte, worktree string) (*E
 func (env *Environment) apply(ctx context.Context, newState *dagger.Container) error {
 	// TODO(braa): is this sync redundant with newState.ID?
 	if _, err := newState.Sync(ctx); err != nil {
-		return err
+		return withRegistryAuthHint(err)
 	}
 
 	containerID, err := newState.ID(ctx)
@@ -132,7 +208,9 @@ func (env *Environment) apply(ctx context.Context, newState *dagger.Container) e
 	return nil
 }
 
-func containerWithEnvAndSecrets(dag *dagger.Client, container *dagger.Container, envs, secrets []string) (*dagger.Container, error) {
+// containerWithEnvAndSecrets sets the variables and secrets of container. If the 1Password CLI is unavailable,
+// op:// secrets are left unset and the container is returned along with a *OnePasswordError naming them.
+func containerWithEnvAndSecrets(ctx context.Context, dag *dagger.Client, container *dagger.Container, envs, secrets []string) (*dagger.Container, error) {
 	for _, env := range envs {
 		k, v, found := strings.Cut(env, "=")
 		if !found {
@@ -144,67 +222,161 @@ func containerWithEnvAndSecrets(dag *dagger.Client, container *dagger.Container,
 		container = container.WithEnvVariable(k, v)
 	}
 
+	var unavailable *OnePasswordError
 	for _, secret := range secrets {
 		k, v, found := strings.Cut(secret, "=")
 		if !found {
 			return nil, fmt.Errorf("invalid secret: %s", secret)
 		}
-		container = container.WithSecretVariable(k, dag.Secret(v))
+		if isOnePasswordReference(v) {
+			if opErr, ok := asOnePasswordError(CheckOnePassword(ctx)); ok {
+				if unavailable == nil {
+					unavailable = &OnePasswordError{Reason: opErr.Reason}
+				}
+				unavailable.Secrets = append(unavailable.Secrets, k)
+				continue
+			}
+		}
+		secret, err := secretFromReference(ctx, dag, v)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", k, err)
+		}
+		container = container.WithSecretVariable(k, secret)
 	}
 
+	if unavailable != nil {
+		return container, unavailable
+	}
 	return container, nil
 }
 
-func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Directory) (*dagger.Container, error) {
-	container := env.dag.
-		Container().
-		From(env.Config.BaseImage).
-		WithWorkdir(env.Config.Workdir)
+// withSecretsWarning records err as a warning of the build if it's a *OnePasswordError, so that the
+// environment is built without the secrets it names. Other errors are returned.
+func (env *Environment) withSecretsWarning(err error) error {
+	opErr, ok := asOnePasswordError(err)
+	if !ok {
+		return err
+	}
+	env.Warnings = append(env.Warnings, opErr.Error())
+	env.Notes.Add("Warning: %s", opErr.Error())
+	return nil
+}
 
-	container, err := containerWithEnvAndSecrets(env.dag, container, env.Config.Env, env.Config.Secrets)
+func (env *Environment) buildBase(ctx context.Context, baseSourceDir *dagger.Directory) (*dagger.Container, error) {
+	if err := ValidatePlatform(env.Config.Platform); err != nil {
+		return nil, err
+	}
+	if err := env.Config.Network.Validate(); err != nil {
+		return nil, err
+	}
+	if err := ValidateUser(env.Config.User); err != nil {
+		return nil, err
+	}
+	if err := env.Config.validateDocker(); err != nil {
+		return nil, err
+	}
+	base, err := env.baseContainer(ctx, baseSourceDir)
 	if err != nil {
 		return nil, err
 	}
+	return env.build(ctx, base, baseSourceDir, true)
+}
 
-	for _, command := range env.Config.SetupCommands {
-		var err error
-
-		container = container.WithExec([]string{"sh", "-c", command})
-
-		exitCode, err := container.ExitCode(ctx)
-		if err != nil {
-			var exitErr *dagger.ExecError
-			if errors.As(err, &exitErr) {
-				env.Notes.AddCommand(command, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
-				return nil, fmt.Errorf("setup command failed with exit code %d.\nstdout: %s\nstderr: %s\n%w", exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr, err)
-			}
-
-			return nil, fmt.Errorf("failed to execute setup command: %w", err)
-		}
-		stdout, err := container.Stdout(ctx)
+// baseContainer returns the image the environment starts from: the one built from its Dockerfile, with
+// sourceDir as build context, or else its base image.
+func (env *Environment) baseContainer(ctx context.Context, sourceDir *dagger.Directory) (*dagger.Container, error) {
+	if env.Config.Dockerfile == "" {
+		container, err := env.withRegistryAuth(ctx, NewContainer(env.dag, env.Config.Platform))
 		if err != nil {
-			return nil, fmt.Errorf("failed to get stdout: %w", err)
+			return nil, err
 		}
+		return container.From(env.Config.BaseImage), nil
+	}
+	buildArgs := make([]dagger.BuildArg, 0, len(env.Config.BuildArgs))
+	for _, arg := range env.Config.BuildArgs {
+		name, value, _ := strings.Cut(arg, "=")
+		buildArgs = append(buildArgs, dagger.BuildArg{Name: name, Value: value})
+	}
+	return sourceDir.DockerBuild(dagger.DirectoryDockerBuildOpts{
+		Dockerfile: env.Config.Dockerfile,
+		BuildArgs:  buildArgs,
+		Target:     env.Config.BuildTarget,
+		Platform:   dagger.Platform(env.Config.Platform),
+	}), nil
+}
+
+// build creates the environment container on top of base with baseSourceDir as its workdir.
+// Setup commands are skipped if setup is false, e.g. when base already went through them.
+func (env *Environment) build(ctx context.Context, base *dagger.Container, baseSourceDir *dagger.Directory, setup bool) (*dagger.Container, error) {
+	container, err := env.withCaches(base.WithWorkdir(env.Config.Workdir))
+	if err != nil {
+		return nil, err
+	}
+	container, err = env.withMounts(container)
+	if err != nil {
+		return nil, err
+	}
+
+	container, err = env.containerWithEnvFiles(ctx, container, baseSourceDir)
+	if err != nil {
+		return nil, err
+	}
+	env.Warnings = nil
+	container, err = containerWithEnvAndSecrets(ctx, env.dag, container, env.Config.Env, env.Config.Secrets)
+	if err := env.withSecretsWarning(err); err != nil {
+		return nil, err
+	}
+	container, err = env.withHostCredentials(ctx, container)
+	if err != nil {
+		return nil, err
+	}
 
-		stderr, err := container.Stderr(ctx)
+	if setup {
+		container, err = env.runSetup(ctx, container)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get stderr: %w", err)
+			return nil, err
 		}
-
-		env.Notes.AddCommand(command, exitCode, stdout, stderr)
+	}
+	container, err = env.withUser(container)
+	if err != nil {
+		return nil, err
 	}
 
 	env.Services, err = env.startServices(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start services: %w", err)
 	}
+	if env.Config.Docker {
+		docker, err := env.startDocker(ctx)
+		if err != nil {
+			return nil, err
+		}
+		env.Services = append(env.Services, docker)
+	}
+	container = env.withDockerCLI(container)
+	env.resetEndpoints()
+	container = withServiceBindings(container, env.Services...)
 	for _, service := range env.Services {
-		container = container.WithServiceBinding(service.Config.Name, service.svc)
+		env.setEndpoints(service.Config.Name, service.Endpoints)
 	}
 
-	container = container.WithDirectory(".", baseSourceDir)
+	container = container.WithDirectory(".", baseSourceDir, dagger.ContainerWithDirectoryOpts{Owner: env.Config.User})
 
-	return container, nil
+	return env.withDefaultCommand(container), nil
+}
+
+// withDefaultCommand sets the default command and entrypoint of the configuration on container, when set,
+// so that they apply to commands run without arguments and to the images of checkpoints.
+func (env *Environment) withDefaultCommand(container *dagger.Container) *dagger.Container {
+	if len(env.Config.Entrypoint) > 0 {
+		container = container.WithEntrypoint(env.Config.Entrypoint, dagger.ContainerWithEntrypointOpts{
+			KeepDefaultArgs: true,
+		})
+	}
+	if env.Config.DefaultCommand != "" {
+		container = container.WithDefaultArgs([]string{"sh", "-c", env.Config.DefaultCommand})
+	}
+	return container
 }
 
 func (env *Environment) UpdateConfig(ctx context.Context, explanation string, newConfig *EnvironmentConfig) error {
@@ -212,7 +384,22 @@ func (env *Environment) UpdateConfig(ctx context.Context, explanation string, ne
 		return fmt.Errorf("Environment is locked, no updates allowed. Try to make do with the current environment or ask a human to remove the lock file (%s)", path.Join(configDir, lockFile))
 	}
 
+	oldConfig := env.Config
 	env.Config = newConfig
+	env.AppendedSetup = nil
+	// The container is built again, even if that fails
+	warmContainers.forget(env.ID)
+
+	if phases, ok := newConfig.appendedSetup(oldConfig); ok && env.State.Container != "" {
+		container, err := env.appendSetup(ctx, phases)
+		if err != nil {
+			return err
+		}
+		for _, phase := range phases {
+			env.AppendedSetup = append(env.AppendedSetup, phase.Commands...)
+		}
+		return env.apply(ctx, container)
+	}
 
 	// Re-build the base image with the new config
 	container, err := env.buildBase(ctx, env.Workdir())
@@ -227,58 +414,256 @@ func (env *Environment) UpdateConfig(ctx context.Context, explanation string, ne
 	return nil
 }
 
-func (env *Environment) Run(ctx context.Context, command, shell string, useEntrypoint bool) (string, error) {
+// RunResult is the outcome of a command executed with Run.
+type RunResult struct {
+	ExitCode   int    `json:"exit_code"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	DurationMs int64  `json:"duration_ms"`
+
+	// TimedOut is set when the command was killed for exceeding its timeout.
+	// Stdout and Stderr then hold the output produced until then.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// Output returns stdout followed by stderr, if any.
+func (r *RunResult) Output() string {
+	output := r.Stdout
+	if r.Stderr != "" {
+		if output != "" {
+			output += "\n"
+		}
+		output += "stderr: " + r.Stderr
+	}
+	return output
+}
+
+// RunOpts tweaks how a single command runs without changing the environment configuration.
+type RunOpts struct {
+	UseEntrypoint bool
+
+	// Timeout kills the command once it has run for that long. Only applies to foreground commands.
+	Timeout time.Duration
+
+	// Workdir is the directory to run the command in, absolute or relative to the environment workdir.
+	Workdir string
+
+	// Env holds additional KEY=VALUE variables for the command.
+	Env []string
+
+	// Nice lowers the CPU and I/O scheduling priority of the command (1-19, higher is lower priority).
+	Nice int
+
+	// MemoryLimit caps the virtual memory of the command, in bytes.
+	// It is enforced with ulimit, so it bounds address space rather than resident memory.
+	MemoryLimit int64
+
+	// User runs the command as another user than the one of the environment, e.g. root.
+	User string
+
+	// Output, if set, receives the output of foreground commands while they run, stdout and stderr
+	// interleaved, with the values of secrets masked. The full output is still returned once they exit.
+	Output func(chunk string)
+}
+
+// limitCommand wraps command so that it runs with the resource limits of opts.
+// Dagger has no per-exec cgroup settings, so limits are applied from within the container
+// with nice, ionice (when available) and ulimit.
+func limitCommand(command, shell string, opts RunOpts) string {
+	if opts.Nice <= 0 && opts.MemoryLimit <= 0 {
+		return command
+	}
+
+	steps := []string{}
+	if opts.MemoryLimit > 0 {
+		steps = append(steps, fmt.Sprintf("ulimit -v %d", max(opts.MemoryLimit/1024, 1)))
+	}
+	exec := "exec"
+	if opts.Nice > 0 {
+		steps = append(steps, "(command -v ionice >/dev/null 2>&1 && ionice -c 3 -p $$ >/dev/null 2>&1; true)")
+		exec += fmt.Sprintf(" nice -n %d", min(opts.Nice, 19))
+	}
+	steps = append(steps, fmt.Sprintf("%s %s -c %s", exec, shell, shellQuote(command)))
+	return strings.Join(steps, " && ")
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// withCommandScope applies the per-command user, workdir and environment variables of opts to container.
+// The returned function reverts them on a container derived from the result, so they don't leak
+// into the environment state.
+func (env *Environment) withCommandScope(ctx context.Context, container *dagger.Container, opts RunOpts) (*dagger.Container, func(*dagger.Container) *dagger.Container, error) {
+	restores := []func(*dagger.Container) *dagger.Container{}
+
+	if opts.User != "" {
+		var restore func(*dagger.Container) *dagger.Container
+		var err error
+		container, restore, err = withCommandUser(ctx, container, opts.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		restores = append(restores, restore)
+	}
+
+	if opts.Workdir != "" {
+		workdir := opts.Workdir
+		if !path.IsAbs(workdir) {
+			workdir = path.Join(env.Config.Workdir, workdir)
+		}
+		container = container.WithWorkdir(workdir)
+		restores = append(restores, func(c *dagger.Container) *dagger.Container {
+			return c.WithWorkdir(env.Config.Workdir)
+		})
+	}
+
+	for _, variable := range opts.Env {
+		k, v, found := strings.Cut(variable, "=")
+		if !found || k == "" {
+			return nil, nil, fmt.Errorf("invalid environment variable: %s", variable)
+		}
+		previous, err := container.EnvVariable(ctx, k)
+		if err != nil {
+			return nil, nil, err
+		}
+		container = container.WithEnvVariable(k, v)
+		restores = append(restores, func(c *dagger.Container) *dagger.Container {
+			if previous == "" {
+				return c.WithoutEnvVariable(k)
+			}
+			return c.WithEnvVariable(k, previous)
+		})
+	}
+
+	return container, func(c *dagger.Container) *dagger.Container {
+		for _, restore := range restores {
+			c = restore(c)
+		}
+		return c
+	}, nil
+}
+
+// Run executes command in the environment and applies the resulting container state.
+// A non-zero exit code is not an error: it is reported in the result, and changes made by the command are kept.
+// If opts.Timeout is positive, the command is killed once it has run for that long, rounded up to the second,
+// and the result is marked as timed out. This relies on the timeout utility (coreutils or busybox) being
+// available in the container. Cancelling ctx aborts the underlying Dagger exec.
+func (env *Environment) Run(ctx context.Context, command, shell string, opts RunOpts) (*RunResult, error) {
+	timeout := opts.Timeout
+	if command == "" && timeout > 0 && env.Config.DefaultCommand != "" {
+		// Run the default command explicitly, to bound it like any other
+		command, shell = env.Config.DefaultCommand, "sh"
+	}
 	args := []string{}
 	if command != "" {
-		args = []string{shell, "-c", command}
+		args = []string{shell, "-c", limitCommand(command, shell, opts)}
+		if timeout > 0 {
+			seconds := (timeout + time.Second - 1) / time.Second
+			args = append([]string{"timeout", "-s", "KILL", strconv.Itoa(int(seconds))}, args...)
+		}
+	}
+	container, restore, err := env.withCommandScope(ctx, env.container(), opts)
+	if err != nil {
+		return nil, err
 	}
-	newState := env.container().WithExec(args, dagger.ContainerWithExecOpts{
+	container, args, useEntrypoint, restoreNetwork, err := env.withNetworkPolicy(ctx, container, args, opts.UseEntrypoint)
+	if err != nil {
+		return nil, err
+	}
+	streamID := ""
+	if opts.Output != nil && len(args) > 0 {
+		streamID = "run-" + newProcessID()
+		container, args = env.withOutputStreaming(container, streamID, shell, args)
+	}
+	// Nesting gives commands the Dagger API, which would let them run containers outside of the network policy
+	newState := container.WithExec(args, dagger.ContainerWithExecOpts{
 		UseEntrypoint:                 useEntrypoint,
 		Expect:                        dagger.ReturnTypeAny, // Don't treat non-zero exit as error
-		ExperimentalPrivilegedNesting: true,
+		ExperimentalPrivilegedNesting: !env.Config.Network.Enforced(),
+		InsecureRootCapabilities:      env.Config.Network.Enforced(),
 	})
 
+	start := time.Now()
+	stopStreaming := func() {}
+	if streamID != "" {
+		streamCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			env.streamOutput(streamCtx, streamID, opts.Output)
+		}()
+		stopStreaming = sync.OnceFunc(func() {
+			cancel()
+			<-stopped
+		})
+		defer stopStreaming()
+		newState = newState.WithoutMount(processDir)
+	}
 	exitCode, err := newState.ExitCode(ctx)
+	stopStreaming()
 	if err != nil {
-		return "", fmt.Errorf("failed to get exit code: %w", err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command aborted: %w", context.Cause(ctx))
+		}
+		return nil, fmt.Errorf("failed to get exit code: %w", err)
 	}
+	duration := time.Since(start)
 
 	stdout, err := newState.Stdout(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stdout: %w", err)
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
 	}
 
 	stderr, err := newState.Stderr(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to get stderr: %w", err)
+		return nil, fmt.Errorf("failed to get stderr: %w", err)
+	}
+
+	// Secrets are available to commands, which may print them
+	stdout, stderr = env.MaskSecrets(ctx, stdout), env.MaskSecrets(ctx, stderr)
+
+	result := &RunResult{
+		ExitCode:   exitCode,
+		Stdout:     stdout,
+		Stderr:     stderr,
+		DurationMs: duration.Milliseconds(),
+		TimedOut:   timeout > 0 && isTimeoutExitCode(exitCode) && duration >= timeout,
 	}
 
 	// Log the command execution with all details
 	env.Notes.AddCommand(command, exitCode, stdout, stderr)
 
 	// Always apply the container state (preserving changes even on non-zero exit)
-	if err := env.apply(ctx, newState); err != nil {
-		return stdout, fmt.Errorf("failed to apply container state: %w", err)
+	if err := env.apply(ctx, restore(restoreNetwork(newState))); err != nil {
+		return result, fmt.Errorf("failed to apply container state: %w", err)
 	}
 
-	// Return combined output (stdout + stderr if there was stderr)
-	combinedOutput := stdout
-	if stderr != "" {
-		if stdout != "" {
-			combinedOutput += "\n"
-		}
-		combinedOutput += "stderr: " + stderr
-	}
-	return combinedOutput, nil
+	return result, nil
 }
 
-func (env *Environment) RunBackground(ctx context.Context, command, shell string, ports []int, useEntrypoint bool) (EndpointMappings, error) {
+// isTimeoutExitCode reports whether exitCode is what timeout(1) yields for a killed command:
+// 124 for coreutils, 128+SIGKILL for busybox.
+func isTimeoutExitCode(exitCode int) bool {
+	return exitCode == 124 || exitCode == 137
+}
+
+// RunBackground starts command as a background process of the environment.
+// The returned process can be inspected with Processes and ProcessLogs, and stopped with ProcessKill.
+func (env *Environment) RunBackground(ctx context.Context, command, shell string, ports []int, opts RunOpts) (*Process, error) {
+	displayCommand := command + " &"
+	// The service container is never applied to the environment, so there is nothing to restore.
+	serviceState, _, err := env.withCommandScope(ctx, env.container(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newProcessID()
 	args := []string{}
 	if command != "" {
-		args = []string{shell, "-c", command}
+		serviceState, args = env.withProcessLogging(serviceState, id, limitCommand(command, shell, opts), shell)
 	}
-	displayCommand := command + " &"
-	serviceState := env.container()
 
 	// Expose ports
 	for _, port := range ports {
@@ -291,9 +676,14 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 	// Start the service
 	startCtx, cancel := context.WithTimeout(ctx, serviceStartTimeout)
 	defer cancel()
+	serviceState, args, useEntrypoint, _, err := env.withNetworkPolicy(ctx, serviceState, args, opts.UseEntrypoint)
+	if err != nil {
+		return nil, err
+	}
 	svc, err := serviceState.AsService(dagger.ContainerAsServiceOpts{
-		Args:          args,
-		UseEntrypoint: useEntrypoint,
+		Args:                     args,
+		UseEntrypoint:            useEntrypoint,
+		InsecureRootCapabilities: env.Config.Network.Enforced(),
 	}).Start(startCtx)
 	if err != nil {
 		var exitErr *dagger.ExecError
@@ -347,7 +737,20 @@ func (env *Environment) RunBackground(ctx context.Context, command, shell string
 		endpoint.EnvironmentInternal = internalEndpoint
 	}
 
-	return endpoints, nil
+	env.setEndpoints(displayCommand, endpoints)
+
+	process := &Process{
+		ID:        id,
+		Command:   command,
+		StartedAt: time.Now(),
+		Endpoints: endpoints,
+		Status:    "running",
+		svc:       svc,
+	}
+	processes.add(env.ID, process)
+	env.recordProcesses()
+
+	return process, nil
 }
 
 func (env *Environment) Terminal(ctx context.Context) error {
@@ -384,5 +787,66 @@ func (env *Environment) Terminal(ctx context.Context) error {
 }
 
 func (env *Environment) Checkpoint(ctx context.Context, target string) (string, error) {
-	return env.container().Publish(ctx, target)
+	container, err := env.withRegistryAuth(ctx, env.withDefaultCommand(env.container()))
+	if err != nil {
+		return "", err
+	}
+	ref, err := container.Publish(ctx, target)
+	if err != nil {
+		return "", withRegistryAuthHint(err)
+	}
+	env.mu.Lock()
+	env.State.Checkpoint = ref
+	env.mu.Unlock()
+	return ref, nil
+}
+
+// Restore replaces the container of the environment with one started from image, a reference
+// returned by Checkpoint. Setup commands are not run again since the image already went through them,
+// but secrets and services, which are not part of the image, are. The workdir is replaced by sourceDir
+// so that it matches the environment branch.
+func (env *Environment) Restore(ctx context.Context, image string, sourceDir *dagger.Directory) error {
+	base, err := env.withRegistryAuth(ctx, NewContainer(env.dag, env.Config.Platform))
+	if err != nil {
+		return err
+	}
+	if err := env.RestoreContainer(ctx, base.From(image), sourceDir); err != nil {
+		return fmt.Errorf("failed to restore from %s: %w", image, err)
+	}
+
+	env.mu.Lock()
+	env.State.Checkpoint = image
+	env.mu.Unlock()
+	env.Notes.Add("Restore from checkpoint %s", image)
+	return nil
+}
+
+// RestoreContainer is like Restore, starting from a container such as one imported from an image tarball.
+func (env *Environment) RestoreContainer(ctx context.Context, base *dagger.Container, sourceDir *dagger.Directory) error {
+	container, err := env.build(ctx, base, sourceDir, false)
+	if err != nil {
+		return err
+	}
+	return env.apply(ctx, container)
+}
+
+// SyncWorkdir updates the workdir with sourceDir, the tree of the environment branch after it was merged
+// with or rebased onto other commits, and removes the files they deleted. Files only found in the
+// container, such as installed dependencies and build artifacts, are kept.
+func (env *Environment) SyncWorkdir(ctx context.Context, sourceDir *dagger.Directory, deleted []string, description string) error {
+	container := env.container().WithDirectory(env.Config.Workdir, sourceDir, dagger.ContainerWithDirectoryOpts{Owner: env.Config.User})
+	for _, file := range deleted {
+		container = container.WithoutFile(path.Join(env.Config.Workdir, file))
+	}
+	if err := env.apply(ctx, container); err != nil {
+		return fmt.Errorf("failed to sync the workdir: %w", err)
+	}
+	env.Notes.Add("Sync: %s", description)
+	return nil
+}
+
+// ExportImage writes the container of the environment to path as an OCI image tarball.
+func (env *Environment) ExportImage(ctx context.Context, path string) error {
+	_, err := env.withDefaultCommand(env.container()).Export(ctx, path)
+	return err
 }
@@ -3,7 +3,12 @@ package environment
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"dagger.io/dagger"
 )
 
 func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldReadEntireFile bool, startLineOneIndexedInclusive int, endLineOneIndexedInclusive int) (string, error) {
@@ -11,8 +16,13 @@ func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldR
 	if err != nil {
 		return "", err
 	}
+	return SelectLines(file, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+}
+
+// SelectLines returns the given range of lines of file, or all of it if shouldReadEntireFile is set.
+func SelectLines(file string, shouldReadEntireFile bool, startLineOneIndexedInclusive int, endLineOneIndexedInclusive int) (string, error) {
 	if shouldReadEntireFile {
-		return file, err
+		return file, nil
 	}
 
 	lines := strings.Split(file, "\n")
@@ -37,7 +47,7 @@ func (env *Environment) FileRead(ctx context.Context, targetFile string, shouldR
 }
 
 func (env *Environment) FileWrite(ctx context.Context, explanation, targetFile, contents string) error {
-	err := env.apply(ctx, env.container().WithNewFile(targetFile, contents))
+	err := env.apply(ctx, env.container().WithNewFile(targetFile, contents, dagger.ContainerWithNewFileOpts{Owner: env.Config.User}))
 	if err != nil {
 		return fmt.Errorf("failed applying file write, skipping git propagation: %w", err)
 	}
@@ -54,6 +64,39 @@ func (env *Environment) FileDelete(ctx context.Context, explanation, targetFile
 	return nil
 }
 
+// FileMove moves or renames a file or directory. The move is committed as a deletion plus an addition
+// of the same content, which git reports as a rename.
+func (env *Environment) FileMove(ctx context.Context, explanation, source, destination string) error {
+	container := env.container()
+
+	var newState *dagger.Container
+	if _, err := container.Directory(source).Sync(ctx); err == nil {
+		newState = container.
+			WithDirectory(destination, container.Directory(source), dagger.ContainerWithDirectoryOpts{Owner: env.Config.User}).
+			WithoutDirectory(source)
+	} else {
+		newState = container.
+			WithFile(destination, container.File(source), dagger.ContainerWithFileOpts{Owner: env.Config.User}).
+			WithoutFile(source)
+	}
+
+	if err := env.apply(ctx, newState); err != nil {
+		return fmt.Errorf("failed applying file move, skipping git propagation: %w", err)
+	}
+	env.Notes.Add("Move %s to %s", source, destination)
+	return nil
+}
+
+// Mkdir creates a directory, including any missing parents.
+func (env *Environment) Mkdir(ctx context.Context, explanation, path string) error {
+	err := env.apply(ctx, env.container().WithDirectory(path, env.dag.Directory(), dagger.ContainerWithDirectoryOpts{Owner: env.Config.User}))
+	if err != nil {
+		return fmt.Errorf("failed applying mkdir, skipping git propagation: %w", err)
+	}
+	env.Notes.Add("Create directory %s", path)
+	return nil
+}
+
 func (env *Environment) FileList(ctx context.Context, path string) (string, error) {
 	entries, err := env.container().Directory(path).Entries(ctx)
 	if err != nil {
@@ -65,3 +108,183 @@ func (env *Environment) FileList(ctx context.Context, path string) (string, erro
 	}
 	return out.String(), nil
 }
+
+// Download exports a file or directory from the environment container to a path on the host.
+// Unlike git propagation, this includes binary files and anything else present in the container.
+// destination is not checked: downloads made for agents must be allowed by the caller first.
+func (env *Environment) Download(ctx context.Context, source, destination string) (string, error) {
+	container := env.container()
+
+	// Directories and files are exported through different APIs, so figure out which one we're dealing with.
+	if _, err := container.Directory(source).Sync(ctx); err == nil {
+		return container.Directory(source).Export(ctx, destination)
+	}
+
+	exported, err := container.File(source).Export(ctx, destination, dagger.FileExportOpts{AllowParentDirPath: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to export %s: %w", source, err)
+	}
+	return exported, nil
+}
+
+const defaultSearchMaxResults = 100
+
+// FileSearchOpts controls the behavior of FileSearch.
+type FileSearchOpts struct {
+	// Path is the directory to search, absolute or relative to the workdir. Defaults to the workdir.
+	Path string
+	// Literal treats the pattern as a fixed string rather than a regular expression.
+	Literal    bool
+	IgnoreCase bool
+	// Include and Exclude are glob patterns matched against file names (e.g. `*.go`).
+	Include []string
+	Exclude []string
+	// ContextLines is the number of lines to show before and after each match.
+	ContextLines int
+	// MaxResults caps the number of output lines. Defaults to 100.
+	MaxResults int
+}
+
+// FileSearch searches the environment for pattern and returns matches formatted as `file:line:text`.
+// The search runs in a throwaway container: it never modifies the environment state.
+func (env *Environment) FileSearch(ctx context.Context, pattern string, opts FileSearchOpts) (string, error) {
+	result := env.container().WithExec(fileSearchArgs(pattern, opts), dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	})
+
+	exitCode, err := result.ExitCode(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get exit code: %w", err)
+	}
+
+	switch exitCode {
+	case 0:
+	case 1:
+		return "No matches found", nil
+	default:
+		stderr, _ := result.Stderr(ctx)
+		return "", fmt.Errorf("search failed with exit code %d: %s", exitCode, stderr)
+	}
+
+	stdout, err := result.Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get stdout: %w", err)
+	}
+
+	maxResults := opts.MaxResults
+	if maxResults <= 0 {
+		maxResults = defaultSearchMaxResults
+	}
+	return truncateLines(stdout, maxResults), nil
+}
+
+func fileSearchArgs(pattern string, opts FileSearchOpts) []string {
+	args := []string{"grep", "-rnI", "--color=never", "--exclude-dir=.git"}
+	if opts.Literal {
+		args = append(args, "-F")
+	} else {
+		args = append(args, "-E")
+	}
+	if opts.IgnoreCase {
+		args = append(args, "-i")
+	}
+	if opts.ContextLines > 0 {
+		args = append(args, fmt.Sprintf("-C%d", opts.ContextLines))
+	}
+	for _, include := range opts.Include {
+		args = append(args, "--include="+include)
+	}
+	for _, exclude := range opts.Exclude {
+		args = append(args, "--exclude="+exclude, "--exclude-dir="+exclude)
+	}
+
+	path := opts.Path
+	if path == "" {
+		path = "."
+	}
+	return append(args, "-e", pattern, "--", path)
+}
+
+// truncateLines keeps the first max lines of output, noting how many were dropped.
+func truncateLines(output string, max int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) <= max {
+		return strings.Join(lines, "\n")
+	}
+	return fmt.Sprintf("%s\n... (%d more lines truncated, narrow the search to see them)", strings.Join(lines[:max], "\n"), len(lines)-max)
+}
+
+const defaultGlobMaxResults = 1000
+
+// FileGlob returns the files under path matching any of the glob patterns (e.g. `**/*.go`), one per line.
+// When withMetadata is set, each entry also includes the file size and modification time.
+func (env *Environment) FileGlob(ctx context.Context, path string, patterns []string, withMetadata bool, maxResults int) (string, error) {
+	if path == "" {
+		path = "."
+	}
+	if maxResults <= 0 {
+		maxResults = defaultGlobMaxResults
+	}
+
+	dir := env.container().Directory(path)
+	matches := []string{}
+	for _, pattern := range patterns {
+		found, err := dir.Glob(ctx, pattern)
+		if err != nil {
+			return "", fmt.Errorf("failed to glob %q: %w", pattern, err)
+		}
+		matches = append(matches, found...)
+	}
+	slices.Sort(matches)
+	matches = slices.Compact(matches)
+
+	if len(matches) == 0 {
+		return "No files found", nil
+	}
+
+	truncated := 0
+	if len(matches) > maxResults {
+		truncated = len(matches) - maxResults
+		matches = matches[:maxResults]
+	}
+
+	out := &strings.Builder{}
+	if withMetadata {
+		if err := env.writeFileMetadata(ctx, out, path, matches); err != nil {
+			return "", err
+		}
+	} else {
+		for _, match := range matches {
+			fmt.Fprintf(out, "%s\n", match)
+		}
+	}
+	if truncated > 0 {
+		fmt.Fprintf(out, "... (%d more files truncated, narrow the patterns to see them)\n", truncated)
+	}
+	return out.String(), nil
+}
+
+// writeFileMetadata stats files (relative to dir) in a single exec and writes `name<TAB>size<TAB>mtime` lines.
+func (env *Environment) writeFileMetadata(ctx context.Context, out *strings.Builder, dir string, files []string) error {
+	args := append([]string{"stat", "-c", "%s\t%Y\t%n", "--"}, files...)
+	stdout, err := env.container().
+		WithWorkdir(dir).
+		WithExec(args).
+		Stdout(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to stat files: %w", err)
+	}
+
+	for line := range strings.Lines(stdout) {
+		fields := strings.SplitN(strings.TrimRight(line, "\n"), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, mtime, name := fields[0], fields[1], fields[2]
+		if epoch, err := strconv.ParseInt(mtime, 10, 64); err == nil {
+			mtime = time.Unix(epoch, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "%s\t%s bytes\t%s\n", name, size, mtime)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSearchArgs(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		args := fileSearchArgs("func main", FileSearchOpts{})
+		assert.Equal(t, []string{"grep", "-rnI", "--color=never", "--exclude-dir=.git", "-E", "-e", "func main", "--", "."}, args)
+	})
+
+	t.Run("all_options", func(t *testing.T) {
+		args := fileSearchArgs("TODO(", FileSearchOpts{
+			Path:         "src",
+			Literal:      true,
+			IgnoreCase:   true,
+			Include:      []string{"*.go"},
+			Exclude:      []string{"vendor"},
+			ContextLines: 2,
+		})
+		assert.Equal(t, []string{
+			"grep", "-rnI", "--color=never", "--exclude-dir=.git",
+			"-F", "-i", "-C2",
+			"--include=*.go",
+			"--exclude=vendor", "--exclude-dir=vendor",
+			"-e", "TODO(", "--", "src",
+		}, args)
+	})
+}
+
+func TestTruncateLines(t *testing.T) {
+	assert.Equal(t, "a\nb", truncateLines("a\nb\n", 2))
+	assert.Equal(t, "a\n... (2 more lines truncated, narrow the search to see them)", truncateLines("a\nb\nc\n", 1))
+}
@@ -0,0 +1,126 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// Forward is a port of a background process or service tunneled to the host.
+type Forward struct {
+	Port     int    `json:"port"`
+	HostPort int    `json:"host_port"`
+	Address  string `json:"address"`
+	// Target is the background process ID or service name the port belongs to.
+	Target string `json:"target"`
+}
+
+// ForwardPort tunnels port to hostPort on the host, or to the same port if hostPort is 0, so that
+// a human can reach it at a stable address. The tunnel lasts as long as the Dagger session.
+//
+// target is the ID of a background process or the name of a service. If empty, the most recent
+// background process exposing port is used, then the first service exposing it.
+func (env *Environment) ForwardPort(ctx context.Context, port, hostPort int, target string) (*Forward, error) {
+	if hostPort == 0 {
+		hostPort = port
+	}
+
+	target, svc, err := env.forwardTarget(ctx, port, target)
+	if err != nil {
+		return nil, err
+	}
+
+	tunnel, err := env.dag.Host().Tunnel(svc, dagger.HostTunnelOpts{
+		Ports: []dagger.PortForward{
+			{
+				Backend:  port,
+				Frontend: hostPort,
+				Protocol: dagger.NetworkProtocolTcp,
+			},
+		},
+	}).Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to forward port %d to host port %d (is it already in use?): %w", port, hostPort, err)
+	}
+
+	address, err := tunnel.Endpoint(ctx, dagger.ServiceEndpointOpts{Port: hostPort})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Forward{
+		Port:     port,
+		HostPort: hostPort,
+		Address:  address,
+		Target:   target,
+	}, nil
+}
+
+func (env *Environment) forwardTarget(ctx context.Context, port int, target string) (string, *dagger.Service, error) {
+	if target != "" {
+		if process, err := processes.get(env.ID, target); err == nil {
+			if process.svc == nil {
+				return "", nil, fmt.Errorf("process %s was started before container-use restarted, which stopped it", target)
+			}
+			return target, process.svc, nil
+		}
+		if env.Config.Services.Get(target) == nil {
+			return "", nil, fmt.Errorf("no background process or service named %q", target)
+		}
+	} else {
+		list := processes.list(env.ID)
+		for i := len(list) - 1; i >= 0; i-- {
+			if _, ok := list[i].Endpoints[port]; ok && list[i].Status == "running" && list[i].svc != nil {
+				return list[i].ID, list[i].svc, nil
+			}
+		}
+		for _, cfg := range env.Config.Services {
+			if slices.Contains(cfg.ExposedPorts, port) {
+				target = cfg.Name
+				break
+			}
+		}
+		if target == "" {
+			return "", nil, fmt.Errorf("no running background process or service exposes port %d", port)
+		}
+	}
+
+	// Services are not kept across tool calls: start them again, which reuses the running
+	// instances when their configuration didn't change.
+	if len(env.Services) == 0 {
+		services, err := env.startServices(ctx)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to start services: %w", err)
+		}
+		env.Services = services
+	}
+	for _, service := range env.Services {
+		if service.Config.Name == target {
+			return target, service.svc, nil
+		}
+	}
+	return "", nil, fmt.Errorf("service %s is not running", target)
+}
+
+// BackgroundCommand returns a background command recorded as exposing port, if any.
+// Endpoints are reset when the environment is rebuilt, so it was started on the current container.
+func (env *Environment) BackgroundCommand(port int) string {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+
+	keys := slices.Sorted(maps.Keys(env.State.Endpoints))
+	for _, key := range keys {
+		command, ok := strings.CutSuffix(key, " &")
+		if !ok {
+			continue
+		}
+		if _, ok := env.State.Endpoints[key][port]; ok {
+			return command
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HostEnvSecrets returns secret references (NAME=env://NAME) for the host environment variables whose
+// names match any of patterns, using shell wildcards (e.g. "AWS_*", "npm_config_*").
+// The values are resolved from the host each time the environment is built, so they are never written
+// to the repository.
+func HostEnvSecrets(patterns []string) ([]string, error) {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	secrets := []string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); matched {
+				secrets = append(secrets, fmt.Sprintf("%s=env://%s", name, name))
+				break
+			}
+		}
+	}
+	sort.Strings(secrets)
+	return secrets, nil
+}
+
+// ImportHostEnv adds secret references for the host variables matching patterns (see HostEnvSecrets)
+// and returns the names of the imported variables.
+func (config *EnvironmentConfig) ImportHostEnv(patterns []string) ([]string, error) {
+	secrets, err := HostEnvSecrets(patterns)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, secret := range secrets {
+		name, value, _ := strings.Cut(secret, "=")
+		config.Secrets.Set(name, value)
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,28 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostEnvSecrets(t *testing.T) {
+	t.Setenv("CU_TEST_AWS_REGION", "eu-west-1")
+	t.Setenv("CU_TEST_AWS_PROFILE", "dev")
+	t.Setenv("CU_TEST_NPM_REGISTRY", "https://registry.example.com")
+
+	secrets, err := HostEnvSecrets([]string{"CU_TEST_AWS_*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CU_TEST_AWS_PROFILE=env://CU_TEST_AWS_PROFILE",
+		"CU_TEST_AWS_REGION=env://CU_TEST_AWS_REGION",
+	}, secrets)
+
+	secrets, err = HostEnvSecrets([]string{"CU_TEST_NONE_*"})
+	require.NoError(t, err)
+	assert.Empty(t, secrets)
+
+	_, err = HostEnvSecrets([]string{"CU_TEST_["})
+	assert.Error(t, err)
+}
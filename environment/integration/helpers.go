@@ -212,18 +212,30 @@ func (u *UserActions) FileWrite(envID, targetFile, contents, explanation string)
 	require.NoError(u.t, err, "repo.Update after FileWrite should succeed")
 }
 
+// FileMove mirrors environment_file_move MCP tool behavior
+func (u *UserActions) FileMove(envID, source, destination, explanation string) {
+	env, err := u.repo.Get(u.ctx, u.dag, envID)
+	require.NoError(u.t, err, "Failed to get environment %s", envID)
+
+	err = env.FileMove(u.ctx, explanation, source, destination)
+	require.NoError(u.t, err, "FileMove should succeed")
+
+	err = u.repo.Update(u.ctx, env, explanation)
+	require.NoError(u.t, err, "repo.Update after FileMove should succeed")
+}
+
 // RunCommand mirrors environment_run_cmd MCP tool behavior
 func (u *UserActions) RunCommand(envID, command, explanation string) string {
 	env, err := u.repo.Get(u.ctx, u.dag, envID)
 	require.NoError(u.t, err, "Failed to get environment %s", envID)
 
-	output, err := env.Run(u.ctx, command, "/bin/sh", false)
+	result, err := env.Run(u.ctx, command, "/bin/sh", environment.RunOpts{})
 	require.NoError(u.t, err, "Run command should succeed")
 
 	err = u.repo.Update(u.ctx, env, explanation)
 	require.NoError(u.t, err, "repo.Update after Run should succeed")
 
-	return output
+	return result.Output()
 }
 
 // CreateEnvironment mirrors environment_create MCP tool behavior
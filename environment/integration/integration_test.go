@@ -59,6 +59,87 @@ func TestGitAuditTrail(t *testing.T) {
 	})
 }
 
+// TestFileMoveIsRecordedAsRename verifies that moving a file shows up as a rename in git history
+func TestFileMoveIsRecordedAsRename(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "move", SetupPythonRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Move Test", "Testing file moves")
+
+		user.FileMove(env.ID, "main.py", "app/main.py", "Move entrypoint into app package")
+
+		status, err := repository.RunGitCommand(context.Background(), user.WorktreePath(env.ID), "show", "--name-status", "--format=", "HEAD")
+		require.NoError(t, err)
+		assert.Contains(t, status, "R100\tmain.py\tapp/main.py")
+	})
+}
+
+// TestRunCommandResult verifies that exit codes and output streams are reported separately
+func TestRunCommandResult(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-result", SetupPythonRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Run Result Test", "Testing run results")
+
+		result, err := env.Run(context.Background(), "echo out; echo err >&2; exit 3", "sh", environment.RunOpts{})
+		require.NoError(t, err, "non-zero exits are not errors")
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "out\n", result.Stdout)
+		assert.Equal(t, "err\n", result.Stderr)
+		assert.False(t, result.TimedOut)
+	})
+}
+
+// TestRunCommandScope verifies that per-command workdir and env overrides don't leak into the environment
+func TestRunCommandScope(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "run-scope", SetupPythonRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Run Scope Test", "Testing per-command overrides")
+		user.RunCommand(env.ID, "mkdir -p sub", "Create subdirectory")
+
+		result, err := env.Run(context.Background(), `pwd; echo "$GREETING"`, "sh", environment.RunOpts{
+			Workdir: "sub",
+			Env:     []string{"GREETING=hello"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, env.Config.Workdir+"/sub\nhello\n", result.Stdout)
+
+		result, err = env.Run(context.Background(), `pwd; echo "[$GREETING]"`, "sh", environment.RunOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, env.Config.Workdir+"\n[]\n", result.Stdout)
+	})
+}
+
+// TestRunCommandTimeout verifies that a command exceeding its timeout is killed and its partial output kept
+func TestRunCommandTimeout(t *testing.T) {
+	t.Parallel()
+	if testing.Short() {
+		t.Skip("Skipping integration test")
+	}
+
+	WithRepository(t, "timeout", SetupPythonRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		env := user.CreateEnvironment("Timeout Test", "Testing command timeouts")
+
+		result, err := env.Run(context.Background(), "echo started; touch partial.txt; sleep 60", "sh", environment.RunOpts{Timeout: 2 * time.Second})
+		require.NoError(t, err)
+		assert.True(t, result.TimedOut)
+		assert.Contains(t, result.Stdout, "started")
+
+		_, err = env.FileRead(context.Background(), "partial.txt", true, 0, 0)
+		assert.NoError(t, err, "changes made before the timeout should be kept")
+	})
+}
+
 // TestEnvironmentIsolation verifies that changes in one environment don't affect others
 func TestEnvironmentIsolation(t *testing.T) {
 	t.Parallel()
@@ -32,6 +32,64 @@ func TestRepositoryCreate(t *testing.T) {
 	})
 }
 
+// TestRepositoryImport tests adopting an existing branch as an environment
+func TestRepositoryImport(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-import", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+
+		// Create a branch with work that isn't on the current branch
+		user.GitCommand("checkout", "-b", "feature")
+		user.WriteSourceFile("feature.txt", "work in progress\n")
+		user.GitCommand("add", "feature.txt")
+		user.GitCommand("commit", "-m", "WIP feature")
+		user.GitCommand("checkout", "-")
+
+		env, err := repo.Import(ctx, user.dag, "feature", "", "Importing feature branch")
+		require.NoError(t, err)
+		assert.Equal(t, "feature", env.State.Title)
+
+		// The environment starts from the tip of the imported branch
+		assert.Equal(t, "work in progress\n", user.FileRead(env.ID, "feature.txt"))
+
+		// Importing a missing branch fails
+		_, err = repo.Import(ctx, user.dag, "does-not-exist", "", "")
+		assert.Error(t, err)
+	})
+}
+
+// TestRepositoryFork tests copying an environment along with its container
+func TestRepositoryFork(t *testing.T) {
+	t.Parallel()
+	WithRepository(t, "repository-fork", SetupEmptyRepo, func(t *testing.T, repo *repository.Repository, user *UserActions) {
+		ctx := context.Background()
+
+		env := user.CreateEnvironment("Test Fork", "Testing repository fork")
+		user.FileWrite(env.ID, "tracked.txt", "tracked\n", "Add a tracked file")
+		user.RunCommand(env.ID, "echo built > /tmp/artifact", "Build outside of the workdir")
+
+		fork, err := repo.Fork(ctx, user.dag, env.ID, "", "Fork for an experiment")
+		require.NoError(t, err)
+		assert.NotEqual(t, env.ID, fork.ID)
+		assert.Equal(t, "Test Fork", fork.State.Title)
+
+		// Both the files and the container carry over
+		assert.Equal(t, "tracked\n", user.FileRead(fork.ID, "tracked.txt"))
+		assert.Equal(t, "built\n", user.RunCommand(fork.ID, "cat /tmp/artifact", "Read the artifact"))
+
+		// The fork is independent of the original
+		user.FileWrite(fork.ID, "tracked.txt", "changed\n", "Change the fork")
+		assert.Equal(t, "tracked\n", user.FileRead(env.ID, "tracked.txt"))
+		original, err := repo.Info(ctx, env.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Test Fork", original.State.Title)
+
+		// Forking a missing environment fails
+		_, err = repo.Fork(ctx, user.dag, "does-not-exist", "", "")
+		assert.Error(t, err)
+	})
+}
+
 // TestRepositoryGet tests retrieving an existing environment
 func TestRepositoryGet(t *testing.T) {
 	t.Parallel()
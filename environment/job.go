@@ -0,0 +1,249 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+	// JobLost is the status of jobs that were still running when the process tracking them exited.
+	JobLost = "lost"
+)
+
+// Job is a long-running command, such as a full test suite or a data migration, executed to completion in the
+// background of an environment. Unlike background processes, jobs are expected to finish and their result is
+// kept once they do. Jobs run on a snapshot of the environment container: changes they make to it are discarded.
+type Job struct {
+	ID         string     `json:"id"`
+	Command    string     `json:"command"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Status     string     `json:"status"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// jobTable tracks the jobs started by this process. Like background processes, they only run as long
+// as the Dagger session that started them.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs map[string]map[string]*Job // environment ID -> job ID -> job
+}
+
+var jobs = &jobTable{jobs: map[string]map[string]*Job{}}
+
+func (t *jobTable) add(envID string, job *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.jobs[envID] == nil {
+		t.jobs[envID] = map[string]*Job{}
+	}
+	t.jobs[envID][job.ID] = job
+}
+
+func (t *jobTable) get(envID, id string) (*Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	job, ok := t.jobs[envID][id]
+	if !ok {
+		return nil, fmt.Errorf("job %q was not started in this session of environment %s", id, envID)
+	}
+	return job, nil
+}
+
+// snapshot returns a copy of job that is safe to read while it runs.
+func (t *jobTable) snapshot(job *Job) *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &Job{
+		ID:         job.ID,
+		Command:    job.Command,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		Status:     job.Status,
+		ExitCode:   job.ExitCode,
+		Error:      job.Error,
+	}
+}
+
+func (t *jobTable) list(envID string) []*Job {
+	t.mu.Lock()
+	list := make([]*Job, 0, len(t.jobs[envID]))
+	for _, job := range t.jobs[envID] {
+		list = append(list, job)
+	}
+	t.mu.Unlock()
+
+	for i, job := range list {
+		list[i] = t.snapshot(job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// TrackedJob returns the current state of job id of environment envID if it was started by this process.
+func TrackedJob(envID, id string) (*Job, bool) {
+	job, err := jobs.get(envID, id)
+	if err != nil {
+		return nil, false
+	}
+	return jobs.snapshot(job), true
+}
+
+// StartJob runs command as a job and returns immediately. onDone is called with the final state of the job
+// and its whole output once it finishes, e.g. to persist them. opts.Timeout, if set, bounds the job duration.
+func (env *Environment) StartJob(ctx context.Context, command, shell string, opts RunOpts, onDone func(job *Job, output string)) (*Job, error) {
+	container, _, err := env.withCommandScope(ctx, env.container(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newProcessID()
+	container = container.
+		WithMountedCache(processDir, env.processVolume(), dagger.ContainerWithMountedCacheOpts{
+			Sharing: dagger.CacheSharingModeShared,
+		}).
+		// Never serve a job from cache: it is expected to run every time
+		WithEnvVariable("CONTAINER_USE_JOB_ID", id)
+	script := fmt.Sprintf(`exec >>"$0.log" 2>&1; %s -c "$1"; code=$?; echo $code >"$0.exit"; exit $code`, shell)
+	args := []string{shell, "-c", script, processDir + "/" + id, limitCommand(command, shell, opts)}
+	container, args, useEntrypoint, _, err := env.withNetworkPolicy(ctx, container, args, opts.UseEntrypoint)
+	if err != nil {
+		return nil, err
+	}
+	result := container.WithExec(args, dagger.ContainerWithExecOpts{
+		UseEntrypoint:            useEntrypoint,
+		Expect:                   dagger.ReturnTypeAny,
+		InsecureRootCapabilities: env.Config.Network.Enforced(),
+	})
+
+	// The job outlives the tool call that started it
+	var jobCtx context.Context
+	var cancel context.CancelFunc
+	if opts.Timeout > 0 {
+		jobCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+	} else {
+		jobCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	job := &Job{
+		ID:        id,
+		Command:   command,
+		StartedAt: time.Now(),
+		Status:    JobRunning,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	jobs.add(env.ID, job)
+	env.Notes.Add("$ %s (job %s)", command, id)
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+
+		exitCode, err := result.ExitCode(jobCtx)
+
+		jobs.mu.Lock()
+		now := time.Now()
+		job.FinishedAt = &now
+		switch {
+		case errors.Is(jobCtx.Err(), context.Canceled):
+			job.Status = JobCanceled
+		case errors.Is(jobCtx.Err(), context.DeadlineExceeded):
+			job.Status = JobFailed
+			job.Error = fmt.Sprintf("timed out after %s", opts.Timeout)
+		case err != nil:
+			job.Status = JobFailed
+			job.Error = err.Error()
+		default:
+			job.ExitCode = &exitCode
+			job.Status = JobSucceeded
+			if exitCode != 0 {
+				job.Status = JobFailed
+			}
+		}
+		jobs.mu.Unlock()
+
+		output, err := env.readProcessFiles(context.WithoutCancel(ctx), fmt.Sprintf(`cat %s/%s.log 2>/dev/null; true`, processDir, id))
+		if err != nil {
+			output = fmt.Sprintf("failed to read the job output: %s", err)
+		}
+		if onDone != nil {
+			onDone(jobs.snapshot(job), output)
+		}
+	}()
+
+	return jobs.snapshot(job), nil
+}
+
+// Jobs returns the jobs started by this process in the environment, oldest first.
+func (env *Environment) Jobs() []*Job {
+	return jobs.list(env.ID)
+}
+
+// JobLogs returns the output of a running job. If tail is positive, only the last tail lines are returned.
+func (env *Environment) JobLogs(ctx context.Context, id string, tail int) (string, error) {
+	if _, err := jobs.get(env.ID, id); err != nil {
+		return "", err
+	}
+	lines := "+1"
+	if tail > 0 {
+		lines = strconv.Itoa(tail)
+	}
+	return env.readProcessFiles(ctx, fmt.Sprintf(`tail -n %s %s/%s.log 2>/dev/null; true`, lines, processDir, id))
+}
+
+// WaitJob waits up to timeout for job id to finish and returns its state, which is still running on timeout.
+// The job is only waited for once it has been fully recorded, i.e. after onDone returned.
+func (env *Environment) WaitJob(ctx context.Context, id string, timeout time.Duration) (*Job, error) {
+	job, err := jobs.get(env.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-job.done:
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return jobs.snapshot(job), nil
+}
+
+// CancelJob stops job id. It has no effect if the job already finished.
+func (env *Environment) CancelJob(ctx context.Context, id string) (*Job, error) {
+	job, err := jobs.get(env.ID, id)
+	if err != nil {
+		return nil, err
+	}
+	if jobs.snapshot(job).Status != JobRunning {
+		return jobs.snapshot(job), nil
+	}
+	job.cancel()
+
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	env.Notes.Add("Cancel job %s (%s)", id, job.Command)
+	return jobs.snapshot(job), nil
+}
@@ -0,0 +1,166 @@
+package environment
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// minMaskedSecretLength is the length under which secret values are not masked: short values such as
+// "1" or "dev" appear everywhere, masking them would garble output without protecting anything.
+const minMaskedSecretLength = 4
+
+// SecretMasker redacts the values of secrets from text. A nil *SecretMasker masks nothing.
+type SecretMasker struct {
+	replacer *strings.Replacer
+	values   []string
+}
+
+// NewSecretMasker returns a masker replacing the values of secrets, a map of names to plaintext values,
+// with a placeholder naming the secret.
+func NewSecretMasker(secrets map[string]string) *SecretMasker {
+	type replacement struct{ value, name string }
+	replacements := []replacement{}
+	for name, value := range secrets {
+		// Files usually end with a newline that isn't part of the secret as used
+		for _, v := range []string{value, strings.TrimSpace(value)} {
+			if len(v) >= minMaskedSecretLength && !slices.ContainsFunc(replacements, func(r replacement) bool { return r.value == v }) {
+				replacements = append(replacements, replacement{v, name})
+			}
+		}
+	}
+	if len(replacements) == 0 {
+		return nil
+	}
+	// Longer values first, so that a secret containing another is masked as a whole
+	slices.SortFunc(replacements, func(a, b replacement) int {
+		return cmp.Or(len(b.value)-len(a.value), strings.Compare(a.value, b.value))
+	})
+
+	m := &SecretMasker{}
+	oldnew := make([]string, 0, 2*len(replacements))
+	for _, r := range replacements {
+		oldnew = append(oldnew, r.value, maskedSecret(r.name))
+		m.values = append(m.values, r.value)
+	}
+	m.replacer = strings.NewReplacer(oldnew...)
+	return m
+}
+
+func maskedSecret(name string) string {
+	return fmt.Sprintf("[REDACTED:%s]", name)
+}
+
+// Mask returns s with the values of secrets replaced.
+func (m *SecretMasker) Mask(s string) string {
+	if m == nil || s == "" {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+// Contains reports whether s contains the value of a secret.
+func (m *SecretMasker) Contains(s string) bool {
+	if m == nil {
+		return false
+	}
+	for _, value := range m.values {
+		if strings.Contains(s, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskers caches the SecretMasker of each list of secret references, as a *maskerEntry: environments are
+// loaded for every tool call, resolving their secrets every time would be slow, and may prompt the user.
+var maskers sync.Map
+
+// maskerTTL is how long a cached SecretMasker is used before the secrets are resolved again, so that the
+// values of rotated secrets get masked too.
+var maskerTTL = 5 * time.Minute
+
+type maskerEntry struct {
+	masker  *SecretMasker
+	expires time.Time
+}
+
+// cachedMasker returns the cached masker of the secrets joined in key, unless it expired.
+func cachedMasker(key string) (*SecretMasker, bool) {
+	entry, ok := maskers.Load(key)
+	if !ok || time.Now().After(entry.(*maskerEntry).expires) {
+		return nil, false
+	}
+	return entry.(*maskerEntry).masker, true
+}
+
+// cacheMasker caches masker for the secrets joined in key for maskerTTL, and drops the expired entries,
+// e.g. those of secrets no environment has anymore.
+func cacheMasker(key string, masker *SecretMasker) {
+	now := time.Now()
+	maskers.Range(func(key, entry any) bool {
+		if now.After(entry.(*maskerEntry).expires) {
+			maskers.Delete(key)
+		}
+		return true
+	})
+	maskers.Store(key, &maskerEntry{masker: masker, expires: now.Add(maskerTTL)})
+}
+
+// SecretMasker returns the masker of the secrets of the environment and of its services, see
+// NewEnvironmentSecretMasker.
+func (env *Environment) SecretMasker(ctx context.Context) *SecretMasker {
+	return NewEnvironmentSecretMasker(ctx, env.dag, env.EnvironmentInfo)
+}
+
+// NewEnvironmentSecretMasker returns the masker of the secrets of the environment info describes and of its
+// services, resolved with dag. Secret values are resolved at most once every maskerTTL per process and list
+// of secrets; those that can't be resolved are not masked, as they can't leak into the environment either.
+func NewEnvironmentSecretMasker(ctx context.Context, dag *dagger.Client, info *EnvironmentInfo) *SecretMasker {
+	secrets := slices.Clone(info.Config.Secrets)
+	for _, service := range info.Config.Services {
+		secrets = append(secrets, service.Secrets...)
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	key := strings.Join(secrets, "\n")
+	if masker, ok := cachedMasker(key); ok {
+		return masker
+	}
+
+	values := map[string]string{}
+	for _, secret := range secrets {
+		name, reference, found := strings.Cut(secret, "=")
+		if !found {
+			continue
+		}
+		if isOnePasswordReference(reference) && CheckOnePassword(ctx) != nil {
+			continue
+		}
+		value, err := secretPlaintext(ctx, dag, reference)
+		if err != nil {
+			slog.Warn("Failed to resolve secret to mask it", "environment.id", info.ID, "secret", name, "err", err)
+			continue
+		}
+		values[name] = value
+	}
+	masker := NewSecretMasker(values)
+	cacheMasker(key, masker)
+	return masker
+}
+
+// MaskSecrets returns s with the values of the secrets of the environment replaced.
+func (env *Environment) MaskSecrets(ctx context.Context, s string) string {
+	if s == "" {
+		return s
+	}
+	return env.SecretMasker(ctx).Mask(s)
+}
@@ -0,0 +1,51 @@
+package environment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretMasker(t *testing.T) {
+	masker := NewSecretMasker(map[string]string{
+		"API_KEY":     "sk-live-123456",
+		"API_KEY_OLD": "sk-live-123456-old",
+		"PRIVATE_KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----\n",
+		"DEBUG":       "1",
+	})
+
+	assert.Equal(t, "key=[REDACTED:API_KEY] old=[REDACTED:API_KEY_OLD]", masker.Mask("key=sk-live-123456 old=sk-live-123456-old"))
+	assert.Equal(t, "[REDACTED:PRIVATE_KEY]", masker.Mask("-----BEGIN KEY-----\nabc\n-----END KEY-----"), "trailing newlines are optional")
+	assert.Equal(t, "DEBUG=1", masker.Mask("DEBUG=1"), "short values are not masked")
+	assert.True(t, masker.Contains("Authorization: Bearer sk-live-123456"))
+	assert.False(t, masker.Contains("nothing to see"))
+
+	var none *SecretMasker
+	assert.Nil(t, NewSecretMasker(map[string]string{"DEBUG": "1"}))
+	assert.Equal(t, "sk-live-123456", none.Mask("sk-live-123456"))
+	assert.False(t, none.Contains("sk-live-123456"))
+}
+
+func TestMaskerCache(t *testing.T) {
+	defer func(ttl time.Duration) { maskerTTL = ttl }(maskerTTL)
+	maskerTTL = time.Hour
+	masker := NewSecretMasker(map[string]string{"API_KEY": "sk-live-123456"})
+
+	cacheMasker("API_KEY=env://API_KEY", masker)
+	cached, ok := cachedMasker("API_KEY=env://API_KEY")
+	assert.True(t, ok)
+	assert.Same(t, masker, cached)
+	_, ok = cachedMasker("API_KEY=env://OTHER_KEY")
+	assert.False(t, ok)
+
+	maskerTTL = -time.Second
+	cacheMasker("TOKEN=env://TOKEN", nil)
+	_, ok = cachedMasker("TOKEN=env://TOKEN")
+	assert.False(t, ok, "expired maskers are resolved again")
+	cacheMasker("OTHER=env://OTHER", nil)
+	_, ok = maskers.Load("API_KEY=env://API_KEY")
+	assert.True(t, ok, "entries are only dropped once expired")
+	_, ok = maskers.Load("TOKEN=env://TOKEN")
+	assert.False(t, ok, "expired entries are dropped")
+}
@@ -0,0 +1,126 @@
+package environment
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/mitchellh/go-homedir"
+)
+
+// Mount makes a host directory or file, such as a dataset, a package mirror or a toolchain, available
+// in the environment. The container gets a snapshot of it: changes made in the environment never reach
+// the host.
+type Mount struct {
+	// Source is the host path, absolute or relative to the home directory (~/...).
+	Source string `json:"source" yaml:"source"`
+	// Target is the absolute path in the container.
+	Target string `json:"target" yaml:"target"`
+}
+
+// ParseMount parses a SOURCE:TARGET mount specification.
+func ParseMount(spec string) (Mount, error) {
+	source, target, ok := strings.Cut(spec, ":")
+	if !ok || source == "" || target == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q, expected HOST_PATH:CONTAINER_PATH", spec)
+	}
+	return Mount{Source: source, Target: target}, nil
+}
+
+func (m Mount) String() string {
+	return m.Source + ":" + m.Target
+}
+
+// MountNotAllowedError reports a mount whose source is outside of the host directories allowed by the
+// global configuration.
+type MountNotAllowedError struct {
+	Source  string
+	Allowed []string
+}
+
+func (e *MountNotAllowedError) Error() string {
+	if len(e.Allowed) == 0 {
+		return fmt.Sprintf("mounting %s is not allowed: no host directory may be mounted, list those that may in [mounts] allow of the global config.toml", e.Source)
+	}
+	return fmt.Sprintf("mounting %s is not allowed, only paths within %s may be mounted (see [mounts] allow of the global config.toml)", e.Source, strings.Join(e.Allowed, ", "))
+}
+
+// hostMountPath returns the host path of source, with symbolic links resolved so that a link can't
+// point outside of the allowed directories.
+func hostMountPath(source string) (string, error) {
+	expanded, err := homedir.Expand(source)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(expanded) {
+		return "", fmt.Errorf("mount source %q must be an absolute path or start with ~/", source)
+	}
+	return filepath.EvalSymlinks(expanded)
+}
+
+// validateMounts checks the mounts of the configuration: absolute targets, outside of the workdir and of
+// the caches, and sources within AllowedMounts.
+func (config *EnvironmentConfig) validateMounts() error {
+	var allowed []string
+	for _, dir := range config.AllowedMounts {
+		resolved, err := hostMountPath(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid allowed mount %s: %w", dir, err)
+		}
+		allowed = append(allowed, resolved)
+	}
+
+	for _, m := range config.Mounts {
+		target := path.Clean(m.Target)
+		switch {
+		case !path.IsAbs(m.Target):
+			return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+		case target == "/":
+			return fmt.Errorf("mount target %q can't be the root directory", m.Target)
+		case isWithin(target, config.Workdir) || isWithin(config.Workdir, target):
+			return fmt.Errorf("mount target %q overlaps the workdir %s, whose files are tracked in git", m.Target, config.Workdir)
+		}
+		for _, dir := range config.Caches {
+			if isWithin(target, path.Clean(dir)) || isWithin(path.Clean(dir), target) {
+				return fmt.Errorf("mount target %q overlaps the cache %s", m.Target, dir)
+			}
+		}
+
+		source, err := hostMountPath(m.Source)
+		if err != nil {
+			return fmt.Errorf("invalid mount source: %w", err)
+		}
+		if !slices.ContainsFunc(allowed, func(dir string) bool { return isWithin(source, dir) }) {
+			return &MountNotAllowedError{Source: m.Source, Allowed: config.AllowedMounts}
+		}
+	}
+	return nil
+}
+
+// withMounts mounts the host paths of the configuration in container.
+func (env *Environment) withMounts(container *dagger.Container) (*dagger.Container, error) {
+	if err := env.Config.validateMounts(); err != nil {
+		return nil, err
+	}
+	for _, m := range env.Config.Mounts {
+		source, _ := hostMountPath(m.Source)
+		info, err := os.Stat(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount source: %w", err)
+		}
+		if info.IsDir() {
+			container = container.WithMountedDirectory(path.Clean(m.Target), env.dag.Host().Directory(source))
+		} else {
+			container = container.WithMountedFile(path.Clean(m.Target), env.dag.Host().File(source))
+		}
+	}
+	return container, nil
+}
@@ -0,0 +1,66 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMount(t *testing.T) {
+	mount, err := ParseMount("~/datasets:/data")
+	require.NoError(t, err)
+	assert.Equal(t, Mount{Source: "~/datasets", Target: "/data"}, mount)
+	assert.Equal(t, "~/datasets:/data", mount.String())
+
+	for _, spec := range []string{"~/datasets", ":/data", "~/datasets:"} {
+		_, err := ParseMount(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestEnvironmentConfig_ValidateMounts(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	datasets := filepath.Join(home, "datasets")
+	secrets := filepath.Join(home, ".ssh")
+	require.NoError(t, os.MkdirAll(filepath.Join(datasets, "imagenet"), 0o755))
+	require.NoError(t, os.MkdirAll(secrets, 0o700))
+	require.NoError(t, os.Symlink(secrets, filepath.Join(datasets, "escape")))
+
+	config := &EnvironmentConfig{
+		Workdir:       "/workdir",
+		Caches:        []string{"/root/.cache"},
+		AllowedMounts: []string{"~/datasets", "/nonexistent"},
+		Mounts:        []Mount{{Source: "~/datasets/imagenet", Target: "/data/imagenet"}, {Source: datasets, Target: "/data/all"}},
+	}
+	require.NoError(t, config.validateMounts())
+
+	var notAllowed *MountNotAllowedError
+	for _, mount := range []Mount{
+		{Source: secrets, Target: "/data/ssh"},
+		{Source: "~/datasets/escape", Target: "/data/ssh"},
+		{Source: "~/datasets/../.ssh", Target: "/data/ssh"},
+	} {
+		config.Mounts = []Mount{mount}
+		assert.ErrorAs(t, config.validateMounts(), &notAllowed, mount.Source)
+	}
+
+	for _, mount := range []Mount{
+		{Source: datasets, Target: "data"},
+		{Source: datasets, Target: "/"},
+		{Source: datasets, Target: "/workdir/data"},
+		{Source: datasets, Target: "/root/.cache/data"},
+		{Source: "datasets", Target: "/data"},
+		{Source: "~/missing", Target: "/data"},
+	} {
+		config.Mounts = []Mount{mount}
+		assert.Error(t, config.validateMounts(), mount.String())
+	}
+
+	config.AllowedMounts = nil
+	config.Mounts = []Mount{{Source: datasets, Target: "/data"}}
+	assert.ErrorContains(t, config.validateMounts(), "no host directory may be mounted")
+}
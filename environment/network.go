@@ -0,0 +1,232 @@
+package environment
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// NetworkMode is how much of the network commands of an environment can reach.
+type NetworkMode string
+
+const (
+	// NetworkFull doesn't restrict the network. It is the default.
+	NetworkFull NetworkMode = "full"
+	// NetworkAllowlist only lets commands reach the hosts and networks of NetworkPolicy.Allow.
+	NetworkAllowlist NetworkMode = "allowlist"
+	// NetworkNone only lets commands reach the services of the environment.
+	NetworkNone NetworkMode = "none"
+)
+
+// NetworkModes are the valid network modes.
+var NetworkModes = []NetworkMode{NetworkFull, NetworkAllowlist, NetworkNone}
+
+// networkToolsDir is where the tools enforcing network policies are mounted while a command runs.
+const networkToolsDir = "/run/container-use/network"
+
+// dropCapabilities are dropped from the bounding set of the commands of environments with a network
+// policy, which need extra capabilities to set up their firewall: only the default capabilities of Docker
+// containers are kept, but for NET_RAW. Their inheritable and ambient sets are cleared too, so that no
+// capability outside of the bounding set survives an exec.
+const dropCapabilities = "-all,+chown,+dac_override,+fowner,+fsetid,+kill,+setgid,+setuid,+setpcap,+net_bind_service,+sys_chroot,+mknod,+audit_write,+setfcap"
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// NetworkPolicy restricts the outbound connections of the commands, setup commands, background
+// processes and services of an environment. Services of the environment are always reachable. In
+// allowlist mode, so are DNS servers, to resolve names: DNS queries are not filtered. In none mode, DNS
+// is blocked so that it can't carry data out, and the names of services are resolved when each command
+// starts instead.
+type NetworkPolicy struct {
+	Mode NetworkMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Allow lists the host names, IP addresses and CIDR ranges reachable in allowlist mode. Host names
+	// are resolved when each command starts.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+}
+
+// Enforced reports whether the policy restricts anything.
+func (p NetworkPolicy) Enforced() bool {
+	return p.Mode == NetworkAllowlist || p.Mode == NetworkNone
+}
+
+// Active returns the policy with its mode set, full by default.
+func (p NetworkPolicy) Active() NetworkPolicy {
+	p.Mode = cmp.Or(p.Mode, NetworkFull)
+	return p
+}
+
+// Validate returns an error if the mode or an allowed destination is invalid.
+func (p NetworkPolicy) Validate() error {
+	if p.Mode != "" && !slices.Contains(NetworkModes, p.Mode) {
+		return fmt.Errorf("unknown network mode %q, expected one of full, allowlist, none", p.Mode)
+	}
+	if len(p.Allow) > 0 && p.Mode != NetworkAllowlist {
+		return fmt.Errorf("allowed destinations only apply to the allowlist network mode, not %q", cmp.Or(p.Mode, NetworkFull))
+	}
+	for _, destination := range p.Allow {
+		if err := validateDestination(destination); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateDestination(destination string) error {
+	if net.ParseIP(destination) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(destination); err == nil {
+		return nil
+	}
+	if hostnamePattern.MatchString(destination) {
+		return nil
+	}
+	return fmt.Errorf("invalid network destination %q, expected a host name, an IP address or a CIDR range", destination)
+}
+
+// networkTools returns the root file system of a container holding iptables, setpriv and getent, for the
+// platform of the environment. The tools are run with its dynamic loader, whatever the distribution of
+// the environment.
+func (env *Environment) networkTools() *dagger.Directory {
+	return NewContainer(env.dag, env.Config.Platform).
+		From(alpineImage).
+		WithExec([]string{"apk", "add", "--no-cache", "iptables", "ip6tables", "setpriv", "musl-utils"}).
+		Rootfs()
+}
+
+// networkPolicyScript sets up the firewall of the network namespace of a command, then runs the
+// command, given as arguments, without the capabilities that would let it change the firewall.
+// It expects the tools of networkTools in networkToolsDir, the network mode in $CONTAINER_USE_NETWORK_MODE,
+// the destinations to allow in $CONTAINER_USE_NETWORK_ALLOW, and optionally a user to run the command as in
+// $CONTAINER_USE_NETWORK_USER. In none mode, the destinations are resolved before the firewall blocks DNS
+// and pinned in a copy of /etc/hosts mounted over it, which doesn't end up in the container.
+const networkPolicyScript = `set -e
+R=` + networkToolsDir + `
+set -- "$R"/lib/ld-musl-*.so.1 "$@"
+L="$1"; shift
+run() { "$L" --library-path "$R/lib:$R/usr/lib" "$@"; }
+export XTABLES_LIBDIR="$R/usr/lib/xtables"
+ipt() { run "$R/sbin/iptables" -w "$@"; }
+ip6t() { run "$R/sbin/ip6tables" -w "$@"; }
+v6() { [ -e /proc/net/if_inet6 ]; }
+
+if [ "$CONTAINER_USE_NETWORK_MODE" = none ]; then
+	run "$R/bin/busybox" cat /etc/hosts >"$R/hosts"
+	allow=
+	for destination in $CONTAINER_USE_NETWORK_ALLOW; do
+		addresses=$(run "$R/usr/bin/getent" hosts "$destination" | while read -r address _; do echo "$address"; done)
+		[ -n "$addresses" ] || echo "container-use: can't resolve $destination, it is not reachable" >&2
+		for address in $addresses; do
+			echo "$address $destination" >>"$R/hosts"
+			allow="$allow $address"
+		done
+	done
+	CONTAINER_USE_NETWORK_ALLOW=$allow
+	run "$R/bin/busybox" mount --bind "$R/hosts" /etc/hosts
+fi
+
+ipt -F OUTPUT; ipt -P OUTPUT DROP
+ipt -A OUTPUT -o lo -j ACCEPT
+ipt -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+if v6; then
+	ip6t -F OUTPUT; ip6t -P OUTPUT DROP
+	ip6t -A OUTPUT -o lo -j ACCEPT
+	ip6t -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT
+fi
+if [ "$CONTAINER_USE_NETWORK_MODE" != none ]; then
+	while read -r key server _; do
+		[ "$key" = nameserver ] || continue
+		case "$server" in
+		*:*) v6 && for p in udp tcp; do ip6t -A OUTPUT -d "$server" -p $p --dport 53 -j ACCEPT; done ;;
+		*) for p in udp tcp; do ipt -A OUTPUT -d "$server" -p $p --dport 53 -j ACCEPT; done ;;
+		esac
+	done </etc/resolv.conf
+fi
+for destination in $CONTAINER_USE_NETWORK_ALLOW; do
+	case "$destination" in
+	*:*) v6 && ip6t -A OUTPUT -d "$destination" -j ACCEPT ;;
+	*[!0-9./]*)
+		ipt -A OUTPUT -d "$destination" -j ACCEPT 2>/dev/null || echo "container-use: can't resolve $destination, it is not reachable" >&2
+		v6 && { ip6t -A OUTPUT -d "$destination" -j ACCEPT 2>/dev/null || true; } ;;
+	*) ipt -A OUTPUT -d "$destination" -j ACCEPT ;;
+	esac
+done
+
+unset XTABLES_LIBDIR CONTAINER_USE_NETWORK_MODE CONTAINER_USE_NETWORK_ALLOW
+user="$CONTAINER_USE_NETWORK_USER"; unset CONTAINER_USE_NETWORK_USER
+if [ -n "$user" ]; then
+	uid="${user%%:*}"; gid="${user#*:}"
+	[ "$gid" != "$user" ] || gid=$(id -g "$uid" 2>/dev/null || echo "$uid")
+	groups=--init-groups
+	id "$uid" >/dev/null 2>&1 || groups=--clear-groups
+	exec "$L" --library-path "$R/lib:$R/usr/lib" "$R/bin/setpriv" --inh-caps=-all --ambient-caps=-all --bounding-set ` + dropCapabilities + ` --reuid "$uid" --regid "$gid" $groups -- "$@"
+fi
+exec "$L" --library-path "$R/lib:$R/usr/lib" "$R/bin/setpriv" --inh-caps=-all --ambient-caps=-all --bounding-set ` + dropCapabilities + ` -- "$@"
+`
+
+// withNetworkPolicy prepares container to run args under the network policy of the environment. It
+// returns the container and arguments to run instead, whether to use the entrypoint, and a function
+// reverting the changes on a container derived from the result. The entrypoint is made part of the
+// arguments, since it must run under the policy too. Commands run under a policy need the
+// InsecureRootCapabilities to set up their firewall, which they drop before starting.
+func (env *Environment) withNetworkPolicy(ctx context.Context, container *dagger.Container, args []string, useEntrypoint bool) (*dagger.Container, []string, bool, func(*dagger.Container) *dagger.Container, error) {
+	policy := env.Config.Network
+	if !policy.Enforced() {
+		return container, args, useEntrypoint, func(c *dagger.Container) *dagger.Container { return c }, nil
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, nil, false, nil, err
+	}
+
+	if len(args) == 0 {
+		defaultArgs, err := container.DefaultArgs(ctx)
+		if err != nil {
+			return nil, nil, false, nil, err
+		}
+		args = defaultArgs
+	}
+	if useEntrypoint {
+		entrypoint, err := container.Entrypoint(ctx)
+		if err != nil {
+			return nil, nil, false, nil, err
+		}
+		args = append(entrypoint, args...)
+	}
+	user, err := container.User(ctx)
+	if err != nil {
+		return nil, nil, false, nil, err
+	}
+	if user == "root" || user == "0" || strings.HasPrefix(user, "0:") || strings.HasPrefix(user, "root:") {
+		user = ""
+	}
+
+	allow := slices.Clone(policy.Allow)
+	for _, service := range env.Config.Services {
+		allow = append(allow, service.Hostnames()...)
+	}
+
+	container = container.
+		WithMountedDirectory(networkToolsDir, env.networkTools()).
+		WithUser("root").
+		WithEnvVariable("CONTAINER_USE_NETWORK_MODE", string(policy.Mode)).
+		WithEnvVariable("CONTAINER_USE_NETWORK_ALLOW", strings.Join(allow, " ")).
+		WithEnvVariable("CONTAINER_USE_NETWORK_USER", user)
+	restore := func(c *dagger.Container) *dagger.Container {
+		c = c.
+			WithoutMount(networkToolsDir).
+			WithoutEnvVariable("CONTAINER_USE_NETWORK_MODE").
+			WithoutEnvVariable("CONTAINER_USE_NETWORK_ALLOW").
+			WithoutEnvVariable("CONTAINER_USE_NETWORK_USER")
+		if user == "" {
+			return c.WithUser("")
+		}
+		return c.WithUser(user)
+	}
+	return container, append([]string{"sh", "-c", networkPolicyScript, "container-use-network"}, args...), false, restore, nil
+}
@@ -0,0 +1,58 @@
+package environment
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkPolicy_Validate(t *testing.T) {
+	valid := []NetworkPolicy{
+		{},
+		{Mode: NetworkFull},
+		{Mode: NetworkNone},
+		{Mode: NetworkAllowlist},
+		{Mode: NetworkAllowlist, Allow: []string{"proxy.golang.org", "10.0.0.1", "10.0.0.0/8", "2001:db8::1", "2001:db8::/32", "localhost"}},
+	}
+	for _, policy := range valid {
+		assert.NoError(t, policy.Validate(), "%+v", policy)
+	}
+
+	tests := []struct {
+		policy NetworkPolicy
+		err    string
+	}{
+		{NetworkPolicy{Mode: "offline"}, "unknown network mode"},
+		{NetworkPolicy{Allow: []string{"example.com"}}, `only apply to the allowlist network mode, not "full"`},
+		{NetworkPolicy{Mode: NetworkNone, Allow: []string{"example.com"}}, "only apply to the allowlist network mode"},
+		{NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"https://example.com"}}, "invalid network destination"},
+		{NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"example.com:443"}}, "invalid network destination"},
+		{NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"10.0.0.0/33"}}, "invalid network destination"},
+		{NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"-example.com"}}, "invalid network destination"},
+		{NetworkPolicy{Mode: NetworkAllowlist, Allow: []string{"$(reboot)"}}, "invalid network destination"},
+	}
+	for _, tt := range tests {
+		assert.ErrorContains(t, tt.policy.Validate(), tt.err, "%+v", tt.policy)
+	}
+}
+
+func TestNetworkPolicy_Enforced(t *testing.T) {
+	assert.False(t, NetworkPolicy{}.Enforced())
+	assert.False(t, NetworkPolicy{Mode: NetworkFull}.Enforced())
+	assert.True(t, NetworkPolicy{Mode: NetworkNone}.Enforced())
+	assert.True(t, NetworkPolicy{Mode: NetworkAllowlist}.Enforced())
+
+	assert.Equal(t, NetworkFull, NetworkPolicy{}.Active().Mode)
+	assert.Equal(t, NetworkNone, NetworkPolicy{Mode: NetworkNone}.Active().Mode)
+}
+
+func TestNetworkPolicyScript_Syntax(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	out, err := exec.Command(sh, "-n", "-c", networkPolicyScript).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
@@ -0,0 +1,124 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// onePasswordTokenEnv is the token of a 1Password service account. When set, the 1Password CLI signs in
+	// with it rather than through the desktop app, which is what headless servers need.
+	onePasswordTokenEnv = "OP_SERVICE_ACCOUNT_TOKEN"
+	// OnePasswordTokenFileEnv is a file holding the token of a 1Password service account, for deployments
+	// that mount secrets as files rather than passing them in the environment.
+	OnePasswordTokenFileEnv = "CONTAINER_USE_OP_TOKEN_FILE"
+
+	// onePasswordCheckTTL is how long the availability of the 1Password CLI is cached.
+	onePasswordCheckTTL = 30 * time.Second
+)
+
+// OnePasswordError reports that op:// secrets could not be resolved because the 1Password CLI is missing
+// or not signed in. Other secrets are unaffected.
+type OnePasswordError struct {
+	// Reason is what is wrong with the 1Password CLI.
+	Reason string
+	// Secrets are the names of the secrets that were left unset.
+	Secrets []string
+}
+
+func (e *OnePasswordError) Error() string {
+	msg := "1Password " + e.Reason
+	if len(e.Secrets) > 0 {
+		msg += fmt.Sprintf(": secrets %s were not set", strings.Join(e.Secrets, ", "))
+	}
+	return msg + ". " + onePasswordFix
+}
+
+const onePasswordFix = "Install the 1Password CLI (https://developer.1password.com/docs/cli/get-started/) and sign in with `op signin`, " +
+	"or set " + onePasswordTokenEnv + " (or " + OnePasswordTokenFileEnv + ") to a service account token on headless servers, then update the environment."
+
+var (
+	// lookPath and runOnePassword are replaced in tests.
+	lookPath       = exec.LookPath
+	runOnePassword = func(ctx context.Context, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, "op", args...).CombinedOutput()
+	}
+
+	onePasswordMu        sync.Mutex
+	onePasswordCheckedAt time.Time
+	onePasswordErr       error
+)
+
+// CheckOnePassword returns a *OnePasswordError if the 1Password CLI can't resolve secrets. The outcome is
+// cached briefly, since environments with several op:// secrets check it for each of them.
+func CheckOnePassword(ctx context.Context) error {
+	onePasswordMu.Lock()
+	defer onePasswordMu.Unlock()
+	if time.Since(onePasswordCheckedAt) < onePasswordCheckTTL {
+		return onePasswordErr
+	}
+	onePasswordErr = checkOnePassword(ctx)
+	onePasswordCheckedAt = time.Now()
+	return onePasswordErr
+}
+
+func checkOnePassword(ctx context.Context) error {
+	if _, err := lookPath("op"); err != nil {
+		return &OnePasswordError{Reason: "CLI (op) is not installed"}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := runOnePassword(ctx, "whoami")
+	if err == nil {
+		return nil
+	}
+	output := strings.TrimSpace(string(out))
+	switch {
+	case ctx.Err() != nil:
+		return &OnePasswordError{Reason: "CLI did not respond, it may be waiting for the desktop app to be unlocked"}
+	case strings.Contains(output, "not signed in") || strings.Contains(output, "no accounts configured"):
+		return &OnePasswordError{Reason: "CLI is not signed in"}
+	case strings.Contains(output, "locked"):
+		return &OnePasswordError{Reason: "is locked"}
+	case os.Getenv(onePasswordTokenEnv) != "":
+		return &OnePasswordError{Reason: fmt.Sprintf("rejected the service account token: %s", output)}
+	default:
+		return &OnePasswordError{Reason: fmt.Sprintf("CLI failed: %s", output)}
+	}
+}
+
+// LoadOnePasswordToken sets OP_SERVICE_ACCOUNT_TOKEN from the file named by CONTAINER_USE_OP_TOKEN_FILE,
+// unless it's already set. It must be called before connecting to Dagger, which resolves op:// secrets
+// in the session it starts with the environment of this process.
+func LoadOnePasswordToken() error {
+	file := os.Getenv(OnePasswordTokenFileEnv)
+	if file == "" || os.Getenv(onePasswordTokenEnv) != "" {
+		return nil
+	}
+	token, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read the 1Password service account token: %w", err)
+	}
+	if len(strings.TrimSpace(string(token))) == 0 {
+		return fmt.Errorf("the 1Password service account token file %s is empty", file)
+	}
+	return os.Setenv(onePasswordTokenEnv, strings.TrimSpace(string(token)))
+}
+
+// isOnePasswordReference reports whether the secret reference is resolved by 1Password.
+func isOnePasswordReference(reference string) bool {
+	return strings.HasPrefix(reference, "op://")
+}
+
+// asOnePasswordError returns err as a *OnePasswordError, if it is one.
+func asOnePasswordError(err error) (*OnePasswordError, bool) {
+	var opErr *OnePasswordError
+	ok := errors.As(err, &opErr)
+	return opErr, ok
+}
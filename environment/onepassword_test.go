@@ -0,0 +1,102 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOnePassword replaces the 1Password CLI for the duration of the test.
+func stubOnePassword(t *testing.T, installed bool, whoami string, whoamiErr error) {
+	t.Helper()
+	origLookPath, origRun := lookPath, runOnePassword
+	t.Cleanup(func() {
+		lookPath, runOnePassword = origLookPath, origRun
+		onePasswordCheckedAt = time.Time{}
+	})
+	lookPath = func(string) (string, error) {
+		if !installed {
+			return "", errors.New("not found")
+		}
+		return "/usr/local/bin/op", nil
+	}
+	runOnePassword = func(context.Context, ...string) ([]byte, error) {
+		return []byte(whoami), whoamiErr
+	}
+	onePasswordCheckedAt = time.Time{}
+}
+
+func TestCheckOnePassword(t *testing.T) {
+	failed := errors.New("exit status 1")
+	tests := []struct {
+		name      string
+		installed bool
+		whoami    string
+		whoamiErr error
+		reason    string
+	}{
+		{name: "signed in", installed: true, whoami: "URL: https://my.1password.com"},
+		{name: "missing", reason: "CLI (op) is not installed"},
+		{name: "signed out", installed: true, whoami: "[ERROR] 2025/07/01 account is not signed in", whoamiErr: failed, reason: "CLI is not signed in"},
+		{name: "other failure", installed: true, whoami: "[ERROR] connection refused", whoamiErr: failed, reason: "CLI failed: [ERROR] connection refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOnePassword(t, tt.installed, tt.whoami, tt.whoamiErr)
+			err := CheckOnePassword(context.Background())
+			if tt.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			opErr, ok := asOnePasswordError(err)
+			require.True(t, ok, "expected a *OnePasswordError, got %v", err)
+			assert.Equal(t, tt.reason, opErr.Reason)
+		})
+	}
+}
+
+func TestUnavailableOnePasswordSecretsAreSkipped(t *testing.T) {
+	stubOnePassword(t, false, "", nil)
+
+	// Only op:// secrets, so that the missing Dagger client is never used
+	_, err := containerWithEnvAndSecrets(context.Background(), nil, nil, nil, []string{
+		"API_KEY=op://vault/api/key",
+		"DB_PASSWORD=op://vault/db/password",
+	})
+	opErr, ok := asOnePasswordError(err)
+	require.True(t, ok, "expected a *OnePasswordError, got %v", err)
+	assert.Equal(t, []string{"API_KEY", "DB_PASSWORD"}, opErr.Secrets)
+	assert.Contains(t, err.Error(), "secrets API_KEY, DB_PASSWORD were not set")
+	assert.Contains(t, err.Error(), onePasswordTokenEnv)
+
+	env := &Environment{}
+	assert.NoError(t, env.withSecretsWarning(err))
+	assert.Equal(t, []string{err.Error()}, env.Warnings)
+	assert.Error(t, env.withSecretsWarning(errors.New("invalid secret")))
+}
+
+func TestLoadOnePasswordToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("ops_abc123\n"), 0600))
+
+	t.Setenv(onePasswordTokenEnv, "")
+	os.Unsetenv(onePasswordTokenEnv)
+	t.Setenv(OnePasswordTokenFileEnv, tokenFile)
+	require.NoError(t, LoadOnePasswordToken())
+	assert.Equal(t, "ops_abc123", os.Getenv(onePasswordTokenEnv))
+
+	// A token set explicitly wins
+	t.Setenv(onePasswordTokenEnv, "ops_explicit")
+	require.NoError(t, LoadOnePasswordToken())
+	assert.Equal(t, "ops_explicit", os.Getenv(onePasswordTokenEnv))
+
+	os.Unsetenv(onePasswordTokenEnv)
+	t.Setenv(OnePasswordTokenFileEnv, filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, LoadOnePasswordToken())
+}
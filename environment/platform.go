@@ -0,0 +1,35 @@
+package environment
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// platformArchitectures are the architectures an environment can be forced to run on. Running on
+// another architecture than the host's relies on emulation, and is much slower.
+var platformArchitectures = []string{"amd64", "arm64"}
+
+// ValidatePlatform returns an error if platform is neither empty, for the platform of the Dagger
+// engine, nor one of linux/amd64 and linux/arm64.
+func ValidatePlatform(platform string) error {
+	if platform == "" {
+		return nil
+	}
+	goos, arch, _ := strings.Cut(platform, "/")
+	if goos != "linux" || !slices.Contains(platformArchitectures, arch) {
+		return fmt.Errorf("unsupported platform %q, expected linux/%s", platform, strings.Join(platformArchitectures, " or linux/"))
+	}
+	return nil
+}
+
+// NewContainer returns an empty container for platform, or for the platform of the Dagger engine if
+// it's empty.
+func NewContainer(dag *dagger.Client, platform string) *dagger.Container {
+	if platform == "" {
+		return dag.Container()
+	}
+	return dag.Container(dagger.ContainerOpts{Platform: dagger.Platform(platform)})
+}
@@ -0,0 +1,16 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePlatform(t *testing.T) {
+	for _, platform := range []string{"", "linux/amd64", "linux/arm64"} {
+		assert.NoError(t, ValidatePlatform(platform), platform)
+	}
+	for _, platform := range []string{"amd64", "linux", "linux/386", "darwin/arm64", "windows/amd64"} {
+		assert.ErrorContains(t, ValidatePlatform(platform), "unsupported platform", platform)
+	}
+}
@@ -0,0 +1,464 @@
+package environment
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	policyFile = "policy.yaml"
+
+	// pipe stands for a pipe between two simple commands in the output of simpleCommands.
+	pipe = "|"
+)
+
+// DefaultDeniedCommands are denied unless the policy ignores the defaults or explicitly allows them:
+// they reach outside of the environment, to shared remotes, package registries or live infrastructure,
+// or they are destructive beyond repair.
+var DefaultDeniedCommands = []string{
+	"git push",
+	"terraform apply",
+	"terraform destroy",
+	"tofu apply",
+	"tofu destroy",
+	"pulumi up",
+	"pulumi destroy",
+	"kubectl",
+	"helm install",
+	"helm upgrade",
+	"helm uninstall",
+	"npm publish",
+	"yarn publish",
+	"pnpm publish",
+	"cargo publish",
+	"twine upload",
+	"poetry publish",
+	"uv publish",
+	"gem push",
+	"rm -rf /",
+	"rm --no-preserve-root",
+	"curl | sh",
+	"curl | bash",
+	"wget | sh",
+	"wget | bash",
+}
+
+// PolicyAction is what happens to a command matching a pattern of a CommandPolicy.
+type PolicyAction string
+
+const (
+	// PolicyDeny refuses to run the command.
+	PolicyDeny PolicyAction = "deny"
+	// PolicyRequireApproval runs the command only once the user approved it.
+	PolicyRequireApproval PolicyAction = "require_approval"
+)
+
+// CommandPolicy restricts the commands agents can run. It is advisory, a safety net against mistakes
+// rather than a sandbox: commands can be disguised in ways it doesn't see through, e.g. in a script
+// written first or in a variable.
+// It also holds the other settings applying to the changes of environments, like the scanning of changes
+// for secrets.
+//
+// Patterns are sequences of words, the first of which names a program: a pattern matches a command
+// when it runs that program with the other words among its arguments, in order. "git push" matches
+// `git -C app push origin` and `cd app && git push`, but not `git commit -m "push it"`.
+// Short options match in any order and grouping: "rm -rf /" matches `rm -r -f /` and `rm -fr /*`.
+// Other words may contain * and ? wildcards. Patterns can also describe pipelines, such as "curl | sh",
+// which matches `curl -fsSL https://example.com/install.sh | sudo sh -s`.
+type CommandPolicy struct {
+	// Deny lists patterns of commands that must not run, in addition to DefaultDeniedCommands.
+	Deny []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// RequireApproval lists patterns of commands that only run once the user approved them.
+	RequireApproval []string `json:"require_approval,omitempty" yaml:"require_approval,omitempty"`
+	// Allow lists patterns of commands that may run even though they match a deny or require_approval
+	// pattern, e.g. "kubectl get" while "kubectl" is denied.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// IgnoreDefaults disables DefaultDeniedCommands.
+	IgnoreDefaults bool `json:"ignore_defaults,omitempty" yaml:"ignore_defaults,omitempty"`
+	// Approval configures how commands requiring approval are approved.
+	Approval ApprovalSettings `json:"approval,omitempty" yaml:"approval,omitempty"`
+	// SecretScanning configures the scanning of the changes of environments for credentials.
+	SecretScanning SecretScanSettings `json:"secret_scanning,omitempty" yaml:"secret_scanning,omitempty"`
+	// BinaryFiles configures how the binary files of environments are committed.
+	BinaryFiles BinaryFileSettings `json:"binary_files,omitempty" yaml:"binary_files,omitempty"`
+
+	// file is where the policy was read from, relative to the repository.
+	file string
+}
+
+// DefaultApprovalTimeout is how long commands requiring approval wait for a decision by default.
+const DefaultApprovalTimeout = 10 * time.Minute
+
+// ApprovalSettings configure how commands requiring approval are approved. By default they are rejected,
+// and the user approves them ahead of the agent's next attempt.
+type ApprovalSettings struct {
+	// Wait parks commands requiring approval until the user approves or denies them, instead of rejecting
+	// them right away.
+	Wait bool `json:"wait,omitempty" yaml:"wait,omitempty"`
+	// Timeout is how long parked commands wait for a decision before being rejected, DefaultApprovalTimeout
+	// if zero.
+	Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Notify shows a desktop notification when a command is parked.
+	Notify bool `json:"notify,omitempty" yaml:"notify,omitempty"`
+}
+
+// PolicyViolationError is returned for commands denied by a CommandPolicy, or that need to be approved.
+type PolicyViolationError struct {
+	// Command is the part of the command that matched, e.g. a single command of a pipeline.
+	Command string
+	Pattern string
+	Action  PolicyAction
+	// Policy is the file of the policy, relative to the repository.
+	Policy string
+}
+
+func (e *PolicyViolationError) Error() string {
+	if e.Action == PolicyRequireApproval {
+		return fmt.Sprintf("policy violation: %q requires the approval of the user, by pattern %q of the repository policy (%s): ask the user to approve it, then run it again",
+			e.Command, e.Pattern, e.Policy)
+	}
+	return fmt.Sprintf("policy violation: %q is denied by pattern %q. Commands are restricted by the repository policy (%s): do not try to work around it, ask the user to run this command instead",
+		e.Command, e.Pattern, e.Policy)
+}
+
+// LoadCommandPolicy reads the command policy of the repository at baseDir, from policy.yaml.
+// Repositories without a policy get the default one.
+func LoadCommandPolicy(baseDir string) (*CommandPolicy, error) {
+	policy := &CommandPolicy{file: path.Join(configDir, policyFile)}
+	data, err := os.ReadFile(filepath.Join(baseDir, configDir, policyFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(policy); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid %s: %w", policy.file, err)
+		}
+	}
+
+	if err := policy.SecretScanning.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", policy.file, err)
+	}
+	if err := policy.BinaryFiles.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", policy.file, err)
+	}
+	if policy.Approval.Timeout < 0 {
+		return nil, fmt.Errorf("invalid %s: negative approval timeout", policy.file)
+	}
+	if policy.Approval.Timeout == 0 {
+		policy.Approval.Timeout = DefaultApprovalTimeout
+	}
+
+	for _, pattern := range slices.Concat(policy.Deny, policy.RequireApproval, policy.Allow) {
+		for _, stage := range strings.Split(pattern, pipe) {
+			if len(strings.Fields(stage)) == 0 {
+				return nil, fmt.Errorf("invalid %s: empty pattern in %q", policy.file, pattern)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Check returns a *PolicyViolationError if command runs any command denied by the policy, or that
+// requires approval. Denials take precedence. Every command of pipelines, lists and substitutions is
+// checked, as well as the scripts given to shells with -c.
+func (p *CommandPolicy) Check(command string) error {
+	deny := p.Deny
+	if !p.IgnoreDefaults {
+		deny = slices.Concat(DefaultDeniedCommands, deny)
+	}
+	commands := simpleCommands(command)
+	for _, rule := range []struct {
+		patterns []string
+		action   PolicyAction
+	}{
+		{deny, PolicyDeny},
+		{p.RequireApproval, PolicyRequireApproval},
+	} {
+		for i := range commands {
+			pattern, end := matchingPattern(rule.patterns, commands, i)
+			if pattern == "" {
+				continue
+			}
+			if allowed, _ := matchingPattern(p.Allow, commands, i); allowed != "" {
+				continue
+			}
+			return &PolicyViolationError{
+				Command: joinCommands(commands[i:end]),
+				Pattern: pattern,
+				Action:  rule.action,
+				Policy:  cmp.Or(p.file, path.Join(configDir, policyFile)),
+			}
+		}
+	}
+	return nil
+}
+
+// joinCommands renders the words of simple commands, as returned by simpleCommands, as a command line.
+func joinCommands(commands [][]string) string {
+	parts := make([]string, len(commands))
+	for i, words := range commands {
+		parts[i] = strings.Join(words, " ")
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchingPattern returns the first of patterns matching the simple commands starting at commands[i],
+// if any, along with the end of the commands it matched.
+func matchingPattern(patterns []string, commands [][]string, i int) (string, int) {
+	for _, pattern := range patterns {
+		if end, ok := matchPipeline(strings.Split(pattern, pipe), commands, i); ok {
+			return pattern, end
+		}
+	}
+	return "", 0
+}
+
+// matchPipeline matches the stages of a pattern against the commands starting at commands[i], which
+// must be piped into each other.
+func matchPipeline(stages []string, commands [][]string, i int) (int, bool) {
+	for n, stage := range stages {
+		if n > 0 {
+			if i >= len(commands) || !isPipe(commands[i]) {
+				return 0, false
+			}
+			i++
+		}
+		if i >= len(commands) || isPipe(commands[i]) || !matchWords(strings.Fields(stage), commands[i]) {
+			return 0, false
+		}
+		i++
+	}
+	return i, true
+}
+
+func isPipe(words []string) bool {
+	return len(words) == 1 && words[0] == pipe
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	// Programs match by name, wherever they're installed, unless the pattern gives a path
+	program := words[0]
+	if !strings.Contains(pattern[0], "/") {
+		program = path.Base(program)
+	}
+	if !matchWord(pattern[0], program) {
+		return false
+	}
+	options, pattern := shortOptions(pattern[1:])
+	given, _ := shortOptions(words[1:])
+	for option := range options {
+		if !given[option] {
+			return false
+		}
+	}
+	for _, word := range words[1:] {
+		if len(pattern) == 0 {
+			break
+		}
+		if matchWord(pattern[0], word) || matchWord(pattern[0], rootPath(word)) {
+			pattern = pattern[1:]
+		}
+	}
+	return len(pattern) == 0
+}
+
+var shortOptionsPattern = regexp.MustCompile(`^-[A-Za-z0-9]+$`)
+
+// shortOptions returns the letters of the short options among words, such as -rf, and the other words.
+func shortOptions(words []string) (map[rune]bool, []string) {
+	options := map[rune]bool{}
+	others := []string{}
+	for _, word := range words {
+		if !shortOptionsPattern.MatchString(word) {
+			others = append(others, word)
+			continue
+		}
+		for _, option := range word[1:] {
+			options[option] = true
+		}
+	}
+	return options, others
+}
+
+// rootPath returns / for the paths standing for everything under the root, such as /* or /., and word
+// otherwise.
+func rootPath(word string) string {
+	if strings.HasPrefix(word, "/") && strings.Trim(word, "/*.") == "" {
+		return "/"
+	}
+	return word
+}
+
+// matchWord matches word against pattern, where * matches any sequence of characters, including
+// slashes, and ? any single character.
+func matchWord(pattern, word string) bool {
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == word
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	matched, _ := regexp.MatchString("^"+expr+"$", word)
+	return matched
+}
+
+// commandPrefixes run the command that follows them, after their own options.
+var commandPrefixes = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "command": true, "exec": true, "time": true,
+	"nohup": true, "nice": true, "ionice": true, "timeout": true, "xargs": true, "watch": true,
+}
+
+var shells = map[string]bool{"sh": true, "bash": true, "zsh": true, "dash": true, "ash": true, "ksh": true}
+
+// shellKeywords start compound commands, or their parts, before the command they run.
+var shellKeywords = map[string]bool{
+	"if": true, "then": true, "elif": true, "else": true, "while": true, "until": true, "do": true, "!": true,
+}
+
+// simpleCommands splits a shell command into the words of the simple commands it runs. Variable
+// assignments, shell keywords and command prefixes such as sudo are dropped, so that the program comes
+// first. The scripts given to eval and to shells with -c are split too.
+// This is a best effort lexer: it knows about quotes, escapes and control operators, not about
+// the full shell grammar.
+func simpleCommands(command string) [][]string {
+	commands := [][]string{}
+	for _, words := range splitCommands(command) {
+		// Skip assignments and prefixes, along with the options of prefixes
+		for len(words) > 0 {
+			if name, _, ok := strings.Cut(words[0], "="); ok && name != "" && !strings.ContainsAny(name, "/-") {
+				words = words[1:]
+				continue
+			}
+			if shellKeywords[words[0]] {
+				words = words[1:]
+				continue
+			}
+			if !commandPrefixes[path.Base(words[0])] {
+				break
+			}
+			words = words[1:]
+			for len(words) > 0 && (strings.HasPrefix(words[0], "-") || strings.Contains(words[0], "=") || isNumber(words[0])) {
+				words = words[1:]
+			}
+		}
+		if len(words) == 0 {
+			continue
+		}
+		commands = append(commands, words)
+
+		if path.Base(words[0]) == "eval" {
+			commands = append(commands, simpleCommands(strings.Join(words[1:], " "))...)
+		}
+		if shells[path.Base(words[0])] {
+			for i := 1; i < len(words)-1; i++ {
+				if strings.HasPrefix(words[i], "-") && !strings.HasPrefix(words[i], "--") && strings.Contains(words[i], "c") {
+					commands = append(commands, simpleCommands(words[i+1])...)
+					break
+				}
+			}
+		}
+		// Substitutions within double quotes are kept in words by splitCommands
+		for _, word := range words {
+			for _, marker := range []string{"$(", "`"} {
+				if _, script, ok := strings.Cut(word, marker); ok {
+					commands = append(commands, simpleCommands(script)...)
+				}
+			}
+		}
+	}
+	return commands
+}
+
+// isNumber reports whether s is a number or a duration, such as the arguments of nice -n or timeout.
+func isNumber(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9' && strings.Trim(s, "0123456789.smhd") == ""
+}
+
+// splitCommands splits command on control operators and substitutions, and each part into words.
+func splitCommands(command string) [][]string {
+	commands := [][]string{}
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			commands = append(commands, words)
+			words = []string{}
+		}
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\' && i+1 < len(runes):
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case c == '\'':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != '\''; i++ {
+				word.WriteRune(runes[i])
+			}
+		case c == '"':
+			inWord = true
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune(`"\$`+"`", runes[i+1]) {
+					i++
+				}
+				word.WriteRune(runes[i])
+			}
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			// Command substitution: check its commands on their own
+			i++
+			endCommand()
+		case c == '|' && i+1 < len(runes) && runes[i+1] == '|':
+			i++
+			endCommand()
+		case c == '|':
+			// Keep track of pipes, for patterns of pipelines
+			endCommand()
+			commands = append(commands, []string{pipe})
+		case c == '&' && i > 0 && (runes[i-1] == '>' || runes[i-1] == '<'):
+			// Redirection to a file descriptor, e.g. 2>&1
+			word.WriteRune(c)
+			inWord = true
+		case c == ';' || c == '&' || c == '\n' || c == '(' || c == ')' || c == '`' || c == '{' && !inWord || c == '}' && !inWord:
+			endCommand()
+		case c == ' ' || c == '\t':
+			endWord()
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return commands
+}
@@ -0,0 +1,295 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/mitchellh/go-homedir"
+)
+
+// processDir is where background processes write their output and exit status.
+// It is backed by a cache volume shared between the processes and the containers reading their logs.
+const processDir = "/.container-use/processes"
+
+// Process is a command running in the background of an environment.
+type Process struct {
+	ID        string           `json:"id"`
+	Command   string           `json:"command"`
+	StartedAt time.Time        `json:"started_at"`
+	Endpoints EndpointMappings `json:"endpoints,omitempty"`
+
+	// Status is "running", "exited", "killed", or "lost" for processes that were still running when the
+	// container-use process that started them exited, which stops them. ExitCode is only set once the
+	// process has exited.
+	Status   string `json:"status"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+
+	// svc is nil for processes started by another container-use process, see restore.
+	svc *dagger.Service
+}
+
+// processTable tracks background processes. They only live as long as the Dagger session that
+// started them: the environment state records them too, so that they are still listed, with their
+// logs, once that session is over.
+type processTable struct {
+	mu        sync.Mutex
+	processes map[string]map[string]*Process // environment ID -> process ID -> process
+}
+
+var processes = &processTable{processes: map[string]map[string]*Process{}}
+
+func (t *processTable) add(envID string, process *Process) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.processes[envID] == nil {
+		t.processes[envID] = map[string]*Process{}
+	}
+	t.processes[envID][process.ID] = process
+}
+
+func (t *processTable) get(envID, id string) (*Process, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	process, ok := t.processes[envID][id]
+	if !ok {
+		return nil, fmt.Errorf("process %q not found in environment %s", id, envID)
+	}
+	return process, nil
+}
+
+func (t *processTable) list(envID string) []*Process {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]*Process, 0, len(t.processes[envID]))
+	for _, process := range t.processes[envID] {
+		list = append(list, process)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// restore adds the processes recorded in the state of environment envID that this process doesn't know
+// about, e.g. after a restart.
+func (t *processTable) restore(envID string, recorded []*Process) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, process := range recorded {
+		if _, ok := t.processes[envID][process.ID]; ok {
+			continue
+		}
+		if t.processes[envID] == nil {
+			t.processes[envID] = map[string]*Process{}
+		}
+		restored := *process
+		t.processes[envID][process.ID] = &restored
+	}
+}
+
+// recordProcesses saves the background processes of the environment in its state.
+func (env *Environment) recordProcesses() {
+	list := processes.list(env.ID)
+	recorded := make([]*Process, len(list))
+	processes.mu.Lock()
+	for i, process := range list {
+		saved := *process
+		saved.svc = nil
+		recorded[i] = &saved
+	}
+	processes.mu.Unlock()
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.State.Processes = recorded
+}
+
+func newProcessID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (env *Environment) processVolume() *dagger.CacheVolume {
+	return env.dag.CacheVolume(cacheVolumeName("processes", env.ID))
+}
+
+// cacheVolumeName names a cache volume of environment id. Engines can be shared by several users
+// and machines, whose environment IDs may collide: volumes are namespaced by machine and user so
+// that they never see the data of each other.
+func cacheVolumeName(kind, id string) string {
+	return fmt.Sprintf("container-use-%s-%s-%d-%s", kind, machineID(), os.Getuid(), id)
+}
+
+// machineID identifies the machine in the names of cache volumes. Hostnames aren't stable (they
+// change on every start of a container), so a random ID is generated once and stored in the
+// container-use config directory. If it can't be stored, the hostname is used instead.
+var machineID = sync.OnceValue(func() string {
+	dir := os.Getenv("CONTAINER_USE_CONFIG_DIR")
+	if dir == "" {
+		dir = "~/.config/container-use"
+	}
+	path, err := homedir.Expand(filepath.Join(dir, "machine-id"))
+	if err == nil {
+		if id, err := loadMachineID(path); err == nil {
+			return id
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return host
+})
+
+// loadMachineID reads the machine ID at path, creating it if it doesn't exist yet.
+func loadMachineID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && len(bytes.TrimSpace(data)) > 0 {
+		return string(bytes.TrimSpace(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Another process may be creating it concurrently: whichever comes first wins.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if os.IsExist(err) {
+		return loadMachineID(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(id + "\n"); err != nil {
+		return "", err
+	}
+	return id, f.Close()
+}
+
+// withProcessLogging mounts the process directory in container and wraps command so that its
+// output and exit status are recorded there under the process ID.
+func (env *Environment) withProcessLogging(container *dagger.Container, id, command, shell string) (*dagger.Container, []string) {
+	container = container.WithMountedCache(processDir, env.processVolume(), dagger.ContainerWithMountedCacheOpts{
+		Sharing: dagger.CacheSharingModeShared,
+	})
+	script := fmt.Sprintf(`exec >>"$0.log" 2>&1; %s -c "$1"; echo $? >"$0.exit"`, shell)
+	return container, []string{shell, "-c", script, processDir + "/" + id, command}
+}
+
+// readProcessFiles runs script in a throwaway container that can see the output of background processes,
+// and returns its output with the values of secrets masked.
+func (env *Environment) readProcessFiles(ctx context.Context, script string) (string, error) {
+	out, err := env.container().
+		WithMountedCache(processDir, env.processVolume(), dagger.ContainerWithMountedCacheOpts{
+			Sharing: dagger.CacheSharingModeShared,
+		}).
+		WithEnvVariable("CONTAINER_USE_CACHEBUST", time.Now().String()).
+		WithExec([]string{"sh", "-c", script}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		}).
+		Stdout(ctx)
+	return env.MaskSecrets(ctx, out), err
+}
+
+// Processes returns the background processes started in the environment, oldest first.
+func (env *Environment) Processes(ctx context.Context) ([]*Process, error) {
+	list := processes.list(env.ID)
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	// Collect the exit status of processes that have finished
+	out, err := env.readProcessFiles(ctx, fmt.Sprintf(`cd %s && for f in *.exit; do [ -f "$f" ] && echo "${f%%.exit} $(cat "$f")"; done; true`, processDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read process status: %w", err)
+	}
+	exitCodes := map[string]int{}
+	for line := range strings.SplitSeq(strings.TrimSpace(out), "\n") {
+		id, code, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if exitCode, err := strconv.Atoi(strings.TrimSpace(code)); err == nil {
+			exitCodes[id] = exitCode
+		}
+	}
+
+	processes.mu.Lock()
+	for _, process := range list {
+		if exitCode, ok := exitCodes[process.ID]; ok && process.Status == "running" {
+			process.Status = "exited"
+			process.ExitCode = &exitCode
+		}
+		if process.Status == "running" && process.svc == nil {
+			process.Status = "lost"
+		}
+	}
+	processes.mu.Unlock()
+
+	env.recordProcesses()
+	return list, nil
+}
+
+// ProcessLogs returns the output of a background process.
+// If tail is positive, only the last tail lines are returned.
+// If follow is positive, output produced during that period is included before returning.
+func (env *Environment) ProcessLogs(ctx context.Context, id string, tail int, follow time.Duration) (string, error) {
+	if _, err := processes.get(env.ID, id); err != nil {
+		return "", err
+	}
+
+	lines := "+1"
+	if tail > 0 {
+		lines = strconv.Itoa(tail)
+	}
+	script := fmt.Sprintf(`tail -n %s %s/%s.log`, lines, processDir, id)
+	if follow > 0 {
+		script = fmt.Sprintf(`timeout %d tail -n %s -f %s/%s.log; true`, int(follow.Seconds()), lines, processDir, id)
+	}
+	return env.readProcessFiles(ctx, script)
+}
+
+// ProcessKill stops a background process.
+func (env *Environment) ProcessKill(ctx context.Context, id string) error {
+	process, err := processes.get(env.ID, id)
+	if err != nil {
+		return err
+	}
+	if process.svc == nil {
+		return fmt.Errorf("process %s was started before container-use restarted, which stopped it", id)
+	}
+	if _, err := process.svc.Stop(ctx, dagger.ServiceStopOpts{Kill: true}); err != nil {
+		return fmt.Errorf("failed to stop process %s: %w", id, err)
+	}
+
+	processes.mu.Lock()
+	if process.Status == "running" {
+		process.Status = "killed"
+	}
+	processes.mu.Unlock()
+	env.recordProcesses()
+
+	env.Notes.Add("Kill %s (%s &)", id, process.Command)
+	return nil
+}
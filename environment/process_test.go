@@ -0,0 +1,60 @@
+package environment
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessTable_Restore(t *testing.T) {
+	envID := "restore-" + newProcessID()
+	exitCode := 0
+	started := time.Now()
+	env := &Environment{EnvironmentInfo: &EnvironmentInfo{ID: envID, State: &State{}}}
+
+	processes.add(envID, &Process{ID: "web", Command: "npm start", StartedAt: started, Status: "running"})
+	processes.add(envID, &Process{ID: "build", Command: "make", StartedAt: started.Add(time.Second), Status: "exited", ExitCode: &exitCode})
+	env.recordProcesses()
+
+	data, err := env.State.Marshal()
+	require.NoError(t, err)
+	var state State
+	require.NoError(t, state.Unmarshal(data))
+	require.Len(t, state.Processes, 2)
+	assert.Equal(t, "web", state.Processes[0].ID)
+	assert.Equal(t, "exited", state.Processes[1].Status)
+
+	// A restart forgets the processes, which are restored from the state
+	processes.mu.Lock()
+	delete(processes.processes, envID)
+	processes.mu.Unlock()
+	processes.restore(envID, state.Processes)
+
+	web, err := processes.get(envID, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "npm start", web.Command)
+	assert.Nil(t, web.svc, "processes of a previous session can't be reached")
+	assert.Len(t, processes.list(envID), 2)
+
+	// Processes known to this session are kept as they are
+	web.Status = "killed"
+	processes.restore(envID, state.Processes)
+	web, err = processes.get(envID, "web")
+	require.NoError(t, err)
+	assert.Equal(t, "killed", web.Status)
+}
+
+func TestLoadMachineID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "machine-id")
+
+	id, err := loadMachineID(path)
+	require.NoError(t, err)
+	assert.Len(t, id, 16)
+
+	again, err := loadMachineID(path)
+	require.NoError(t, err)
+	assert.Equal(t, id, again, "the machine ID must be stable")
+}
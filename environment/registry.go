@@ -0,0 +1,96 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// RegistryCredentials authenticate to a private container registry, to pull base images and service
+// images from it and push checkpoints to it. Registries without credentials are reached with those of
+// the host's docker config (docker login), which Dagger reads on its own.
+type RegistryCredentials struct {
+	// Address is the registry host, e.g. ghcr.io or 123456789.dkr.ecr.us-east-1.amazonaws.com.
+	Address  string `json:"address" yaml:"address"`
+	Username string `json:"username" yaml:"username"`
+	// Password is a secret reference to the password or token, e.g. env://GHCR_TOKEN.
+	Password string `json:"password" yaml:"password"`
+}
+
+// Validate returns an error if the credentials are incomplete.
+func (rc RegistryCredentials) Validate() error {
+	switch {
+	case rc.Address == "":
+		return errors.New("registry credentials must have an address")
+	case strings.Contains(rc.Address, "/"):
+		return fmt.Errorf("registry address %q must be a host, without scheme or repository", rc.Address)
+	case rc.Username == "":
+		return fmt.Errorf("registry credentials of %s must have a username", rc.Address)
+	case !strings.Contains(rc.Password, "://"):
+		return fmt.Errorf("password of registry %s must be a secret reference, e.g. env://REGISTRY_TOKEN", rc.Address)
+	}
+	return nil
+}
+
+// withRegistryAuth sets the registry credentials of the configuration on container, before it pulls
+// or pushes images.
+func (env *Environment) withRegistryAuth(ctx context.Context, container *dagger.Container) (*dagger.Container, error) {
+	for _, rc := range env.Config.Registries {
+		if err := rc.Validate(); err != nil {
+			return nil, err
+		}
+		password, err := secretFromReference(ctx, env.dag, rc.Password)
+		if err != nil {
+			return nil, fmt.Errorf("password of registry %s: %w", rc.Address, err)
+		}
+		container = container.WithRegistryAuth(rc.Address, rc.Username, password)
+	}
+	return container, nil
+}
+
+// registryAuthFailures are found in the errors of registries refusing a pull or push for lack of
+// credentials.
+var registryAuthFailures = []string{
+	"unauthorized",
+	"authentication required",
+	"insufficient_scope",
+	"denied: requested access",
+	"access denied",
+	"no basic auth credentials",
+}
+
+// RegistryAuthError reports that a registry refused to serve or accept an image for lack of credentials.
+type RegistryAuthError struct {
+	Err error
+}
+
+func (e *RegistryAuthError) Error() string {
+	return fmt.Sprintf("%s\nThe registry requires credentials: log in to it on the host with `docker login <registry>`, "+
+		"or add it to the registries of the environment configuration with a secret reference to its password", e.Err)
+}
+
+func (e *RegistryAuthError) Unwrap() error {
+	return e.Err
+}
+
+// withRegistryAuthHint returns err as a *RegistryAuthError if it looks like a registry refused
+// credentials, so that it says how to provide them.
+func withRegistryAuthHint(err error) error {
+	if err == nil {
+		return nil
+	}
+	var authErr *RegistryAuthError
+	if errors.As(err, &authErr) {
+		return err
+	}
+	message := strings.ToLower(err.Error())
+	for _, failure := range registryAuthFailures {
+		if strings.Contains(message, failure) {
+			return &RegistryAuthError{Err: err}
+		}
+	}
+	return err
+}
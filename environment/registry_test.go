@@ -0,0 +1,37 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryCredentials_Validate(t *testing.T) {
+	assert.NoError(t, RegistryCredentials{Address: "ghcr.io", Username: "me", Password: "env://GHCR_TOKEN"}.Validate())
+
+	for _, rc := range []RegistryCredentials{
+		{Username: "me", Password: "env://GHCR_TOKEN"},
+		{Address: "https://ghcr.io", Username: "me", Password: "env://GHCR_TOKEN"},
+		{Address: "ghcr.io/me/app", Username: "me", Password: "env://GHCR_TOKEN"},
+		{Address: "ghcr.io", Password: "env://GHCR_TOKEN"},
+		{Address: "ghcr.io", Username: "me", Password: "hunter2"},
+	} {
+		assert.Error(t, rc.Validate(), rc.Address)
+	}
+}
+
+func TestWithRegistryAuthHint(t *testing.T) {
+	assert.NoError(t, withRegistryAuthHint(nil))
+
+	other := errors.New("exit code 1")
+	assert.Equal(t, other, withRegistryAuthHint(other))
+
+	denied := errors.New(`failed to resolve source metadata for ghcr.io/acme/base:latest: unexpected status from HEAD request: 401 Unauthorized`)
+	err := withRegistryAuthHint(denied)
+	var authErr *RegistryAuthError
+	assert.ErrorAs(t, err, &authErr)
+	assert.ErrorIs(t, err, denied)
+	assert.Contains(t, err.Error(), "docker login")
+	assert.Equal(t, err, withRegistryAuthHint(err), "errors are only wrapped once")
+}
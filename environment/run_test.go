@@ -0,0 +1,51 @@
+package environment
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     RunOpts
+		expected string
+	}{
+		{
+			name:     "no_limits",
+			opts:     RunOpts{},
+			expected: "make build",
+		},
+		{
+			name:     "memory",
+			opts:     RunOpts{MemoryLimit: 512 * 1024 * 1024},
+			expected: "ulimit -v 524288 && exec sh -c 'make build'",
+		},
+		{
+			name:     "nice",
+			opts:     RunOpts{Nice: 50},
+			expected: "(command -v ionice >/dev/null 2>&1 && ionice -c 3 -p $$ >/dev/null 2>&1; true) && exec nice -n 19 sh -c 'make build'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, limitCommand("make build", "sh", tt.opts))
+		})
+	}
+}
+
+func TestLimitCommandQuoting(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	command := `echo "it's $((1+1))"`
+	out, err := exec.Command(sh, "-c", limitCommand(command, "sh", RunOpts{Nice: 5, MemoryLimit: 1 << 30})).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "it's 2\n", string(out))
+}
@@ -0,0 +1,162 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+// SecretStatus reports whether a secret reference can be resolved. It never includes the secret value.
+type SecretStatus struct {
+	Name      string `json:"name"`
+	Reference string `json:"reference,omitempty"`
+	// Service is set for secrets that belong to a service rather than the environment itself.
+	Service string `json:"service,omitempty"`
+	// Registry is set for the passwords of registry credentials.
+	Registry string `json:"registry,omitempty"`
+	OK       bool   `json:"ok"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CheckSecrets resolves each secret reference (NAME=schema://value) without exposing its value.
+// env:// and file:// references are checked on the host, as are the references read from the APIs of
+// secret managers (see hostSecretResolver); other schemas (op://, vault://, ...) are resolved through
+// Dagger, which requires their provider to be reachable.
+func CheckSecrets(ctx context.Context, dag *dagger.Client, secrets []string) []SecretStatus {
+	statuses := make([]SecretStatus, 0, len(secrets))
+	for _, secret := range secrets {
+		name, reference, found := strings.Cut(secret, "=")
+		status := SecretStatus{Name: name, Reference: reference}
+		if !strings.Contains(reference, "://") {
+			// Not a reference but possibly a literal value: don't echo it back
+			status.Reference = ""
+		}
+		if !found {
+			status.Error = "invalid secret, expected NAME=schema://value"
+		} else if err := checkSecretReference(ctx, dag, reference); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.OK = true
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func checkSecretReference(ctx context.Context, dag *dagger.Client, reference string) error {
+	schema, value, found := strings.Cut(reference, "://")
+	if !found {
+		return fmt.Errorf("missing schema, expected one of env://, file://, op://, vault://, gcp-sm://, azure-kv://")
+	}
+
+	switch schema {
+	case "env":
+		v, ok := os.LookupEnv(value)
+		if !ok {
+			return fmt.Errorf("host environment variable %s is not set", value)
+		}
+		if v == "" {
+			return fmt.Errorf("host environment variable %s is set but empty", value)
+		}
+		return nil
+	case "file":
+		info, err := os.Stat(value)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", value)
+		}
+		if info.Size() == 0 {
+			return fmt.Errorf("%s is empty", value)
+		}
+		return nil
+	default:
+		if isOnePasswordReference(reference) {
+			if err := CheckOnePassword(ctx); err != nil {
+				return err
+			}
+		}
+		plaintext, err := secretPlaintext(ctx, dag, reference)
+		if err != nil {
+			return fmt.Errorf("failed to resolve: %w", err)
+		}
+		if plaintext == "" {
+			return fmt.Errorf("resolves to an empty value")
+		}
+		return nil
+	}
+}
+
+// hostSecretResolver returns the function reading the value of reference, if it's resolved on the host by
+// container-use rather than by Dagger: vault://<mount>/<path>#<key>, gcp-sm:// and azure-kv:// references.
+func hostSecretResolver(reference string) (func(context.Context, string) (string, error), bool) {
+	schema, _, _ := strings.Cut(reference, "://")
+	switch schema {
+	case "vault":
+		if _, ok := parseVaultReference(reference); ok {
+			return resolveVaultSecret, true
+		}
+	case "gcp-sm":
+		return resolveGCPSecret, true
+	case "azure-kv":
+		return resolveAzureSecret, true
+	}
+	return nil, false
+}
+
+// secretFromReference returns the secret a reference designates. Secrets resolved on the host are read
+// right away, others are resolved by Dagger when the secret is used.
+func secretFromReference(ctx context.Context, dag *dagger.Client, reference string) (*dagger.Secret, error) {
+	if resolve, ok := hostSecretResolver(reference); ok {
+		value, err := resolve(ctx, reference)
+		if err != nil {
+			return nil, err
+		}
+		return dag.SetSecret(reference, value), nil
+	}
+	return dag.Secret(reference), nil
+}
+
+// secretPlaintext returns the value of the secret a reference designates.
+func secretPlaintext(ctx context.Context, dag *dagger.Client, reference string) (string, error) {
+	if resolve, ok := hostSecretResolver(reference); ok {
+		return resolve(ctx, reference)
+	}
+	return dag.Secret(reference).Plaintext(ctx)
+}
+
+// ResolveSecret returns the value of the secret a reference designates, for container-use itself to use
+// on the host rather than an environment.
+func ResolveSecret(ctx context.Context, dag *dagger.Client, reference string) (string, error) {
+	if !strings.Contains(reference, "://") {
+		return "", fmt.Errorf("invalid secret reference, expected schema://value, e.g. env://GITHUB_TOKEN")
+	}
+	value, err := secretPlaintext(ctx, dag, reference)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", reference, err)
+	}
+	return value, nil
+}
+
+// CheckSecrets resolves the secrets of the environment, of its services and of its registry credentials
+// without exposing their values.
+func (env *Environment) CheckSecrets(ctx context.Context) []SecretStatus {
+	statuses := CheckSecrets(ctx, env.dag, env.Config.Secrets)
+	for _, service := range env.Config.Services {
+		for _, status := range CheckSecrets(ctx, env.dag, service.Secrets) {
+			status.Service = service.Name
+			statuses = append(statuses, status)
+		}
+	}
+	for _, rc := range env.Config.Registries {
+		for _, status := range CheckSecrets(ctx, env.dag, []string{"password=" + rc.Password}) {
+			status.Registry = rc.Address
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
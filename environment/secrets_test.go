@@ -0,0 +1,50 @@
+package environment
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "token")
+	assert.NoError(t, os.WriteFile(secretFile, []byte("s3cr3t"), 0600))
+	emptyFile := filepath.Join(dir, "empty")
+	assert.NoError(t, os.WriteFile(emptyFile, nil, 0600))
+	t.Setenv("CU_TEST_SECRET", "s3cr3t")
+	t.Setenv("CU_TEST_EMPTY", "")
+
+	// Only host-resolved schemas are checked here: they don't need a Dagger client.
+	statuses := CheckSecrets(context.Background(), nil, []string{
+		"TOKEN=env://CU_TEST_SECRET",
+		"EMPTY=env://CU_TEST_EMPTY",
+		"MISSING=env://CU_TEST_MISSING",
+		"FILE=file://" + secretFile,
+		"EMPTY_FILE=file://" + emptyFile,
+		"NO_FILE=file://" + filepath.Join(dir, "missing"),
+		"NO_SCHEMA=s3cr3t",
+		"MALFORMED",
+	})
+
+	expected := map[string]bool{
+		"TOKEN":      true,
+		"EMPTY":      false,
+		"MISSING":    false,
+		"FILE":       true,
+		"EMPTY_FILE": false,
+		"NO_FILE":    false,
+		"NO_SCHEMA":  false,
+		"MALFORMED":  false,
+	}
+	assert.Len(t, statuses, len(expected))
+	for _, status := range statuses {
+		assert.Equal(t, expected[status.Name], status.OK, "%s: %s", status.Name, status.Error)
+		assert.Equal(t, status.OK, status.Error == "", status.Name)
+		assert.NotContains(t, status.Error, "s3cr3t")
+		assert.NotContains(t, status.Reference, "s3cr3t")
+	}
+}
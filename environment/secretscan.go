@@ -0,0 +1,165 @@
+package environment
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AllowSecretMarker on a line tells the secret scanner that it holds no credential, e.g. a test fixture.
+const AllowSecretMarker = "container-use:allow-secret"
+
+// SecretScanMode is what happens to files found to contain credentials when committing the changes of
+// an environment.
+type SecretScanMode string
+
+const (
+	// SecretScanWarn commits the files, and tells the agent about the credentials found.
+	SecretScanWarn SecretScanMode = "warn"
+	// SecretScanBlock refuses to commit any change until the credentials are removed.
+	SecretScanBlock SecretScanMode = "block"
+	// SecretScanStrip commits the other changes, leaving the files containing credentials uncommitted.
+	SecretScanStrip SecretScanMode = "strip"
+	// SecretScanOff disables scanning.
+	SecretScanOff SecretScanMode = "off"
+)
+
+// SecretScanSettings configure the scanning of the changes of environments for credentials, before they
+// are committed to the environment branch.
+type SecretScanSettings struct {
+	// Mode is SecretScanWarn if empty.
+	Mode SecretScanMode `json:"mode,omitempty" yaml:"mode,omitempty"`
+	// Allow lists the paths not to scan: globs matching the path or the name of files, or directories
+	// ending with a slash.
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+}
+
+func (s SecretScanSettings) validate() error {
+	switch s.Mode {
+	case "", SecretScanWarn, SecretScanBlock, SecretScanStrip, SecretScanOff:
+	default:
+		return fmt.Errorf("invalid secret scanning mode %q, expected one of warn, block, strip, off", s.Mode)
+	}
+	for _, pattern := range s.Allow {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("invalid secret scanning allow pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Allowed reports whether file is excluded from scanning.
+func (s SecretScanSettings) Allowed(file string) bool {
+	for _, pattern := range s.Allow {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			if file == dir || strings.HasPrefix(file, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, file); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(file)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretFinding is a credential found by ScanForSecrets.
+type SecretFinding struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Rule string `json:"rule"`
+	// Match is the beginning of the credential, enough to find it without exposing it.
+	Match string `json:"match"`
+	// Stripped is set if the file was left uncommitted, see SecretScanStrip.
+	Stripped bool `json:"stripped,omitempty"`
+}
+
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.File, f.Line, f.Rule, f.Match)
+}
+
+type secretRule struct {
+	name string
+	expr *regexp.Regexp
+}
+
+// secretRules detect credentials with a recognizable format, with few false positives.
+var secretRules = []secretRule{
+	{"AWS access key ID", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{"AWS secret access key", regexp.MustCompile(`(?i)aws_?secret_?access_?key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{50,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`)},
+	{"OpenAI API key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_\-]{20,}T3BlbkFJ[A-Za-z0-9_\-]{20,}\b`)},
+	{"Anthropic API key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_\-]{32,}\b`)},
+	{"npm token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+	{"PyPI token", regexp.MustCompile(`\bpypi-AgEIcHlwaS5vcmc[A-Za-z0-9_\-]{50,}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+// ScanForSecrets returns the credentials found in the lines a unified diff adds, as produced by
+// git diff. Lines marked with container-use:allow-secret, and files allowed by settings, are skipped.
+func ScanForSecrets(patch string, settings SecretScanSettings) []SecretFinding {
+	findings := []SecretFinding{}
+	file, line, inHeader := "", 0, false
+	for text := range strings.Lines(patch) {
+		text = strings.TrimRight(text, "\n")
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			file, inHeader = "", true
+		case inHeader && strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+			if file == "/dev/null" || settings.Allowed(file) {
+				file = ""
+			}
+		case strings.HasPrefix(text, "@@"):
+			line, inHeader = hunkStart(text), false
+		case file == "":
+		case strings.HasPrefix(text, "+"):
+			findings = append(findings, scanLine(file, line, text[1:])...)
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+// hunkStart returns the first line of the new file in a hunk header: @@ -a,b +c,d @@.
+func hunkStart(header string) int {
+	_, rest, _ := strings.Cut(header, " +")
+	rest, _, _ = strings.Cut(rest, " ")
+	rest, _, _ = strings.Cut(rest, ",")
+	start, _ := strconv.Atoi(rest)
+	return start
+}
+
+func scanLine(file string, line int, text string) []SecretFinding {
+	if strings.Contains(text, AllowSecretMarker) {
+		return nil
+	}
+	findings := []SecretFinding{}
+	for _, rule := range secretRules {
+		for _, match := range rule.expr.FindAllString(text, -1) {
+			findings = append(findings, SecretFinding{File: file, Line: line, Rule: rule.name, Match: redactMatch(match)})
+		}
+	}
+	return findings
+}
+
+// redactMatch keeps the beginning of a credential, which identifies its kind but not its value.
+func redactMatch(match string) string {
+	keep := min(8, len(match)/3)
+	if strings.HasPrefix(match, "-----") {
+		return match
+	}
+	return match[:keep] + "…"
+}
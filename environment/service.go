@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"dagger.io/dagger"
@@ -27,33 +29,114 @@ type EndpointMapping struct {
 
 type EndpointMappings map[int]*EndpointMapping
 
-func (env *Environment) startServices(ctx context.Context) ([]*Service, error) {
+// setEndpoints records the endpoints exposed under name in the environment state.
+func (env *Environment) setEndpoints(name string, endpoints EndpointMappings) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if len(endpoints) == 0 {
+		return
+	}
+	if env.State.Endpoints == nil {
+		env.State.Endpoints = map[string]EndpointMappings{}
+	}
+	env.State.Endpoints[name] = endpoints
+}
+
+// resetEndpoints forgets all recorded endpoints, e.g. when the environment is rebuilt.
+func (env *Environment) resetEndpoints() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	env.State.Endpoints = nil
+}
+
+// ServiceList returns the services of the environment. Services are only started when the environment
+// is built or needs them: otherwise, e.g. after a restart, the configured services are returned, with the
+// endpoints recorded when they last started.
+func (env *Environment) ServiceList() []*Service {
+	if len(env.Services) > 0 {
+		return env.Services
+	}
+
+	env.mu.RLock()
+	defer env.mu.RUnlock()
 	services := []*Service{}
 	for _, cfg := range env.Config.Services {
-		service, err := env.startService(ctx, cfg)
+		services = append(services, &Service{Config: cfg, Endpoints: env.State.Endpoints[cfg.Name]})
+	}
+	if env.Config.Docker {
+		services = append(services, dockerService())
+	}
+	return services
+}
+
+// withServiceBindings makes services reachable from container under all their hostnames.
+func withServiceBindings(container *dagger.Container, services ...*Service) *dagger.Container {
+	for _, service := range services {
+		for _, hostname := range service.Config.Hostnames() {
+			container = container.WithServiceBinding(hostname, service.svc)
+		}
+	}
+	return container
+}
+
+// startServices starts the services of the environment, dependencies first.
+func (env *Environment) startServices(ctx context.Context) ([]*Service, error) {
+	ordered, err := env.Config.Services.StartOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	services := []*Service{}
+	started := map[string]*Service{}
+	for _, cfg := range ordered {
+		service, err := env.startService(ctx, cfg, started)
 		if err != nil {
 			return nil, err
 		}
 		services = append(services, service)
+		started[cfg.Name] = service
 	}
 	return services, nil
 }
 
-func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*Service, error) {
-	container := env.dag.Container().From(cfg.Image)
-	container, err := containerWithEnvAndSecrets(env.dag, container, cfg.Env, cfg.Secrets)
+func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig, started map[string]*Service) (*Service, error) {
+	container, err := env.withRegistryAuth(ctx, env.dag.Container())
 	if err != nil {
 		return nil, err
 	}
+	container, err = containerWithEnvAndSecrets(ctx, env.dag, container.From(cfg.Image), cfg.Env, cfg.Secrets)
+	if err := env.withSecretsWarning(err); err != nil {
+		return nil, err
+	}
+
+	for _, dep := range cfg.DependsOn {
+		service, ok := started[dep]
+		if !ok {
+			return nil, fmt.Errorf("service %s depends on %s, which is not running", cfg.Name, dep)
+		}
+		container = withServiceBindings(container, service)
+	}
 
 	if cfg.Command != "" {
-		container = container.WithExec([]string{"sh", "-c", cfg.Command})
+		withPolicy, args, _, restoreNetwork, err := env.withNetworkPolicy(ctx, container, []string{"sh", "-c", cfg.Command}, false)
+		if err != nil {
+			return nil, err
+		}
+		container = restoreNetwork(withPolicy.WithExec(args, dagger.ContainerWithExecOpts{
+			InsecureRootCapabilities: env.Config.Network.Enforced(),
+		}))
 	}
 
 	args := []string{}
 	if cfg.Command != "" {
 		args = []string{"sh", "-c", cfg.Command}
 	}
+	container, args, useEntrypoint, _, err := env.withNetworkPolicy(ctx, container, args, true)
+	if err != nil {
+		return nil, err
+	}
 
 	// Expose ports
 	for _, port := range cfg.ExposedPorts {
@@ -67,8 +150,9 @@ func (env *Environment) startService(ctx context.Context, cfg *ServiceConfig) (*
 	startCtx, cancel := context.WithTimeout(ctx, serviceStartTimeout)
 	defer cancel()
 	svc, err := container.AsService(dagger.ContainerAsServiceOpts{
-		Args:          args,
-		UseEntrypoint: true,
+		Args:                     args,
+		UseEntrypoint:            useEntrypoint,
+		InsecureRootCapabilities: env.Config.Network.Enforced(),
 	}).Start(startCtx)
 	if err != nil {
 		var exitErr *dagger.ExecError
@@ -122,17 +206,34 @@ func (env *Environment) AddService(ctx context.Context, explanation string, cfg
 	if env.Config.Services.Get(cfg.Name) != nil {
 		return nil, fmt.Errorf("service %s already exists", cfg.Name)
 	}
-	svc, err := env.startService(ctx, cfg)
+	services := append(slices.Clone(env.Config.Services), cfg)
+	if _, err := services.StartOrder(); err != nil {
+		return nil, err
+	}
+	if err := (&EnvironmentConfig{Docker: env.Config.Docker, Services: services}).validateDocker(); err != nil {
+		return nil, err
+	}
+
+	started := map[string]*Service{}
+	for _, service := range env.Services {
+		started[service.Config.Name] = service
+	}
+	svc, err := env.startService(ctx, cfg, started)
 	if err != nil {
 		return nil, err
 	}
-	env.Config.Services = append(env.Config.Services, cfg)
-	env.Services = append(env.Services, svc)
 
-	state := env.container().WithServiceBinding(cfg.Name, svc.svc)
+	// Only record the service once it is bound, so a failure doesn't leave it in the config.
+	state := withServiceBindings(env.container(), svc)
 	if err := env.apply(ctx, state); err != nil {
-		return nil, err
+		if _, stopErr := svc.svc.Stop(ctx); stopErr != nil {
+			slog.Warn("failed to stop service", "service", cfg.Name, "err", stopErr)
+		}
+		return nil, fmt.Errorf("failed to bind service %s: %w", cfg.Name, err)
 	}
+	env.Config.Services = append(env.Config.Services, cfg)
+	env.Services = append(env.Services, svc)
+	env.setEndpoints(cfg.Name, svc.Endpoints)
 
 	env.Notes.Add("Add service %s\n%s\n\n", cfg.Name, explanation)
 
@@ -0,0 +1,36 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"dagger.io/dagger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableEngine is an engine connection failing every request.
+type unreachableEngine struct{}
+
+func (unreachableEngine) Do(*http.Request) (*http.Response, error) {
+	return nil, errors.New("engine unreachable")
+}
+func (unreachableEngine) Host() string { return "engine" }
+func (unreachableEngine) Close() error { return nil }
+
+func TestAddServiceStartFailure(t *testing.T) {
+	ctx := context.Background()
+	dag, err := dagger.Connect(ctx, dagger.WithConn(unreachableEngine{}))
+	require.NoError(t, err)
+	env := &Environment{
+		EnvironmentInfo: &EnvironmentInfo{ID: "fancy-mallard", Config: DefaultConfig(), State: &State{}},
+		dag:             dag,
+	}
+
+	_, err = env.AddService(ctx, "Add a database", &ServiceConfig{Name: "postgres", Image: "postgres:16", ExposedPorts: []int{5432}})
+	require.ErrorContains(t, err, "engine unreachable")
+	assert.Nil(t, env.Config.Services.Get("postgres"), "a service that failed to start is not kept in the config")
+	assert.Empty(t, env.Services)
+}
@@ -0,0 +1,303 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// SetupPhaseNames are the named setup phases, in the order they run.
+// Plain setup commands run before all of them, as the "setup" phase.
+var SetupPhaseNames = []string{"system", "language", "deps", "project"}
+
+const legacySetupPhase = "setup"
+
+// setupRevisionVariable is set while running a phase that was explicitly re-run, to invalidate its cache.
+const setupRevisionVariable = "CONTAINER_USE_SETUP_REVISION"
+
+// SetupPhase is a named group of setup commands.
+type SetupPhase struct {
+	Name     string
+	Commands []string
+}
+
+// SetupPhases maps phase names to their setup commands.
+type SetupPhases map[string][]string
+
+func (sp SetupPhases) Copy() SetupPhases {
+	if sp == nil {
+		return nil
+	}
+	copy := make(SetupPhases, len(sp))
+	for name, commands := range sp {
+		copy[name] = slices.Clone(commands)
+	}
+	return copy
+}
+
+// PhaseTiming reports how long a setup phase took during the last build.
+type PhaseTiming struct {
+	Name       string `json:"name"`
+	Commands   int    `json:"commands"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ValidateSetupPhase returns an error if name is not a setup phase.
+func ValidateSetupPhase(name string) error {
+	if name == legacySetupPhase || slices.Contains(SetupPhaseNames, name) {
+		return nil
+	}
+	return fmt.Errorf("unknown setup phase %q, expected one of %s", name, strings.Join(SetupPhaseNames, ", "))
+}
+
+// Phases returns the setup phases to run, in order: the plain setup commands followed by the named phases.
+// Phases without commands are omitted.
+func (config *EnvironmentConfig) Phases() ([]SetupPhase, error) {
+	for name := range config.SetupPhases {
+		if !slices.Contains(SetupPhaseNames, name) {
+			return nil, fmt.Errorf("unknown setup phase %q, expected one of %s", name, strings.Join(SetupPhaseNames, ", "))
+		}
+	}
+
+	phases := []SetupPhase{}
+	if len(config.SetupCommands) > 0 {
+		phases = append(phases, SetupPhase{Name: legacySetupPhase, Commands: config.SetupCommands})
+	}
+	for _, name := range SetupPhaseNames {
+		if commands := config.SetupPhases[name]; len(commands) > 0 {
+			phases = append(phases, SetupPhase{Name: name, Commands: commands})
+		}
+	}
+	return phases, nil
+}
+
+// runSetup runs the setup phases of the configuration on top of container and records their timing
+// and output. If a command fails, the returned error is a *BuildError.
+func (env *Environment) runSetup(ctx context.Context, container *dagger.Container) (*dagger.Container, error) {
+	phases, err := env.Config.Phases()
+	if err != nil {
+		return nil, err
+	}
+
+	container, timings, err := env.runPhases(ctx, container, phases)
+	if err != nil {
+		return nil, err
+	}
+
+	env.mu.Lock()
+	env.State.SetupPhases = timings
+	env.mu.Unlock()
+	return container, nil
+}
+
+// runPhases runs the commands of phases on top of container, recording their output as the last build.
+func (env *Environment) runPhases(ctx context.Context, container *dagger.Container, phases []SetupPhase) (*dagger.Container, []PhaseTiming, error) {
+	buildLog := &BuildLog{StartedAt: time.Now()}
+	var out buildLogWriter
+	defer func() {
+		buildLog.Output = out.String()
+		if len(phases) > 0 {
+			env.LastBuild = buildLog
+		}
+	}()
+
+	if env.Config.User != "" {
+		container = container.WithUser("root")
+	}
+
+	var err error
+	timings := []PhaseTiming{}
+	for _, phase := range phases {
+		start := time.Now()
+		out.phase(phase.Name)
+
+		revision := env.State.SetupRevisions[phase.Name]
+		if revision != 0 {
+			container = container.WithEnvVariable(setupRevisionVariable, strconv.FormatInt(revision, 10))
+		}
+		for _, command := range phase.Commands {
+			container, err = env.runSetupCommand(ctx, container, phase.Name, command, &out)
+			if err != nil {
+				buildLog.Failed = true
+				return nil, nil, &BuildError{Log: buildLog, Err: err}
+			}
+		}
+		if revision != 0 {
+			container = container.WithoutEnvVariable(setupRevisionVariable)
+		}
+
+		timings = append(timings, PhaseTiming{
+			Name:       phase.Name,
+			Commands:   len(phase.Commands),
+			DurationMs: time.Since(start).Milliseconds(),
+		})
+	}
+
+	if env.Config.User != "" && len(phases) > 0 {
+		// Files created by setup commands belong to root
+		owned := append([]string{"chown", "-R", env.Config.User, env.Config.Workdir}, env.Config.Caches...)
+		container = container.WithExec(owned)
+	}
+	return container, timings, nil
+}
+
+// appendedSetup returns the setup commands config adds to old, by phase, if that's the only change
+// between them and they run after all the commands of old. Running them on top of an environment
+// built with old then gives the same result as rebuilding it with config.
+func (config *EnvironmentConfig) appendedSetup(old *EnvironmentConfig) ([]SetupPhase, bool) {
+	if !sameBuild(config, old) {
+		return nil, false
+	}
+	phases, err := config.Phases()
+	if err != nil {
+		return nil, false
+	}
+	oldPhases, err := old.Phases()
+	if err != nil {
+		return nil, false
+	}
+
+	appended := []SetupPhase{}
+	for _, phase := range phases {
+		if len(oldPhases) == 0 {
+			appended = append(appended, phase)
+			continue
+		}
+		oldPhase := oldPhases[0]
+		oldPhases = oldPhases[1:]
+		if oldPhase.Name != phase.Name || !slices.Equal(oldPhase.Commands, phase.Commands[:min(len(oldPhase.Commands), len(phase.Commands))]) {
+			return nil, false
+		}
+		if len(phase.Commands) > len(oldPhase.Commands) {
+			if len(oldPhases) > 0 {
+				// commands added before those of later phases
+				return nil, false
+			}
+			appended = append(appended, SetupPhase{Name: phase.Name, Commands: phase.Commands[len(oldPhase.Commands):]})
+		} else if len(phase.Commands) < len(oldPhase.Commands) {
+			return nil, false
+		}
+	}
+	return appended, len(oldPhases) == 0 && len(appended) > 0
+}
+
+// sameBuild returns whether a and b build the same environment, setup commands aside.
+func sameBuild(a, b *EnvironmentConfig) bool {
+	encode := func(config *EnvironmentConfig) []byte {
+		config = config.Copy()
+		config.SetupCommands, config.SetupPhases, config.Locked = nil, nil, false
+		// omitempty makes nil and empty lists compare equal
+		data, _ := json.Marshal(config)
+		return data
+	}
+	return bytes.Equal(encode(a), encode(b))
+}
+
+// appendSetup runs the setup commands of phases on top of the current container of the environment,
+// rather than rebuilding it: installed packages, files and services are kept.
+func (env *Environment) appendSetup(ctx context.Context, phases []SetupPhase) (*dagger.Container, error) {
+	container, timings, err := env.runPhases(ctx, env.container(), phases)
+	if err != nil {
+		return nil, err
+	}
+	container, err = env.withUser(container)
+	if err != nil {
+		return nil, err
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	for _, timing := range timings {
+		i := slices.IndexFunc(env.State.SetupPhases, func(t PhaseTiming) bool { return t.Name == timing.Name })
+		if i < 0 {
+			env.State.SetupPhases = append(env.State.SetupPhases, timing)
+			continue
+		}
+		env.State.SetupPhases[i].Commands += timing.Commands
+		env.State.SetupPhases[i].DurationMs += timing.DurationMs
+	}
+	return container, nil
+}
+
+func (env *Environment) runSetupCommand(ctx context.Context, container *dagger.Container, phase, command string, out *buildLogWriter) (*dagger.Container, error) {
+	start := time.Now()
+	container, args, _, restoreNetwork, err := env.withNetworkPolicy(ctx, container, []string{"sh", "-c", command}, false)
+	if err != nil {
+		return nil, err
+	}
+	container = container.WithExec(args, dagger.ContainerWithExecOpts{
+		InsecureRootCapabilities: env.Config.Network.Enforced(),
+	})
+
+	exitCode, err := container.ExitCode(ctx)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			out.command(command, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr, time.Since(start))
+			env.Notes.AddCommand(command, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr)
+			return nil, fmt.Errorf("setup command failed in phase %s with exit code %d.\nstdout: %s\nstderr: %s\n%w", phase, exitErr.ExitCode, exitErr.Stdout, exitErr.Stderr, err)
+		}
+
+		out.command(command, -1, "", err.Error(), time.Since(start))
+		return nil, fmt.Errorf("failed to execute setup command: %w", err)
+	}
+	stdout, err := container.Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout: %w", err)
+	}
+
+	stderr, err := container.Stderr(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stderr: %w", err)
+	}
+
+	out.command(command, exitCode, stdout, stderr, time.Since(start))
+	env.Notes.AddCommand(command, exitCode, stdout, stderr)
+	return restoreNetwork(container), nil
+}
+
+// RerunSetupPhase rebuilds the environment, forcing the commands of phase to run again instead of
+// being served from cache (e.g. to reinstall dependencies). Earlier phases are reused from cache,
+// later phases run again since they build on top of it.
+func (env *Environment) RerunSetupPhase(ctx context.Context, explanation, phase string) error {
+	if env.Config.Locked {
+		return fmt.Errorf("Environment is locked, no updates allowed. Try to make do with the current environment or ask a human to remove the lock file (%s)", path.Join(configDir, lockFile))
+	}
+	if err := ValidateSetupPhase(phase); err != nil {
+		return err
+	}
+	phases, err := env.Config.Phases()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(phases, func(p SetupPhase) bool { return p.Name == phase }) {
+		return fmt.Errorf("setup phase %s has no commands", phase)
+	}
+
+	env.mu.Lock()
+	if env.State.SetupRevisions == nil {
+		env.State.SetupRevisions = map[string]int64{}
+	}
+	env.State.SetupRevisions[phase] = time.Now().UnixNano()
+	env.mu.Unlock()
+
+	container, err := env.buildBase(ctx, env.Workdir())
+	if err != nil {
+		return err
+	}
+	if err := env.apply(ctx, container); err != nil {
+		return err
+	}
+
+	env.Notes.Add("Re-run setup phase %s", phase)
+	return nil
+}
@@ -11,6 +11,38 @@ type State struct {
 	Title     string    `json:"title,omitempty"`
 	CreatedAt time.Time `json:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
+
+	// Endpoints records the addresses exposed by services and background commands, keyed by
+	// service name or command. They are reset whenever the environment is rebuilt.
+	Endpoints map[string]EndpointMappings `json:"endpoints,omitempty"`
+
+	// Processes records the background processes started in the environment, see processTable.
+	Processes []*Process `json:"processes,omitempty"`
+
+	// Checkpoint is the image the environment was last checkpointed to or restored from.
+	Checkpoint string `json:"checkpoint,omitempty"`
+
+	// PullRequest is the pull request the environment was created from, if any.
+	PullRequest *PullRequest `json:"pull_request,omitempty"`
+
+	// SetupPhases reports the timing of each setup phase during the last build.
+	SetupPhases []PhaseTiming `json:"setup_phases,omitempty"`
+	// SetupRevisions forces phases that were explicitly re-run to run again rather than being served from cache.
+	SetupRevisions map[string]int64 `json:"setup_revisions,omitempty"`
+}
+
+// PullRequest describes a GitHub pull request, as it was when an environment was created from it.
+type PullRequest struct {
+	Number  int    `json:"number"`
+	URL     string `json:"url"`
+	Title   string `json:"title,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Author  string `json:"author,omitempty"`
+	State   string `json:"state,omitempty"`
+	BaseRef string `json:"base_ref,omitempty"`
+	HeadRef string `json:"head_ref,omitempty"`
+	// HeadSHA is the commit the environment started from.
+	HeadSHA string `json:"head_sha,omitempty"`
 }
 
 func (s *State) Marshal() ([]byte, error) {
@@ -0,0 +1,202 @@
+package environment
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// statsSkippedDirs are not descended into when computing repository statistics: they hold
+// version control data, dependencies or caches rather than the code of the project.
+var statsSkippedDirs = []string{".git", "node_modules", ".venv", "venv", "__pycache__", ".tox", ".mypy_cache", ".pytest_cache", ".gradle", ".terraform"}
+
+// languageExtensions maps file extensions to the language they are written in.
+var languageExtensions = map[string]string{
+	".go": "Go", ".py": "Python", ".pyi": "Python", ".ipynb": "Jupyter Notebook",
+	".js": "JavaScript", ".mjs": "JavaScript", ".cjs": "JavaScript", ".jsx": "JavaScript",
+	".ts": "TypeScript", ".tsx": "TypeScript", ".mts": "TypeScript", ".cts": "TypeScript",
+	".rs": "Rust", ".java": "Java", ".kt": "Kotlin", ".kts": "Kotlin", ".scala": "Scala",
+	".c": "C", ".h": "C", ".cc": "C++", ".cpp": "C++", ".cxx": "C++", ".hpp": "C++", ".hh": "C++",
+	".cs": "C#", ".fs": "F#", ".swift": "Swift", ".m": "Objective-C", ".mm": "Objective-C",
+	".rb": "Ruby", ".php": "PHP", ".pl": "Perl", ".lua": "Lua", ".r": "R", ".jl": "Julia",
+	".ex": "Elixir", ".exs": "Elixir", ".erl": "Erlang", ".hs": "Haskell", ".ml": "OCaml", ".clj": "Clojure",
+	".dart": "Dart", ".zig": "Zig", ".nim": "Nim", ".sol": "Solidity", ".tf": "HCL", ".hcl": "HCL",
+	".sh": "Shell", ".bash": "Shell", ".zsh": "Shell", ".fish": "Shell", ".ps1": "PowerShell",
+	".html": "HTML", ".htm": "HTML", ".css": "CSS", ".scss": "SCSS", ".sass": "SCSS", ".less": "Less",
+	".vue": "Vue", ".svelte": "Svelte", ".astro": "Astro",
+	".sql": "SQL", ".proto": "Protocol Buffers", ".graphql": "GraphQL", ".gql": "GraphQL",
+	".md": "Markdown", ".mdx": "MDX", ".rst": "reStructuredText",
+	".json": "JSON", ".yaml": "YAML", ".yml": "YAML", ".toml": "TOML", ".xml": "XML",
+}
+
+// buildFiles are files at the root of a repository that describe how to build, test or run it.
+var buildFiles = []string{
+	"Makefile", "GNUmakefile", "makefile", "Taskfile.yml", "Taskfile.yaml", "justfile", "Justfile", "Rakefile",
+	"package.json", "go.mod", "Cargo.toml", "pyproject.toml", "setup.py", "requirements.txt", "Pipfile",
+	"Gemfile", "pom.xml", "build.gradle", "build.gradle.kts", "CMakeLists.txt", "meson.build", "BUILD.bazel",
+	"WORKSPACE", "MODULE.bazel", "mix.exs", "composer.json", "deno.json", "dagger.json", "flake.nix",
+	"Dockerfile", "docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml",
+}
+
+// ciConfigs are the paths (or directories, ending with /) where CI systems are configured.
+var ciConfigs = []string{
+	".github/workflows/", ".gitlab-ci.yml", ".circleci/", "Jenkinsfile", "azure-pipelines.yml",
+	".travis.yml", "bitbucket-pipelines.yml", ".buildkite/", ".drone.yml", ".woodpecker/", "cloudbuild.yaml",
+}
+
+const statsMaxDirectories = 10
+
+// RepoStats are facts about the files of a repository.
+type RepoStats struct {
+	Files              int             `json:"files"`
+	Bytes              int64           `json:"bytes"`
+	Languages          []LanguageStats `json:"languages"`
+	LargestDirectories []DirStats      `json:"largest_directories"`
+	BuildFiles         []string        `json:"build_files"`
+	CIConfigs          []string        `json:"ci_configs"`
+	// SkippedDirectories were not inspected, such as dependency directories.
+	SkippedDirectories []string `json:"skipped_directories,omitempty"`
+}
+
+// LanguageStats is the share of a language in a repository, by size.
+type LanguageStats struct {
+	Language string  `json:"language"`
+	Files    int     `json:"files"`
+	Bytes    int64   `json:"bytes"`
+	Percent  float64 `json:"percent"`
+}
+
+// DirStats is the size of a directory.
+type DirStats struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// RepoStats computes statistics about the files under dir (the workdir if empty) in a single exec.
+// The computation runs in a throwaway container: it never modifies the environment state.
+func (env *Environment) RepoStats(ctx context.Context, dir string) (*RepoStats, error) {
+	if dir == "" {
+		dir = "."
+	}
+	stdout, err := env.container().
+		WithWorkdir(dir).
+		WithExec(repoStatsArgs()).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	return computeRepoStats(stdout), nil
+}
+
+// repoStatsArgs lists regular files as `size<TAB>path` and skipped directories as `d<TAB>path`.
+// It relies on find and stat -c only, which both GNU and busybox provide.
+func repoStatsArgs() []string {
+	args := []string{"find", ".", "-type", "d", "("}
+	for i, name := range statsSkippedDirs {
+		if i > 0 {
+			args = append(args, "-o")
+		}
+		args = append(args, "-name", name)
+	}
+	return append(args, ")", "-prune", "-exec", "stat", "-c", "d\t%n", "{}", "+",
+		"-o", "-type", "f", "-exec", "stat", "-c", "%s\t%n", "{}", "+")
+}
+
+// computeRepoStats aggregates the output of repoStatsArgs.
+func computeRepoStats(listing string) *RepoStats {
+	stats := &RepoStats{
+		Languages:          []LanguageStats{},
+		LargestDirectories: []DirStats{},
+		BuildFiles:         []string{},
+		CIConfigs:          []string{},
+	}
+	languages := map[string]*LanguageStats{}
+	dirs := map[string]*DirStats{}
+	ci := map[string]bool{}
+
+	for line := range strings.Lines(listing) {
+		field, name, ok := strings.Cut(strings.TrimRight(line, "\n"), "\t")
+		if !ok {
+			continue
+		}
+		name = strings.TrimPrefix(name, "./")
+		if field == "d" {
+			stats.SkippedDirectories = append(stats.SkippedDirectories, name)
+			continue
+		}
+		size, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		stats.Files++
+		stats.Bytes += size
+
+		if language, ok := languageExtensions[strings.ToLower(path.Ext(name))]; ok {
+			if languages[language] == nil {
+				languages[language] = &LanguageStats{Language: language}
+			}
+			languages[language].Files++
+			languages[language].Bytes += size
+		}
+
+		// Only account for the first two levels: deeper directories are part of those
+		parts := strings.Split(name, "/")
+		for depth := 1; depth < len(parts) && depth <= 2; depth++ {
+			dir := strings.Join(parts[:depth], "/")
+			if dirs[dir] == nil {
+				dirs[dir] = &DirStats{Path: dir}
+			}
+			dirs[dir].Files++
+			dirs[dir].Bytes += size
+		}
+
+		if !strings.Contains(name, "/") && slices.Contains(buildFiles, name) {
+			stats.BuildFiles = append(stats.BuildFiles, name)
+		}
+		for _, config := range ciConfigs {
+			if name == config || (strings.HasSuffix(config, "/") && strings.HasPrefix(name, config)) {
+				ci[config] = true
+			}
+		}
+	}
+
+	var languageBytes int64
+	for _, language := range languages {
+		languageBytes += language.Bytes
+	}
+	for _, language := range languages {
+		if languageBytes > 0 {
+			language.Percent = math.Round(float64(language.Bytes)*1000/float64(languageBytes)) / 10
+		}
+		stats.Languages = append(stats.Languages, *language)
+	}
+	slices.SortFunc(stats.Languages, func(a, b LanguageStats) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Language, b.Language))
+	})
+
+	for _, dir := range dirs {
+		stats.LargestDirectories = append(stats.LargestDirectories, *dir)
+	}
+	slices.SortFunc(stats.LargestDirectories, func(a, b DirStats) int {
+		return cmp.Or(cmp.Compare(b.Bytes, a.Bytes), cmp.Compare(a.Path, b.Path))
+	})
+	if len(stats.LargestDirectories) > statsMaxDirectories {
+		stats.LargestDirectories = stats.LargestDirectories[:statsMaxDirectories]
+	}
+
+	for _, config := range ciConfigs {
+		if ci[config] {
+			stats.CIConfigs = append(stats.CIConfigs, strings.TrimSuffix(config, "/"))
+		}
+	}
+	slices.Sort(stats.BuildFiles)
+	slices.Sort(stats.SkippedDirectories)
+	return stats
+}
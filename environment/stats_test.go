@@ -0,0 +1,44 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputeRepoStats(t *testing.T) {
+	listing := "300\t./main.go\n" +
+		"100\t./internal/server/server.go\n" +
+		"100\t./web/src/app.ts\n" +
+		"50\t./web/package.json\n" +
+		"40\t./Makefile\n" +
+		"10\t./.github/workflows/ci.yml\n" +
+		"10\t./.github/workflows/release.yml\n" +
+		"d\t./web/node_modules\n" +
+		"d\t./.git\n" +
+		"not a listing line\n"
+
+	stats := computeRepoStats(listing)
+	assert.Equal(t, 7, stats.Files)
+	assert.EqualValues(t, 610, stats.Bytes)
+
+	require.Len(t, stats.Languages, 4)
+	assert.Equal(t, LanguageStats{Language: "Go", Files: 2, Bytes: 400, Percent: 70.2}, stats.Languages[0])
+	assert.Equal(t, "TypeScript", stats.Languages[1].Language)
+
+	require.NotEmpty(t, stats.LargestDirectories)
+	assert.Equal(t, DirStats{Path: "web", Files: 2, Bytes: 150}, stats.LargestDirectories[0])
+	assert.Contains(t, stats.LargestDirectories, DirStats{Path: "internal/server", Files: 1, Bytes: 100})
+	assert.NotContains(t, stats.LargestDirectories, DirStats{Path: ".", Files: 7, Bytes: 610})
+
+	assert.Equal(t, []string{"Makefile"}, stats.BuildFiles, "only build files at the root are reported")
+	assert.Equal(t, []string{".github/workflows"}, stats.CIConfigs)
+	assert.Equal(t, []string{".git", "web/node_modules"}, stats.SkippedDirectories)
+}
+
+func TestRepoStatsArgs(t *testing.T) {
+	args := repoStatsArgs()
+	assert.Equal(t, []string{"find", ".", "-type", "d", "(", "-name", ".git", "-o", "-name", "node_modules"}, args[:10])
+	assert.Equal(t, []string{"-o", "-type", "f", "-exec", "stat", "-c", "%s\t%n", "{}", "+"}, args[len(args)-9:])
+}
@@ -0,0 +1,81 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// outputPollInterval is how often the output of a command streamed with RunOpts.Output is read.
+var outputPollInterval = 2 * time.Second
+
+// maxOutputChunk caps how much of the output of a streamed command is read at a time, in bytes.
+const maxOutputChunk = 64 * 1024
+
+// withOutputStreaming mounts the process directory in container and wraps args so that their output is
+// also appended to a log file there, named after id, while stdout and stderr still get their own. Dagger
+// only returns the output of an exec once it exits: streamOutput reads the log file meanwhile.
+func (env *Environment) withOutputStreaming(container *dagger.Container, id, shell string, args []string) (*dagger.Container, []string) {
+	container = container.WithMountedCache(processDir, env.processVolume(), dagger.ContainerWithMountedCacheOpts{
+		Sharing: dagger.CacheSharingModeShared,
+	})
+	return container, append([]string{shell, "-c", outputStreamingScript, processDir + "/" + id}, args...)
+}
+
+// outputStreamingScript runs its arguments, appending their output to $0.log. stdout goes through fd 3 while
+// stderr is piped to the first tee, and the exit status is passed through $0.exit since pipelines lose it.
+const outputStreamingScript = `{ { "$@"; echo $? >"$0.exit"; } 2>&1 1>&3 3>&- | tee -a "$0.log" >&2 3>&-; } 3>&1 | tee -a "$0.log"; ` +
+	`code=$(cat "$0.exit" 2>/dev/null || echo 1); rm -f "$0.log" "$0.exit"; exit "$code"`
+
+// streamOutput passes the output appended to the log file of id to output every outputPollInterval, until
+// ctx is done. The values of secrets are masked.
+func (env *Environment) streamOutput(ctx context.Context, id string, output func(string)) {
+	ticker := time.NewTicker(outputPollInterval)
+	defer ticker.Stop()
+	offset := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		out, err := env.readProcessFiles(ctx, outputChunkScript(processDir+"/"+id+".log", offset))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Debug("Failed to read the output of the command", "environment.id", env.ID, "err", err)
+			continue
+		}
+		end, chunk, ok := parseOutputChunk(out)
+		if !ok || end <= offset {
+			continue
+		}
+		offset = end
+		output(chunk)
+	}
+}
+
+// outputChunkScript prints how far the log file at path has been read once the next chunk of it, starting
+// at offset, is, followed by that chunk.
+func outputChunkScript(path string, offset int) string {
+	return fmt.Sprintf(`end=$(( $(wc -c <%[1]s 2>/dev/null || echo 0) )); [ "$end" -gt %[2]d ] && end=%[2]d; echo "$end"; `+
+		`tail -c +%[3]d %[1]s 2>/dev/null | head -c $((end - %[4]d))`,
+		shellQuote(path), offset+maxOutputChunk, offset+1, offset)
+}
+
+// parseOutputChunk parses the output of outputChunkScript.
+func parseOutputChunk(out string) (int, string, bool) {
+	line, chunk, _ := strings.Cut(out, "\n")
+	end, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		return 0, "", false
+	}
+	return end, chunk, true
+}
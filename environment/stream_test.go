@@ -0,0 +1,57 @@
+package environment
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputStreamingScript(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "run-1")
+	cmd := exec.Command("sh", "-c", outputStreamingScript, base, "sh", "-c", "echo out; echo err >&2; exit 3")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	require.True(t, errors.As(err, &exitErr), "expected an exit error, got %v", err)
+	assert.Equal(t, 3, exitErr.ExitCode(), "the exit status of the command is kept")
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+	assert.NoFileExists(t, base+".log", "the log is removed once the command exits")
+	assert.NoFileExists(t, base+".exit")
+}
+
+func TestOutputChunkScript(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run-1.log")
+	read := func(offset int) (int, string) {
+		out, err := exec.Command("sh", "-c", outputChunkScript(path, offset)).Output()
+		require.NoError(t, err)
+		end, chunk, ok := parseOutputChunk(string(out))
+		require.True(t, ok, string(out))
+		return end, chunk
+	}
+
+	end, chunk := read(0)
+	assert.Equal(t, 0, end, "the log doesn't exist before the command writes to it")
+	assert.Empty(t, chunk)
+
+	require.NoError(t, os.WriteFile(path, []byte("compiling\nlinking\n"), 0644))
+	end, chunk = read(0)
+	assert.Equal(t, 18, end)
+	assert.Equal(t, "compiling\nlinking\n", chunk)
+	end, chunk = read(10)
+	assert.Equal(t, 18, end)
+	assert.Equal(t, "linking\n", chunk)
+
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), maxOutputChunk+10), 0644))
+	end, chunk = read(0)
+	assert.Equal(t, maxOutputChunk, end, "chunks are capped")
+	assert.Len(t, chunk, maxOutputChunk)
+}
@@ -0,0 +1,164 @@
+package environment
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// builtinTemplates are the templates shipped with container-use.
+//
+//go:embed templates/*.yaml
+var builtinTemplates embed.FS
+
+const templateExt = ".yaml"
+
+// Template is a named preset of environment configuration: a base image, setup commands, variables and
+// instructions, applied to new environments on request. Templates are YAML files using the keys of
+// .container-use/environment.yaml, plus a description.
+type Template struct {
+	Name        string `json:"name" yaml:"-"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Source is "builtin", or the path of the file defining the template.
+	Source string            `json:"source" yaml:"-"`
+	Config EnvironmentConfig `json:"config" yaml:",inline"`
+}
+
+// Templates returns the built-in templates along with those defined in userDir, sorted by name. User
+// templates override built-in templates of the same name. A missing userDir has no templates.
+func Templates(userDir string) ([]*Template, error) {
+	templates := map[string]*Template{}
+
+	builtins, err := fs.Glob(builtinTemplates, "templates/*"+templateExt)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range builtins {
+		data, err := builtinTemplates.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		template, err := parseTemplate(name, data)
+		if err != nil {
+			return nil, err
+		}
+		template.Source = "builtin"
+		templates[template.Name] = template
+	}
+
+	if userDir != "" {
+		entries, err := os.ReadDir(userDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || filepath.Ext(entry.Name()) != templateExt {
+				continue
+			}
+			file := filepath.Join(userDir, entry.Name())
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			template, err := parseTemplate(file, data)
+			if err != nil {
+				return nil, err
+			}
+			template.Source = file
+			templates[template.Name] = template
+		}
+	}
+
+	return slices.SortedFunc(maps.Values(templates), func(a, b *Template) int {
+		return strings.Compare(a.Name, b.Name)
+	}), nil
+}
+
+// LoadTemplate returns the template name, see Templates.
+func LoadTemplate(userDir, name string) (*Template, error) {
+	templates, err := Templates(userDir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(templates))
+	for i, template := range templates {
+		if template.Name == name {
+			return template, nil
+		}
+		names[i] = template.Name
+	}
+	return nil, fmt.Errorf("unknown template %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+func parseTemplate(file string, data []byte) (*Template, error) {
+	template := &Template{Name: strings.TrimSuffix(filepath.Base(file), templateExt)}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(template); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid template %s: %w", file, err)
+	}
+	if template.Config.BaseImage == "" && template.Config.Dockerfile == "" {
+		return nil, fmt.Errorf("invalid template %s: base_image or dockerfile must be set", file)
+	}
+	if err := ValidatePlatform(template.Config.Platform); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", file, err)
+	}
+	if err := ValidateUser(template.Config.User); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", file, err)
+	}
+	if _, err := template.Config.Phases(); err != nil {
+		return nil, fmt.Errorf("invalid template %s: %w", file, err)
+	}
+	return template, nil
+}
+
+// Apply sets the image and setup of the template on config, and adds its variables, secrets, caches
+// and services. Its instructions replace the default ones only: instructions written for the repository
+// are kept.
+func (t *Template) Apply(config *EnvironmentConfig) {
+	tc := t.Config.Copy()
+	config.BaseImage = tc.BaseImage
+	config.Dockerfile, config.BuildArgs, config.BuildTarget = tc.Dockerfile, slices.Clone(tc.BuildArgs), tc.BuildTarget
+	config.SetupCommands = slices.Clone(tc.SetupCommands)
+	config.SetupPhases = tc.SetupPhases
+	if tc.Platform != "" {
+		config.Platform = tc.Platform
+	}
+	if tc.User != "" {
+		config.User = tc.User
+	}
+	if tc.Workdir != "" {
+		config.Workdir = tc.Workdir
+	}
+	for _, kv := range tc.Env {
+		key, value := config.Env.parseKeyValue(kv)
+		config.Env.Set(key, value)
+	}
+	for _, kv := range tc.Secrets {
+		key, value := config.Secrets.parseKeyValue(kv)
+		config.Secrets.Set(key, value)
+	}
+	for _, dir := range tc.Caches {
+		if !slices.Contains(config.Caches, dir) {
+			config.Caches = append(config.Caches, dir)
+		}
+	}
+	for _, service := range tc.Services {
+		if config.Services.Get(service.Name) == nil {
+			config.Services = append(config.Services, service)
+		}
+	}
+	if tc.Instructions != "" && config.Instructions == DefaultConfig().Instructions {
+		config.Instructions = tc.Instructions
+	}
+}
@@ -0,0 +1,79 @@
+package environment
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplates(t *testing.T) {
+	userDir := t.TempDir()
+	templates, err := Templates(filepath.Join(userDir, "missing"))
+	require.NoError(t, err)
+	names := []string{}
+	for _, template := range templates {
+		names = append(names, template.Name)
+		assert.Equal(t, "builtin", template.Source)
+		assert.NotEmpty(t, template.Description, template.Name)
+		assert.NotEmpty(t, template.Config.Instructions, template.Name)
+	}
+	assert.Equal(t, []string{"go-1.23", "node-20-pnpm", "python-3.12-uv", "rust-stable"}, names)
+
+	writeTemplate := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(userDir, name), []byte(content), 0644))
+	}
+	writeTemplate("go-1.23.yaml", "base_image: golang:1.23-alpine\n")
+	writeTemplate("elixir.yaml", "description: Elixir\nbase_image: elixir:1.17\nsetup_commands: [mix deps.get]\n")
+	writeTemplate("notes.txt", "not a template")
+
+	template, err := LoadTemplate(userDir, "go-1.23")
+	require.NoError(t, err)
+	assert.Equal(t, "golang:1.23-alpine", template.Config.BaseImage, "user templates override built-in ones")
+	assert.Equal(t, filepath.Join(userDir, "go-1.23.yaml"), template.Source)
+	template, err = LoadTemplate(userDir, "elixir")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mix deps.get"}, template.Config.SetupCommands)
+
+	_, err = LoadTemplate(userDir, "cobol")
+	assert.ErrorContains(t, err, "expected one of elixir, go-1.23, node-20-pnpm")
+
+	for _, invalid := range []string{"setup_commands: [make]\n", "base_image: x\nbase_imag: y\n", "base_image: x\nsetup_phases: {build: [make]}\n"} {
+		writeTemplate("invalid.yaml", invalid)
+		_, err = Templates(userDir)
+		assert.ErrorContains(t, err, "invalid.yaml", invalid)
+	}
+}
+
+func TestTemplateApply(t *testing.T) {
+	template := &Template{Name: "node", Config: EnvironmentConfig{
+		Instructions:  "Use pnpm.",
+		BaseImage:     "node:20",
+		SetupCommands: []string{"corepack enable"},
+		Env:           KVList{"CI=true"},
+		Services:      ServiceConfigs{{Name: "redis", Image: "redis:7"}},
+	}}
+
+	config := DefaultConfig()
+	config.Dockerfile = "Dockerfile"
+	config.SetupCommands = []string{"apt-get install -y nodejs"}
+	config.Env = KVList{"CI=false", "PORT=3000"}
+	config.Services = ServiceConfigs{{Name: "redis", Image: "redis:6"}}
+	template.Apply(config)
+
+	assert.Equal(t, "node:20", config.BaseImage)
+	assert.Empty(t, config.Dockerfile)
+	assert.Equal(t, []string{"corepack enable"}, config.SetupCommands)
+	assert.ElementsMatch(t, KVList{"PORT=3000", "CI=true"}, config.Env)
+	require.Len(t, config.Services, 1)
+	assert.Equal(t, "redis:6", config.Services[0].Image, "services of the repository are kept")
+	assert.Equal(t, "Use pnpm.", config.Instructions)
+
+	config.Instructions = "Run make test."
+	template.Apply(config)
+	assert.Equal(t, "Run make test.", config.Instructions, "instructions of the repository are kept")
+	config.SetupCommands[0] = "changed"
+	assert.Equal(t, "corepack enable", template.Config.SetupCommands[0], "the template is not shared with the configuration")
+}
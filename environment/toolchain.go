@@ -0,0 +1,223 @@
+package environment
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ToolchainSuggestion is a configuration proposed for a repository that has none, from the toolchain
+// files found at its root.
+type ToolchainSuggestion struct {
+	BaseImage     string   `json:"base_image"`
+	SetupCommands []string `json:"setup_commands,omitempty"`
+	// Rationale explains what was detected, one finding per line.
+	Rationale []string `json:"rationale"`
+}
+
+var (
+	goVersion      = regexp.MustCompile(`(?m)^go\s+(\d+\.\d+)`)
+	versionPrefix  = regexp.MustCompile(`\d+(\.\d+)?`)
+	gemfileVersion = regexp.MustCompile(`(?m)^\s*ruby\s+["'](\d+\.\d+)`)
+)
+
+// toolchainDetectors are tried in order: the first one finding its toolchain picks the base image, the
+// others are only mentioned in the rationale.
+var toolchainDetectors = []func(readFile func(string) ([]byte, error)) *ToolchainSuggestion{
+	detectGo,
+	detectNode,
+	detectPython,
+	detectRust,
+	detectRuby,
+}
+
+// DetectToolchain inspects the root of a repository with readFile, which reads files given their path
+// relative to it, and proposes a base image and setup commands. It returns nil if no toolchain is found.
+func DetectToolchain(readFile func(name string) ([]byte, error)) *ToolchainSuggestion {
+	var suggestion *ToolchainSuggestion
+	for _, detect := range toolchainDetectors {
+		found := detect(readFile)
+		if found == nil {
+			continue
+		}
+		if suggestion == nil {
+			suggestion = found
+			suggestion.Rationale[0] = fmt.Sprintf("Detected %s, hence the base image %s.", found.Rationale[0], found.BaseImage)
+			continue
+		}
+		suggestion.Rationale = append(suggestion.Rationale, fmt.Sprintf("Also found %s: install it with setup commands if the project needs it.", found.Rationale[0]))
+	}
+	return suggestion
+}
+
+// DetectToolchainIn is DetectToolchain on the directory dir.
+func DetectToolchainIn(dir string) *ToolchainSuggestion {
+	return DetectToolchain(func(name string) ([]byte, error) {
+		return os.ReadFile(path.Join(dir, name))
+	})
+}
+
+// SuggestToolchain returns the configuration proposed for the repository checked out at worktree, if
+// it has none of its own and its toolchain is detected.
+func SuggestToolchain(worktree string) *ToolchainSuggestion {
+	if hasConfigFile(worktree) {
+		return nil
+	}
+	return DetectToolchainIn(worktree)
+}
+
+func fileExists(readFile func(string) ([]byte, error), name string) bool {
+	_, err := readFile(name)
+	return err == nil
+}
+
+func detectGo(readFile func(string) ([]byte, error)) *ToolchainSuggestion {
+	goMod, err := readFile("go.mod")
+	if err != nil {
+		return nil
+	}
+	s := &ToolchainSuggestion{BaseImage: "golang:1", SetupCommands: []string{"go mod download"}}
+	if m := goVersion.FindSubmatch(goMod); m != nil {
+		s.BaseImage = "golang:" + string(m[1])
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Go %s (go.mod)", m[1]))
+	} else {
+		s.Rationale = append(s.Rationale, "Go (go.mod)")
+	}
+	return s
+}
+
+func detectNode(readFile func(string) ([]byte, error)) *ToolchainSuggestion {
+	data, err := readFile("package.json")
+	if err != nil {
+		return nil
+	}
+	var pkg struct {
+		Engines        map[string]string `json:"engines"`
+		PackageManager string            `json:"packageManager"`
+	}
+	_ = json.Unmarshal(data, &pkg)
+
+	s := &ToolchainSuggestion{BaseImage: "node:lts"}
+	version, source := "", ".nvmrc"
+	if nvmrc, err := readFile(".nvmrc"); err == nil {
+		version = versionPrefix.FindString(string(nvmrc))
+	}
+	if version == "" {
+		version, source = versionPrefix.FindString(pkg.Engines["node"]), "package.json"
+	}
+	if major, _, _ := strings.Cut(version, "."); major != "" {
+		s.BaseImage = "node:" + major
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Node.js %s (%s)", major, source))
+	} else {
+		s.Rationale = append(s.Rationale, "Node.js (package.json)")
+	}
+
+	switch {
+	case strings.HasPrefix(pkg.PackageManager, "pnpm") || fileExists(readFile, "pnpm-lock.yaml"):
+		s.SetupCommands = []string{"corepack enable", "pnpm install --frozen-lockfile"}
+	case strings.HasPrefix(pkg.PackageManager, "yarn") || fileExists(readFile, "yarn.lock"):
+		s.SetupCommands = []string{"corepack enable", "yarn install --immutable"}
+	case fileExists(readFile, "package-lock.json"):
+		s.SetupCommands = []string{"npm ci"}
+	default:
+		s.SetupCommands = []string{"npm install"}
+	}
+	return s
+}
+
+func detectPython(readFile func(string) ([]byte, error)) *ToolchainSuggestion {
+	pyproject, pyprojectErr := readFile("pyproject.toml")
+	hasRequirements := fileExists(readFile, "requirements.txt")
+	if pyprojectErr != nil && !hasRequirements {
+		return nil
+	}
+
+	s := &ToolchainSuggestion{BaseImage: "python:3"}
+	source := "requirements.txt"
+	if pyprojectErr == nil {
+		source = "pyproject.toml"
+	}
+	version := ""
+	if pythonVersion, err := readFile(".python-version"); err == nil {
+		version, source = versionPrefix.FindString(string(pythonVersion)), ".python-version"
+	}
+	if version == "" && pyprojectErr == nil {
+		source = "pyproject.toml"
+		var project struct {
+			Project struct {
+				RequiresPython string `toml:"requires-python"`
+			} `toml:"project"`
+		}
+		if toml.Unmarshal(pyproject, &project) == nil {
+			version = versionPrefix.FindString(project.Project.RequiresPython)
+		}
+	}
+	if strings.Contains(version, ".") {
+		s.BaseImage = "python:" + version
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Python %s (%s)", version, source))
+	} else {
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Python (%s)", source))
+	}
+
+	switch {
+	case fileExists(readFile, "uv.lock"):
+		s.SetupCommands = []string{"pip install uv", "uv sync --frozen"}
+	case fileExists(readFile, "poetry.lock"):
+		s.SetupCommands = []string{"pip install poetry", "poetry install --no-root"}
+	case hasRequirements:
+		s.SetupCommands = []string{"pip install -r requirements.txt"}
+	default:
+		s.SetupCommands = []string{"pip install -e ."}
+	}
+	return s
+}
+
+func detectRust(readFile func(string) ([]byte, error)) *ToolchainSuggestion {
+	cargo, err := readFile("Cargo.toml")
+	if err != nil {
+		return nil
+	}
+	s := &ToolchainSuggestion{BaseImage: "rust:1", SetupCommands: []string{"cargo fetch"}}
+	var manifest struct {
+		Package struct {
+			RustVersion string `toml:"rust-version"`
+		} `toml:"package"`
+	}
+	if toml.Unmarshal(cargo, &manifest) == nil && strings.Contains(manifest.Package.RustVersion, ".") {
+		// rust-version is the minimum supported version: newer ones build the project too
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Rust %s or later (Cargo.toml)", manifest.Package.RustVersion))
+	} else {
+		s.Rationale = append(s.Rationale, "Rust (Cargo.toml)")
+	}
+	return s
+}
+
+func detectRuby(readFile func(string) ([]byte, error)) *ToolchainSuggestion {
+	gemfile, err := readFile("Gemfile")
+	if err != nil {
+		return nil
+	}
+	s := &ToolchainSuggestion{BaseImage: "ruby:3", SetupCommands: []string{"bundle install"}}
+	version, source := "", ".ruby-version"
+	if rubyVersion, err := readFile(".ruby-version"); err == nil {
+		version = versionPrefix.FindString(string(rubyVersion))
+	}
+	if version == "" {
+		source = "Gemfile"
+		if m := gemfileVersion.FindSubmatch(gemfile); m != nil {
+			version = string(m[1])
+		}
+	}
+	if strings.Contains(version, ".") {
+		s.BaseImage = "ruby:" + version
+		s.Rationale = append(s.Rationale, fmt.Sprintf("Ruby %s (%s)", version, source))
+	} else {
+		s.Rationale = append(s.Rationale, "Ruby (Gemfile)")
+	}
+	return s
+}
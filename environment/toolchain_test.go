@@ -0,0 +1,104 @@
+package environment
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFiles(files map[string]string) func(string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		contents, ok := files[name]
+		if !ok {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+		}
+		return []byte(contents), nil
+	}
+}
+
+func TestDetectToolchain(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		baseImage     string
+		setupCommands []string
+		rationale     []string
+	}{
+		{
+			name:          "go",
+			files:         map[string]string{"go.mod": "module example.com/app\n\ngo 1.24.2\n"},
+			baseImage:     "golang:1.24",
+			setupCommands: []string{"go mod download"},
+			rationale:     []string{"Detected Go 1.24 (go.mod), hence the base image golang:1.24."},
+		},
+		{
+			name: "node with pnpm and nvmrc",
+			files: map[string]string{
+				"package.json":   `{"engines": {"node": ">=18"}}`,
+				"pnpm-lock.yaml": "",
+				".nvmrc":         "v20.11.0\n",
+			},
+			baseImage:     "node:20",
+			setupCommands: []string{"corepack enable", "pnpm install --frozen-lockfile"},
+			rationale:     []string{"Detected Node.js 20 (.nvmrc), hence the base image node:20."},
+		},
+		{
+			name:          "node with npm",
+			files:         map[string]string{"package.json": `{"engines": {"node": "^22.1"}}`, "package-lock.json": "{}"},
+			baseImage:     "node:22",
+			setupCommands: []string{"npm ci"},
+			rationale:     []string{"Detected Node.js 22 (package.json), hence the base image node:22."},
+		},
+		{
+			name:          "python with uv",
+			files:         map[string]string{"pyproject.toml": "[project]\nname = \"app\"\nrequires-python = \">=3.11\"\n", "uv.lock": ""},
+			baseImage:     "python:3.11",
+			setupCommands: []string{"pip install uv", "uv sync --frozen"},
+			rationale:     []string{"Detected Python 3.11 (pyproject.toml), hence the base image python:3.11."},
+		},
+		{
+			name:          "python requirements",
+			files:         map[string]string{"requirements.txt": "flask\n"},
+			baseImage:     "python:3",
+			setupCommands: []string{"pip install -r requirements.txt"},
+			rationale:     []string{"Detected Python (requirements.txt), hence the base image python:3."},
+		},
+		{
+			name:          "rust",
+			files:         map[string]string{"Cargo.toml": "[package]\nname = \"app\"\nrust-version = \"1.75\"\n"},
+			baseImage:     "rust:1",
+			setupCommands: []string{"cargo fetch"},
+			rationale:     []string{"Detected Rust 1.75 or later (Cargo.toml), hence the base image rust:1."},
+		},
+		{
+			name:          "ruby",
+			files:         map[string]string{"Gemfile": "source \"https://rubygems.org\"\nruby \"3.2.2\"\n"},
+			baseImage:     "ruby:3.2",
+			setupCommands: []string{"bundle install"},
+			rationale:     []string{"Detected Ruby 3.2 (Gemfile), hence the base image ruby:3.2."},
+		},
+		{
+			name:          "go with a node frontend",
+			files:         map[string]string{"go.mod": "module app\n", "package.json": "{}"},
+			baseImage:     "golang:1",
+			setupCommands: []string{"go mod download"},
+			rationale: []string{
+				"Detected Go (go.mod), hence the base image golang:1.",
+				"Also found Node.js (package.json): install it with setup commands if the project needs it.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestion := DetectToolchain(readFiles(tt.files))
+			require.NotNil(t, suggestion)
+			assert.Equal(t, tt.baseImage, suggestion.BaseImage)
+			assert.Equal(t, tt.setupCommands, suggestion.SetupCommands)
+			assert.Equal(t, tt.rationale, suggestion.Rationale)
+		})
+	}
+
+	assert.Nil(t, DetectToolchain(readFiles(map[string]string{"README.md": "# App"})))
+}
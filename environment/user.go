@@ -0,0 +1,48 @@
+package environment
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"dagger.io/dagger"
+)
+
+var userPattern = regexp.MustCompile(`^([a-z_][a-z0-9_.-]*|[0-9]+)(:([a-z_][a-z0-9_.-]*|[0-9]+))?$`)
+
+// ValidateUser returns an error if user is neither empty, for the user of the image, nor a user name or
+// UID optionally followed by a group name or GID, e.g. node or 1000:1000. Names must exist in the image.
+func ValidateUser(user string) error {
+	if user != "" && !userPattern.MatchString(user) {
+		return fmt.Errorf("invalid user %q, expected a name or UID with an optional group, e.g. node or 1000:1000", user)
+	}
+	return nil
+}
+
+// withUser makes the user of the configuration run the commands of container. Setup commands run as
+// root instead, e.g. to install system packages, and the workdir and caches are then handed over to the
+// user, see runPhases.
+func (env *Environment) withUser(container *dagger.Container) (*dagger.Container, error) {
+	if env.Config.User == "" {
+		return container, nil
+	}
+	if err := ValidateUser(env.Config.User); err != nil {
+		return nil, err
+	}
+	return container.WithUser(env.Config.User), nil
+}
+
+// withCommandUser runs the commands of container as user instead of the user of the environment. The
+// returned function switches back on a container derived from the result.
+func withCommandUser(ctx context.Context, container *dagger.Container, user string) (*dagger.Container, func(*dagger.Container) *dagger.Container, error) {
+	if err := ValidateUser(user); err != nil {
+		return nil, nil, err
+	}
+	previous, err := container.User(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return container.WithUser(user), func(c *dagger.Container) *dagger.Container {
+		return c.WithUser(previous)
+	}, nil
+}
@@ -0,0 +1,16 @@
+package environment
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUser(t *testing.T) {
+	for _, user := range []string{"", "root", "node", "1000", "1000:1000", "app:staff", "www-data", "_apt", "1000:docker"} {
+		assert.NoError(t, ValidateUser(user), user)
+	}
+	for _, user := range []string{"Node", "1000:", ":1000", "a b", "user:group:extra", "-rf", "$(id)", "node;reboot"} {
+		assert.ErrorContains(t, ValidateUser(user), "invalid user", user)
+	}
+}
@@ -0,0 +1,255 @@
+package environment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	vaultAddrEnv      = "VAULT_ADDR"
+	vaultTokenEnv     = "VAULT_TOKEN"
+	vaultNamespaceEnv = "VAULT_NAMESPACE"
+	// vaultRoleIDEnv and vaultSecretIDEnv log in with AppRole when no token is set, under the mount
+	// named by vaultAppRoleMountEnv ("approle" by default). They are the variables Dagger uses too.
+	vaultRoleIDEnv       = "VAULT_APPROLE_ROLE_ID"
+	vaultSecretIDEnv     = "VAULT_APPROLE_SECRET_ID"
+	vaultAppRoleMountEnv = "VAULT_APPROLE_MOUNT"
+
+	secretsTimeout = 30 * time.Second
+)
+
+var (
+	// secretsHTTPClient calls the APIs of the secret managers resolved on the host.
+	secretsHTTPClient = &http.Client{Timeout: secretsTimeout}
+
+	// vaultLogin caches the token obtained with AppRole, for as long as Vault leases it.
+	vaultLoginMu        sync.Mutex
+	vaultLoginToken     string
+	vaultLoginExpiresAt time.Time
+)
+
+// vaultReference is a secret reference of the form vault://<mount>/<path>#<key>, read by container-use
+// through the Vault API. References without a key, e.g. vault://credentials.github, are resolved by Dagger.
+type vaultReference struct {
+	// Path is the mount and path of the secret, e.g. secret/myapp/github.
+	Path string
+	Key  string
+}
+
+// parseVaultReference returns the vault reference in reference, if it is one resolved by container-use.
+func parseVaultReference(reference string) (vaultReference, bool) {
+	rest, ok := strings.CutPrefix(reference, "vault://")
+	if !ok {
+		return vaultReference{}, false
+	}
+	path, key, ok := strings.Cut(rest, "#")
+	if !ok {
+		return vaultReference{}, false
+	}
+	return vaultReference{Path: strings.Trim(path, "/"), Key: key}, true
+}
+
+// VaultError reports that a secret could not be read from Vault.
+type VaultError struct {
+	Reference string
+	Reason    string
+}
+
+func (e *VaultError) Error() string {
+	return fmt.Sprintf("failed to read %s from Vault: %s", e.Reference, e.Reason)
+}
+
+// resolveVaultSecret reads the value of a vault://<mount>/<path>#<key> reference. Both versions of the KV
+// secrets engine are supported. Vault is reached at VAULT_ADDR with VAULT_TOKEN, the token of the Vault
+// CLI (~/.vault-token), or an AppRole login.
+func resolveVaultSecret(ctx context.Context, reference string) (string, error) {
+	ref, ok := parseVaultReference(reference)
+	if !ok {
+		return "", fmt.Errorf("invalid Vault reference %s, expected vault://<mount>/<path>#<key>", reference)
+	}
+	fail := func(format string, args ...any) error {
+		return &VaultError{Reference: reference, Reason: fmt.Sprintf(format, args...)}
+	}
+	if ref.Path == "" || ref.Key == "" {
+		return "", fail("expected vault://<mount>/<path>#<key>")
+	}
+	addr := strings.TrimRight(os.Getenv(vaultAddrEnv), "/")
+	if addr == "" {
+		return "", fail("%s is not set", vaultAddrEnv)
+	}
+	token, err := vaultToken(ctx, addr)
+	if err != nil {
+		return "", fail("%s", err)
+	}
+
+	apiPath, kv2, err := vaultReadPath(ctx, addr, token, ref.Path)
+	if err != nil {
+		return "", fail("%s", err)
+	}
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := vaultRequest(ctx, http.MethodGet, addr, apiPath, token, nil, &secret); err != nil {
+		return "", fail("%s", err)
+	}
+	data := secret.Data
+	if kv2 {
+		// KV version 2 nests the secret along with its metadata
+		data, _ = data["data"].(map[string]any)
+	}
+	value, ok := data[ref.Key]
+	if !ok {
+		return "", fail("the secret has no key %q", ref.Key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", fail("%s", err)
+	}
+	return string(encoded), nil
+}
+
+// vaultReadPath returns the API path reading the secret at path, and whether it's mounted on version 2
+// of the KV secrets engine. If the mount can't be looked up, e.g. for lack of permissions, version 1 is
+// assumed.
+func vaultReadPath(ctx context.Context, addr, token, path string) (string, bool, error) {
+	var mount struct {
+		Data struct {
+			Path    string            `json:"path"`
+			Options map[string]string `json:"options"`
+		} `json:"data"`
+	}
+	if err := vaultRequest(ctx, http.MethodGet, addr, "sys/internal/ui/mounts/"+path, token, nil, &mount); err != nil {
+		var statusErr *vaultStatusError
+		if errors.As(err, &statusErr) && (statusErr.Status == http.StatusForbidden || statusErr.Status == http.StatusNotFound) {
+			return path, false, nil
+		}
+		return "", false, err
+	}
+	if mount.Data.Options["version"] != "2" {
+		return path, false, nil
+	}
+	mountPath := strings.Trim(mount.Data.Path, "/")
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, mountPath), "/")
+	return mountPath + "/data/" + rest, true, nil
+}
+
+// vaultToken returns the token to authenticate to Vault with.
+func vaultToken(ctx context.Context, addr string) (string, error) {
+	if token := os.Getenv(vaultTokenEnv); token != "" {
+		return token, nil
+	}
+	roleID, secretID := os.Getenv(vaultRoleIDEnv), os.Getenv(vaultSecretIDEnv)
+	if roleID != "" && secretID != "" {
+		return vaultAppRoleLogin(ctx, addr, roleID, secretID)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if token, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil && len(bytes.TrimSpace(token)) > 0 {
+			return string(bytes.TrimSpace(token)), nil
+		}
+	}
+	return "", fmt.Errorf("not authenticated, set %s, or %s and %s, or log in with `vault login`", vaultTokenEnv, vaultRoleIDEnv, vaultSecretIDEnv)
+}
+
+func vaultAppRoleLogin(ctx context.Context, addr, roleID, secretID string) (string, error) {
+	vaultLoginMu.Lock()
+	defer vaultLoginMu.Unlock()
+	if vaultLoginToken != "" && time.Now().Before(vaultLoginExpiresAt) {
+		return vaultLoginToken, nil
+	}
+
+	mount := os.Getenv(vaultAppRoleMountEnv)
+	if mount == "" {
+		mount = "approle"
+	}
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role_id": roleID, "secret_id": secretID}
+	if err := vaultRequest(ctx, http.MethodPost, addr, "auth/"+strings.Trim(mount, "/")+"/login", "", body, &login); err != nil {
+		return "", fmt.Errorf("AppRole login failed: %w", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return "", errors.New("AppRole login returned no token")
+	}
+	vaultLoginToken = login.Auth.ClientToken
+	// Log in again a little before the lease ends, in case a build takes a while
+	ttl := time.Duration(login.Auth.LeaseDuration) * time.Second
+	vaultLoginExpiresAt = time.Now().Add(ttl - ttl/10)
+	return vaultLoginToken, nil
+}
+
+// vaultStatusError is an error response of the Vault API.
+type vaultStatusError struct {
+	Status int
+	Errors []string
+}
+
+func (e *vaultStatusError) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("Vault responded %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("Vault responded %d: %s", e.Status, strings.Join(e.Errors, ", "))
+}
+
+// vaultRequest calls the Vault API at path, relative to /v1, decoding the response into out.
+func vaultRequest(ctx context.Context, method, addr, path, token string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	u, err := url.JoinPath(addr, "v1", path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if namespace := os.Getenv(vaultNamespaceEnv); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := secretsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &vaultStatusError{Status: resp.StatusCode}
+		var errs struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errs) == nil {
+			statusErr.Errors = errs.Errors
+		}
+		return statusErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
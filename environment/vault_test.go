@@ -0,0 +1,120 @@
+package environment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves a KV version 2 engine mounted at secret/, a KV version 1 engine mounted at kv/ whose
+// mount can't be looked up, and AppRole logins.
+func fakeVault(t *testing.T) *httptest.Server {
+	t.Helper()
+	reply := func(w http.ResponseWriter, status int, body any) {
+		w.WriteHeader(status)
+		require.NoError(t, json.NewEncoder(w).Encode(body))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/approle/login" {
+			var login map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+			if login["role_id"] != "role" || login["secret_id"] != "secret" {
+				reply(w, http.StatusBadRequest, map[string]any{"errors": []string{"invalid role or secret ID"}})
+				return
+			}
+			reply(w, http.StatusOK, map[string]any{"auth": map[string]any{"client_token": "approle-token", "lease_duration": 3600}})
+			return
+		}
+		if token := r.Header.Get("X-Vault-Token"); token != "root-token" && token != "approle-token" {
+			reply(w, http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/sys/internal/ui/mounts/secret/myapp/github":
+			reply(w, http.StatusOK, map[string]any{"data": map[string]any{"path": "secret/", "options": map[string]string{"version": "2"}}})
+		case "/v1/secret/data/myapp/github":
+			reply(w, http.StatusOK, map[string]any{"data": map[string]any{
+				"data":     map[string]any{"token": "gh-s3cr3t", "scopes": []string{"repo"}},
+				"metadata": map[string]any{"version": 3},
+			}})
+		case "/v1/kv/database":
+			reply(w, http.StatusOK, map[string]any{"data": map[string]any{"password": "db-s3cr3t"}})
+		default:
+			reply(w, http.StatusNotFound, map[string]any{"errors": []string{}})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveVaultSecret(t *testing.T) {
+	vault := fakeVault(t)
+	ctx := context.Background()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(vaultAddrEnv, vault.URL)
+	t.Setenv(vaultTokenEnv, "root-token")
+
+	value, err := resolveVaultSecret(ctx, "vault://secret/myapp/github#token")
+	require.NoError(t, err)
+	assert.Equal(t, "gh-s3cr3t", value)
+	value, err = resolveVaultSecret(ctx, "vault://secret/myapp/github#scopes")
+	require.NoError(t, err)
+	assert.Equal(t, `["repo"]`, value, "values that aren't strings are returned as JSON")
+	value, err = resolveVaultSecret(ctx, "vault://kv/database#password")
+	require.NoError(t, err)
+	assert.Equal(t, "db-s3cr3t", value, "KV version 1 is assumed if the mount can't be looked up")
+
+	var vaultErr *VaultError
+	_, err = resolveVaultSecret(ctx, "vault://secret/myapp/github#password")
+	require.ErrorAs(t, err, &vaultErr)
+	assert.Contains(t, err.Error(), `no key "password"`)
+	_, err = resolveVaultSecret(ctx, "vault://kv/missing#password")
+	assert.ErrorContains(t, err, "404")
+	_, err = resolveVaultSecret(ctx, "vault://kv/database#")
+	assert.ErrorContains(t, err, "expected vault://<mount>/<path>#<key>")
+
+	t.Setenv(vaultTokenEnv, "")
+	_, err = resolveVaultSecret(ctx, "vault://kv/database#password")
+	assert.ErrorContains(t, err, "not authenticated")
+
+	t.Setenv(vaultAddrEnv, "")
+	_, err = resolveVaultSecret(ctx, "vault://kv/database#password")
+	assert.ErrorContains(t, err, "VAULT_ADDR is not set")
+}
+
+func TestResolveVaultSecretAppRole(t *testing.T) {
+	vault := fakeVault(t)
+	ctx := context.Background()
+	t.Cleanup(func() { vaultLoginToken, vaultLoginExpiresAt = "", time.Time{} })
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(vaultAddrEnv, vault.URL)
+	t.Setenv(vaultTokenEnv, "")
+	t.Setenv(vaultRoleIDEnv, "role")
+	t.Setenv(vaultSecretIDEnv, "wrong")
+
+	_, err := resolveVaultSecret(ctx, "vault://kv/database#password")
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+
+	t.Setenv(vaultSecretIDEnv, "secret")
+	value, err := resolveVaultSecret(ctx, "vault://kv/database#password")
+	require.NoError(t, err)
+	assert.Equal(t, "db-s3cr3t", value)
+	assert.Equal(t, "approle-token", vaultLoginToken)
+}
+
+func TestParseVaultReference(t *testing.T) {
+	ref, ok := parseVaultReference("vault://secret/myapp/github#token")
+	assert.True(t, ok)
+	assert.Equal(t, vaultReference{Path: "secret/myapp/github", Key: "token"}, ref)
+
+	_, ok = parseVaultReference("vault://credentials.github")
+	assert.False(t, ok, "references without a key are left to Dagger")
+	_, ok = parseVaultReference("op://vault/item#field")
+	assert.False(t, ok)
+}
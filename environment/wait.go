@@ -0,0 +1,151 @@
+package environment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"dagger.io/dagger"
+)
+
+// waitLoop polls probe (a shell function body taking the probe arguments as $1, $2, ...) once a second
+// until it succeeds, exiting with 124 once timeout is reached and 127 if the probe has no tool to run with.
+const waitLoop = `probe() { %s; }
+end=$(( $(date +%%s) + %d ))
+until probe "$@"; do
+	status=$?
+	[ $status -eq 127 ] && exit 127
+	[ $(date +%%s) -ge $end ] && exit 124
+	sleep 1
+done`
+
+// tcpProbe checks whether $1:$2 accepts connections with whichever tool the base image provides.
+const tcpProbe = `if command -v nc >/dev/null 2>&1; then nc -z -w 2 "$1" "$2";
+	elif command -v bash >/dev/null 2>&1; then timeout 2 bash -c 'exec 3<>"/dev/tcp/$0/$1"' "$1" "$2" 2>/dev/null;
+	else echo "neither nc nor bash is available to probe the port" >&2; return 127; fi`
+
+// httpProbe checks whether $1 responds with 200.
+const httpProbe = `if command -v curl >/dev/null 2>&1; then [ "$(curl -s -o /dev/null -w '%%{http_code}' --max-time 5 "$1")" = 200 ];
+	elif command -v wget >/dev/null 2>&1; then wget -q -O /dev/null -T 5 "$1";
+	else echo "neither curl nor wget is available to probe the URL" >&2; return 127; fi`
+
+// waitFor runs probe from the environment container until it succeeds or timeout is reached.
+func (env *Environment) waitFor(ctx context.Context, probe string, timeout time.Duration, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout+30*time.Second)
+	defer cancel()
+
+	script := fmt.Sprintf(waitLoop, probe, int(timeout.Seconds()))
+	_, err := env.container().
+		WithEnvVariable("CONTAINER_USE_CACHEBUST", time.Now().String()).
+		WithExec(append([]string{"sh", "-c", script, "wait"}, args...)).
+		Sync(ctx)
+	if err != nil {
+		var exitErr *dagger.ExecError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode {
+			case 124:
+				return fmt.Errorf("timed out after %s", timeout)
+			case 127:
+				return fmt.Errorf("unable to probe: %s", exitErr.Stderr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// WaitForPort waits until host:port accepts TCP connections.
+// If host is empty, it is the host of the background process processID or, if that is empty too,
+// of the background process or service exposing port.
+func (env *Environment) WaitForPort(ctx context.Context, host string, port int, processID string, timeout time.Duration) (string, error) {
+	if host == "" {
+		var err error
+		host, err = env.portHost(ctx, port, processID)
+		if err != nil {
+			return "", err
+		}
+	}
+	address := fmt.Sprintf("%s:%d", host, port)
+	if err := env.waitFor(ctx, tcpProbe, timeout, host, strconv.Itoa(port)); err != nil {
+		return address, fmt.Errorf("waiting for %s: %w", address, err)
+	}
+	return address, nil
+}
+
+// WaitForHTTP waits until target responds with 200 OK.
+func (env *Environment) WaitForHTTP(ctx context.Context, target string, timeout time.Duration) error {
+	if err := env.waitFor(ctx, httpProbe, timeout, target); err != nil {
+		return fmt.Errorf("waiting for %s: %w", target, err)
+	}
+	return nil
+}
+
+// WaitForProcess waits until the background process id exits and returns it.
+func (env *Environment) WaitForProcess(ctx context.Context, id string, timeout time.Duration) (*Process, error) {
+	process, err := processes.get(env.ID, id)
+	if err != nil {
+		return nil, err
+	}
+	if process.svc == nil {
+		// Started before a restart, it exited or was stopped already
+		if _, err := env.Processes(ctx); err != nil {
+			return nil, err
+		}
+		return process, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout+30*time.Second)
+	defer cancel()
+	script := fmt.Sprintf(`end=$(( $(date +%%s) + %d ))
+while [ ! -f %s/%s.exit ]; do
+	[ $(date +%%s) -ge $end ] && echo timeout && exit 0
+	sleep 1
+done`, int(timeout.Seconds()), processDir, id)
+	out, err := env.readProcessFiles(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for process %s: %w", id, err)
+	}
+	if out == "timeout\n" {
+		return nil, fmt.Errorf("timed out after %s waiting for process %s to exit", timeout, id)
+	}
+
+	if _, err := env.Processes(ctx); err != nil {
+		return nil, err
+	}
+	return processes.get(env.ID, id)
+}
+
+// portHost returns the host at which port is reachable from the environment.
+func (env *Environment) portHost(ctx context.Context, port int, processID string) (string, error) {
+	if processID != "" {
+		process, err := processes.get(env.ID, processID)
+		if err != nil {
+			return "", err
+		}
+		if process.svc == nil {
+			return "", fmt.Errorf("process %s was started before container-use restarted, which stopped it", processID)
+		}
+		return process.svc.Hostname(ctx)
+	}
+
+	candidates := []EndpointMappings{}
+	for _, process := range processes.list(env.ID) {
+		candidates = append(candidates, process.Endpoints)
+	}
+	for _, service := range env.Services {
+		candidates = append(candidates, service.Endpoints)
+	}
+	for _, endpoints := range candidates {
+		if endpoint, ok := endpoints[port]; ok && endpoint.EnvironmentInternal != "" {
+			u, err := url.Parse(endpoint.EnvironmentInternal)
+			if err != nil {
+				return "", err
+			}
+			return u.Hostname(), nil
+		}
+	}
+	return "", fmt.Errorf("no background process or service exposes port %d, specify the host", port)
+}
@@ -0,0 +1,114 @@
+package environment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// warmContainer is the container of an environment evaluated by the current Dagger session.
+//
+// Dagger can't exec into a running container: every command runs on top of the container of the
+// environment, loaded from its ID. The first time a session does so, the engine goes through the build of
+// that container again, from the base image and the setup commands, if only to find it in its cache. Once
+// evaluated, the container stays in the session and the next commands start right away: the container is
+// warm.
+type warmContainer struct {
+	// container and config are the ID of the container and the fingerprint of the configuration it was
+	// built with.
+	container string
+	config    string
+
+	// ready is closed once the container is evaluated, with err set if that failed.
+	ready chan struct{}
+	err   error
+}
+
+// warmTable tracks the containers of environments warmed by the current Dagger session, so that opening an
+// environment several times only evaluates its container once. Like background processes, they only live as
+// long as that session.
+type warmTable struct {
+	mu         sync.Mutex
+	containers map[string]*warmContainer // environment ID -> container
+}
+
+var warmContainers = &warmTable{containers: map[string]*warmContainer{}}
+
+// start records that the container of environment envID is being evaluated, unless it is warm or already
+// being evaluated. It returns nil in that case, and otherwise the entry to pass to done.
+func (t *warmTable) start(envID, container, config string) *warmContainer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if warm, ok := t.containers[envID]; ok && warm.container == container && warm.config == config {
+		select {
+		case <-warm.ready:
+			if warm.err == nil {
+				return nil
+			}
+		default:
+			return nil
+		}
+	}
+	warm := &warmContainer{container: container, config: config, ready: make(chan struct{})}
+	t.containers[envID] = warm
+	return warm
+}
+
+func (t *warmTable) done(warm *warmContainer, err error) {
+	warm.err = err
+	close(warm.ready)
+}
+
+func (t *warmTable) forget(envID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.containers, envID)
+}
+
+// ForgetWarmContainer lets the container of environment id go cold, e.g. once the environment is deleted.
+func ForgetWarmContainer(id string) {
+	warmContainers.forget(id)
+}
+
+// configFingerprint identifies config, so that containers built with another configuration are not
+// mistaken for warm ones.
+func configFingerprint(config *EnvironmentConfig) string {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Warm evaluates the container of the environment in the background, unless it is already warm, so that the
+// first command run in it doesn't wait for the engine to go through its build. The evaluation outlives ctx.
+func (env *Environment) Warm(ctx context.Context) {
+	env.mu.RLock()
+	container := env.State.Container
+	env.mu.RUnlock()
+	if container == "" {
+		return
+	}
+	warm := warmContainers.start(env.ID, container, configFingerprint(env.Config))
+	if warm == nil {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	loaded := env.container()
+	go func() {
+		start := time.Now()
+		_, err := loaded.Sync(ctx)
+		if err != nil {
+			slog.Warn("Failed to warm the container", "environment.id", env.ID, "err", err)
+		} else {
+			slog.Info("Warmed the container", "environment.id", env.ID, "duration", time.Since(start))
+		}
+		warmContainers.done(warm, err)
+	}()
+}
@@ -0,0 +1,38 @@
+package environment
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmTable(t *testing.T) {
+	table := &warmTable{containers: map[string]*warmContainer{}}
+
+	warm := table.start("env", "container-1", "config-1")
+	require.NotNil(t, warm)
+	assert.Nil(t, table.start("env", "container-1", "config-1"), "the container is already being evaluated")
+	table.done(warm, errors.New("engine went away"))
+	warm = table.start("env", "container-1", "config-1")
+	require.NotNil(t, warm, "evaluating is tried again after a failure")
+	table.done(warm, nil)
+	assert.Nil(t, table.start("env", "container-1", "config-1"), "the container is warm")
+
+	assert.NotNil(t, table.start("env", "container-1", "config-2"), "containers built with another configuration are cold")
+	assert.NotNil(t, table.start("env", "container-2", "config-2"), "only the latest container of an environment is warm")
+	assert.NotNil(t, table.start("other", "container-2", "config-2"))
+
+	table.forget("env")
+	assert.NotNil(t, table.start("env", "container-2", "config-2"))
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	config := DefaultConfig()
+	same := DefaultConfig()
+	assert.Equal(t, configFingerprint(config), configFingerprint(same))
+
+	same.SetupCommands = append(same.SetupCommands, "apk add git")
+	assert.NotEqual(t, configFingerprint(config), configFingerprint(same))
+}
@@ -0,0 +1,78 @@
+package environment
+
+import (
+	"context"
+	"crypto/rand"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"dagger.io/dagger"
+)
+
+// sessions are random tokens telling apart the engine sessions of the clients environments are loaded with,
+// by client.
+var sessions sync.Map
+
+// Session returns a token identifying the engine session of env. IDs of Dagger objects, such as that of the
+// workdir, are only valid in the session that made them.
+func (env *Environment) Session() string {
+	token, ok := sessions.Load(env.dag)
+	if !ok {
+		token, _ = sessions.LoadOrStore(env.dag, rand.Text())
+	}
+	return token.(string)
+}
+
+// WorkdirChanges returns what changed in the workdir since it was the directory of ID since, which must come
+// from the current Session: a directory with the files added or modified, and the paths of those deleted.
+// Exporting only them to a copy of since brings it up to date without transferring the files that didn't
+// change. Finding the deleted paths lists all the files of both directories.
+func (env *Environment) WorkdirChanges(ctx context.Context, since string) (*dagger.Directory, []string, error) {
+	before := env.dag.LoadDirectoryFromID(dagger.DirectoryID(since))
+	after := env.Workdir()
+
+	beforeFiles, err := before.Glob(ctx, "**")
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFiles, err := after.Glob(ctx, "**")
+	if err != nil {
+		return nil, nil, err
+	}
+	return before.Diff(after), removedPaths(beforeFiles, afterFiles), nil
+}
+
+// removedPaths returns the paths of before that are missing from after. The contents of a removed directory
+// are left out, removing it removes them.
+func removedPaths(before, after []string) []string {
+	kept := map[string]bool{}
+	for _, p := range after {
+		kept[strings.TrimSuffix(p, "/")] = true
+	}
+	before = slices.Clone(before)
+	slices.Sort(before)
+
+	removed := []string{}
+	isRemoved := map[string]bool{}
+	for _, p := range before {
+		p = strings.TrimSuffix(p, "/")
+		if kept[p] || underRemoved(p, isRemoved) {
+			continue
+		}
+		removed = append(removed, p)
+		isRemoved[p] = true
+	}
+	return removed
+}
+
+// underRemoved reports whether one of the parent directories of p is removed.
+func underRemoved(p string, removed map[string]bool) bool {
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if removed[dir] {
+			return true
+		}
+	}
+	return false
+}
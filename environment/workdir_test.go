@@ -0,0 +1,26 @@
+package environment
+
+import (
+	"testing"
+
+	"dagger.io/dagger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemovedPaths(t *testing.T) {
+	before := []string{"main.go", "docs/", "docs/index.md", "docs/img/", "docs/img/logo.png", "pkg/", "pkg/a.go", "pkg/b.go", "old.txt"}
+	after := []string{"main.go", "pkg/", "pkg/a.go", "new.txt"}
+	assert.Equal(t, []string{"docs", "old.txt", "pkg/b.go"}, removedPaths(before, after))
+
+	assert.Empty(t, removedPaths(nil, after))
+	assert.Empty(t, removedPaths(after, after))
+	assert.Equal(t, []string{"a", "a-b"}, removedPaths([]string{"a/x", "a", "a-b"}, nil), "siblings sharing a prefix are both removed")
+}
+
+func TestSession(t *testing.T) {
+	client, other := &dagger.Client{}, &dagger.Client{}
+	env := &Environment{dag: client}
+	assert.NotEmpty(t, env.Session())
+	assert.Equal(t, env.Session(), (&Environment{dag: client}).Session(), "environments of a client share its session")
+	assert.NotEqual(t, env.Session(), (&Environment{dag: other}).Session())
+}
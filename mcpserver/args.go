@@ -0,0 +1,171 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// portItems is the JSON schema for the items of a ports array.
+var portItems = map[string]any{
+	"type":    "integer",
+	"minimum": 1,
+	"maximum": 65535,
+}
+
+// optionalArray returns the array argument named key, or nil if it's absent or null.
+func optionalArray(request mcp.CallToolRequest, key string) ([]any, error) {
+	val, ok := request.GetArguments()[key]
+	if !ok || val == nil {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case []any:
+		return v, nil
+	case []string:
+		items := make([]any, len(v))
+		for i, s := range v {
+			items[i] = s
+		}
+		return items, nil
+	case []int:
+		items := make([]any, len(v))
+		for i, n := range v {
+			items[i] = n
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("argument %q must be an array, got %s", key, describeValue(val))
+	}
+}
+
+// optionalStringSlice is a strict variant of GetStringSlice: rather than silently dropping
+// elements that aren't strings, it reports which one is invalid.
+func optionalStringSlice(request mcp.CallToolRequest, key string) ([]string, error) {
+	items, err := optionalArray(request, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(items))
+	for i, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s[%d]: expected a string, got %s", key, i, describeValue(item))
+		}
+		result = append(result, str)
+	}
+	return result, nil
+}
+
+// optionalIntSlice reads an array of integers, accepting JSON numbers as well as numeric strings
+// since some clients send everything as strings.
+func optionalIntSlice(request mcp.CallToolRequest, key string) ([]int, error) {
+	items, err := optionalArray(request, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]int, 0, len(items))
+	for i, item := range items {
+		n, err := toInt(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s[%d]: %w", key, i, err)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// optionalPorts reads an array of TCP ports and validates their range.
+func optionalPorts(request mcp.CallToolRequest, key string) ([]int, error) {
+	ports, err := optionalIntSlice(request, key)
+	if err != nil {
+		return nil, err
+	}
+	for i, port := range ports {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid %s[%d]: port %d is out of range (1-65535)", key, i, port)
+		}
+	}
+	return ports, nil
+}
+
+func toInt(val any) (int, error) {
+	switch v := val.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("expected an integer, got %v", v)
+		}
+		return int(v), nil
+	case json.Number:
+		n, err := strconv.Atoi(v.String())
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %q", v.String())
+		}
+		return n, nil
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer, got %q", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("expected an integer, got %s", describeValue(val))
+	}
+}
+
+func describeValue(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	case float64, int, json.Number:
+		return fmt.Sprintf("number %v", v)
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// setupPhases reads an object mapping setup phase names to arrays of commands.
+func setupPhases(request mcp.CallToolRequest, key string) (environment.SetupPhases, error) {
+	val, ok := request.GetArguments()[key]
+	if !ok || val == nil {
+		return nil, nil
+	}
+	obj, ok := val.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("argument %q must be an object, got %s", key, describeValue(val))
+	}
+	phases := environment.SetupPhases{}
+	for name, commands := range obj {
+		if !slices.Contains(environment.SetupPhaseNames, name) {
+			return nil, fmt.Errorf("invalid %s: unknown phase %q, expected one of %s", key, name, strings.Join(environment.SetupPhaseNames, ", "))
+		}
+		items, ok := commands.([]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s.%s: expected an array, got %s", key, name, describeValue(commands))
+		}
+		for i, item := range items {
+			command, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s.%s[%d]: expected a string, got %s", key, name, i, describeValue(item))
+			}
+			phases[name] = append(phases[name], command)
+		}
+	}
+	return phases, nil
+}
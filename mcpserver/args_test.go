@@ -0,0 +1,138 @@
+package mcpserver
+
+import (
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestWithArgs(args map[string]any) mcp.CallToolRequest {
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = args
+	return request
+}
+
+func TestOptionalPorts(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     map[string]any
+		expected []int
+		err      string
+	}{
+		{
+			name:     "missing",
+			args:     map[string]any{},
+			expected: []int{},
+		},
+		{
+			name:     "null",
+			args:     map[string]any{"ports": nil},
+			expected: []int{},
+		},
+		{
+			name:     "numbers",
+			args:     map[string]any{"ports": []any{float64(80), float64(8080)}},
+			expected: []int{80, 8080},
+		},
+		{
+			name:     "numeric_strings",
+			args:     map[string]any{"ports": []any{"80", " 443 "}},
+			expected: []int{80, 443},
+		},
+		{
+			name: "not_a_number",
+			args: map[string]any{"ports": []any{float64(80), "http"}},
+			err:  `invalid ports[1]: expected an integer, got "http"`,
+		},
+		{
+			name: "null_element",
+			args: map[string]any{"ports": []any{nil}},
+			err:  "invalid ports[0]: expected an integer, got null",
+		},
+		{
+			name: "fractional",
+			args: map[string]any{"ports": []any{float64(80.5)}},
+			err:  "invalid ports[0]: expected an integer, got 80.5",
+		},
+		{
+			name: "out_of_range",
+			args: map[string]any{"ports": []any{float64(70000)}},
+			err:  "invalid ports[0]: port 70000 is out of range (1-65535)",
+		},
+		{
+			name: "not_an_array",
+			args: map[string]any{"ports": "80"},
+			err:  `argument "ports" must be an array, got string "80"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, err := optionalPorts(requestWithArgs(tt.args), "ports")
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ports)
+		})
+	}
+}
+
+func TestOptionalStringSlice(t *testing.T) {
+	values, err := optionalStringSlice(requestWithArgs(map[string]any{"envs": []any{"FOO=bar"}}), "envs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"FOO=bar"}, values)
+
+	_, err = optionalStringSlice(requestWithArgs(map[string]any{"envs": []any{"FOO=bar", float64(1)}}), "envs")
+	assert.EqualError(t, err, "invalid envs[1]: expected a string, got number 1")
+}
+
+func TestSetupPhases(t *testing.T) {
+	phases, err := setupPhases(requestWithArgs(map[string]any{"setup_phases": map[string]any{
+		"system": []any{"apt-get update"},
+		"deps":   []any{"npm ci", "npm run build"},
+	}}), "setup_phases")
+	require.NoError(t, err)
+	assert.Equal(t, environment.SetupPhases{
+		"system": {"apt-get update"},
+		"deps":   {"npm ci", "npm run build"},
+	}, phases)
+
+	_, err = setupPhases(requestWithArgs(map[string]any{"setup_phases": map[string]any{"setup": []any{"true"}}}), "setup_phases")
+	assert.ErrorContains(t, err, `unknown phase "setup"`)
+
+	_, err = setupPhases(requestWithArgs(map[string]any{"setup_phases": map[string]any{"deps": "npm ci"}}), "setup_phases")
+	assert.ErrorContains(t, err, "invalid setup_phases.deps: expected an array")
+}
+
+func TestImageArguments(t *testing.T) {
+	config := &environment.EnvironmentConfig{BaseImage: "ubuntu:24.04"}
+	require.NoError(t, imageArguments(config, requestWithArgs(map[string]any{
+		"dockerfile":   "build/dev.Dockerfile",
+		"build_args":   []any{"GO_VERSION=1.24"},
+		"build_target": "dev",
+	})))
+	assert.Equal(t, "build/dev.Dockerfile", config.Dockerfile)
+	assert.Equal(t, environment.KVList{"GO_VERSION=1.24"}, config.BuildArgs)
+	assert.Equal(t, "dev", config.BuildTarget)
+	assert.Equal(t, "ubuntu:24.04", config.BaseImage, "the base image is kept for when the Dockerfile is dropped")
+
+	require.NoError(t, imageArguments(config, requestWithArgs(map[string]any{"base_image": "golang:1.24"})))
+	assert.Equal(t, "golang:1.24", config.BaseImage)
+	assert.Empty(t, config.Dockerfile)
+	assert.Empty(t, config.BuildArgs)
+	assert.Empty(t, config.BuildTarget)
+
+	for _, args := range []map[string]any{
+		{},
+		{"dockerfile": "../Dockerfile"},
+		{"dockerfile": "/etc/Dockerfile"},
+		{"dockerfile": "Dockerfile", "build_args": []any{"GO_VERSION"}},
+	} {
+		assert.Error(t, imageArguments(config, requestWithArgs(args)), "%v", args)
+	}
+}
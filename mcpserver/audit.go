@@ -0,0 +1,171 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// maxAuditValue is the length past which argument values are summarized in the audit log,
+	// e.g. the contents of written files.
+	maxAuditValue = 1024
+	// maxAuditError is the length past which error messages are truncated in the audit log.
+	maxAuditError = 512
+
+	redacted = "[REDACTED]"
+)
+
+// secretName matches the names of arguments and environment variables likely to hold secrets.
+var secretName = regexp.MustCompile(`(?i)(secret|token|passw|credential|api_?key|private_?key|authorization)`)
+
+// withAudit records each call of the tool name in the audit log of the environment it concerns: the one
+// it names, or the one it created.
+func withAudit(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		start := time.Now()
+		result, err := handler(ctx, request)
+
+		entry := &repository.AuditEntry{
+			Time:     start.UTC(),
+			Tool:     name,
+			RunID:    runID(ctx),
+			Client:   clientName(ctx),
+			Duration: time.Since(start),
+			Status:   repository.AuditOK,
+		}
+		if session := server.ClientSessionFromContext(ctx); session != nil {
+			entry.Session = session.SessionID()
+		}
+		switch {
+		case err != nil:
+			entry.Status = repository.AuditFailed
+			entry.Error = err.Error()
+		case result != nil && result.IsError:
+			entry.Status = repository.AuditError
+			entry.Error = resultText(result)
+		}
+
+		if defaulted, defaultsErr := withSessionDefaults(ctx, request, sessionArguments); defaultsErr == nil {
+			request = defaulted
+		}
+		source := request.GetString("environment_source", "")
+		id := auditedEnvironmentID(request.GetString("environment_id", ""), result)
+		if source != "" && id != "" {
+			// Record even if the call was cancelled
+			if err := recordAudit(context.WithoutCancel(ctx), source, id, request.GetArguments(), entry); err != nil {
+				slog.Warn("Failed to record tool call in the audit log", "tool", name, "environment.id", id, "err", err)
+			}
+		}
+		return result, err
+	}
+}
+
+// recordAudit records entry, with arguments, in the audit log of environment id, with the values of its
+// secrets masked.
+func recordAudit(ctx context.Context, source, id string, arguments map[string]any, entry *repository.AuditEntry) error {
+	repo, err := repositories.open(ctx, source)
+	if err != nil {
+		return err
+	}
+	masker := auditMasker(ctx, repo, id)
+	entry.Arguments = redactArguments(arguments, masker)
+	entry.Error = truncateAudit(masker.Mask(entry.Error), maxAuditError)
+	return repo.RecordAudit(ctx, id, entry)
+}
+
+// auditMasker returns the masker of the secrets of environment id, nil if they can't be resolved: then only
+// the values of secret-looking names are redacted.
+func auditMasker(ctx context.Context, repo *repository.Repository, id string) *environment.SecretMasker {
+	info, err := repo.Info(ctx, id)
+	if err != nil {
+		return nil
+	}
+	dag, err := daggerClient(ctx)
+	if err != nil {
+		slog.Warn("Failed to resolve secrets to mask them in the audit log", "environment.id", id, "err", err)
+		return nil
+	}
+	return environment.NewEnvironmentSecretMasker(ctx, dag, info)
+}
+
+// auditedEnvironmentID returns id or, for tools creating environments, the ID of the environment in result.
+func auditedEnvironmentID(id string, result *mcp.CallToolResult) string {
+	if id != "" || result == nil || result.IsError {
+		return id
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(resultText(result)), &created); err != nil {
+		return ""
+	}
+	return created.ID
+}
+
+// resultText returns the text contents of result.
+func resultText(result *mcp.CallToolResult) string {
+	texts := []string{}
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// redactArguments returns a copy of the arguments of a tool call fit for the audit log: values of
+// secret-looking names are redacted, as are the values of secret-looking NAME=value environment
+// variables, the values of secrets are masked with masker wherever they appear, and long values are
+// summarized.
+func redactArguments(arguments map[string]any, masker *environment.SecretMasker) map[string]any {
+	if len(arguments) == 0 {
+		return nil
+	}
+	redactedArguments := make(map[string]any, len(arguments))
+	for name, value := range arguments {
+		if secretName.MatchString(name) {
+			redactedArguments[name] = redacted
+			continue
+		}
+		redactedArguments[name] = redactValue(value, masker)
+	}
+	return redactedArguments
+}
+
+func redactValue(value any, masker *environment.SecretMasker) any {
+	switch value := value.(type) {
+	case string:
+		if name, _, ok := strings.Cut(value, "="); ok && !strings.ContainsAny(name, " \t\n") && secretName.MatchString(name) {
+			return name + "=" + redacted
+		}
+		return truncateAudit(masker.Mask(value), maxAuditValue)
+	case []any:
+		values := make([]any, len(value))
+		for i, v := range value {
+			values[i] = redactValue(v, masker)
+		}
+		return values
+	case map[string]any:
+		return redactArguments(value, masker)
+	default:
+		return value
+	}
+}
+
+// truncateAudit shortens s to max bytes, noting how long it was.
+func truncateAudit(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d bytes)", strings.ToValidUTF8(s[:max], ""), len(s))
+}
@@ -0,0 +1,40 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactArguments(t *testing.T) {
+	arguments := map[string]any{
+		"command":   "go test ./...",
+		"script":    "curl -H 'X-Auth: sk-live-4242' https://api.example.com",
+		"api_token": "s3cr3t",
+		"env":       []any{"GITHUB_TOKEN=ghp_123", "DEBUG=1", "DB_PASSWORD=hunter2"},
+		"contents":  strings.Repeat("x", 2*maxAuditValue),
+		"timeout":   30.0,
+	}
+	masker := environment.NewSecretMasker(map[string]string{"API_KEY": "sk-live-4242"})
+	redactedArguments := redactArguments(arguments, masker)
+
+	assert.Equal(t, "go test ./...", redactedArguments["command"])
+	assert.Equal(t, "curl -H 'X-Auth: [REDACTED:API_KEY]' https://api.example.com", redactedArguments["script"], "secret values are masked whatever the name")
+	assert.Equal(t, redacted, redactedArguments["api_token"])
+	assert.Equal(t, []any{"GITHUB_TOKEN=" + redacted, "DEBUG=1", "DB_PASSWORD=" + redacted}, redactedArguments["env"])
+	assert.Contains(t, redactedArguments["contents"], "(2048 bytes)")
+	assert.Equal(t, 30.0, redactedArguments["timeout"])
+	assert.Equal(t, "s3cr3t", arguments["api_token"], "the arguments of the call are left untouched")
+
+	assert.Nil(t, redactArguments(nil, nil))
+}
+
+func TestAuditedEnvironmentID(t *testing.T) {
+	assert.Equal(t, "fancy-mallard", auditedEnvironmentID("fancy-mallard", nil))
+	assert.Equal(t, "quiet-otter", auditedEnvironmentID("", mcp.NewToolResultText(`{"id": "quiet-otter", "title": "New"}`)))
+	assert.Empty(t, auditedEnvironmentID("", mcp.NewToolResultError(`{"id": "quiet-otter"}`)))
+	assert.Empty(t, auditedEnvironmentID("", mcp.NewToolResultText("[]")))
+}
@@ -0,0 +1,153 @@
+package mcpserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const cancelledNotificationMethod = "notifications/cancelled"
+
+// errRequestCancelled is the cancellation cause of tool calls cancelled by the client.
+var errRequestCancelled = errors.New("request cancelled by the client")
+
+// inflightCalls tracks the cancel functions of running tool calls so that
+// notifications/cancelled can abort the underlying Dagger operations.
+//
+// The stdio transport dispatches requests one at a time: the BeforeCallTool
+// hook records the request ID which the tool wrapper then claims.
+type inflightCalls struct {
+	mu          sync.Mutex
+	dispatching string
+	cancels     map[string]context.CancelCauseFunc
+}
+
+var inflight = &inflightCalls{cancels: map[string]context.CancelCauseFunc{}}
+
+func (c *inflightCalls) beforeCallTool(_ context.Context, id any, _ *mcp.CallToolRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dispatching = requestKey(id)
+}
+
+// start returns a context that is cancelled when the client cancels the tool call being dispatched.
+// The returned function must be called once the call completes.
+func (c *inflightCalls) start(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	c.mu.Lock()
+	id := c.dispatching
+	c.dispatching = ""
+	if id != "" {
+		c.cancels[id] = cancel
+	}
+	c.mu.Unlock()
+
+	return ctx, func() {
+		c.mu.Lock()
+		delete(c.cancels, id)
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+func (c *inflightCalls) cancel(id any, reason string) {
+	c.mu.Lock()
+	cancel, ok := c.cancels[requestKey(id)]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	slog.Info("cancelling tool call", "request_id", requestKey(id), "reason", reason)
+	cancel(errRequestCancelled)
+}
+
+func requestKey(id any) string {
+	switch id := id.(type) {
+	case mcp.RequestId:
+		return id.String()
+	case *mcp.RequestId:
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	default:
+		return mcp.NewRequestId(id).String()
+	}
+}
+
+// watchCancellations returns a reader yielding the contents of r unchanged.
+// Cancellation notifications are acted upon as soon as they are read rather than
+// when the transport gets to them, since it does not read while a tool call is running.
+func watchCancellations(r io.Reader, calls *inflightCalls) io.Reader {
+	pr, pw := io.Pipe()
+
+	var (
+		mu      sync.Mutex
+		pending [][]byte
+		readErr error
+		ready   = make(chan struct{}, 1)
+	)
+	signal := func() {
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				handleCancellation(line, calls)
+				mu.Lock()
+				pending = append(pending, line)
+				mu.Unlock()
+				signal()
+			}
+			if err != nil {
+				mu.Lock()
+				readErr = err
+				mu.Unlock()
+				signal()
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for range ready {
+			mu.Lock()
+			lines, err := pending, readErr
+			pending = nil
+			mu.Unlock()
+
+			for _, line := range lines {
+				if _, err := pw.Write(line); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
+func handleCancellation(line []byte, calls *inflightCalls) {
+	var notification mcp.CancelledNotification
+	if err := json.Unmarshal(line, &notification); err != nil || notification.Method != cancelledNotificationMethod {
+		return
+	}
+	calls.cancel(notification.Params.RequestId, notification.Params.Reason)
+}
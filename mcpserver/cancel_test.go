@@ -0,0 +1,47 @@
+package mcpserver
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchCancellations(t *testing.T) {
+	calls := &inflightCalls{cancels: map[string]context.CancelCauseFunc{}}
+
+	calls.beforeCallTool(context.Background(), mcp.NewRequestId(int64(7)), nil)
+	ctx, done := calls.start(context.Background())
+	defer done()
+
+	// The transport is busy with request 7 and does not read: the cancellation must still be seen.
+	input := `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"environment_run_cmd"}}
+{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":7,"reason":"user abort"}}
+`
+	out := watchCancellations(strings.NewReader(input), calls)
+
+	<-ctx.Done()
+	assert.ErrorIs(t, context.Cause(ctx), errRequestCancelled)
+
+	forwarded, err := io.ReadAll(out)
+	require.NoError(t, err)
+	assert.Equal(t, input, string(forwarded))
+}
+
+func TestInflightCallsIgnoresUnknownRequests(t *testing.T) {
+	calls := &inflightCalls{cancels: map[string]context.CancelCauseFunc{}}
+
+	calls.beforeCallTool(context.Background(), mcp.NewRequestId("abc"), nil)
+	ctx, done := calls.start(context.Background())
+
+	calls.cancel(mcp.NewRequestId("other"), "")
+	assert.NoError(t, ctx.Err())
+
+	done()
+	assert.Empty(t, calls.cancels)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
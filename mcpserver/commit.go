@@ -0,0 +1,146 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const maxOperationLength = 72
+
+// clientNames maps MCP session IDs to the name the client reported during initialization.
+var clientNames sync.Map
+
+func recordClientInfo(ctx context.Context, _ any, message *mcp.InitializeRequest, _ *mcp.InitializeResult) {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil || message == nil {
+		return
+	}
+	clientNames.Store(session.SessionID(), message.Params.ClientInfo.Name)
+}
+
+func clientName(ctx context.Context) string {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return ""
+	}
+	name, _ := clientNames.Load(session.SessionID())
+	s, _ := name.(string)
+	return s
+}
+
+// commitMessage builds the message of every commit made on behalf of a tool call, so history reads the same
+// regardless of the tool. The subject is the agent's explanation, falling back to a summary of the operation.
+// Trailers record the operation, tool, agent and run ID.
+func commitMessage(ctx context.Context, request mcp.CallToolRequest, operation string) string {
+	operation = summarize(operation)
+	explanation := strings.TrimSpace(request.GetString("explanation", ""))
+
+	subject := operation
+	if explanation != "" {
+		subject = explanation
+	}
+
+	trailers := []string{}
+	if subject != operation {
+		trailers = append(trailers, "Operation: "+operation)
+	}
+	trailers = append(trailers, "Tool: "+request.Params.Name)
+	if agent := clientName(ctx); agent != "" {
+		trailers = append(trailers, "Agent: "+agent)
+	}
+	if id := runID(ctx); id != "" {
+		trailers = append(trailers, "Run-Id: "+id)
+	}
+
+	return fmt.Sprintf("%s\n\n%s", subject, strings.Join(trailers, "\n"))
+}
+
+// updateRepository saves env on behalf of the tool call, tagging its log note with the run ID.
+func updateRepository(ctx context.Context, repo *repository.Repository, env *environment.Environment, request mcp.CallToolRequest, operation string) error {
+	if id := runID(ctx); id != "" && env.Notes.String() != "" {
+		env.Notes.Add("Run-Id: %s", id)
+	}
+	if err := repo.Update(ctx, env, commitMessage(ctx, request, operation)); err != nil {
+		return err
+	}
+	if len(env.SecretFindings) > 0 {
+		addNotice(ctx, "%s", secretFindingsNotice(env.SecretFindings))
+	}
+	if len(env.SkippedFiles) > 0 {
+		addNotice(ctx, "%s", skippedFilesNotice(env.SkippedFiles))
+	}
+	return nil
+}
+
+// updateRepositoryLazily saves env like updateRepository, except that with the lazy propagation the change
+// may be left pending, see repository.PropagationLazy. The agent is told when it is.
+func updateRepositoryLazily(ctx context.Context, repo *repository.Repository, env *environment.Environment, request mcp.CallToolRequest, operation string) error {
+	if id := runID(ctx); id != "" && env.Notes.String() != "" {
+		env.Notes.Add("Run-Id: %s", id)
+	}
+	committed, err := repo.UpdateLazily(ctx, env, commitMessage(ctx, request, operation))
+	if err != nil {
+		return err
+	}
+	if !committed {
+		pendingFlushes.schedule(ctx, repo, request.GetString("environment_source", ""), env.ID)
+		addNotice(ctx, "the change is in the container but not committed to the environment branch yet, it will be within %s, with the next command run or with environment_flush", repo.FlushInterval())
+		return nil
+	}
+	if len(env.SecretFindings) > 0 {
+		addNotice(ctx, "%s", secretFindingsNotice(env.SecretFindings))
+	}
+	if len(env.SkippedFiles) > 0 {
+		addNotice(ctx, "%s", skippedFilesNotice(env.SkippedFiles))
+	}
+	return nil
+}
+
+// secretFindingsNotice tells the agent about the credentials found in its changes, so that it removes them.
+func secretFindingsNotice(findings []environment.SecretFinding) string {
+	var notice strings.Builder
+	if findings[0].Stripped {
+		notice.WriteString("files containing credentials were left uncommitted, remove the credentials to commit them")
+	} else {
+		notice.WriteString("credentials were committed to the environment branch, remove them")
+	}
+	fmt.Fprintf(&notice, ", e.g. by reading them from environment variables. Add %s to lines that are false positives:", environment.AllowSecretMarker)
+	for _, finding := range findings {
+		notice.WriteString("\n  " + finding.String())
+	}
+	return notice.String()
+}
+
+// maxSkippedFilesListed is how many of the binary files left uncommitted are named in notices.
+const maxSkippedFilesListed = 20
+
+// skippedFilesNotice tells the agent about the binary files left out of the environment branch, which
+// the user won't get when merging it.
+func skippedFilesNotice(files []string) string {
+	var notice strings.Builder
+	notice.WriteString("binary files were left uncommitted, they are in the container but not in the environment branch. The binary_files setting of the repository policy decides which are committed: ask the user to change it if they need these files:")
+	for i, file := range files {
+		if i == maxSkippedFilesListed {
+			fmt.Fprintf(&notice, "\n  and %d more", len(files)-i)
+			break
+		}
+		notice.WriteString("\n  " + file)
+	}
+	return notice.String()
+}
+
+// summarize flattens an operation to a single line short enough for a commit trailer.
+func summarize(operation string) string {
+	operation = strings.Join(strings.Fields(operation), " ")
+	if len(operation) > maxOperationLength {
+		operation = operation[:maxOperationLength-1] + "…"
+	}
+	return operation
+}
@@ -0,0 +1,76 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitMessage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("explanation_as_subject", func(t *testing.T) {
+		request := requestWithArgs(map[string]any{"explanation": "Add the greeting endpoint"})
+		request.Params.Name = "environment_file_write"
+
+		assert.Equal(t, "Add the greeting endpoint\n\nOperation: Write main.go\nTool: environment_file_write", commitMessage(ctx, request, "Write main.go"))
+	})
+
+	t.Run("operation_as_subject", func(t *testing.T) {
+		request := requestWithArgs(map[string]any{})
+		request.Params.Name = "environment_file_delete"
+
+		assert.Equal(t, "Delete main.go\n\nTool: environment_file_delete", commitMessage(ctx, request, "Delete main.go"))
+	})
+
+	t.Run("run_id", func(t *testing.T) {
+		request := requestWithArgs(map[string]any{})
+		request.Params.Name = "environment_mkdir"
+		ctx := context.WithValue(ctx, runIDKey{}, "abc123")
+
+		assert.Equal(t, "Create directory src\n\nTool: environment_mkdir\nRun-Id: abc123", commitMessage(ctx, request, "Create directory src"))
+	})
+
+	t.Run("long_operation", func(t *testing.T) {
+		request := requestWithArgs(map[string]any{})
+		request.Params.Name = "environment_run_cmd"
+
+		msg := commitMessage(ctx, request, "Run "+strings.Repeat("x", 100)+"\necho done")
+		subject, _, _ := strings.Cut(msg, "\n")
+		assert.Len(t, []rune(subject), maxOperationLength)
+	})
+}
+
+func TestSecretFindingsNotice(t *testing.T) {
+	handler := withNotices(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		addNotice(ctx, "%s", secretFindingsNotice([]environment.SecretFinding{
+			{File: "deploy.sh", Line: 3, Rule: "GitHub token", Match: "ghp_aBcD…", Stripped: true},
+		}))
+		return mcp.NewToolResultText("done"), nil
+	})
+	result, err := handler(context.Background(), mcp.CallToolRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Content, 2)
+	notice := resultText(result)
+	assert.Contains(t, notice, "left uncommitted")
+	assert.Contains(t, notice, "deploy.sh:3: GitHub token (ghp_aBcD…)")
+
+	addNotice(context.Background(), "ignored outside of tool calls")
+}
+
+func TestSkippedFilesNotice(t *testing.T) {
+	files := []string{"logo.png"}
+	for i := range 25 {
+		files = append(files, fmt.Sprintf("dist/chunk%d.wasm", i))
+	}
+	notice := skippedFilesNotice(files)
+	assert.Contains(t, notice, "binary_files")
+	assert.Contains(t, notice, "\n  logo.png\n")
+	assert.NotContains(t, notice, "chunk19.wasm")
+	assert.True(t, strings.HasSuffix(notice, "\n  and 6 more"))
+}
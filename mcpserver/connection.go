@@ -0,0 +1,171 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// Connector connects to the Dagger engine. The client it returns is used until the server stops, or until
+// the connection to the engine is lost.
+type Connector func(ctx context.Context) (*dagger.Client, error)
+
+// daggerConnection is the Dagger client shared by the tool calls of a server. It connects on first use, so
+// that the server starts without waiting for the engine, and connecting again is tried on the next use if it
+// failed or if the connection was lost, e.g. because the engine restarted.
+type daggerConnection struct {
+	// ctx bounds the lifetime of the connection, rather than the tool call that happens to open it.
+	ctx     context.Context
+	connect Connector
+
+	mu  sync.Mutex
+	dag *dagger.Client
+	// generation counts the clients connected so far, so that a tool call only drops the client it used.
+	generation int
+}
+
+// daggerConnectionErrors are what the errors of a client whose connection to the engine is gone contain.
+// Tool handlers turn most errors into tool results, so they are matched by message.
+var daggerConnectionErrors = []string{
+	"connection refused",
+	"connection reset by peer",
+	"broken pipe",
+	"unexpected EOF",
+	"use of closed network connection",
+}
+
+// isConnectionError reports whether message says that the connection to the engine is gone.
+func isConnectionError(message string) bool {
+	for _, connectionError := range daggerConnectionErrors {
+		if strings.Contains(message, connectionError) {
+			return true
+		}
+	}
+	return false
+}
+
+func newDaggerConnection(ctx context.Context, connect Connector) *daggerConnection {
+	return &daggerConnection{ctx: ctx, connect: connect}
+}
+
+func (c *daggerConnection) client() (*dagger.Client, error) {
+	dag, _, err := c.clientGeneration()
+	return dag, err
+}
+
+// clientGeneration returns the client along with its generation.
+func (c *daggerConnection) clientGeneration() (*dagger.Client, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dag != nil {
+		return c.dag, c.generation, nil
+	}
+	slog.Info("connecting to dagger")
+	dag, err := c.connect(c.ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.dag = dag
+	c.generation++
+	return dag, c.generation, nil
+}
+
+// drop closes the client of the given generation, if it is still the current one, so that the next tool call
+// connects again.
+func (c *daggerConnection) drop(generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dag == nil || c.generation != generation {
+		return
+	}
+	slog.Warn("Lost the connection to dagger, reconnecting on the next tool call")
+	c.dag.Close()
+	c.dag = nil
+}
+
+// dropOnConnectionError drops the client of generation if the outcome of a tool call that used it says that
+// its connection to the engine is gone. Tool calls that didn't use a client have generation 0.
+func (c *daggerConnection) dropOnConnectionError(generation int, result *mcp.CallToolResult, err error) {
+	switch {
+	case generation == 0:
+		return
+	case err != nil && isConnectionError(err.Error()):
+	case result != nil && result.IsError && isConnectionError(resultText(result)):
+	default:
+		return
+	}
+	c.drop(generation)
+}
+
+// Close closes the client, if the engine was connected to.
+func (c *daggerConnection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dag == nil {
+		return nil
+	}
+	err := c.dag.Close()
+	c.dag = nil
+	return err
+}
+
+// usedClientKey is the context key of the generation of the client a tool call used, see daggerClient.
+type usedClientKey struct{}
+
+// daggerClient returns the Dagger client of the server handling the tool call.
+func daggerClient(ctx context.Context) (*dagger.Client, error) {
+	conn, ok := ctx.Value(daggerClientKey{}).(*daggerConnection)
+	if !ok || conn == nil {
+		return nil, errors.New("dagger client not found in context")
+	}
+	dag, generation, err := conn.clientGeneration()
+	if used, ok := ctx.Value(usedClientKey{}).(*atomic.Int64); ok && err == nil {
+		used.Store(int64(generation))
+	}
+	return dag, err
+}
+
+// repositoryCache keeps the repositories opened by tool calls, keyed by environment source: opening one
+// runs several git commands, which would otherwise be paid by every call. Repositories whose source or fork
+// disappeared are opened again, and so are those a call failed to load an environment from, in case the
+// failure comes from a setup that changed since they were opened.
+type repositoryCache struct {
+	mu    sync.Mutex
+	repos map[string]*repository.Repository
+}
+
+var repositories = &repositoryCache{}
+
+func (c *repositoryCache) open(ctx context.Context, source string) (*repository.Repository, error) {
+	c.mu.Lock()
+	repo, ok := c.repos[source]
+	c.mu.Unlock()
+	if ok && repo.Valid() {
+		return repo, nil
+	}
+
+	repo, err := repository.Open(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.repos == nil {
+		c.repos = map[string]*repository.Repository{}
+	}
+	c.repos[source] = repo
+	return repo, nil
+}
+
+func (c *repositoryCache) forget(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.repos, source)
+}
@@ -0,0 +1,188 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaggerConnection(t *testing.T) {
+	type key struct{}
+	serverCtx := context.WithValue(context.Background(), key{}, "server")
+
+	attempts := 0
+	conn := newDaggerConnection(serverCtx, func(ctx context.Context) (*dagger.Client, error) {
+		attempts++
+		assert.Equal(t, "server", ctx.Value(key{}), "the connection outlives the tool call opening it")
+		if attempts == 1 {
+			return nil, errors.New("engine not running")
+		}
+		return &dagger.Client{}, nil
+	})
+	ctx := context.WithValue(context.Background(), daggerClientKey{}, conn)
+	assert.Equal(t, 0, attempts, "the engine is only connected to when a tool needs it")
+
+	_, err := daggerClient(ctx)
+	assert.ErrorContains(t, err, "engine not running")
+	dag, err := daggerClient(ctx)
+	require.NoError(t, err, "connecting is tried again after a failure")
+	again, err := daggerClient(ctx)
+	require.NoError(t, err)
+	assert.Same(t, dag, again)
+	assert.Equal(t, 2, attempts)
+
+	_, err = daggerClient(context.Background())
+	assert.Error(t, err)
+}
+
+func TestDaggerConnectionLost(t *testing.T) {
+	attempts := 0
+	conn := newDaggerConnection(context.Background(), func(context.Context) (*dagger.Client, error) {
+		attempts++
+		return &dagger.Client{}, nil
+	})
+	used := &atomic.Int64{}
+	ctx := context.WithValue(context.WithValue(context.Background(), daggerClientKey{}, conn), usedClientKey{}, used)
+
+	dag, err := daggerClient(ctx)
+	require.NoError(t, err)
+	first := int(used.Load())
+	assert.Equal(t, 1, first, "the generation of the client used by the call is recorded")
+
+	conn.dropOnConnectionError(first, mcp.NewToolResultError("failed to read file: input: container.file.contents Post \"http://dagger/query\": EOF"), nil)
+	again, err := daggerClient(ctx)
+	require.NoError(t, err)
+	assert.Same(t, dag, again, "other errors keep the client")
+
+	conn.dropOnConnectionError(0, nil, errors.New("read unix @->/run/dagger/engine.sock: connection reset by peer"))
+	again, err = daggerClient(ctx)
+	require.NoError(t, err)
+	assert.Same(t, dag, again, "calls that didn't use a client don't drop it")
+
+	conn.dropOnConnectionError(first, nil, errors.New("read unix @->/run/dagger/engine.sock: connection reset by peer"))
+	reconnected, err := daggerClient(ctx)
+	require.NoError(t, err)
+	assert.NotSame(t, dag, reconnected, "a client whose connection is lost is replaced")
+	assert.Equal(t, 2, attempts)
+
+	conn.dropOnConnectionError(first, nil, errors.New("connection refused"))
+	again, err = daggerClient(ctx)
+	require.NoError(t, err)
+	assert.Same(t, reconnected, again, "calls that used a previous client don't drop the new one")
+}
+
+func TestRepositoryCache(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("CONTAINER_USE_CONFIG_DIR", t.TempDir())
+	source := newTestRepository(t)
+	cache := &repositoryCache{}
+
+	repo, err := cache.open(ctx, source)
+	require.NoError(t, err)
+	again, err := cache.open(ctx, source)
+	require.NoError(t, err)
+	assert.Same(t, repo, again)
+
+	cache.forget(source)
+	reopened, err := cache.open(ctx, source)
+	require.NoError(t, err)
+	assert.NotSame(t, repo, reopened)
+
+	require.NoError(t, os.RemoveAll(repository.DefaultBasePath()))
+	assert.False(t, reopened.Valid())
+	recreated, err := cache.open(ctx, source)
+	require.NoError(t, err)
+	assert.NotSame(t, reopened, recreated, "repositories whose fork disappeared are opened again")
+	assert.True(t, recreated.Valid())
+}
+
+// BenchmarkOpenRepository compares opening the repository of a tool call with and without the cache.
+func BenchmarkOpenRepository(b *testing.B) {
+	ctx := context.Background()
+	b.Setenv("CONTAINER_USE_CONFIG_DIR", b.TempDir())
+	source := newTestRepository(b)
+
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			_, err := repository.Open(ctx, source)
+			require.NoError(b, err)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cache := &repositoryCache{}
+		for b.Loop() {
+			_, err := cache.open(ctx, source)
+			require.NoError(b, err)
+		}
+	})
+}
+
+// BenchmarkFileReadHotPath measures environment_file_read on the host, reading a revision of an environment
+// whose repository was opened by a previous call, against opening it again as every call used to. Reading the
+// container goes through the same path, plus a query to the engine.
+func BenchmarkFileReadHotPath(b *testing.B) {
+	ctx := context.Background()
+	b.Setenv("CONTAINER_USE_CONFIG_DIR", b.TempDir())
+	source := newTestRepository(b)
+	_, err := repositories.open(ctx, source)
+	require.NoError(b, err)
+	require.NoError(b, os.WriteFile(filepath.Join(source, "README.md"), []byte("# Test\n"), 0644))
+	for _, args := range [][]string{
+		{"add", "README.md"},
+		{"commit", "-m", "Environment"},
+		{"notes", "--ref", "container-use-state", "add", "-m", "{}"},
+		{"push", "container-use", "HEAD:refs/heads/fancy-mallard", "refs/notes/container-use-state"},
+	} {
+		_, err := repository.RunGitCommand(ctx, source, args...)
+		require.NoError(b, err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{
+		"environment_source": source,
+		"environment_id":     "fancy-mallard",
+		"target_file":        "README.md",
+		"revision":           "~0",
+	}
+	read := func(b *testing.B) {
+		result, err := EnvironmentFileReadTool.Handler(ctx, request)
+		require.NoError(b, err)
+		require.False(b, result.IsError, resultText(result))
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for b.Loop() {
+			repositories.forget(source)
+			read(b)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for b.Loop() {
+			read(b)
+		}
+	})
+}
+
+func newTestRepository(t testing.TB) string {
+	ctx := context.Background()
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"commit", "--allow-empty", "-m", "Initial commit"},
+	} {
+		_, err := repository.RunGitCommand(ctx, dir, args...)
+		require.NoError(t, err)
+	}
+	return dir
+}
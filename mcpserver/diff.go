@@ -0,0 +1,53 @@
+package mcpserver
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+)
+
+const (
+	// maxDiffBytesEnv bounds the size of the diffs returned to agents, larger diffs are summarized.
+	maxDiffBytesEnv     = "CONTAINER_USE_MAX_DIFF_BYTES"
+	defaultMaxDiffBytes = 32 * 1024
+)
+
+// maxDiffBytes returns the largest diff returned to agents as is.
+func maxDiffBytes() int {
+	value := os.Getenv(maxDiffBytesEnv)
+	if value == "" {
+		return defaultMaxDiffBytes
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		slog.Warn("Ignoring invalid diff size limit", "env", maxDiffBytesEnv, "value", value)
+		return defaultMaxDiffBytes
+	}
+	return limit
+}
+
+// summarizeDiff returns patch if it fits in limit bytes. Otherwise it returns the files it changes with
+// their line and hunk counts, followed by how to get the diff of specific files.
+func summarizeDiff(patch string, limit int, followUp string) (string, bool) {
+	if len(patch) <= limit {
+		return patch, false
+	}
+	return fmt.Sprintf("The diff is %d bytes, over the limit of %d, so only a summary is shown. %s\n\n%s",
+		len(patch), limit, followUp, repository.FormatDiffSummary(repository.ParseDiff(patch))), true
+}
+
+// truncateDiff cuts patch to at most limit bytes, at a line boundary.
+func truncateDiff(patch string, limit int) (string, bool) {
+	if len(patch) <= limit {
+		return patch, false
+	}
+	cut := patch[:limit]
+	if i := strings.LastIndexByte(cut, '\n'); i >= 0 {
+		cut = cut[:i+1]
+	}
+	return cut, true
+}
@@ -0,0 +1,42 @@
+package mcpserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeDiff(t *testing.T) {
+	patch := "diff --git a/package-lock.json b/package-lock.json\n--- a/package-lock.json\n+++ b/package-lock.json\n@@ -1,1 +1,1000 @@\n" +
+		strings.Repeat("+    \"resolved\": \"https://registry.npmjs.org/left-pad\",\n", 1000)
+
+	out, summarized := summarizeDiff(patch, len(patch), "Ask for paths.")
+	assert.False(t, summarized)
+	assert.Equal(t, patch, out)
+
+	out, summarized = summarizeDiff(patch, 1024, "Ask for paths.")
+	assert.True(t, summarized)
+	assert.Less(t, len(out), 1024)
+	assert.Contains(t, out, "Ask for paths.")
+	assert.Contains(t, out, " package-lock.json | +1000 -0 in 1 hunks\n")
+}
+
+func TestTruncateDiff(t *testing.T) {
+	out, truncated := truncateDiff("line 1\nline 2\nline 3\n", 10)
+	assert.True(t, truncated)
+	assert.Equal(t, "line 1\n", out)
+
+	out, truncated = truncateDiff("line 1\n", 10)
+	assert.False(t, truncated)
+	assert.Equal(t, "line 1\n", out)
+}
+
+func TestMaxDiffBytes(t *testing.T) {
+	t.Setenv(maxDiffBytesEnv, "")
+	assert.Equal(t, defaultMaxDiffBytes, maxDiffBytes())
+	t.Setenv(maxDiffBytesEnv, "4096")
+	assert.Equal(t, 4096, maxDiffBytes())
+	t.Setenv(maxDiffBytesEnv, "lots")
+	assert.Equal(t, defaultMaxDiffBytes, maxDiffBytes())
+}
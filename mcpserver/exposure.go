@@ -0,0 +1,55 @@
+package mcpserver
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/dagger/container-use/repository"
+)
+
+// ToolConfig selects the tools exposed to agents, see repository.ToolSettings.
+type ToolConfig repository.ToolSettings
+
+// Exposed returns the tools selected by c, failing on unknown tool names so that typos don't
+// silently expose more than intended.
+func (c *ToolConfig) Exposed() ([]*Tool, error) {
+	names := ToolNames()
+	for _, name := range slices.Concat(c.Enable, c.Disable) {
+		if !slices.Contains(names, name) {
+			return nil, fmt.Errorf("unknown tool %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	exposed := []*Tool{}
+	for _, tool := range tools {
+		name := tool.Definition.Name
+		switch {
+		case c.ReadOnly && !isReadOnly(tool):
+		case len(c.Enable) > 0 && !slices.Contains(c.Enable, name):
+		case slices.Contains(c.Disable, name):
+		default:
+			exposed = append(exposed, tool)
+		}
+	}
+	if len(exposed) == 0 {
+		return nil, errors.New("the tool configuration doesn't expose any tool")
+	}
+	return exposed, nil
+}
+
+// ToolNames returns the names of all the tools.
+func ToolNames() []string {
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool.Definition.Name
+	}
+	return names
+}
+
+// isReadOnly reports whether tool doesn't change environments, as told to clients by its annotations.
+func isReadOnly(tool *Tool) bool {
+	hint := tool.Definition.Annotations.ReadOnlyHint
+	return hint != nil && *hint
+}
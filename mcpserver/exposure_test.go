@@ -0,0 +1,60 @@
+package mcpserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exposedNames(t *testing.T, config ToolConfig) []string {
+	exposed, err := config.Exposed()
+	require.NoError(t, err)
+	names := []string{}
+	for _, tool := range exposed {
+		names = append(names, tool.Definition.Name)
+	}
+	return names
+}
+
+func TestToolConfigExposed(t *testing.T) {
+	assert.Equal(t, ToolNames(), exposedNames(t, ToolConfig{}), "every tool is exposed by default")
+
+	assert.Equal(t, []string{"environment_diff"}, exposedNames(t, ToolConfig{Enable: []string{"environment_diff"}}))
+
+	names := exposedNames(t, ToolConfig{Disable: []string{"environment_run_cmd"}})
+	assert.NotContains(t, names, "environment_run_cmd")
+	assert.Contains(t, names, "environment_file_write")
+
+	names = exposedNames(t, ToolConfig{ReadOnly: true, Disable: []string{"environment_file_search"}})
+	assert.NotContains(t, names, "environment_file_search")
+	assert.Contains(t, names, "environment_file_read")
+
+	_, err := (&ToolConfig{Disable: []string{"environment_run"}}).Exposed()
+	assert.ErrorContains(t, err, `unknown tool "environment_run"`)
+
+	_, err = (&ToolConfig{ReadOnly: true, Enable: []string{"environment_file_write"}}).Exposed()
+	assert.Error(t, err, "a configuration exposing no tool is a mistake")
+}
+
+func TestToolConfigReadOnly(t *testing.T) {
+	// Read-only access must never include a tool that changes environments or the host
+	assert.ElementsMatch(t, []string{
+		"environment_open",
+		"environment_list",
+		"environment_select",
+		"environment_history",
+		"environment_diff",
+		"environment_conflicts_list",
+		"environment_build_log",
+		"environment_process_list",
+		"environment_process_logs",
+		"environment_job_status",
+		"environment_file_read",
+		"environment_file_list",
+		"environment_file_search",
+		"environment_file_glob",
+		"environment_repo_stats",
+		"environment_dependencies",
+	}, exposedNames(t, ToolConfig{ReadOnly: true}))
+}
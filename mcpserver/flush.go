@@ -0,0 +1,106 @@
+package mcpserver
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+)
+
+// flushKey identifies an environment across repositories.
+type flushKey struct {
+	source, id string
+}
+
+// scheduledFlush is a flush of the changes of an environment left pending by the lazy propagation.
+type scheduledFlush struct {
+	timer *time.Timer
+	run   func()
+}
+
+// flushScheduler flushes the changes left pending by the lazy propagation once the flush interval elapsed,
+// rather than waiting for the next change of the environment, and when the server stops.
+type flushScheduler struct {
+	mu      sync.Mutex
+	flushes map[flushKey]*scheduledFlush
+}
+
+var pendingFlushes = &flushScheduler{flushes: map[flushKey]*scheduledFlush{}}
+
+// schedule flushes the pending changes of environment id of repo, opened from source, once the flush interval
+// of repo elapsed, unless a flush is scheduled already. The engine is reached through the connection of the
+// tool call in ctx.
+func (s *flushScheduler) schedule(ctx context.Context, repo *repository.Repository, source, id string) {
+	conn, ok := ctx.Value(daggerClientKey{}).(*daggerConnection)
+	if !ok || conn == nil {
+		return
+	}
+	key := flushKey{source: source, id: id}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.flushes[key]; ok {
+		return
+	}
+	flush := &scheduledFlush{}
+	flush.run = func() {
+		s.mu.Lock()
+		if s.flushes[key] != flush {
+			s.mu.Unlock()
+			return
+		}
+		delete(s.flushes, key)
+		s.mu.Unlock()
+		flushPending(conn, repo, id)
+	}
+	flush.timer = time.AfterFunc(repo.FlushInterval(), flush.run)
+	s.flushes[key] = flush
+}
+
+// flushAll runs the scheduled flushes right away, e.g. before the server stops.
+func (s *flushScheduler) flushAll() {
+	s.mu.Lock()
+	flushes := make([]*scheduledFlush, 0, len(s.flushes))
+	for _, flush := range s.flushes {
+		flushes = append(flushes, flush)
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, flush := range flushes {
+		// Flushes whose timer fired already are running or done
+		if flush.timer.Stop() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				flush.run()
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// flushPending commits the pending changes of environment id of repo, outside of any tool call.
+func flushPending(conn *daggerConnection, repo *repository.Repository, id string) {
+	ctx := context.WithoutCancel(conn.ctx)
+	dag, err := conn.client()
+	if err != nil {
+		slog.Warn("Failed to flush pending changes", "environment.id", id, "err", err)
+		return
+	}
+	env, err := repo.Get(ctx, dag, id)
+	if err != nil {
+		slog.Warn("Failed to flush pending changes", "environment.id", id, "err", err)
+		return
+	}
+	flushed, err := repo.Flush(ctx, env)
+	if err != nil {
+		slog.Warn("Failed to flush pending changes", "environment.id", id, "err", err)
+		return
+	}
+	if flushed {
+		slog.Info("Flushed pending changes", "environment.id", id)
+	}
+}
@@ -0,0 +1,40 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushScheduler(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("CONTAINER_USE_CONFIG_DIR", t.TempDir())
+	t.Setenv("CONTAINER_USE_FLUSH_INTERVAL", "1h")
+	source := newTestRepository(t)
+	repo, err := repository.Open(ctx, source)
+	require.NoError(t, err)
+
+	var connects atomic.Int32
+	conn := newDaggerConnection(ctx, func(context.Context) (*dagger.Client, error) {
+		connects.Add(1)
+		return nil, errors.New("engine not running")
+	})
+	ctx = context.WithValue(ctx, daggerClientKey{}, conn)
+
+	scheduler := &flushScheduler{flushes: map[flushKey]*scheduledFlush{}}
+	scheduler.schedule(ctx, repo, source, "fancy-mallard")
+	scheduler.schedule(ctx, repo, source, "fancy-mallard")
+	scheduler.schedule(ctx, repo, source, "shiny-heron")
+	assert.Len(t, scheduler.flushes, 2, "an environment has one flush scheduled at most")
+	assert.Equal(t, int32(0), connects.Load(), "flushes wait for the flush interval")
+
+	scheduler.flushAll()
+	assert.Equal(t, int32(2), connects.Load(), "scheduled flushes run when the server stops")
+	assert.Empty(t, scheduler.flushes)
+}
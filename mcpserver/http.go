@@ -0,0 +1,233 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HTTPOptions configure RunHTTPServer.
+type HTTPOptions struct {
+	// Addr is the address to listen on, e.g. :8080.
+	Addr string
+	// Token must be sent by clients as a bearer token.
+	Token string
+	// AllowedHosts are the host names, with an optional port, that clients may reach the server with besides
+	// the listen address, e.g. the name of a reverse proxy. When listening on all interfaces, the loopback
+	// addresses, the host name and the addresses of the interfaces of this machine are allowed.
+	AllowedHosts []string
+	// ConfigPath is the global configuration. It is watched while serving, and changes to the exposed tools
+	// and to the allowed mounts are applied without restarting.
+	ConfigPath string
+	// Tools selects the tools exposed to clients for a configuration, e.g. overriding its [tools] section
+	// with flags.
+	Tools func(*repository.Config) ToolConfig
+}
+
+// RunHTTPServer serves the tools over HTTP so that several remote agents can share this process: with
+// the streamable HTTP transport on /mcp, and the SSE transport on /sse and /message for older clients.
+// version is reported to clients in the server info.
+//
+// Unlike over stdio, cancellation notifications are not acted upon: a tool call is cancelled when its
+// client disconnects.
+func RunHTTPServer(ctx context.Context, connect Connector, version string, opts HTTPOptions) error {
+	if err := configureEngineGate(); err != nil {
+		return err
+	}
+	config, err := repository.LoadConfig(opts.ConfigPath)
+	if err != nil {
+		return err
+	}
+	toolConfig := opts.Tools(config)
+	exposed, err := toolConfig.Exposed()
+	if err != nil {
+		return err
+	}
+	if opts.Token == "" {
+		return errors.New("a token is required to serve the tools over HTTP")
+	}
+	hosts, err := allowedHosts(opts.Addr, opts.AllowedHosts)
+	if err != nil {
+		return err
+	}
+
+	dag := newDaggerConnection(ctx, connect)
+	defer dag.Close()
+	// Commit the changes left pending by the lazy propagation before disconnecting
+	defer pendingFlushes.flushAll()
+
+	s, expose := newServer(dag, version, &server.Hooks{}, exposed)
+	sse := server.NewSSEServer(s, server.WithKeepAlive(true))
+
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", server.NewStreamableHTTPServer(s))
+	mux.Handle("/sse", sse)
+	mux.Handle("/message", sse)
+
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           checkHost(hosts, requireToken(opts.Token, mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go watchConfig(ctx, opts.ConfigPath, configPollInterval, config, func(old, new *repository.Config) {
+		var applyErr error
+		if next := opts.Tools(new); !reflect.DeepEqual(next, toolConfig) {
+			if exposed, err := next.Exposed(); err != nil {
+				applyErr = err
+			} else {
+				expose(exposed)
+				toolConfig = next
+			}
+		}
+		// Mounts are checked against the configuration file whenever an environment is loaded: the new
+		// allowlist is in effect already.
+		for _, change := range configChanges(old, new) {
+			var err error
+			if strings.HasPrefix(change.Setting, "tools.") {
+				err = applyErr
+			}
+			auditConfigChange(filepath.Dir(opts.ConfigPath), change, err)
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		sse.Shutdown(shutdownCtx)
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("starting server", "addr", opts.Addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// requireToken rejects requests that don't carry token as a bearer token. An empty token rejects every request.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="container-use"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback reports whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkHost rejects the requests of web pages: those with an Origin header that isn't a loopback address or
+// an allowed host, since the SSE transport lets any origin read its responses, and those whose Host header
+// isn't an allowed host, which DNS rebinding would otherwise use to reach the server from a page of another
+// domain. hosts are lowercase host:port pairs.
+func checkHost(hosts map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hosts[hostWithPort(r.Host, "80")] {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && !allowedOrigin(origin, hosts) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedOrigin reports whether origin, the scheme, host and port of a web page, is served from this machine
+// or one of hosts.
+func allowedOrigin(origin string, hosts map[string]bool) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	if hosts[hostWithPort(u.Host, port)] {
+		return true
+	}
+	host := u.Hostname()
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// allowedHosts returns the host:port pairs clients may use to reach a server listening on addr: the listen
+// address and extra, along with the loopback addresses when listening on one or on all interfaces, and the
+// host name and the interface addresses of this machine in the latter case.
+func allowedHosts(addr string, extra []string) (map[string]bool, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	names := []string{host}
+	ip := net.ParseIP(host)
+	wildcard := host == "" || ip != nil && ip.IsUnspecified()
+	if wildcard || isLoopback(addr) {
+		names = append(names, "localhost", "127.0.0.1", "::1")
+	}
+	if wildcard {
+		if hostname, err := os.Hostname(); err == nil {
+			names = append(names, hostname)
+		}
+		if addrs, err := net.InterfaceAddrs(); err == nil {
+			for _, a := range addrs {
+				if network, ok := a.(*net.IPNet); ok {
+					names = append(names, network.IP.String())
+				}
+			}
+		}
+	}
+
+	hosts := map[string]bool{}
+	for _, name := range names {
+		if name != "" {
+			hosts[hostWithPort(net.JoinHostPort(name, port), port)] = true
+		}
+	}
+	for _, name := range extra {
+		hosts[hostWithPort(name, port)] = true
+	}
+	return hosts, nil
+}
+
+// hostWithPort normalizes hostport, a host with an optional port as found in Host headers, to a lowercase
+// host:port pair, using port when it has none.
+func hostWithPort(hostport, port string) string {
+	hostport = strings.ToLower(hostport)
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		return net.JoinHostPort(h, p)
+	}
+	return net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+}
@@ -0,0 +1,125 @@
+package mcpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{name: "no token configured", authorization: "Bearer ", want: http.StatusUnauthorized},
+		{name: "valid token", token: "s3cret", authorization: "Bearer s3cret", want: http.StatusNoContent},
+		{name: "missing token", token: "s3cret", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "s3cret", authorization: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "wrong scheme", token: "s3cret", authorization: "Basic s3cret", want: http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			requireToken(tt.token, ok).ServeHTTP(recorder, request)
+			assert.Equal(t, tt.want, recorder.Code)
+		})
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name   string
+		addr   string
+		extra  []string
+		host   string
+		origin string
+		want   int
+	}{
+		{name: "listen address", addr: "localhost:8080", host: "localhost:8080", want: http.StatusNoContent},
+		{name: "loopback alias", addr: "localhost:8080", host: "127.0.0.1:8080", want: http.StatusNoContent},
+		{name: "loopback origin", addr: "localhost:8080", host: "localhost:8080", origin: "http://localhost:3000", want: http.StatusNoContent},
+		{name: "dns rebinding", addr: "localhost:8080", host: "attacker.example:8080", want: http.StatusForbidden},
+		{name: "other port", addr: "localhost:8080", host: "localhost:9090", want: http.StatusForbidden},
+		{name: "cross origin", addr: "localhost:8080", host: "localhost:8080", origin: "https://attacker.example", want: http.StatusForbidden},
+		{name: "null origin", addr: "localhost:8080", host: "localhost:8080", origin: "null", want: http.StatusForbidden},
+		{name: "all interfaces", addr: ":8080", host: "localhost:8080", want: http.StatusNoContent},
+		{name: "all interfaces unknown host", addr: ":8080", host: "attacker.example:8080", want: http.StatusForbidden},
+		{name: "proxy", addr: "localhost:8080", extra: []string{"cu.internal:443"}, host: "cu.internal:443", origin: "https://cu.internal", want: http.StatusNoContent},
+		{name: "proxy default port", addr: "localhost:8080", extra: []string{"CU.internal"}, host: "cu.internal", want: http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := allowedHosts(tt.addr, tt.extra)
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+			request.Host = tt.host
+			if tt.origin != "" {
+				request.Header.Set("Origin", tt.origin)
+			}
+			recorder := httptest.NewRecorder()
+			checkHost(hosts, ok).ServeHTTP(recorder, request)
+			assert.Equal(t, tt.want, recorder.Code)
+		})
+	}
+
+	_, err := allowedHosts("localhost", nil)
+	assert.Error(t, err)
+}
+
+func TestIsLoopback(t *testing.T) {
+	assert.True(t, isLoopback("localhost:8080"))
+	assert.True(t, isLoopback("127.0.0.1:8080"))
+	assert.True(t, isLoopback("[::1]:8080"))
+	assert.False(t, isLoopback(":8080"))
+	assert.False(t, isLoopback("0.0.0.0:8080"))
+	assert.False(t, isLoopback("192.168.1.10:8080"))
+}
+
+func TestStreamableHTTPListsTools(t *testing.T) {
+	s, _ := newServer(nil, "test", &server.Hooks{}, Tools())
+	srv := httptest.NewServer(requireToken("s3cret", server.NewStreamableHTTPServer(s)))
+	defer srv.Close()
+
+	post := func(sessionID, body string) *http.Response {
+		request, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer s3cret")
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept", "application/json, text/event-stream")
+		if sessionID != "" {
+			request.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		return response
+	}
+
+	response := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`)
+	response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	sessionID := response.Header.Get("Mcp-Session-Id")
+
+	response = post(sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"environment_create"`)
+}
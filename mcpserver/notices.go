@@ -0,0 +1,47 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type noticesKey struct{}
+
+// callNotices collects what a tool call needs to tell the agent besides its result.
+type callNotices struct {
+	mu      sync.Mutex
+	notices []string
+}
+
+// addNotice adds a notice to the result of the tool call ctx belongs to. Outside of a tool call wrapped
+// with withNotices, it does nothing.
+func addNotice(ctx context.Context, format string, args ...any) {
+	notices, ok := ctx.Value(noticesKey{}).(*callNotices)
+	if !ok {
+		return
+	}
+	notices.mu.Lock()
+	defer notices.mu.Unlock()
+	notices.notices = append(notices.notices, fmt.Sprintf(format, args...))
+}
+
+// withNotices appends the notices added during each tool call to its result.
+func withNotices(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		notices := &callNotices{}
+		result, err := handler(context.WithValue(ctx, noticesKey{}, notices), request)
+		if result == nil {
+			return result, err
+		}
+		notices.mu.Lock()
+		defer notices.mu.Unlock()
+		for _, notice := range notices.notices {
+			result.Content = append(result.Content, mcp.NewTextContent("Notice: "+notice))
+		}
+		return result, err
+	}
+}
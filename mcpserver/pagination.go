@@ -0,0 +1,55 @@
+package mcpserver
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultPageSize is the number of items returned by paginated tools when no limit is given.
+const defaultPageSize = 50
+
+// limitOption and cursorOption are the arguments of the tools returning a page of a longer listing.
+func limitOption(items string) mcp.ToolOption {
+	return mcp.WithNumber("limit",
+		mcp.Description(fmt.Sprintf("The maximum number of %s to return, %d by default.", items, defaultPageSize)),
+		mcp.Min(1),
+	)
+}
+
+func cursorOption(items string) mcp.ToolOption {
+	return mcp.WithString("cursor",
+		mcp.Description(fmt.Sprintf("The next_cursor of a previous call, to get the %s that follow.", items)),
+	)
+}
+
+// pageParams returns the limit of the request and the key of the last item of the previous page,
+// empty for the first page.
+func pageParams(request mcp.CallToolRequest) (int, string, error) {
+	limit := request.GetInt("limit", defaultPageSize)
+	if limit < 1 {
+		return 0, "", fmt.Errorf("invalid limit %d: must be at least 1", limit)
+	}
+	cursor := request.GetString("cursor", "")
+	if cursor == "" {
+		return limit, "", nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(after) == 0 {
+		return 0, "", fmt.Errorf("invalid cursor %q: pass the next_cursor of a previous call", cursor)
+	}
+	return limit, string(after), nil
+}
+
+// paginate returns at most limit items starting at start, along with the cursor of the next page,
+// empty if this is the last one. key identifies an item for the next page to start after it.
+func paginate[T any](items []T, start, limit int, key func(T) string) ([]T, string) {
+	start = min(start, len(items))
+	end := min(start+limit, len(items))
+	page := items[start:end]
+	if end == len(items) || len(page) == 0 {
+		return page, ""
+	}
+	return page, base64.RawURLEncoding.EncodeToString([]byte(key(page[len(page)-1])))
+}
@@ -0,0 +1,86 @@
+package mcpserver
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	// Items are keyed by their value, which is also the index of the item following them
+	key := strconv.Itoa
+
+	var pages [][]int
+	after := ""
+	for {
+		start := 0
+		if after != "" {
+			n, err := strconv.Atoi(after)
+			require.NoError(t, err)
+			start = n
+		}
+		page, next := paginate(items, start, 2, key)
+		pages = append(pages, page)
+		if next == "" {
+			break
+		}
+		_, after, _ = pageParams(requestWithArgs(map[string]any{"cursor": next}))
+	}
+	assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, pages)
+
+	page, next := paginate(items, 10, 2, key)
+	assert.Empty(t, page)
+	assert.Empty(t, next)
+}
+
+func TestPageParams(t *testing.T) {
+	limit, after, err := pageParams(requestWithArgs(map[string]any{}))
+	require.NoError(t, err)
+	assert.Equal(t, defaultPageSize, limit)
+	assert.Empty(t, after)
+
+	_, _, err = pageParams(requestWithArgs(map[string]any{"limit": 0}))
+	assert.Error(t, err)
+	_, _, err = pageParams(requestWithArgs(map[string]any{"cursor": "not a cursor!"}))
+	assert.Error(t, err)
+}
+
+func TestEnvironmentListStart(t *testing.T) {
+	now := time.Now()
+	summary := func(id string, updatedAt time.Time) *repository.EnvironmentSummary {
+		return &repository.EnvironmentSummary{
+			EnvironmentInfo: &environment.EnvironmentInfo{ID: id},
+			UpdatedAt:       updatedAt,
+		}
+	}
+	envs := []*repository.EnvironmentSummary{
+		summary("fancy-mallard", now),
+		summary("calm-otter", now.Add(-time.Minute)),
+		summary("eager-fox", now.Add(-time.Minute)),
+		summary("brave-owl", now.Add(-time.Hour)),
+	}
+
+	start, err := environmentListStart(envs, "")
+	require.NoError(t, err)
+	assert.Equal(t, 0, start)
+
+	start, err = environmentListStart(envs, environmentListKey(envs[1]))
+	require.NoError(t, err)
+	assert.Equal(t, 2, start)
+
+	// The environment of the cursor was updated since: the next page continues where the previous one stopped
+	after := environmentListKey(envs[2])
+	moved := []*repository.EnvironmentSummary{summary("eager-fox", now.Add(time.Second)), envs[0], envs[1], envs[3]}
+	start, err = environmentListStart(moved, after)
+	require.NoError(t, err)
+	assert.Equal(t, 3, start)
+
+	_, err = environmentListStart(envs, "garbage")
+	assert.Error(t, err)
+}
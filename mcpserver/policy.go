@@ -0,0 +1,152 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// policyViolation is the structured error returned to agents for commands the command policy doesn't let run.
+type policyViolation struct {
+	Error   string                   `json:"error"`
+	Action  environment.PolicyAction `json:"action"`
+	Command string                   `json:"command"`
+	Pattern string                   `json:"pattern"`
+	Policy  string                   `json:"policy"`
+	Message string                   `json:"message"`
+	// ApproveCommand is what the user runs to approve the command, for commands that require approval.
+	ApproveCommand string `json:"approve_command,omitempty"`
+	// ApprovalID is the approval request the command was parked in, when the policy waits for approvals.
+	ApprovalID string `json:"approval_id,omitempty"`
+	// Reason is what the user gave when denying the approval request.
+	Reason string `json:"reason,omitempty"`
+}
+
+// checkCommandsPolicy checks each of commands with checkCommandPolicy, and returns the result of the first
+// one that may not run.
+func checkCommandsPolicy(ctx context.Context, request mcp.CallToolRequest, repo *repository.Repository, id string, commands []string) (*mcp.CallToolResult, error) {
+	for _, command := range commands {
+		if command == "" {
+			continue
+		}
+		if denied, err := checkCommandPolicy(ctx, request, repo, id, command); denied != nil || err != nil {
+			return denied, err
+		}
+	}
+	return nil, nil
+}
+
+// checkCommandPolicy returns an error result if the policy of repo doesn't let command run in environment
+// id, or nil if it may run. Commands that require approval run if they were approved ahead of time, which
+// uses up the approval. Otherwise, if the policy says so, they are parked until the user decides on them.
+func checkCommandPolicy(ctx context.Context, request mcp.CallToolRequest, repo *repository.Repository, id, command string) (*mcp.CallToolResult, error) {
+	policy, err := repo.CommandPolicy()
+	if err != nil {
+		return mcp.NewToolResultErrorFromErr("unable to load the command policy", err), nil
+	}
+	var violation *environment.PolicyViolationError
+	if err := policy.Check(command); !errors.As(err, &violation) {
+		return nil, nil
+	}
+
+	response := &policyViolation{
+		Error:   "policy_violation",
+		Action:  violation.Action,
+		Command: violation.Command,
+		Pattern: violation.Pattern,
+		Policy:  violation.Policy,
+		Message: violation.Error(),
+	}
+	if violation.Action == environment.PolicyRequireApproval {
+		approved, err := repo.ConsumeApproval(id, command)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to check approvals", err), nil
+		}
+		if approved {
+			return nil, nil
+		}
+		if !policy.Approval.Wait {
+			response.ApproveCommand = approveCommandLine(id, command)
+		} else if approved, err := waitForApproval(ctx, request, repo, id, command, policy, response); err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to wait for approval", err), nil
+		} else if approved {
+			return nil, nil
+		}
+	}
+
+	out, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultError(string(out)), nil
+}
+
+// waitForApproval parks command until the user decides on it, sending progress notifications meanwhile,
+// and reports whether it was approved. Otherwise, response is updated with why it can't run.
+func waitForApproval(ctx context.Context, request mcp.CallToolRequest, repo *repository.Repository, id, command string, policy *environment.CommandPolicy, response *policyViolation) (bool, error) {
+	approval, err := repo.RequestApproval(id, command, response.Pattern)
+	if err != nil {
+		return false, err
+	}
+	response.ApprovalID = approval.ID
+	slog.Info("Waiting for approval", "environment.id", id, "approval.id", approval.ID, "command", command)
+	if policy.Approval.Notify {
+		notifyDesktop("container-use: approval needed",
+			fmt.Sprintf("%s wants to run %q. Run: container-use approvals approve %s", id, approval.Command, approval.ID))
+	}
+
+	done := startProgress(ctx, request, fmt.Sprintf("waiting for the user to approve %s", approval.ID))
+	decision, err := repo.WaitForApproval(ctx, approval.ID, policy.Approval.Timeout)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		done("cancelled")
+		return false, err
+	case err != nil:
+		done("no decision")
+		response.Message = fmt.Sprintf("%q requires the approval of the user, who didn't decide within %s: ask them to approve it with container-use approvals, then run it again",
+			response.Command, policy.Approval.Timeout)
+		response.ApproveCommand = approveCommandLine(id, command)
+		return false, nil
+	case decision.Status == repository.ApprovalApproved:
+		done("approved")
+		return true, nil
+	default:
+		done("denied")
+		response.Reason = decision.Reason
+		response.Message = fmt.Sprintf("the user denied running %q: do not try to work around it", response.Command)
+		if decision.Reason != "" {
+			response.Message += ". Their reason: " + decision.Reason
+		}
+		return false, nil
+	}
+}
+
+// approveCommandLine returns the command approving command ahead of time in environment id.
+func approveCommandLine(id, command string) string {
+	return fmt.Sprintf("container-use approvals approve %s -- '%s'", id, strings.ReplaceAll(command, "'", `'\''`))
+}
+
+// notifyDesktop shows a desktop notification, if the platform has a way to.
+func notifyDesktop(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	case "linux":
+		cmd = exec.Command("notify-send", title, message)
+	default:
+		return
+	}
+	if err := cmd.Run(); err != nil {
+		slog.Debug("Failed to show desktop notification", "err", err)
+	}
+}
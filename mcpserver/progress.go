@@ -0,0 +1,81 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+var progressInterval = 5 * time.Second
+
+// startProgress periodically notifies the client that a long-running tool call is still making progress.
+// Notifications are only sent if the client asked for them by providing a progress token.
+// The returned function stops the notifications and sends a final one with the given message.
+func startProgress(ctx context.Context, request mcp.CallToolRequest, operation string) func(message string) {
+	_, stop := startOutputProgress(ctx, request, operation)
+	return stop
+}
+
+// startOutputProgress is startProgress for tool calls with output, such as commands: the first returned
+// function sends a chunk of output as a progress notification, so that the client sees it before the call
+// returns. It is nil if the client didn't provide a progress token, so that the output isn't collected.
+func startOutputProgress(ctx context.Context, request mcp.CallToolRequest, operation string) (func(chunk string), func(message string)) {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil, func(string) {}
+	}
+	srv := server.ServerFromContext(ctx)
+	if srv == nil {
+		return nil, func(string) {}
+	}
+	token := request.Params.Meta.ProgressToken
+
+	var mu sync.Mutex
+	progress := 0
+	notify := func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress++
+		if err := srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+			"progressToken": token,
+			"progress":      progress,
+			"message":       message,
+		}); err != nil {
+			slog.Debug("Failed to send progress notification", "err", err)
+		}
+	}
+
+	start := time.Now()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				notify(fmt.Sprintf("%s: still running after %s", operation, time.Since(start).Round(time.Second)))
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	output := func(chunk string) {
+		if chunk != "" {
+			notify(chunk)
+		}
+	}
+	return output, func(message string) {
+		close(done)
+		<-stopped
+		notify(fmt.Sprintf("%s: %s", operation, message))
+	}
+}
@@ -0,0 +1,98 @@
+package mcpserver
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+)
+
+// configPollInterval is how often a long-lived server checks its configuration file for changes.
+const configPollInterval = 2 * time.Second
+
+// configChange is a setting of the configuration that changed, named after its TOML key.
+type configChange struct {
+	Setting string
+	Old     any
+	New     any
+}
+
+// configChanges returns the settings that differ between old and new.
+func configChanges(old, new *repository.Config) []configChange {
+	changes := []configChange{}
+	for _, setting := range []struct {
+		name     string
+		old, new any
+	}{
+		{"tools.read_only", old.Tools.ReadOnly, new.Tools.ReadOnly},
+		{"tools.enable", old.Tools.Enable, new.Tools.Enable},
+		{"tools.disable", old.Tools.Disable, new.Tools.Disable},
+		{"mounts.allow", old.Mounts.Allow, new.Mounts.Allow},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changes = append(changes, configChange{Setting: setting.name, Old: setting.old, New: setting.new})
+		}
+	}
+	return changes
+}
+
+// watchConfig polls the configuration file at path until ctx is done, and calls apply with the previous and the
+// new configuration whenever its content changes. current is the configuration the caller started with.
+// Configurations that fail to load are logged and skipped, keeping the previous one in effect.
+func watchConfig(ctx context.Context, path string, interval time.Duration, current *repository.Config, apply func(old, new *repository.Config)) {
+	// Checked on the first tick, in case the file changed since current was loaded
+	var modTime time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var latest time.Time
+		if info, err := os.Stat(path); err == nil {
+			latest = info.ModTime()
+		}
+		if latest.Equal(modTime) {
+			continue
+		}
+		modTime = latest
+
+		config, err := repository.LoadConfig(path)
+		if err != nil {
+			slog.Warn("Ignoring the changed configuration", "path", path, "err", err)
+			continue
+		}
+		if reflect.DeepEqual(config, current) {
+			continue
+		}
+		apply(current, config)
+		current = config
+	}
+}
+
+// auditConfigChange records a change of the configuration applied by a server in the audit log of the servers
+// in basePath. applyErr is why the change could not be applied, if so.
+func auditConfigChange(basePath string, change configChange, applyErr error) {
+	entry := &repository.AuditEntry{
+		Time:      time.Now().UTC(),
+		Tool:      "config_reload",
+		Arguments: map[string]any{"setting": change.Setting, "old": change.Old, "new": change.New},
+		Status:    repository.AuditOK,
+	}
+	if applyErr != nil {
+		entry.Status = repository.AuditFailed
+		entry.Error = truncateAudit(applyErr.Error(), maxAuditError)
+		slog.Warn("Failed to apply configuration change", "setting", change.Setting, "err", applyErr)
+	} else {
+		slog.Info("Applied configuration change", "setting", change.Setting, "old", change.Old, "new", change.New)
+	}
+	if err := repository.RecordServerAudit(basePath, entry); err != nil {
+		slog.Warn("Failed to record configuration change in the audit log", "setting", change.Setting, "err", err)
+	}
+}
@@ -0,0 +1,58 @@
+package mcpserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/repository"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigChanges(t *testing.T) {
+	old := &repository.Config{Tools: repository.ToolSettings{Disable: []string{"environment_checkpoint"}}}
+	assert.Empty(t, configChanges(old, old))
+
+	new := &repository.Config{
+		Tools:  repository.ToolSettings{ReadOnly: true, Disable: []string{"environment_checkpoint"}},
+		Mounts: repository.MountSettings{Allow: []string{"/opt/toolchains"}},
+	}
+	assert.Equal(t, []configChange{
+		{Setting: "tools.read_only", Old: false, New: true},
+		{Setting: "mounts.allow", Old: []string(nil), New: []string{"/opt/toolchains"}},
+	}, configChanges(old, new))
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *repository.Config, 1)
+	go watchConfig(ctx, path, 10*time.Millisecond, &repository.Config{}, func(_, new *repository.Config) {
+		applied <- new
+	})
+
+	write := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	write("[tools]\nread_only = true\n", time.Now().Add(-time.Minute))
+	select {
+	case config := <-applied:
+		assert.True(t, config.Tools.ReadOnly)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the change was not applied")
+	}
+
+	write("[tools\n", time.Now().Add(-30*time.Second))
+	write("[tools]\nread_only = true\n", time.Now())
+	select {
+	case config := <-applied:
+		t.Fatalf("invalid or unchanged configurations are not applied, got %+v", config)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,232 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/dagger/container-use/repository"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// recentLogLength is the number of commits in the log resource of an environment.
+const recentLogLength = 20
+
+// environmentResource is a document of an environment published as the MCP resource
+// container-use://<environment_id>/<name>.
+type environmentResource struct {
+	name        string
+	description string
+	mimeType    string
+	read        func(ctx context.Context, repo *repository.Repository, envInfo *environment.EnvironmentInfo) (string, error)
+}
+
+var environmentResourceTypes = []*environmentResource{
+	{
+		name:        "instructions",
+		description: "Instructions of the environment for agents working in it, from its AGENT.md.",
+		mimeType:    "text/markdown",
+		read: func(_ context.Context, _ *repository.Repository, envInfo *environment.EnvironmentInfo) (string, error) {
+			return envInfo.Config.Instructions, nil
+		},
+	},
+	{
+		name:        "state",
+		description: "State of the environment as recorded in environment.json: title, endpoints, checkpoint, setup timings.",
+		mimeType:    "application/json",
+		read: func(_ context.Context, _ *repository.Repository, envInfo *environment.EnvironmentInfo) (string, error) {
+			state, err := envInfo.State.Marshal()
+			return string(state), err
+		},
+	},
+	{
+		name:        "log",
+		description: fmt.Sprintf("The %d latest commits of the environment, with the operations that produced them.", recentLogLength),
+		mimeType:    "text/plain",
+		read: func(ctx context.Context, repo *repository.Repository, envInfo *environment.EnvironmentInfo) (string, error) {
+			var log strings.Builder
+			err := repo.RecentLog(ctx, envInfo.ID, recentLogLength, &log)
+			return log.String(), err
+		},
+	},
+}
+
+func environmentResourceURI(id, name string) string {
+	return fmt.Sprintf("container-use://%s/%s", id, name)
+}
+
+// environmentResources publishes environments as MCP resources. Environments are found from the tool
+// calls of every session, or from the source selected by the session with environment_select.
+//
+// mcp-go doesn't handle resources/subscribe requests, so reading a resource subscribes the session to
+// it instead: once a tool call changes the environment, notifications/resources/updated is sent for
+// each of its resources to every session that read one.
+type environmentResources struct {
+	server *server.MCPServer
+
+	mu sync.Mutex
+	// sources maps environment IDs to their source repository, as given to tools.
+	sources map[string]string
+	// readers maps environment IDs to the set of sessions that read their resources.
+	readers map[string]map[string]bool
+	// versions maps environment IDs to the time they were last updated when their readers last heard of them.
+	versions map[string]time.Time
+}
+
+func newEnvironmentResources() *environmentResources {
+	return &environmentResources{
+		sources:  map[string]string{},
+		readers:  map[string]map[string]bool{},
+		versions: map[string]time.Time{},
+	}
+}
+
+// register adds the resource templates to s, which notifications are sent through.
+func (r *environmentResources) register(s *server.MCPServer) {
+	r.server = s
+	for _, resource := range environmentResourceTypes {
+		s.AddResourceTemplate(
+			mcp.NewResourceTemplate(
+				environmentResourceURI("{environment_id}", resource.name),
+				"Environment "+resource.name,
+				mcp.WithTemplateDescription(resource.description),
+				mcp.WithTemplateMIMEType(resource.mimeType),
+			),
+			func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+				return r.read(ctx, request, resource)
+			},
+		)
+	}
+}
+
+func (r *environmentResources) read(ctx context.Context, request mcp.ReadResourceRequest, resource *environmentResource) ([]mcp.ResourceContents, error) {
+	id := templateArgument(request.Params.Arguments, "environment_id")
+	source := r.source(ctx, id)
+	if source == "" {
+		return nil, fmt.Errorf("unknown environment %q: use it in a tool call first, or select its repository with environment_select", id)
+	}
+	repo, err := repositories.open(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	envInfo, err := repo.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	text, err := resource.read(ctx, repo, envInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		r.mu.Lock()
+		if r.readers[id] == nil {
+			r.readers[id] = map[string]bool{}
+			r.versions[id] = envInfo.State.UpdatedAt
+		}
+		r.readers[id][session.SessionID()] = true
+		r.mu.Unlock()
+	}
+
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{URI: request.Params.URI, MIMEType: resource.mimeType, Text: text},
+	}, nil
+}
+
+// source returns the source repository of environment id, if known.
+func (r *environmentResources) source(ctx context.Context, id string) string {
+	r.mu.Lock()
+	source := r.sources[id]
+	r.mu.Unlock()
+	if source != "" {
+		return source
+	}
+	if selection := selectedContext(ctx); selection != nil {
+		return selection.Source
+	}
+	return ""
+}
+
+// track wraps the handler of a tool to record the environment of each call, and to notify the readers
+// of its resources if the call changed it.
+func (r *environmentResources) track(handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := handler(ctx, request)
+		if request, defaultsErr := withSessionDefaults(ctx, request, sessionArguments); defaultsErr == nil {
+			r.changed(ctx, request.GetString("environment_source", ""), request.GetString("environment_id", ""))
+		}
+		return result, err
+	}
+}
+
+// changed notifies the readers of environment id if it was updated since they last heard of it.
+func (r *environmentResources) changed(ctx context.Context, source, id string) {
+	r.mu.Lock()
+	r.sources[id] = source
+	readers := len(r.readers[id])
+	r.mu.Unlock()
+	if readers == 0 {
+		return
+	}
+
+	var updatedAt time.Time
+	if repo, err := repositories.open(ctx, source); err == nil {
+		if envInfo, err := repo.Info(ctx, id); err == nil {
+			updatedAt = envInfo.State.UpdatedAt
+		}
+	}
+
+	r.mu.Lock()
+	if r.versions[id].Equal(updatedAt) {
+		r.mu.Unlock()
+		return
+	}
+	r.versions[id] = updatedAt
+	sessions := make([]string, 0, len(r.readers[id]))
+	for session := range r.readers[id] {
+		sessions = append(sessions, session)
+	}
+	r.mu.Unlock()
+
+	for _, session := range sessions {
+		for _, resource := range environmentResourceTypes {
+			err := r.server.SendNotificationToSpecificClient(session, mcp.MethodNotificationResourceUpdated, map[string]any{
+				"uri": environmentResourceURI(id, resource.name),
+			})
+			if err != nil {
+				slog.Warn("Failed to notify resource update", "environment.id", id, "session", session, "err", err)
+			}
+		}
+	}
+}
+
+// forget drops the subscriptions of a session once its client is gone.
+func (r *environmentResources) forget(_ context.Context, session server.ClientSession) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, readers := range r.readers {
+		delete(readers, session.SessionID())
+		if len(readers) == 0 {
+			delete(r.readers, id)
+			delete(r.versions, id)
+		}
+	}
+}
+
+// templateArgument returns the value of the variable name of a resource template.
+func templateArgument(arguments map[string]any, name string) string {
+	switch value := arguments[name].(type) {
+	case string:
+		return value
+	case []string:
+		if len(value) > 0 {
+			return value[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentResourceTemplates(t *testing.T) {
+	s, _ := newServer(nil, "test", &server.Hooks{}, Tools())
+
+	response := s.HandleMessage(context.Background(), json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"resources/templates/list"}`))
+	out, err := json.Marshal(response)
+	require.NoError(t, err)
+
+	var list struct {
+		Result mcp.ListResourceTemplatesResult `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(out, &list))
+	templates := []string{}
+	for _, template := range list.Result.ResourceTemplates {
+		templates = append(templates, template.URITemplate.Raw())
+	}
+	assert.ElementsMatch(t, []string{
+		"container-use://{environment_id}/instructions",
+		"container-use://{environment_id}/state",
+		"container-use://{environment_id}/log",
+	}, templates)
+}
+
+func TestEnvironmentResourceUnknownEnvironment(t *testing.T) {
+	s, _ := newServer(nil, "test", &server.Hooks{}, Tools())
+
+	response := s.HandleMessage(context.Background(), json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":"container-use://fancy-mallard/state"}}`))
+	rpcErr, ok := response.(mcp.JSONRPCError)
+	require.True(t, ok, "reading an environment that was never used must fail, got %#v", response)
+	assert.Contains(t, rpcErr.Error.Message, "unknown environment")
+}
+
+func TestEnvironmentResourcesTrack(t *testing.T) {
+	resources := newEnvironmentResources()
+	handler := resources.track(func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("ok"), nil
+	})
+
+	ctx := sessionContext(t, "tracked")
+	_, err := handler(ctx, requestWithArgs(map[string]any{"environment_source": "/src", "environment_id": "env"}))
+	require.NoError(t, err)
+	assert.Equal(t, "/src", resources.source(ctx, "env"))
+
+	// Calls without an environment are not recorded
+	_, err = handler(ctx, requestWithArgs(map[string]any{"environment_source": "/other"}))
+	require.NoError(t, err)
+	assert.Equal(t, "", resources.source(ctx, "other"))
+
+	// The source selected by the session is the fallback
+	sessionDefaults.Store("tracked", &sessionSelection{Source: "/selected"})
+	assert.Equal(t, "/selected", resources.source(ctx, "other"))
+}
+
+func TestEnvironmentResourcesForget(t *testing.T) {
+	resources := newEnvironmentResources()
+	resources.readers["env"] = map[string]bool{"gone": true, "kept": true}
+	resources.readers["other"] = map[string]bool{"gone": true}
+
+	resources.forget(context.Background(), &fakeSession{id: "gone"})
+	assert.Equal(t, map[string]map[string]bool{"env": {"kept": true}}, resources.readers)
+}
+
+func TestTemplateArgument(t *testing.T) {
+	assert.Equal(t, "env", templateArgument(map[string]any{"environment_id": []string{"env"}}, "environment_id"))
+	assert.Equal(t, "env", templateArgument(map[string]any{"environment_id": "env"}, "environment_id"))
+	assert.Equal(t, "", templateArgument(map[string]any{}, "environment_id"))
+}
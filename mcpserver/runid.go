@@ -0,0 +1,77 @@
+package mcpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// correlationIDMetaKey is the request _meta field clients can set to provide their own run ID.
+const correlationIDMetaKey = "correlation_id"
+
+type runIDKey struct{}
+
+// withRunID stamps the tool call with a run ID: the client-provided correlation ID if any, or a random one.
+func withRunID(ctx context.Context, request mcp.CallToolRequest) (context.Context, string) {
+	id := ""
+	if meta := request.Params.Meta; meta != nil {
+		id, _ = meta.AdditionalFields[correlationIDMetaKey].(string)
+	}
+	if id == "" {
+		id = newRunID()
+	}
+	return context.WithValue(ctx, runIDKey{}, id), id
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// runID returns the run ID of the tool call ctx belongs to, or an empty string outside of a tool call.
+func runID(ctx context.Context) string {
+	id, _ := ctx.Value(runIDKey{}).(string)
+	return id
+}
+
+// withRunIDLogging logs the start and outcome of each tool call along with its run ID, and reports the run ID
+// back to the client in the result _meta and, for failures, in the error text.
+func withRunIDLogging(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		ctx, id := withRunID(ctx, request)
+		logger := slog.With("tool", name, "run_id", id)
+
+		start := time.Now()
+		logger.Info("tool call started")
+		result, err := handler(ctx, request)
+		duration := time.Since(start)
+
+		switch {
+		case err != nil:
+			logger.Error("tool call failed", "duration", duration, "err", err)
+			return result, fmt.Errorf("%w (run ID: %s)", err, id)
+		case result != nil && result.IsError:
+			logger.Warn("tool call returned an error", "duration", duration)
+			result.Content = append(result.Content, mcp.NewTextContent("run ID: "+id))
+		default:
+			logger.Info("tool call finished", "duration", duration)
+		}
+
+		if result != nil {
+			if result.Meta == nil {
+				result.Meta = map[string]any{}
+			}
+			result.Meta[correlationIDMetaKey] = id
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,70 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRunID(t *testing.T) {
+	t.Run("client_correlation_id", func(t *testing.T) {
+		request := requestWithArgs(map[string]any{})
+		request.Params.Meta = &mcp.Meta{AdditionalFields: map[string]any{correlationIDMetaKey: "agent-42"}}
+
+		ctx, id := withRunID(context.Background(), request)
+		assert.Equal(t, "agent-42", id)
+		assert.Equal(t, "agent-42", runID(ctx))
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		_, first := withRunID(context.Background(), requestWithArgs(map[string]any{}))
+		_, second := withRunID(context.Background(), requestWithArgs(map[string]any{}))
+		assert.Len(t, first, 16)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("outside_tool_call", func(t *testing.T) {
+		assert.Empty(t, runID(context.Background()))
+	})
+}
+
+func TestWithRunIDLogging(t *testing.T) {
+	request := requestWithArgs(map[string]any{})
+	request.Params.Meta = &mcp.Meta{AdditionalFields: map[string]any{correlationIDMetaKey: "run-1"}}
+
+	t.Run("success", func(t *testing.T) {
+		handler := withRunIDLogging("test", func(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			assert.Equal(t, "run-1", runID(ctx))
+			return mcp.NewToolResultText("ok"), nil
+		})
+
+		result, err := handler(context.Background(), request)
+		require.NoError(t, err)
+		assert.Equal(t, "run-1", result.Meta[correlationIDMetaKey])
+		assert.Len(t, result.Content, 1)
+	})
+
+	t.Run("tool_error", func(t *testing.T) {
+		handler := withRunIDLogging("test", func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultError("boom"), nil
+		})
+
+		result, err := handler(context.Background(), request)
+		require.NoError(t, err)
+		require.Len(t, result.Content, 2)
+		assert.Equal(t, "run ID: run-1", result.Content[1].(mcp.TextContent).Text)
+	})
+
+	t.Run("protocol_error", func(t *testing.T) {
+		handler := withRunIDLogging("test", func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return nil, errors.New("missing argument")
+		})
+
+		_, err := handler(context.Background(), request)
+		assert.EqualError(t, err, "missing argument (run ID: run-1)")
+	})
+}
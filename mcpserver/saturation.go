@@ -0,0 +1,206 @@
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// maxEngineCallsEnv bounds the number of tool calls using the engine at once, further calls are queued.
+	// Unset or 0 means no limit.
+	maxEngineCallsEnv = "CONTAINER_USE_MAX_ENGINE_CALLS"
+	// metricsAddrEnv is the address to serve metrics on, in the Prometheus text format, e.g. localhost:9464.
+	metricsAddrEnv = "CONTAINER_USE_METRICS_ADDR"
+
+	maxEngineRetries = 5
+	baseEngineDelay  = time.Second
+	maxEngineDelay   = 30 * time.Second
+)
+
+// saturationMarkers are found in the errors of an engine that ran out of resources under load.
+// They are transient: the same call usually succeeds once other calls complete.
+var saturationMarkers = []string{
+	"resourceexhausted",
+	"resource exhausted",
+	"cannot allocate memory",
+	"too many open files",
+}
+
+// isEngineSaturated reports whether err is a transient resource exhaustion of the engine.
+func isEngineSaturated(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	// Oversized gRPC messages are reported as resource exhaustion too, but retrying doesn't help
+	if strings.Contains(msg, "larger than max") {
+		return false
+	}
+	for _, marker := range saturationMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// engineErrKey is the context key of the engine error a tool call reported in its result, see engineFailure.
+type engineErrKey struct{}
+
+// engineFailure returns the result of a tool call that failed with err, keeping err for the engine gate to
+// tell whether the call can be retried.
+func engineFailure(ctx context.Context, text string, err error) *mcp.CallToolResult {
+	if engineErr, ok := ctx.Value(engineErrKey{}).(*error); ok {
+		*engineErr = err
+	}
+	return mcp.NewToolResultErrorFromErr(text, err)
+}
+
+// engineGate queues the tool calls using the engine and retries the read-only ones failing because the engine
+// is saturated, with exponential backoff and jitter. Calls changing environments are never retried: they may
+// have done part of their work, like running a command, before the engine ran out of resources.
+type engineGate struct {
+	// slots limits the calls running at once, nil means no limit.
+	slots chan struct{}
+	// backoff returns the delay before retry attempt, starting at 0.
+	backoff func(attempt int) time.Duration
+
+	queued      atomic.Int64
+	running     atomic.Int64
+	saturations atomic.Int64
+	retries     atomic.Int64
+}
+
+var engineCalls = newEngineGate(0)
+
+func newEngineGate(limit int) *engineGate {
+	g := &engineGate{backoff: jitteredBackoff}
+	if limit > 0 {
+		g.slots = make(chan struct{}, limit)
+	}
+	return g
+}
+
+// jitteredBackoff doubles the delay on each attempt, up to maxEngineDelay, and picks a random delay in its
+// upper half so that processes sharing the engine don't retry in lockstep.
+func jitteredBackoff(attempt int) time.Duration {
+	delay := min(baseEngineDelay<<attempt, maxEngineDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// acquire waits for a slot to run a call. The returned function releases it.
+func (g *engineGate) acquire(ctx context.Context) (func(), error) {
+	if g.slots != nil {
+		g.queued.Add(1)
+		select {
+		case g.slots <- struct{}{}:
+			g.queued.Add(-1)
+		case <-ctx.Done():
+			g.queued.Add(-1)
+			return nil, ctx.Err()
+		}
+	}
+	g.running.Add(1)
+	return func() {
+		g.running.Add(-1)
+		if g.slots != nil {
+			<-g.slots
+		}
+	}, nil
+}
+
+// call runs handler once a slot is available. If readOnly, it retries handler while the engine is saturated,
+// as told by the error handler returns or reports with engineFailure.
+func (g *engineGate) call(ctx context.Context, request mcp.CallToolRequest, readOnly bool, handler server.ToolHandlerFunc) (*mcp.CallToolResult, error) {
+	for attempt := 0; ; attempt++ {
+		release, err := g.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var callErr error
+		result, err := handler(context.WithValue(ctx, engineErrKey{}, &callErr), request)
+		release()
+
+		if err != nil {
+			callErr = err
+		}
+		if !isEngineSaturated(callErr) {
+			return result, err
+		}
+		g.saturations.Add(1)
+		if !readOnly {
+			return result, err
+		}
+		if attempt == maxEngineRetries {
+			return mcp.NewToolResultErrorFromErr(
+				fmt.Sprintf("the Dagger engine is out of resources, the call failed %d times: retry later, run fewer agents in parallel or give the engine more resources", attempt+1),
+				callErr), nil
+		}
+
+		delay := g.backoff(attempt)
+		slog.Warn("Engine saturated, retrying", "tool", request.Params.Name, "attempt", attempt+1, "delay", delay, "err", callErr)
+		g.queued.Add(1)
+		select {
+		case <-time.After(delay):
+			g.queued.Add(-1)
+		case <-ctx.Done():
+			g.queued.Add(-1)
+			return result, err
+		}
+		g.retries.Add(1)
+	}
+}
+
+// configureEngineGate sets up engineCalls and the metrics server from the environment.
+func configureEngineGate() error {
+	if value := os.Getenv(maxEngineCallsEnv); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("invalid %s %q: must be a positive number", maxEngineCallsEnv, value)
+		}
+		engineCalls = newEngineGate(limit)
+	}
+
+	if addr := os.Getenv(metricsAddrEnv); addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", engineCalls.serveMetrics)
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				slog.Error("Failed to serve metrics", "addr", addr, "err", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// serveMetrics writes the metrics of the gate in the Prometheus text format.
+func (g *engineGate) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	limit := 0
+	if g.slots != nil {
+		limit = cap(g.slots)
+	}
+	for _, metric := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"container_use_engine_calls_queued", "gauge", "Tool calls waiting for the engine, for a slot or before a retry.", g.queued.Load()},
+		{"container_use_engine_calls_running", "gauge", "Tool calls using the engine.", g.running.Load()},
+		{"container_use_engine_calls_limit", "gauge", "Maximum number of tool calls using the engine at once, 0 if unlimited.", int64(limit)},
+		{"container_use_engine_saturations_total", "counter", "Tool calls that failed because the engine was out of resources.", g.saturations.Load()},
+		{"container_use_engine_retries_total", "counter", "Tool calls retried after the engine was out of resources.", g.retries.Load()},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
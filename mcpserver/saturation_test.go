@@ -0,0 +1,120 @@
+package mcpserver
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsEngineSaturated(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("rpc error: code = ResourceExhausted desc = no more workers"), true},
+		{errors.New("fork/exec /bin/sh: cannot allocate memory"), true},
+		{errors.New("open /tmp/x: too many open files"), true},
+		{errors.New("rpc error: code = ResourceExhausted desc = grpc: received message larger than max (5000000 vs. 4194304)"), false},
+		{errors.New("exit code 1"), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, isEngineSaturated(tt.err), "%v", tt.err)
+	}
+}
+
+func TestEngineGateRetries(t *testing.T) {
+	gate := newEngineGate(1)
+	gate.backoff = func(int) time.Duration { return 0 }
+
+	calls := 0
+	result, err := gate.call(context.Background(), mcp.CallToolRequest{}, true, func(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		if calls < 3 {
+			return engineFailure(ctx, "failed to read file", errors.New("code = ResourceExhausted")), nil
+		}
+		return mcp.NewToolResultText("ok"), nil
+	})
+	require.NoError(t, err)
+	assert.False(t, result.IsError)
+	assert.Equal(t, 3, calls)
+	assert.EqualValues(t, 2, gate.saturations.Load())
+	assert.EqualValues(t, 2, gate.retries.Load())
+	assert.EqualValues(t, 0, gate.running.Load())
+
+	// Other errors are returned as they are
+	calls = 0
+	_, err = gate.call(context.Background(), mcp.CallToolRequest{}, true, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return nil, errors.New("invalid argument")
+	})
+	assert.EqualError(t, err, "invalid argument")
+	assert.Equal(t, 1, calls)
+
+	// Tool output is never taken for an engine error
+	calls = 0
+	result, err = gate.call(context.Background(), mcp.CallToolRequest{}, true, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return mcp.NewToolResultError("command failed: too many open files"), nil
+	})
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, 1, calls)
+
+	// Calls changing environments are never retried
+	calls = 0
+	_, err = gate.call(context.Background(), mcp.CallToolRequest{}, false, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return nil, errors.New("cannot allocate memory")
+	})
+	assert.EqualError(t, err, "cannot allocate memory")
+	assert.Equal(t, 1, calls)
+
+	// Retries give up eventually
+	calls = 0
+	result, err = gate.call(context.Background(), mcp.CallToolRequest{}, true, func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		calls++
+		return nil, errors.New("cannot allocate memory")
+	})
+	require.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, maxEngineRetries+1, calls)
+	assert.Contains(t, result.Content[0].(mcp.TextContent).Text, "out of resources")
+}
+
+func TestEngineGateQueue(t *testing.T) {
+	gate := newEngineGate(1)
+	release, err := gate.acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = gate.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualValues(t, 0, gate.queued.Load())
+
+	recorder := httptest.NewRecorder()
+	gate.serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), "container_use_engine_calls_running 1\n")
+	assert.Contains(t, recorder.Body.String(), "container_use_engine_calls_limit 1\n")
+
+	release()
+	release, err = gate.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for attempt := range 10 {
+		delay := jitteredBackoff(attempt)
+		ceiling := min(baseEngineDelay<<attempt, maxEngineDelay)
+		assert.GreaterOrEqual(t, delay, ceiling/2)
+		assert.LessOrEqual(t, delay, ceiling)
+	}
+}
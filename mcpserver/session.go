@@ -0,0 +1,156 @@
+package mcpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// sessionArguments are the arguments that default to what the session selected with environment_select.
+var sessionArguments = []string{"environment_source", "environment_id"}
+
+// sessionDefaults maps MCP session IDs to the *sessionSelection of the session.
+var sessionDefaults sync.Map
+
+// sessionSelection is the default context of a session, set with environment_select.
+type sessionSelection struct {
+	Source        string `json:"environment_source"`
+	EnvironmentID string `json:"environment_id,omitempty"`
+}
+
+func (s *sessionSelection) argument(name string) string {
+	switch name {
+	case "environment_source":
+		return s.Source
+	case "environment_id":
+		return s.EnvironmentID
+	}
+	return ""
+}
+
+func selectedContext(ctx context.Context) *sessionSelection {
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return nil
+	}
+	selection, _ := sessionDefaults.Load(session.SessionID())
+	s, _ := selection.(*sessionSelection)
+	return s
+}
+
+// forgetSession drops what was recorded about a session once its client is gone.
+func forgetSession(_ context.Context, session server.ClientSession) {
+	sessionDefaults.Delete(session.SessionID())
+	clientNames.Delete(session.SessionID())
+}
+
+// withOptionalSessionArguments makes the session arguments of definition optional, since they can be
+// selected once per session instead. It returns the new definition and the arguments it made optional.
+func withOptionalSessionArguments(definition mcp.Tool) (mcp.Tool, []string) {
+	defaulted := []string{}
+	for _, name := range sessionArguments {
+		if slices.Contains(definition.InputSchema.Required, name) {
+			defaulted = append(defaulted, name)
+		}
+	}
+	if len(defaulted) == 0 {
+		return definition, nil
+	}
+
+	// Copy what's modified: definitions are package variables shared with the tests
+	definition.InputSchema.Required = slices.DeleteFunc(slices.Clone(definition.InputSchema.Required), func(name string) bool {
+		return slices.Contains(defaulted, name)
+	})
+	definition.InputSchema.Properties = maps.Clone(definition.InputSchema.Properties)
+	for _, name := range defaulted {
+		property, ok := definition.InputSchema.Properties[name].(map[string]any)
+		if !ok {
+			continue
+		}
+		property = maps.Clone(property)
+		description, _ := property["description"].(string)
+		property["description"] = description + " Required unless selected for the session with environment_select."
+		definition.InputSchema.Properties[name] = property
+	}
+	return definition, defaulted
+}
+
+// withSessionDefaults returns request with the arguments in defaulted that it's missing set to what the
+// session selected. It fails if one was neither given nor selected.
+func withSessionDefaults(ctx context.Context, request mcp.CallToolRequest, defaulted []string) (mcp.CallToolRequest, error) {
+	if len(defaulted) == 0 {
+		return request, nil
+	}
+	args := request.GetArguments()
+	selection := selectedContext(ctx)
+	var updated map[string]any
+	for _, name := range defaulted {
+		if value, ok := args[name].(string); ok && value != "" {
+			continue
+		}
+		if selection == nil || selection.argument(name) == "" {
+			return request, fmt.Errorf("%s is required: pass it, or select it for the session with environment_select", name)
+		}
+		if updated == nil {
+			updated = maps.Clone(args)
+			if updated == nil {
+				updated = map[string]any{}
+			}
+		}
+		updated[name] = selection.argument(name)
+	}
+	if updated != nil {
+		request.Params.Arguments = updated
+	}
+	return request, nil
+}
+
+var EnvironmentSelectTool = &Tool{
+	Definition: mcp.NewTool("environment_select",
+		mcp.WithDescription(`Select the environment source, and optionally the environment, that later tool calls of this session default to, so that they can omit environment_source and environment_id.
+Selecting a source without an environment only clears the selected environment. Each MCP session has its own selection.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this environment is being selected."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to select."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		session := server.ClientSessionFromContext(ctx)
+		if session == nil {
+			return nil, fmt.Errorf("environment_select requires an MCP session")
+		}
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open repository", err), nil
+		}
+		selection := &sessionSelection{
+			Source:        repo.SourcePath(),
+			EnvironmentID: request.GetString("environment_id", ""),
+		}
+		if selection.EnvironmentID != "" {
+			if _, err := repo.Info(ctx, selection.EnvironmentID); err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to select environment", err), nil
+			}
+		}
+		sessionDefaults.Store(session.SessionID(), selection)
+
+		out, err := json.Marshal(selection)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
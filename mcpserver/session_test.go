@@ -0,0 +1,94 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSession struct {
+	id string
+}
+
+func (s *fakeSession) Initialize()                                         {}
+func (s *fakeSession) Initialized() bool                                   { return true }
+func (s *fakeSession) NotificationChannel() chan<- mcp.JSONRPCNotification { return nil }
+func (s *fakeSession) SessionID() string                                   { return s.id }
+
+func sessionContext(t *testing.T, id string) context.Context {
+	t.Cleanup(func() { sessionDefaults.Delete(id) })
+	s := server.NewMCPServer("test", "dev")
+	return s.WithContext(context.Background(), &fakeSession{id: id})
+}
+
+func TestWithOptionalSessionArguments(t *testing.T) {
+	original := mcp.NewTool("test",
+		mcp.WithString("environment_source", mcp.Description("Source."), mcp.Required()),
+		mcp.WithString("environment_id", mcp.Description("ID."), mcp.Required()),
+		mcp.WithString("command", mcp.Required()),
+	)
+
+	definition, defaulted := withOptionalSessionArguments(original)
+	assert.Equal(t, []string{"environment_source", "environment_id"}, defaulted)
+	assert.Equal(t, []string{"command"}, definition.InputSchema.Required)
+	assert.Contains(t, definition.InputSchema.Properties["environment_id"].(map[string]any)["description"], "environment_select")
+
+	// The original definition is left alone
+	assert.Equal(t, []string{"environment_source", "environment_id", "command"}, original.InputSchema.Required)
+	assert.Equal(t, "ID.", original.InputSchema.Properties["environment_id"].(map[string]any)["description"])
+}
+
+func TestWithSessionDefaults(t *testing.T) {
+	defaulted := []string{"environment_source", "environment_id"}
+
+	t.Run("nothing selected", func(t *testing.T) {
+		ctx := sessionContext(t, "none")
+		_, err := withSessionDefaults(ctx, requestWithArgs(map[string]any{"environment_source": "/src"}), defaulted)
+		assert.ErrorContains(t, err, "environment_id is required")
+
+		request, err := withSessionDefaults(ctx, requestWithArgs(map[string]any{"environment_source": "/src", "environment_id": "env"}), defaulted)
+		require.NoError(t, err)
+		assert.Equal(t, "env", request.GetString("environment_id", ""))
+	})
+
+	t.Run("selected", func(t *testing.T) {
+		ctx := sessionContext(t, "selected")
+		sessionDefaults.Store("selected", &sessionSelection{Source: "/src", EnvironmentID: "env"})
+
+		args := map[string]any{"command": "ls"}
+		request, err := withSessionDefaults(ctx, requestWithArgs(args), defaulted)
+		require.NoError(t, err)
+		assert.Equal(t, "/src", request.GetString("environment_source", ""))
+		assert.Equal(t, "env", request.GetString("environment_id", ""))
+		assert.Equal(t, "ls", request.GetString("command", ""))
+		assert.NotContains(t, args, "environment_id", "the arguments of the request must not be modified")
+
+		request, err = withSessionDefaults(ctx, requestWithArgs(map[string]any{"environment_id": "other"}), defaulted)
+		require.NoError(t, err)
+		assert.Equal(t, "other", request.GetString("environment_id", ""), "given arguments win over the selection")
+	})
+
+	t.Run("other session", func(t *testing.T) {
+		sessionDefaults.Store("selected", &sessionSelection{Source: "/src", EnvironmentID: "env"})
+		t.Cleanup(func() { sessionDefaults.Delete("selected") })
+
+		_, err := withSessionDefaults(sessionContext(t, "other"), requestWithArgs(map[string]any{}), defaulted)
+		assert.ErrorContains(t, err, "environment_source is required")
+	})
+}
+
+func TestEnvironmentSelectRequiresSession(t *testing.T) {
+	_, err := EnvironmentSelectTool.Handler(context.Background(), requestWithArgs(map[string]any{"environment_source": t.TempDir()}))
+	assert.Error(t, err)
+}
+
+func TestForgetSession(t *testing.T) {
+	sessionDefaults.Store("gone", &sessionSelection{Source: "/src"})
+	forgetSession(context.Background(), &fakeSession{id: "gone"})
+	_, ok := sessionDefaults.Load("gone")
+	assert.False(t, ok)
+}
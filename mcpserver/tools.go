@@ -1,6 +1,7 @@
 package mcpserver
 
 import (
+	"cmp"
 	"context"
 	_ "embed"
 	"encoding/json"
@@ -10,9 +11,15 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
-	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
 	"github.com/dagger/container-use/repository"
 	"github.com/dagger/container-use/rules"
@@ -27,11 +34,7 @@ func openRepository(ctx context.Context, request mcp.CallToolRequest) (*reposito
 	if err != nil {
 		return nil, err
 	}
-	repo, err := repository.Open(ctx, source)
-	if err != nil {
-		return nil, err
-	}
-	return repo, nil
+	return repositories.open(ctx, source)
 }
 
 func openEnvironment(ctx context.Context, request mcp.CallToolRequest) (*repository.Repository, *environment.Environment, error) {
@@ -43,33 +46,109 @@ func openEnvironment(ctx context.Context, request mcp.CallToolRequest) (*reposit
 	if err != nil {
 		return nil, nil, err
 	}
-	dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
-	if !ok {
-		return nil, nil, fmt.Errorf("dagger client not found in context")
+	dag, err := daggerClient(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 	env, err := repo.Get(ctx, dag, envID)
 	if err != nil {
+		repositories.forget(request.GetString("environment_source", ""))
 		return nil, nil, err
 	}
 	return repo, env, nil
 }
 
+// openEnvironmentInfo opens the metadata of the environment of the request, without its container.
+func openEnvironmentInfo(ctx context.Context, request mcp.CallToolRequest) (*repository.Repository, *environment.EnvironmentInfo, error) {
+	repo, err := openRepository(ctx, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	envID, err := request.RequireString("environment_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	envInfo, err := repo.Info(ctx, envID)
+	if err != nil {
+		repositories.forget(request.GetString("environment_source", ""))
+		return nil, nil, err
+	}
+	return repo, envInfo, nil
+}
+
+const revisionDescription = "Read from a past revision of the environment instead of its container: a commit shown by `container-use log`, or ~N for N commits before the latest. Only files tracked by git are available."
+
+// workdirRelative returns name, absolute or relative to workdir, relative to workdir: the root of the
+// repository tree of the environment.
+func workdirRelative(workdir, name string) (string, error) {
+	if path.IsAbs(name) {
+		rel, ok := strings.CutPrefix(path.Clean(name), path.Clean(workdir))
+		if !ok || rel != "" && !strings.HasPrefix(rel, "/") {
+			return "", fmt.Errorf("%s is outside of the workdir %s: only the workdir is tracked by git", name, workdir)
+		}
+		name = strings.TrimPrefix(rel, "/")
+	}
+	name = path.Clean(name)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%s is outside of the workdir %s: only the workdir is tracked by git", name, workdir)
+	}
+	return name, nil
+}
+
 type Tool struct {
 	Definition mcp.Tool
 	Handler    server.ToolHandlerFunc
 }
 
-func RunStdioServer(ctx context.Context, dag *dagger.Client) error {
+// newServer returns an MCP server exposing the tools in exposed, and a function replacing them. version is
+// reported to clients in the server info.
+func newServer(dag *daggerConnection, version string, hooks *server.Hooks, exposed []*Tool) (*server.MCPServer, func([]*Tool)) {
+	resources := newEnvironmentResources()
+	hooks.AddAfterInitialize(recordClientInfo)
+	hooks.AddOnUnregisterSession(forgetSession)
+	hooks.AddOnUnregisterSession(resources.forget)
+
 	s := server.NewMCPServer(
 		"Dagger",
-		"1.0.0",
+		version,
 		server.WithInstructions(rules.AgentRules),
+		server.WithHooks(hooks),
+		server.WithResourceCapabilities(false, false),
+		server.WithToolCapabilities(true),
 	)
 
-	for _, t := range tools {
-		s.AddTool(t.Definition, wrapToolWithClient(t, dag).Handler)
+	expose := func(exposed []*Tool) {
+		serverTools := make([]server.ServerTool, 0, len(exposed))
+		for _, t := range exposed {
+			serverTools = append(serverTools, server.ServerTool{Tool: t.Definition, Handler: resources.track(wrapToolWithClient(t, dag).Handler)})
+		}
+		s.SetTools(serverTools...)
+	}
+	expose(exposed)
+	resources.register(s)
+	return s, expose
+}
+
+// RunStdioServer serves the tools selected by toolConfig over stdio, connecting to the engine with connect once a
+// tool needs it. version is reported to clients in the server info.
+func RunStdioServer(ctx context.Context, connect Connector, version string, toolConfig ToolConfig) error {
+	if err := configureEngineGate(); err != nil {
+		return err
+	}
+	exposed, err := toolConfig.Exposed()
+	if err != nil {
+		return err
 	}
 
+	dag := newDaggerConnection(ctx, connect)
+	defer dag.Close()
+	// Commit the changes left pending by the lazy propagation before disconnecting
+	defer pendingFlushes.flushAll()
+
+	hooks := &server.Hooks{}
+	hooks.AddBeforeCallTool(inflight.beforeCallTool)
+	s, _ := newServer(dag, version, hooks, exposed)
+
 	slog.Info("starting server")
 
 	stdioSrv := server.NewStdioServer(s)
@@ -78,7 +157,7 @@ func RunStdioServer(ctx context.Context, dag *dagger.Client) error {
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, os.Kill, syscall.SIGTERM)
 	defer cancel()
 
-	err := stdioSrv.Listen(ctx, os.Stdin, os.Stdout)
+	err = stdioSrv.Listen(ctx, watchCancellations(os.Stdin, inflight), os.Stdout)
 	if err != nil && !errors.Is(err, context.Canceled) {
 		return err
 	}
@@ -98,25 +177,36 @@ func registerTool(tool ...*Tool) {
 }
 
 func wrapTool(tool *Tool) *Tool {
+	definition, defaulted := withOptionalSessionArguments(tool.Definition)
 	return &Tool{
-		Definition: tool.Definition,
+		Definition: definition,
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 			slog.Info("Tool called", "tool", tool.Definition.Name)
 			defer func() {
 				slog.Info("Tool finished", "tool", tool.Definition.Name)
 			}()
+			request, err := withSessionDefaults(ctx, request, defaulted)
+			if err != nil {
+				return nil, err
+			}
 			return tool.Handler(ctx, request)
 		},
 	}
 }
 
 // keeping this modular for now. we could move tool registration to RunStdioServer and collapse the 2 wrapTool functions.
-func wrapToolWithClient(tool *Tool, dag *dagger.Client) *Tool {
+func wrapToolWithClient(tool *Tool, dag *daggerConnection) *Tool {
 	return &Tool{
 		Definition: tool.Definition,
 		Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			ctx, done := inflight.start(ctx)
+			defer done()
 			ctx = context.WithValue(ctx, daggerClientKey{}, dag)
-			return tool.Handler(ctx, request)
+			used := &atomic.Int64{}
+			ctx = context.WithValue(ctx, usedClientKey{}, used)
+			result, err := engineCalls.call(ctx, request, isReadOnly(tool), withRunIDLogging(tool.Definition.Name, withAudit(tool.Definition.Name, withNotices(tool.Handler))))
+			dag.dropOnConnectionError(int(used.Load()), result, err)
+			return result, err
 		},
 	}
 }
@@ -124,34 +214,91 @@ func wrapToolWithClient(tool *Tool, dag *dagger.Client) *Tool {
 func init() {
 	registerTool(
 		EnvironmentOpenTool,
+		EnvironmentListTool,
+		EnvironmentSelectTool,
 		EnvironmentCreateTool,
+		EnvironmentImportTool,
+		EnvironmentCreateFromPRTool,
+		EnvironmentCloneTool,
+		EnvironmentForkTool,
 		EnvironmentUpdateTool,
+		EnvironmentSetupRerunTool,
+		EnvironmentBuildLogTool,
+		EnvironmentHistoryTool,
+		EnvironmentDiffTool,
+		EnvironmentSyncTool,
+		EnvironmentConflictsListTool,
+		EnvironmentMergeContinueTool,
 
 		EnvironmentRunCmdTool,
+		EnvironmentProcessListTool,
+		EnvironmentProcessLogsTool,
+		EnvironmentProcessKillTool,
+		EnvironmentWaitTool,
+		EnvironmentPortForwardTool,
+		EnvironmentJobStartTool,
+		EnvironmentJobStatusTool,
+		EnvironmentJobWaitTool,
+		EnvironmentJobCancelTool,
 
 		EnvironmentFileReadTool,
 		EnvironmentFileListTool,
+		EnvironmentFileSearchTool,
+		EnvironmentFileGlobTool,
+		EnvironmentRepoStatsTool,
+		EnvironmentDependenciesTool,
 		EnvironmentFileWriteTool,
+		EnvironmentFileEditTool,
 		EnvironmentFileDeleteTool,
+		EnvironmentFileMoveTool,
+		EnvironmentMkdirTool,
+		EnvironmentDownloadTool,
+		EnvironmentFlushTool,
 
 		EnvironmentAddServiceTool,
+		EnvironmentSecretsCheckTool,
 
 		EnvironmentCheckpointTool,
+		EnvironmentRestoreTool,
+		EnvironmentExportTool,
+		EnvironmentPublishTool,
 	)
 }
 
 type EnvironmentResponse struct {
-	ID              string                 `json:"id"`
-	Title           string                 `json:"title"`
-	BaseImage       string                 `json:"base_image"`
-	SetupCommands   []string               `json:"setup_commands"`
-	Instructions    string                 `json:"instructions"`
-	Workdir         string                 `json:"workdir"`
-	RemoteRef       string                 `json:"remote_ref"`
-	CheckoutCommand string                 `json:"checkout_command_to_share_with_user"`
-	LogCommand      string                 `json:"log_command_to_share_with_user"`
-	DiffCommand     string                 `json:"diff_command_to_share_with_user"`
-	Services        []*environment.Service `json:"services,omitempty"`
+	ID              string                                  `json:"id"`
+	Title           string                                  `json:"title"`
+	BaseImage       string                                  `json:"base_image"`
+	Dockerfile      string                                  `json:"dockerfile,omitempty"`
+	BuildArgs       []string                                `json:"build_args,omitempty"`
+	BuildTarget     string                                  `json:"build_target,omitempty"`
+	Platform        string                                  `json:"platform,omitempty"`
+	User            string                                  `json:"user,omitempty"`
+	SetupCommands   []string                                `json:"setup_commands"`
+	SetupPhases     environment.SetupPhases                 `json:"setup_phases,omitempty"`
+	SetupTimings    []environment.PhaseTiming               `json:"setup_timings,omitempty"`
+	DefaultCommand  string                                  `json:"default_command,omitempty"`
+	Entrypoint      []string                                `json:"entrypoint,omitempty"`
+	Caches          []string                                `json:"caches,omitempty"`
+	Mounts          []environment.Mount                     `json:"mounts,omitempty"`
+	Docker          bool                                    `json:"docker,omitempty"`
+	ForwardSSHAgent bool                                    `json:"forward_ssh_agent,omitempty"`
+	GitCredentials  []string                                `json:"git_credentials,omitempty"`
+	Network         environment.NetworkPolicy               `json:"network"`
+	Instructions    string                                  `json:"instructions"`
+	Workdir         string                                  `json:"workdir"`
+	RemoteRef       string                                  `json:"remote_ref"`
+	CheckoutCommand string                                  `json:"checkout_command_to_share_with_user"`
+	LogCommand      string                                  `json:"log_command_to_share_with_user"`
+	DiffCommand     string                                  `json:"diff_command_to_share_with_user"`
+	Services        []*environment.Service                  `json:"services,omitempty"`
+	Endpoints       map[string]environment.EndpointMappings `json:"endpoints,omitempty"`
+	ServiceAliases  map[string]string                       `json:"service_aliases,omitempty"`
+	Checkpoint      string                                  `json:"checkpoint,omitempty"`
+	PullRequest     *environment.PullRequest                `json:"pull_request,omitempty"`
+	Notices         []string                                `json:"notices,omitempty"`
+	// SuggestedConfig is proposed to newly created environments of repositories without configuration.
+	SuggestedConfig *environment.ToolchainSuggestion `json:"suggested_config,omitempty"`
 }
 
 func environmentResponseFromEnvInfo(envInfo *environment.EnvironmentInfo) *EnvironmentResponse {
@@ -160,22 +307,104 @@ func environmentResponseFromEnvInfo(envInfo *environment.EnvironmentInfo) *Envir
 		Title:           envInfo.State.Title,
 		Instructions:    envInfo.Config.Instructions,
 		BaseImage:       envInfo.Config.BaseImage,
+		Dockerfile:      envInfo.Config.Dockerfile,
+		BuildArgs:       envInfo.Config.BuildArgs,
+		BuildTarget:     envInfo.Config.BuildTarget,
+		Platform:        envInfo.Config.Platform,
+		User:            envInfo.Config.User,
 		SetupCommands:   envInfo.Config.SetupCommands,
+		SetupPhases:     envInfo.Config.SetupPhases,
+		SetupTimings:    envInfo.State.SetupPhases,
+		DefaultCommand:  envInfo.Config.DefaultCommand,
+		Entrypoint:      envInfo.Config.Entrypoint,
+		Caches:          envInfo.Config.Caches,
+		Mounts:          envInfo.Config.Mounts,
+		Docker:          envInfo.Config.Docker,
+		ForwardSSHAgent: envInfo.Config.ForwardSSHAgent,
+		GitCredentials:  envInfo.Config.GitCredentials,
+		Network:         envInfo.Config.Network.Active(),
 		Workdir:         envInfo.Config.Workdir,
 		RemoteRef:       fmt.Sprintf("container-use/%s", envInfo.ID),
 		CheckoutCommand: fmt.Sprintf("container-use checkout %s", envInfo.ID),
 		LogCommand:      fmt.Sprintf("container-use log %s", envInfo.ID),
 		DiffCommand:     fmt.Sprintf("container-use diff %s", envInfo.ID),
 		Services:        nil, // EnvironmentInfo doesn't have "active" services, specifically useful for EndpointMappings
+		Endpoints:       envInfo.State.Endpoints,
+		ServiceAliases:  envInfo.Config.Services.Aliases(),
+		Checkpoint:      envInfo.State.Checkpoint,
+		PullRequest:     envInfo.State.PullRequest,
 	}
 }
 
 func environmentResponseFromEnv(env *environment.Environment) *EnvironmentResponse {
 	resp := environmentResponseFromEnvInfo(env.EnvironmentInfo)
-	resp.Services = env.Services
+	resp.Services = env.ServiceList()
+	resp.Notices = append(resp.Notices, env.Warnings...)
+	if env.ToolchainSuggestion != nil {
+		resp.SuggestedConfig = env.ToolchainSuggestion
+		resp.Notices = append(resp.Notices, "The repository has no environment configuration, and runs on the default base image. "+
+			"suggested_config was detected from its files: to accept it, call environment_update with its base_image and setup_commands, adjusted as needed.")
+	}
 	return resp
 }
 
+// imageArguments sets the image of config from the base_image, or dockerfile, build_args and build_target
+// arguments of request.
+func imageArguments(config *environment.EnvironmentConfig, request mcp.CallToolRequest) error {
+	dockerfile := request.GetString("dockerfile", "")
+	if dockerfile == "" {
+		baseImage, err := request.RequireString("base_image")
+		if err != nil {
+			return fmt.Errorf("%w, or set dockerfile", err)
+		}
+		config.BaseImage = baseImage
+		config.Dockerfile, config.BuildArgs, config.BuildTarget = "", nil, ""
+		return nil
+	}
+
+	if !filepath.IsLocal(dockerfile) {
+		return fmt.Errorf("dockerfile must be a path relative to the root of the repository, got %q", dockerfile)
+	}
+	buildArgs, err := optionalStringSlice(request, "build_args")
+	if err != nil {
+		return err
+	}
+	for _, arg := range buildArgs {
+		if name, _, ok := strings.Cut(arg, "="); !ok || name == "" {
+			return fmt.Errorf("invalid build argument %q, expected NAME=value", arg)
+		}
+	}
+	config.Dockerfile = filepath.ToSlash(dockerfile)
+	config.BuildArgs = buildArgs
+	config.BuildTarget = request.GetString("build_target", "")
+	if baseImage := request.GetString("base_image", ""); baseImage != "" {
+		config.BaseImage = baseImage
+	}
+	return nil
+}
+
+// importHostEnv adds secret references to config for the host variables matching the host_env patterns of request.
+// It reports whether any variable matched.
+func importHostEnv(config *environment.EnvironmentConfig, request mcp.CallToolRequest) (bool, error) {
+	patterns, err := optionalStringSlice(request, "host_env")
+	if err != nil || len(patterns) == 0 {
+		return false, err
+	}
+	names, err := config.ImportHostEnv(patterns)
+	return len(names) > 0, err
+}
+
+// stalenessNotices returns advisory notices for an environment that has drifted from the source repository.
+// They are best effort: failures are logged and yield no notices.
+func stalenessNotices(ctx context.Context, repo *repository.Repository, envInfo *environment.EnvironmentInfo) []string {
+	staleness, err := repo.Staleness(ctx, envInfo)
+	if err != nil {
+		slog.Warn("failed to check environment staleness", "environment", envInfo.ID, "err", err)
+		return nil
+	}
+	return staleness.Notices(envInfo.ID)
+}
+
 func marshalEnvironment(env *environment.Environment) (string, error) {
 	out, err := json.Marshal(environmentResponseFromEnv(env))
 	if err != nil {
@@ -211,6 +440,7 @@ func EnvironmentInfoToCallResult(envInfo *environment.EnvironmentInfo) (*mcp.Cal
 var EnvironmentOpenTool = &Tool{
 	Definition: mcp.NewTool("environment_open",
 		mcp.WithDescription("Opens an existing environment. Return format is same as environment_create."),
+		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithString("explanation",
 			mcp.Description("One sentence explanation for why this environment is being opened."),
 		),
@@ -224,11 +454,20 @@ var EnvironmentOpenTool = &Tool{
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, env, err := openEnvironment(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return engineFailure(ctx, "unable to open the environment", err), nil
 		}
-		return EnvironmentToCallResult(env)
+		// Commands usually follow, get the container ready for them meanwhile
+		env.Warm(ctx)
+
+		resp := environmentResponseFromEnv(env)
+		resp.Notices = append(resp.Notices, stalenessNotices(ctx, repo, env.EnvironmentInfo)...)
+		out, err := json.Marshal(resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
@@ -247,9 +486,25 @@ DO NOT manually install toolchains inside the environment, instead explicitly ca
 			mcp.Required(),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
+		mcp.WithString("ref",
+			mcp.Description("Optional branch, tag or commit of the repository to start the environment from, e.g. v1.4.2 to reproduce a bug in a release. Refs missing locally are fetched from the origin remote. Defaults to the checked out commit."),
+		),
+		mcp.WithString("from_image",
+			mcp.Description("Optional container image that already contains the source tree (e.g. a CI build artifact). When set, the environment uses this image as its base and its source tree replaces the repository content."),
+		),
+		mcp.WithString("from_image_path",
+			mcp.Description("Path of the source tree inside `from_image`. Defaults to the working directory of the image."),
+		),
+		mcp.WithString("template",
+			mcp.Description("Optional preset to configure the environment with, overriding the base image and setup commands of the repository: go-1.23, node-20-pnpm, python-3.12-uv, rust-stable, or a template defined in ~/.config/container-use/templates/<name>.yaml. Omit to use the configuration of the repository."),
+		),
+		mcp.WithArray("host_env",
+			mcp.Description("Patterns of host environment variable names to import, e.g. [\"AWS_*\", \"npm_config_*\"]. Matching variables are added as env:// secrets, resolved from the host on every build and never written to the repository."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, err := openRepository(ctx, request)
@@ -261,12 +516,51 @@ DO NOT manually install toolchains inside the environment, instead explicitly ca
 			return nil, err
 		}
 
-		dag, ok := ctx.Value(daggerClientKey{}).(*dagger.Client)
-		if !ok {
-			return mcp.NewToolResultErrorFromErr("dagger client not found in context", nil), nil
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
+
+		// Validate the patterns before spending time creating the environment
+		if _, err := importHostEnv(environment.DefaultConfig(), request); err != nil {
+			return nil, err
+		}
+		ref := request.GetString("ref", "")
+		if ref != "" && request.GetString("from_image", "") != "" {
+			return nil, fmt.Errorf("ref and from_image can't be used together")
+		}
+		var template *environment.Template
+		if name := request.GetString("template", ""); name != "" {
+			if request.GetString("from_image", "") != "" {
+				return nil, fmt.Errorf("template and from_image can't be used together")
+			}
+			if template, err = repository.Template(name); err != nil {
+				return nil, err
+			}
 		}
 
-		env, err := repo.Create(ctx, dag, title, request.GetString("explanation", ""))
+		stopProgress := startProgress(ctx, request, "environment_create")
+		var env *environment.Environment
+		if image := request.GetString("from_image", ""); image != "" {
+			env, err = repo.CreateFromImage(ctx, dag, image, request.GetString("from_image_path", ""), title, commitMessage(ctx, request, fmt.Sprintf("Create environment %s from %s", title, image)))
+		} else if ref != "" {
+			env, err = repo.CreateFromRef(ctx, dag, ref, template, title, commitMessage(ctx, request, fmt.Sprintf("Create environment %s from %s", title, ref)))
+		} else if template != nil {
+			env, err = repo.CreateFromTemplate(ctx, dag, template, title, commitMessage(ctx, request, fmt.Sprintf("Create environment %s from template %s", title, template.Name)))
+		} else {
+			env, err = repo.Create(ctx, dag, title, commitMessage(ctx, request, "Create environment "+title))
+		}
+		if err == nil {
+			config := env.Config.Copy()
+			if imported, _ := importHostEnv(config, request); imported {
+				if err = env.UpdateConfig(ctx, request.GetString("explanation", ""), config); err == nil {
+					err = updateRepository(ctx, repo, env, request, "Import host environment variables")
+				} else {
+					err = repo.SaveBuildError(env.ID, err)
+				}
+			}
+		}
+		stopProgress("done")
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("failed to create environment", err), nil
 		}
@@ -296,16 +590,231 @@ You MUST tell the user: To include these changes in the environment, they need t
 	},
 }
 
+var EnvironmentImportTool = &Tool{
+	Definition: mcp.NewTool("environment_import",
+		mcp.WithDescription(`Creates a new environment from an existing branch of the source repository.
+Use this to continue work that was started outside of container-use. Return format is same as environment_create.`,
+		),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this branch is being imported."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("branch",
+			mcp.Description("Name of the branch to import."),
+			mcp.Required(),
+		),
+		mcp.WithString("title",
+			mcp.Description("Short description of the work that is happening in this environment. Defaults to the branch name."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		branch, err := request.RequireString("branch")
+		if err != nil {
+			return nil, err
+		}
+
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
+
+		env, err := repo.Import(ctx, dag, branch, request.GetString("title", ""), commitMessage(ctx, request, "Import branch "+branch))
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to import branch", err), nil
+		}
+
+		return EnvironmentToCallResult(env)
+	},
+}
+
+var EnvironmentCreateFromPRTool = &Tool{
+	Definition: mcp.NewTool("environment_create_from_pr",
+		mcp.WithDescription(`Creates a new environment from the head of a GitHub pull request of the source repository, e.g. to review or fix PR #123.
+The pull request (title, description, author, base and head branches) is returned in pull_request. Return format is same as environment_create.`,
+		),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this pull request is being worked on."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote. Its origin remote must be on GitHub."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("pr_number",
+			mcp.Description("Number of the pull request."),
+			mcp.Required(),
+			mcp.Min(1),
+		),
+		mcp.WithString("title",
+			mcp.Description("Short description of the work that is happening in this environment. Defaults to the title of the pull request."),
+		),
+		mcp.WithString("template",
+			mcp.Description("Optional preset to configure the environment with, as for environment_create. Omit to use the configuration of the repository at the head of the pull request."),
+		),
+		mcp.WithString("github_token",
+			mcp.Description("Optional secret reference to the token to call the GitHub API with, e.g. env://GITHUB_TOKEN or op://vault/github/token. Defaults to GH_TOKEN, GITHUB_TOKEN or the login of the gh CLI on the host."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		number, err := request.RequireInt("pr_number")
+		if err != nil {
+			return nil, err
+		}
+
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
+
+		var template *environment.Template
+		if name := request.GetString("template", ""); name != "" {
+			if template, err = repository.Template(name); err != nil {
+				return nil, err
+			}
+		}
+		var token string
+		if reference := request.GetString("github_token", ""); reference != "" {
+			if token, err = environment.ResolveSecret(ctx, dag, reference); err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to resolve github_token", err), nil
+			}
+		}
+
+		stopProgress := startProgress(ctx, request, "environment_create_from_pr")
+		env, err := repo.CreateFromPullRequest(ctx, dag, number, token, template, request.GetString("title", ""), commitMessage(ctx, request, fmt.Sprintf("Create environment from pull request #%d", number)))
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to create environment from pull request", err), nil
+		}
+
+		return EnvironmentToCallResult(env)
+	},
+}
+
+var EnvironmentCloneTool = &Tool{
+	Definition: mcp.NewTool("environment_clone",
+		mcp.WithDescription(`Creates a new environment in another repository from the configuration of an existing environment (base image, setup commands, environment variables, secrets, services).
+Use this to reuse a carefully configured environment instead of configuring a new one from scratch. Return format is same as environment_create.`,
+		),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this environment is being cloned."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository of the environment to clone."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to clone."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_source",
+			mcp.Description("Absolute path to the git repository to create the new environment in. It starts from the HEAD of that repository."),
+			mcp.Required(),
+		),
+		mcp.WithString("title",
+			mcp.Description("Short description of the work that is happening in the new environment. Defaults to the title of the cloned environment."),
+		),
+		mcp.WithBoolean("include_container",
+			mcp.Description("Reuse the container of the cloned environment, including everything installed outside of the workdir, instead of building a new one from the configuration."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, source, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		targetSource, err := request.RequireString("target_source")
+		if err != nil {
+			return nil, err
+		}
+		target, err := repositories.open(ctx, targetSource)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the target repository", err), nil
+		}
+
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
+
+		title := request.GetString("title", source.State.Title)
+		stopProgress := startProgress(ctx, request, "environment_clone")
+		env, err := target.Clone(ctx, dag, source, request.GetBool("include_container", false), title, commitMessage(ctx, request, fmt.Sprintf("Clone environment %s", source.ID)))
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to clone environment", err), nil
+		}
+
+		return EnvironmentToCallResult(env)
+	},
+}
+
+var EnvironmentForkTool = &Tool{
+	Definition: mcp.NewTool("environment_fork",
+		mcp.WithDescription(`Creates a new environment as a deep copy of an existing one in the same repository: it continues the history of the environment, and its container is a snapshot of the current container, with installed dependencies, compiled artifacts and untracked files.
+Use this to branch an experiment off a fully warmed environment without running setup or builds again, leaving the original untouched. Services are restarted and background processes are not copied. Return format is same as environment_create.`,
+		),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this environment is being forked."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to fork."),
+			mcp.Required(),
+		),
+		mcp.WithString("title",
+			mcp.Description("Short description of the work that is happening in the new environment. Defaults to the title of the forked environment."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open repository", err), nil
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
+
+		stopProgress := startProgress(ctx, request, "environment_fork")
+		env, err := repo.Fork(ctx, dag, envID, request.GetString("title", ""), commitMessage(ctx, request, fmt.Sprintf("Fork environment %s", envID)))
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to fork environment", err), nil
+		}
+
+		return EnvironmentToCallResult(env)
+	},
+}
+
 var EnvironmentUpdateTool = &Tool{
 	Definition: mcp.NewTool("environment_update",
 		mcp.WithDescription("Updates an environment with new instructions and toolchains."+
 			"If the environment is missing any tools or instructions, you MUST call this function to update the environment."+
-			"You MUST update the environment with any useful information or tools. You will be resumed with no other context than the information provided here"),
+			"You MUST update the environment with any useful information or tools. You will be resumed with no other context than the information provided here. "+
+			"When the only change is setup commands appended after the existing ones, they run on top of the current environment, which is kept as is; any other change rebuilds it from scratch."),
 		mcp.WithString("explanation",
 			mcp.Description("One sentence explanation for why this environment is being updated."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
@@ -321,14 +830,37 @@ var EnvironmentUpdateTool = &Tool{
 			mcp.Required(),
 		),
 		mcp.WithString("base_image",
-			mcp.Description("Change the base image for the environment."),
-			mcp.Required(),
+			mcp.Description("Change the base image for the environment. Required unless dockerfile is set."),
+		),
+		mcp.WithString("dockerfile",
+			mcp.Description("Path of a Dockerfile in the repository to build the image of the environment from, instead of base_image, with the repository as build context. Use it when the project already describes its toolchain in a Dockerfile. Omit to use base_image."),
+		),
+		mcp.WithArray("build_args",
+			mcp.Description("Build arguments of the Dockerfile (e.g. `[\"GO_VERSION=1.24\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("build_target",
+			mcp.Description("Stage of the Dockerfile to build. Defaults to the last one."),
+		),
+		mcp.WithString("platform",
+			mcp.Description("Platform to run the environment on, linux/amd64 or linux/arm64, e.g. to use dependencies only available for x86 on an ARM machine. Other platforms than the host's are emulated and much slower. Set to an empty string to use the platform of the host, omit to keep the current one."),
+			mcp.Enum("", "linux/amd64", "linux/arm64"),
+		),
+		mcp.WithString("user",
+			mcp.Description("User to run commands as, instead of the user of the image, as a name or UID with an optional group (e.g. `node`, `1000:1000`). Names must exist in the image. Setup commands still run as root, and the workdir is owned by the user. Set to an empty string to use the user of the image, omit to keep the current one."),
 		),
 		mcp.WithArray("setup_commands",
 			mcp.Description("Commands that will be executed on top of the base image to set up the environment. Similar to `RUN` instructions in Dockerfiles."),
 			mcp.Required(),
 			mcp.Items(map[string]any{"type": "string"}),
 		),
+		mcp.WithObject("setup_phases",
+			mcp.Description("Setup commands grouped into named phases, run after setup_commands in this order: system, language, deps, project (e.g. `{\"system\": [\"apt-get update && apt-get install -y curl\"], \"deps\": [\"pip install -r requirements.txt\"]}`). A single phase can then be re-run with environment_setup_rerun. Omit to keep the current phases."),
+			mcp.AdditionalProperties(map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string"},
+			}),
+		),
 		mcp.WithArray("envs",
 			mcp.Description("The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
 			mcp.Required(),
@@ -343,10 +875,43 @@ Supported schemas are:
 - file://PATH: local file path
 - env://NAME: environment variable
 - op://<vault-name>/<item-name>/[section-name/]<field-name>: 1Password secret
+- vault://<mount>/<path>#<key>: HashiCorp Vault secret
+- gcp-sm://projects/<project>/secrets/<name>[/versions/<version>]: Google Cloud Secret Manager secret
+- azure-kv://<vault>/<secret>[/<version>]: Azure Key Vault secret
 `),
 			mcp.Required(),
 			mcp.Items(map[string]any{"type": "string"}),
 		),
+		mcp.WithArray("host_env",
+			mcp.Description("Patterns of host environment variable names to import, e.g. [\"AWS_*\", \"npm_config_*\"]. Matching variables are added as env:// secrets, resolved from the host on every build and never written to the repository."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("env_files",
+			mcp.Description(`.env files to load, in order (e.g. [".env", ".env.local"]). Later files override earlier ones, and envs and secrets override them all. Missing files are skipped.
+
+Relative paths are read from the repository and loaded as environment variables. Absolute paths are read from the host and loaded as secrets. Values that are secret references (env://, file://, op://, vault://, gcp-sm://, azure-kv://) are always resolved as secrets.
+
+Omit to keep the current env files.`),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("caches",
+			mcp.Description("Absolute paths of directories to persist across environments and commands, typically package manager caches (e.g. `[\"/go/pkg/mod\", \"/root/.npm\", \"/root/.cache/pip\"]`). They are shared by every environment using the same path, so that downloads happen once. Omit to keep the current caches."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("default_command",
+			mcp.Description("Shell command run by environment_run_cmd when its command is empty, and by containers started from checkpoints of the environment (e.g. `npm start`). Set to an empty string to use the default command of the image, omit to keep the current one."),
+		),
+		mcp.WithArray("entrypoint",
+			mcp.Description("Entrypoint replacing the one of the image, used by commands run with use_entrypoint and by containers started from checkpoints (e.g. `[\"/usr/bin/tini\", \"--\"]`). Set to an empty array to use the entrypoint of the image, omit to keep the current one."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithBoolean("docker",
+			mcp.Description("Run a Docker daemon next to the environment, reachable as the `docker` service, and install the Docker CLI with compose and buildx, so that `docker build`, `docker run` and `docker compose` work in commands. Containers started this way are reachable at the `docker` host name, and can't bind mount the workdir: copy files into images instead. Omit to keep the current setting."),
+		),
+		mcp.WithArray("mounts",
+			mcp.Description("Host paths to make available in the environment, as HOST_PATH:CONTAINER_PATH (e.g. `[\"~/datasets/imagenet:/data/imagenet\"]`), such as datasets, package mirrors or toolchains. The environment gets a snapshot: changes never reach the host. Only paths allowed by the user in the global container-use configuration can be mounted. Omit to keep the current mounts."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -362,11 +927,9 @@ Supported schemas are:
 		}
 		config.Instructions = instructions
 
-		baseImage, err := request.RequireString("base_image")
-		if err != nil {
+		if err := imageArguments(config, request); err != nil {
 			return nil, err
 		}
-		config.BaseImage = baseImage
 
 		setupCommands, err := request.RequireStringSlice("setup_commands")
 		if err != nil {
@@ -374,6 +937,14 @@ Supported schemas are:
 		}
 		config.SetupCommands = setupCommands
 
+		if _, ok := request.GetArguments()["setup_phases"]; ok {
+			phases, err := setupPhases(request, "setup_phases")
+			if err != nil {
+				return nil, err
+			}
+			config.SetupPhases = phases
+		}
+
 		envs, err := request.RequireStringSlice("envs")
 		if err != nil {
 			return nil, err
@@ -386,350 +957,2264 @@ Supported schemas are:
 		}
 		config.Secrets = secrets
 
-		if title := request.GetString("title", ""); title != "" {
+		if _, ok := request.GetArguments()["env_files"]; ok {
+			envFiles, err := optionalStringSlice(request, "env_files")
+			if err != nil {
+				return nil, err
+			}
+			config.EnvFiles = envFiles
+		}
+
+		if _, ok := request.GetArguments()["mounts"]; ok {
+			specs, err := optionalStringSlice(request, "mounts")
+			if err != nil {
+				return nil, err
+			}
+			config.Mounts = nil
+			for _, spec := range specs {
+				mount, err := environment.ParseMount(spec)
+				if err != nil {
+					return nil, err
+				}
+				config.Mounts = append(config.Mounts, mount)
+			}
+		}
+
+		if _, ok := request.GetArguments()["platform"]; ok {
+			platform := request.GetString("platform", "")
+			if err := environment.ValidatePlatform(platform); err != nil {
+				return nil, err
+			}
+			config.Platform = platform
+		}
+
+		if _, ok := request.GetArguments()["user"]; ok {
+			user := request.GetString("user", "")
+			if err := environment.ValidateUser(user); err != nil {
+				return nil, err
+			}
+			config.User = user
+		}
+
+		if _, ok := request.GetArguments()["default_command"]; ok {
+			config.DefaultCommand = request.GetString("default_command", "")
+		}
+
+		if _, ok := request.GetArguments()["entrypoint"]; ok {
+			entrypoint, err := optionalStringSlice(request, "entrypoint")
+			if err != nil {
+				return nil, err
+			}
+			config.Entrypoint = entrypoint
+		}
+
+		if _, ok := request.GetArguments()["docker"]; ok {
+			config.Docker = request.GetBool("docker", false)
+		}
+
+		if _, ok := request.GetArguments()["caches"]; ok {
+			caches, err := optionalStringSlice(request, "caches")
+			if err != nil {
+				return nil, err
+			}
+			config.Caches = caches
+		}
+
+		if _, err := importHostEnv(config, request); err != nil {
+			return nil, err
+		}
+
+		// Commands the configuration already ran were checked when they were set, or come from the user
+		current := env.Config.Commands()
+		added := slices.DeleteFunc(config.Commands(), func(command string) bool {
+			return slices.Contains(current, command)
+		})
+		if denied, err := checkCommandsPolicy(ctx, request, repo, env.ID, added); denied != nil || err != nil {
+			return denied, err
+		}
+
+		if title := request.GetString("title", ""); title != "" {
 			env.State.Title = title
 		}
 
-		if err := env.UpdateConfig(ctx, request.GetString("explanation", ""), config); err != nil {
+		stopProgress := startProgress(ctx, request, "environment_update")
+		err = env.UpdateConfig(ctx, request.GetString("explanation", ""), config)
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to update the environment", repo.SaveBuildError(env.ID, err)), nil
+		}
+
+		if err := updateRepository(ctx, repo, env, request, "Update environment configuration"); err != nil {
 			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
 		}
 
-		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
+		out, err := marshalEnvironment(env)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to marshal environment", err), nil
+		}
+		if len(env.AppendedSetup) > 0 {
+			return mcp.NewToolResultText(fmt.Sprintf("Environment %s updated successfully. The %d new setup commands ran on top of the current environment, which was not restarted.\n%s", env.ID, len(env.AppendedSetup), out)), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Environment %s updated successfully. Environment has been restarted, all previous commands have been lost.\n%s", env.ID, out)), nil
+	},
+}
+
+var EnvironmentListTool = &Tool{
+	Definition: mcp.NewTool("environment_list",
+		mcp.WithDescription("List available environments, most recently updated first, with how far each has diverged from the current branch of the source repository. Results are paginated: when next_cursor is set, call again with it as cursor to get the following environments."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this environment is being listed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("The source directory of the environment."), //  This can be a local folder (e.g. file://) or a URL to a git repository (e.g. https://github.com/user/repo.git, git@github.com:user/repo.git)"),
+			mcp.Required(),
+		),
+		limitOption("environments"),
+		cursorOption("environments"),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		limit, after, err := pageParams(request)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		envInfos, err := repo.List(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("invalid source", err), nil
+		}
+		start, err := environmentListStart(envInfos, after)
+		if err != nil {
+			return nil, err
+		}
+		envInfos, next := paginate(envInfos, start, limit, environmentListKey)
+
+		responses := make([]environmentListEntry, len(envInfos))
+		for i, envInfo := range envInfos {
+			responses[i] = environmentListEntry{
+				EnvironmentResponse: *environmentResponseFromEnvInfo(envInfo.EnvironmentInfo),
+				CreatedAt:           envInfo.CreatedAt,
+				UpdatedAt:           envInfo.UpdatedAt,
+				Ahead:               envInfo.Ahead,
+				Behind:              envInfo.Behind,
+				Dirty:               envInfo.Dirty,
+			}
+			responses[i].Notices = stalenessNotices(ctx, repo, envInfo.EnvironmentInfo)
+		}
+
+		out, err := json.Marshal(environmentListResponse{Environments: responses, NextCursor: next})
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+type environmentListResponse struct {
+	Environments []environmentListEntry `json:"environments"`
+	// NextCursor is set when more environments follow.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// environmentListKey identifies an environment in the order of repository.List: most recently updated
+// first, then by ID.
+func environmentListKey(envInfo *repository.EnvironmentSummary) string {
+	return fmt.Sprintf("%d/%s", envInfo.UpdatedAt.UnixNano(), envInfo.ID)
+}
+
+// environmentListStart returns the index of the first environment listed after the key after. Comparing
+// keys rather than looking the environment up keeps pages consistent when environments are updated,
+// created or deleted between calls.
+func environmentListStart(envInfos []*repository.EnvironmentSummary, after string) (int, error) {
+	if after == "" {
+		return 0, nil
+	}
+	timestamp, id, ok := strings.Cut(after, "/")
+	updatedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("invalid cursor: pass the next_cursor of a previous call")
+	}
+	for i, envInfo := range envInfos {
+		updated := envInfo.UpdatedAt.UnixNano()
+		if updated < updatedAt || (updated == updatedAt && envInfo.ID > id) {
+			return i, nil
+		}
+	}
+	return len(envInfos), nil
+}
+
+var EnvironmentHistoryTool = &Tool{
+	Definition: mcp.NewTool("environment_history",
+		mcp.WithDescription("List the commits of the environment that are not on the current branch of the source repository, oldest first, each with its explanation and the operations that produced it. Read-only, doesn't need the environment container. Results are paginated: when next_cursor is set, call again with it as cursor to get the following commits."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the history is being read."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment."),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("patch",
+			mcp.Description("Include the diff of each commit."),
+		),
+		limitOption("commits"),
+		cursorOption("commits"),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		limit, after, err := pageParams(request)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := repo.History(ctx, envID, request.GetBool("patch", false))
+		if err != nil {
+			return engineFailure(ctx, "failed to read the history", err), nil
+		}
+		start := 0
+		if after != "" {
+			i := slices.IndexFunc(entries, func(entry *repository.HistoryEntry) bool { return entry.Commit == after })
+			if i < 0 {
+				return nil, fmt.Errorf("invalid cursor: commit %s is no longer in the history of the environment, start over without a cursor", after)
+			}
+			start = i + 1
+		}
+		entries, next := paginate(entries, start, limit, func(entry *repository.HistoryEntry) string { return entry.Commit })
+		maxDiff := maxDiffBytes()
+		for _, entry := range entries {
+			entry.Patch, _ = summarizeDiff(entry.Patch, maxDiff, fmt.Sprintf("Call environment_diff with revision %s and paths to get the diff of specific files.", entry.Commit))
+		}
+
+		out, err := json.Marshal(environmentHistoryResponse{Commits: entries, NextCursor: next})
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentDiffTool = &Tool{
+	Definition: mcp.NewTool("environment_diff",
+		mcp.WithDescription("Show the changes of the environment as a unified diff: all the changes that are not on the current branch of the source repository, or those of a single commit. Diffs too large for the context window are summarized with the lines and hunks changed per file; call again with paths to get the diff of specific files. Read-only, doesn't need the environment container."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the diff is being read."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment."),
+			mcp.Required(),
+		),
+		mcp.WithString("revision",
+			mcp.Description("Only show the changes of this commit of the environment: a commit shown by environment_history, or ~N for N commits before the latest."),
+		),
+		mcp.WithArray("paths",
+			mcp.Description("Only show the changes of these files or directories, relative to the root of the repository."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+		paths, err := optionalStringSlice(request, "paths")
+		if err != nil {
+			return nil, err
+		}
+
+		patch, err := repo.EnvironmentDiff(ctx, envID, request.GetString("revision", ""), paths)
+		if err != nil {
+			return engineFailure(ctx, "failed to get the diff", err), nil
+		}
+		if patch == "" {
+			return mcp.NewToolResultText("No changes."), nil
+		}
+
+		limit := maxDiffBytes()
+		if len(paths) == 0 {
+			patch, _ = summarizeDiff(patch, limit, "Call environment_diff with paths to get the diff of specific files.")
+			return mcp.NewToolResultText(patch), nil
+		}
+		// The files were asked for explicitly: show as much of them as fits
+		if truncated, ok := truncateDiff(patch, limit); ok {
+			return mcp.NewToolResultText(fmt.Sprintf("%s\n[diff truncated at %d of %d bytes: ask for fewer paths to see the rest]", truncated, len(truncated), len(patch))), nil
+		}
+		return mcp.NewToolResultText(patch), nil
+	},
+}
+
+type environmentHistoryResponse struct {
+	Commits []*repository.HistoryEntry `json:"commits"`
+	// NextCursor is set when more commits follow.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// environmentListEntry is an environment as listed by environment_list.
+type environmentListEntry struct {
+	EnvironmentResponse
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Ahead     int       `json:"commits_ahead_of_source"`
+	Behind    int       `json:"commits_behind_source"`
+	Dirty     bool      `json:"has_uncommitted_changes"`
+}
+
+var EnvironmentSetupRerunTool = &Tool{
+	Definition: mcp.NewTool("environment_setup_rerun",
+		mcp.WithDescription("Re-run a single setup phase (e.g. deps after changing dependencies) without rebuilding the phases before it. Later phases run again since they build on top of it. Like environment_update, this restarts the environment."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this phase is being re-run."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to update."),
+			mcp.Required(),
+		),
+		mcp.WithString("phase",
+			mcp.Description("The phase to re-run: setup (the plain setup_commands), system, language, deps or project."),
+			mcp.Required(),
+			mcp.Enum(append([]string{"setup"}, environment.SetupPhaseNames...)...),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		phase, err := request.RequireString("phase")
+		if err != nil {
+			return nil, err
+		}
+		if err := environment.ValidateSetupPhase(phase); err != nil {
+			return nil, err
+		}
+
+		stopProgress := startProgress(ctx, request, "environment_setup_rerun")
+		err = env.RerunSetupPhase(ctx, request.GetString("explanation", ""), phase)
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to re-run the setup phase", repo.SaveBuildError(env.ID, err)), nil
+		}
+
+		if err := updateRepository(ctx, repo, env, request, "Re-run setup phase "+phase); err != nil {
 			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
 		}
 
-		out, err := marshalEnvironment(env)
+		out, err := marshalEnvironment(env)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to marshal environment", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Setup phase %s of environment %s re-run successfully. Environment has been restarted, all previous commands have been lost.\n%s", phase, env.ID, out)), nil
+	},
+}
+
+var EnvironmentBuildLogTool = &Tool{
+	Definition: mcp.NewTool("environment_build_log",
+		mcp.WithDescription("Read the full output of the setup commands of a previous build of the environment, including failed builds. Use it to investigate why environment_create, environment_update or environment_setup_rerun failed."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this build log is being read."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("revision",
+			mcp.Description("Which build to read: 0 for the most recent (default), 1 for the one before, and so on."),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("tail",
+			mcp.Description("Only return the last lines of the log."),
+			mcp.Min(1),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, err := openRepository(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
+		}
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
+		}
+
+		info, log, err := repo.ReadBuildLog(envID, request.GetInt("revision", 0))
+		if err != nil {
+			return engineFailure(ctx, "failed to read build log", err), nil
+		}
+		if tail := request.GetInt("tail", 0); tail > 0 {
+			lines := strings.Split(strings.TrimSuffix(log, "\n"), "\n")
+			log = strings.Join(lines[max(len(lines)-tail, 0):], "\n")
+		}
+
+		status := "succeeded"
+		if info.Failed {
+			status = "failed"
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Build started at %s %s:\n%s", info.StartedAt.Format(time.RFC3339), status, log)), nil
+	},
+}
+
+var EnvironmentRunCmdTool = &Tool{
+	Definition: mcp.NewTool("environment_run_cmd",
+		mcp.WithDescription("Run a terminal command inside a NEW container within the environment. Foreground commands return a JSON object with exit_code, stdout, stderr and duration_ms; a non-zero exit_code is reported as a tool error. Clients that send a progress token get the output of foreground commands as progress notifications while they run. Commands the repository policy denies or requires approval for are rejected with a JSON policy_violation error: do not try to work around it, and for require_approval ask the user to run its approve_command before retrying. If the policy waits for approvals, the call blocks until the user decides instead."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this command is being run."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("command",
+			mcp.Description("The terminal command to execute. If empty, the environment's default command is used."),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting this command (default: sh)"),
+		),
+		mcp.WithBoolean("background",
+			mcp.Description(`Run the command in the background
+Must ALWAYS be set for long running command (e.g. http server).
+Failure to do so will result in the tool being stuck, awaiting for the command to finish.`,
+			),
+		),
+		mcp.WithBoolean("use_entrypoint",
+			mcp.Description("Use the image entrypoint, if present, by prepending it to the args."),
+		),
+		mcp.WithArray("ports",
+			mcp.Description("Ports to expose. Only works with background environments. For each port, returns the environment_internal (for use inside environments) and host_external (for use by the user) addresses."),
+			mcp.Items(portItems),
+		),
+		mcp.WithString("workdir",
+			mcp.Description("Directory to run the command in, absolute or relative to the environment workdir. Only applies to this command."),
+		),
+		mcp.WithString("user",
+			mcp.Description("User to run this command as instead of the environment's, as a name or UID with an optional group, e.g. root to install system packages."),
+		),
+		mcp.WithArray("env",
+			mcp.Description("Additional environment variables for this command only, in the format KEY=VALUE. Use environment_update to set variables for every command."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("nice",
+			mcp.Description("Lower the CPU and I/O priority of this command (1-19, higher is lower priority), e.g. for heavy builds that shouldn't starve services."),
+			mcp.Min(0),
+			mcp.Max(19),
+		),
+		mcp.WithNumber("memory_limit_mb",
+			mcp.Description("Cap the virtual memory of this command, in megabytes. The command fails to allocate past the limit."),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("Kill the command, or the default command, if it runs longer than this many seconds, rounded up, and return its output so far. Ignored for background commands."),
+			mcp.Min(1),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		command := request.GetString("command", "")
+		shell := request.GetString("shell", "sh")
+		// Without a command, the default command of the environment runs
+		checked := []string{cmp.Or(command, env.Config.DefaultCommand)}
+		if request.GetBool("use_entrypoint", false) {
+			checked = append(checked, env.Config.EntrypointCommand())
+		}
+		if denied, err := checkCommandsPolicy(ctx, request, repo, env.ID, checked); denied != nil || err != nil {
+			return denied, err
+		}
+
+		updateRepo := func() (*mcp.CallToolResult, error) {
+			if err := updateRepository(ctx, repo, env, request, "Run "+command); err != nil {
+				return mcp.NewToolResultErrorFromErr("failed to update repository", err), err
+			}
+			return nil, nil
+		}
+
+		envs, err := optionalStringSlice(request, "env")
+		if err != nil {
+			return nil, err
+		}
+		nice := request.GetInt("nice", 0)
+		if nice < 0 || nice > 19 {
+			return nil, fmt.Errorf("nice must be between 0 and 19")
+		}
+		memoryLimit := request.GetInt("memory_limit_mb", 0)
+		if memoryLimit < 0 {
+			return nil, fmt.Errorf("memory_limit_mb must be positive")
+		}
+		user := request.GetString("user", "")
+		if err := environment.ValidateUser(user); err != nil {
+			return nil, err
+		}
+		opts := environment.RunOpts{
+			UseEntrypoint: request.GetBool("use_entrypoint", false),
+			Workdir:       request.GetString("workdir", ""),
+			Env:           envs,
+			Nice:          nice,
+			MemoryLimit:   int64(memoryLimit) * 1024 * 1024,
+			User:          user,
+		}
+
+		background := request.GetBool("background", false)
+		if background {
+			ports, err := optionalPorts(request, "ports")
+			if err != nil {
+				return nil, err
+			}
+			process, runErr := env.RunBackground(ctx, command, shell, ports, opts)
+			// We want to update the repository even if the command failed.
+			if resp, err := updateRepo(); err != nil {
+				return resp, nil
+			}
+			if runErr != nil {
+				return mcp.NewToolResultErrorFromErr("failed to run command", runErr), nil
+			}
+
+			out, err := json.Marshal(process.Endpoints)
+			if err != nil {
+				return nil, err
+			}
+
+			return mcp.NewToolResultText(fmt.Sprintf(`Command started in the background in NEW container with process ID %s. Endpoints are %s
+
+Use environment_process_logs to read its output and environment_process_kill to stop it.
+
+To access from the user's machine: use host_external. To access from other commands in this environment: use environment_internal.
+
+Any changes to the container workdir (%s) WILL NOT be committed to container-use/%s
+
+Background commands are unaffected by filesystem and any other kind of changes. You need to start a new command for changes to take effect.`,
+				process.ID, string(out), env.Config.Workdir, env.ID)), nil
+		}
+
+		timeout := time.Duration(request.GetFloat("timeout_seconds", 0) * float64(time.Second))
+		if timeout < 0 {
+			return nil, fmt.Errorf("timeout_seconds can't be negative")
+		}
+		opts.Timeout = timeout
+
+		start := time.Now()
+		output, stopProgress := startOutputProgress(ctx, request, "environment_run_cmd")
+		opts.Output = output
+		result, runErr := env.Run(ctx, command, shell, opts)
+		stopProgress(fmt.Sprintf("finished in %s", time.Since(start).Round(time.Millisecond)))
+		if ctx.Err() != nil {
+			// The client cancelled the call: nothing ran to completion, so there is nothing to record.
+			return mcp.NewToolResultErrorFromErr("command cancelled", runErr), nil
+		}
+		// We want to update the repository even if the command failed.
+		if resp, err := updateRepo(); err != nil {
+			return resp, nil
+		}
+		if runErr != nil {
+			return mcp.NewToolResultErrorFromErr("failed to run command", runErr), nil
+		}
+
+		response := &runCmdResponse{
+			RunResult: result,
+			Note:      fmt.Sprintf("Any changes to the container workdir (%s) have been committed and pushed to container-use/ remote", env.Config.Workdir),
+		}
+		if result.TimedOut {
+			response.Note = fmt.Sprintf("Command was killed after %s, output is partial. %s", timeout, response.Note)
+		}
+		out, err := json.Marshal(response)
+		if err != nil {
+			return nil, err
+		}
+		if result.ExitCode != 0 {
+			return mcp.NewToolResultError(string(out)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+// runCmdResponse is the result of a foreground environment_run_cmd call.
+type runCmdResponse struct {
+	*environment.RunResult
+	Note string `json:"note"`
+}
+
+var EnvironmentProcessListTool = &Tool{
+	Definition: mcp.NewTool("environment_process_list",
+		mcp.WithDescription("List the background processes started with environment_run_cmd, with their status and endpoints. Processes still running when container-use restarted are stopped, and listed as lost: their logs can still be read."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the processes are being listed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		processes, err := env.Processes(ctx)
+		if err != nil {
+			return engineFailure(ctx, "failed to list processes", err), nil
+		}
+
+		out, err := json.Marshal(processes)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentProcessLogsTool = &Tool{
+	Definition: mcp.NewTool("environment_process_logs",
+		mcp.WithDescription("Read the output (stdout and stderr) of a background process."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the logs are being read."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("process_id",
+			mcp.Description("The ID of the process, as returned when it was started."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("tail",
+			mcp.Description("Only return the last N lines. Defaults to the whole output."),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("follow_seconds",
+			mcp.Description("Keep reading new output for this many seconds before returning, e.g. to wait for a server to log that it is ready."),
+			mcp.Min(1),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+		processID, err := request.RequireString("process_id")
+		if err != nil {
+			return nil, err
+		}
+		follow := time.Duration(request.GetFloat("follow_seconds", 0) * float64(time.Second))
+
+		logs, err := env.ProcessLogs(ctx, processID, request.GetInt("tail", 0), follow)
+		if err != nil {
+			return engineFailure(ctx, "failed to read process logs", err), nil
+		}
+		return mcp.NewToolResultText(logs), nil
+	},
+}
+
+var EnvironmentProcessKillTool = &Tool{
+	Definition: mcp.NewTool("environment_process_kill",
+		mcp.WithDescription("Stop a background process."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this process is being stopped."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("process_id",
+			mcp.Description("The ID of the process, as returned when it was started."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		processID, err := request.RequireString("process_id")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.ProcessKill(ctx, processID); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to kill process", err), nil
+		}
+		if err := updateRepository(ctx, repo, env, request, "Kill process "+processID); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Process %s stopped.", processID)), nil
+	},
+}
+
+var EnvironmentWaitTool = &Tool{
+	Definition: mcp.NewTool("environment_wait",
+		mcp.WithDescription(`Wait until a condition holds in the environment, instead of polling with sleep. Exactly one of:
+- port: wait until the port accepts TCP connections
+- url: wait until the URL responds with 200 OK
+- process_id alone: wait until the background process exits`),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for what is being waited for."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("port",
+			mcp.Description("The TCP port to wait for."),
+			mcp.Min(1),
+			mcp.Max(65535),
+		),
+		mcp.WithString("host",
+			mcp.Description("The host of the port. Defaults to the background process given by process_id, or to the background process or service exposing the port."),
+		),
+		mcp.WithString("url",
+			mcp.Description("The HTTP URL to wait for, as reachable from the environment (e.g. http://<host>:8080/health)."),
+		),
+		mcp.WithString("process_id",
+			mcp.Description("The ID of a background process: the process to wait for, or the host of the port."),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("How long to wait before giving up. Defaults to 60."),
+			mcp.Min(1),
+			mcp.Max(600),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		port := request.GetInt("port", 0)
+		target := request.GetString("url", "")
+		processID := request.GetString("process_id", "")
+		timeout := time.Duration(request.GetFloat("timeout_seconds", 60) * float64(time.Second))
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout_seconds must be positive")
+		}
+
+		start := time.Now()
+		switch {
+		case target != "":
+			if port != 0 {
+				return nil, fmt.Errorf("port and url are mutually exclusive")
+			}
+			if err := env.WaitForHTTP(ctx, target, timeout); err != nil {
+				return mcp.NewToolResultErrorFromErr("condition not met", err), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("%s responded with 200 OK after %s.", target, time.Since(start).Round(time.Second))), nil
+		case port != 0:
+			address, err := env.WaitForPort(ctx, request.GetString("host", ""), port, processID, timeout)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("condition not met", err), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("%s is accepting connections after %s.", address, time.Since(start).Round(time.Second))), nil
+		case processID != "":
+			process, err := env.WaitForProcess(ctx, processID, timeout)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("condition not met", err), nil
+			}
+			out, err := json.Marshal(process)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("failed to marshal process", err), nil
+			}
+			return mcp.NewToolResultText(fmt.Sprintf("Process %s exited after %s: %s", processID, time.Since(start).Round(time.Second), out)), nil
+		default:
+			return nil, fmt.Errorf("one of port, url or process_id is required")
+		}
+	},
+}
+
+var EnvironmentPortForwardTool = &Tool{
+	Definition: mcp.NewTool("environment_port_forward",
+		mcp.WithDescription("Forward a port of a background process or service to a stable localhost port, so the user can open the app in their browser. The port stays forwarded until the MCP server stops. Share the returned address with the user."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this port is being forwarded."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("port",
+			mcp.Description("The port to forward, as exposed by the background process or service."),
+			mcp.Required(),
+			mcp.Min(1),
+			mcp.Max(65535),
+		),
+		mcp.WithNumber("host_port",
+			mcp.Description("The localhost port to forward to. Defaults to the same port."),
+			mcp.Min(1),
+			mcp.Max(65535),
+		),
+		mcp.WithString("target",
+			mcp.Description("The ID of the background process or the name of the service exposing the port. Defaults to the most recent background process, then the first service, exposing it."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		port := request.GetInt("port", 0)
+		if port <= 0 {
+			return nil, fmt.Errorf("port is required")
+		}
+
+		forward, err := env.ForwardPort(ctx, port, request.GetInt("host_port", 0), request.GetString("target", ""))
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to forward port", err), nil
+		}
+		out, err := json.Marshal(forward)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to marshal forward", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Port %d of %s forwarded to %s: %s", port, forward.Target, forward.Address, out)), nil
+	},
+}
+
+var EnvironmentJobStartTool = &Tool{
+	Definition: mcp.NewTool("environment_job_start",
+		mcp.WithDescription(`Start a long-running command that is expected to finish, such as a full test suite, a large build or a data migration, and return its job ID immediately instead of blocking.
+Use environment_job_status to poll it, environment_job_wait to block until it finishes and environment_job_cancel to stop it. The result of the job is kept once it finishes.
+Jobs run on a snapshot of the environment: changes they make to the container are NOT committed. Use environment_run_cmd for commands whose changes must be kept.`),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this job is being started."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("command",
+			mcp.Description("The terminal command to execute."),
+			mcp.Required(),
+		),
+		mcp.WithString("shell",
+			mcp.Description("The shell that will be interpreting this command (default: sh)"),
+		),
+		mcp.WithBoolean("use_entrypoint",
+			mcp.Description("Use the image entrypoint, if present, by prepending it to the args."),
+		),
+		mcp.WithString("workdir",
+			mcp.Description("Directory to run the command in, absolute or relative to the environment workdir."),
+		),
+		mcp.WithString("user",
+			mcp.Description("User to run the job as instead of the environment's, as a name or UID with an optional group, e.g. root."),
+		),
+		mcp.WithArray("env",
+			mcp.Description("Additional environment variables for this job only, in the format KEY=VALUE."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("nice",
+			mcp.Description("Lower the CPU and I/O priority of this job (1-19, higher is lower priority)."),
+			mcp.Min(0),
+			mcp.Max(19),
+		),
+		mcp.WithNumber("memory_limit_mb",
+			mcp.Description("Cap the virtual memory of this job, in megabytes."),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("Stop the job and mark it as failed if it runs longer than this many seconds. Defaults to no limit."),
+			mcp.Min(1),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		command, err := request.RequireString("command")
+		if err != nil {
+			return nil, err
+		}
+		if denied, err := checkCommandPolicy(ctx, request, repo, env.ID, command); denied != nil || err != nil {
+			return denied, err
+		}
+		envs, err := optionalStringSlice(request, "env")
+		if err != nil {
+			return nil, err
+		}
+		nice := request.GetInt("nice", 0)
+		if nice < 0 || nice > 19 {
+			return nil, fmt.Errorf("nice must be between 0 and 19")
+		}
+		memoryLimit := request.GetInt("memory_limit_mb", 0)
+		if memoryLimit < 0 {
+			return nil, fmt.Errorf("memory_limit_mb must be positive")
+		}
+		timeout := time.Duration(request.GetFloat("timeout_seconds", 0) * float64(time.Second))
+		if timeout < 0 {
+			return nil, fmt.Errorf("timeout_seconds can't be negative")
+		}
+		user := request.GetString("user", "")
+		if err := environment.ValidateUser(user); err != nil {
+			return nil, err
+		}
+		opts := environment.RunOpts{
+			UseEntrypoint: request.GetBool("use_entrypoint", false),
+			Workdir:       request.GetString("workdir", ""),
+			Env:           envs,
+			Nice:          nice,
+			MemoryLimit:   int64(memoryLimit) * 1024 * 1024,
+			User:          user,
+			Timeout:       timeout,
+		}
+
+		job, err := env.StartJob(ctx, command, request.GetString("shell", "sh"), opts, func(job *environment.Job, output string) {
+			if err := repo.SaveJob(env.ID, job, output); err != nil {
+				slog.Error("failed to save job", "environment", env.ID, "job", job.ID, "error", err)
+			}
+		})
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to start job", err), nil
+		}
+		if err := repo.SaveJob(env.ID, job, ""); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to save job", err), nil
+		}
+		if err := updateRepository(ctx, repo, env, request, "Start job "+job.ID); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf(`Job %s started. Use environment_job_status to check on it and environment_job_wait to wait for it to finish.
+
+Jobs run until they finish, are canceled or the MCP server stops. Changes to the container workdir (%s) WILL NOT be committed to container-use/%s`,
+			job.ID, env.Config.Workdir, env.ID)), nil
+	},
+}
+
+// jobStatusResponse is the result of environment_job_status and environment_job_wait for a single job.
+type jobStatusResponse struct {
+	*environment.Job
+	Output string `json:"output"`
+}
+
+// jobStatus returns the state and output of job id, from this process if it is tracking it or from
+// the repository otherwise.
+func jobStatus(ctx context.Context, repo *repository.Repository, env *environment.Environment, id string, tail int) (*jobStatusResponse, error) {
+	job, err := repo.Job(env.ID, id)
+	if err != nil {
+		return nil, err
+	}
+	var output string
+	if job.Status == environment.JobRunning {
+		output, err = env.JobLogs(ctx, id, tail)
+	} else {
+		output, err = repo.JobOutput(env.ID, id, tail)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the job output: %w", err)
+	}
+	return &jobStatusResponse{Job: job, Output: output}, nil
+}
+
+var EnvironmentJobStatusTool = &Tool{
+	Definition: mcp.NewTool("environment_job_status",
+		mcp.WithDescription(`Check on jobs started with environment_job_start. With job_id, returns its status (running, succeeded, failed, canceled, or lost if the MCP server running it stopped), exit code and output. Without, lists the jobs of the environment.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the job status is being checked."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job, as returned when it was started."),
+		),
+		mcp.WithNumber("tail",
+			mcp.Description("Only return the last N lines of output. Defaults to 100, use 0 for the whole output."),
+			mcp.Min(0),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		jobID := request.GetString("job_id", "")
+		if jobID == "" {
+			jobs, err := repo.Jobs(env.ID)
+			if err != nil {
+				return engineFailure(ctx, "failed to list jobs", err), nil
+			}
+			if jobs == nil {
+				jobs = []*environment.Job{}
+			}
+			out, err := json.Marshal(jobs)
+			if err != nil {
+				return nil, err
+			}
+			return mcp.NewToolResultText(string(out)), nil
+		}
+
+		status, err := jobStatus(ctx, repo, env, jobID, request.GetInt("tail", 100))
+		if err != nil {
+			return engineFailure(ctx, "failed to get job status", err), nil
+		}
+		out, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentJobWaitTool = &Tool{
+	Definition: mcp.NewTool("environment_job_wait",
+		mcp.WithDescription("Wait for a job started with environment_job_start to finish, up to a timeout, and return its status and output. If the job is still running on timeout, its status is running: call again to keep waiting."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the job is being waited for."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job, as returned when it was started."),
+			mcp.Required(),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("How long to wait before returning. Defaults to 300."),
+			mcp.Min(1),
+			mcp.Max(3600),
+		),
+		mcp.WithNumber("tail",
+			mcp.Description("Only return the last N lines of output. Defaults to 100, use 0 for the whole output."),
+			mcp.Min(0),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+		timeout := time.Duration(request.GetFloat("timeout_seconds", 300) * float64(time.Second))
+		if timeout <= 0 {
+			return nil, fmt.Errorf("timeout_seconds must be positive")
+		}
+
+		// Jobs started by another process can't be waited for: report their recorded state
+		if _, ok := environment.TrackedJob(env.ID, jobID); ok {
+			start := time.Now()
+			stopProgress := startProgress(ctx, request, "environment_job_wait")
+			_, err := env.WaitJob(ctx, jobID, timeout)
+			stopProgress(fmt.Sprintf("waited %s", time.Since(start).Round(time.Second)))
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("failed to wait for job", err), nil
+			}
+		}
+
+		status, err := jobStatus(ctx, repo, env, jobID, request.GetInt("tail", 100))
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to get job status", err), nil
+		}
+		out, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+		if status.Status == environment.JobFailed || status.Status == environment.JobLost {
+			return mcp.NewToolResultError(string(out)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentJobCancelTool = &Tool{
+	Definition: mcp.NewTool("environment_job_cancel",
+		mcp.WithDescription("Cancel a running job started with environment_job_start."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this job is being canceled."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("job_id",
+			mcp.Description("The ID of the job, as returned when it was started."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		jobID, err := request.RequireString("job_id")
+		if err != nil {
+			return nil, err
+		}
+
+		job, err := env.CancelJob(ctx, jobID)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to cancel job", err), nil
+		}
+		if err := updateRepository(ctx, repo, env, request, "Cancel job "+jobID); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Job %s is %s.", jobID, job.Status)), nil
+	},
+}
+
+var EnvironmentFileReadTool = &Tool{
+	Definition: mcp.NewTool("environment_file_read",
+		mcp.WithDescription("Read the contents of a file, specifying a line range or the entire file."),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being read."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to read, absolute or relative to the workdir"),
+			mcp.Required(),
+		),
+		mcp.WithBoolean("should_read_entire_file",
+			mcp.Description("Whether to read the entire file. Defaults to false."),
+		),
+		mcp.WithNumber("start_line_one_indexed_inclusive",
+			mcp.Description("The one-indexed line number to start reading from (inclusive)."),
+		),
+		mcp.WithNumber("end_line_one_indexed_inclusive",
+			mcp.Description("The one-indexed line number to end reading at (inclusive)."),
+		),
+		mcp.WithString("revision",
+			mcp.Description(revisionDescription),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		shouldReadEntireFile := request.GetBool("should_read_entire_file", false)
+		startLineOneIndexedInclusive := request.GetInt("start_line_one_indexed_inclusive", 0)
+		endLineOneIndexedInclusive := request.GetInt("end_line_one_indexed_inclusive", 0)
+
+		if revision := request.GetString("revision", ""); revision != "" {
+			repo, envInfo, err := openEnvironmentInfo(ctx, request)
+			if err != nil {
+				return engineFailure(ctx, "unable to open the environment", err), nil
+			}
+			name, err := workdirRelative(envInfo.Config.Workdir, targetFile)
+			if err != nil {
+				return nil, err
+			}
+			file, err := repo.ReadFileAt(ctx, envInfo.ID, revision, name)
+			if err != nil {
+				return engineFailure(ctx, "failed to read file", err), nil
+			}
+			fileContents, err := environment.SelectLines(file, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+			if err != nil {
+				return engineFailure(ctx, "failed to read file", err), nil
+			}
+			return mcp.NewToolResultText(fileContents), nil
+		}
+
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		fileContents, err := env.FileRead(ctx, targetFile, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+		if err != nil {
+			return engineFailure(ctx, "failed to read file", err), nil
+		}
+
+		return mcp.NewToolResultText(fileContents), nil
+	},
+}
+
+var EnvironmentFileListTool = &Tool{
+	Definition: mcp.NewTool("environment_file_list",
+		mcp.WithDescription("List the contents of a directory"),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this directory is being listed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Path of the directory to list contents of, absolute or relative to the workdir"),
+			mcp.Required(),
+		),
+		mcp.WithString("revision",
+			mcp.Description(revisionDescription),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		path, err := request.RequireString("path")
+		if err != nil {
+			return nil, err
+		}
+
+		if revision := request.GetString("revision", ""); revision != "" {
+			repo, envInfo, err := openEnvironmentInfo(ctx, request)
+			if err != nil {
+				return engineFailure(ctx, "unable to open the environment", err), nil
+			}
+			name, err := workdirRelative(envInfo.Config.Workdir, path)
+			if err != nil {
+				return nil, err
+			}
+			entries, err := repo.ListFilesAt(ctx, envInfo.ID, revision, name)
+			if err != nil {
+				return engineFailure(ctx, "failed to list directory", err), nil
+			}
+			out := &strings.Builder{}
+			for _, entry := range entries {
+				if entry.Dir {
+					fmt.Fprintf(out, "%s/\n", entry.Name)
+				} else {
+					fmt.Fprintf(out, "%s\n", entry.Name)
+				}
+			}
+			return mcp.NewToolResultText(out.String()), nil
+		}
+
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		out, err := env.FileList(ctx, path)
+		if err != nil {
+			return engineFailure(ctx, "failed to list directory", err), nil
+		}
+
+		return mcp.NewToolResultText(out), nil
+	},
+}
+
+var EnvironmentFileSearchTool = &Tool{
+	Definition: mcp.NewTool("environment_file_search",
+		mcp.WithDescription(`Search file contents in the environment for a regular expression or literal string.
+Returns matching lines formatted as "file:line:text". Prefer this over running grep through environment_run_cmd.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this search is being run."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("pattern",
+			mcp.Description("The pattern to search for (extended regular expression unless `literal` is set)."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to search, absolute or relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithBoolean("literal",
+			mcp.Description("Treat the pattern as a literal string instead of a regular expression."),
+		),
+		mcp.WithBoolean("ignore_case",
+			mcp.Description("Perform a case insensitive search."),
+		),
+		mcp.WithArray("include",
+			mcp.Description("Only search files whose name matches one of these globs (e.g. `[\"*.go\", \"*.md\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Skip files and directories whose name matches one of these globs (e.g. `[\"node_modules\", \"*.min.js\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithNumber("context_lines",
+			mcp.Description("Number of lines of context to show before and after each match."),
+		),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of output lines to return (default: 100)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		pattern, err := request.RequireString("pattern")
+		if err != nil {
+			return nil, err
+		}
+
+		include, err := optionalStringSlice(request, "include")
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := optionalStringSlice(request, "exclude")
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := env.FileSearch(ctx, pattern, environment.FileSearchOpts{
+			Path:         request.GetString("path", ""),
+			Literal:      request.GetBool("literal", false),
+			IgnoreCase:   request.GetBool("ignore_case", false),
+			Include:      include,
+			Exclude:      exclude,
+			ContextLines: request.GetInt("context_lines", 0),
+			MaxResults:   request.GetInt("max_results", 0),
+		})
+		if err != nil {
+			return engineFailure(ctx, "failed to search files", err), nil
+		}
+
+		return mcp.NewToolResultText(out), nil
+	},
+}
+
+var EnvironmentFileGlobTool = &Tool{
+	Definition: mcp.NewTool("environment_file_glob",
+		mcp.WithDescription(`Find files matching glob patterns (e.g. "**/*.go", "src/**/*.test.ts").
+Searches recursively, which is much faster than walking the tree with environment_file_list.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why these files are being searched for."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithArray("patterns",
+			mcp.Description("Glob patterns to match, relative to `path`. `**` matches any number of directories."),
+			mcp.Required(),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to search from, absolute or relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithBoolean("include_metadata",
+			mcp.Description("Include the size and modification time of each file."),
+		),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of files to return (default: 1000)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		patterns, err := request.RequireStringSlice("patterns")
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := env.FileGlob(ctx, request.GetString("path", ""), patterns, request.GetBool("include_metadata", false), request.GetInt("max_results", 0))
+		if err != nil {
+			return engineFailure(ctx, "failed to find files", err), nil
+		}
+
+		return mcp.NewToolResultText(out), nil
+	},
+}
+
+var EnvironmentRepoStatsTool = &Tool{
+	Definition: mcp.NewTool("environment_repo_stats",
+		mcp.WithDescription(`Get an overview of the repository in one call: file count and size, language breakdown, largest directories, build files (Makefile, Taskfile, package.json, go.mod, ...) and CI configuration.
+Use this first to orient on an unfamiliar codebase instead of listing and reading files one by one.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the repository statistics are needed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to compute statistics for, absolute or relative to the workdir. Defaults to the workdir."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		stats, err := env.RepoStats(ctx, request.GetString("path", ""))
+		if err != nil {
+			return engineFailure(ctx, "failed to compute repository statistics", err), nil
+		}
+
+		out, err := json.Marshal(stats)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentDependenciesTool = &Tool{
+	Definition: mcp.NewTool("environment_dependencies",
+		mcp.WithDescription(`List the dependencies of the project as JSON (ecosystem, name, version, direct or transitive, manifest), extracted from go.mod, package.json, package-lock.json, yarn.lock, pnpm-lock.yaml, pyproject.toml, requirements.txt, poetry.lock, uv.lock, Cargo.toml and Cargo.lock.
+Use this to answer questions like "what version of X do we use" instead of reading lockfiles. Versions are exact when a lockfile is present, otherwise they are the constraints of the manifest.`),
+		mcp.WithReadOnlyHintAnnotation(true),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the dependencies are needed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("name",
+			mcp.Description("Only return the dependencies whose name contains this string, ignoring case."),
+		),
+		mcp.WithBoolean("direct_only",
+			mcp.Description("Only return the dependencies declared by the project, not their own dependencies."),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to search for manifests recursively, absolute or relative to the workdir. Defaults to the workdir."),
+		),
+		mcp.WithNumber("max_results",
+			mcp.Description("Maximum number of dependencies to return (default: 500)."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		_, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return engineFailure(ctx, "unable to open the environment", err), nil
+		}
+
+		report, err := env.Dependencies(ctx, environment.DependencyQuery{
+			Path:       request.GetString("path", ""),
+			Name:       request.GetString("name", ""),
+			DirectOnly: request.GetBool("direct_only", false),
+			MaxResults: request.GetInt("max_results", 0),
+		})
+		if err != nil {
+			return engineFailure(ctx, "failed to extract dependencies", err), nil
+		}
+
+		out, err := json.Marshal(report)
+		if err != nil {
+			return nil, err
+		}
+		return mcp.NewToolResultText(string(out)), nil
+	},
+}
+
+var EnvironmentFileWriteTool = &Tool{
+	Definition: mcp.NewTool("environment_file_write",
+		mcp.WithDescription("Write the contents of a file."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being written."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("contents",
+			mcp.Description("Full text content of the file you want to write."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+		contents, err := request.RequireString("contents")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.FileWrite(ctx, request.GetString("explanation", ""), targetFile, contents); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to write file", err), nil
+		}
+
+		if err := updateRepositoryLazily(ctx, repo, env, request, "Write "+targetFile); err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+var EnvironmentFileEditTool = &Tool{
+	Definition: mcp.NewTool("environment_file_edit",
+		mcp.WithDescription(`Edit a file in place without rewriting all of it. Prefer this over environment_file_write for changes to existing files.
+Provide either a list of search/replace edits or a unified diff. Either all edits are applied, or none are and the failing ones are reported.`),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being edited."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to edit, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithArray("edits",
+			mcp.Description("Search/replace edits applied in order. `search` must match exactly once unless `replace_all` is set."),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"search":      map[string]any{"type": "string", "description": "Exact text to replace, including whitespace."},
+					"replace":     map[string]any{"type": "string", "description": "Replacement text."},
+					"replace_all": map[string]any{"type": "boolean", "description": "Replace every occurrence instead of requiring a unique match."},
+				},
+				"required": []string{"search", "replace"},
+			}),
+		),
+		mcp.WithString("diff",
+			mcp.Description("Unified diff to apply to the file (as produced by `diff -u`). Line numbers may be approximate, context lines must match."),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+
+		diff := request.GetString("diff", "")
+		rawEdits, hasEdits := request.GetArguments()["edits"]
+		switch {
+		case diff != "" && hasEdits:
+			return mcp.NewToolResultError("only one of `edits` or `diff` can be provided"), nil
+		case diff != "":
+			err = env.FilePatch(ctx, request.GetString("explanation", ""), targetFile, diff)
+		case hasEdits:
+			var edits []environment.EditHunk
+			data, marshalErr := json.Marshal(rawEdits)
+			if marshalErr == nil {
+				marshalErr = json.Unmarshal(data, &edits)
+			}
+			if marshalErr != nil {
+				return mcp.NewToolResultErrorFromErr("invalid edits", marshalErr), nil
+			}
+			if len(edits) == 0 {
+				return mcp.NewToolResultError("`edits` must contain at least one edit"), nil
+			}
+			err = env.FileEdit(ctx, request.GetString("explanation", ""), targetFile, edits)
+		default:
+			return mcp.NewToolResultError("one of `edits` or `diff` is required"), nil
+		}
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to edit file", err), nil
+		}
+
+		if err := updateRepositoryLazily(ctx, repo, env, request, "Edit "+targetFile); err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("file %s edited successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+var EnvironmentFileDeleteTool = &Tool{
+	Definition: mcp.NewTool("environment_file_delete",
+		mcp.WithDescription("Deletes a file at the specified path."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being deleted."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("target_file",
+			mcp.Description("Path of the file to delete, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		targetFile, err := request.RequireString("target_file")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.FileDelete(ctx, request.GetString("explanation", ""), targetFile); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to delete file", err), nil
+		}
+
+		if err := updateRepositoryLazily(ctx, repo, env, request, "Delete "+targetFile); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully and committed to container-use/ remote", targetFile)), nil
+	},
+}
+
+var EnvironmentFileMoveTool = &Tool{
+	Definition: mcp.NewTool("environment_file_move",
+		mcp.WithDescription("Moves or renames a file or directory. Prefer this over reading, writing and deleting files so history records a rename."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this file is being moved."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("source",
+			mcp.Description("Path of the file or directory to move, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("destination",
+			mcp.Description("New path of the file or directory, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		source, err := request.RequireString("source")
+		if err != nil {
+			return nil, err
+		}
+		destination, err := request.RequireString("destination")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.FileMove(ctx, request.GetString("explanation", ""), source, destination); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to move file", err), nil
+		}
+
+		if err := updateRepositoryLazily(ctx, repo, env, request, fmt.Sprintf("Move %s to %s", source, destination)); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("%s moved to %s successfully and committed to container-use/ remote", source, destination)), nil
+	},
+}
+
+var EnvironmentMkdirTool = &Tool{
+	Definition: mcp.NewTool("environment_mkdir",
+		mcp.WithDescription("Creates a directory, including any missing parent directories. Empty directories are not tracked by git."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this directory is being created."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("path",
+			mcp.Description("Path of the directory to create, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		path, err := request.RequireString("path")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := env.Mkdir(ctx, request.GetString("explanation", ""), path); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to create directory", err), nil
+		}
+
+		if err := updateRepositoryLazily(ctx, repo, env, request, "Create directory "+path); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("directory %s created successfully", path)), nil
+	},
+}
+
+var EnvironmentDownloadTool = &Tool{
+	Definition: mcp.NewTool("environment_download",
+		mcp.WithDescription(`Download a file or directory from the environment to the host filesystem.
+Use this to retrieve artifacts that are not committed to git, such as built binaries, archives or coverage reports.
+Only the host directories the user allowed may be downloaded to.`),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this path is being downloaded."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("source",
+			mcp.Description("Path of the file or directory to download, absolute or relative to the workdir."),
+			mcp.Required(),
+		),
+		mcp.WithString("destination",
+			mcp.Description("Absolute path on the host where the file or directory will be written."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		source, err := request.RequireString("source")
+		if err != nil {
+			return nil, err
+		}
+		destination, err := request.RequireString("destination")
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.CheckDownloadDestination(destination); err != nil {
+			return mcp.NewToolResultErrorFromErr("invalid destination", err), nil
+		}
+
+		exported, err := env.Download(ctx, source, destination)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to download", err), nil
+		}
+
+		return mcp.NewToolResultText(fmt.Sprintf("%s downloaded successfully to %s", source, exported)), nil
+	},
+}
+
+var EnvironmentFlushTool = &Tool{
+	Definition: mcp.NewTool("environment_flush",
+		mcp.WithDescription(`Commit the file changes of an environment that are not on its branch yet.
+With the lazy propagation configured by the user, file changes are only committed once in a while, or with the next command run: flush them before asking the user to review or merge the environment.`),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why the changes are being flushed."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to flush."),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+
+		flushed, err := repo.Flush(ctx, env)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to flush the environment", err), nil
+		}
+		if !flushed {
+			return mcp.NewToolResultText("no pending changes, the environment branch is up to date"), nil
+		}
+		if len(env.SecretFindings) > 0 {
+			addNotice(ctx, "%s", secretFindingsNotice(env.SecretFindings))
+		}
+		if len(env.SkippedFiles) > 0 {
+			addNotice(ctx, "%s", skippedFilesNotice(env.SkippedFiles))
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("pending changes committed to container-use/%s", env.ID)), nil
+	},
+}
+
+var EnvironmentCheckpointTool = &Tool{
+	Definition: mcp.NewTool("environment_checkpoint",
+		mcp.WithDescription("Checkpoints an environment in its current state as a container."),
+		mcp.WithString("explanation",
+			mcp.Description("One sentence explanation for why this checkpoint is being created."),
+		),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Required(),
+		),
+		mcp.WithString("destination",
+			mcp.Description("Container image destination to checkpoint to (e.g. registry.com/user/image:tag"),
+			mcp.Required(),
+		),
+	),
+	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		repo, env, err := openEnvironment(ctx, request)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+		}
+		destination, err := request.RequireString("destination")
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint, err := env.Checkpoint(ctx, destination)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to marshal environment", err), nil
+			return mcp.NewToolResultErrorFromErr("failed to checkpoint", err), nil
 		}
-		return mcp.NewToolResultText(fmt.Sprintf("Environment %s updated successfully. Environment has been restarted, all previous commands have been lost.\n%s", env.ID, out)), nil
+		// Record the checkpoint so that environment_restore can default to it
+		if err := updateRepository(ctx, repo, env, request, "Checkpoint to "+endpoint); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Checkpoint pushed to %q. You MUST use the full content addressed (@sha256:...) reference in `docker` commands. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", endpoint)), nil
 	},
 }
 
-var EnvironmentListTool = &Tool{
-	Definition: mcp.NewTool("environment_list",
-		mcp.WithDescription("List available environments"),
+var EnvironmentSyncTool = &Tool{
+	Definition: mcp.NewTool("environment_sync",
+		mcp.WithDescription("Bring the latest commits of a branch of the source repository (the branch checked out by the user by default) into an environment that fell behind it, by merging them into the environment branch or rebasing the branch onto them. The workdir is updated with the result, keeping installed dependencies. If both sides changed the same lines, the sync stops and the conflicting files are returned: they are left in the workdir with conflict markers, to resolve with the file tools before calling environment_merge_continue."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this environment is being listed."),
+			mcp.Description("One sentence explanation for why this environment is being synced."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("The source directory of the environment."), //  This can be a local folder (e.g. file://) or a URL to a git repository (e.g. https://github.com/user/repo.git, git@github.com:user/repo.git)"),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
+			mcp.Required(),
+		),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment to sync."),
 			mcp.Required(),
 		),
+		mcp.WithString("upstream",
+			mcp.Description("Branch of the source repository to sync with. Defaults to the branch checked out in the source repository."),
+		),
+		mcp.WithString("strategy",
+			mcp.Description("merge (default) to merge the upstream commits into the environment branch, or rebase to replay the commits of the environment on top of them."),
+			mcp.Enum(string(repository.SyncMerge), string(repository.SyncRebase)),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, err := openRepository(ctx, request)
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-		envInfos, err := repo.List(ctx)
+		envID, err := request.RequireString("environment_id")
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("invalid source", err), nil
+			return nil, err
+		}
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
 		}
 
-		// Convert EnvironmentInfo slice to EnvironmentResponse slice
-		responses := make([]EnvironmentResponse, len(envInfos))
-		for i, envInfo := range envInfos {
-			responses[i] = *environmentResponseFromEnvInfo(envInfo)
+		upstream := request.GetString("upstream", "")
+		strategy := repository.SyncStrategy(request.GetString("strategy", string(repository.SyncMerge)))
+		stopProgress := startProgress(ctx, request, "environment_sync")
+		result, err := repo.Sync(ctx, dag, envID, upstream, strategy, commitMessage(ctx, request, "Sync with upstream changes"))
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to sync environment", err), nil
 		}
 
-		out, err := json.Marshal(responses)
+		out, err := json.Marshal(result)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
 		}
 		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentRunCmdTool = &Tool{
-	Definition: mcp.NewTool("environment_run_cmd",
-		mcp.WithDescription("Run a terminal command inside a NEW container within the environment."),
+var EnvironmentConflictsListTool = &Tool{
+	Definition: mcp.NewTool("environment_conflicts_list",
+		mcp.WithDescription("List the conflicting files of the sync in progress in an environment, and whether each is resolved. Read a file with environment_file_read to see its conflict markers, then write it without them, or delete it, to resolve it."),
+		mcp.WithReadOnlyHintAnnotation(true),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this command is being run."),
+			mcp.Description("One sentence explanation for why the conflicts are being listed."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Description("The ID of the environment to list the conflicts of."),
 			mcp.Required(),
 		),
-		mcp.WithString("command",
-			mcp.Description("The terminal command to execute. If empty, the environment's default command is used."),
-		),
-		mcp.WithString("shell",
-			mcp.Description("The shell that will be interpreting this command (default: sh)"),
-		),
-		mcp.WithBoolean("background",
-			mcp.Description(`Run the command in the background
-Must ALWAYS be set for long running command (e.g. http server).
-Failure to do so will result in the tool being stuck, awaiting for the command to finish.`,
-			),
-		),
-		mcp.WithBoolean("use_entrypoint",
-			mcp.Description("Use the image entrypoint, if present, by prepending it to the args."),
-		),
-		mcp.WithArray("ports",
-			mcp.Description("Ports to expose. Only works with background environments. For each port, returns the environment_internal (for use inside environments) and host_external (for use by the user) addresses."),
-			mcp.Items(map[string]any{"type": "number"}),
-		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		repo, env, err := openEnvironment(ctx, request)
+		repo, err := openRepository(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
-		}
-
-		command := request.GetString("command", "")
-		shell := request.GetString("shell", "sh")
-
-		updateRepo := func() (*mcp.CallToolResult, error) {
-			if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-				return mcp.NewToolResultErrorFromErr("failed to update repository", err), err
-			}
-			return nil, nil
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-
-		background := request.GetBool("background", false)
-		if background {
-			ports := []int{}
-			if portList, ok := request.GetArguments()["ports"].([]any); ok {
-				for _, port := range portList {
-					ports = append(ports, int(port.(float64)))
-				}
-			}
-			endpoints, runErr := env.RunBackground(ctx, command, shell, ports, request.GetBool("use_entrypoint", false))
-			// We want to update the repository even if the command failed.
-			if resp, err := updateRepo(); err != nil {
-				return resp, nil
-			}
-			if runErr != nil {
-				return mcp.NewToolResultErrorFromErr("failed to run command", runErr), nil
-			}
-
-			out, err := json.Marshal(endpoints)
-			if err != nil {
-				return nil, err
-			}
-
-			return mcp.NewToolResultText(fmt.Sprintf(`Command started in the background in NEW container. Endpoints are %s
-
-To access from the user's machine: use host_external. To access from other commands in this environment: use environment_internal.
-
-Any changes to the container workdir (%s) WILL NOT be committed to container-use/%s
-
-Background commands are unaffected by filesystem and any other kind of changes. You need to start a new command for changes to take effect.`,
-				string(out), env.Config.Workdir, env.ID)), nil
+		envID, err := request.RequireString("environment_id")
+		if err != nil {
+			return nil, err
 		}
 
-		stdout, runErr := env.Run(ctx, command, shell, request.GetBool("use_entrypoint", false))
-		// We want to update the repository even if the command failed.
-		if resp, err := updateRepo(); err != nil {
-			return resp, nil
+		status, err := repo.SyncStatus(ctx, envID)
+		if err != nil {
+			return engineFailure(ctx, "failed to list conflicts", err), nil
 		}
-		if runErr != nil {
-			return mcp.NewToolResultErrorFromErr("failed to run command", runErr), nil
+		out, err := json.Marshal(status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
 		}
-
-		return mcp.NewToolResultText(fmt.Sprintf("%s\n\nAny changes to the container workdir (%s) have been committed and pushed to container-use/ remote", stdout, env.Config.Workdir)), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileReadTool = &Tool{
-	Definition: mcp.NewTool("environment_file_read",
-		mcp.WithDescription("Read the contents of a file, specifying a line range or the entire file."),
+var EnvironmentMergeContinueTool = &Tool{
+	Definition: mcp.NewTool("environment_merge_continue",
+		mcp.WithDescription("Complete the sync in progress in an environment once its conflicts are resolved in the workdir. The resolved files are committed and the workdir is updated with the result. A rebase goes on with the next commits of the environment, which may return new conflicts to resolve. Files still having conflict markers are returned as an error."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this file is being read."),
+			mcp.Description("One sentence explanation of how the conflicts were resolved."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
-			mcp.Required(),
-		),
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to read, absolute or relative to the workdir"),
+			mcp.Description("The ID of the environment to complete the sync of."),
 			mcp.Required(),
 		),
-		mcp.WithBoolean("should_read_entire_file",
-			mcp.Description("Whether to read the entire file. Defaults to false."),
-		),
-		mcp.WithNumber("start_line_one_indexed_inclusive",
-			mcp.Description("The one-indexed line number to start reading from (inclusive)."),
-		),
-		mcp.WithNumber("end_line_one_indexed_inclusive",
-			mcp.Description("The one-indexed line number to end reading at (inclusive)."),
-		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, err := openRepository(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-
-		targetFile, err := request.RequireString("target_file")
+		envID, err := request.RequireString("environment_id")
 		if err != nil {
 			return nil, err
 		}
-		shouldReadEntireFile := request.GetBool("should_read_entire_file", false)
-		startLineOneIndexedInclusive := request.GetInt("start_line_one_indexed_inclusive", 0)
-		endLineOneIndexedInclusive := request.GetInt("end_line_one_indexed_inclusive", 0)
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
 
-		fileContents, err := env.FileRead(ctx, targetFile, shouldReadEntireFile, startLineOneIndexedInclusive, endLineOneIndexedInclusive)
+		stopProgress := startProgress(ctx, request, "environment_merge_continue")
+		result, err := repo.ContinueSync(ctx, dag, envID, commitMessage(ctx, request, "Resolve sync conflicts"))
+		stopProgress("done")
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to read file", err), nil
+			return mcp.NewToolResultErrorFromErr("failed to continue sync", err), nil
 		}
 
-		return mcp.NewToolResultText(fileContents), nil
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		if result.Commit == "" {
+			// Nothing was done, some conflicts are left unresolved
+			return mcp.NewToolResultError(string(out)), nil
+		}
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentFileListTool = &Tool{
-	Definition: mcp.NewTool("environment_file_list",
-		mcp.WithDescription("List the contents of a directory"),
+var EnvironmentRestoreTool = &Tool{
+	Definition: mcp.NewTool("environment_restore",
+		mcp.WithDescription("Restore an environment from a checkpointed image: its container is started from the image instead of being rebuilt, on top of the current files of the environment branch. Archived environments are unarchived first. Services and background processes are restarted from scratch."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this directory is being listed."),
+			mcp.Description("One sentence explanation for why this environment is being restored."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Description("The ID of the environment to restore."),
 			mcp.Required(),
 		),
-		mcp.WithString("path",
-			mcp.Description("Path of the directory to list contents of, absolute or relative to the workdir"),
-			mcp.Required(),
+		mcp.WithString("image",
+			mcp.Description("The checkpoint image reference, as returned by environment_checkpoint. Defaults to the last checkpoint of the environment."),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		_, env, err := openEnvironment(ctx, request)
+		repo, err := openRepository(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-
-		path, err := request.RequireString("path")
+		envID, err := request.RequireString("environment_id")
 		if err != nil {
 			return nil, err
 		}
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+		}
 
-		out, err := env.FileList(ctx, path)
+		image := request.GetString("image", "")
+		stopProgress := startProgress(ctx, request, "environment_restore")
+		env, err := repo.Restore(ctx, dag, envID, image, commitMessage(ctx, request, "Restore environment from checkpoint"))
+		stopProgress("done")
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to list directory", err), nil
+			return mcp.NewToolResultErrorFromErr("failed to restore environment", err), nil
 		}
 
-		return mcp.NewToolResultText(out), nil
+		out, err := marshalEnvironment(env)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to marshal environment", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Environment %s restored from %s.\n%s", env.ID, env.State.Checkpoint, out)), nil
 	},
 }
 
-var EnvironmentFileWriteTool = &Tool{
-	Definition: mcp.NewTool("environment_file_write",
-		mcp.WithDescription("Write the contents of a file."),
+var EnvironmentExportTool = &Tool{
+	Definition: mcp.NewTool("environment_export",
+		mcp.WithDescription("Package an environment into a portable tarball containing its branch and notes as a git bundle and its container as an OCI image, so it can be moved to another machine and brought back with `container-use import <path>`."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this file is being written."),
+			mcp.Description("One sentence explanation for why this environment is being exported."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
-			mcp.Required(),
-		),
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to write, absolute or relative to the workdir."),
+			mcp.Description("The ID of the environment to export."),
 			mcp.Required(),
 		),
-		mcp.WithString("contents",
-			mcp.Description("Full text content of the file you want to write."),
+		mcp.WithString("destination",
+			mcp.Description("Absolute path on the host of the tarball to write. Its extension selects the compression: .tar.gz or .tgz (gzip), .tar.zst (zstd) or .tar (none)."),
 			mcp.Required(),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		repo, env, err := openEnvironment(ctx, request)
+		repo, err := openRepository(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-
-		targetFile, err := request.RequireString("target_file")
+		envID, err := request.RequireString("environment_id")
 		if err != nil {
 			return nil, err
 		}
-		contents, err := request.RequireString("contents")
+		destination, err := request.RequireString("destination")
 		if err != nil {
 			return nil, err
 		}
-
-		if err := env.FileWrite(ctx, request.GetString("explanation", ""), targetFile, contents); err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to write file", err), nil
+		if !filepath.IsAbs(destination) {
+			return nil, fmt.Errorf("destination must be an absolute path")
+		}
+		if !repository.IsExportPath(destination) {
+			return nil, fmt.Errorf("destination must end with .tar, .tar.gz, .tgz or .tar.zst")
+		}
+		dag, err := daggerClient(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
 		}
 
-		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to update the environment", err), nil
+		stopProgress := startProgress(ctx, request, "environment_export")
+		manifest, err := repo.Export(ctx, dag, envID, destination)
+		stopProgress("done")
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to export environment", err), nil
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("file %s written successfully and committed to container-use/ remote", targetFile)), nil
+		out, err := json.Marshal(manifest)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to marshal manifest", err), nil
+		}
+		return mcp.NewToolResultText(fmt.Sprintf("Environment %s exported to %s.\n%s", envID, destination, out)), nil
 	},
 }
 
-var EnvironmentFileDeleteTool = &Tool{
-	Definition: mcp.NewTool("environment_file_delete",
-		mcp.WithDescription("Deletes a file at the specified path."),
+var EnvironmentPublishTool = &Tool{
+	Definition: mcp.NewTool("environment_publish",
+		mcp.WithDescription("Push the branch of an environment to a remote of the source repository (origin by default) and optionally open a draft GitHub pull request of it, titled after the environment and describing its history. Only publish when the user asked for the work to be shared."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this file is being deleted."),
+			mcp.Description("One sentence explanation for why this environment is being published."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+			mcp.Description("The ID of the environment to publish."),
 			mcp.Required(),
 		),
-		mcp.WithString("target_file",
-			mcp.Description("Path of the file to delete, absolute or relative to the workdir."),
-			mcp.Required(),
+		mcp.WithString("remote",
+			mcp.Description("Remote of the source repository to push to. Defaults to origin."),
+		),
+		mcp.WithString("branch",
+			mcp.Description("Branch to push the environment to. Defaults to cu-<environment_id>."),
+		),
+		mcp.WithBoolean("draft_pr",
+			mcp.Description("Open a draft pull request of the branch, if the remote is on GitHub. An open pull request of the branch is reused."),
+		),
+		mcp.WithString("base",
+			mcp.Description("Branch the pull request is opened against. Defaults to the default branch of the repository."),
+		),
+		mcp.WithString("github_token",
+			mcp.Description("Optional secret reference to the token to call the GitHub API with, e.g. env://GITHUB_TOKEN or op://vault/github/token. Defaults to GH_TOKEN, GITHUB_TOKEN or the login of the gh CLI on the host."),
 		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		repo, env, err := openEnvironment(ctx, request)
+		repo, err := openRepository(ctx, request)
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
+			return mcp.NewToolResultErrorFromErr("unable to open the repository", err), nil
 		}
-
-		targetFile, err := request.RequireString("target_file")
+		envID, err := request.RequireString("environment_id")
 		if err != nil {
 			return nil, err
 		}
-
-		if err := env.FileDelete(ctx, request.GetString("explanation", ""), targetFile); err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to delete file", err), nil
+		opts := repository.PublishOptions{
+			Remote:  request.GetString("remote", ""),
+			Branch:  request.GetString("branch", ""),
+			DraftPR: request.GetBool("draft_pr", false),
+			Base:    request.GetString("base", ""),
+		}
+		if reference := request.GetString("github_token", ""); reference != "" {
+			dag, err := daggerClient(ctx)
+			if err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to connect to the dagger engine", err), nil
+			}
+			if opts.Token, err = environment.ResolveSecret(ctx, dag, reference); err != nil {
+				return mcp.NewToolResultErrorFromErr("unable to resolve github_token", err), nil
+			}
 		}
 
-		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to update env", err), nil
+		result, err := repo.Publish(ctx, envID, opts)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to publish environment", err), nil
 		}
 
-		return mcp.NewToolResultText(fmt.Sprintf("file %s deleted successfully and committed to container-use/ remote", targetFile)), nil
+		out, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response: %w", err)
+		}
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
-var EnvironmentCheckpointTool = &Tool{
-	Definition: mcp.NewTool("environment_checkpoint",
-		mcp.WithDescription("Checkpoints an environment in its current state as a container."),
+var EnvironmentSecretsCheckTool = &Tool{
+	Definition: mcp.NewTool("environment_secrets_check",
+		mcp.WithDescription("Check that every secret configured for the environment and its services can be resolved (host variable set, file present, 1Password/Vault reachable), without revealing any value. Use this to diagnose empty or missing secrets."),
 		mcp.WithString("explanation",
-			mcp.Description("One sentence explanation for why this checkpoint is being created."),
+			mcp.Description("One sentence explanation for why the secrets are being checked."),
 		),
-		mcp.WithString("environment_id",
-			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
+		mcp.WithString("environment_source",
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
-		mcp.WithString("destination",
-			mcp.Description("Container image destination to checkpoint to (e.g. registry.com/user/image:tag"),
+		mcp.WithString("environment_id",
+			mcp.Description("The ID of the environment for this command. Must call `environment_create` first."),
 			mcp.Required(),
 		),
 	),
@@ -738,16 +3223,12 @@ var EnvironmentCheckpointTool = &Tool{
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("unable to open the environment", err), nil
 		}
-		destination, err := request.RequireString("destination")
-		if err != nil {
-			return nil, err
-		}
 
-		endpoint, err := env.Checkpoint(ctx, destination)
+		out, err := json.Marshal(env.CheckSecrets(ctx))
 		if err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to checkpoint", err), nil
+			return nil, err
 		}
-		return mcp.NewToolResultText(fmt.Sprintf("Checkpoint pushed to %q. You MUST use the full content addressed (@sha256:...) reference in `docker` commands. The entrypoint is set to `sh`, keep that in mind when giving commands to the container.", endpoint)), nil
+		return mcp.NewToolResultText(string(out)), nil
 	},
 }
 
@@ -758,7 +3239,7 @@ var EnvironmentAddServiceTool = &Tool{
 			mcp.Description("One sentence explanation for why this service is being added."),
 		),
 		mcp.WithString("environment_source",
-			mcp.Description("Absolute path to the source git repository for the environment."),
+			mcp.Description("Absolute path to the source git repository for the environment, or the https or SSH URL of its remote."),
 			mcp.Required(),
 		),
 		mcp.WithString("environment_id",
@@ -778,7 +3259,7 @@ var EnvironmentAddServiceTool = &Tool{
 		),
 		mcp.WithArray("ports",
 			mcp.Description("Ports to expose. For each port, returns the container_internal (for use by environments) and host_external (for use by the user) address."),
-			mcp.Items(map[string]any{"type": "number"}),
+			mcp.Items(portItems),
 		),
 		mcp.WithArray("envs",
 			mcp.Description("The environment variables to set (e.g. `[\"FOO=bar\", \"BAZ=qux\"]`)."),
@@ -793,9 +3274,20 @@ Supported schemas are:
 - file://PATH: local file path
 - env://NAME: environment variable
 - op://<vault-name>/<item-name>/[section-name/]<field-name>: 1Password secret
+- vault://<mount>/<path>#<key>: HashiCorp Vault secret
+- gcp-sm://projects/<project>/secrets/<name>[/versions/<version>]: Google Cloud Secret Manager secret
+- azure-kv://<vault>/<secret>[/<version>]: Azure Key Vault secret
 `),
 			mcp.Items(map[string]any{"type": "string"}),
 		),
+		mcp.WithArray("depends_on",
+			mcp.Description("Names of existing services this service depends on. They are started first and are reachable from this service by name and alias."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
+		mcp.WithArray("aliases",
+			mcp.Description("Additional hostnames the service is reachable at from the environment and from services depending on it (e.g. `[\"db\"]`)."),
+			mcp.Items(map[string]any{"type": "string"}),
+		),
 	),
 	Handler: func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		repo, env, err := openEnvironment(ctx, request)
@@ -811,15 +3303,29 @@ Supported schemas are:
 			return nil, err
 		}
 		command := request.GetString("command", "")
-		ports := []int{}
-		if portList, ok := request.GetArguments()["ports"].([]any); ok {
-			for _, port := range portList {
-				ports = append(ports, int(port.(float64)))
-			}
+		ports, err := optionalPorts(request, "ports")
+		if err != nil {
+			return nil, err
+		}
+		envs, err := optionalStringSlice(request, "envs")
+		if err != nil {
+			return nil, err
+		}
+		secrets, err := optionalStringSlice(request, "secrets")
+		if err != nil {
+			return nil, err
+		}
+		dependsOn, err := optionalStringSlice(request, "depends_on")
+		if err != nil {
+			return nil, err
+		}
+		aliases, err := optionalStringSlice(request, "aliases")
+		if err != nil {
+			return nil, err
+		}
+		if denied, err := checkCommandsPolicy(ctx, request, repo, env.ID, []string{command}); denied != nil || err != nil {
+			return denied, err
 		}
-
-		envs := request.GetStringSlice("envs", []string{})
-		secrets := request.GetStringSlice("secrets", []string{})
 
 		service, err := env.AddService(ctx, request.GetString("explanation", ""), &environment.ServiceConfig{
 			Name:         serviceName,
@@ -828,13 +3334,15 @@ Supported schemas are:
 			ExposedPorts: ports,
 			Env:          envs,
 			Secrets:      secrets,
+			DependsOn:    dependsOn,
+			Aliases:      aliases,
 		})
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("failed to add service", err), nil
 		}
 
-		if err := repo.Update(ctx, env, request.GetString("explanation", "")); err != nil {
-			return mcp.NewToolResultErrorFromErr("failed to update env", err), nil
+		if err := updateRepository(ctx, repo, env, request, "Add service "+serviceName); err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to update repository", err), nil
 		}
 
 		output, err := json.Marshal(service)
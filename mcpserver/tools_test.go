@@ -0,0 +1,98 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// placeholderArgs fills every required argument of tool with a value of the right type.
+func placeholderArgs(tool mcp.Tool) map[string]any {
+	args := map[string]any{}
+	for _, name := range tool.InputSchema.Required {
+		property, _ := tool.InputSchema.Properties[name].(map[string]any)
+		switch property["type"] {
+		case "array":
+			args[name] = []any{}
+		case "number", "integer":
+			args[name] = 1
+		case "boolean":
+			args[name] = false
+		default:
+			args[name] = "placeholder"
+		}
+	}
+	return args
+}
+
+func TestToolHandlerErrors(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tool := range Tools() {
+		t.Run(tool.Definition.Name, func(t *testing.T) {
+			t.Run("missing_arguments", func(t *testing.T) {
+				_, hasSessionArguments := tool.Definition.InputSchema.Properties["environment_source"]
+				if len(tool.Definition.InputSchema.Required) == 0 && !hasSessionArguments {
+					t.Skip("no required arguments")
+				}
+				request := requestWithArgs(map[string]any{})
+				request.Params.Name = tool.Definition.Name
+
+				result, err := tool.Handler(ctx, request)
+				if err != nil {
+					assert.ErrorContains(t, err, "required", "missing arguments must be reported as such")
+					return
+				}
+				require.NotNil(t, result)
+				assert.True(t, result.IsError, "missing arguments must be reported as an error")
+			})
+
+			t.Run("invalid_repository", func(t *testing.T) {
+				args := placeholderArgs(tool.Definition)
+				if _, ok := args["environment_source"]; !ok {
+					t.Skip("tool does not open a repository")
+				}
+				args["environment_source"] = t.TempDir()
+				request := requestWithArgs(args)
+				request.Params.Name = tool.Definition.Name
+
+				result, err := tool.Handler(ctx, request)
+				require.NoError(t, err, "operation failures must be returned as tool errors")
+				require.NotNil(t, result)
+				assert.True(t, result.IsError)
+				require.NotEmpty(t, result.Content)
+				text, ok := result.Content[0].(mcp.TextContent)
+				require.True(t, ok)
+				assert.NotEmpty(t, text.Text)
+			})
+		})
+	}
+}
+
+func TestWorkdirRelative(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "/workdir/src/main.go", want: "src/main.go"},
+		{name: "/workdir", want: "."},
+		{name: "src/../main.go", want: "main.go"},
+		{name: ".", want: "."},
+		{name: "/workdir2/main.go", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "../main.go", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := workdirRelative("/workdir", tt.name)
+		if tt.wantErr {
+			assert.Error(t, err, tt.name)
+			continue
+		}
+		assert.NoError(t, err, tt.name)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
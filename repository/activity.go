@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActivityCommit is a commit of an environment, along with what the agent did to produce it.
+type ActivityCommit struct {
+	EnvironmentID string    `json:"environment_id"`
+	Commit        string    `json:"commit"`
+	Time          time.Time `json:"time"`
+	// Message is the explanation given by the agent.
+	Message string `json:"message"`
+	// Commands are the commands run by the agent, as recorded in the log notes.
+	Commands []string `json:"commands,omitempty"`
+	// Files are the files changed by the commit, along with their git status letter (A, M, D, R...).
+	Files []FileChange `json:"files,omitempty"`
+}
+
+// FileChange is a file changed by a commit.
+type FileChange struct {
+	Status string `json:"status"`
+	Path   string `json:"path"`
+}
+
+// Activity returns the most recent commits of all environments that are not on the current branch
+// of the source repository, newest first.
+func (r *Repository) Activity(ctx context.Context, limit int) ([]*ActivityCommit, error) {
+	args := []string{
+		"log", "--remotes=" + containerUseRemote, "--source",
+		"--notes=" + gitNotesLogRef,
+		"--format=%x1e%H%x1f%S%x1f%cI%x1f%s%x1f%N%x1f",
+		"--name-status", "--no-renames",
+		"-n", fmt.Sprint(limit),
+	}
+	output, err := RunGitCommand(ctx, r.userRepoPath, append(args, "--not", "HEAD")...)
+	if err != nil {
+		// HEAD doesn't exist yet in a repository without commits
+		output, err = RunGitCommand(ctx, r.userRepoPath, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parseActivity(output), nil
+}
+
+// parseActivity parses the output of the git log of Activity.
+func parseActivity(output string) []*ActivityCommit {
+	commits := []*ActivityCommit{}
+	for record := range strings.SplitSeq(output, "\x1e") {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		source := strings.TrimPrefix(fields[1], "refs/remotes/")
+		id, ok := strings.CutPrefix(source, containerUseRemote+"/")
+		if !ok {
+			continue
+		}
+		commit := &ActivityCommit{
+			EnvironmentID: id,
+			Commit:        fields[0],
+			Message:       fields[3],
+			Commands:      []string{},
+			Files:         []FileChange{},
+		}
+		commit.Time, _ = time.Parse(time.RFC3339, fields[2])
+
+		for line := range strings.Lines(fields[4]) {
+			if command, ok := strings.CutPrefix(strings.TrimRight(line, "\n"), "$ "); ok {
+				commit.Commands = append(commit.Commands, command)
+			}
+		}
+		for line := range strings.Lines(fields[5]) {
+			status, path, ok := strings.Cut(strings.TrimSpace(line), "\t")
+			if !ok {
+				continue
+			}
+			commit.Files = append(commit.Files, FileChange{Status: status, Path: path})
+		}
+		commits = append(commits, commit)
+	}
+	return commits
+}
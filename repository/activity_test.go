@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseActivity(t *testing.T) {
+	output := "\x1e" + "abc123\x1frefs/remotes/container-use/fancy-mallard\x1f2025-07-01T12:00:00+02:00\x1fAdd login form\x1f" +
+		"$ npm install\n$ npm test\nexit 1\nFAIL src/login.test.ts\nstderr: oops\n\x1f\n\nA\tsrc/login.tsx\nM\tpackage.json\n" +
+		"\x1e" + "def456\x1frefs/remotes/container-use/quiet-otter\x1f2025-07-01T11:00:00Z\x1fCreate environment\x1f\x1f\n" +
+		// Not an environment
+		"\x1e" + "fed789\x1frefs/remotes/origin/main\x1f2025-07-01T10:00:00Z\x1fUpstream\x1f\x1f\n"
+
+	commits := parseActivity(output)
+	require.Len(t, commits, 2)
+
+	assert.Equal(t, "fancy-mallard", commits[0].EnvironmentID)
+	assert.Equal(t, "abc123", commits[0].Commit)
+	assert.Equal(t, "Add login form", commits[0].Message)
+	assert.Equal(t, 10, commits[0].Time.UTC().Hour())
+	assert.Equal(t, []string{"npm install", "npm test"}, commits[0].Commands)
+	assert.Equal(t, []FileChange{{Status: "A", Path: "src/login.tsx"}, {Status: "M", Path: "package.json"}}, commits[0].Files)
+
+	assert.Equal(t, "quiet-otter", commits[1].EnvironmentID)
+	assert.Empty(t, commits[1].Commands)
+	assert.Empty(t, commits[1].Files)
+}
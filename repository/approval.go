@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// approvalPollInterval is how often WaitForApproval checks whether a decision was made.
+var approvalPollInterval = time.Second
+
+// ApprovalStatus is the state of an Approval.
+type ApprovalStatus string
+
+const (
+	// ApprovalPending is a request waiting for the user to decide.
+	ApprovalPending ApprovalStatus = "pending"
+	// ApprovalApproved lets the command run once.
+	ApprovalApproved ApprovalStatus = "approved"
+	// ApprovalDenied refuses to run the command.
+	ApprovalDenied ApprovalStatus = "denied"
+)
+
+// Approval is a command of an environment that the command policy only lets run once the user approved it:
+// either a request parked by a tool call until the user decides, or a command the user approved ahead of time.
+type Approval struct {
+	ID            string `json:"id"`
+	EnvironmentID string `json:"environment_id"`
+	Command       string `json:"command"`
+	// Pattern is the require_approval pattern of the policy that the command matched.
+	Pattern     string         `json:"pattern,omitempty"`
+	Status      ApprovalStatus `json:"status"`
+	Reason      string         `json:"reason,omitempty"`
+	RequestedAt time.Time      `json:"requested_at"`
+	DecidedAt   *time.Time     `json:"decided_at,omitempty"`
+}
+
+// ErrApprovalNotFound is returned for approvals that don't exist, or no longer do because their tool call
+// gave up on them.
+var ErrApprovalNotFound = errors.New("approval not found")
+
+func (r *Repository) approvalDir() (string, error) {
+	return r.dataPath("approvals")
+}
+
+func newApprovalID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// normalizeCommand makes commands that only differ in spacing equal.
+func normalizeCommand(command string) string {
+	return strings.Join(strings.Fields(command), " ")
+}
+
+func (r *Repository) saveApproval(approval *Approval) error {
+	dir, err := r.approvalDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(approval, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write atomically: other processes poll the file
+	tmp := filepath.Join(dir, "."+approval.ID+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, approval.ID+".json"))
+}
+
+func (r *Repository) removeApproval(requestID string) error {
+	dir, err := r.approvalDir()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, requestID+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Approval returns the approval requestID.
+func (r *Repository) Approval(requestID string) (*Approval, error) {
+	dir, err := r.approvalDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(requestID)+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrApprovalNotFound, requestID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	approval := &Approval{}
+	if err := json.Unmarshal(data, approval); err != nil {
+		return nil, fmt.Errorf("invalid approval %s: %w", requestID, err)
+	}
+	return approval, nil
+}
+
+// Approvals returns the approvals of every environment of the repository, oldest first.
+func (r *Repository) Approvals() ([]*Approval, error) {
+	dir, err := r.approvalDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []*Approval{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	approvals := []*Approval{}
+	for _, entry := range entries {
+		requestID, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		approval, err := r.Approval(requestID)
+		if errors.Is(err, ErrApprovalNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	sort.Slice(approvals, func(i, j int) bool {
+		return approvals[i].RequestedAt.Before(approvals[j].RequestedAt)
+	})
+	return approvals, nil
+}
+
+// ApproveCommand lets command run once in environment id even though the command policy requires approval
+// for it. The command must be given exactly as the agent runs it, up to spacing.
+func (r *Repository) ApproveCommand(ctx context.Context, id, command string) (*Approval, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	approval := &Approval{
+		ID:            newApprovalID(),
+		EnvironmentID: id,
+		Command:       normalizeCommand(command),
+		Status:        ApprovalApproved,
+		RequestedAt:   now,
+		DecidedAt:     &now,
+	}
+	return approval, r.saveApproval(approval)
+}
+
+// ConsumeApproval reports whether command was approved for environment id ahead of time with
+// ApproveCommand and, if so, uses up the approval.
+func (r *Repository) ConsumeApproval(id, command string) (bool, error) {
+	approvals, err := r.Approvals()
+	if err != nil {
+		return false, err
+	}
+	command = normalizeCommand(command)
+	for _, approval := range approvals {
+		if approval.EnvironmentID == id && approval.Command == command && approval.Status == ApprovalApproved {
+			return true, r.removeApproval(approval.ID)
+		}
+	}
+	return false, nil
+}
+
+// RequestApproval parks command, which matched the require_approval pattern of the command policy, until
+// the user decides on it with DecideApproval. Wait for the decision with WaitForApproval.
+func (r *Repository) RequestApproval(id, command, pattern string) (*Approval, error) {
+	approval := &Approval{
+		ID:            newApprovalID(),
+		EnvironmentID: id,
+		Command:       normalizeCommand(command),
+		Pattern:       pattern,
+		Status:        ApprovalPending,
+		RequestedAt:   time.Now(),
+	}
+	return approval, r.saveApproval(approval)
+}
+
+// DecideApproval approves or denies the pending request requestID, giving the agent the reason if any.
+func (r *Repository) DecideApproval(requestID string, approve bool, reason string) (*Approval, error) {
+	approval, err := r.Approval(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if approval.Status != ApprovalPending {
+		return nil, fmt.Errorf("approval %s was already %s", requestID, approval.Status)
+	}
+	now := time.Now()
+	approval.Status = ApprovalDenied
+	if approve {
+		approval.Status = ApprovalApproved
+	}
+	approval.Reason = reason
+	approval.DecidedAt = &now
+	return approval, r.saveApproval(approval)
+}
+
+// WaitForApproval waits up to timeout for the user to decide on the request requestID, and returns the
+// decision. The request is removed once decided, or when giving up on it, so that approvals only apply
+// to the tool call that requested them.
+func (r *Repository) WaitForApproval(ctx context.Context, requestID string, timeout time.Duration) (*Approval, error) {
+	defer r.removeApproval(requestID)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+	for {
+		approval, err := r.Approval(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if approval.Status != ApprovalPending {
+			return approval, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("no decision on approval %s after %s", requestID, timeout)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// deleteApprovals removes the approvals of environment id.
+func (r *Repository) deleteApprovals(id string) error {
+	approvals, err := r.Approvals()
+	if err != nil {
+		return err
+	}
+	for _, approval := range approvals {
+		if approval.EnvironmentID != id {
+			continue
+		}
+		if err := r.removeApproval(approval.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryApprovals(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	repo, err := OpenWithBasePath(ctx, tempDir, t.TempDir())
+	require.NoError(t, err)
+
+	approved, err := repo.ConsumeApproval("fancy-mallard", "./deploy.sh staging")
+	require.NoError(t, err)
+	assert.False(t, approved)
+	_, err = repo.ApproveCommand(ctx, "unknown-env", "./deploy.sh")
+	assert.Error(t, err)
+
+	// Approved ahead of time, as ApproveCommand does for existing environments
+	now := time.Now()
+	require.NoError(t, repo.saveApproval(&Approval{
+		ID:            newApprovalID(),
+		EnvironmentID: "fancy-mallard",
+		Command:       normalizeCommand("  ./deploy.sh   staging "),
+		Status:        ApprovalApproved,
+		RequestedAt:   now,
+		DecidedAt:     &now,
+	}))
+	approved, err = repo.ConsumeApproval("fancy-mallard", "./deploy.sh staging")
+	require.NoError(t, err)
+	assert.True(t, approved)
+	approved, err = repo.ConsumeApproval("fancy-mallard", "./deploy.sh staging")
+	require.NoError(t, err)
+	assert.False(t, approved, "approvals are used up")
+
+	pending, err := repo.RequestApproval("fancy-mallard", "make release", "make release")
+	require.NoError(t, err)
+	approvals, err := repo.Approvals()
+	require.NoError(t, err)
+	require.Len(t, approvals, 1)
+	assert.Equal(t, ApprovalPending, approvals[0].Status)
+
+	_, err = repo.WaitForApproval(ctx, pending.ID, 10*time.Millisecond)
+	assert.ErrorContains(t, err, "no decision")
+	_, err = repo.DecideApproval(pending.ID, true, "")
+	assert.ErrorIs(t, err, ErrApprovalNotFound, "requests are withdrawn once their tool call gave up")
+}
+
+func TestRepositoryWaitForApproval(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	repo, err := OpenWithBasePath(ctx, tempDir, t.TempDir())
+	require.NoError(t, err)
+
+	defer func(interval time.Duration) { approvalPollInterval = interval }(approvalPollInterval)
+	approvalPollInterval = 10 * time.Millisecond
+
+	for _, approve := range []bool{true, false} {
+		pending, err := repo.RequestApproval("fancy-mallard", "./deploy.sh prod", "./deploy.sh")
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			repo.DecideApproval(pending.ID, approve, "not today")
+		}()
+
+		decision, err := repo.WaitForApproval(ctx, pending.ID, time.Minute)
+		require.NoError(t, err)
+		if approve {
+			assert.Equal(t, ApprovalApproved, decision.Status)
+		} else {
+			assert.Equal(t, ApprovalDenied, decision.Status)
+			assert.Equal(t, "not today", decision.Reason)
+		}
+
+		_, err = repo.DecideApproval(pending.ID, approve, "")
+		assert.ErrorIs(t, err, ErrApprovalNotFound)
+	}
+}
@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// ArchiveInfo describes an archived environment.
+type ArchiveInfo struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title,omitempty"`
+	ArchivedAt time.Time `json:"archived_at"`
+
+	// Checkpoint is the container image the environment was checkpointed to before being archived, if any.
+	Checkpoint string `json:"checkpoint,omitempty"`
+
+	// Bundle is the path of the git bundle holding the environment branch and notes.
+	Bundle string `json:"-"`
+}
+
+// environmentNotesRefs are the notes refs holding data about the commits of environments.
+var environmentNotesRefs = []string{gitNotesLogRef, gitNotesStateRef, gitNotesAuditRef}
+
+// archiveNotesRef returns the temporary notes ref used to carry the notes of ref for environment id in a bundle.
+func archiveNotesRef(id, ref string) string {
+	return fmt.Sprintf("refs/notes/archive/%s/%s", id, ref)
+}
+
+// dataPath returns where data of the given kind (e.g. "archives") is stored for this repository.
+func (r *Repository) dataPath(kind string) (string, error) {
+	reposPath, err := homedir.Expand(r.getRepoPath())
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(reposPath, r.forkRepoPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(r.forkRepoPath)
+	}
+	return homedir.Expand(filepath.Join(r.basePath, kind, rel))
+}
+
+// archivePath returns where archives of this repository are stored.
+func (r *Repository) archivePath() (string, error) {
+	return r.dataPath("archives")
+}
+
+func (r *Repository) archiveFiles(id string) (bundle, metadata string, err error) {
+	dir, err := r.archivePath()
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(dir, id+".bundle"), filepath.Join(dir, id+".json"), nil
+}
+
+// Archive moves an environment to cold storage.
+// The environment branch and its notes are saved to a git bundle, then its worktree and branch are removed.
+// checkpoint optionally records a container image the environment was checkpointed to.
+// The environment can be brought back with Unarchive.
+func (r *Repository) Archive(ctx context.Context, id, checkpoint string) (*ArchiveInfo, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, metadata, err := r.archiveFiles(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(bundle); err == nil {
+		return nil, fmt.Errorf("environment %q is already archived", id)
+	}
+	if err := os.MkdirAll(filepath.Dir(bundle), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := r.createBundle(ctx, id, bundle); err != nil {
+		return nil, err
+	}
+
+	info := &ArchiveInfo{
+		ID:         id,
+		Title:      envInfo.State.Title,
+		ArchivedAt: time.Now(),
+		Checkpoint: checkpoint,
+		Bundle:     bundle,
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(metadata, data, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := r.Delete(ctx, id); err != nil {
+		return nil, fmt.Errorf("environment archived to %s but could not be removed: %w", bundle, err)
+	}
+
+	return info, nil
+}
+
+// Unarchive restores an environment archived with Archive, along with its history and notes.
+func (r *Repository) Unarchive(ctx context.Context, id string) (*ArchiveInfo, error) {
+	info, err := r.archiveInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.exists(ctx, id); err == nil {
+		return nil, fmt.Errorf("environment %q already exists", id)
+	}
+
+	if err := r.restoreBundle(ctx, id, info.Bundle); err != nil {
+		return nil, fmt.Errorf("failed to restore archive of %q: %w", id, err)
+	}
+
+	bundle, metadata, err := r.archiveFiles(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := errors.Join(os.Remove(bundle), os.Remove(metadata)); err != nil {
+		slog.Warn("Failed to remove archive", "id", id, "err", err)
+	}
+
+	return info, nil
+}
+
+// createBundle saves the branch of environment id and the notes attached to its commits to a git bundle.
+func (r *Repository) createBundle(ctx context.Context, id, bundle string) error {
+	commits, err := r.managedGit(ctx, r.forkRepoPath, "rev-list", id)
+	if err != nil {
+		return err
+	}
+	refs := []string{"refs/heads/" + id}
+	for _, ref := range environmentNotesRefs {
+		copied, err := r.copyNotes(ctx, ref, archiveNotesRef(id, ref), strings.Fields(commits))
+		if err != nil {
+			return err
+		}
+		if copied {
+			refs = append(refs, archiveNotesRef(id, ref))
+		}
+	}
+	defer r.deleteArchiveNotesRefs(ctx, id)
+
+	_, err = r.managedGit(ctx, r.forkRepoPath, append([]string{"bundle", "create", bundle}, refs...)...)
+	return err
+}
+
+// restoreBundle brings back environment id from a bundle made by createBundle: its branch, notes and worktree.
+func (r *Repository) restoreBundle(ctx context.Context, id, bundle string) error {
+	ctx, unlock, err := r.lockRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := r.managedGit(ctx, r.forkRepoPath, "bundle", "verify", bundle); err != nil {
+		return fmt.Errorf("bundle is corrupted: %w", err)
+	}
+
+	heads, err := r.managedGit(ctx, r.forkRepoPath, "bundle", "list-heads", bundle)
+	if err != nil {
+		return err
+	}
+	refspecs := []string{}
+	for line := range strings.SplitSeq(strings.TrimSpace(heads), "\n") {
+		if _, ref, ok := strings.Cut(line, " "); ok {
+			refspecs = append(refspecs, ref+":"+ref)
+		}
+	}
+	if _, err := r.managedGit(ctx, r.forkRepoPath, append([]string{"fetch", bundle}, refspecs...)...); err != nil {
+		return err
+	}
+	defer r.deleteArchiveNotesRefs(ctx, id)
+
+	for _, ref := range environmentNotesRefs {
+		if _, err := r.copyNotes(ctx, archiveNotesRef(id, ref), ref, nil); err != nil {
+			return err
+		}
+	}
+
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return err
+	}
+	if _, err := r.managedGit(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id); err != nil {
+		return err
+	}
+
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id); err != nil {
+		return err
+	}
+	for _, ref := range []string{gitNotesLogRef, gitNotesStateRef} {
+		if err := r.propagateGitNotes(ctx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Archives lists the archived environments of the repository, most recently archived first.
+func (r *Repository) Archives() ([]*ArchiveInfo, error) {
+	dir, err := r.archivePath()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	archives := []*ArchiveInfo{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := r.archiveInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, info)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ArchivedAt.After(archives[j].ArchivedAt)
+	})
+	return archives, nil
+}
+
+func (r *Repository) archiveInfo(id string) (*ArchiveInfo, error) {
+	bundle, metadata, err := r.archiveFiles(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no archive found for environment %q", id)
+		}
+		return nil, err
+	}
+	info := &ArchiveInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("failed to read archive of %q: %w", id, err)
+	}
+	info.Bundle = bundle
+	return info, nil
+}
+
+// copyNotes copies the notes of src to dst in the fork repository.
+// If commits is not nil, only notes attached to those commits are copied.
+// It reports whether any note was copied.
+func (r *Repository) copyNotes(ctx context.Context, src, dst string, commits []string) (bool, error) {
+	list, err := r.managedGit(ctx, r.forkRepoPath, "notes", "--ref", src, "list")
+	if err != nil {
+		// The notes ref doesn't exist yet: nothing to copy
+		return false, nil
+	}
+
+	var wanted map[string]bool
+	if commits != nil {
+		wanted = map[string]bool{}
+		for _, commit := range commits {
+			wanted[commit] = true
+		}
+	}
+
+	copied := false
+	for line := range strings.SplitSeq(strings.TrimSpace(list), "\n") {
+		note, commit, ok := strings.Cut(line, " ")
+		if !ok || (wanted != nil && !wanted[commit]) {
+			continue
+		}
+		if _, err := r.managedGit(ctx, r.forkRepoPath, "notes", "--ref", dst, "add", "-f", "-C", note, commit); err != nil {
+			return false, err
+		}
+		copied = true
+	}
+	return copied, nil
+}
+
+func (r *Repository) deleteArchiveNotesRefs(ctx context.Context, id string) {
+	refs := []string{}
+	for _, ref := range environmentNotesRefs {
+		refs = append(refs, archiveNotesRef(id, ref))
+	}
+	r.deleteRefs(ctx, refs...)
+}
+
+func (r *Repository) deleteRefs(ctx context.Context, refs ...string) {
+	for _, ref := range refs {
+		if _, err := r.managedGit(ctx, r.forkRepoPath, "update-ref", "-d", ref); err != nil {
+			slog.Warn("Failed to delete ref", "ref", ref, "err", err)
+		}
+	}
+}
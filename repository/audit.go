@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditStatus is the outcome of an audited tool call.
+type AuditStatus string
+
+const (
+	// AuditOK is a tool call that succeeded.
+	AuditOK AuditStatus = "ok"
+	// AuditError is a tool call that reported an error in its result, e.g. a command that failed.
+	AuditError AuditStatus = "error"
+	// AuditFailed is a tool call rejected before doing anything, e.g. for invalid arguments.
+	AuditFailed AuditStatus = "failed"
+)
+
+// AuditEntry records a tool call made on an environment.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	Tool string    `json:"tool"`
+	// RunID identifies the tool call in the logs of the server.
+	RunID string `json:"run_id,omitempty"`
+	// Client is the name the MCP client reported, and Session its MCP session.
+	Client    string         `json:"client,omitempty"`
+	Session   string         `json:"session,omitempty"`
+	Arguments map[string]any `json:"arguments,omitempty"`
+	Duration  time.Duration  `json:"duration_ns"`
+	Status    AuditStatus    `json:"status"`
+	Error     string         `json:"error,omitempty"`
+	// Commit is the commit of the environment the entry is attached to, set when reading the audit log.
+	Commit string `json:"commit,omitempty"`
+}
+
+// serverAuditFile is the audit log of the changes made to a server rather than to an environment, e.g.
+// reloads of its configuration, in the data directory of container-use.
+const serverAuditFile = "audit.jsonl"
+
+// auditMu serializes the writes of the audit notes of this process: git fails to update a notes ref
+// that is being updated concurrently.
+var auditMu sync.Mutex
+
+// RecordAudit appends entry to the audit log of environment id. Entries are one JSON object per line in
+// git notes on the latest commit of the environment, under their own ref so that they don't clutter
+// the history.
+func (r *Repository) RecordAudit(ctx context.Context, id string, entry *AuditEntry) error {
+	if err := r.exists(ctx, id); err != nil {
+		return err
+	}
+	entry.Commit = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	_, err = r.managedGit(ctx, r.forkRepoPath, "notes", "--ref", gitNotesAuditRef, "append", "-m", string(data), "refs/heads/"+id)
+	return err
+}
+
+// RecordServerAudit appends entry to the audit log of the servers sharing basePath, one JSON object per line.
+func RecordServerAudit(basePath string, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return err
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(basePath, serverAuditFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Audit returns the audit log of environment id, oldest first. It covers the whole history of the
+// environment, including commits that were merged already.
+func (r *Repository) Audit(ctx context.Context, id string) ([]*AuditEntry, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	output, err := r.managedGit(ctx, r.forkRepoPath, "log", "--reverse",
+		"--notes="+gitNotesAuditRef,
+		"--format=%x1e%H%x1f%N",
+		"refs/heads/"+id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseAudit(output)
+}
+
+// parseAudit parses the output of the git log of Audit.
+func parseAudit(output string) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	for record := range strings.SplitSeq(output, "\x1e") {
+		commit, notes, ok := strings.Cut(record, "\x1f")
+		if !ok {
+			continue
+		}
+		for line := range strings.Lines(notes) {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			entry := &AuditEntry{}
+			if err := json.Unmarshal([]byte(line), entry); err != nil {
+				return nil, fmt.Errorf("invalid audit entry on commit %s: %w", shortCommit(commit), err)
+			}
+			entry.Commit = commit
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
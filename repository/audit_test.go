@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryAudit(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "audited-env")
+	require.NoError(t, err)
+
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.RecordAudit(ctx, "audited-env", &AuditEntry{
+		Time:      start,
+		Tool:      "environment_file_write",
+		Arguments: map[string]any{"target_file": "main.go"},
+		Duration:  time.Second,
+		Status:    AuditOK,
+	}))
+	writeFile(t, worktree, "main.go", "package main")
+	_, err = RunGitCommand(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "commit", "-m", "Write main.go")
+	require.NoError(t, err)
+	for _, tool := range []string{"environment_run_cmd", "environment_file_read"} {
+		require.NoError(t, repo.RecordAudit(ctx, "audited-env", &AuditEntry{
+			Time:   start.Add(time.Minute),
+			Tool:   tool,
+			Status: AuditError,
+			Error:  "exit code 1",
+		}))
+	}
+
+	entries, err := repo.Audit(ctx, "audited-env")
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "environment_file_write", entries[0].Tool)
+	assert.Equal(t, "main.go", entries[0].Arguments["target_file"])
+	assert.Equal(t, time.Second, entries[0].Duration)
+	assert.Equal(t, []string{"environment_run_cmd", "environment_file_read"}, []string{entries[1].Tool, entries[2].Tool})
+	assert.NotEqual(t, entries[0].Commit, entries[1].Commit)
+	assert.Equal(t, entries[1].Commit, entries[2].Commit)
+
+	assert.Error(t, repo.RecordAudit(ctx, "unknown-env", &AuditEntry{Tool: "environment_open"}))
+}
+
+func TestRecordServerAudit(t *testing.T) {
+	basePath := filepath.Join(t.TempDir(), "container-use")
+	for _, setting := range []string{"tools.read_only", "mounts.allow"} {
+		require.NoError(t, RecordServerAudit(basePath, &AuditEntry{
+			Tool:      "config_reload",
+			Arguments: map[string]any{"setting": setting},
+			Status:    AuditOK,
+		}))
+	}
+
+	data, err := os.ReadFile(filepath.Join(basePath, serverAuditFile))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	entry := &AuditEntry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), entry))
+	assert.Equal(t, "mounts.allow", entry.Arguments["setting"])
+}
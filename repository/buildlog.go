@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// maxBuildLogs is the number of build logs kept per environment.
+const maxBuildLogs = 20
+
+const buildLogTimeFormat = "20060102T150405.000000000Z"
+
+// BuildLogInfo describes a build log saved with SaveBuildLog.
+type BuildLogInfo struct {
+	StartedAt time.Time `json:"started_at"`
+	Failed    bool      `json:"failed"`
+	Path      string    `json:"path"`
+}
+
+func (r *Repository) buildLogPath(id string) (string, error) {
+	dir, err := r.dataPath("build-logs")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id), nil
+}
+
+// SaveBuildLog stores the setup output of a build of environment id, so it can be read after the fact
+// with BuildLogs and ReadBuildLog. Only the most recent logs are kept.
+func (r *Repository) SaveBuildLog(id string, log *environment.BuildLog) (*BuildLogInfo, error) {
+	dir, err := r.buildLogPath(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	status := "ok"
+	if log.Failed {
+		status = "failed"
+	}
+	info := &BuildLogInfo{
+		StartedAt: log.StartedAt,
+		Failed:    log.Failed,
+		Path:      filepath.Join(dir, fmt.Sprintf("%s-%s.log", log.StartedAt.UTC().Format(buildLogTimeFormat), status)),
+	}
+	if err := os.WriteFile(info.Path, []byte(log.Output), 0644); err != nil {
+		return nil, err
+	}
+
+	logs, err := r.BuildLogs(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range logs[min(len(logs), maxBuildLogs):] {
+		if err := os.Remove(old.Path); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// saveLastBuild saves the log of the last build of env, if it wasn't saved already.
+func (r *Repository) saveLastBuild(env *environment.Environment) error {
+	if env.LastBuild == nil {
+		return nil
+	}
+	if _, err := r.SaveBuildLog(env.ID, env.LastBuild); err != nil {
+		return err
+	}
+	env.LastBuild = nil
+	return nil
+}
+
+// SaveBuildError saves the build log carried by err, if any, and points to it in the returned error.
+func (r *Repository) SaveBuildError(id string, err error) error {
+	var buildErr *environment.BuildError
+	if !errors.As(err, &buildErr) {
+		return err
+	}
+	if _, saveErr := r.SaveBuildLog(id, buildErr.Log); saveErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to save build log: %w", saveErr))
+	}
+	return fmt.Errorf("%w\nThe full build log is available with `container-use build-log %s`", err, id)
+}
+
+// BuildLogs lists the saved build logs of environment id, most recent first.
+func (r *Repository) BuildLogs(id string) ([]*BuildLogInfo, error) {
+	dir, err := r.buildLogPath(id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	logs := []*BuildLogInfo{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".log")
+		if !ok {
+			continue
+		}
+		timestamp, status, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		startedAt, err := time.Parse(buildLogTimeFormat, timestamp)
+		if err != nil {
+			continue
+		}
+		logs = append(logs, &BuildLogInfo{
+			StartedAt: startedAt,
+			Failed:    status == "failed",
+			Path:      filepath.Join(dir, entry.Name()),
+		})
+	}
+
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].StartedAt.After(logs[j].StartedAt)
+	})
+	return logs, nil
+}
+
+// ReadBuildLog returns a saved build log of environment id: the most recent one if n is 0,
+// the one before it if n is 1, and so on.
+func (r *Repository) ReadBuildLog(id string, n int) (*BuildLogInfo, string, error) {
+	logs, err := r.BuildLogs(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(logs) == 0 {
+		return nil, "", fmt.Errorf("no build log found for environment %q", id)
+	}
+	if n < 0 || n >= len(logs) {
+		return nil, "", fmt.Errorf("build log %d not found for environment %q, %d available", n, id, len(logs))
+	}
+	data, err := os.ReadFile(logs[n].Path)
+	if err != nil {
+		return nil, "", err
+	}
+	return logs[n], string(data), nil
+}
+
+func (r *Repository) deleteBuildLogs(id string) error {
+	dir, err := r.buildLogPath(id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryBuildLogs(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i := range maxBuildLogs + 2 {
+		_, err := repo.SaveBuildLog("busy-env", &environment.BuildLog{
+			StartedAt: start.Add(time.Duration(i) * time.Minute),
+			Output:    fmt.Sprintf("build %d\n", i),
+		})
+		require.NoError(t, err)
+	}
+
+	err = repo.SaveBuildError("busy-env", &environment.BuildError{
+		Log: &environment.BuildLog{
+			StartedAt: start.Add(time.Hour),
+			Failed:    true,
+			Output:    "$ pip install nope\nERROR: No matching distribution found for nope\n",
+		},
+		Err: errors.New("setup command failed"),
+	})
+	require.ErrorContains(t, err, "container-use build-log busy-env")
+
+	logs, err := repo.BuildLogs("busy-env")
+	require.NoError(t, err)
+	assert.Len(t, logs, maxBuildLogs, "older logs are pruned")
+
+	info, log, err := repo.ReadBuildLog("busy-env", 0)
+	require.NoError(t, err)
+	assert.True(t, info.Failed)
+	assert.Contains(t, log, "No matching distribution")
+
+	info, log, err = repo.ReadBuildLog("busy-env", 1)
+	require.NoError(t, err)
+	assert.False(t, info.Failed)
+	assert.Equal(t, fmt.Sprintf("build %d\n", maxBuildLogs+1), log)
+
+	_, _, err = repo.ReadBuildLog("busy-env", 100)
+	assert.Error(t, err)
+	_, _, err = repo.ReadBuildLog("other-env", 0)
+	assert.ErrorContains(t, err, "no build log found")
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ConfigFile is the configuration of container-use shared by all repositories and MCP servers, in its data
+// directory.
+const ConfigFile = "config.toml"
+
+// Config is the global configuration of container-use, read from ConfigFile.
+type Config struct {
+	Tools     ToolSettings     `toml:"tools"`
+	Mounts    MountSettings    `toml:"mounts"`
+	Downloads DownloadSettings `toml:"downloads"`
+}
+
+// ToolSettings select the tools MCP servers expose to agents.
+type ToolSettings struct {
+	// ReadOnly only exposes the tools that don't change environments, e.g. to give an agent
+	// review-only access.
+	ReadOnly bool `toml:"read_only"`
+	// Enable, if set, only exposes these tools.
+	Enable []string `toml:"enable"`
+	// Disable hides these tools.
+	Disable []string `toml:"disable"`
+}
+
+// MountSettings select the host directories environments may mount.
+type MountSettings struct {
+	// Allow lists the directories that may be mounted, along with their subdirectories. Without it, no host
+	// directory may be mounted.
+	Allow []string `toml:"allow"`
+}
+
+// DownloadSettings select the host directories agents may download files from environments to.
+type DownloadSettings struct {
+	// Allow lists the directories that files may be downloaded to, along with their subdirectories. Without
+	// it, agents may not download files.
+	Allow []string `toml:"allow"`
+}
+
+// ConfigPath returns the path of the global configuration in basePath, the data directory of container-use.
+func ConfigPath(basePath string) string {
+	return filepath.Join(basePath, ConfigFile)
+}
+
+// LoadConfig reads the global configuration at path. A missing file is an empty configuration.
+func LoadConfig(path string) (*Config, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := toml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	return config, nil
+}
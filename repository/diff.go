@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FileDiff summarizes the changes of a file in a diff.
+type FileDiff struct {
+	Path    string `json:"path"`
+	Added   int    `json:"lines_added"`
+	Deleted int    `json:"lines_deleted"`
+	Hunks   int    `json:"hunks"`
+	Binary  bool   `json:"binary,omitempty"`
+	// Submodule is the range of commits a submodule moved across, e.g. 1a2b3c4..5d6e7f8.
+	Submodule string `json:"submodule,omitempty"`
+}
+
+// EnvironmentDiff returns the unified diff of environment id, limited to paths if any are given. With an
+// empty revision, it is the diff of all the changes of the environment that are not on the current branch,
+// like `container-use diff`. Otherwise it is the diff of that single commit, see resolveRevision.
+// Submodule changes are shown as the log of the commits they move across.
+func (r *Repository) EnvironmentDiff(ctx context.Context, id, revision string, paths []string) (string, error) {
+	pathspec := []string{"--submodule=log"}
+	if len(paths) > 0 {
+		pathspec = append(pathspec, "--")
+		pathspec = append(pathspec, paths...)
+	}
+
+	if revision != "" {
+		commit, err := r.resolveRevision(ctx, id, revision)
+		if err != nil {
+			return "", err
+		}
+		// The commit may not have been fetched into the source repository yet
+		return r.managedGit(ctx, r.forkRepoPath, append([]string{"diff", commit + "^!"}, pathspec...)...)
+	}
+
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return "", err
+	}
+	return RunGitCommand(ctx, r.userRepoPath, append([]string{"diff", revisionRange}, pathspec...)...)
+}
+
+// ParseDiff summarizes each file of the unified diff patch, as produced by git diff, and each submodule
+// change of git diff --submodule=log.
+func ParseDiff(patch string) []*FileDiff {
+	files := []*FileDiff{}
+	var file *FileDiff
+	inHunk := false
+	for line := range strings.Lines(patch) {
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			// diff --git a/<path> b/<path>
+			path := line[len("diff --git "):]
+			if i := strings.Index(path, " b/"); i >= 0 {
+				path = path[i+len(" b/"):]
+			}
+			file = &FileDiff{Path: path}
+			files = append(files, file)
+			inHunk = false
+		case strings.HasPrefix(line, "Submodule "):
+			// Submodule <path> <from>..<to>[ (<description>)]:, followed by the log of the commits
+			change := strings.TrimSuffix(line[len("Submodule "):], ":")
+			if i := strings.LastIndex(change, " ("); i >= 0 && strings.HasSuffix(change, ")") {
+				change = change[:i]
+			}
+			i := strings.LastIndex(change, " ")
+			if i < 0 {
+				continue
+			}
+			file = &FileDiff{Path: change[:i], Submodule: change[i+1:]}
+			files = append(files, file)
+			inHunk = false
+		case file == nil:
+		case strings.HasPrefix(line, "@@"):
+			file.Hunks++
+			inHunk = true
+		case !inHunk:
+			if strings.HasPrefix(line, "Binary files ") {
+				file.Binary = true
+			}
+		case strings.HasPrefix(line, "+"):
+			file.Added++
+		case strings.HasPrefix(line, "-"):
+			file.Deleted++
+		}
+	}
+	return files
+}
+
+// FormatDiffSummary renders files like git diff --stat, with the number of hunks of each file.
+func FormatDiffSummary(files []*FileDiff) string {
+	var s strings.Builder
+	added, deleted := 0, 0
+	for _, file := range files {
+		if file.Submodule != "" {
+			fmt.Fprintf(&s, " %s | submodule %s\n", file.Path, file.Submodule)
+			continue
+		}
+		if file.Binary {
+			fmt.Fprintf(&s, " %s | binary\n", file.Path)
+			continue
+		}
+		fmt.Fprintf(&s, " %s | +%d -%d in %d hunks\n", file.Path, file.Added, file.Deleted, file.Hunks)
+		added += file.Added
+		deleted += file.Deleted
+	}
+	fmt.Fprintf(&s, " %d files changed, %d insertions(+), %d deletions(-)\n", len(files), added, deleted)
+	return s.String()
+}
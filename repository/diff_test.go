@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiff(t *testing.T) {
+	patch := `diff --git a/main.go b/main.go
+index 3b18e51..a042389 100644
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@
+ package main
++
+-// TODO
++// Entry point
+@@ -10,2 +11,2 @@ func main() {
+-	println("hi")
++	println("hello")
+diff --git a/logo.png b/logo.png
+new file mode 100644
+index 0000000..e69de29
+Binary files /dev/null and b/logo.png differ
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+--- a separator
+\ No newline at end of file
+`
+	files := ParseDiff(patch)
+	assert.Equal(t, []*FileDiff{
+		{Path: "main.go", Added: 3, Deleted: 2, Hunks: 2},
+		{Path: "logo.png", Binary: true},
+		{Path: "old.txt", Deleted: 1, Hunks: 1},
+	}, files)
+
+	assert.Equal(t, ` main.go | +3 -2 in 2 hunks
+ logo.png | binary
+ old.txt | +0 -1 in 1 hunks
+ 3 files changed, 3 insertions(+), 3 deletions(-)
+`, FormatDiffSummary(files))
+
+	assert.Empty(t, ParseDiff(""))
+}
+
+func TestParseDiffSubmodules(t *testing.T) {
+	patch := `diff --git a/.gitmodules b/.gitmodules
+new file mode 100644
+index 0000000..77165de
+--- /dev/null
++++ b/.gitmodules
+@@ -0,0 +1,3 @@
++[submodule "vendor lib"]
++	path = vendor lib
++	url = ../lib
+Submodule vendor lib 0000000...181536a (new submodule)
+Submodule tools d7accf6..181536a:
+  > Fix the linter
+  < Revert the linter fix
+`
+	files := ParseDiff(patch)
+	assert.Equal(t, []*FileDiff{
+		{Path: ".gitmodules", Added: 3, Hunks: 1},
+		{Path: "vendor lib", Submodule: "0000000...181536a"},
+		{Path: "tools", Submodule: "d7accf6..181536a"},
+	}, files)
+
+	assert.Equal(t, ` .gitmodules | +3 -0 in 1 hunks
+ vendor lib | submodule 0000000...181536a
+ tools | submodule d7accf6..181536a
+ 3 files changed, 3 insertions(+), 0 deletions(-)
+`, FormatDiffSummary(files))
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// DownloadNotAllowedError reports a download destination outside of the host directories allowed by the
+// global configuration.
+type DownloadNotAllowedError struct {
+	Destination string
+	Allowed     []string
+}
+
+func (e *DownloadNotAllowedError) Error() string {
+	if len(e.Allowed) == 0 {
+		return fmt.Sprintf("downloading to %s is not allowed: no host directory may be downloaded to, list those that may in [downloads] allow of the global config.toml", e.Destination)
+	}
+	return fmt.Sprintf("downloading to %s is not allowed, only paths within %s may be downloaded to (see [downloads] allow of the global config.toml)", e.Destination, strings.Join(e.Allowed, ", "))
+}
+
+// CheckDownloadDestination checks that agents may download files to destination: an absolute path within
+// the directories listed in the [downloads] section of the global configuration. Users copying files
+// themselves, with `cu cp`, are not restricted.
+func (r *Repository) CheckDownloadDestination(destination string) error {
+	if !filepath.IsAbs(destination) {
+		return fmt.Errorf("destination %q must be an absolute path", destination)
+	}
+	config, err := LoadConfig(ConfigPath(r.basePath))
+	if err != nil {
+		return err
+	}
+	resolved, err := resolveHostPath(destination)
+	if err != nil {
+		return err
+	}
+	for _, dir := range config.Downloads.Allow {
+		expanded, err := homedir.Expand(dir)
+		if err != nil {
+			return err
+		}
+		allowed, err := resolveHostPath(expanded)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(allowed) && isWithinDir(resolved, allowed) {
+			return nil
+		}
+	}
+	return &DownloadNotAllowedError{Destination: destination, Allowed: config.Downloads.Allow}
+}
+
+// resolveHostPath returns path with the symbolic links of its existing parents resolved, so that a link
+// can't point outside of an allowed directory. The part of path that doesn't exist yet is kept as is.
+func resolveHostPath(path string) (string, error) {
+	path = filepath.Clean(path)
+	missing := []string{}
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			slices.Reverse(missing)
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || filepath.Dir(path) == path {
+			return "", err
+		}
+		missing = append(missing, filepath.Base(path))
+		path = filepath.Dir(path)
+	}
+}
+
+func isWithinDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDownloadDestination(t *testing.T) {
+	r := &Repository{basePath: t.TempDir()}
+	host := t.TempDir()
+	artifacts := filepath.Join(host, "artifacts")
+	require.NoError(t, os.MkdirAll(artifacts, 0755))
+	require.NoError(t, os.Symlink(host, filepath.Join(artifacts, "escape")))
+
+	var notAllowed *DownloadNotAllowedError
+	err := r.CheckDownloadDestination(filepath.Join(artifacts, "app"))
+	require.True(t, errors.As(err, &notAllowed), "nothing may be downloaded to without configuration, got %v", err)
+
+	config := "[downloads]\nallow = [\"" + artifacts + "\"]\n"
+	require.NoError(t, os.WriteFile(ConfigPath(r.basePath), []byte(config), 0644))
+	for destination, allowed := range map[string]bool{
+		filepath.Join(artifacts, "app"):                   true,
+		filepath.Join(artifacts, "build", "coverage.out"): true,
+		artifacts:                                     true,
+		filepath.Join(host, "app"):                    false,
+		filepath.Join(artifacts, "..", "app"):         false,
+		filepath.Join(artifacts, "escape", "app"):     false,
+		filepath.Join(host, "artifacts-other", "app"): false,
+	} {
+		err := r.CheckDownloadDestination(destination)
+		if allowed {
+			assert.NoError(t, err, destination)
+		} else {
+			assert.True(t, errors.As(err, &notAllowed), "%s: expected a DownloadNotAllowedError, got %v", destination, err)
+		}
+	}
+
+	assert.ErrorContains(t, r.CheckDownloadDestination("artifacts/app"), "must be an absolute path")
+}
@@ -0,0 +1,297 @@
+package repository
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+const (
+	exportManifestFile = "manifest.json"
+	exportBundleFile   = "environment.bundle"
+	exportImageFile    = "image.tar"
+	exportVersion      = 1
+)
+
+// ExportManifest describes the contents of an environment export.
+type ExportManifest struct {
+	Version    int       `json:"version"`
+	ID         string    `json:"id"`
+	Title      string    `json:"title,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Export packages environment id into a tarball at path: its branch and notes as a git bundle,
+// and its container as an OCI image. The tarball is compressed according to the extension of
+// path: .tar.gz or .tgz with gzip, .tar.zst with zstd (which must be installed), .tar uncompressed.
+// It can be reconstituted on another machine with ImportBundle.
+func (r *Repository) Export(ctx context.Context, dag *dagger.Client, id, path string) (*ExportManifest, error) {
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "container-use-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := r.createBundle(ctx, id, filepath.Join(dir, exportBundleFile)); err != nil {
+		return nil, fmt.Errorf("failed to bundle the environment history: %w", err)
+	}
+	if err := env.ExportImage(ctx, filepath.Join(dir, exportImageFile)); err != nil {
+		return nil, fmt.Errorf("failed to export the environment container: %w", err)
+	}
+
+	manifest := &ExportManifest{
+		Version:    exportVersion,
+		ID:         id,
+		Title:      env.State.Title,
+		ExportedAt: time.Now(),
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, exportManifestFile), data, 0644); err != nil {
+		return nil, err
+	}
+
+	if err := writeTarball(path, dir, exportManifestFile, exportBundleFile, exportImageFile); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ImportBundle reconstitutes an environment exported with Export, under the same ID.
+func (r *Repository) ImportBundle(ctx context.Context, dag *dagger.Client, path, explanation string) (*environment.Environment, error) {
+	dir, err := os.MkdirTemp("", "container-use-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := readTarball(path, dir); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, exportManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an environment export: %w", path, err)
+	}
+	manifest := &ExportManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid export manifest: %w", err)
+	}
+	if manifest.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", manifest.Version)
+	}
+
+	if err := r.exists(ctx, manifest.ID); err == nil {
+		return nil, fmt.Errorf("environment %q already exists", manifest.ID)
+	}
+	if err := r.restoreBundle(ctx, manifest.ID, filepath.Join(dir, exportBundleFile)); err != nil {
+		return nil, fmt.Errorf("failed to restore the environment history: %w", err)
+	}
+
+	env, err := r.Get(ctx, dag, manifest.ID)
+	if err != nil {
+		return nil, err
+	}
+	head, err := r.managedGit(ctx, r.forkRepoPath, "rev-parse", manifest.ID)
+	if err != nil {
+		return nil, err
+	}
+	sourceDir := dag.
+		Host().
+		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(strings.TrimSpace(head)).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true})
+	image := dag.Container().Import(dag.Host().File(filepath.Join(dir, exportImageFile)))
+
+	if err := env.RestoreContainer(ctx, image, sourceDir); err != nil {
+		return nil, fmt.Errorf("failed to restore the environment container: %w", err)
+	}
+	if err := r.Update(ctx, env, explanation); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// IsExportPath reports whether path names an environment export, judging by its extension.
+func IsExportPath(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.zst"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// zstdCommand returns the command compressing or decompressing .zst tarballs,
+// or nil for other tarballs which are handled natively.
+func zstdCommand(path string, decompress bool) (*exec.Cmd, error) {
+	if !strings.HasSuffix(path, ".zst") {
+		return nil, nil
+	}
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return nil, fmt.Errorf("zstd is required for .zst tarballs, install it or use .tar.gz")
+	}
+	if decompress {
+		return exec.Command("zstd", "-q", "-d", "-c"), nil
+	}
+	return exec.Command("zstd", "-q", "-c"), nil
+}
+
+func isGzip(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// writeTarball writes the given files of dir to a tarball at path.
+func writeTarball(path, dir string, files ...string) (rerr error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && rerr == nil {
+			rerr = err
+		}
+	}()
+
+	var w io.Writer = f
+	var closers []func() error
+	if isGzip(path) {
+		gz := gzip.NewWriter(f)
+		w = gz
+		closers = append(closers, gz.Close)
+	}
+	cmd, err := zstdCommand(path, false)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
+		cmd.Stdout = f
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		w = stdin
+		closers = append(closers, stdin.Close, cmd.Wait)
+	}
+
+	tw := tar.NewWriter(w)
+	for _, name := range files {
+		if err := addTarFile(tw, filepath.Join(dir, name), name); err != nil {
+			return err
+		}
+	}
+	errs := []error{tw.Close()}
+	for _, closeFn := range closers {
+		errs = append(errs, closeFn())
+	}
+	return errors.Join(errs...)
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// readTarball extracts the regular files at the top level of the tarball at path into dir.
+func readTarball(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isGzip(path) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	cmd, err := zstdCommand(path, true)
+	if err != nil {
+		return err
+	}
+	if cmd != nil {
+		cmd.Stdin = f
+		cmd.Stderr = os.Stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		defer func() {
+			// zstd has exited unless we stopped reading early
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}()
+		r = stdout
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		// Only flat regular files are expected: ignore anything that could escape dir
+		if header.Typeflag != tar.TypeReg || header.Name != filepath.Base(header.Name) {
+			continue
+		}
+		out, err := os.OpenFile(filepath.Join(dir, header.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if err := errors.Join(err, out.Close()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarballRoundTrip(t *testing.T) {
+	for _, name := range []string{"env.tar", "env.tar.gz", "env.tgz", "env.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			if filepath.Ext(name) == ".zst" {
+				if _, err := exec.LookPath("zstd"); err != nil {
+					t.Skip("zstd is not installed")
+				}
+			}
+
+			src := t.TempDir()
+			writeFile(t, src, "manifest.json", `{"version": 1}`)
+			writeFile(t, src, "environment.bundle", "bundle contents")
+
+			tarball := filepath.Join(t.TempDir(), name)
+			require.NoError(t, writeTarball(tarball, src, "manifest.json", "environment.bundle"))
+
+			dst := t.TempDir()
+			require.NoError(t, readTarball(tarball, dst))
+			for _, file := range []string{"manifest.json", "environment.bundle"} {
+				expected, err := os.ReadFile(filepath.Join(src, file))
+				require.NoError(t, err)
+				actual, err := os.ReadFile(filepath.Join(dst, file))
+				require.NoError(t, err)
+				assert.Equal(t, expected, actual)
+			}
+		})
+	}
+}
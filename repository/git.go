@@ -31,16 +31,31 @@ var (
 
 // RunGitCommand executes a git command in the specified directory.
 // This is exported for use in tests and other packages that need direct git access.
-func RunGitCommand(ctx context.Context, dir string, args ...string) (out string, rerr error) {
+func RunGitCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	return runGitCommand(ctx, dir, nil, args...)
+}
+
+// runGitCommand executes a git command in the specified directory with env, or the environment
+// of the process if nil.
+func runGitCommand(ctx context.Context, dir string, env []string, args ...string) (out string, rerr error) {
 	slog.Info(fmt.Sprintf("[%s] $ git %s", dir, strings.Join(args, " ")))
 	defer func() {
 		slog.Info(fmt.Sprintf("[%s] $ git %s (DONE)", dir, strings.Join(args, " ")), "err", rerr)
 	}()
 
-	cmd := exec.CommandContext(ctx, "git", args...)
-	cmd.Dir = dir
-
-	output, err := cmd.CombinedOutput()
+	var output []byte
+	var err error
+	// Other processes may hold the locks of the repository, such as several servers sharing the fork
+	for attempt := 0; ; attempt++ {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		output, err = cmd.CombinedOutput()
+		if err == nil || attempt == gitLockRetries || !isLockContention(string(output)) || !sleepBeforeRetry(ctx, attempt) {
+			break
+		}
+		slog.Info("Retrying git command held up by a lock", "dir", dir, "args", args, "attempt", attempt+1)
+	}
 	if err != nil {
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
@@ -87,24 +102,40 @@ func (r *Repository) WorktreePath(id string) (string, error) {
 	return homedir.Expand(path.Join(r.getWorktreePath(), id))
 }
 
+// Worktree returns the path of the worktree of environment id on the host, creating it if the environment
+// was only read so far.
+func (r *Repository) Worktree(ctx context.Context, id string) (string, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return "", err
+	}
+	return r.initializeWorktree(ctx, id)
+}
+
 func (r *Repository) deleteWorktree(id string) error {
 	worktreePath, err := r.WorktreePath(id)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("Deleting worktree at %s\n", worktreePath)
-	return os.RemoveAll(worktreePath)
+	if err := os.RemoveAll(worktreePath); err != nil {
+		return err
+	}
+	persistent, err := r.persistentWorktreePath(id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(persistent)
 }
 
 func (r *Repository) deleteLocalRemoteBranch(id string) error {
 	slog.Info("Pruning git worktrees", "repo", r.forkRepoPath)
-	if _, err := RunGitCommand(context.Background(), r.forkRepoPath, "worktree", "prune"); err != nil {
+	if _, err := r.managedGit(context.Background(), r.forkRepoPath, "worktree", "prune"); err != nil {
 		slog.Error("Failed to prune git worktrees", "repo", r.forkRepoPath, "err", err)
 		return err
 	}
 
 	slog.Info("Deleting local branch", "repo", r.forkRepoPath, "branch", id)
-	if _, err := RunGitCommand(context.Background(), r.forkRepoPath, "branch", "-D", id); err != nil {
+	if _, err := r.managedGit(context.Background(), r.forkRepoPath, "branch", "-D", id); err != nil {
 		slog.Error("Failed to delete local branch", "repo", r.forkRepoPath, "branch", id, "err", err)
 		return err
 	}
@@ -117,7 +148,52 @@ func (r *Repository) deleteLocalRemoteBranch(id string) error {
 	return nil
 }
 
+// discardEnvironment removes what creating environment id left behind when it failed partway: its
+// worktree, its branch in the fork repository and the tracking ref of the source repository.
+// Build logs are kept, failed builds point to them. Every step is attempted and missing pieces are ignored.
+func (r *Repository) discardEnvironment(ctx context.Context, id string) error {
+	// The creation may have failed because ctx was canceled
+	ctx, unlock, err := r.lockRepository(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	slog.Info("Discarding partially created environment", "environment.id", id)
+
+	var errs []error
+	worktreePath, err := r.WorktreePath(id)
+	if err == nil {
+		err = os.RemoveAll(worktreePath)
+	}
+	errs = append(errs, err)
+	persistent, err := r.persistentWorktreePath(id)
+	if err == nil {
+		err = os.RemoveAll(persistent)
+	}
+	errs = append(errs, err)
+
+	_, err = r.managedGit(ctx, r.forkRepoPath, "worktree", "prune")
+	errs = append(errs, err)
+	// Unlike branch -D, update-ref -d succeeds if the branch was never created
+	_, err = r.managedGit(ctx, r.forkRepoPath, "update-ref", "-d", "refs/heads/"+id)
+	errs = append(errs, err)
+	_, err = RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", fmt.Sprintf("refs/remotes/%s/%s", containerUseRemote, id))
+	errs = append(errs, err)
+
+	if err := errors.Join(errs...); err != nil {
+		slog.Error("Failed to discard partially created environment", "environment.id", id, "err", err)
+		return err
+	}
+	return nil
+}
+
 func (r *Repository) initializeWorktree(ctx context.Context, id string) (string, error) {
+	return r.initializeWorktreeFromRef(ctx, id, "HEAD")
+}
+
+// initializeWorktreeFromRef creates the worktree for an environment, branching off ref
+// in the source repository if the environment doesn't exist yet.
+func (r *Repository) initializeWorktreeFromRef(ctx context.Context, id, ref string) (string, error) {
 	worktreePath, err := r.WorktreePath(id)
 	if err != nil {
 		return "", err
@@ -126,24 +202,47 @@ func (r *Repository) initializeWorktree(ctx context.Context, id string) (string,
 	if _, err := os.Stat(worktreePath); err == nil {
 		return worktreePath, nil
 	}
+	ctx, unlock, err := r.lockRepository(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	// Another process may have created it meanwhile
+	if _, err := os.Stat(worktreePath); err == nil {
+		return worktreePath, nil
+	}
+
+	if err := r.exists(ctx, id); err == nil {
+		if err := r.recoverWorktree(ctx, id, worktreePath); err != nil {
+			return "", fmt.Errorf("failed to recover the worktree of %s: %w", id, err)
+		}
+		if err := r.updateSubmodules(ctx, worktreePath); err != nil {
+			return "", err
+		}
+		return worktreePath, nil
+	}
 
 	slog.Info("Initializing worktree", "repository", r.userRepoPath, "container-id", id)
 
-	currentHead, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "HEAD")
+	baseCommit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", ref+"^{commit}")
 	if err != nil {
 		return "", err
 	}
-	currentHead = strings.TrimSpace(currentHead)
+	baseCommit = strings.TrimSpace(baseCommit)
 
-	_, err = RunGitCommand(ctx, r.userRepoPath, "push", containerUseRemote, fmt.Sprintf("%s:refs/heads/%s", currentHead, id))
+	// The pre-push hooks of the user are meant for their remotes, not for the fork
+	_, err = RunGitCommand(ctx, r.userRepoPath, "push", "--no-verify", containerUseRemote, fmt.Sprintf("%s:refs/heads/%s", baseCommit, id))
 	if err != nil {
 		return "", err
 	}
 
-	_, err = RunGitCommand(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id)
+	_, err = r.managedGit(ctx, r.forkRepoPath, "worktree", "add", worktreePath, id)
 	if err != nil {
 		return "", err
 	}
+	if err := r.updateSubmodules(ctx, worktreePath); err != nil {
+		return "", err
+	}
 
 	_, err = RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id)
 	if err != nil {
@@ -154,6 +253,11 @@ func (r *Repository) initializeWorktree(ctx context.Context, id string) (string,
 }
 
 func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.Environment, explanation string) (rerr error) {
+	ctx, unlock, err := r.lockEnvironment(ctx, env.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	slog.Info("Propagating to worktree...",
 		"environment.id", env.ID,
 		"workdir", env.Config.Workdir,
@@ -166,6 +270,10 @@ func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.E
 			"err", rerr)
 	}()
 
+	// Environments are loaded without their worktree, set it up on the first write
+	if _, err := r.initializeWorktree(ctx, env.ID); err != nil {
+		return fmt.Errorf("failed to initialize the worktree: %w", err)
+	}
 	if err := r.exportEnvironment(ctx, env); err != nil {
 		return err
 	}
@@ -174,8 +282,24 @@ func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.E
 	if err != nil {
 		return fmt.Errorf("failed to get worktree path: %w", err)
 	}
-	if err := r.commitWorktreeChanges(ctx, worktreePath, explanation); err != nil {
-		return fmt.Errorf("failed to commit worktree changes: %w", err)
+	policy, err := r.CommandPolicy()
+	if err != nil {
+		return err
+	}
+	checks := &commitChecks{masker: env.SecretMasker(ctx), scanning: policy.SecretScanning, binaries: policy.BinaryFiles}
+	if strategy, inProgress := r.syncInProgress(ctx, worktreePath); inProgress {
+		// Committing would conclude the sync with the conflicts unresolved
+		slog.Info("Sync in progress, leaving the changes uncommitted", "environment.id", env.ID, "strategy", strategy)
+	} else {
+		err = r.commitWorktreeChanges(ctx, worktreePath, checks.masker.Mask(explanation), checks)
+		env.SecretFindings = checks.findings
+		env.SkippedFiles = checks.skipped
+		if err != nil {
+			return fmt.Errorf("failed to commit worktree changes: %w", err)
+		}
+	}
+	if err := r.copyUpWorktree(env.ID); err != nil {
+		return fmt.Errorf("failed to copy up the worktree: %w", err)
 	}
 
 	if err := r.saveState(ctx, env); err != nil {
@@ -191,34 +315,116 @@ func (r *Repository) propagateToWorktree(ctx context.Context, env *environment.E
 		return err
 	}
 
-	return nil
+	return r.fetchSubmoduleCommits(ctx, env.ID, worktreePath)
 }
 
-func (r *Repository) exportEnvironment(ctx context.Context, env *environment.Environment) error {
-	worktreePointer := fmt.Sprintf("gitdir: %s/worktrees/%s", r.forkRepoPath, env.ID)
+// exportStateFile is the file of the administrative directory of a worktree that records the engine session
+// and the ID of the workdir last exported to it, see exportEnvironment.
+const exportStateFile = "container-use-export"
 
+// exportEnvironment brings the worktree of env up to date with its workdir. Only the files that changed
+// since the last export are written. The whole workdir is exported the first time, after the worktree was
+// recovered, when the last export was made in another engine session, whose IDs can't be loaded anymore,
+// or if exporting the changes fails.
+func (r *Repository) exportEnvironment(ctx context.Context, env *environment.Environment) error {
 	worktreePath, err := r.WorktreePath(env.ID)
 	if err != nil {
 		return fmt.Errorf("failed to get worktree path: %w", err)
 	}
 
-	_, err = env.Workdir().
-		WithNewFile(".git", worktreePointer).
+	workdirID, err := env.Workdir().ID(ctx)
+	if err != nil {
+		return err
+	}
+	stateFile := filepath.Join(r.forkRepoPath, "worktrees", env.ID, exportStateFile)
+	session, since := readExportState(stateFile)
+	switch {
+	case since == "":
+		slog.Info("No previous export of the workdir, exporting all of it", "environment.id", env.ID)
+		err = r.exportWorkdir(ctx, env, worktreePath)
+	case session != env.Session():
+		slog.Info("Workdir last exported in another engine session, exporting all of it", "environment.id", env.ID)
+		err = r.exportWorkdir(ctx, env, worktreePath)
+	case since == string(workdirID):
+		slog.Info("Workdir unchanged since the last export", "environment.id", env.ID)
+	default:
+		if err = r.exportWorkdirChanges(ctx, env, worktreePath, since); err != nil {
+			slog.Warn("Failed to export the changes of the workdir, exporting all of it", "environment.id", env.ID, "err", err)
+			err = r.exportWorkdir(ctx, env, worktreePath)
+		}
+	}
+	if err != nil {
+		os.Remove(stateFile)
+		return err
+	}
+	if err := os.WriteFile(stateFile, []byte(env.Session()+"\n"+string(workdirID)), 0644); err != nil {
+		return err
+	}
+
+	slog.Info("Saving environment")
+	if err := env.Config.Save(worktreePath); err != nil {
+		return err
+	}
+	return nil
+}
+
+// readExportState returns the engine session and the workdir ID recorded in the export state file at path,
+// empty if there is none.
+func readExportState(path string) (string, string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+	session, workdirID, _ := strings.Cut(string(data), "\n")
+	return session, workdirID
+}
+
+// exportWorkdir replaces the files of the worktree at worktreePath with the workdir of env.
+func (r *Repository) exportWorkdir(ctx context.Context, env *environment.Environment, worktreePath string) error {
+	worktreePointer := fmt.Sprintf("gitdir: %s/worktrees/%s", r.forkRepoPath, env.ID)
+
+	submodules, err := r.submoduleGitFiles(ctx, worktreePath)
+	if err != nil {
+		return err
+	}
+	workdir := env.Workdir().
+		WithoutDirectory(".git").
+		WithNewFile(".git", worktreePointer)
+	for path, gitFile := range submodules {
+		workdir = workdir.WithNewFile(path+"/.git", gitFile)
+	}
+
+	_, err = workdir.
 		Export(
 			ctx,
 			worktreePath,
 			dagger.DirectoryExportOpts{Wipe: true},
 		)
+	return err
+}
+
+// exportWorkdirChanges writes to the worktree at worktreePath the changes of the workdir of env since it was
+// the directory of ID since, leaving the git files of the worktree and its submodules alone.
+func (r *Repository) exportWorkdirChanges(ctx context.Context, env *environment.Environment, worktreePath, since string) error {
+	changed, deleted, err := env.WorkdirChanges(ctx, since)
 	if err != nil {
 		return err
 	}
-
-	slog.Info("Saving environment")
-	if err := env.Config.Save(worktreePath); err != nil {
+	if _, err := changed.WithoutDirectory(".git").Export(ctx, worktreePath); err != nil {
 		return err
 	}
+	for _, path := range deleted {
+		if slices.Contains(strings.Split(path, "/"), ".git") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(worktreePath, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+	slog.Info("Exported the changes of the workdir", "environment.id", env.ID, "deleted", len(deleted))
 	return nil
 }
+
 func (r *Repository) propagateGitNotes(ctx context.Context, ref string) error {
 	fullRef := fmt.Sprintf("refs/notes/%s", ref)
 	fetch := func() error {
@@ -256,30 +462,19 @@ func (r *Repository) saveState(ctx context.Context, env *environment.Environment
 		return err
 	}
 
-	_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "add", "-f", "-F", f.Name())
+	_, err = r.managedGit(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "add", "-f", "-F", f.Name())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *Repository) loadState(ctx context.Context, worktreePath string) ([]byte, error) {
-	buff, err := RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesStateRef, "show")
-	if err != nil {
-		if strings.Contains(err.Error(), "no note found") {
-			return nil, nil
-		}
-		return nil, err
-	}
-	return []byte(buff), nil
-}
-
 func (r *Repository) addGitNote(ctx context.Context, env *environment.Environment, note string) error {
 	worktreePath, err := r.WorktreePath(env.ID)
 	if err != nil {
 		return fmt.Errorf("failed to get worktree path: %w", err)
 	}
-	_, err = RunGitCommand(ctx, worktreePath, "notes", "--ref", gitNotesLogRef, "append", "-m", note)
+	_, err = r.managedGit(ctx, worktreePath, "notes", "--ref", gitNotesLogRef, "append", "-m", note)
 	if err != nil {
 		return err
 	}
@@ -316,8 +511,36 @@ func (r *Repository) revisionRange(ctx context.Context, env *environment.Environ
 	return fmt.Sprintf("%s..%s", mergeBase, envGitRef), nil
 }
 
-func (r *Repository) commitWorktreeChanges(ctx context.Context, worktreePath, explanation string) error {
-	status, err := RunGitCommand(ctx, worktreePath, "status", "--porcelain")
+// commitChecks are applied by commitWorktreeChanges to the changes it commits.
+type commitChecks struct {
+	// masker redacts the values of the secrets of the environment.
+	masker   *environment.SecretMasker
+	scanning environment.SecretScanSettings
+	// findings are the credentials found in the changes, set by commitWorktreeChanges.
+	findings []environment.SecretFinding
+	binaries environment.BinaryFileSettings
+	// skipped are the binary files left uncommitted, set by commitWorktreeChanges.
+	skipped []string
+}
+
+// SecretLeakError is returned when the changes of an environment are not committed because they contain
+// credentials, see environment.SecretScanBlock.
+type SecretLeakError struct {
+	Findings []environment.SecretFinding
+}
+
+func (e *SecretLeakError) Error() string {
+	lines := make([]string, len(e.Findings))
+	for i, finding := range e.Findings {
+		lines[i] = "  " + finding.String()
+	}
+	return fmt.Sprintf("changes not committed, they contain credentials: remove them, e.g. read them from environment variables instead, then try again. Add %s to lines that are false positives:\n%s",
+		environment.AllowSecretMarker, strings.Join(lines, "\n"))
+}
+
+// commitWorktreeChanges commits the changes of worktreePath, applying checks if not nil.
+func (r *Repository) commitWorktreeChanges(ctx context.Context, worktreePath, explanation string, checks *commitChecks) error {
+	status, err := r.managedGit(ctx, worktreePath, "status", "--porcelain")
 	if err != nil {
 		return err
 	}
@@ -326,107 +549,259 @@ func (r *Repository) commitWorktreeChanges(ctx context.Context, worktreePath, ex
 		return nil
 	}
 
-	if err := r.addNonBinaryFiles(ctx, worktreePath); err != nil {
+	if err := r.commitSubmoduleChanges(ctx, worktreePath, explanation, checks); err != nil {
+		return err
+	}
+	if err := r.stageWorktreeChanges(ctx, worktreePath, checks); err != nil {
 		return err
 	}
 
-	_, err = RunGitCommand(ctx, worktreePath, "commit", "--allow-empty", "--allow-empty-message", "-m", explanation)
+	_, err = r.managedGit(ctx, worktreePath, "commit", "--allow-empty", "--allow-empty-message", "-m", explanation)
 	return err
 }
 
-// AI slop below!
-// this is just to keep us moving fast because big git repos get hard to work with
-// and our demos like to download large dependencies.
-func (r *Repository) addNonBinaryFiles(ctx context.Context, worktreePath string) error {
-	statusOutput, err := RunGitCommand(ctx, worktreePath, "status", "--porcelain")
+// stageWorktreeChanges stages the changes of worktreePath, applying checks if not nil.
+func (r *Repository) stageWorktreeChanges(ctx context.Context, worktreePath string, checks *commitChecks) error {
+	var binaries environment.BinaryFileSettings
+	if checks != nil {
+		binaries = checks.binaries
+	}
+	skipped, err := r.addWorktreeFiles(ctx, worktreePath, binaries)
+	if err != nil {
+		return err
+	}
+	if checks != nil {
+		checks.skipped = append(checks.skipped, skipped...)
+		if err := r.maskStagedSecrets(ctx, worktreePath, checks.masker); err != nil {
+			return fmt.Errorf("failed to mask secrets: %w", err)
+		}
+		if err := r.scanStagedSecrets(ctx, worktreePath, checks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanStagedSecrets scans the changes staged in worktreePath for credentials, recording them in checks,
+// and applies the scanning mode: blocking unstages everything and returns a *SecretLeakError, stripping
+// unstages the files containing credentials.
+func (r *Repository) scanStagedSecrets(ctx context.Context, worktreePath string, checks *commitChecks) error {
+	if checks.scanning.Mode == environment.SecretScanOff {
+		return nil
+	}
+	patch, err := r.managedGit(ctx, worktreePath, "diff", "--cached", "--no-color", "--no-ext-diff", "--unified=0", "--diff-filter=ACMR")
+	if err != nil {
+		return err
+	}
+	findings := environment.ScanForSecrets(patch, checks.scanning)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	switch checks.scanning.Mode {
+	case environment.SecretScanBlock:
+		if _, err := r.managedGit(ctx, worktreePath, "reset", "--quiet"); err != nil {
+			return err
+		}
+		checks.findings = append(checks.findings, findings...)
+		return &SecretLeakError{Findings: findings}
+	case environment.SecretScanStrip:
+		files := []string{}
+		for i, finding := range findings {
+			findings[i].Stripped = true
+			if !slices.Contains(files, finding.File) {
+				files = append(files, finding.File)
+			}
+		}
+		checks.findings = append(checks.findings, findings...)
+		slog.Warn("Leaving files with credentials uncommitted", "files", files)
+		_, err := r.managedGit(ctx, worktreePath, append([]string{"--literal-pathspecs", "reset", "--quiet", "--"}, files...)...)
+		return err
+	default:
+		checks.findings = append(checks.findings, findings...)
+		slog.Warn("Committing files with credentials", "findings", len(findings))
+		return nil
+	}
+}
+
+// maskStagedSecrets redacts the values of secrets from the files staged in worktreePath, so that they
+// never reach the environment branch. The files are only changed in the worktree: the container keeps
+// the actual values.
+func (r *Repository) maskStagedSecrets(ctx context.Context, worktreePath string, masker *environment.SecretMasker) error {
+	if masker == nil {
+		return nil
+	}
+	staged, err := r.managedGit(ctx, worktreePath, "diff", "--cached", "--name-only", "-z", "--diff-filter=ACMR")
 	if err != nil {
 		return err
 	}
 
-	for line := range strings.SplitSeq(strings.TrimSpace(statusOutput), "\n") {
-		if line == "" {
+	masked := []string{}
+	for name := range strings.SplitSeq(staged, "\x00") {
+		if name == "" {
+			continue
+		}
+		path := filepath.Join(worktreePath, name)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
 			continue
 		}
-		if len(line) < 3 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !masker.Contains(string(data)) {
 			continue
 		}
+		if err := os.WriteFile(path, []byte(masker.Mask(string(data))), info.Mode().Perm()); err != nil {
+			return err
+		}
+		masked = append(masked, name)
+	}
+	if len(masked) == 0 {
+		return nil
+	}
+
+	slog.Warn("Masked secrets in files before committing them", "files", masked)
+	_, err = r.managedGit(ctx, worktreePath, append([]string{"--literal-pathspecs", "add", "--"}, masked...)...)
+	return err
+}
+
+// addWorktreeFiles stages the changes of worktreePath, leaving out the files shouldSkipFile matches. Binary
+// files are staged according to binaries, and the ones left out are returned. The status is read once,
+// untracked directories included except the skipped ones, and the files are staged with a single git add:
+// running git once per file took minutes on worktrees with thousands of changes, such as freshly installed
+// dependencies.
+func (r *Repository) addWorktreeFiles(ctx context.Context, worktreePath string, binaries environment.BinaryFileSettings) ([]string, error) {
+	statusArgs := append([]string{"status", "--porcelain", "-z", "--untracked-files=all", "--"}, skippedDirPathspecs()...)
+	statusOutput, err := r.managedGit(ctx, worktreePath, statusArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	lfsFiles := []string{}
+	skipped := []string{}
+	addChanged := func(fileName string) {
+		if !r.isBinaryFile(worktreePath, fileName) {
+			files = append(files, fileName)
+			return
+		}
+		stat, err := os.Stat(filepath.Join(worktreePath, fileName))
+		switch {
+		case err != nil || !binaries.Commits(stat.Size()):
+			skipped = append(skipped, fileName)
+		case binaries.Mode == environment.BinaryFilesLFS:
+			lfsFiles = append(lfsFiles, fileName)
+		default:
+			files = append(files, fileName)
+		}
+	}
 
-		indexStatus := line[0]
-		workTreeStatus := line[1]
-		fileName := strings.TrimSpace(line[2:])
-		if fileName == "" {
+	entries := strings.Split(statusOutput, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
 			continue
 		}
 
+		indexStatus := entry[0]
+		workTreeStatus := entry[1]
+		fileName := entry[3:]
+		if indexStatus == 'R' || indexStatus == 'C' {
+			// Renames and copies are followed by the path they come from
+			i++
+		}
+
 		if r.shouldSkipFile(fileName) {
 			continue
 		}
 
 		switch {
 		case indexStatus == '?' && workTreeStatus == '?':
-			// ?? = untracked files or directories
-			if strings.HasSuffix(fileName, "/") {
-				// Untracked directory - traverse and add non-binary files
-				dirName := strings.TrimSuffix(fileName, "/")
-				if err := r.addFilesFromUntrackedDirectory(ctx, worktreePath, dirName); err != nil {
-					return err
-				}
-			} else if !r.isBinaryFile(worktreePath, fileName) {
-				// Untracked file - add if not binary
-
-				_, err = RunGitCommand(ctx, worktreePath, "add", fileName)
-				if err != nil {
-					return err
-				}
+			// ?? = untracked files. Directories are only listed for nested repositories, which aren't ours
+			// to add.
+			if !strings.HasSuffix(fileName, "/") {
+				addChanged(fileName)
 			}
-		case indexStatus == 'A':
-			// A = already staged, skip
+		case indexStatus == 'A', indexStatus == 'D' && workTreeStatus == ' ':
+			// A, D = already staged, skip
 			continue
 		case indexStatus == 'D' || workTreeStatus == 'D':
 			// D = deleted files (always stage deletion)
-			_, err = RunGitCommand(ctx, worktreePath, "add", fileName)
-			if err != nil {
-				return err
-			}
+			files = append(files, fileName)
 		default:
-			// M, R, C and other statuses - add if not binary
-			if !r.isBinaryFile(worktreePath, fileName) {
-				_, err = RunGitCommand(ctx, worktreePath, "add", fileName)
-				if err != nil {
-					return err
-				}
-			}
+			// M, R, C and other statuses
+			addChanged(fileName)
 		}
 	}
 
-	return nil
+	if len(lfsFiles) > 0 {
+		if err := r.trackWithLFS(ctx, worktreePath, lfsFiles); err != nil {
+			return nil, err
+		}
+		files = append(files, lfsFiles...)
+		files = append(files, gitAttributesFile)
+	}
+	if len(skipped) > 0 {
+		slog.Info("Left binary files uncommitted", "worktree", worktreePath, "files", skipped)
+	}
+	return skipped, r.stageFiles(ctx, worktreePath, files)
 }
 
-func (r *Repository) shouldSkipFile(fileName string) bool {
-	skipExtensions := []string{
-		".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz",
-		".zip", ".rar", ".7z", ".gz", ".bz2", ".xz",
-		".exe", ".bin", ".dmg", ".pkg", ".msi",
-		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".svg",
-		".mp3", ".mp4", ".avi", ".mov", ".wmv", ".flv", ".mkv",
-		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
-		".so", ".dylib", ".dll", ".a", ".lib",
+// stageFiles stages files, paths relative to worktreePath, with a single git add. They are passed in a
+// file rather than as arguments, which have a size limit.
+func (r *Repository) stageFiles(ctx context.Context, worktreePath string, files []string) error {
+	if len(files) == 0 {
+		return nil
 	}
 
-	lowerName := strings.ToLower(fileName)
-	for _, ext := range skipExtensions {
-		if strings.HasSuffix(lowerName, ext) {
-			return true
-		}
+	f, err := os.CreateTemp(os.TempDir(), ".container-use-git-add-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(files, "\x00")); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
 
-	skipPatterns := []string{
-		"node_modules/", ".git/", "__pycache__/", ".DS_Store",
-		"venv/", ".venv/", "env/", ".env/",
-		"target/", "build/", "dist/", ".next/",
-		"*.tmp", "*.temp", "*.cache", "*.log",
+	_, err = r.managedGit(ctx, worktreePath, "--literal-pathspecs", "add", "--pathspec-from-file="+f.Name(), "--pathspec-file-nul")
+	return err
+}
+
+// skipPatterns match the paths of dependencies, caches and build outputs, which are never committed. Those
+// ending with / are directories.
+var skipPatterns = []string{
+	"node_modules/", ".git/", "__pycache__/", ".DS_Store",
+	"venv/", ".venv/", "env/", ".env/",
+	"target/", "build/", "dist/", ".next/",
+	"*.tmp", "*.temp", "*.cache", "*.log",
+}
+
+// skippedDirPathspecs returns the pathspecs leaving the directories of skipPatterns out of git status, so
+// that it doesn't list the thousands of files of dependencies only for shouldSkipFile to drop them. Like
+// shouldSkipFile, they match directories whose name ends with the pattern, in any case.
+func skippedDirPathspecs() []string {
+	pathspecs := []string{"."}
+	for _, pattern := range skipPatterns {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			pathspecs = append(pathspecs, ":(exclude,glob,icase)**/*"+dir+"/**")
+		}
 	}
+	return pathspecs
+}
 
+// shouldSkipFile reports whether fileName is part of dependencies, caches or build outputs, which are never
+// committed.
+func (r *Repository) shouldSkipFile(fileName string) bool {
+	lowerName := strings.ToLower(fileName)
 	for _, pattern := range skipPatterns {
 		if strings.Contains(lowerName, strings.ToLower(pattern)) {
 			return true
@@ -449,42 +824,25 @@ func (r *Repository) IsDirty(ctx context.Context) (bool, string, error) {
 	return true, status, nil
 }
 
-func (r *Repository) addFilesFromUntrackedDirectory(ctx context.Context, worktreePath, dirName string) error {
-	dirPath := filepath.Join(worktreePath, dirName)
-
-	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(worktreePath, path)
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			if r.shouldSkipFile(relPath + "/") {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		if r.shouldSkipFile(relPath) {
-			return nil
-		}
-
-		if !r.isBinaryFile(worktreePath, relPath) {
-			_, err = RunGitCommand(ctx, worktreePath, "add", relPath)
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
+// binaryExtensions are the extensions of files treated as binary without looking at their contents.
+var binaryExtensions = []string{
+	".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz",
+	".zip", ".rar", ".7z", ".gz", ".bz2", ".xz",
+	".exe", ".bin", ".dmg", ".pkg", ".msi",
+	".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".svg",
+	".mp3", ".mp4", ".avi", ".mov", ".wmv", ".flv", ".mkv",
+	".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+	".so", ".dylib", ".dll", ".a", ".lib",
 }
 
 func (r *Repository) isBinaryFile(worktreePath, fileName string) bool {
+	lowerName := strings.ToLower(fileName)
+	for _, ext := range binaryExtensions {
+		if strings.HasSuffix(lowerName, ext) {
+			return true
+		}
+	}
+
 	fullPath := filepath.Join(worktreePath, fileName)
 
 	stat, err := os.Stat(fullPath)
@@ -2,11 +2,13 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/dagger/container-use/environment"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -76,6 +78,15 @@ func TestSelectiveFileStaging(t *testing.T) {
 			shouldSkip:  []string{"node_modules", "build"},
 			reason:      "Dependencies and build outputs should be excluded",
 		},
+		{
+			name: "special_file_names",
+			setup: func(t *testing.T, dir string) {
+				writeFile(t, dir, "[draft].md", "# Draft")
+				writeFile(t, dir, "d.md", "# D")
+			},
+			shouldStage: []string{"[draft].md", "d.md"},
+			reason:      "Names are staged literally, not as patterns",
+		},
 	}
 
 	for _, scenario := range scenarios {
@@ -101,7 +112,7 @@ func TestSelectiveFileStaging(t *testing.T) {
 			repo := &Repository{}
 
 			// Run the actual staging logic (testing the integration)
-			err = repo.addNonBinaryFiles(ctx, dir)
+			_, err = repo.addWorktreeFiles(ctx, dir, environment.BinaryFileSettings{})
 			require.NoError(t, err, "Staging should not error")
 
 			status, err := RunGitCommand(ctx, dir, "status", "--porcelain")
@@ -125,6 +136,120 @@ func TestSelectiveFileStaging(t *testing.T) {
 	}
 }
 
+// Skipped directories are left out of git status rather than listed and dropped
+func TestSkippedDirPathspecs(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	_, err := RunGitCommand(ctx, dir, "init")
+	require.NoError(t, err)
+	for _, name := range []string{
+		"main.go",
+		"src/app.js",
+		"node_modules/lodash/index.js",
+		"web/node_modules/react/index.js",
+		"Build/output.txt",
+		"rebuild/output.txt",
+		"environment/config.go",
+	} {
+		writeFile(t, dir, name, "content")
+	}
+
+	status, err := RunGitCommand(ctx, dir, append([]string{"status", "--porcelain", "--untracked-files=all", "--"}, skippedDirPathspecs()...)...)
+	require.NoError(t, err)
+	listed := []string{}
+	for line := range strings.Lines(status) {
+		listed = append(listed, strings.TrimSpace(line[3:]))
+	}
+	assert.ElementsMatch(t, []string{"main.go", "src/app.js", "environment/config.go"}, listed)
+
+	repo := &Repository{}
+	for _, name := range listed {
+		assert.False(t, repo.shouldSkipFile(name), name)
+	}
+	for _, name := range []string{"web/node_modules/react/index.js", "Build/output.txt", "rebuild/output.txt"} {
+		assert.True(t, repo.shouldSkipFile(name), "pathspecs leave out what shouldSkipFile skips: %s", name)
+	}
+}
+
+// Binary files are staged according to the policy of the repository, and reported when left out
+func TestBinaryFilePolicy(t *testing.T) {
+	ctx := context.Background()
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		_, err := RunGitCommand(ctx, dir, "init")
+		require.NoError(t, err)
+		writeFile(t, dir, "main.go", "package main")
+		writeBinaryFile(t, dir, "small.bin", 100)
+		writeBinaryFile(t, dir, "large.bin", 5000)
+		writeBinaryFile(t, dir, "node_modules/dep.node", 100)
+		return dir
+	}
+	staged := func(t *testing.T, dir string) []string {
+		out, err := RunGitCommand(ctx, dir, "diff", "--cached", "--name-only")
+		require.NoError(t, err)
+		return strings.Fields(out)
+	}
+	repo := &Repository{}
+
+	t.Run("skip", func(t *testing.T) {
+		dir := setup(t)
+		skipped, err := repo.addWorktreeFiles(ctx, dir, environment.BinaryFileSettings{})
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"small.bin", "large.bin"}, skipped)
+		assert.Equal(t, []string{"main.go"}, staged(t, dir))
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		dir := setup(t)
+		skipped, err := repo.addWorktreeFiles(ctx, dir, environment.BinaryFileSettings{Mode: environment.BinaryFilesCommit, MaxSize: 1000})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"large.bin"}, skipped)
+		assert.Equal(t, []string{"main.go", "small.bin"}, staged(t, dir))
+	})
+
+	t.Run("lfs", func(t *testing.T) {
+		if _, err := RunGitCommand(ctx, t.TempDir(), "lfs", "version"); err != nil {
+			t.Skip("git-lfs is not installed")
+		}
+		dir := setup(t)
+		skipped, err := repo.addWorktreeFiles(ctx, dir, environment.BinaryFileSettings{Mode: environment.BinaryFilesLFS})
+		require.NoError(t, err)
+		assert.Empty(t, skipped)
+		assert.Equal(t, []string{".gitattributes", "large.bin", "main.go", "small.bin"}, staged(t, dir))
+		pointer, err := RunGitCommand(ctx, dir, "cat-file", "blob", ":large.bin")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(pointer, "version https://git-lfs.github.com/spec/v1"))
+	})
+}
+
+func TestLFSAttributes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	_, err := RunGitCommand(ctx, dir, "init")
+	require.NoError(t, err)
+
+	tracked := []string{"assets/logo [dark].png", "model*.bin", "#1.zip", "docs/!.pdf"}
+	lines := []string{}
+	for _, name := range tracked {
+		lines = append(lines, lfsAttributes(name))
+	}
+	writeFile(t, dir, ".gitattributes", strings.Join(lines, "\n")+"\n")
+
+	for name, lfs := range map[string]bool{
+		"assets/logo [dark].png":        true,
+		"assets/logo d.png":             false,
+		"model*.bin":                    true,
+		"model-v2.bin":                  false,
+		"#1.zip":                        true,
+		"docs/!.pdf":                    true,
+		"vendor/assets/logo [dark].png": false,
+	} {
+		out, err := RunGitCommand(ctx, dir, "check-attr", "filter", "--", name)
+		require.NoError(t, err)
+		assert.Equal(t, lfs, strings.HasSuffix(strings.TrimSpace(out), ": lfs"), name)
+	}
+}
+
 // Test the commitWorktreeChanges function
 func TestCommitWorktreeChanges(t *testing.T) {
 	ctx := context.Background()
@@ -149,7 +274,7 @@ func TestCommitWorktreeChanges(t *testing.T) {
 
 		// This verifies that commitWorktreeChanges handles empty directories gracefully
 		// It should return nil (success) when there's nothing to commit
-		err := repo.commitWorktreeChanges(ctx, dir, "Empty dirs")
+		err := repo.commitWorktreeChanges(ctx, dir, "Empty dirs", nil)
 		assert.NoError(t, err, "commitWorktreeChanges should handle empty dirs gracefully")
 	})
 
@@ -157,7 +282,7 @@ func TestCommitWorktreeChanges(t *testing.T) {
 		// Create a file to commit
 		writeFile(t, dir, "test.txt", "hello world")
 
-		err := repo.commitWorktreeChanges(ctx, dir, "Testing commit functionality")
+		err := repo.commitWorktreeChanges(ctx, dir, "Testing commit functionality", nil)
 		require.NoError(t, err)
 
 		// Verify commit was created
@@ -167,6 +292,31 @@ func TestCommitWorktreeChanges(t *testing.T) {
 	})
 }
 
+// Staging thousands of files, as after installing dependencies, takes a single git add
+func BenchmarkAddWorktreeFiles(b *testing.B) {
+	ctx := context.Background()
+	dir := b.TempDir()
+	_, err := RunGitCommand(ctx, dir, "init")
+	require.NoError(b, err)
+
+	for i := range 2000 {
+		name := filepath.Join(dir, "vendor", fmt.Sprintf("pkg%d", i%50), fmt.Sprintf("file%d.go", i))
+		require.NoError(b, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(b, os.WriteFile(name, []byte("package pkg\n"), 0644))
+	}
+	repo := &Repository{}
+
+	for b.Loop() {
+		b.StopTimer()
+		_, err := RunGitCommand(ctx, dir, "rm", "-r", "-q", "--cached", "--ignore-unmatch", ".")
+		require.NoError(b, err)
+		b.StartTimer()
+
+		_, err = repo.addWorktreeFiles(ctx, dir, environment.BinaryFileSettings{})
+		require.NoError(b, err)
+	}
+}
+
 // Test helper functions
 func writeFile(t *testing.T, dir, name, content string) {
 	t.Helper()
@@ -197,3 +347,162 @@ func createDir(t *testing.T, dir, name string) {
 	err := os.MkdirAll(path, 0755)
 	require.NoError(t, err)
 }
+
+// Managed storage must not be affected by the global git configuration of the user
+func TestManagedGitIsolation(t *testing.T) {
+	ctx := context.Background()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, userRepo, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, userRepo, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, userRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	// Signing without a working gpg and hooks that always fail
+	hooksDir := t.TempDir()
+	for _, hook := range []string{"pre-commit", "pre-push", "commit-msg"} {
+		require.NoError(t, os.WriteFile(filepath.Join(hooksDir, hook), []byte("#!/bin/sh\nexit 1\n"), 0755))
+	}
+	globalConfig := filepath.Join(t.TempDir(), "gitconfig")
+	require.NoError(t, os.WriteFile(globalConfig, []byte(fmt.Sprintf(`[commit]
+	gpgSign = true
+[gpg]
+	program = false
+[core]
+	hooksPath = %s
+[status]
+	showUntrackedFiles = no
+`, hooksDir)), 0644))
+	t.Setenv("GIT_CONFIG_GLOBAL", globalConfig)
+
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "isolated-env")
+	require.NoError(t, err)
+
+	writeFile(t, worktree, "new.txt", "untracked file")
+	require.NoError(t, repo.commitWorktreeChanges(ctx, worktree, "Add new file", nil))
+
+	log, err := repo.managedGit(ctx, worktree, "log", "-1", "--format=%an <%ae> %s")
+	require.NoError(t, err)
+	assert.Equal(t, "Test User <test@example.com> Add new file\n", log)
+	files, err := repo.managedGit(ctx, worktree, "ls-files")
+	require.NoError(t, err)
+	assert.Contains(t, files, "new.txt")
+}
+
+func TestCommitWorktreeChangesMasksSecrets(t *testing.T) {
+	ctx := context.Background()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, userRepo, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, userRepo, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, userRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "leaky-env")
+	require.NoError(t, err)
+
+	masker := environment.NewSecretMasker(map[string]string{"API_KEY": "sk-live-123456"})
+	writeFile(t, worktree, "config.yaml", "api_key: sk-live-123456\n")
+	// Not a pathspec with magic, but a file name
+	writeFile(t, worktree, ":secrets.env", "API_KEY=sk-live-123456\n")
+	writeFile(t, worktree, "main.go", "package main")
+	require.NoError(t, repo.commitWorktreeChanges(ctx, worktree, "Configure the client", &commitChecks{masker: masker}))
+
+	committed, err := repo.managedGit(ctx, worktree, "show", "HEAD:config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "api_key: [REDACTED:API_KEY]\n", committed)
+	committed, err = repo.managedGit(ctx, worktree, "show", "HEAD:./:secrets.env")
+	require.NoError(t, err)
+	assert.Equal(t, "API_KEY=[REDACTED:API_KEY]\n", committed)
+	status, err := repo.managedGit(ctx, worktree, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Empty(t, status, "the masked files are committed as they are in the worktree")
+}
+
+func TestCommitWorktreeChangesScansSecrets(t *testing.T) {
+	ctx := context.Background()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, userRepo, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, userRepo, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, userRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "leaky-env")
+	require.NoError(t, err)
+	head, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	token := "ghp_" + "aBcDeFgHiJkLmNoPqRsTuVwXyZ0123456789"
+	writeFile(t, worktree, "deploy.sh", "curl -H 'Authorization: token "+token+"' https://api.github.com\n")
+	writeFile(t, worktree, "main.go", "package main")
+
+	checks := &commitChecks{scanning: environment.SecretScanSettings{Mode: environment.SecretScanBlock}}
+	err = repo.commitWorktreeChanges(ctx, worktree, "Add deployment", checks)
+	var leak *SecretLeakError
+	require.ErrorAs(t, err, &leak)
+	require.Len(t, leak.Findings, 1)
+	assert.Equal(t, "deploy.sh", leak.Findings[0].File)
+	blockedHead, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head, blockedHead, "nothing is committed")
+
+	checks = &commitChecks{scanning: environment.SecretScanSettings{Mode: environment.SecretScanStrip}}
+	require.NoError(t, repo.commitWorktreeChanges(ctx, worktree, "Add deployment", checks))
+	require.Len(t, checks.findings, 1)
+	assert.True(t, checks.findings[0].Stripped)
+	files, err := repo.managedGit(ctx, worktree, "show", "--name-only", "--format=", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "main.go", strings.TrimSpace(files), "only the files without credentials are committed")
+	status, err := repo.managedGit(ctx, worktree, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Contains(t, status, "deploy.sh")
+
+	checks = &commitChecks{}
+	require.NoError(t, repo.commitWorktreeChanges(ctx, worktree, "Add deployment", checks))
+	require.Len(t, checks.findings, 1, "the default mode warns")
+	_, err = repo.managedGit(ctx, worktree, "show", "HEAD:deploy.sh")
+	assert.NoError(t, err)
+}
+
+func TestReadExportState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), exportStateFile)
+	session, workdirID := readExportState(path)
+	assert.Empty(t, session)
+	assert.Empty(t, workdirID)
+
+	require.NoError(t, os.WriteFile(path, []byte("Xyz123\nDirectoryID"), 0644))
+	session, workdirID = readExportState(path)
+	assert.Equal(t, "Xyz123", session)
+	assert.Equal(t, "DirectoryID", workdirID)
+}
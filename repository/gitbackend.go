@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const gitBackendEnv = "CONTAINER_USE_GIT_BACKEND"
+
+var (
+	errRevisionNotFound = errors.New("revision not found")
+	errNoteNotFound     = errors.New("no note found")
+)
+
+// GitBackend reads the objects, references and notes of a repository. It only covers the reads loading
+// environments, which happen on every tool call: they are served in process by default instead of spawning
+// git, which is slow on some platforms. Everything else still runs git: writes and worktrees, but also the
+// reads of the history, diffs and listings of notes and branches, which are left to move here later.
+type GitBackend interface {
+	// ResolveCommit returns the commit revision points to in the repository at dir, or an error wrapping
+	// errRevisionNotFound.
+	ResolveCommit(ctx context.Context, dir, revision string) (string, error)
+	// ReadBlob returns the contents of the file at name in revision of the repository at dir. Missing files
+	// are reported with an error satisfying os.IsNotExist.
+	ReadBlob(ctx context.Context, dir, revision, name string) ([]byte, error)
+	// ReadNote returns the note of notesRef attached to revision in the repository at dir, or an error
+	// wrapping errNoteNotFound. Like git notes --ref, notesRef is relative to refs/notes/ unless it starts
+	// with refs/.
+	ReadNote(ctx context.Context, dir, notesRef, revision string) (string, error)
+}
+
+// gitBackendFromEnv returns the backend selected with CONTAINER_USE_GIT_BACKEND: go-git (the default),
+// falling back to git for what it doesn't support, or exec to always run git with run.
+func gitBackendFromEnv(run func(ctx context.Context, dir string, args ...string) (string, error)) (GitBackend, error) {
+	execBackend := &execGitBackend{run: run}
+	switch backend := os.Getenv(gitBackendEnv); backend {
+	case "", "go-git":
+		return &fallbackGitBackend{primary: &goGitBackend{}, fallback: execBackend}, nil
+	case "exec":
+		return execBackend, nil
+	default:
+		return nil, fmt.Errorf("invalid %s %q, expected one of go-git, exec", gitBackendEnv, backend)
+	}
+}
+
+// execGitBackend runs git.
+type execGitBackend struct {
+	run func(ctx context.Context, dir string, args ...string) (string, error)
+}
+
+func (b *execGitBackend) ResolveCommit(ctx context.Context, dir, revision string) (string, error) {
+	out, err := b.run(ctx, dir, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		// --quiet exits with 1 and no output for revisions that don't exist
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", fmt.Errorf("%w: %s", errRevisionNotFound, revision)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (b *execGitBackend) ReadBlob(ctx context.Context, dir, revision, name string) ([]byte, error) {
+	out, err := b.run(ctx, dir, "cat-file", "blob", revision+":"+name)
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") || strings.Contains(err.Error(), "Not a valid object name") {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+func (b *execGitBackend) ReadNote(ctx context.Context, dir, notesRef, revision string) (string, error) {
+	out, err := b.run(ctx, dir, "notes", "--ref", notesRef, "show", revision)
+	if err != nil {
+		if strings.Contains(err.Error(), "no note found") {
+			return "", fmt.Errorf("%w for %s", errNoteNotFound, revision)
+		}
+		return "", err
+	}
+	return out, nil
+}
+
+// goGitBackend reads repositories with go-git. They are opened on every call, to see the writes of git.
+type goGitBackend struct{}
+
+func (b *goGitBackend) open(dir string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+}
+
+func (b *goGitBackend) resolve(repo *git.Repository, revision string) (*object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return nil, fmt.Errorf("%w: %s", errRevisionNotFound, revision)
+		}
+		return nil, err
+	}
+	return repo.CommitObject(*hash)
+}
+
+func (b *goGitBackend) ResolveCommit(_ context.Context, dir, revision string) (string, error) {
+	repo, err := b.open(dir)
+	if err != nil {
+		return "", err
+	}
+	commit, err := b.resolve(repo, revision)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+func (b *goGitBackend) ReadBlob(_ context.Context, dir, revision, name string) ([]byte, error) {
+	repo, err := b.open(dir)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := b.resolve(repo, revision)
+	if err != nil {
+		if errors.Is(err, errRevisionNotFound) {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := tree.FindEntry(name)
+	if err != nil {
+		if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	if entry.Mode == filemode.Dir || entry.Mode == filemode.Submodule {
+		return nil, fmt.Errorf("%s is not a file in %s", name, revision)
+	}
+	return readBlobObject(repo, entry.Hash)
+}
+
+func (b *goGitBackend) ReadNote(_ context.Context, dir, notesRef, revision string) (string, error) {
+	repo, err := b.open(dir)
+	if err != nil {
+		return "", err
+	}
+	commit, err := b.resolve(repo, revision)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(notesRef, "refs/") {
+		notesRef = "refs/notes/" + notesRef
+	}
+	ref, err := repo.Reference(plumbing.ReferenceName(notesRef), true)
+	if err != nil {
+		if errors.Is(err, plumbing.ErrReferenceNotFound) {
+			return "", fmt.Errorf("%w for %s", errNoteNotFound, revision)
+		}
+		return "", err
+	}
+	notes, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return "", err
+	}
+	tree, err := notes.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	// Notes are named after the object they annotate, split in directories once there are many of them,
+	// e.g. ab/cdef...
+	target := commit.Hash.String()
+	var note *plumbing.Hash
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if strings.ReplaceAll(f.Name, "/", "") == target {
+			note = &f.Hash
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	if note == nil {
+		return "", fmt.Errorf("%w for %s", errNoteNotFound, revision)
+	}
+	data, err := readBlobObject(repo, *note)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func readBlobObject(repo *git.Repository, hash plumbing.Hash) ([]byte, error) {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// fallbackGitBackend reads with primary, and with fallback when primary fails for any other reason than
+// what it reads not existing, e.g. repository formats or revision syntaxes go-git doesn't support.
+type fallbackGitBackend struct {
+	primary  GitBackend
+	fallback GitBackend
+}
+
+func (b *fallbackGitBackend) ResolveCommit(ctx context.Context, dir, revision string) (string, error) {
+	commit, err := b.primary.ResolveCommit(ctx, dir, revision)
+	if err != nil && !errors.Is(err, errRevisionNotFound) {
+		slog.Info("Falling back to git", "op", "resolve", "revision", revision, "err", err)
+		return b.fallback.ResolveCommit(ctx, dir, revision)
+	}
+	return commit, err
+}
+
+func (b *fallbackGitBackend) ReadBlob(ctx context.Context, dir, revision, name string) ([]byte, error) {
+	data, err := b.primary.ReadBlob(ctx, dir, revision, name)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Info("Falling back to git", "op", "read", "revision", revision, "name", name, "err", err)
+		return b.fallback.ReadBlob(ctx, dir, revision, name)
+	}
+	return data, err
+}
+
+func (b *fallbackGitBackend) ReadNote(ctx context.Context, dir, notesRef, revision string) (string, error) {
+	note, err := b.primary.ReadNote(ctx, dir, notesRef, revision)
+	if err != nil && !errors.Is(err, errNoteNotFound) {
+		slog.Info("Falling back to git", "op", "note", "ref", notesRef, "revision", revision, "err", err)
+		return b.fallback.ReadNote(ctx, dir, notesRef, revision)
+	}
+	return note, err
+}
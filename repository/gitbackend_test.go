@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitBackends(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		out, err := RunGitCommand(ctx, dir, args...)
+		require.NoError(t, err)
+		return strings.TrimSpace(out)
+	}
+	git("init", "-b", "main")
+	git("config", "user.email", "test@example.com")
+	git("config", "user.name", "Test User")
+	writeFile(t, dir, "README.md", "# app\n")
+	writeFile(t, dir, ".container-use/environment.json", "{}\n")
+	git("add", ".")
+	git("commit", "-m", "Initial commit")
+	first := git("rev-parse", "HEAD")
+	writeFile(t, dir, "README.md", "# app\n\nUpdated\n")
+	git("commit", "-am", "Update README")
+	head := git("rev-parse", "HEAD")
+	git("notes", "--ref", gitNotesStateRef, "add", "-m", `{"title":"test"}`, "main")
+
+	backends := map[string]GitBackend{
+		"exec":   &execGitBackend{run: RunGitCommand},
+		"go-git": &goGitBackend{},
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			commit, err := backend.ResolveCommit(ctx, dir, environmentRef("main"))
+			require.NoError(t, err)
+			assert.Equal(t, head, commit)
+			commit, err = backend.ResolveCommit(ctx, dir, "main~1")
+			require.NoError(t, err)
+			assert.Equal(t, first, commit)
+			_, err = backend.ResolveCommit(ctx, dir, environmentRef("missing"))
+			assert.ErrorIs(t, err, errRevisionNotFound)
+
+			data, err := backend.ReadBlob(ctx, dir, first, "README.md")
+			require.NoError(t, err)
+			assert.Equal(t, "# app\n", string(data))
+			data, err = backend.ReadBlob(ctx, dir, "main", ".container-use/environment.json")
+			require.NoError(t, err)
+			assert.Equal(t, "{}\n", string(data))
+			_, err = backend.ReadBlob(ctx, dir, "main", ".container-use/missing.json")
+			assert.True(t, os.IsNotExist(err))
+
+			note, err := backend.ReadNote(ctx, dir, gitNotesStateRef, "main")
+			require.NoError(t, err)
+			assert.Equal(t, `{"title":"test"}`, strings.TrimSpace(note))
+			_, err = backend.ReadNote(ctx, dir, gitNotesStateRef, first)
+			assert.ErrorIs(t, err, errNoteNotFound)
+			_, err = backend.ReadNote(ctx, dir, gitNotesLogRef, "main")
+			assert.ErrorIs(t, err, errNoteNotFound)
+		})
+	}
+}
+
+// failingGitBackend fails every read with err.
+type failingGitBackend struct{ err error }
+
+func (b *failingGitBackend) ResolveCommit(context.Context, string, string) (string, error) {
+	return "", b.err
+}
+
+func (b *failingGitBackend) ReadBlob(context.Context, string, string, string) ([]byte, error) {
+	return nil, b.err
+}
+
+func (b *failingGitBackend) ReadNote(context.Context, string, string, string) (string, error) {
+	return "", b.err
+}
+
+func TestFallbackGitBackend(t *testing.T) {
+	ctx := context.Background()
+	fallback := &failingGitBackend{err: errors.New("fallback")}
+
+	backend := &fallbackGitBackend{primary: &failingGitBackend{err: errors.New("unsupported")}, fallback: fallback}
+	_, err := backend.ResolveCommit(ctx, "", "main")
+	assert.EqualError(t, err, "fallback")
+	_, err = backend.ReadBlob(ctx, "", "main", "README.md")
+	assert.EqualError(t, err, "fallback")
+	_, err = backend.ReadNote(ctx, "", gitNotesStateRef, "main")
+	assert.EqualError(t, err, "fallback")
+
+	// What doesn't exist doesn't exist with git either
+	backend = &fallbackGitBackend{primary: &failingGitBackend{err: errRevisionNotFound}, fallback: fallback}
+	_, err = backend.ResolveCommit(ctx, "", "main")
+	assert.ErrorIs(t, err, errRevisionNotFound)
+	backend = &fallbackGitBackend{primary: &failingGitBackend{err: os.ErrNotExist}, fallback: fallback}
+	_, err = backend.ReadBlob(ctx, "", "main", "README.md")
+	assert.True(t, os.IsNotExist(err))
+	backend = &fallbackGitBackend{primary: &failingGitBackend{err: errNoteNotFound}, fallback: fallback}
+	_, err = backend.ReadNote(ctx, "", gitNotesStateRef, "main")
+	assert.ErrorIs(t, err, errNoteNotFound)
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// managedGitSettings are the settings git runs with in managed storage (the fork repository and the
+// worktrees of environments), instead of the global and system configuration of the user: signing,
+// hooks, aliases, fsmonitor or a custom status configuration cause unpredictable failures there.
+var managedGitSettings = []string{
+	"core.hooksPath=/dev/null",
+	"core.fsmonitor=false",
+	"core.untrackedCache=false",
+	"core.autocrlf=false",
+	"commit.gpgSign=false",
+	"tag.gpgSign=false",
+	"status.showUntrackedFiles=normal",
+}
+
+// inheritedGitSettings are the settings of the user that are kept in managed storage.
+var inheritedGitSettings = []string{"user.name", "user.email"}
+
+// isolatedGitVariables are variables of the caller that would make git operate on another repository
+// or with another configuration.
+var isolatedGitVariables = []string{
+	"GIT_DIR", "GIT_WORK_TREE", "GIT_INDEX_FILE", "GIT_COMMON_DIR", "GIT_OBJECT_DIRECTORY",
+	"GIT_CONFIG", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_SYSTEM", "GIT_CONFIG_NOSYSTEM", "GIT_CONFIG_PARAMETERS", "GIT_CONFIG_COUNT",
+}
+
+// managedGit runs git in dir, which must be in managed storage, with an isolated configuration:
+// see managedGitSettings.
+func (r *Repository) managedGit(ctx context.Context, dir string, args ...string) (string, error) {
+	return runGitCommand(ctx, dir, r.managedGitEnv(ctx), args...)
+}
+
+// managedGitEnv returns the environment managedGit runs git with, computed once per repository.
+// The global and system configurations are replaced by the managed settings and the inherited
+// settings of the user, passed with GIT_CONFIG_COUNT so that they take precedence over the
+// configuration of the repository as well.
+func (r *Repository) managedGitEnv(ctx context.Context) []string {
+	r.gitEnvOnce.Do(func() {
+		settings := append([]string{}, managedGitSettings...)
+		for _, key := range inheritedGitSettings {
+			// Settings the user doesn't have are left unset
+			if value, err := RunGitCommand(ctx, r.userRepoPath, "config", "--get", key); err == nil {
+				settings = append(settings, key+"="+strings.TrimSpace(value))
+			}
+		}
+		r.gitEnv = isolatedGitEnv(os.Environ(), settings)
+	})
+	return r.gitEnv
+}
+
+// isolatedGitEnv returns environ without the variables affecting git, configuring git with settings only.
+func isolatedGitEnv(environ []string, settings []string) []string {
+	env := []string{}
+	for _, variable := range environ {
+		name, _, _ := strings.Cut(variable, "=")
+		if isGitConfigVariable(name) {
+			continue
+		}
+		env = append(env, variable)
+	}
+	env = append(env, "GIT_CONFIG_GLOBAL="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1", fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(settings)))
+	for i, setting := range settings {
+		key, value, _ := strings.Cut(setting, "=")
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, key), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, value))
+	}
+	return env
+}
+
+func isGitConfigVariable(name string) bool {
+	for _, isolated := range isolatedGitVariables {
+		if name == isolated {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "GIT_CONFIG_KEY_") || strings.HasPrefix(name, "GIT_CONFIG_VALUE_")
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is a commit of an environment along with the operations that produced it.
+type HistoryEntry struct {
+	Commit string    `json:"commit"`
+	Time   time.Time `json:"time"`
+	// Explanation is the subject of the commit, as given by the agent.
+	Explanation string `json:"explanation"`
+	// Trailers are the trailers of the commit message, such as the tool and agent that made it.
+	Trailers string `json:"trailers,omitempty"`
+	// Notes are the operations recorded since the previous commit: commands run with their output,
+	// files written, services added, configuration changes...
+	Notes string `json:"notes,omitempty"`
+	// Patch is the diff of the commit, if requested.
+	Patch string `json:"patch,omitempty"`
+}
+
+// History returns the commits of environment id that are not on the current branch, oldest first.
+// If patch is true, each entry includes the diff of its commit.
+func (r *Repository) History(ctx context.Context, id string, patch bool) ([]*HistoryEntry, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	revisionRange, err := r.revisionRange(ctx, envInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"log", "--reverse",
+		"--notes=" + gitNotesLogRef,
+		"--format=%x1e%H%x1f%cI%x1f%s%x1f%b%x1f%N%x1f",
+	}
+	if patch {
+		args = append(args, "--patch")
+	}
+	output, err := RunGitCommand(ctx, r.userRepoPath, append(args, revisionRange)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseHistory(output), nil
+}
+
+// parseHistory parses the output of the git log of History.
+func parseHistory(output string) []*HistoryEntry {
+	entries := []*HistoryEntry{}
+	for record := range strings.SplitSeq(output, "\x1e") {
+		fields := strings.Split(record, "\x1f")
+		if len(fields) != 6 {
+			continue
+		}
+		entry := &HistoryEntry{
+			Commit:      fields[0],
+			Explanation: fields[2],
+			Trailers:    strings.TrimSpace(fields[3]),
+			Notes:       strings.TrimSpace(fields[4]),
+			Patch:       strings.TrimSpace(fields[5]),
+		}
+		entry.Time, _ = time.Parse(time.RFC3339, fields[1])
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Log writes the history of environment id to w in chronological order: each commit is followed by
+// the operations that produced it and, if patch is true, by its diff.
+func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer) error {
+	entries, err := r.History(ctx, id, patch)
+	if err != nil {
+		return err
+	}
+	writeHistory(w, entries)
+	return nil
+}
+
+// RecentLog is like Log without diffs, limited to the n latest commits.
+func (r *Repository) RecentLog(ctx context.Context, id string, n int, w io.Writer) error {
+	entries, err := r.History(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	writeHistory(w, entries[max(len(entries)-n, 0):])
+	return nil
+}
+
+func writeHistory(w io.Writer, entries []*HistoryEntry) {
+	for i, entry := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		writeHistoryEntry(w, entry)
+	}
+}
+
+func writeHistoryEntry(w io.Writer, entry *HistoryEntry) {
+	fmt.Fprintf(w, "commit %s  %s\n", shortCommit(entry.Commit), entry.Time.Local().Format(time.DateTime))
+	fmt.Fprintf(w, "    %s\n", entry.Explanation)
+	if entry.Notes != "" {
+		fmt.Fprintln(w)
+		for line := range strings.Lines(entry.Notes) {
+			fmt.Fprintf(w, "    %s\n", strings.TrimRight(line, "\n"))
+		}
+	}
+	if entry.Patch != "" {
+		fmt.Fprintf(w, "\n%s\n", entry.Patch)
+	}
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHistory(t *testing.T) {
+	output := "\x1e" + "abc123\x1f2025-07-01T10:00:00Z\x1fCreate environment\x1fTool: environment_create\n\x1f\x1f\n" +
+		"\x1e" + "def456\x1f2025-07-01T12:00:00+02:00\x1fAdd login form\x1fTool: environment_file_write\nAgent: claude\n\x1f" +
+		"Write src/login.tsx\n$ npm test\nexit 1\n\x1f\n\ndiff --git a/src/login.tsx b/src/login.tsx\n+export function Login() {}\n"
+
+	entries := parseHistory(output)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, "abc123", entries[0].Commit)
+	assert.Equal(t, "Create environment", entries[0].Explanation)
+	assert.Equal(t, "Tool: environment_create", entries[0].Trailers)
+	assert.Empty(t, entries[0].Notes)
+	assert.Empty(t, entries[0].Patch)
+
+	assert.Equal(t, "def456", entries[1].Commit)
+	assert.Equal(t, 10, entries[1].Time.UTC().Hour())
+	assert.Equal(t, "Write src/login.tsx\n$ npm test\nexit 1", entries[1].Notes)
+	assert.Equal(t, "diff --git a/src/login.tsx b/src/login.tsx\n+export function Login() {}", entries[1].Patch)
+
+	var buf bytes.Buffer
+	writeHistoryEntry(&buf, entries[1])
+	assert.Contains(t, buf.String(), "commit def456")
+	assert.Contains(t, buf.String(), "    Add login form\n\n    Write src/login.tsx\n    $ npm test\n")
+	assert.Contains(t, buf.String(), "\n\ndiff --git")
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// maxJobs is the number of finished jobs kept per environment.
+const maxJobs = 50
+
+// savedJob is a job as saved by SaveJob, along with the process it runs in.
+type savedJob struct {
+	*environment.Job
+	PID int `json:"pid"`
+}
+
+func (r *Repository) jobPath(id string) (string, error) {
+	dir, err := r.dataPath("jobs")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id), nil
+}
+
+// SaveJob records the state of a job of environment id and, if not empty, its output, so that its result
+// can be read with Jobs and JobOutput once it finished, even from another process. Only the most recent
+// finished jobs are kept.
+func (r *Repository) SaveJob(id string, job *environment.Job, output string) error {
+	dir, err := r.jobPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(savedJob{Job: job, PID: os.Getpid()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, job.ID+".json"), data, 0644); err != nil {
+		return err
+	}
+	if output != "" {
+		if err := os.WriteFile(filepath.Join(dir, job.ID+".log"), []byte(output), 0644); err != nil {
+			return err
+		}
+	}
+
+	jobs, err := r.Jobs(id)
+	if err != nil {
+		return err
+	}
+	finished := []*environment.Job{}
+	for _, job := range jobs {
+		if job.Status != environment.JobRunning {
+			finished = append(finished, job)
+		}
+	}
+	for _, old := range finished[:max(0, len(finished)-maxJobs)] {
+		for _, ext := range []string{".json", ".log"} {
+			if err := os.Remove(filepath.Join(dir, old.ID+ext)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Jobs lists the jobs of environment id, oldest first. Jobs started by this process are reported in their
+// current state. Jobs recorded as running whose process exited before they finished are reported as lost.
+func (r *Repository) Jobs(id string) ([]*environment.Job, error) {
+	dir, err := r.jobPath(id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	jobs := []*environment.Job{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		saved := savedJob{Job: &environment.Job{}}
+		if err := json.Unmarshal(data, &saved); err != nil {
+			continue
+		}
+		job := saved.Job
+		if tracked, ok := environment.TrackedJob(id, job.ID); ok {
+			job = tracked
+		} else if job.Status == environment.JobRunning && (saved.PID == os.Getpid() || !processAlive(saved.PID)) {
+			job.Status = environment.JobLost
+		}
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs, nil
+}
+
+// Job returns job jobID of environment id.
+func (r *Repository) Job(id, jobID string) (*environment.Job, error) {
+	jobs, err := r.Jobs(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.ID == jobID {
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("job %q not found in environment %q", jobID, id)
+}
+
+// JobOutput returns the saved output of a finished job. If tail is positive, only the last tail lines are returned.
+func (r *Repository) JobOutput(id, jobID string, tail int) (string, error) {
+	dir, err := r.jobPath(id)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, jobID+".log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	output := string(data)
+	if tail > 0 {
+		lines := strings.SplitAfter(strings.TrimSuffix(output, "\n"), "\n")
+		output = strings.Join(lines[max(0, len(lines)-tail):], "")
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+	}
+	return output, nil
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func (r *Repository) deleteJobs(id string) error {
+	dir, err := r.jobPath(id)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryJobs(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+
+	start := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	finished := start.Add(time.Minute)
+	exitCode := 1
+	require.NoError(t, repo.SaveJob("busy-env", &environment.Job{
+		ID:         "tests",
+		Command:    "go test ./...",
+		StartedAt:  start,
+		FinishedAt: &finished,
+		Status:     environment.JobFailed,
+		ExitCode:   &exitCode,
+	}, "ok  pkg/a\nFAIL pkg/b\nFAIL\n"))
+	// Recorded as running, but not tracked by this process: the process running it exited
+	require.NoError(t, repo.SaveJob("busy-env", &environment.Job{
+		ID:        "migration",
+		Command:   "./migrate.sh",
+		StartedAt: start.Add(time.Hour),
+		Status:    environment.JobRunning,
+	}, ""))
+
+	jobs, err := repo.Jobs("busy-env")
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "tests", jobs[0].ID)
+	assert.Equal(t, environment.JobFailed, jobs[0].Status)
+	require.NotNil(t, jobs[0].ExitCode)
+	assert.Equal(t, 1, *jobs[0].ExitCode)
+	assert.Equal(t, "migration", jobs[1].ID)
+	assert.Equal(t, environment.JobLost, jobs[1].Status)
+
+	output, err := repo.JobOutput("busy-env", "tests", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "ok  pkg/a\nFAIL pkg/b\nFAIL\n", output)
+	output, err = repo.JobOutput("busy-env", "tests", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "FAIL pkg/b\nFAIL\n", output)
+
+	_, err = repo.Job("busy-env", "nope")
+	assert.Error(t, err)
+	jobs, err = repo.Jobs("other-env")
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+
+	require.NoError(t, repo.deleteJobs("busy-env"))
+	jobs, err = repo.Jobs("busy-env")
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+}
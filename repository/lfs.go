@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const gitAttributesFile = ".gitattributes"
+
+// trackWithLFS makes git store files, paths relative to worktreePath, with Git LFS: the ones .gitattributes
+// doesn't route through LFS yet get a line of their own. Their contents are kept in the fork repository,
+// which the source repository fetches them from like any other LFS remote.
+func (r *Repository) trackWithLFS(ctx context.Context, worktreePath string, files []string) error {
+	if _, err := r.managedGit(ctx, worktreePath, "lfs", "version"); err != nil {
+		return fmt.Errorf("binary files are committed with Git LFS, but git-lfs is not installed: %w", err)
+	}
+	// Hooks are disabled in managed storage, only the filters are needed
+	if _, err := r.managedGit(ctx, worktreePath, "lfs", "install", "--local", "--skip-repo"); err != nil {
+		return err
+	}
+
+	out, err := r.managedGit(ctx, worktreePath, append([]string{"check-attr", "-z", "filter", "--"}, files...)...)
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	fields := strings.Split(out, "\x00")
+	// check-attr -z prints the path, the attribute and its value of each file
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] != "lfs" {
+			lines = append(lines, lfsAttributes(fields[i]))
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	path := filepath.Join(worktreePath, gitAttributesFile)
+	attributes, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(attributes) > 0 && attributes[len(attributes)-1] != '\n' {
+		attributes = append(attributes, '\n')
+	}
+	attributes = append(attributes, strings.Join(lines, "\n")+"\n"...)
+	return os.WriteFile(path, attributes, 0644)
+}
+
+// lfsAttributes returns the line of .gitattributes routing the file at name, and only it, through LFS.
+func lfsAttributes(name string) string {
+	var pattern strings.Builder
+	pattern.WriteString("/")
+	for _, c := range name {
+		switch c {
+		case '\\', '*', '?', '[', ']', '!', '#':
+			pattern.WriteRune('\\')
+			pattern.WriteRune(c)
+		case ' ', '\t':
+			pattern.WriteString("[[:space:]]")
+		default:
+			pattern.WriteRune(c)
+		}
+	}
+	return pattern.String() + " filter=lfs diff=lfs merge=lfs -text"
+}
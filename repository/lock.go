@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// lockWait is how long updating an environment waits for another update of it to finish before giving
+	// up with an *EnvironmentBusyError.
+	lockWait = 10 * time.Second
+	// lockStaleAfter is how long a lock goes without being refreshed before it is considered abandoned, even
+	// if its holder still runs: holders refresh their locks every lockRefreshInterval.
+	lockStaleAfter = 2 * time.Minute
+	// repositoryLockWait is how long operations on the fork repository wait for those of other processes.
+	repositoryLockWait = 30 * time.Second
+
+	lockPollInterval = 100 * time.Millisecond
+
+	// gitLockRetries is how many times git commands failing to take a lock of the repository are retried.
+	gitLockRetries = 6
+	// gitLockBackoff is how long the first retry waits, doubling for every next one.
+	gitLockBackoff = 50 * time.Millisecond
+)
+
+// lockRefreshInterval is how often holders refresh their locks, so that updates outlasting lockStaleAfter
+// keep them.
+var lockRefreshInterval = 30 * time.Second
+
+// EnvironmentBusyError reports that an environment is being updated by someone else: another agent, or
+// another tool call of the same agent.
+type EnvironmentBusyError struct {
+	ID string
+	// PID and Host identify the process holding the lock of the environment, since AcquiredAt.
+	PID        int
+	Host       string
+	AcquiredAt time.Time
+}
+
+func (e *EnvironmentBusyError) Error() string {
+	return fmt.Sprintf("environment %s is busy: another update of it, by process %d on %s, has been in progress since %s. Nothing was saved, retry once it is done",
+		e.ID, e.PID, e.Host, e.AcquiredAt.Format(time.RFC3339))
+}
+
+// environmentLock is the content of a lock file.
+type environmentLock struct {
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	// RefreshedAt is when the holder last showed that it still holds the lock.
+	RefreshedAt time.Time `json:"refreshed_at,omitzero"`
+	// Token tells locks apart, so that a holder only ever releases its own.
+	Token string `json:"token"`
+}
+
+// stale reports whether the holder of the lock is gone: the process died without releasing it, or it
+// stopped refreshing the lock, e.g. because it hangs or runs on another host that went away.
+func (l *environmentLock) stale(host string) bool {
+	refreshed := l.RefreshedAt
+	if refreshed.IsZero() {
+		refreshed = l.AcquiredAt
+	}
+	if time.Since(refreshed) > lockStaleAfter {
+		return true
+	}
+	return l.Host == host && !processAlive(l.PID)
+}
+
+// heldLocksKey is the context key of the paths of the locks held by the caller, see lockEnvironment.
+type heldLocksKey struct{}
+
+// lockEnvironment takes the advisory lock of environment id, shared by all the container-use processes of
+// the user, so that updates of the environment don't interleave in its worktree and git index. It waits up
+// to lockWait for the current holder, then fails with an *EnvironmentBusyError. Locks left by processes that
+// died are taken over.
+//
+// The returned context records that the lock is held: locking the environment again with it is a no-op, so
+// that operations taking the lock can call each other.
+func (r *Repository) lockEnvironment(ctx context.Context, id string) (context.Context, func(), error) {
+	dir, err := r.dataPath("locks")
+	if err != nil {
+		return nil, nil, err
+	}
+	return holdLock(ctx, filepath.Join(dir, id+".lock"), id, lockWait)
+}
+
+// lockRepository takes the advisory lock of the fork repository, shared like those of environments, around
+// the operations changing its structure rather than a single environment: creating the fork, adding and
+// pruning worktrees, deleting branches and collecting garbage. Environments created at the same time by
+// several processes then don't prune each other's worktrees or race to create the same branch. Like
+// lockEnvironment, locking again with the returned context is a no-op.
+func (r *Repository) lockRepository(ctx context.Context) (context.Context, func(), error) {
+	dir, err := r.dataPath("locks")
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, unlock, err := holdLock(ctx, filepath.Clean(dir)+".lock", filepath.Base(r.forkRepoPath), repositoryLockWait)
+	var busy *EnvironmentBusyError
+	if errors.As(err, &busy) {
+		return nil, nil, fmt.Errorf("the repository is busy: process %d on %s has been changing it since %s. Nothing was changed, retry once it is done",
+			busy.PID, busy.Host, busy.AcquiredAt.Format(time.RFC3339))
+	}
+	return ctx, unlock, err
+}
+
+// holdLock takes the lock file at path unless ctx records that it is held, and returns a context recording it.
+func holdLock(ctx context.Context, path, name string, wait time.Duration) (context.Context, func(), error) {
+	parent, _ := ctx.Value(heldLocksKey{}).(map[string]bool)
+	if parent[path] {
+		return ctx, func() {}, nil
+	}
+	unlock, err := acquireLock(ctx, path, name, wait)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	held := map[string]bool{path: true}
+	for lockPath := range parent {
+		held[lockPath] = true
+	}
+	return context.WithValue(ctx, heldLocksKey{}, held), unlock, nil
+}
+
+// acquireLock creates the lock file at path, waiting up to wait for it to be released if it exists. name is
+// what the lock protects, the environment ID of the *EnvironmentBusyError returned once wait elapsed.
+func acquireLock(ctx context.Context, path, name string, wait time.Duration) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	lock := &environmentLock{PID: os.Getpid(), Host: host, Token: hex.EncodeToString(token)}
+
+	deadline := time.Now().Add(wait)
+	for {
+		lock.AcquiredAt = time.Now()
+		lock.RefreshedAt = lock.AcquiredAt
+		created, err := createLockFile(path, lock)
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			stop := refreshLock(path, *lock)
+			return func() {
+				stop()
+				releaseLock(path, lock.Token)
+			}, nil
+		}
+
+		holder, err := readLockFile(path)
+		if os.IsNotExist(err) {
+			// Released meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if holder.stale(host) {
+			slog.Warn("Taking over a stale lock", "lock", name, "pid", holder.PID, "host", holder.Host,
+				"acquired_at", holder.AcquiredAt, "refreshed_at", holder.RefreshedAt)
+			releaseStaleLock(path, holder.Token, host)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, &EnvironmentBusyError{ID: name, PID: holder.PID, Host: holder.Host, AcquiredAt: holder.AcquiredAt}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// createLockFile creates the lock file at path holding lock, and reports whether it didn't exist. The file
+// is written aside and linked into place, so that its content is complete once it exists.
+func createLockFile(path string, lock *environmentLock) (bool, error) {
+	tmp, err := writeLockAside(path, lock)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// writeLockAside writes lock to a temporary file next to path, and returns its path.
+func writeLockAside(path string, lock *environmentLock) (string, error) {
+	data, err := json.Marshal(lock)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// refreshLock refreshes the lock file at path every lockRefreshInterval while it holds lock, until the returned
+// function is called, so that other processes don't take it over as stale in the middle of a long update.
+func refreshLock(path string, lock environmentLock) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			current, err := readLockFile(path)
+			if err != nil || current.Token != lock.Token {
+				slog.Warn("Lost a lock while holding it", "path", path, "err", err)
+				return
+			}
+			lock.RefreshedAt = time.Now()
+			if err := replaceLockFile(path, &lock); err != nil {
+				slog.Warn("Failed to refresh lock", "path", path, "err", err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// replaceLockFile writes lock to the lock file at path, which is replaced at once so that readers never see
+// it partially written.
+func replaceLockFile(path string, lock *environmentLock) error {
+	tmp, err := writeLockAside(path, lock)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func readLockFile(path string) (*environmentLock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lock := &environmentLock{}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("invalid lock file %s, remove it if no update is in progress: %w", path, err)
+	}
+	return lock, nil
+}
+
+// releaseLock removes the lock file at path, if it is still the lock identified by token.
+func releaseLock(path, token string) {
+	removeLockIf(path, func(lock *environmentLock) bool {
+		return lock.Token == token
+	})
+}
+
+// releaseStaleLock removes the lock file at path, if it is still the lock identified by token and still stale
+// as seen from host: its holder may have refreshed it since it was found stale.
+func releaseStaleLock(path, token, host string) {
+	removeLockIf(path, func(lock *environmentLock) bool {
+		return lock.Token == token && lock.stale(host)
+	})
+}
+
+// removeLockIf removes the lock file at path if remove approves of the lock it holds. The file is moved aside
+// first, which only one process can do, so that the lock checked is the lock removed even if another process
+// replaces the file meanwhile. A lock that isn't to be removed is put back, unless the lock was taken again
+// in the meantime.
+func removeLockIf(path string, remove func(*environmentLock) bool) {
+	aside, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".release-*")
+	if err != nil {
+		slog.Warn("Failed to release lock", "path", path, "err", err)
+		return
+	}
+	aside.Close()
+	defer os.Remove(aside.Name())
+
+	if err := os.Rename(path, aside.Name()); err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to release lock", "path", path, "err", err)
+		}
+		return
+	}
+	if lock, err := readLockFile(aside.Name()); err == nil && remove(lock) {
+		return
+	}
+	if err := os.Link(aside.Name(), path); err != nil && !os.IsExist(err) {
+		slog.Warn("Failed to put back a lock", "path", path, "err", err)
+	}
+}
+
+// gitLockErrors are what git prints when a lock of the repository, e.g. the index, a ref or the
+// configuration, is held by another git process. Nothing was changed: running the command again is safe.
+var gitLockErrors = []string{
+	".lock': File exists",
+	"cannot lock ref",
+	"could not lock config file",
+	"Another git process seems to be running",
+}
+
+// isLockContention reports whether the output of a failed git command says that it gave up on a lock.
+func isLockContention(output string) bool {
+	for _, message := range gitLockErrors {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepBeforeRetry waits before retry attempt+1 of a git command, with an exponential backoff and some
+// jitter so that processes contending for a lock don't retry in lockstep. It reports false if ctx is done.
+func sleepBeforeRetry(ctx context.Context, attempt int) bool {
+	backoff := gitLockBackoff << attempt
+	backoff += time.Duration(mathrand.Int64N(int64(backoff) / 2))
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(backoff):
+		return true
+	}
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "locks", "fancy-mallard.lock")
+
+	unlock, err := acquireLock(ctx, path, "fancy-mallard", time.Second)
+	require.NoError(t, err)
+	_, err = acquireLock(ctx, path, "fancy-mallard", 200*time.Millisecond)
+	var busy *EnvironmentBusyError
+	require.True(t, errors.As(err, &busy), "expected an EnvironmentBusyError, got %v", err)
+	assert.Equal(t, "fancy-mallard", busy.ID)
+	assert.Equal(t, os.Getpid(), busy.PID)
+	assert.Contains(t, err.Error(), "environment fancy-mallard is busy")
+
+	released := make(chan struct{})
+	go func(unlock func()) {
+		time.Sleep(200 * time.Millisecond)
+		unlock()
+		close(released)
+	}(unlock)
+	unlock, err = acquireLock(ctx, path, "fancy-mallard", 5*time.Second)
+	require.NoError(t, err, "the lock is taken once released")
+	<-released
+	unlock()
+	assert.NoFileExists(t, path)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	writeLock(t, path, &environmentLock{PID: os.Getpid(), Host: "elsewhere", AcquiredAt: time.Now(), Token: "theirs"})
+	cancel()
+	_, err = acquireLock(cancelled, path, "fancy-mallard", time.Minute)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestAcquireStaleLock(t *testing.T) {
+	ctx := context.Background()
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name  string
+		lock  *environmentLock
+		stale bool
+	}{
+		{name: "dead_process", lock: &environmentLock{PID: 1 << 30, Host: host, AcquiredAt: time.Now()}, stale: true},
+		{name: "expired", lock: &environmentLock{PID: os.Getpid(), Host: "elsewhere", AcquiredAt: time.Now().Add(-time.Hour)}, stale: true},
+		{name: "other_host", lock: &environmentLock{PID: 1 << 30, Host: "elsewhere", AcquiredAt: time.Now()}},
+		{name: "refreshed", lock: &environmentLock{PID: 1 << 30, Host: "elsewhere", AcquiredAt: time.Now().Add(-time.Hour), RefreshedAt: time.Now()}},
+		{name: "live_process_not_refreshed", lock: &environmentLock{PID: os.Getpid(), Host: host, AcquiredAt: time.Now().Add(-time.Hour)}, stale: true},
+		{name: "live_process", lock: &environmentLock{PID: os.Getpid(), Host: host, AcquiredAt: time.Now()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fancy-mallard.lock")
+			tc.lock.Token = "theirs"
+			writeLock(t, path, tc.lock)
+
+			unlock, err := acquireLock(ctx, path, "fancy-mallard", 0)
+			if !tc.stale {
+				var busy *EnvironmentBusyError
+				assert.True(t, errors.As(err, &busy), "expected an EnvironmentBusyError, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			defer unlock()
+			lock, err := readLockFile(path)
+			require.NoError(t, err)
+			assert.NotEqual(t, "theirs", lock.Token)
+		})
+	}
+}
+
+func TestRefreshLock(t *testing.T) {
+	defer func(interval time.Duration) { lockRefreshInterval = interval }(lockRefreshInterval)
+	lockRefreshInterval = 10 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "fancy-mallard.lock")
+
+	unlock, err := acquireLock(context.Background(), path, "fancy-mallard", 0)
+	require.NoError(t, err)
+	acquired, err := readLockFile(path)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		lock, err := readLockFile(path)
+		return err == nil && lock.RefreshedAt.After(acquired.RefreshedAt) && lock.Token == acquired.Token
+	}, 5*time.Second, 10*time.Millisecond, "the holder refreshes the lock")
+
+	unlock()
+	assert.NoFileExists(t, path)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no temporary lock file is left behind")
+}
+
+func TestReleaseLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fancy-mallard.lock")
+	writeLock(t, path, &environmentLock{PID: os.Getpid(), AcquiredAt: time.Now(), Token: "theirs"})
+
+	releaseLock(path, "mine")
+	lock, err := readLockFile(path)
+	require.NoError(t, err, "only the holder releases the lock")
+	assert.Equal(t, "theirs", lock.Token)
+	releaseStaleLock(path, "theirs", "localhost")
+	assert.FileExists(t, path, "a lock is only taken over while stale")
+	releaseLock(path, "theirs")
+	assert.NoFileExists(t, path)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no lock is left aside")
+}
+
+func TestLockEnvironment(t *testing.T) {
+	repo := &Repository{basePath: t.TempDir(), forkRepoPath: "/repos/project"}
+
+	ctx, unlock, err := repo.lockEnvironment(context.Background(), "fancy-mallard")
+	require.NoError(t, err)
+	dir, err := repo.dataPath("locks")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "fancy-mallard.lock"))
+
+	nested, unlockNested, err := repo.lockEnvironment(ctx, "fancy-mallard")
+	require.NoError(t, err, "the caller already holds the lock")
+	unlockNested()
+	assert.FileExists(t, filepath.Join(dir, "fancy-mallard.lock"), "the lock is held until the outermost caller releases it")
+
+	_, unlockOther, err := repo.lockEnvironment(nested, "shiny-heron")
+	require.NoError(t, err)
+	unlockOther()
+
+	unlock()
+	assert.NoFileExists(t, filepath.Join(dir, "fancy-mallard.lock"))
+}
+
+func TestLockRepository(t *testing.T) {
+	repo := &Repository{basePath: t.TempDir(), forkRepoPath: "/repos/project"}
+
+	ctx, unlock, err := repo.lockRepository(context.Background())
+	require.NoError(t, err)
+	_, unlockEnvironment, err := repo.lockEnvironment(ctx, "repository")
+	require.NoError(t, err, "the lock of the repository is not that of an environment")
+	unlockEnvironment()
+	_, unlockNested, err := repo.lockRepository(ctx)
+	require.NoError(t, err)
+	unlockNested()
+	unlock()
+
+	dir, err := repo.dataPath("locks")
+	require.NoError(t, err)
+	assert.NoFileExists(t, dir+".lock")
+}
+
+func TestConcurrentWorktrees(t *testing.T) {
+	ctx := context.Background()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"commit", "--allow-empty", "-m", "Initial commit"},
+	} {
+		_, err := RunGitCommand(ctx, userRepo, args...)
+		require.NoError(t, err)
+	}
+
+	// Each repository stands for a server process of its own
+	repos := make([]*Repository, 3)
+	errs := make(chan error, len(repos)*4)
+	var wg sync.WaitGroup
+	for i := range repos {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+			if err != nil {
+				errs <- err
+				return
+			}
+			repos[i] = repo
+			for _, id := range []string{fmt.Sprintf("env-%d", i), "shared-env"} {
+				_, err := repo.initializeWorktree(ctx, id)
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	worktrees, err := repos[0].managedGit(ctx, repos[0].forkRepoPath, "worktree", "list", "--porcelain")
+	require.NoError(t, err)
+	for _, id := range []string{"env-0", "env-1", "env-2", "shared-env"} {
+		worktree, err := repos[0].WorktreePath(id)
+		require.NoError(t, err)
+		assert.Contains(t, worktrees, "worktree "+worktree+"\n")
+		assert.Contains(t, worktrees, "branch refs/heads/"+id+"\n")
+		_, err = RunGitCommand(ctx, userRepo, "rev-parse", "--verify", "refs/remotes/container-use/"+id)
+		assert.NoError(t, err)
+	}
+	_, err = repos[0].managedGit(ctx, repos[0].forkRepoPath, "fsck", "--no-dangling")
+	assert.NoError(t, err)
+}
+
+func TestRunGitCommandRetriesLocks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	_, err := RunGitCommand(ctx, dir, "init")
+	require.NoError(t, err)
+	writeFile(t, dir, "main.go", "package main\n")
+
+	lock := filepath.Join(dir, ".git", "index.lock")
+	require.NoError(t, os.WriteFile(lock, nil, 0644))
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		os.Remove(lock)
+	}()
+	_, err = RunGitCommand(ctx, dir, "add", "main.go")
+	require.NoError(t, err, "the command is retried once the lock is released")
+	status, err := RunGitCommand(ctx, dir, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Equal(t, "A  main.go\n", status)
+}
+
+func TestIsLockContention(t *testing.T) {
+	for output, expected := range map[string]bool{
+		"fatal: Unable to create '/repo/.git/index.lock': File exists.\n\nAnother git process seems to be running in this repository": true,
+		"error: cannot lock ref 'refs/notes/container-use-state': is at 1a2b3c but expected 4d5e6f":                                   true,
+		"error: could not lock config file .git/config: File exists":                                                                  true,
+		"fatal: not a git repository (or any of the parent directories): .git":                                                        false,
+		"error: pathspec 'missing' did not match any file(s) known to git":                                                            false,
+	} {
+		assert.Equal(t, expected, isLockContention(output), output)
+	}
+}
+
+func writeLock(t *testing.T, path string, lock *environmentLock) {
+	t.Helper()
+	data, err := json.Marshal(lock)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, data, 0644))
+}
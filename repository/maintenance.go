@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaintenanceReport summarizes the outcome of a maintenance run.
+type MaintenanceReport struct {
+	// SizeBefore and SizeAfter are the on-disk sizes (in bytes) of the managed storage.
+	SizeBefore int64
+	SizeAfter  int64
+
+	// PrunedWorktrees lists orphaned worktrees that were removed.
+	PrunedWorktrees []string
+}
+
+// Reclaimed returns the number of bytes freed by the maintenance run.
+func (m *MaintenanceReport) Reclaimed() int64 {
+	return max(m.SizeBefore-m.SizeAfter, 0)
+}
+
+// Maintenance compacts the managed fork repository.
+// It removes orphaned worktrees left behind by deleted environments, expires reflogs,
+// prunes unreachable objects and repacks the remaining ones.
+// When aggressive is set, git gc is run with --aggressive, which is slower but yields smaller packs.
+//
+// Reflogs and unreachable objects are expired with the grace periods of git: environments are updated
+// under their own lock rather than that of the repository, and the objects of an update in progress are
+// unreachable until it commits.
+func (r *Repository) Maintenance(ctx context.Context, aggressive bool) (*MaintenanceReport, error) {
+	ctx, unlock, err := r.lockRepository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	report := &MaintenanceReport{}
+
+	sizeBefore, err := r.storageSize()
+	if err != nil {
+		return nil, err
+	}
+	report.SizeBefore = sizeBefore
+
+	pruned, err := r.pruneOrphanedWorktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.PrunedWorktrees = pruned
+
+	if _, err := r.managedGit(ctx, r.forkRepoPath, "worktree", "prune"); err != nil {
+		return nil, err
+	}
+
+	gcArgs := []string{"gc", "--quiet"}
+	if aggressive {
+		gcArgs = append(gcArgs, "--aggressive")
+	}
+	if _, err := r.managedGit(ctx, r.forkRepoPath, gcArgs...); err != nil {
+		return nil, err
+	}
+
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "remote", "prune", containerUseRemote); err != nil {
+		return nil, err
+	}
+
+	sizeAfter, err := r.storageSize()
+	if err != nil {
+		return nil, err
+	}
+	report.SizeAfter = sizeAfter
+
+	return report, nil
+}
+
+// storageSize returns the size of the fork repository plus the worktrees that belong to it.
+func (r *Repository) storageSize() (int64, error) {
+	size, err := dirSize(r.forkRepoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	worktrees, err := r.ownedWorktrees()
+	if err != nil {
+		return 0, err
+	}
+	for _, worktree := range worktrees {
+		worktreeSize, err := dirSize(worktree)
+		if err != nil {
+			return 0, err
+		}
+		size += worktreeSize
+	}
+
+	return size, nil
+}
+
+// ownedWorktrees returns the paths of all worktrees whose .git pointer references this fork repository,
+// including the persistent copies of the overlay storage.
+func (r *Repository) ownedWorktrees() ([]string, error) {
+	roots, err := r.worktreeRoots()
+	if err != nil {
+		return nil, err
+	}
+
+	worktrees := []string{}
+	for _, worktreesDir := range roots {
+		entries, err := os.ReadDir(worktreesDir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			worktree := filepath.Join(worktreesDir, entry.Name())
+			pointer, err := os.ReadFile(filepath.Join(worktree, ".git"))
+			if err != nil {
+				continue
+			}
+			gitDir := strings.TrimSpace(strings.TrimPrefix(string(pointer), "gitdir:"))
+			if filepath.Dir(filepath.Dir(gitDir)) != r.forkRepoPath {
+				continue
+			}
+			worktrees = append(worktrees, worktree)
+		}
+	}
+
+	return worktrees, nil
+}
+
+// OrphanedWorktrees returns the IDs of the worktrees belonging to this repository whose environment
+// branch no longer exists. Maintenance removes them.
+func (r *Repository) OrphanedWorktrees(ctx context.Context) ([]string, error) {
+	worktrees, err := r.orphanedWorktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, worktree := range worktrees {
+		ids = append(ids, filepath.Base(worktree))
+	}
+	return ids, nil
+}
+
+func (r *Repository) orphanedWorktrees(ctx context.Context) ([]string, error) {
+	worktrees, err := r.ownedWorktrees()
+	if err != nil {
+		return nil, err
+	}
+
+	orphaned := []string{}
+	for _, worktree := range worktrees {
+		id := filepath.Base(worktree)
+		if _, err := r.managedGit(ctx, r.forkRepoPath, "show-ref", "--verify", "--quiet", "refs/heads/"+id); err == nil {
+			continue
+		}
+		orphaned = append(orphaned, worktree)
+	}
+	return orphaned, nil
+}
+
+// pruneOrphanedWorktrees removes worktrees belonging to this repository whose environment branch no longer exists.
+func (r *Repository) pruneOrphanedWorktrees(ctx context.Context) ([]string, error) {
+	worktrees, err := r.orphanedWorktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []string{}
+	for _, worktree := range worktrees {
+		slog.Info("Removing orphaned worktree", "repo", r.forkRepoPath, "worktree", worktree)
+		if err := os.RemoveAll(worktree); err != nil {
+			return nil, err
+		}
+		pruned = append(pruned, filepath.Base(worktree))
+	}
+
+	return pruned, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
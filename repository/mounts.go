@@ -0,0 +1,11 @@
+package repository
+
+// allowedMounts returns the host directories environments may mount, from the [mounts] section of the
+// global configuration.
+func (r *Repository) allowedMounts() ([]string, error) {
+	config, err := LoadConfig(ConfigPath(r.basePath))
+	if err != nil {
+		return nil, err
+	}
+	return config.Mounts.Allow, nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedMounts(t *testing.T) {
+	r := &Repository{basePath: t.TempDir()}
+	allowed, err := r.allowedMounts()
+	require.NoError(t, err)
+	assert.Empty(t, allowed, "nothing may be mounted without configuration")
+
+	config := "[tools]\nread_only = true\n\n[mounts]\nallow = [\"~/datasets\", \"/opt/toolchains\"]\n"
+	require.NoError(t, os.WriteFile(ConfigPath(r.basePath), []byte(config), 0o644))
+	allowed, err = r.allowedMounts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"~/datasets", "/opt/toolchains"}, allowed)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := LoadConfig(filepath.Join(dir, "missing.toml"))
+	require.NoError(t, err)
+	assert.Equal(t, &Config{}, config)
+
+	path := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+[tools]
+read_only = true
+disable = ["environment_file_search"]
+
+[mounts]
+allow = ["/opt/toolchains"]
+`), 0644))
+	config, err = LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, ToolSettings{ReadOnly: true, Disable: []string{"environment_file_search"}}, config.Tools)
+	assert.Equal(t, MountSettings{Allow: []string{"/opt/toolchains"}}, config.Mounts)
+
+	require.NoError(t, os.WriteFile(path, []byte("[tools\n"), 0644))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "invalid configuration")
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+const (
+	propagationEnv   = "CONTAINER_USE_PROPAGATION"
+	flushIntervalEnv = "CONTAINER_USE_FLUSH_INTERVAL"
+
+	// DefaultFlushInterval is how long changes are left pending with the lazy propagation, unless
+	// CONTAINER_USE_FLUSH_INTERVAL says otherwise.
+	DefaultFlushInterval = 30 * time.Second
+)
+
+// Propagation selects when the changes of environments reach their worktree and branch.
+type Propagation string
+
+const (
+	// PropagationImmediate exports, commits and fetches the changes on every update. This is the default.
+	PropagationImmediate Propagation = "immediate"
+	// PropagationLazy leaves the changes of UpdateLazily pending, only saving the state of the environment,
+	// until they are flushed: by the next Update, by Flush, or once the flush interval elapsed. The MCP
+	// servers call Flush once it elapsed, and when they stop. The environment branch lags behind the
+	// container meanwhile.
+	PropagationLazy Propagation = "lazy"
+)
+
+// propagationFromEnv returns the propagation configured with CONTAINER_USE_PROPAGATION, and the flush
+// interval of the lazy propagation configured with CONTAINER_USE_FLUSH_INTERVAL.
+func propagationFromEnv() (Propagation, time.Duration, error) {
+	var propagation Propagation
+	switch p := Propagation(os.Getenv(propagationEnv)); p {
+	case "":
+		propagation = PropagationImmediate
+	case PropagationImmediate, PropagationLazy:
+		propagation = p
+	default:
+		return "", 0, fmt.Errorf("invalid %s %q, expected one of immediate, lazy", propagationEnv, p)
+	}
+
+	interval := DefaultFlushInterval
+	if value := os.Getenv(flushIntervalEnv); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return "", 0, fmt.Errorf("invalid %s %q, expected a duration such as 30s", flushIntervalEnv, value)
+		}
+		interval = d
+	}
+	return propagation, interval, nil
+}
+
+// pendingChanges are the updates of an environment left pending by UpdateLazily.
+type pendingChanges struct {
+	// Since is when the oldest of them was made.
+	Since time.Time `json:"since"`
+	// Messages are their commit messages.
+	Messages []string `json:"messages"`
+	// Notes are the log notes they added.
+	Notes []string `json:"notes,omitempty"`
+}
+
+func (r *Repository) pendingChangesPath(id string) (string, error) {
+	dir, err := r.dataPath("pending")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// pendingChanges returns the changes of environment id left pending, nil if there are none.
+func (r *Repository) pendingChanges(id string) (*pendingChanges, error) {
+	path, err := r.pendingChangesPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pending := &pendingChanges{}
+	if err := json.Unmarshal(data, pending); err != nil {
+		return nil, fmt.Errorf("failed to read the pending changes of %s: %w", id, err)
+	}
+	return pending, nil
+}
+
+func (r *Repository) savePendingChanges(id string, pending *pendingChanges) error {
+	path, err := r.pendingChangesPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func (r *Repository) deletePendingChanges(id string) error {
+	path, err := r.pendingChangesPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// HasPendingChanges reports whether environment id has changes that didn't reach its branch yet.
+func (r *Repository) HasPendingChanges(id string) (bool, error) {
+	pending, err := r.pendingChanges(id)
+	return pending != nil, err
+}
+
+// UpdateLazily saves env like Update with the immediate propagation. With the lazy propagation, only its
+// state is saved, so that the next calls load it, and the changes are left pending: it reports whether they
+// were committed, which happens when the oldest pending change is older than the flush interval.
+func (r *Repository) UpdateLazily(ctx context.Context, env *environment.Environment, explanation string) (bool, error) {
+	if r.propagation != PropagationLazy {
+		return true, r.Update(ctx, env, explanation)
+	}
+	ctx, unlock, err := r.lockEnvironment(ctx, env.ID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	pending, err := r.pendingChanges(env.ID)
+	if err != nil {
+		return false, err
+	}
+	if pending != nil && time.Since(pending.Since) >= r.flushInterval {
+		return true, r.Update(ctx, env, explanation)
+	}
+
+	if err := r.saveLastBuild(env); err != nil {
+		return false, err
+	}
+	// The state is attached to the head of the worktree
+	if _, err := r.initializeWorktree(ctx, env.ID); err != nil {
+		return false, fmt.Errorf("failed to initialize the worktree: %w", err)
+	}
+	if err := r.saveState(ctx, env); err != nil {
+		return false, fmt.Errorf("failed to save the state: %w", err)
+	}
+
+	if pending == nil {
+		pending = &pendingChanges{Since: time.Now()}
+	}
+	pending.Messages = append(pending.Messages, explanation)
+	if note := env.Notes.Pop(); note != "" {
+		pending.Notes = append(pending.Notes, env.MaskSecrets(ctx, note))
+	}
+	slog.Info("Leaving changes pending", "environment.id", env.ID, "changes", len(pending.Messages))
+	return false, r.savePendingChanges(env.ID, pending)
+}
+
+// FlushInterval is how long changes are left pending with the lazy propagation.
+func (r *Repository) FlushInterval() time.Duration {
+	return r.flushInterval
+}
+
+// Flush commits the changes of env left pending by UpdateLazily, if any, and reports whether there were.
+func (r *Repository) Flush(ctx context.Context, env *environment.Environment) (bool, error) {
+	ctx, unlock, err := r.lockEnvironment(ctx, env.ID)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	pending, err := r.pendingChanges(env.ID)
+	if err != nil || pending == nil {
+		return false, err
+	}
+	return true, r.Update(ctx, env, "")
+}
+
+// batchCommitMessage combines the commit messages of several changes into the message of the commit made
+// for all of them: the subject lists their subjects, the trailers of all of them follow.
+func batchCommitMessage(messages []string) string {
+	messages = slices.DeleteFunc(slices.Clone(messages), func(message string) bool {
+		return strings.TrimSpace(message) == ""
+	})
+	switch len(messages) {
+	case 0:
+		return ""
+	case 1:
+		return messages[0]
+	}
+
+	subjects := []string{}
+	trailers := []string{}
+	for _, message := range messages {
+		subject, rest, _ := strings.Cut(message, "\n")
+		subjects = append(subjects, "- "+subject)
+		for _, line := range strings.Split(rest, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !slices.Contains(trailers, line) {
+				trailers = append(trailers, line)
+			}
+		}
+	}
+	first, _, _ := strings.Cut(messages[0], "\n")
+	message := fmt.Sprintf("%s, and %d more changes\n\n%s", first, len(messages)-1, strings.Join(subjects, "\n"))
+	if len(trailers) > 0 {
+		message += "\n\n" + strings.Join(trailers, "\n")
+	}
+	return message
+}
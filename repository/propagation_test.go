@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagationFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		propagation string
+		interval    string
+		expected    Propagation
+		expectedInt time.Duration
+		err         bool
+	}{
+		{name: "default", expected: PropagationImmediate, expectedInt: DefaultFlushInterval},
+		{name: "immediate", propagation: "immediate", expected: PropagationImmediate, expectedInt: DefaultFlushInterval},
+		{name: "lazy", propagation: "lazy", interval: "2m", expected: PropagationLazy, expectedInt: 2 * time.Minute},
+		{name: "lazy_without_interval", propagation: "lazy", interval: "0s", expected: PropagationLazy, expectedInt: 0},
+		{name: "invalid", propagation: "eventually", err: true},
+		{name: "invalid_interval", propagation: "lazy", interval: "often", err: true},
+		{name: "negative_interval", propagation: "lazy", interval: "-1s", err: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(propagationEnv, tc.propagation)
+			t.Setenv(flushIntervalEnv, tc.interval)
+			propagation, interval, err := propagationFromEnv()
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, propagation)
+			assert.Equal(t, tc.expectedInt, interval)
+		})
+	}
+}
+
+func TestPendingChanges(t *testing.T) {
+	repo := &Repository{basePath: t.TempDir(), forkRepoPath: "/repos/project"}
+
+	pending, err := repo.pendingChanges("fancy-mallard")
+	require.NoError(t, err)
+	assert.Nil(t, pending)
+
+	since := time.Now().Truncate(time.Second)
+	require.NoError(t, repo.savePendingChanges("fancy-mallard", &pendingChanges{
+		Since:    since,
+		Messages: []string{"Write main.go"},
+		Notes:    []string{"$ go mod tidy"},
+	}))
+	has, err := repo.HasPendingChanges("fancy-mallard")
+	require.NoError(t, err)
+	assert.True(t, has)
+	pending, err = repo.pendingChanges("fancy-mallard")
+	require.NoError(t, err)
+	assert.True(t, since.Equal(pending.Since))
+	assert.Equal(t, []string{"Write main.go"}, pending.Messages)
+	assert.Equal(t, []string{"$ go mod tidy"}, pending.Notes)
+
+	require.NoError(t, repo.deletePendingChanges("fancy-mallard"))
+	require.NoError(t, repo.deletePendingChanges("fancy-mallard"), "deleting is idempotent")
+	has, err = repo.HasPendingChanges("fancy-mallard")
+	require.NoError(t, err)
+	assert.False(t, has)
+}
+
+func TestBatchCommitMessage(t *testing.T) {
+	assert.Equal(t, "", batchCommitMessage(nil))
+	assert.Equal(t, "Add the handler\n\nTool: environment_file_write",
+		batchCommitMessage([]string{"Add the handler\n\nTool: environment_file_write", ""}))
+
+	assert.Equal(t, `Add the handler, and 2 more changes
+
+- Add the handler
+- Fix the imports
+- Remove the stub
+
+Tool: environment_file_write
+Run-Id: 42
+Operation: Edit main.go
+Tool: environment_file_edit
+Tool: environment_file_delete`, batchCommitMessage([]string{
+		"Add the handler\n\nTool: environment_file_write\nRun-Id: 42",
+		"Fix the imports\n\nOperation: Edit main.go\nTool: environment_file_edit\nRun-Id: 42",
+		"Remove the stub\n\nTool: environment_file_delete\nRun-Id: 42",
+	}))
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// PruneReport summarizes the outcome of pruning the source repository.
+type PruneReport struct {
+	// PrunedRefs are the environments whose ref under the container-use remote was removed.
+	PrunedRefs []string
+	// DeletedBranches are the local branches tracking deleted environments that were removed.
+	DeletedBranches []string
+	// SkippedBranches are the local branches tracking deleted environments that were kept.
+	SkippedBranches []SkippedBranch
+}
+
+// SkippedBranch is a branch kept by pruning, and why.
+type SkippedBranch struct {
+	Branch string
+	Reason string
+}
+
+// PruneRemote removes what deleted environments left in the source repository: their refs under the
+// container-use remote, and the local branches tracking them, such as those created by Checkout.
+// Branches with commits found in no other branch, tag or remote are kept, as is the current branch.
+// When dryRun is set, nothing is removed and the report lists what would be.
+func (r *Repository) PruneRemote(ctx context.Context, dryRun bool) (*PruneReport, error) {
+	return r.prune(ctx, dryRun, nil)
+}
+
+// prune implements PruneRemote for the environments in ids, or all of them if ids is nil.
+func (r *Repository) prune(ctx context.Context, dryRun bool, ids map[string]bool) (*PruneReport, error) {
+	live, err := r.managedGit(ctx, r.forkRepoPath, "for-each-ref", "--format=%(refname:lstrip=2)", "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	exists := map[string]bool{}
+	for id := range strings.Lines(live) {
+		exists[strings.TrimSpace(id)] = true
+	}
+	deleted := func(id string) bool {
+		return !exists[id] && (ids == nil || ids[id])
+	}
+	report := &PruneReport{}
+
+	remotePrefix := fmt.Sprintf("refs/remotes/%s/", containerUseRemote)
+	remoteRefs, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format=%(refname)", remotePrefix)
+	if err != nil {
+		return nil, err
+	}
+	staleRefs := []string{}
+	for ref := range strings.Lines(remoteRefs) {
+		ref = strings.TrimSpace(ref)
+		if id := strings.TrimPrefix(ref, remotePrefix); id != "HEAD" && deleted(id) {
+			staleRefs = append(staleRefs, ref)
+			report.PrunedRefs = append(report.PrunedRefs, id)
+		}
+	}
+
+	current, _ := RunGitCommand(ctx, r.userRepoPath, "symbolic-ref", "--quiet", "HEAD")
+	current = strings.TrimSpace(current)
+	branches, err := RunGitCommand(ctx, r.userRepoPath, "for-each-ref", "--format=%(refname)%00%(upstream)", "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	staleBranches := []string{}
+	for line := range strings.Lines(branches) {
+		ref, upstream, _ := strings.Cut(strings.TrimSpace(line), "\x00")
+		id, ok := strings.CutPrefix(upstream, remotePrefix)
+		if !ok || !deleted(id) {
+			continue
+		}
+		branch := strings.TrimPrefix(ref, "refs/heads/")
+		if ref == current {
+			report.SkippedBranches = append(report.SkippedBranches, SkippedBranch{Branch: branch, Reason: "it is checked out"})
+			continue
+		}
+		unique, err := r.uniqueCommits(ctx, ref, staleRefs)
+		if err != nil {
+			return nil, err
+		}
+		if unique > 0 {
+			report.SkippedBranches = append(report.SkippedBranches, SkippedBranch{
+				Branch: branch,
+				Reason: fmt.Sprintf("it has %d commits found in no other branch", unique),
+			})
+			continue
+		}
+		staleBranches = append(staleBranches, branch)
+		report.DeletedBranches = append(report.DeletedBranches, branch)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	for _, ref := range staleRefs {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, "update-ref", "-d", ref); err != nil {
+			return nil, err
+		}
+	}
+	if len(staleBranches) > 0 {
+		if _, err := RunGitCommand(ctx, r.userRepoPath, append([]string{"branch", "-D"}, staleBranches...)...); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// uniqueCommits returns the number of commits of ref found in no other ref of the source repository,
+// not counting the stale refs about to be removed.
+func (r *Repository) uniqueCommits(ctx context.Context, ref string, staleRefs []string) (int, error) {
+	args := []string{"rev-list", "--count", ref, "--not", "--exclude=" + ref}
+	for _, stale := range staleRefs {
+		args = append(args, "--exclude="+stale)
+	}
+	out, err := RunGitCommand(ctx, r.userRepoPath, append(args, "--all")...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// pruneDeleted prunes what environment id, which was just deleted, left in the source repository.
+// Failures are logged rather than returned: the environment itself is gone already.
+func (r *Repository) pruneDeleted(ctx context.Context, id string) {
+	report, err := r.prune(ctx, false, map[string]bool{id: true})
+	if err != nil {
+		slog.Warn("Failed to prune the branches of a deleted environment", "environment.id", id, "err", err)
+		return
+	}
+	for _, skipped := range report.SkippedBranches {
+		slog.Info("Kept branch of deleted environment", "environment.id", id, "branch", skipped.Branch, "reason", skipped.Reason)
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneRemote(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+	mainBranch, err := RunGitCommand(ctx, tempDir, "symbolic-ref", "--short", "HEAD")
+	require.NoError(t, err)
+	mainBranch = strings.TrimSpace(mainBranch)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+
+	for _, id := range []string{"gone-env", "reworked-env", "live-env"} {
+		_, err := repo.initializeWorktree(ctx, id)
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, tempDir, "branch", "--track", "cu-"+id, containerUseRemote+"/"+id)
+		require.NoError(t, err)
+	}
+	// The user committed on top of an environment before it was deleted
+	_, err = RunGitCommand(ctx, tempDir, "checkout", "cu-reworked-env")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "notes.md", "# Notes")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Take notes")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "checkout", mainBranch)
+	require.NoError(t, err)
+
+	// Delete the environments from the fork only, leaving the source repository behind
+	for _, id := range []string{"gone-env", "reworked-env"} {
+		worktree, err := repo.WorktreePath(id)
+		require.NoError(t, err)
+		require.NoError(t, os.RemoveAll(worktree))
+		_, err = repo.managedGit(ctx, repo.forkRepoPath, "worktree", "prune")
+		require.NoError(t, err)
+		_, err = repo.managedGit(ctx, repo.forkRepoPath, "update-ref", "-d", environmentRef(id))
+		require.NoError(t, err)
+	}
+
+	expected := &PruneReport{
+		PrunedRefs:      []string{"gone-env", "reworked-env"},
+		DeletedBranches: []string{"cu-gone-env"},
+		SkippedBranches: []SkippedBranch{{Branch: "cu-reworked-env", Reason: "it has 1 commits found in no other branch"}},
+	}
+	report, err := repo.PruneRemote(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report)
+	refs, err := RunGitCommand(ctx, tempDir, "for-each-ref", "--format=%(refname)", "refs/heads/cu-gone-env", "refs/remotes/"+containerUseRemote+"/gone-env")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/cu-gone-env\nrefs/remotes/container-use/gone-env\n", refs, "a dry run removes nothing")
+
+	report, err = repo.PruneRemote(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report)
+	refs, err = RunGitCommand(ctx, tempDir, "for-each-ref", "--format=%(refname)", "refs/heads/", "refs/remotes/"+containerUseRemote)
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/cu-live-env\nrefs/heads/cu-reworked-env\nrefs/heads/"+mainBranch+"\nrefs/remotes/container-use/live-env\n", refs)
+
+	report, err = repo.PruneRemote(ctx, false)
+	require.NoError(t, err)
+	assert.Empty(t, report.PrunedRefs)
+	assert.Empty(t, report.DeletedBranches)
+}
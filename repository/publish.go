@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// PublishOptions configures Publish.
+type PublishOptions struct {
+	// Remote is the remote of the source repository to push to, origin by default.
+	Remote string
+	// Branch is the branch to push the environment to, cu-<id> by default as with Checkout.
+	Branch string
+	// DraftPR opens a draft pull request of the branch, if the remote is on GitHub.
+	DraftPR bool
+	// Base is the branch the pull request is opened against, the default branch of the repository by default.
+	Base string
+	// Token authenticates to the GitHub API. If empty, GH_TOKEN, GITHUB_TOKEN or the token of the gh CLI is used.
+	Token string
+}
+
+// PublishResult describes where Publish pushed an environment.
+type PublishResult struct {
+	Remote string `json:"remote"`
+	Branch string `json:"branch"`
+	Commit string `json:"commit"`
+	// PullRequestURL is the URL of the draft pull request of the branch, if one was requested.
+	PullRequestURL string `json:"pull_request_url,omitempty"`
+	// PullRequestExisted is set if the branch already had an open pull request, which was left as is.
+	PullRequestExisted bool `json:"pull_request_existed,omitempty"`
+}
+
+// Publish pushes the branch of environment id to a remote of the source repository, and optionally opens
+// a draft pull request of it with a title and description generated from the environment history. The
+// push isn't forced: if the branch of the remote moved in between, it has to be reconciled by hand.
+func (r *Repository) Publish(ctx context.Context, id string, opts PublishOptions) (*PublishResult, error) {
+	envInfo, err := r.Info(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Remote == "" {
+		opts.Remote = "origin"
+	}
+	if opts.Branch == "" {
+		opts.Branch = "cu-" + id
+	}
+	for _, name := range []string{opts.Remote, opts.Branch, opts.Base} {
+		if strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("invalid remote or branch name %q", name)
+		}
+	}
+	remoteURL, err := RunGitCommand(ctx, r.userRepoPath, "remote", "get-url", opts.Remote)
+	if err != nil {
+		return nil, fmt.Errorf("remote %q not found in %s", opts.Remote, r.userRepoPath)
+	}
+	remoteURL = strings.TrimSpace(remoteURL)
+
+	// Push what the environment is at, rather than what the user repository last fetched
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, id); err != nil {
+		return nil, err
+	}
+	commit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", fmt.Sprintf("refs/remotes/%s/%s", containerUseRemote, id))
+	if err != nil {
+		return nil, err
+	}
+	result := &PublishResult{Remote: opts.Remote, Branch: opts.Branch, Commit: strings.TrimSpace(commit)}
+
+	slog.Info("Publishing environment", "environment-id", id, "remote", opts.Remote, "branch", opts.Branch)
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "push", opts.Remote, fmt.Sprintf("%s:refs/heads/%s", result.Commit, opts.Branch)); err != nil {
+		return nil, fmt.Errorf("failed to push to %s: %w", opts.Remote, err)
+	}
+	if !opts.DraftPR {
+		return result, nil
+	}
+
+	host, owner, name, err := githubRepository(remoteURL)
+	if err != nil {
+		return result, fmt.Errorf("pushed %s, but can't open a pull request: %w", opts.Branch, err)
+	}
+	if opts.Token == "" {
+		opts.Token = githubToken(ctx, host)
+	}
+	if opts.Token == "" {
+		return result, fmt.Errorf("pushed %s, but opening a pull request needs a GitHub token: set GITHUB_TOKEN or log in with `gh auth login`", opts.Branch)
+	}
+	history, err := r.History(ctx, id, false)
+	if err != nil {
+		return result, err
+	}
+	followUp := 0
+	if pr := envInfo.State.PullRequest; pr != nil {
+		followUp = pr.Number
+	}
+	repoURL := fmt.Sprintf("%s/repos/%s/%s", strings.TrimSuffix(githubAPIURL(host), "/"), owner, name)
+	result.PullRequestURL, result.PullRequestExisted, err = openDraftPullRequest(ctx, repoURL, owner, opts,
+		envInfo.State.Title, pullRequestBody(id, followUp, history))
+	if err != nil {
+		return result, fmt.Errorf("pushed %s, but failed to open a pull request: %w", opts.Branch, err)
+	}
+	return result, nil
+}
+
+// openDraftPullRequest opens a draft pull request of opts.Branch in the GitHub repository at repoURL, an
+// API URL, unless the branch already has an open one. It returns the URL of the pull request.
+func openDraftPullRequest(ctx context.Context, repoURL, owner string, opts PublishOptions, title, body string) (string, bool, error) {
+	type pullRequest struct {
+		HTMLURL string `json:"html_url"`
+	}
+
+	var existing []pullRequest
+	query := url.Values{"head": {owner + ":" + opts.Branch}, "state": {"open"}}
+	if err := githubRequest(ctx, http.MethodGet, repoURL+"/pulls?"+query.Encode(), opts.Token, nil, &existing); err != nil {
+		return "", false, err
+	}
+	if len(existing) > 0 {
+		return existing[0].HTMLURL, true, nil
+	}
+
+	if opts.Base == "" {
+		var repo struct {
+			DefaultBranch string `json:"default_branch"`
+		}
+		if err := githubRequest(ctx, http.MethodGet, repoURL, opts.Token, nil, &repo); err != nil {
+			return "", false, err
+		}
+		opts.Base = repo.DefaultBranch
+	}
+	var created pullRequest
+	if err := githubRequest(ctx, http.MethodPost, repoURL+"/pulls", opts.Token, map[string]any{
+		"title": title,
+		"body":  body,
+		"head":  opts.Branch,
+		"base":  opts.Base,
+		"draft": true,
+	}, &created); err != nil {
+		return "", false, err
+	}
+	return created.HTMLURL, false, nil
+}
+
+// pullRequestBody describes the work done in environment id from its history: the explanation of each
+// of its commits. pullRequest is the number of the pull request the environment was created from, or 0.
+func pullRequestBody(id string, pullRequest int, history []*HistoryEntry) string {
+	var body strings.Builder
+	if pullRequest != 0 {
+		fmt.Fprintf(&body, "Follow-up to #%d.\n\n", pullRequest)
+	}
+	if len(history) > 0 {
+		body.WriteString("## Changes\n\n")
+		for _, entry := range history {
+			fmt.Fprintf(&body, "- %s (%s)\n", entry.Explanation, shortCommit(entry.Commit))
+		}
+		body.WriteString("\n")
+	}
+	fmt.Fprintf(&body, "---\nPublished from the container-use environment `%s`. Run `container-use log %s` for the commands and outputs behind each change.\n", id, id)
+	return body.String()
+}
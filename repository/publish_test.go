@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullRequestBody(t *testing.T) {
+	body := pullRequestBody("fancy-mallard", 123, []*HistoryEntry{
+		{Commit: "0123456789abcdef", Explanation: "Reproduce the login crash"},
+		{Commit: "fedcba9876543210", Explanation: "Fix the nil session"},
+	})
+	assert.Equal(t, "Follow-up to #123.\n\n"+
+		"## Changes\n\n"+
+		"- Reproduce the login crash (0123456789ab)\n"+
+		"- Fix the nil session (fedcba987654)\n\n"+
+		"---\nPublished from the container-use environment `fancy-mallard`. Run `container-use log fancy-mallard` for the commands and outputs behind each change.\n", body)
+
+	assert.NotContains(t, pullRequestBody("fancy-mallard", 0, nil), "#")
+}
+
+func TestOpenDraftPullRequest(t *testing.T) {
+	ctx := context.Background()
+	var created map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer gh-token", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/dagger/app/pulls":
+			if r.URL.Query().Get("head") == "dagger:cu-existing" {
+				_ = json.NewEncoder(w).Encode([]map[string]string{{"html_url": "https://github.com/dagger/app/pull/1"}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode([]any{})
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/dagger/app":
+			_ = json.NewEncoder(w).Encode(map[string]string{"default_branch": "trunk"})
+		case r.Method == http.MethodPost && r.URL.Path == "/repos/dagger/app/pulls":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			if created["base"] == "missing" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_ = json.NewEncoder(w).Encode(map[string]any{"message": "Validation Failed", "errors": []map[string]string{{"message": "base is invalid"}}})
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]string{"html_url": "https://github.com/dagger/app/pull/2"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	repoURL := server.URL + "/repos/dagger/app"
+
+	prURL, existed, err := openDraftPullRequest(ctx, repoURL, "dagger", PublishOptions{Branch: "cu-existing", Token: "gh-token"}, "Title", "Body")
+	require.NoError(t, err)
+	assert.True(t, existed)
+	assert.Equal(t, "https://github.com/dagger/app/pull/1", prURL)
+	assert.Nil(t, created, "no pull request is opened for branches that have one")
+
+	prURL, existed, err = openDraftPullRequest(ctx, repoURL, "dagger", PublishOptions{Branch: "cu-new", Token: "gh-token"}, "Title", "Body")
+	require.NoError(t, err)
+	assert.False(t, existed)
+	assert.Equal(t, "https://github.com/dagger/app/pull/2", prURL)
+	assert.Equal(t, map[string]any{"title": "Title", "body": "Body", "head": "cu-new", "base": "trunk", "draft": true}, created)
+
+	_, _, err = openDraftPullRequest(ctx, repoURL, "dagger", PublishOptions{Branch: "cu-new", Base: "missing", Token: "gh-token"}, "Title", "Body")
+	assert.ErrorContains(t, err, "Validation Failed: base is invalid")
+}
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+const githubTimeout = 30 * time.Second
+
+var (
+	githubHTTPClient = &http.Client{Timeout: githubTimeout}
+
+	// githubAPIURL returns the base URL of the REST API of the GitHub instance at host.
+	githubAPIURL = func(host string) string {
+		if host == "github.com" {
+			return "https://api.github.com"
+		}
+		// GitHub Enterprise Server
+		return "https://" + host + "/api/v3"
+	}
+)
+
+// CreateFromPullRequest creates a new environment like CreateFromTemplate, with its branch forked from the
+// head of pull request number of the GitHub repository origin points to. The pull request is described in
+// the state of the environment. token authenticates to the GitHub API; if empty, GH_TOKEN, GITHUB_TOKEN or
+// the token of the gh CLI is used, if any. template may be nil, and description defaults to the title of
+// the pull request.
+func (r *Repository) CreateFromPullRequest(ctx context.Context, dag *dagger.Client, number int, token string, template *environment.Template, description, explanation string) (*environment.Environment, error) {
+	if number <= 0 {
+		return nil, fmt.Errorf("invalid pull request number %d", number)
+	}
+	origin, err := RunGitCommand(ctx, r.userRepoPath, "remote", "get-url", "origin")
+	if err != nil {
+		return nil, fmt.Errorf("the repository has no origin remote to find pull requests on")
+	}
+	host, owner, name, err := githubRepository(strings.TrimSpace(origin))
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		token = githubToken(ctx, host)
+	}
+
+	pr, err := fetchPullRequest(ctx, githubAPIURL(host), owner, name, number, token)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.fetchPullRequestHead(ctx, number)
+	if err != nil {
+		return nil, err
+	}
+	if commit != pr.HeadSHA {
+		// The pull request was pushed to in between, record what the environment actually starts from
+		slog.Warn("Pull request head moved while fetching it", "number", number, "api", pr.HeadSHA, "fetched", commit)
+		pr.HeadSHA = commit
+	}
+
+	if description == "" {
+		description = fmt.Sprintf("PR #%d: %s", number, pr.Title)
+	}
+	return r.create(ctx, dag, commit, template, pr, description, explanation)
+}
+
+// githubRepository returns the host, owner and name of the GitHub repository remote is the URL of.
+func githubRepository(remote string) (host, owner, name string, err error) {
+	if !isRemoteURL(remote) {
+		return "", "", "", fmt.Errorf("origin %s is not a GitHub repository", remote)
+	}
+	normalized, err := normalizeGitURL(remote)
+	if err != nil {
+		return "", "", "", fmt.Errorf("origin %s is not a GitHub repository", remote)
+	}
+	parts := strings.Split(strings.Trim(normalized, "/"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("origin %s is not a GitHub repository", remote)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// githubToken returns the token to call the GitHub API of host with, or "" to call it anonymously.
+func githubToken(ctx context.Context, host string) string {
+	for _, name := range []string{"GH_TOKEN", "GITHUB_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return token
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, githubTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "gh", "auth", "token", "--hostname", host).Output()
+	if err != nil {
+		if !errors.Is(err, exec.ErrNotFound) {
+			slog.Info("No token from the gh CLI, calling the GitHub API anonymously", "host", host, "err", err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// githubAPIError is an error response of the GitHub API.
+type githubAPIError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *githubAPIError) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s: %s", e.Status, e.Message)
+}
+
+// githubRequest calls the GitHub API, sending in as JSON if not nil, and decodes the response into out.
+// Error responses are returned as a *githubAPIError.
+func githubRequest(ctx context.Context, method, u, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := githubHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errs struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errs)
+		message := errs.Message
+		// Validation failures only detail what went wrong in errors
+		for _, e := range errs.Errors {
+			if e.Message != "" {
+				message += ": " + e.Message
+			}
+		}
+		return &githubAPIError{StatusCode: resp.StatusCode, Status: resp.Status, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetchPullRequest describes pull request number of the repository owner/name through the GitHub API at apiURL.
+func fetchPullRequest(ctx context.Context, apiURL, owner, name string, number int, token string) (*environment.PullRequest, error) {
+	var pr struct {
+		HTMLURL string `json:"html_url"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		State   string `json:"state"`
+		Merged  bool   `json:"merged"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Head struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	}
+	u := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", strings.TrimSuffix(apiURL, "/"), owner, name, number)
+	if err := githubRequest(ctx, http.MethodGet, u, token, nil, &pr); err != nil {
+		var apiErr *githubAPIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			hint := ""
+			if token == "" {
+				hint = " (set GITHUB_TOKEN or log in with `gh auth login` for private repositories)"
+			}
+			return nil, fmt.Errorf("pull request #%d of %s/%s not found%s", number, owner, name, hint)
+		}
+		return nil, fmt.Errorf("failed to get pull request #%d of %s/%s: %w", number, owner, name, err)
+	}
+	state := pr.State
+	if pr.Merged {
+		state = "merged"
+	}
+	return &environment.PullRequest{
+		Number:  number,
+		URL:     pr.HTMLURL,
+		Title:   pr.Title,
+		Body:    pr.Body,
+		Author:  pr.User.Login,
+		State:   state,
+		BaseRef: pr.Base.Ref,
+		HeadRef: pr.Head.Ref,
+		HeadSHA: pr.Head.SHA,
+	}, nil
+}
+
+// fetchPullRequestHead fetches the head of pull request number from origin, including pull requests
+// opened from forks, and returns its commit.
+func (r *Repository) fetchPullRequestHead(ctx context.Context, number int) (string, error) {
+	slog.Info("Fetching pull request from origin", "repository", r.userRepoPath, "number", number)
+	args := []string{"fetch", "--no-tags"}
+	if shallow, _ := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--is-shallow-repository"); strings.TrimSpace(shallow) == "true" {
+		args = append(args, "--depth", "1")
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, append(args, "origin", fmt.Sprintf("refs/pull/%d/head", number))...); err != nil {
+		return "", fmt.Errorf("failed to fetch pull request #%d from origin: %w", number, err)
+	}
+	commit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("pull request #%d not found on origin", number)
+	}
+	return strings.TrimSpace(commit), nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubRepository(t *testing.T) {
+	for _, remote := range []string{
+		"https://github.com/dagger/container-use.git",
+		"git@github.com:dagger/container-use.git",
+		"ssh://git@github.com/dagger/container-use",
+	} {
+		host, owner, name, err := githubRepository(remote)
+		require.NoError(t, err, remote)
+		assert.Equal(t, []string{"github.com", "dagger", "container-use"}, []string{host, owner, name}, remote)
+	}
+	for _, remote := range []string{
+		"file:///srv/git/app.git",
+		"/srv/git/app.git",
+		"https://gitlab.example.com/group/subgroup/app.git",
+	} {
+		_, _, _, err := githubRepository(remote)
+		assert.ErrorContains(t, err, "is not a GitHub repository", remote)
+	}
+}
+
+func TestFetchPullRequest(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/dagger/container-use/pulls/123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer gh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": "Bad credentials"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"html_url": "https://github.com/dagger/container-use/pull/123",
+			"title":    "Fix the login crash",
+			"body":     "Closes #42",
+			"state":    "closed",
+			"merged":   true,
+			"user":     map[string]string{"login": "octocat"},
+			"base":     map[string]string{"ref": "main"},
+			"head":     map[string]string{"ref": "fix/login", "sha": "abc123"},
+		})
+	}))
+	defer server.Close()
+
+	pr, err := fetchPullRequest(ctx, server.URL, "dagger", "container-use", 123, "gh-token")
+	require.NoError(t, err)
+	assert.Equal(t, 123, pr.Number)
+	assert.Equal(t, "https://github.com/dagger/container-use/pull/123", pr.URL)
+	assert.Equal(t, "Fix the login crash", pr.Title)
+	assert.Equal(t, "octocat", pr.Author)
+	assert.Equal(t, "merged", pr.State)
+	assert.Equal(t, "main", pr.BaseRef)
+	assert.Equal(t, "fix/login", pr.HeadRef)
+	assert.Equal(t, "abc123", pr.HeadSHA)
+
+	_, err = fetchPullRequest(ctx, server.URL, "dagger", "container-use", 123, "wrong-token")
+	assert.ErrorContains(t, err, "Bad credentials")
+	_, err = fetchPullRequest(ctx, server.URL, "dagger", "container-use", 999, "")
+	assert.ErrorContains(t, err, "pull request #999 of dagger/container-use not found (set GITHUB_TOKEN")
+}
+
+// TestFetchPullRequestHead verifies that the head of a pull request is fetched from the pull request refs
+// of origin, which regular fetches don't download.
+func TestFetchPullRequestHead(t *testing.T) {
+	ctx := context.Background()
+	remote := t.TempDir()
+	basePath := t.TempDir()
+
+	_, err := RunGitCommand(ctx, remote, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, remote, "README.md", "# main")
+	_, err = RunGitCommand(ctx, remote, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "commit", "-m", "main")
+	require.NoError(t, err)
+	checkout, err := ensureClone(ctx, basePath, "file://"+remote)
+	require.NoError(t, err)
+
+	// The way GitHub exposes pull requests, including those opened from forks
+	_, err = RunGitCommand(ctx, remote, "checkout", "-b", "contribution")
+	require.NoError(t, err)
+	writeFile(t, remote, "README.md", "# contribution")
+	_, err = RunGitCommand(ctx, remote, "commit", "-am", "contribution")
+	require.NoError(t, err)
+	head, err := RunGitCommand(ctx, remote, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "update-ref", "refs/pull/7/head", "HEAD")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, checkout, basePath)
+	require.NoError(t, err)
+	commit, err := repo.fetchPullRequestHead(ctx, 7)
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(head), commit)
+
+	_, err = repo.fetchPullRequestHead(ctx, 8)
+	assert.ErrorContains(t, err, "failed to fetch pull request #8")
+
+	_, err = repo.initializeWorktreeFromRef(ctx, "pr-env", commit)
+	require.NoError(t, err, "the fork accepts the fetched commit")
+}
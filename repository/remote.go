@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// remoteSchemes are the URL schemes of git remotes that can be opened without a local checkout.
+var remoteSchemes = []string{"https", "http", "ssh", "git", "git+ssh"}
+
+var (
+	// clonesMu serializes clones, so that concurrent tool calls on a new URL clone it once.
+	clonesMu sync.Mutex
+	// clonesUpdated are the checkouts already brought up to date by this process.
+	clonesUpdated = map[string]bool{}
+)
+
+// isRemoteURL reports whether repo is the URL of a git remote, such as https://github.com/org/repo.git or
+// git@github.com:org/repo.git, rather than a local path.
+func isRemoteURL(repo string) bool {
+	if matchesURLScheme(repo) {
+		scheme, _, _ := strings.Cut(repo, "://")
+		return slices.Contains(remoteSchemes, strings.ToLower(scheme))
+	}
+	if !matchesScpLike(repo) {
+		return false
+	}
+	// A local path such as ./a:b/c looks like an SCP-like URL too
+	_, err := os.Stat(repo)
+	return os.IsNotExist(err)
+}
+
+// clonePath returns where the remote at url is checked out, under the repos directory of basePath.
+func clonePath(basePath, url string) (string, error) {
+	normalized, err := normalizeGitURL(url)
+	if err != nil {
+		return "", fmt.Errorf("invalid git URL %s: %w", url, err)
+	}
+	normalized = strings.Trim(normalized, "/")
+	if normalized == "" || slices.Contains(strings.Split(normalized, "/"), "..") {
+		return "", fmt.Errorf("invalid git URL %s", url)
+	}
+	return homedir.Expand(filepath.Join(basePath, "repos", "clones", normalized))
+}
+
+// ensureClone returns the path of a checkout of the remote at url, making a shallow clone of its default
+// branch the first time. Existing checkouts are fast-forwarded once per process, on a best effort basis:
+// they may hold environments checked out by the user.
+func ensureClone(ctx context.Context, basePath, url string) (string, error) {
+	dir, err := clonePath(basePath, url)
+	if err != nil {
+		return "", err
+	}
+
+	clonesMu.Lock()
+	defer clonesMu.Unlock()
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		if !clonesUpdated[dir] {
+			clonesUpdated[dir] = true
+			if _, err := RunGitCommand(ctx, dir, "pull", "--ff-only", "--depth", "1"); err != nil {
+				slog.Warn("Failed to update checkout, using it as is", "url", url, "dir", dir, "err", err)
+			}
+		}
+		return dir, nil
+	}
+
+	slog.Info("Cloning remote repository", "url", url, "dir", dir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	if _, err := RunGitCommand(ctx, filepath.Dir(dir), "clone", "--depth", "1", "--", url, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to clone %s: %w", url, err)
+	}
+	clonesUpdated[dir] = true
+	return dir, nil
+}
+
+// allowShallowPushes lets the fork receive the commits of a shallow checkout, such as a clone made by
+// ensureClone: git refuses to push from shallow repositories otherwise.
+func (r *Repository) allowShallowPushes(ctx context.Context) error {
+	shallow, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--is-shallow-repository")
+	if err != nil || strings.TrimSpace(shallow) != "true" {
+		return err
+	}
+	_, err = r.managedGit(ctx, r.forkRepoPath, "config", "receive.shallowUpdate", "true")
+	return err
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRemoteURL(t *testing.T) {
+	for _, repo := range []string{
+		"https://github.com/dagger/container-use.git",
+		"ssh://git@github.com/dagger/container-use.git",
+		"git@github.com:dagger/container-use.git",
+		"git+ssh://git@gitlab.example.com:2222/team/app",
+	} {
+		assert.True(t, isRemoteURL(repo), repo)
+	}
+	for _, repo := range []string{
+		"/home/user/src/app",
+		"./app",
+		"file:///srv/git/app.git",
+		t.TempDir(),
+	} {
+		assert.False(t, isRemoteURL(repo), repo)
+	}
+}
+
+func TestClonePath(t *testing.T) {
+	basePath := t.TempDir()
+	https, err := clonePath(basePath, "https://github.com/dagger/container-use.git")
+	require.NoError(t, err)
+	ssh, err := clonePath(basePath, "git@github.com:dagger/container-use.git")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(basePath, "repos", "clones", "github.com", "dagger", "container-use"), https)
+	assert.Equal(t, https, ssh, "both URLs of a remote share its checkout")
+
+	_, err = clonePath(basePath, "https://example.com/../../etc")
+	assert.Error(t, err)
+}
+
+// TestOpenRemote verifies that a remote is cloned shallowly, and that environments can be created from
+// the clone.
+func TestOpenRemote(t *testing.T) {
+	ctx := context.Background()
+	remote := t.TempDir()
+	basePath := t.TempDir()
+
+	_, err := RunGitCommand(ctx, remote, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	for _, content := range []string{"# Test", "# Test, again"} {
+		writeFile(t, remote, "README.md", content)
+		_, err = RunGitCommand(ctx, remote, "add", ".")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, remote, "commit", "-m", content)
+		require.NoError(t, err)
+	}
+
+	url := "file://" + remote
+	checkout, err := ensureClone(ctx, basePath, url)
+	require.NoError(t, err)
+	shallow, err := RunGitCommand(ctx, checkout, "rev-parse", "--is-shallow-repository")
+	require.NoError(t, err)
+	assert.Equal(t, "true", strings.TrimSpace(shallow))
+
+	again, err := ensureClone(ctx, basePath, url)
+	require.NoError(t, err)
+	assert.Equal(t, checkout, again, "the checkout is reused")
+
+	repo, err := OpenWithBasePath(ctx, checkout, basePath)
+	require.NoError(t, err)
+	_, err = repo.initializeWorktree(ctx, "remote-env")
+	require.NoError(t, err, "the fork accepts the commits of the shallow checkout")
+}
+
+// TestResolveRef verifies that refs are resolved locally first, then fetched from origin, such as a tag
+// of a shallow clone.
+func TestResolveRef(t *testing.T) {
+	ctx := context.Background()
+	remote := t.TempDir()
+	basePath := t.TempDir()
+
+	_, err := RunGitCommand(ctx, remote, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, remote, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	var commits []string
+	for _, content := range []string{"# v1", "# v2"} {
+		writeFile(t, remote, "README.md", content)
+		_, err = RunGitCommand(ctx, remote, "add", ".")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, remote, "commit", "-m", content)
+		require.NoError(t, err)
+		head, err := RunGitCommand(ctx, remote, "rev-parse", "HEAD")
+		require.NoError(t, err)
+		commits = append(commits, strings.TrimSpace(head))
+	}
+	_, err = RunGitCommand(ctx, remote, "tag", "-a", "v1.0.0", "-m", "v1.0.0", commits[0])
+	require.NoError(t, err)
+
+	checkout, err := ensureClone(ctx, basePath, "file://"+remote)
+	require.NoError(t, err)
+	repo, err := OpenWithBasePath(ctx, checkout, basePath)
+	require.NoError(t, err)
+
+	head, err := repo.resolveRef(ctx, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[1], head)
+
+	tag, err := repo.resolveRef(ctx, "v1.0.0")
+	require.NoError(t, err, "the tag is fetched from origin")
+	assert.Equal(t, commits[0], tag, "annotated tags resolve to their commit")
+
+	_, err = repo.resolveRef(ctx, "v9.9.9")
+	assert.ErrorContains(t, err, `ref "v9.9.9" not found`)
+	_, err = repo.resolveRef(ctx, "--upload-pack=touch")
+	assert.ErrorContains(t, err, "invalid ref")
+
+	_, err = repo.initializeWorktreeFromRef(ctx, "tagged-env", tag)
+	require.NoError(t, err, "the fork accepts the fetched commit")
+}
@@ -10,7 +10,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"dagger.io/dagger"
 	"github.com/dagger/container-use/environment"
@@ -24,12 +27,24 @@ const (
 	containerUseRemote = "container-use"
 	gitNotesLogRef     = "container-use"
 	gitNotesStateRef   = "container-use-state"
+	gitNotesAuditRef   = "container-use-audit"
+	configDirEnv       = "CONTAINER_USE_CONFIG_DIR"
 )
 
 type Repository struct {
 	userRepoPath string
 	forkRepoPath string
 	basePath     string // defaults to ~/.config/container-use if empty
+	storage      WorktreeStorage
+	git          GitBackend // reads the fork repository
+
+	propagation   Propagation
+	flushInterval time.Duration // see PropagationLazy
+
+	loaded sync.Map // environment ID -> *loadedEnvironment, see load
+
+	gitEnvOnce sync.Once
+	gitEnv     []string // see managedGitEnv
 }
 
 // getRepoPath returns the path for storing repository data
@@ -39,16 +54,40 @@ func (r *Repository) getRepoPath() string {
 
 // getWorktreePath returns the path for storing worktrees
 func (r *Repository) getWorktreePath() string {
+	if r.storage == WorktreeStorageTmpfs || r.storage == WorktreeStorageOverlay {
+		return filepath.Join(tmpfsPath(), "worktrees")
+	}
 	return filepath.Join(r.basePath, "worktrees")
 }
 
 func Open(ctx context.Context, repo string) (*Repository, error) {
-	return OpenWithBasePath(ctx, repo, cuGlobalConfigPath)
+	return OpenWithBasePath(ctx, repo, DefaultBasePath())
+}
+
+// DefaultBasePath returns where container-use stores its data.
+// It can be overridden with CONTAINER_USE_CONFIG_DIR, which is useful when running inside
+// a container (e.g. a devcontainer) where the home directory isn't persisted or shared with the host.
+// Paths under it, like worktrees and the container-use remote of repositories, are not translated to
+// host paths: they are only usable from within the container.
+func DefaultBasePath() string {
+	if dir := os.Getenv(configDirEnv); dir != "" {
+		return dir
+	}
+	return cuGlobalConfigPath
 }
 
 // OpenWithBasePath opens a repository with a custom base path for container-use data.
 // This is useful for tests that need isolated environments.
+// repo is a local path, or the https or SSH URL of a remote, which is then cloned under basePath.
 func OpenWithBasePath(ctx context.Context, repo string, basePath string) (*Repository, error) {
+	if isRemoteURL(repo) {
+		checkout, err := ensureClone(ctx, basePath, repo)
+		if err != nil {
+			return nil, err
+		}
+		repo = checkout
+	}
+
 	output, err := RunGitCommand(ctx, repo, "rev-parse", "--show-toplevel")
 	if err != nil {
 		// Check for exit code 128 which means not a git repository
@@ -73,15 +112,33 @@ func OpenWithBasePath(ctx context.Context, repo string, basePath string) (*Repos
 		}
 	}
 
+	storage, err := worktreeStorageFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	propagation, flushInterval, err := propagationFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	r := &Repository{
-		userRepoPath: userRepoPath,
-		forkRepoPath: forkRepoPath,
-		basePath:     basePath,
+		userRepoPath:  userRepoPath,
+		forkRepoPath:  forkRepoPath,
+		basePath:      basePath,
+		storage:       storage,
+		propagation:   propagation,
+		flushInterval: flushInterval,
+	}
+	if r.git, err = gitBackendFromEnv(r.managedGit); err != nil {
+		return nil, err
 	}
 
 	if err := r.ensureFork(ctx); err != nil {
 		return nil, fmt.Errorf("unable to fork the repository: %w", err)
 	}
+	if err := r.allowShallowPushes(ctx); err != nil {
+		return nil, fmt.Errorf("unable to fork the repository: %w", err)
+	}
 	if err := r.ensureUserRemote(ctx); err != nil {
 		return nil, fmt.Errorf("unable to set container-use remote: %w", err)
 	}
@@ -89,6 +146,17 @@ func OpenWithBasePath(ctx context.Context, repo string, basePath string) (*Repos
 	return r, nil
 }
 
+// Valid reports whether r can still be used as opened: its source repository and its fork are still there.
+// Processes keeping repositories open across operations check it before reusing them.
+func (r *Repository) Valid() bool {
+	for _, path := range []string{r.userRepoPath, r.forkRepoPath} {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *Repository) ensureFork(ctx context.Context) error {
 	// Make sure the fork repo path exists, otherwise create it
 	_, err := os.Stat(r.forkRepoPath)
@@ -98,12 +166,21 @@ func (r *Repository) ensureFork(ctx context.Context) error {
 	if !os.IsNotExist(err) {
 		return err
 	}
+	ctx, unlock, err := r.lockRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// Another process may have created it meanwhile
+	if _, err := os.Stat(r.forkRepoPath); err == nil {
+		return nil
+	}
 
 	slog.Info("Initializing local remote", "user-repo", r.userRepoPath, "fork-repo", r.forkRepoPath)
 	if err := os.MkdirAll(r.forkRepoPath, 0755); err != nil {
 		return err
 	}
-	_, err = RunGitCommand(ctx, r.forkRepoPath, "init", "--bare")
+	_, err = r.managedGit(ctx, r.forkRepoPath, "init", "--bare")
 	if err != nil {
 		return err
 	}
@@ -112,6 +189,17 @@ func (r *Repository) ensureFork(ctx context.Context) error {
 
 func (r *Repository) ensureUserRemote(ctx context.Context) error {
 	currentForkPath, err := getContainerUseRemote(ctx, r.userRepoPath)
+	if err == nil && currentForkPath == r.forkRepoPath {
+		return nil
+	}
+	ctx, unlock, err := r.lockRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Another process may have set it meanwhile
+	currentForkPath, err = getContainerUseRemote(ctx, r.userRepoPath)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
 			return err
@@ -128,13 +216,32 @@ func (r *Repository) ensureUserRemote(ctx context.Context) error {
 	return nil
 }
 
+// CheckRemote verifies that the container-use remote of the source repository points to a valid fork repository.
+func (r *Repository) CheckRemote(ctx context.Context) error {
+	remote, err := getContainerUseRemote(ctx, r.userRepoPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("the %s remote is missing", containerUseRemote)
+		}
+		return err
+	}
+	if remote != r.forkRepoPath {
+		return fmt.Errorf("the %s remote points to %s instead of %s", containerUseRemote, remote, r.forkRepoPath)
+	}
+	bare, err := r.managedGit(ctx, r.forkRepoPath, "rev-parse", "--is-bare-repository")
+	if err != nil || strings.TrimSpace(bare) != "true" {
+		return fmt.Errorf("%s is not a valid fork repository", r.forkRepoPath)
+	}
+	return nil
+}
+
 func (r *Repository) SourcePath() string {
 	return r.userRepoPath
 }
 
 func (r *Repository) exists(ctx context.Context, id string) error {
-	if _, err := RunGitCommand(ctx, r.forkRepoPath, "rev-parse", "--verify", id); err != nil {
-		if strings.Contains(err.Error(), "Needed a single revision") {
+	if _, err := r.git.ResolveCommit(ctx, r.forkRepoPath, environmentRef(id)); err != nil {
+		if errors.Is(err, errRevisionNotFound) {
 			return fmt.Errorf("environment %q not found", id)
 		}
 		return err
@@ -145,31 +252,112 @@ func (r *Repository) exists(ctx context.Context, id string) error {
 // Create creates a new environment with the given description and explanation.
 // Requires a dagger client for container operations during environment initialization.
 func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description, explanation string) (*environment.Environment, error) {
-	id := petname.Generate(2, "-")
-	worktree, err := r.initializeWorktree(ctx, id)
+	return r.create(ctx, dag, "HEAD", nil, nil, description, explanation)
+}
+
+// CreateFromTemplate creates a new environment like Create, with the configuration of the repository
+// overridden by template.
+func (r *Repository) CreateFromTemplate(ctx context.Context, dag *dagger.Client, template *environment.Template, description, explanation string) (*environment.Environment, error) {
+	return r.create(ctx, dag, "HEAD", template, nil, description, explanation)
+}
+
+// CreateFromRef creates a new environment like CreateFromTemplate, with its branch forked from ref, a
+// branch, tag or commit of the source repository, instead of HEAD. template may be nil.
+func (r *Repository) CreateFromRef(ctx context.Context, dag *dagger.Client, ref string, template *environment.Template, description, explanation string) (*environment.Environment, error) {
+	commit, err := r.resolveRef(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return r.create(ctx, dag, commit, template, nil, description, explanation)
+}
+
+// resolveRef returns the commit ref points to in the source repository. Refs it doesn't have, such as
+// the tags of a shallow clone, are fetched from its origin remote.
+func (r *Repository) resolveRef(ctx context.Context, ref string) (string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+	if commit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err == nil {
+		return strings.TrimSpace(commit), nil
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "remote", "get-url", "origin"); err != nil {
+		return "", fmt.Errorf("ref %q not found", ref)
+	}
+
+	slog.Info("Fetching ref from origin", "repository", r.userRepoPath, "ref", ref)
+	args := []string{"fetch", "--no-tags"}
+	if shallow, _ := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--is-shallow-repository"); strings.TrimSpace(shallow) == "true" {
+		args = append(args, "--depth", "1")
+	}
+	if _, err := RunGitCommand(ctx, r.userRepoPath, append(args, "origin", ref)...); err != nil {
+		return "", fmt.Errorf("ref %q not found, locally or on origin: %w", ref, err)
+	}
+	commit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref %q not found", ref)
+	}
+	return strings.TrimSpace(commit), nil
+}
+
+// Import adopts an existing branch of the source repository as a new environment.
+// The environment starts from the tip of the branch and picks up any configuration committed on it.
+func (r *Repository) Import(ctx context.Context, dag *dagger.Client, branch, description, explanation string) (*environment.Environment, error) {
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", branch+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("branch %q not found", branch)
+	}
+	if description == "" {
+		description = branch
+	}
+	return r.create(ctx, dag, branch, nil, nil, description, explanation)
+}
+
+func (r *Repository) create(ctx context.Context, dag *dagger.Client, ref string, template *environment.Template, pullRequest *environment.PullRequest, description, explanation string) (_ *environment.Environment, rerr error) {
+	id, err := r.newEnvironmentID(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer r.discardOnError(ctx, id, &rerr)
 
-	worktreeHead, err := RunGitCommand(ctx, worktree, "rev-parse", "HEAD")
+	worktree, err := r.initializeWorktreeFromRef(ctx, id, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	worktreeHead, err := r.managedGit(ctx, worktree, "rev-parse", "HEAD")
 	if err != nil {
 		return nil, err
 	}
 	worktreeHead = strings.TrimSpace(worktreeHead)
 
-	baseSourceDir, err := dag.
-		Host().
-		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}). // bust cache for each Create call
-		AsGit().
-		Ref(worktreeHead).
-		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}).
-		Sync(ctx) // don't bust cache when loading from state
+	// NoCache busts the cache for each Create call
+	baseSourceDir, err := r.sourceDirectory(ctx, dag, worktree, worktreeHead)
+	if err != nil {
+		return nil, err
+	}
+	baseSourceDir, err = baseSourceDir.Sync(ctx) // don't bust cache when loading from state
 	if err != nil {
 		return nil, fmt.Errorf("failed loading initial source directory: %w", err)
 	}
 
-	env, err := environment.New(ctx, dag, id, description, worktree, baseSourceDir)
+	config := environment.DefaultConfig()
+	if err := config.Load(worktree); err != nil {
+		return nil, err
+	}
+	if config.AllowedMounts, err = r.allowedMounts(); err != nil {
+		return nil, err
+	}
+	if template != nil {
+		template.Apply(config)
+	}
+	env, err := environment.NewWithConfig(ctx, dag, id, description, config, baseSourceDir)
 	if err != nil {
+		return nil, r.SaveBuildError(id, err)
+	}
+	if template == nil {
+		env.ToolchainSuggestion = environment.SuggestToolchain(worktree)
+	}
+	env.State.PullRequest = pullRequest
+	if err := r.saveLastBuild(env); err != nil {
 		return nil, err
 	}
 
@@ -180,106 +368,428 @@ func (r *Repository) Create(ctx context.Context, dag *dagger.Client, description
 	return env, nil
 }
 
-// Get retrieves a full Environment with dagger client embedded for container operations.
-// Use this when you need to perform container operations like running commands, terminals, etc.
-// For basic metadata access without container operations, use Info() instead.
-func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*environment.Environment, error) {
-	if err := r.exists(ctx, id); err != nil {
+// newEnvironmentID returns a random ID that no environment of the repository uses, so that a failed
+// creation can be rolled back without touching an existing environment.
+func (r *Repository) newEnvironmentID(ctx context.Context) (string, error) {
+	for range 10 {
+		id := petname.Generate(2, "-")
+		if err := r.exists(ctx, id); err == nil {
+			continue
+		}
+		worktreePath, err := r.WorktreePath(id)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(worktreePath); err == nil {
+			continue
+		}
+		return id, nil
+	}
+	return "", errors.New("failed to find an unused environment ID")
+}
+
+// discardOnError rolls back the creation of environment id if *rerr is set, so that a failed
+// creation doesn't leave a broken environment behind.
+func (r *Repository) discardOnError(ctx context.Context, id string, rerr *error) {
+	if *rerr == nil {
+		return
+	}
+	if err := r.discardEnvironment(ctx, id); err != nil {
+		*rerr = errors.Join(*rerr, fmt.Errorf("failed to clean up environment %s: %w", id, err))
+	}
+}
+
+// Clone creates a new environment from the configuration of source, an environment which may belong to
+// another repository. The new environment starts from the HEAD of r, and reuses the container of source
+// rather than building a new one if withContainer is set.
+func (r *Repository) Clone(ctx context.Context, dag *dagger.Client, source *environment.Environment, withContainer bool, description, explanation string) (_ *environment.Environment, rerr error) {
+	id, err := r.newEnvironmentID(ctx)
+	if err != nil {
 		return nil, err
 	}
+	defer r.discardOnError(ctx, id, &rerr)
 
 	worktree, err := r.initializeWorktree(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	state, err := r.loadState(ctx, worktree)
+	worktreeHead, err := r.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	sourceDir, err := r.sourceDirectory(ctx, dag, worktree, strings.TrimSpace(worktreeHead))
 	if err != nil {
 		return nil, err
 	}
 
-	env, err := environment.Load(ctx, dag, id, state, worktree)
+	env, err := source.Clone(ctx, id, description, sourceDir, withContainer)
 	if err != nil {
+		return nil, r.SaveBuildError(id, err)
+	}
+	if err := r.saveLastBuild(env); err != nil {
+		return nil, err
+	}
+
+	if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
 		return nil, err
 	}
 
 	return env, nil
 }
 
-// Info retrieves environment metadata without requiring dagger operations.
-// This is more efficient than Get() when you only need access to configuration,
-// state, and other metadata without performing container operations.
-func (r *Repository) Info(ctx context.Context, id string) (*environment.EnvironmentInfo, error) {
-	if err := r.exists(ctx, id); err != nil {
+// Fork creates a new environment as a deep copy of environment sourceID: its branch continues the history
+// of sourceID, and its container is a snapshot of the container of sourceID, untracked files of the workdir
+// included, so that experiments can start from a fully warmed environment. The title defaults to the
+// title of sourceID.
+func (r *Repository) Fork(ctx context.Context, dag *dagger.Client, sourceID, title, explanation string) (_ *environment.Environment, rerr error) {
+	source, err := r.Get(ctx, dag, sourceID)
+	if err != nil {
 		return nil, err
 	}
+	if title == "" {
+		title = source.State.Title
+	}
 
-	worktree, err := r.initializeWorktree(ctx, id)
+	tip, err := r.managedGit(ctx, r.forkRepoPath, "rev-parse", environmentRef(sourceID))
 	if err != nil {
 		return nil, err
 	}
+	// The branch of the fork is pushed from the source repository, which may not have the latest commit yet
+	if _, err := RunGitCommand(ctx, r.userRepoPath, "fetch", containerUseRemote, sourceID); err != nil {
+		return nil, err
+	}
 
-	state, err := r.loadState(ctx, worktree)
+	id, err := r.newEnvironmentID(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer r.discardOnError(ctx, id, &rerr)
 
-	envInfo, err := environment.LoadInfo(ctx, id, state, worktree)
+	worktree, err := r.initializeWorktreeFromRef(ctx, id, strings.TrimSpace(tip))
 	if err != nil {
 		return nil, err
 	}
+	// The state of an environment is a note on its latest commit: start with a commit of its own so that
+	// saving the state of the fork doesn't overwrite the state of sourceID
+	if _, err := r.managedGit(ctx, worktree, "commit", "--allow-empty", "--allow-empty-message", "-m", explanation); err != nil {
+		return nil, err
+	}
 
-	return envInfo, nil
+	env, err := source.Fork(ctx, id, title)
+	if err != nil {
+		return nil, r.SaveBuildError(id, err)
+	}
+	if err := r.saveLastBuild(env); err != nil {
+		return nil, err
+	}
+
+	if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
+		return nil, err
+	}
+
+	return env, nil
 }
 
-// List returns information about all environments in the repository.
-// Returns EnvironmentInfo slice avoiding dagger client initialization.
-// Use Get() on individual environments when you need full Environment with container operations.
-func (r *Repository) List(ctx context.Context) ([]*environment.EnvironmentInfo, error) {
-	branches, err := RunGitCommand(ctx, r.forkRepoPath, "branch", "--format", "%(refname:short)")
+// CreateFromImage creates a new environment whose source tree is extracted from a container image,
+// such as a CI build artifact. The image becomes the base image of the environment and its tree is
+// committed on top of the current HEAD, so the differences with the source repository are visible in the history.
+// If sourcePath is empty, the working directory of the image is used.
+func (r *Repository) CreateFromImage(ctx context.Context, dag *dagger.Client, image, sourcePath, description, explanation string) (_ *environment.Environment, rerr error) {
+	imageContainer := dag.Container().From(image)
+	if sourcePath == "" {
+		workdir, err := imageContainer.Workdir(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect image %s: %w", image, err)
+		}
+		sourcePath = workdir
+	}
+	if sourcePath == "" || sourcePath == "/" {
+		return nil, fmt.Errorf("image %s has no working directory, the path of the source tree must be provided", image)
+	}
+
+	id, err := r.newEnvironmentID(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer r.discardOnError(ctx, id, &rerr)
 
-	envs := []*environment.EnvironmentInfo{}
-	for branch := range strings.SplitSeq(branches, "\n") {
-		branch = strings.TrimSpace(branch)
+	worktree, err := r.initializeWorktree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 
-		// FIXME(aluzzardi): This is a hack to make sure the branch is actually an environment.
-		// There must be a better way to do this.
-		worktree, err := r.WorktreePath(branch)
-		if err != nil {
+	config := environment.DefaultConfig()
+	if err := config.Load(worktree); err != nil {
+		return nil, err
+	}
+	// The image is used as-is: setup commands already ran when it was built.
+	config.BaseImage = image
+	config.Dockerfile, config.BuildArgs, config.BuildTarget = "", nil, ""
+	config.Workdir = sourcePath
+	config.SetupCommands = nil
+	config.SetupPhases = nil
+	if config.AllowedMounts, err = r.allowedMounts(); err != nil {
+		return nil, err
+	}
+
+	sourceDir := imageContainer.Directory(sourcePath).WithoutDirectory(".git")
+
+	env, err := environment.NewWithConfig(ctx, dag, id, description, config, sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// Restore brings environment id back from a checkpointed image: its container is started from image
+// rather than rebuilt, on top of the current state of the environment branch and its notes.
+// Archived environments are unarchived first. If image is empty, the last checkpoint of the
+// environment is used.
+func (r *Repository) Restore(ctx context.Context, dag *dagger.Client, id, image, explanation string) (*environment.Environment, error) {
+	if err := r.exists(ctx, id); err != nil {
+		info, archiveErr := r.archiveInfo(id)
+		if archiveErr != nil {
 			return nil, err
 		}
-		state, err := r.loadState(ctx, worktree)
-		if err != nil || state == nil {
-			continue
+		if _, err := r.Unarchive(ctx, id); err != nil {
+			return nil, err
 		}
+		if image == "" {
+			image = info.Checkpoint
+		}
+	}
 
-		envInfo, err := r.Info(ctx, branch)
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+	if image == "" {
+		image = env.State.Checkpoint
+	}
+	if image == "" {
+		return nil, fmt.Errorf("environment %q has no checkpoint, an image must be provided", id)
+	}
+
+	head, err := r.managedGit(ctx, r.forkRepoPath, "rev-parse", id)
+	if err != nil {
+		return nil, err
+	}
+	sourceDir := dag.
+		Host().
+		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(strings.TrimSpace(head)).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true})
+
+	if err := env.Restore(ctx, image, sourceDir); err != nil {
+		return nil, err
+	}
+	if err := r.Update(ctx, env, explanation); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// Get retrieves a full Environment with dagger client embedded for container operations.
+// Use this when you need to perform container operations like running commands, terminals, etc.
+// For basic metadata access without container operations, use Info() instead.
+func (r *Repository) Get(ctx context.Context, dag *dagger.Client, id string) (*environment.Environment, error) {
+	state, config, err := r.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := environment.Load(ctx, dag, id, state, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// Info retrieves environment metadata without requiring dagger operations.
+// This is more efficient than Get() when you only need access to configuration,
+// state, and other metadata without performing container operations.
+func (r *Repository) Info(ctx context.Context, id string) (*environment.EnvironmentInfo, error) {
+	state, config, err := r.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	envInfo, err := environment.LoadInfo(ctx, id, state, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return envInfo, nil
+}
+
+// CommandPolicy returns the policy restricting the commands run in the environments of the repository.
+// It is read from the source repository, which agents can't modify, rather than from environments.
+func (r *Repository) CommandPolicy() (*environment.CommandPolicy, error) {
+	return environment.LoadCommandPolicy(r.userRepoPath)
+}
+
+// EnvironmentSummary is an environment as listed by List: its metadata along with
+// facts about its branch, as recorded by git.
+type EnvironmentSummary struct {
+	*environment.EnvironmentInfo
+
+	// CreatedAt and UpdatedAt are the dates of the first and last commits of the environment.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Ahead is the number of commits of the environment that are not on the current branch of the source
+	// repository, Behind the number of commits of the current branch that are not in the environment.
+	Ahead  int `json:"ahead"`
+	Behind int `json:"behind"`
+	// Dirty reports whether the worktree of the environment has changes that were not committed.
+	Dirty bool `json:"dirty"`
+}
+
+// List returns information about all environments in the repository.
+// Returns EnvironmentSummary slice avoiding dagger client initialization.
+// Use Get() on individual environments when you need full Environment with container operations.
+func (r *Repository) List(ctx context.Context) ([]*EnvironmentSummary, error) {
+	ids, err := r.environmentBranches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	envs := []*EnvironmentSummary{}
+	for _, id := range ids {
+		envInfo, err := r.Info(ctx, id)
 		if err != nil {
 			return nil, err
 		}
-
-		envs = append(envs, envInfo)
+		envs = append(envs, r.summarize(ctx, envInfo))
 	}
 
-	// Sort by most recently updated environments first
+	// Sort by most recently updated environments first, then by ID so that the order is stable
 	sort.Slice(envs, func(i, j int) bool {
-		return envs[i].State.UpdatedAt.After(envs[j].State.UpdatedAt)
+		if !envs[i].UpdatedAt.Equal(envs[j].UpdatedAt) {
+			return envs[i].UpdatedAt.After(envs[j].UpdatedAt)
+		}
+		return envs[i].ID < envs[j].ID
 	})
 
 	return envs, nil
 }
 
+// environmentBranches returns the branches of the fork that hold an environment: those whose tip carries
+// a state note. Full ref names are used so that branch names containing slashes, or clashing with tags,
+// are never mistaken for one another.
+func (r *Repository) environmentBranches(ctx context.Context) ([]string, error) {
+	notes, err := r.managedGit(ctx, r.forkRepoPath, "notes", "--ref", gitNotesStateRef, "list")
+	if err != nil {
+		// There are no notes until the first environment is created
+		return nil, nil
+	}
+	annotated := map[string]bool{}
+	for line := range strings.Lines(notes) {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			annotated[fields[1]] = true
+		}
+	}
+
+	branches, err := r.managedGit(ctx, r.forkRepoPath, "for-each-ref", "--format", "%(objectname) %(refname)", "refs/heads/")
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for line := range strings.Lines(branches) {
+		commit, ref, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || !annotated[commit] {
+			continue
+		}
+		ids = append(ids, strings.TrimPrefix(ref, "refs/heads/"))
+	}
+	return ids, nil
+}
+
+// summarize gathers the git facts about environment envInfo. Facts that can't be determined,
+// e.g. because the environment branch wasn't fetched in the source repository, are left out.
+func (r *Repository) summarize(ctx context.Context, envInfo *environment.EnvironmentInfo) *EnvironmentSummary {
+	summary := &EnvironmentSummary{
+		EnvironmentInfo: envInfo,
+		CreatedAt:       envInfo.State.CreatedAt,
+		UpdatedAt:       envInfo.State.UpdatedAt,
+	}
+
+	if dates, err := r.managedGit(ctx, r.forkRepoPath, "log", "-1", "--format=%cI", "refs/heads/"+envInfo.ID); err == nil {
+		if updatedAt, err := time.Parse(time.RFC3339, strings.TrimSpace(dates)); err == nil {
+			summary.UpdatedAt = updatedAt
+		}
+	}
+
+	currentBranch, err := r.currentUserBranch(ctx)
+	if err == nil {
+		currentBranch = strings.TrimSpace(currentBranch)
+		if currentBranch == "" {
+			currentBranch = "HEAD"
+		}
+		envGitRef := fmt.Sprintf("%s/%s", containerUseRemote, envInfo.ID)
+		if counts, err := RunGitCommand(ctx, r.userRepoPath, "rev-list", "--left-right", "--count", currentBranch+"..."+envGitRef); err == nil {
+			if behind, ahead, ok := strings.Cut(strings.TrimSpace(counts), "\t"); ok {
+				summary.Behind, _ = strconv.Atoi(behind)
+				summary.Ahead, _ = strconv.Atoi(ahead)
+			}
+		}
+		if dates, err := RunGitCommand(ctx, r.userRepoPath, "log", "--reverse", "--format=%cI", currentBranch+".."+envGitRef); err == nil {
+			first, _, _ := strings.Cut(dates, "\n")
+			if createdAt, err := time.Parse(time.RFC3339, strings.TrimSpace(first)); err == nil {
+				summary.CreatedAt = createdAt
+			}
+		}
+	}
+
+	if worktreePath, err := r.WorktreePath(envInfo.ID); err == nil {
+		if status, err := r.managedGit(ctx, worktreePath, "status", "--porcelain"); err == nil {
+			summary.Dirty = strings.TrimSpace(status) != ""
+		}
+	}
+	return summary
+}
+
 // Update saves the provided environment to the repository.
 // Writes configuration and source code changes to the worktree and history + state to git notes.
 func (r *Repository) Update(ctx context.Context, env *environment.Environment, explanation string) error {
+	ctx, unlock, err := r.lockEnvironment(ctx, env.ID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// The changes left pending by UpdateLazily are committed along
+	pending, err := r.pendingChanges(env.ID)
+	if err != nil {
+		return err
+	}
+	notes := []string{}
+	if pending != nil {
+		explanation = batchCommitMessage(append(pending.Messages, explanation))
+		notes = pending.Notes
+	}
+
+	if err := r.saveLastBuild(env); err != nil {
+		return err
+	}
 	if err := r.propagateToWorktree(ctx, env, explanation); err != nil {
 		return err
 	}
+	if err := r.deletePendingChanges(env.ID); err != nil {
+		return err
+	}
 	if note := env.Notes.Pop(); note != "" {
-		return r.addGitNote(ctx, env, note)
+		notes = append(notes, env.MaskSecrets(ctx, note))
+	}
+	if len(notes) > 0 {
+		return r.addGitNote(ctx, env, strings.Join(notes, "\n"))
 	}
 
 	return nil
@@ -287,6 +797,17 @@ func (r *Repository) Update(ctx context.Context, env *environment.Environment, e
 
 // Delete removes an environment from the repository.
 func (r *Repository) Delete(ctx context.Context, id string) error {
+	ctx, unlock, err := r.lockEnvironment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ctx, unlockRepository, err := r.lockRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlockRepository()
+
 	if err := r.exists(ctx, id); err != nil {
 		return err
 	}
@@ -297,6 +818,20 @@ func (r *Repository) Delete(ctx context.Context, id string) error {
 	if err := r.deleteLocalRemoteBranch(id); err != nil {
 		return err
 	}
+	r.pruneDeleted(ctx, id)
+	environment.ForgetWarmContainer(id)
+	if err := r.deleteBuildLogs(id); err != nil {
+		return err
+	}
+	if err := r.deleteJobs(id); err != nil {
+		return err
+	}
+	if err := r.deleteApprovals(id); err != nil {
+		return err
+	}
+	if err := r.deletePendingChanges(id); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -353,33 +888,6 @@ func (r *Repository) Checkout(ctx context.Context, id, branch string) (string, e
 	return branch, err
 }
 
-func (r *Repository) Log(ctx context.Context, id string, patch bool, w io.Writer) error {
-	envInfo, err := r.Info(ctx, id)
-	if err != nil {
-		return err
-	}
-
-	logArgs := []string{
-		"log",
-		fmt.Sprintf("--notes=%s", gitNotesLogRef),
-	}
-
-	if patch {
-		logArgs = append(logArgs, "--patch")
-	} else {
-		logArgs = append(logArgs, "--format=%C(yellow)%h%Creset  %s %Cgreen(%cr)%Creset %+N")
-	}
-
-	revisionRange, err := r.revisionRange(ctx, envInfo)
-	if err != nil {
-		return err
-	}
-
-	logArgs = append(logArgs, revisionRange)
-
-	return RunInteractiveGitCommand(ctx, r.userRepoPath, w, logArgs...)
-}
-
 func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
 	envInfo, err := r.Info(ctx, id)
 	if err != nil {
@@ -388,6 +896,7 @@ func (r *Repository) Diff(ctx context.Context, id string, w io.Writer) error {
 
 	diffArgs := []string{
 		"diff",
+		"--submodule=log",
 	}
 
 	revisionRange, err := r.revisionRange(ctx, envInfo)
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"strings"
@@ -66,3 +67,230 @@ func TestRepositoryOpen(t *testing.T) {
 		assert.Equal(t, repo.forkRepoPath, strings.TrimSpace(remote))
 	})
 }
+
+// TestRepositoryMaintenance verifies that maintenance removes orphaned worktrees
+// and leaves live environments alone.
+func TestRepositoryMaintenance(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+
+	live, err := repo.initializeWorktree(ctx, "live-env")
+	require.NoError(t, err)
+	orphan, err := repo.initializeWorktree(ctx, "orphan-env")
+	require.NoError(t, err)
+
+	// Simulate an environment whose branch was deleted without cleaning up its worktree
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "worktree", "remove", "--force", orphan)
+	require.NoError(t, err)
+	createDir(t, orphan, "")
+	writeFile(t, orphan, ".git", "gitdir: "+repo.forkRepoPath+"/worktrees/orphan-env")
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "branch", "-D", "orphan-env")
+	require.NoError(t, err)
+
+	report, err := repo.Maintenance(ctx, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"orphan-env"}, report.PrunedWorktrees)
+	assert.NoDirExists(t, orphan)
+	assert.DirExists(t, live)
+	assert.Positive(t, report.SizeAfter)
+}
+
+// TestRepositoryArchive verifies that an archived environment can be restored with its history and notes.
+func TestRepositoryArchive(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	for _, dir := range []string{tempDir, repo.forkRepoPath} {
+		_, err = RunGitCommand(ctx, dir, "config", "user.email", "test@example.com")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, dir, "config", "user.name", "Test User")
+		require.NoError(t, err)
+	}
+
+	worktree, err := repo.initializeWorktree(ctx, "cold-env")
+	require.NoError(t, err)
+	writeFile(t, worktree, "work.txt", "agent work")
+	_, err = RunGitCommand(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "commit", "-m", "Agent work")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "Cold storage"}`)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesLogRef, "add", "-m", "$ make build")
+	require.NoError(t, err)
+	head, err := RunGitCommand(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+
+	info, err := repo.Archive(ctx, "cold-env", "registry.example.com/cold-env@sha256:abc")
+	require.NoError(t, err)
+	assert.Equal(t, "Cold storage", info.Title)
+	assert.FileExists(t, info.Bundle)
+	assert.NoDirExists(t, worktree)
+	assert.Error(t, repo.exists(ctx, "cold-env"))
+
+	archives, err := repo.Archives()
+	require.NoError(t, err)
+	require.Len(t, archives, 1)
+	assert.Equal(t, "cold-env", archives[0].ID)
+	assert.Equal(t, "registry.example.com/cold-env@sha256:abc", archives[0].Checkpoint)
+
+	_, err = repo.Unarchive(ctx, "cold-env")
+	require.NoError(t, err)
+	assert.NoFileExists(t, info.Bundle)
+
+	restoredHead, err := RunGitCommand(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head, restoredHead)
+
+	envInfo, err := repo.Info(ctx, "cold-env")
+	require.NoError(t, err)
+	assert.Equal(t, "Cold storage", envInfo.State.Title)
+
+	log, err := RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesLogRef, "show")
+	require.NoError(t, err)
+	assert.Contains(t, log, "make build")
+
+	_, err = repo.Unarchive(ctx, "cold-env")
+	assert.ErrorContains(t, err, "no archive found")
+}
+
+func TestRepositoryListFiltering(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.name", "Test User")
+	require.NoError(t, err)
+
+	envs, err := repo.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+
+	// An environment whose name contains a slash and clashes with a tag
+	worktree, err := repo.initializeWorktree(ctx, "agent/fix-login")
+	require.NoError(t, err)
+	writeFile(t, worktree, "login.go", "package login")
+	_, err = RunGitCommand(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "commit", "-m", "Fix login")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "Fix login"}`)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "tag", "agent/fix-login", "refs/heads/agent/fix-login~1")
+	require.NoError(t, err)
+	// A branch that isn't an environment
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "branch", "scratch", "refs/heads/agent/fix-login~1")
+	require.NoError(t, err)
+
+	envs, err = repo.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.Equal(t, "agent/fix-login", envs[0].ID)
+	assert.Equal(t, "Fix login", envs[0].State.Title)
+	assert.False(t, envs[0].UpdatedAt.IsZero())
+	assert.False(t, envs[0].Dirty)
+
+	writeFile(t, worktree, "login.go", "package login // edited")
+	envs, err = repo.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, envs, 1)
+	assert.True(t, envs[0].Dirty)
+}
+
+func TestDiscardEnvironment(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+
+	// A creation that failed after its worktree was set up
+	worktree, err := repo.initializeWorktree(ctx, "broken-env")
+	require.NoError(t, err)
+	require.NoError(t, repo.exists(ctx, "broken-env"))
+
+	createErr := errors.New("build failed")
+	err = createErr
+	repo.discardOnError(ctx, "broken-env", &err)
+	assert.Equal(t, createErr, err)
+
+	assert.NoDirExists(t, worktree)
+	assert.Error(t, repo.exists(ctx, "broken-env"))
+	refs, err := RunGitCommand(ctx, tempDir, "for-each-ref", "refs/remotes/"+containerUseRemote)
+	require.NoError(t, err)
+	assert.Empty(t, refs)
+	worktrees, err := RunGitCommand(ctx, repo.forkRepoPath, "worktree", "list")
+	require.NoError(t, err)
+	assert.NotContains(t, worktrees, "broken-env")
+	envs, err := repo.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, envs)
+
+	// Discarding is idempotent, e.g. when the creation failed before the branch was pushed
+	require.NoError(t, repo.discardEnvironment(ctx, "broken-env"))
+
+	// The ID can be used again
+	_, err = repo.initializeWorktree(ctx, "broken-env")
+	require.NoError(t, err)
+}
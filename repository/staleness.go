@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+)
+
+const (
+	// staleBehindCommits is how many commits the source branch can gain past an environment's fork point
+	// before the environment is considered out of date.
+	staleBehindCommits = 50
+	// staleIdleTime is how long an environment can go untouched before it is considered abandoned.
+	staleIdleTime = 14 * 24 * time.Hour
+)
+
+// Staleness describes how far an environment has drifted from the source repository.
+type Staleness struct {
+	// BehindCommits is the number of commits made on the current branch since the environment forked from it.
+	BehindCommits int
+	// Idle is the time since the environment was last updated.
+	Idle time.Duration
+}
+
+// Notices returns advisory messages suggesting how to deal with a stale environment, if any.
+func (s *Staleness) Notices(id string) []string {
+	notices := []string{}
+	if s.BehindCommits >= staleBehindCommits {
+		notices = append(notices, fmt.Sprintf("The source branch has gained %d commits since this environment was created. Consider merging it (container-use merge %s) or deleting it (container-use delete %s) and starting from the latest code.", s.BehindCommits, id, id))
+	}
+	if s.Idle >= staleIdleTime {
+		notices = append(notices, fmt.Sprintf("This environment hasn't been updated in %d days. If the work is done, merge it (container-use merge %s); if it was abandoned, delete it (container-use delete %s).", int(s.Idle.Hours()/24), id, id))
+	}
+	return notices
+}
+
+// Staleness reports how far an environment has drifted from the current branch of the source repository.
+func (r *Repository) Staleness(ctx context.Context, env *environment.EnvironmentInfo) (*Staleness, error) {
+	staleness := &Staleness{}
+	if !env.State.UpdatedAt.IsZero() {
+		staleness.Idle = time.Since(env.State.UpdatedAt)
+	}
+
+	mergeBase, err := r.mergeBase(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+	currentBranch, err := r.currentUserBranch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	currentBranch = strings.TrimSpace(currentBranch)
+	if currentBranch == "" {
+		currentBranch = "HEAD"
+	}
+	count, err := RunGitCommand(ctx, r.userRepoPath, "rev-list", "--count", mergeBase+".."+currentBranch)
+	if err != nil {
+		return nil, err
+	}
+	staleness.BehindCommits, err = strconv.Atoi(strings.TrimSpace(count))
+	if err != nil {
+		return nil, err
+	}
+
+	return staleness, nil
+}
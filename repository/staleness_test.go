@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStalenessNotices(t *testing.T) {
+	tests := []struct {
+		name      string
+		staleness Staleness
+		expected  int
+	}{
+		{"fresh", Staleness{BehindCommits: 3, Idle: time.Hour}, 0},
+		{"behind", Staleness{BehindCommits: staleBehindCommits}, 1},
+		{"idle", Staleness{Idle: staleIdleTime + time.Hour}, 1},
+		{"behind_and_idle", Staleness{BehindCommits: 200, Idle: 30 * 24 * time.Hour}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notices := tt.staleness.Notices("fancy-mallard")
+			assert.Len(t, notices, tt.expected)
+			for _, notice := range notices {
+				assert.Contains(t, notice, "fancy-mallard")
+			}
+		})
+	}
+}
+
+func TestRepositoryStaleness(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	_, err = repo.initializeWorktree(ctx, "drifting-env")
+	require.NoError(t, err)
+
+	for i := range 3 {
+		_, err = RunGitCommand(ctx, tempDir, "commit", "--allow-empty", "-m", fmt.Sprintf("Upstream change %d", i))
+		require.NoError(t, err)
+	}
+
+	staleness, err := repo.Staleness(ctx, &environment.EnvironmentInfo{
+		ID:    "drifting-env",
+		State: &environment.State{UpdatedAt: time.Now().Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, staleness.BehindCommits)
+	assert.InDelta(t, time.Hour.Seconds(), staleness.Idle.Seconds(), 60)
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	worktreeStorageEnv = "CONTAINER_USE_WORKTREE_STORAGE"
+	tmpfsDirEnv        = "CONTAINER_USE_TMPFS_DIR"
+)
+
+// WorktreeStorage selects where the worktrees of environments are placed.
+// Worktrees receive a full export of the environment on every update, which dominates
+// the cost of updates on repositories with huge dependency trees: keeping them in memory
+// makes those exports much faster.
+type WorktreeStorage string
+
+const (
+	// WorktreeStorageDisk keeps worktrees under the base path. This is the default.
+	WorktreeStorageDisk WorktreeStorage = "disk"
+	// WorktreeStorageTmpfs keeps worktrees in memory. When they are lost (e.g. on reboot), they are
+	// checked out again from the environment branch: files that were never committed, such as
+	// binaries, are lost with them.
+	WorktreeStorageTmpfs WorktreeStorage = "tmpfs"
+	// WorktreeStorageOverlay keeps worktrees in memory and copies the changes up to the base path
+	// after every commit, which is where they are recovered from when lost.
+	WorktreeStorageOverlay WorktreeStorage = "overlay"
+)
+
+// worktreeStorageFromEnv returns the worktree storage configured with CONTAINER_USE_WORKTREE_STORAGE.
+func worktreeStorageFromEnv() (WorktreeStorage, error) {
+	switch storage := WorktreeStorage(os.Getenv(worktreeStorageEnv)); storage {
+	case "":
+		return WorktreeStorageDisk, nil
+	case WorktreeStorageDisk, WorktreeStorageTmpfs, WorktreeStorageOverlay:
+		return storage, nil
+	default:
+		return "", fmt.Errorf("invalid %s %q, expected one of disk, tmpfs, overlay", worktreeStorageEnv, storage)
+	}
+}
+
+// tmpfsPath returns the in-memory directory worktrees are placed in by the tmpfs and overlay storages.
+// It can be overridden with CONTAINER_USE_TMPFS_DIR.
+func tmpfsPath() string {
+	if dir := os.Getenv(tmpfsDirEnv); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "container-use")
+	}
+	return filepath.Join("/dev/shm", fmt.Sprintf("container-use-%d", os.Getuid()))
+}
+
+// persistentWorktreePath returns where the worktree of environment id is kept on disk: the worktree
+// itself with the disk storage, the copy it is recovered from with the overlay storage.
+func (r *Repository) persistentWorktreePath(id string) (string, error) {
+	return homedir.Expand(filepath.Join(r.basePath, "worktrees", id))
+}
+
+// worktreeRoots returns the directories that may hold worktrees, including those left
+// on disk after switching to an in-memory storage.
+func (r *Repository) worktreeRoots() ([]string, error) {
+	root, err := r.WorktreePath("")
+	if err != nil {
+		return nil, err
+	}
+	persistent, err := r.persistentWorktreePath("")
+	if err != nil {
+		return nil, err
+	}
+	if root == persistent {
+		return []string{root}, nil
+	}
+	return []string{root, persistent}, nil
+}
+
+// copyUpWorktree copies the worktree of environment id to persistent storage when using the overlay storage.
+func (r *Repository) copyUpWorktree(id string) error {
+	if r.storage != WorktreeStorageOverlay {
+		return nil
+	}
+	worktreePath, err := r.WorktreePath(id)
+	if err != nil {
+		return err
+	}
+	persistent, err := r.persistentWorktreePath(id)
+	if err != nil {
+		return err
+	}
+	return syncDir(worktreePath, persistent)
+}
+
+// recoverWorktree recreates the worktree of the existing environment id at worktreePath after it
+// was lost, e.g. because tmpfs was cleared by a reboot. With the overlay storage its files are copied
+// down from persistent storage, otherwise they are checked out from the environment branch.
+func (r *Repository) recoverWorktree(ctx context.Context, id, worktreePath string) error {
+	slog.Info("Recovering worktree", "repository", r.userRepoPath, "container-id", id, "storage", r.storage)
+
+	// Drop the registration of the lost worktree, unless it was moved from another storage and still exists
+	if _, err := r.managedGit(ctx, r.forkRepoPath, "worktree", "prune"); err != nil {
+		return err
+	}
+	adminDir := filepath.Join(r.forkRepoPath, "worktrees", id)
+	// The recovered files may not be the ones last exported, the next export writes all of them
+	if err := os.Remove(filepath.Join(adminDir, exportStateFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(adminDir); err != nil {
+		if _, err := r.managedGit(ctx, r.forkRepoPath, "worktree", "add", "--no-checkout", worktreePath, id); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(worktreePath, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(worktreePath, ".git"), []byte("gitdir: "+adminDir+"\n"), 0644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(adminDir, "gitdir"), []byte(filepath.Join(worktreePath, ".git")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	persistent, err := r.persistentWorktreePath(id)
+	if err != nil {
+		return err
+	}
+	if r.storage == WorktreeStorageOverlay && persistent != worktreePath {
+		if _, err := os.Stat(persistent); err == nil {
+			if err := syncDir(persistent, worktreePath); err != nil {
+				return fmt.Errorf("failed to copy down %s: %w", persistent, err)
+			}
+			_, err := r.managedGit(ctx, worktreePath, "reset", "--quiet")
+			return err
+		}
+	}
+	_, err = r.managedGit(ctx, worktreePath, "reset", "--hard", "--quiet")
+	return err
+}
+
+// syncDir makes dst a copy of src. Files whose size and modification time match are left alone,
+// so that only the changes are copied.
+func syncDir(src, dst string) error {
+	seen := map[string]bool{}
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		seen[rel] = true
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		existing, err := os.Lstat(target)
+		if err == nil && existing.Mode().Type() != info.Mode().Type() {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			err = os.ErrNotExist
+		}
+
+		switch {
+		case d.IsDir():
+			if err != nil {
+				return os.MkdirAll(target, info.Mode().Perm())
+			}
+			return nil
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, lerr := os.Readlink(path)
+			if lerr != nil {
+				return lerr
+			}
+			if err == nil {
+				if current, _ := os.Readlink(target); current == link {
+					return nil
+				}
+				if err := os.Remove(target); err != nil {
+					return err
+				}
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err == nil && existing.Size() == info.Size() && existing.ModTime().Equal(info.ModTime()) {
+				return nil
+			}
+			return copyFile(path, target, info)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove what is no longer in src
+	removed := []string{}
+	err = filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if !seen[rel] {
+			removed = append(removed, path)
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range removed {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Write to a temporary file first so that an interrupted copy never leaves a truncated file behind
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".cu-tmp")
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
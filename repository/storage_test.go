@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncDir(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "copy")
+
+	writeFile(t, src, "main.go", "package main")
+	writeFile(t, src, "node_modules/left-pad/index.js", "module.exports = {}")
+	require.NoError(t, os.Symlink("main.go", filepath.Join(src, "link.go")))
+	require.NoError(t, syncDir(src, dst))
+
+	for _, name := range []string{"main.go", "node_modules/left-pad/index.js"} {
+		assert.FileExists(t, filepath.Join(dst, name))
+	}
+	link, err := os.Readlink(filepath.Join(dst, "link.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "main.go", link)
+
+	// Unchanged files are not copied again
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dst, "main.go"), old, old))
+	require.NoError(t, os.Chtimes(filepath.Join(src, "main.go"), old, old))
+	marker := filepath.Join(dst, "main.go")
+	require.NoError(t, os.WriteFile(marker, []byte("package mine"), 0644))
+	require.NoError(t, os.Chtimes(marker, old, old))
+
+	writeFile(t, src, "README.md", "# Docs")
+	require.NoError(t, os.RemoveAll(filepath.Join(src, "node_modules")))
+	require.NoError(t, syncDir(src, dst))
+
+	data, err := os.ReadFile(filepath.Join(dst, "main.go"))
+	require.NoError(t, err)
+	assert.Equal(t, "package mine", string(data), "size and time matched, the file was left alone")
+	assert.FileExists(t, filepath.Join(dst, "README.md"))
+	assert.NoDirExists(t, filepath.Join(dst, "node_modules"))
+}
+
+func TestWorktreeStorageFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected WorktreeStorage
+		err      bool
+	}{
+		{value: "", expected: WorktreeStorageDisk},
+		{value: "disk", expected: WorktreeStorageDisk},
+		{value: "tmpfs", expected: WorktreeStorageTmpfs},
+		{value: "overlay", expected: WorktreeStorageOverlay},
+		{value: "ramdisk", err: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv(worktreeStorageEnv, tc.value)
+			storage, err := worktreeStorageFromEnv()
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, storage)
+		})
+	}
+}
+
+func TestWorktreeRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		storage    WorktreeStorage
+		keepsCache bool
+	}{
+		{storage: WorktreeStorageTmpfs, keepsCache: false},
+		{storage: WorktreeStorageOverlay, keepsCache: true},
+	} {
+		t.Run(string(tc.storage), func(t *testing.T) {
+			ctx := context.Background()
+			tempDir := t.TempDir()
+			configDir := t.TempDir()
+			tmpfsDir := t.TempDir()
+			t.Setenv(worktreeStorageEnv, string(tc.storage))
+			t.Setenv(tmpfsDirEnv, tmpfsDir)
+
+			_, err := RunGitCommand(ctx, tempDir, "init")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+			require.NoError(t, err)
+			writeFile(t, tempDir, "README.md", "# Test")
+			_, err = RunGitCommand(ctx, tempDir, "add", ".")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+			require.NoError(t, err)
+
+			repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.email", "test@example.com")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.name", "Test User")
+			require.NoError(t, err)
+
+			worktree, err := repo.initializeWorktree(ctx, "ram-env")
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(tmpfsDir, "worktrees", "ram-env"), worktree)
+
+			writeFile(t, worktree, "work.txt", "agent work")
+			_, err = RunGitCommand(ctx, worktree, "add", ".")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, worktree, "commit", "-m", "Agent work")
+			require.NoError(t, err)
+			_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "In memory"}`)
+			require.NoError(t, err)
+			writeBinaryFile(t, worktree, "cache.bin", 128)
+			require.NoError(t, repo.copyUpWorktree("ram-env"))
+
+			// Simulate a reboot clearing tmpfs
+			require.NoError(t, os.RemoveAll(tmpfsDir))
+
+			envs, err := repo.List(ctx)
+			require.NoError(t, err)
+			require.Len(t, envs, 1)
+			assert.Equal(t, "In memory", envs[0].State.Title)
+			// Reading doesn't touch worktrees, they are recovered on the next write
+			assert.NoDirExists(t, worktree)
+			_, err = repo.initializeWorktree(ctx, "ram-env")
+			require.NoError(t, err)
+
+			assert.FileExists(t, filepath.Join(worktree, "work.txt"))
+			if tc.keepsCache {
+				assert.FileExists(t, filepath.Join(worktree, "cache.bin"))
+			} else {
+				assert.NoFileExists(t, filepath.Join(worktree, "cache.bin"))
+			}
+			status, err := RunGitCommand(ctx, worktree, "status", "--porcelain", "--untracked-files=no")
+			require.NoError(t, err)
+			assert.Empty(t, status)
+
+			require.NoError(t, repo.Delete(ctx, "ram-env"))
+			persistent, err := repo.persistentWorktreePath("ram-env")
+			require.NoError(t, err)
+			assert.NoDirExists(t, persistent)
+			assert.NoDirExists(t, worktree)
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dagger.io/dagger"
+)
+
+const gitModulesFile = ".gitmodules"
+
+// updateSubmodules checks out, in worktreePath, the submodules the source repository has checked out.
+// They are cloned from its checkouts, so that they need no network access and have the commits of the
+// user, the others are left uninitialized like in the source repository.
+func (r *Repository) updateSubmodules(ctx context.Context, worktreePath string) error {
+	if _, err := os.Stat(filepath.Join(worktreePath, gitModulesFile)); err != nil {
+		return nil
+	}
+	out, err := r.managedGit(ctx, worktreePath, "config", "-z", "--file", gitModulesFile, "--get-regexp", `^submodule\..*\.path$`)
+	if err != nil {
+		// No submodule is declared
+		return nil
+	}
+
+	paths := []string{}
+	for _, entry := range strings.Split(out, "\x00") {
+		// -z prints the key and the value of each entry separated by a newline
+		key, path, ok := strings.Cut(entry, "\n")
+		if !ok {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "submodule."), ".path")
+		url := r.submoduleURL(ctx, name, path)
+		if url == "" {
+			continue
+		}
+		if _, err := r.managedGit(ctx, worktreePath, "config", "submodule."+name+".url", url); err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
+	slog.Info("Initializing submodules", "worktree", worktreePath, "paths", paths)
+	// Git refuses to clone submodules from local paths by default
+	args := append([]string{"-c", "protocol.file.allow=always", "submodule", "update", "--init", "--recursive", "--"}, paths...)
+	if _, err := r.managedGit(ctx, worktreePath, args...); err != nil {
+		return fmt.Errorf("failed to initialize submodules: %w", err)
+	}
+	return nil
+}
+
+// submoduleURL returns where to clone the submodule name at path from: the checkout of the source
+// repository if there is one, otherwise the URL it initialized the submodule with. It is empty if the
+// source repository didn't initialize the submodule.
+func (r *Repository) submoduleURL(ctx context.Context, name, path string) string {
+	checkout := filepath.Join(r.userRepoPath, path)
+	if _, err := os.Stat(filepath.Join(checkout, ".git")); err == nil {
+		return checkout
+	}
+	url, err := RunGitCommand(ctx, r.userRepoPath, "config", "--get", "submodule."+name+".url")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(url)
+}
+
+// checkedOutSubmodules returns the paths, relative to worktreePath, of the submodules checked out in it.
+// Nested submodules are included if recursive is set.
+func (r *Repository) checkedOutSubmodules(ctx context.Context, worktreePath string, recursive bool) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(worktreePath, gitModulesFile)); err != nil {
+		return nil, nil
+	}
+	args := []string{"submodule", "status"}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	out, err := r.managedGit(ctx, worktreePath, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseSubmoduleStatus(out), nil
+}
+
+// parseSubmoduleStatus returns the paths of the submodules git submodule status reports as checked out.
+func parseSubmoduleStatus(out string) []string {
+	paths := []string{}
+	for line := range strings.Lines(out) {
+		line = strings.TrimRight(line, "\n")
+		// <state><commit> <path>[ (<description>)], uninitialized submodules have a - state
+		if len(line) == 0 || line[0] == '-' {
+			continue
+		}
+		_, path, ok := strings.Cut(line[1:], " ")
+		if !ok {
+			continue
+		}
+		if i := strings.LastIndex(path, " ("); i >= 0 && strings.HasSuffix(path, ")") {
+			path = path[:i]
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// submoduleGitFiles returns the .git files of the submodules checked out in worktreePath, keyed by their
+// path. Exporting the environment wipes the worktree, they are written back so the submodules survive it.
+func (r *Repository) submoduleGitFiles(ctx context.Context, worktreePath string) (map[string]string, error) {
+	paths, err := r.checkedOutSubmodules(ctx, worktreePath, true)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{}
+	for _, path := range paths {
+		content, err := os.ReadFile(filepath.Join(worktreePath, path, ".git"))
+		if err != nil {
+			// Submodules cloned by older versions of git embed their repository, there is nothing to restore
+			continue
+		}
+		files[path] = string(content)
+	}
+	return files, nil
+}
+
+// commitSubmoduleChanges commits the changes made inside the submodules checked out in worktreePath,
+// so that the superproject then commits their new commits. Nested submodules are committed first.
+func (r *Repository) commitSubmoduleChanges(ctx context.Context, worktreePath, explanation string, checks *commitChecks) error {
+	paths, err := r.checkedOutSubmodules(ctx, worktreePath, false)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		var submoduleChecks *commitChecks
+		if checks != nil {
+			submoduleChecks = &commitChecks{masker: checks.masker, scanning: checks.scanning, binaries: checks.binaries}
+		}
+		if err := r.commitWorktreeChanges(ctx, filepath.Join(worktreePath, path), explanation, submoduleChecks); err != nil {
+			return fmt.Errorf("failed to commit the changes of submodule %s: %w", path, err)
+		}
+		if submoduleChecks == nil {
+			continue
+		}
+		for _, finding := range submoduleChecks.findings {
+			finding.File = filepath.ToSlash(filepath.Join(path, finding.File))
+			checks.findings = append(checks.findings, finding)
+		}
+		for _, file := range submoduleChecks.skipped {
+			checks.skipped = append(checks.skipped, filepath.ToSlash(filepath.Join(path, file)))
+		}
+	}
+	return nil
+}
+
+// fetchSubmoduleCommits fetches the commits of the submodules of environment id that the checkouts of the
+// source repository don't have into container-use/<id>, so that the submodule pointers of the environment
+// branch resolve once it is merged.
+func (r *Repository) fetchSubmoduleCommits(ctx context.Context, id, worktreePath string) error {
+	paths, err := r.checkedOutSubmodules(ctx, worktreePath, false)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		checkout := filepath.Join(r.userRepoPath, path)
+		if _, err := os.Stat(filepath.Join(checkout, ".git")); err != nil {
+			continue
+		}
+		head, err := r.managedGit(ctx, filepath.Join(worktreePath, path), "rev-parse", "HEAD")
+		if err != nil {
+			return err
+		}
+		if _, err := RunGitCommand(ctx, checkout, "cat-file", "-e", strings.TrimSpace(head)+"^{commit}"); err == nil {
+			continue
+		}
+		ref := fmt.Sprintf("+HEAD:refs/remotes/%s/%s", containerUseRemote, id)
+		if _, err := RunGitCommand(ctx, checkout, "fetch", "--no-tags", filepath.Join(worktreePath, path), ref); err != nil {
+			return fmt.Errorf("failed to fetch the commits of submodule %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// sourceDirectory returns the files of commit for a new container, worktreePath being checked out at it.
+// They come from the fork repository, unless the worktree has submodules checked out: their files are only
+// in the worktree.
+func (r *Repository) sourceDirectory(ctx context.Context, dag *dagger.Client, worktreePath, commit string) (*dagger.Directory, error) {
+	submodules, err := r.checkedOutSubmodules(ctx, worktreePath, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(submodules) > 0 {
+		return dag.Host().Directory(worktreePath, dagger.HostDirectoryOpts{NoCache: true, Exclude: []string{".git", "**/.git"}}), nil
+	}
+	return dag.
+		Host().
+		Directory(r.forkRepoPath, dagger.HostDirectoryOpts{NoCache: true}).
+		AsGit().
+		Ref(commit).
+		Tree(dagger.GitRefTreeOpts{DiscardGitDir: true}), nil
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmodules(t *testing.T) {
+	ctx := context.Background()
+	libRepo := t.TempDir()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+
+	for _, dir := range []string{libRepo, userRepo} {
+		_, err := RunGitCommand(ctx, dir, "init")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, dir, "config", "user.email", "test@example.com")
+		require.NoError(t, err)
+		_, err = RunGitCommand(ctx, dir, "config", "user.name", "Test User")
+		require.NoError(t, err)
+	}
+	writeFile(t, libRepo, "lib.go", "package lib")
+	_, err := RunGitCommand(ctx, libRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, libRepo, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	writeFile(t, userRepo, "README.md", "# Test")
+	_, err = RunGitCommand(ctx, userRepo, "-c", "protocol.file.allow=always", "submodule", "add", libRepo, "vendor/lib")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, userRepo, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+	// The source repository is moved away from where its submodule was cloned from
+	require.NoError(t, os.RemoveAll(libRepo))
+
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "submodule-env")
+	require.NoError(t, err)
+
+	t.Run("checked_out_from_source_repository", func(t *testing.T) {
+		content, err := os.ReadFile(filepath.Join(worktree, "vendor/lib/lib.go"))
+		require.NoError(t, err)
+		assert.Equal(t, "package lib", string(content))
+
+		gitFiles, err := repo.submoduleGitFiles(ctx, worktree)
+		require.NoError(t, err)
+		require.Contains(t, gitFiles, "vendor/lib")
+		assert.True(t, strings.HasPrefix(gitFiles["vendor/lib"], "gitdir: "))
+	})
+
+	t.Run("pointer_changes_are_committed", func(t *testing.T) {
+		before, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD:vendor/lib")
+		require.NoError(t, err)
+
+		writeFile(t, worktree, "vendor/lib/lib.go", "package lib\n\nconst Version = 2")
+		checks := &commitChecks{}
+		require.NoError(t, repo.commitWorktreeChanges(ctx, worktree, "Bump the library", checks))
+
+		after, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD:vendor/lib")
+		require.NoError(t, err)
+		assert.NotEqual(t, before, after, "the superproject points to the new commit of the submodule")
+		subject, err := repo.managedGit(ctx, filepath.Join(worktree, "vendor/lib"), "log", "-1", "--format=%s", strings.TrimSpace(after))
+		require.NoError(t, err)
+		assert.Equal(t, "Bump the library", strings.TrimSpace(subject))
+		status, err := repo.managedGit(ctx, worktree, "status", "--porcelain")
+		require.NoError(t, err)
+		assert.Empty(t, strings.TrimSpace(status))
+
+		require.NoError(t, repo.fetchSubmoduleCommits(ctx, "submodule-env", worktree))
+		fetched, err := RunGitCommand(ctx, filepath.Join(userRepo, "vendor/lib"), "rev-parse", "refs/remotes/container-use/submodule-env")
+		require.NoError(t, err)
+		assert.Equal(t, after, fetched, "the source repository gets the commits of the submodule")
+	})
+
+	t.Run("uninitialized_submodules_are_left_alone", func(t *testing.T) {
+		_, err := RunGitCommand(ctx, userRepo, "submodule", "deinit", "--all")
+		require.NoError(t, err)
+		worktree, err := repo.initializeWorktree(ctx, "deinit-env")
+		require.NoError(t, err)
+		paths, err := repo.checkedOutSubmodules(ctx, worktree, true)
+		require.NoError(t, err)
+		assert.Empty(t, paths)
+	})
+}
+
+func TestParseSubmoduleStatus(t *testing.T) {
+	status := ` 1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b vendor/lib (v1.2.0)
++5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e tools/my linter (heads/main)
+-9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a1f0e docs/theme
+ 0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b vendor/lib/nested
+`
+	assert.Equal(t, []string{"vendor/lib", "tools/my linter", "vendor/lib/nested"}, parseSubmoduleStatus(status))
+	assert.Empty(t, parseSubmoduleStatus(""))
+}
@@ -0,0 +1,508 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"dagger.io/dagger"
+	"github.com/dagger/container-use/environment"
+)
+
+// SyncStrategy is how Sync brings upstream changes into an environment.
+type SyncStrategy string
+
+const (
+	// SyncMerge merges the upstream branch into the environment branch.
+	SyncMerge SyncStrategy = "merge"
+	// SyncRebase replays the commits of the environment on top of the upstream branch. Their notes
+	// follow them.
+	SyncRebase SyncStrategy = "rebase"
+)
+
+// ErrNoSyncInProgress is returned when continuing or aborting a sync of an environment that has none.
+var ErrNoSyncInProgress = errors.New("no sync in progress")
+
+// SyncConflict is a file that both the environment and the upstream branch changed in ways git can't
+// reconcile.
+type SyncConflict struct {
+	Path string `json:"path"`
+	// Kind is how both sides changed the file, e.g. "both modified" or "deleted by upstream".
+	Kind string `json:"kind"`
+	// Resolved is set once the file has no conflict markers left.
+	Resolved bool `json:"resolved"`
+}
+
+// SyncResult describes what Sync did.
+type SyncResult struct {
+	Upstream       string       `json:"upstream,omitempty"`
+	UpstreamCommit string       `json:"upstream_commit,omitempty"`
+	Strategy       SyncStrategy `json:"strategy"`
+	// UpToDate is set if the environment already had the commits of the upstream branch.
+	UpToDate bool `json:"up_to_date,omitempty"`
+	// Commit is the head of the environment branch after the sync.
+	Commit string `json:"commit,omitempty"`
+	// ChangedFiles are the files of the workdir the sync changed.
+	ChangedFiles []string `json:"changed_files,omitempty"`
+	// Conflicts are the files to resolve for the sync to complete. The sync stays in progress until
+	// ContinueSync or AbortSync.
+	Conflicts []SyncConflict `json:"conflicts,omitempty"`
+}
+
+// SyncStatus reports the sync in progress in an environment, if any.
+type SyncStatus struct {
+	InProgress bool           `json:"in_progress"`
+	Strategy   SyncStrategy   `json:"strategy,omitempty"`
+	Conflicts  []SyncConflict `json:"conflicts,omitempty"`
+}
+
+// conflictKinds describe the unmerged states of git status --porcelain. Upstream is "theirs" when merging
+// and "ours" when rebasing, see conflictKind.
+var conflictKinds = map[string]string{
+	"DD": "deleted by both",
+	"AU": "added by environment",
+	"UD": "deleted by upstream",
+	"UA": "added by upstream",
+	"DU": "deleted by environment",
+	"AA": "added by both",
+	"UU": "both modified",
+}
+
+// Sync brings the commits of upstream, a branch of the source repository (its current branch if empty),
+// into environment id by merging or rebasing its branch, then updates the workdir of its container with
+// the result. Files that only exist in the container, such as installed dependencies, are kept.
+// If git can't reconcile both sides, the sync stays in progress: the workdir gets the conflicting files,
+// with conflict markers, which are reported in the result. Once they are resolved, ContinueSync completes
+// the sync.
+func (r *Repository) Sync(ctx context.Context, dag *dagger.Client, id, upstream string, strategy SyncStrategy, explanation string) (*SyncResult, error) {
+	ctx, unlock, err := r.lockEnvironment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if strategy == "" {
+		strategy = SyncMerge
+	}
+	if strategy != SyncMerge && strategy != SyncRebase {
+		return nil, fmt.Errorf("invalid sync strategy %q, expected %s or %s", strategy, SyncMerge, SyncRebase)
+	}
+	if upstream == "" {
+		branch, err := r.currentUserBranch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if upstream = strings.TrimSpace(branch); upstream == "" {
+			return nil, fmt.Errorf("the source repository is in detached HEAD state, an upstream branch must be provided")
+		}
+	}
+	if strings.HasPrefix(upstream, "-") {
+		return nil, fmt.Errorf("invalid upstream branch %q", upstream)
+	}
+	upstreamCommit, err := RunGitCommand(ctx, r.userRepoPath, "rev-parse", "--verify", "--quiet", upstream+"^{commit}")
+	if err != nil {
+		return nil, fmt.Errorf("branch %q not found", upstream)
+	}
+	result := &SyncResult{Upstream: upstream, UpstreamCommit: strings.TrimSpace(upstreamCommit), Strategy: strategy}
+
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := r.initializeWorktree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if strategy, inProgress := r.syncInProgress(ctx, worktree); inProgress {
+		return nil, fmt.Errorf("a %s is already in progress in %s: continue or abort it first", strategy, id)
+	}
+	if status, err := r.managedGit(ctx, worktree, "status", "--porcelain", "--untracked-files=no"); err != nil {
+		return nil, err
+	} else if strings.TrimSpace(status) != "" {
+		return nil, fmt.Errorf("the worktree of %s has uncommitted changes:\n%s", id, status)
+	}
+	before, err := r.trackedFiles(ctx, worktree, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.syncWorktree(ctx, worktree, result, explanation); err != nil {
+		return nil, err
+	}
+	if result.UpToDate {
+		return result, nil
+	}
+	description := fmt.Sprintf("%s %s (%s)", strategy, upstream, shortCommit(result.UpstreamCommit))
+	if err := r.applySync(ctx, dag, env, worktree, before, result, description, explanation); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// SyncStatus returns the sync in progress in environment id, with the state of its conflicts.
+func (r *Repository) SyncStatus(ctx context.Context, id string) (*SyncStatus, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return nil, err
+	}
+	worktree, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(worktree); err != nil {
+		// Syncs happen in the worktree, there's none in progress without one
+		return &SyncStatus{}, nil
+	}
+	strategy, inProgress := r.syncInProgress(ctx, worktree)
+	if !inProgress {
+		return &SyncStatus{}, nil
+	}
+	conflicts, err := r.syncConflicts(ctx, worktree, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncStatus{InProgress: true, Strategy: strategy, Conflicts: conflicts}, nil
+}
+
+// ContinueSync completes the sync in progress in environment id once its conflicts are resolved: the
+// files as they are in the container are committed, and a rebase goes on with the next commits of the
+// environment, which may conflict in turn. If files still have conflict markers, they are reported in
+// the result and nothing is done.
+func (r *Repository) ContinueSync(ctx context.Context, dag *dagger.Client, id, explanation string) (*SyncResult, error) {
+	ctx, unlock, err := r.lockEnvironment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return nil, err
+	}
+	worktree, err := r.WorktreePath(id)
+	if err != nil {
+		return nil, err
+	}
+	strategy, inProgress := r.syncInProgress(ctx, worktree)
+	if !inProgress {
+		return nil, fmt.Errorf("%w in %s", ErrNoSyncInProgress, id)
+	}
+	result := &SyncResult{Strategy: strategy, UpstreamCommit: r.syncUpstreamCommit(ctx, worktree, strategy)}
+
+	// Conflicts may have been resolved in the container without going through the file tools
+	if err := r.exportEnvironment(ctx, env); err != nil {
+		return nil, err
+	}
+	conflicts, err := r.syncConflicts(ctx, worktree, strategy)
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(conflicts, func(c SyncConflict) bool { return !c.Resolved }) {
+		result.Conflicts = conflicts
+		return result, nil
+	}
+	before, err := r.trackedFiles(ctx, worktree, "")
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := r.CommandPolicy()
+	if err != nil {
+		return nil, err
+	}
+	checks := &commitChecks{masker: env.SecretMasker(ctx), scanning: policy.SecretScanning, binaries: policy.BinaryFiles}
+	err = r.continueWorktreeSync(ctx, worktree, result, checks)
+	env.SecretFindings = checks.findings
+	env.SkippedFiles = checks.skipped
+	if err != nil {
+		return nil, err
+	}
+	if err := r.applySync(ctx, dag, env, worktree, before, result, "continue "+string(strategy), explanation); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AbortSync gives up on the sync in progress in environment id, bringing the environment back to where
+// it was before: resolutions made in the container are discarded.
+func (r *Repository) AbortSync(ctx context.Context, dag *dagger.Client, id, explanation string) error {
+	ctx, unlock, err := r.lockEnvironment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	env, err := r.Get(ctx, dag, id)
+	if err != nil {
+		return err
+	}
+	worktree, err := r.WorktreePath(id)
+	if err != nil {
+		return err
+	}
+	strategy, inProgress := r.syncInProgress(ctx, worktree)
+	if !inProgress {
+		return fmt.Errorf("%w in %s", ErrNoSyncInProgress, id)
+	}
+	before, err := r.trackedFiles(ctx, worktree, "")
+	if err != nil {
+		return err
+	}
+	if _, err := r.managedGit(ctx, worktree, string(strategy), "--abort"); err != nil {
+		return err
+	}
+	return r.applySync(ctx, dag, env, worktree, before, &SyncResult{Strategy: strategy}, "abort "+string(strategy), explanation)
+}
+
+// syncWorktree merges the commit result.UpstreamCommit of the source repository into the branch checked out
+// in worktree, or rebases the branch onto it, and fills result in. Conflicts are left in the worktree.
+func (r *Repository) syncWorktree(ctx context.Context, worktree string, result *SyncResult, explanation string) error {
+	// The fork doesn't know the commits of the source repository past the creation of the environment
+	if _, err := r.managedGit(ctx, worktree, "fetch", "--no-tags", r.userRepoPath, result.UpstreamCommit); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", result.Upstream, err)
+	}
+	if _, err := r.managedGit(ctx, worktree, "merge-base", "--is-ancestor", result.UpstreamCommit, "HEAD"); err == nil {
+		result.UpToDate = true
+		return nil
+	}
+	oldHead, err := r.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Syncing worktree", "worktree", worktree, "upstream", result.Upstream, "strategy", result.Strategy)
+	if result.Strategy == SyncRebase {
+		// Carry the log and state notes over to the rebased commits
+		_, err = r.managedGit(ctx, worktree, "-c", "notes.rewriteRef=refs/notes/*", "rebase", result.UpstreamCommit)
+	} else {
+		_, err = r.managedGit(ctx, worktree, "merge", "--no-ff", "--no-edit", "-m", explanation, result.UpstreamCommit)
+	}
+	if err != nil {
+		return r.syncStopped(ctx, worktree, result, err)
+	}
+	return r.syncDone(ctx, worktree, strings.TrimSpace(oldHead), result)
+}
+
+// continueWorktreeSync stages the resolutions of the sync in progress in worktree, applying checks, and
+// continues the sync.
+func (r *Repository) continueWorktreeSync(ctx context.Context, worktree string, result *SyncResult, checks *commitChecks) error {
+	oldHead, err := r.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	// Resolutions can't be left out, even the files addWorktreeFiles skips
+	conflicts, err := r.syncConflicts(ctx, worktree, result.Strategy)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		if _, err := r.managedGit(ctx, worktree, "add", "--all", "--", conflict.Path); err != nil {
+			return err
+		}
+	}
+	if err := r.stageWorktreeChanges(ctx, worktree, checks); err != nil {
+		return err
+	}
+
+	if result.Strategy == SyncRebase {
+		_, err = r.managedGit(ctx, worktree, "-c", "core.editor=true", "-c", "notes.rewriteRef=refs/notes/*", "rebase", "--continue")
+	} else {
+		_, err = r.managedGit(ctx, worktree, "commit", "--no-edit")
+	}
+	if err != nil {
+		return r.syncStopped(ctx, worktree, result, err)
+	}
+	return r.syncDone(ctx, worktree, strings.TrimSpace(oldHead), result)
+}
+
+// syncStopped records the conflicts that stopped the sync in progress in worktree with err. Syncs that
+// failed for other reasons are aborted.
+func (r *Repository) syncStopped(ctx context.Context, worktree string, result *SyncResult, err error) error {
+	conflicts, conflictsErr := r.syncConflicts(ctx, worktree, result.Strategy)
+	if conflictsErr == nil && len(conflicts) > 0 {
+		result.Conflicts = conflicts
+		return nil
+	}
+	if _, abortErr := r.managedGit(ctx, worktree, string(result.Strategy), "--abort"); abortErr != nil {
+		return fmt.Errorf("failed to %s: %w, then to abort: %w", result.Strategy, err, abortErr)
+	}
+	return fmt.Errorf("failed to %s: %w", result.Strategy, err)
+}
+
+// syncDone records the head of worktree after a sync completed, and the files it changed since oldHead.
+func (r *Repository) syncDone(ctx context.Context, worktree, oldHead string, result *SyncResult) error {
+	newHead, err := r.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	result.Commit = strings.TrimSpace(newHead)
+	changed, err := r.managedGit(ctx, worktree, "diff", "--name-only", "--no-renames", oldHead, result.Commit)
+	if err != nil {
+		return err
+	}
+	result.ChangedFiles = strings.Fields(changed)
+	return nil
+}
+
+// applySync updates the container of env with worktree after a sync step, then saves env: the workdir gets
+// the tree of the head of worktree, or its files while conflicts are being resolved. The files of before,
+// those tracked before the step, that are no longer tracked are removed.
+func (r *Repository) applySync(ctx context.Context, dag *dagger.Client, env *environment.Environment, worktree string, before []string, result *SyncResult, description, explanation string) error {
+	var sourceDir *dagger.Directory
+	var after []string
+	var err error
+	if _, inProgress := r.syncInProgress(ctx, worktree); inProgress {
+		sourceDir = dag.Host().Directory(worktree, dagger.HostDirectoryOpts{NoCache: true, Exclude: []string{".git", "**/.git"}})
+		after, err = r.trackedFiles(ctx, worktree, "")
+	} else {
+		var head string
+		if head, err = r.managedGit(ctx, worktree, "rev-parse", "HEAD"); err != nil {
+			return err
+		}
+		// The step may have moved the submodule pointers
+		if err = r.updateSubmodules(ctx, worktree); err != nil {
+			return err
+		}
+		if sourceDir, err = r.sourceDirectory(ctx, dag, worktree, strings.TrimSpace(head)); err != nil {
+			return err
+		}
+		after, err = r.trackedFiles(ctx, worktree, "HEAD")
+	}
+	if err != nil {
+		return err
+	}
+	deleted := []string{}
+	for _, file := range before {
+		if !slices.Contains(after, file) {
+			deleted = append(deleted, file)
+		}
+	}
+
+	if len(result.Conflicts) > 0 {
+		description += fmt.Sprintf(", %d conflicts to resolve", len(result.Conflicts))
+	}
+	if err := env.SyncWorkdir(ctx, sourceDir, deleted, description); err != nil {
+		return err
+	}
+	return r.Update(ctx, env, explanation)
+}
+
+// syncInProgress returns the strategy of the sync stopped by conflicts in worktree, if any.
+func (r *Repository) syncInProgress(ctx context.Context, worktree string) (SyncStrategy, bool) {
+	for _, state := range []struct {
+		path     string
+		strategy SyncStrategy
+	}{
+		{"rebase-merge", SyncRebase},
+		{"rebase-apply", SyncRebase},
+		{"MERGE_HEAD", SyncMerge},
+	} {
+		path, err := r.managedGit(ctx, worktree, "rev-parse", "--path-format=absolute", "--git-path", state.path)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(strings.TrimSpace(path)); err == nil {
+			return state.strategy, true
+		}
+	}
+	return "", false
+}
+
+// syncUpstreamCommit returns the upstream commit of the sync in progress in worktree, if known.
+func (r *Repository) syncUpstreamCommit(ctx context.Context, worktree string, strategy SyncStrategy) string {
+	if strategy == SyncRebase {
+		path, err := r.managedGit(ctx, worktree, "rev-parse", "--path-format=absolute", "--git-path", "rebase-merge/onto")
+		if err != nil {
+			return ""
+		}
+		onto, err := os.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(onto))
+	}
+	commit, err := r.managedGit(ctx, worktree, "rev-parse", "--verify", "--quiet", "MERGE_HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(commit)
+}
+
+// trackedFiles returns the files git tracks in worktree at rev, or in its index if rev is empty.
+func (r *Repository) trackedFiles(ctx context.Context, worktree, rev string) ([]string, error) {
+	args := []string{"ls-files", "-z"}
+	if rev != "" {
+		args = []string{"ls-tree", "-r", "-z", "--name-only", rev}
+	}
+	out, err := r.managedGit(ctx, worktree, args...)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for file := range strings.SplitSeq(out, "\x00") {
+		// Unmerged files are listed once per side in the index
+		if file != "" && !slices.Contains(files, file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// syncConflicts returns the unmerged files of worktree.
+func (r *Repository) syncConflicts(ctx context.Context, worktree string, strategy SyncStrategy) ([]SyncConflict, error) {
+	status, err := r.managedGit(ctx, worktree, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return nil, err
+	}
+	conflicts := []SyncConflict{}
+	for line := range strings.Lines(status) {
+		line = strings.TrimRight(line, "\n")
+		if len(line) < 4 {
+			continue
+		}
+		kind, ok := conflictKinds[line[:2]]
+		if !ok {
+			continue
+		}
+		path := line[3:]
+		conflicts = append(conflicts, SyncConflict{
+			Path:     path,
+			Kind:     conflictKind(kind, strategy),
+			Resolved: !hasConflictMarkers(filepath.Join(worktree, path)),
+		})
+	}
+	return conflicts, nil
+}
+
+// hasConflictMarkers reports whether the file at path has the lines git delimits conflicts with. Deleted
+// files have none: deleting a file is a resolution.
+func hasConflictMarkers(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "<<<<<<< ") || strings.HasPrefix(line, ">>>>>>> ") {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictKind returns kind from the point of view of the environment: when rebasing, git applies the
+// commits of the environment onto upstream, which makes upstream "ours".
+func conflictKind(kind string, strategy SyncStrategy) string {
+	if strategy != SyncRebase {
+		return kind
+	}
+	return strings.NewReplacer("environment", "upstream", "upstream", "environment").Replace(kind)
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncTestRepository returns a repository with an environment worktree forked from its initial commit,
+// and commits to both.
+func syncTestRepository(t *testing.T, envContent, upstreamContent string) (*Repository, string) {
+	t.Helper()
+	ctx := context.Background()
+	source := t.TempDir()
+	basePath := t.TempDir()
+
+	commit := func(dir, name, content string, git func(string, ...string) (string, error)) {
+		writeFile(t, dir, name, content)
+		_, err := git(dir, "add", ".")
+		require.NoError(t, err)
+		_, err = git(dir, "commit", "-m", "Write "+name)
+		require.NoError(t, err)
+	}
+	git := func(dir string, args ...string) (string, error) {
+		return RunGitCommand(ctx, dir, args...)
+	}
+
+	_, err := RunGitCommand(ctx, source, "init", "-b", "main")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, source, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, source, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	commit(source, "README.md", "# app\n", git)
+	commit(source, "obsolete.txt", "obsolete\n", git)
+
+	repo, err := OpenWithBasePath(ctx, source, basePath)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "sync-env")
+	require.NoError(t, err)
+	managed := func(dir string, args ...string) (string, error) {
+		return repo.managedGit(ctx, dir, args...)
+	}
+	commit(worktree, "app.txt", envContent, managed)
+
+	commit(source, "app.txt", upstreamContent, git)
+	_, err = RunGitCommand(ctx, source, "rm", "obsolete.txt")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, source, "commit", "-m", "Remove obsolete.txt")
+	require.NoError(t, err)
+	return repo, worktree
+}
+
+func TestSyncWorktree(t *testing.T) {
+	ctx := context.Background()
+
+	for _, strategy := range []SyncStrategy{SyncMerge, SyncRebase} {
+		t.Run(string(strategy), func(t *testing.T) {
+			repo, worktree := syncTestRepository(t, "same\n", "same\n")
+			upstream, err := RunGitCommand(ctx, repo.userRepoPath, "rev-parse", "main")
+			require.NoError(t, err)
+			result := &SyncResult{Upstream: "main", UpstreamCommit: strings.TrimSpace(upstream), Strategy: strategy}
+
+			require.NoError(t, repo.syncWorktree(ctx, worktree, result, "Sync with main"))
+			assert.Empty(t, result.Conflicts)
+			assert.Contains(t, result.ChangedFiles, "obsolete.txt")
+			assert.NoFileExists(t, filepath.Join(worktree, "obsolete.txt"))
+			_, err = repo.managedGit(ctx, worktree, "merge-base", "--is-ancestor", result.UpstreamCommit, result.Commit)
+			assert.NoError(t, err, "the environment has the upstream commits")
+
+			require.NoError(t, repo.syncWorktree(ctx, worktree, result, "Sync with main"))
+			assert.True(t, result.UpToDate)
+		})
+	}
+}
+
+func TestSyncWorktreeConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	for _, strategy := range []SyncStrategy{SyncMerge, SyncRebase} {
+		t.Run(string(strategy), func(t *testing.T) {
+			repo, worktree := syncTestRepository(t, "environment\n", "upstream\n")
+			upstream, err := RunGitCommand(ctx, repo.userRepoPath, "rev-parse", "main")
+			require.NoError(t, err)
+			result := &SyncResult{Upstream: "main", UpstreamCommit: strings.TrimSpace(upstream), Strategy: strategy}
+
+			require.NoError(t, repo.syncWorktree(ctx, worktree, result, "Sync with main"))
+			assert.Equal(t, []SyncConflict{{Path: "app.txt", Kind: "added by both"}}, result.Conflicts)
+			inProgress, ok := repo.syncInProgress(ctx, worktree)
+			assert.True(t, ok, "the conflicts are left to resolve")
+			assert.Equal(t, strategy, inProgress)
+			content, err := os.ReadFile(filepath.Join(worktree, "app.txt"))
+			require.NoError(t, err)
+			assert.Contains(t, string(content), "<<<<<<< ")
+
+			// Continuing with the conflict markers left does nothing
+			status, err := repo.SyncStatus(ctx, "sync-env")
+			require.NoError(t, err)
+			assert.Equal(t, &SyncStatus{InProgress: true, Strategy: strategy, Conflicts: result.Conflicts}, status)
+
+			writeFile(t, worktree, "app.txt", "environment and upstream\n")
+			conflicts, err := repo.syncConflicts(ctx, worktree, strategy)
+			require.NoError(t, err)
+			assert.Equal(t, []SyncConflict{{Path: "app.txt", Kind: "added by both", Resolved: true}}, conflicts)
+
+			continued := &SyncResult{Strategy: strategy, UpstreamCommit: repo.syncUpstreamCommit(ctx, worktree, strategy)}
+			assert.Equal(t, result.UpstreamCommit, continued.UpstreamCommit)
+			require.NoError(t, repo.continueWorktreeSync(ctx, worktree, continued, nil))
+			assert.Empty(t, continued.Conflicts)
+			_, ok = repo.syncInProgress(ctx, worktree)
+			assert.False(t, ok)
+			_, err = repo.managedGit(ctx, worktree, "merge-base", "--is-ancestor", result.UpstreamCommit, continued.Commit)
+			assert.NoError(t, err, "the environment has the upstream commits")
+			committed, err := repo.managedGit(ctx, worktree, "show", continued.Commit+":app.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "environment and upstream\n", committed)
+		})
+	}
+}
+
+func TestSyncWorktreeAbort(t *testing.T) {
+	ctx := context.Background()
+	repo, worktree := syncTestRepository(t, "environment\n", "upstream\n")
+	head, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	upstream, err := RunGitCommand(ctx, repo.userRepoPath, "rev-parse", "main")
+	require.NoError(t, err)
+	result := &SyncResult{Upstream: "main", UpstreamCommit: strings.TrimSpace(upstream), Strategy: SyncMerge}
+
+	require.NoError(t, repo.syncWorktree(ctx, worktree, result, "Sync with main"))
+	require.NotEmpty(t, result.Conflicts)
+	_, err = repo.managedGit(ctx, worktree, "merge", "--abort")
+	require.NoError(t, err)
+
+	status, err := repo.SyncStatus(ctx, "sync-env")
+	require.NoError(t, err)
+	assert.False(t, status.InProgress)
+	after, err := repo.managedGit(ctx, worktree, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head, after)
+	content, err := os.ReadFile(filepath.Join(worktree, "app.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "environment\n", string(content))
+}
+
+func TestHasConflictMarkers(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "conflicted.txt", "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> upstream\n")
+	writeFile(t, dir, "resolved.txt", "ours and theirs\n=======\n")
+
+	assert.True(t, hasConflictMarkers(filepath.Join(dir, "conflicted.txt")))
+	assert.False(t, hasConflictMarkers(filepath.Join(dir, "resolved.txt")))
+	assert.False(t, hasConflictMarkers(filepath.Join(dir, "deleted.txt")))
+}
+
+func TestConflictKind(t *testing.T) {
+	assert.Equal(t, "deleted by upstream", conflictKind("deleted by upstream", SyncMerge))
+	assert.Equal(t, "deleted by environment", conflictKind("deleted by upstream", SyncRebase))
+	assert.Equal(t, "both modified", conflictKind("both modified", SyncRebase))
+}
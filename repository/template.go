@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"path/filepath"
+
+	"github.com/dagger/container-use/environment"
+	"github.com/mitchellh/go-homedir"
+)
+
+// TemplatesDir returns the directory of user-defined environment templates, templates/ in the
+// container-use data directory.
+func TemplatesDir() (string, error) {
+	return homedir.Expand(filepath.Join(DefaultBasePath(), "templates"))
+}
+
+// Templates returns the environment templates available: the built-in ones and those of TemplatesDir.
+func Templates() ([]*environment.Template, error) {
+	dir, err := TemplatesDir()
+	if err != nil {
+		return nil, err
+	}
+	return environment.Templates(dir)
+}
+
+// Template returns the environment template name, see Templates.
+func Template(name string) (*environment.Template, error) {
+	dir, err := TemplatesDir()
+	if err != nil {
+		return nil, err
+	}
+	return environment.LoadTemplate(dir, name)
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dagger/container-use/environment"
+)
+
+// The read path of environments: metadata and files are read from the branches of the fork repository
+// directly, so that read-only operations don't create or touch worktrees. Worktrees are only set up
+// once an environment is written to, by propagateToWorktree.
+
+// environmentRef is the branch of environment id in the fork repository.
+func environmentRef(id string) string {
+	return "refs/heads/" + id
+}
+
+// loadedEnvironment is the state and configuration of an environment read by load, along with the commits
+// of its branch and of the state notes they were read from.
+type loadedEnvironment struct {
+	commits string
+	state   []byte
+	config  *environment.EnvironmentConfig
+}
+
+// load reads the state and the configuration of environment id from its branch. They are only read again
+// once the branch or the state notes moved.
+func (r *Repository) load(ctx context.Context, id string) ([]byte, *environment.EnvironmentConfig, error) {
+	head, err := r.git.ResolveCommit(ctx, r.forkRepoPath, environmentRef(id))
+	if err != nil {
+		if errors.Is(err, errRevisionNotFound) {
+			return nil, nil, fmt.Errorf("environment %q not found", id)
+		}
+		return nil, nil, err
+	}
+	notes, err := r.git.ResolveCommit(ctx, r.forkRepoPath, "refs/notes/"+gitNotesStateRef)
+	if err != nil && !errors.Is(err, errRevisionNotFound) {
+		return nil, nil, err
+	}
+	commits := head + " " + notes
+
+	var state []byte
+	var config *environment.EnvironmentConfig
+	if cached, ok := r.loaded.Load(id); ok && cached.(*loadedEnvironment).commits == commits {
+		state, config = cached.(*loadedEnvironment).state, cached.(*loadedEnvironment).config.Copy()
+	} else {
+		if state, config, err = r.read(ctx, id); err != nil {
+			return nil, nil, err
+		}
+		r.loaded.Store(id, &loadedEnvironment{commits: commits, state: state, config: config.Copy()})
+	}
+
+	if config.AllowedMounts, err = r.allowedMounts(); err != nil {
+		return nil, nil, err
+	}
+	return state, config, nil
+}
+
+// read reads the state and the configuration of environment id from the fork repository, see load.
+func (r *Repository) read(ctx context.Context, id string) ([]byte, *environment.EnvironmentConfig, error) {
+	state, err := r.git.ReadNote(ctx, r.forkRepoPath, gitNotesStateRef, environmentRef(id))
+	if err != nil {
+		if !errors.Is(err, errNoteNotFound) {
+			return nil, nil, err
+		}
+		state = ""
+	}
+
+	config := environment.DefaultConfig()
+	if err := config.LoadFrom(func(name string) ([]byte, error) {
+		return r.readBlob(ctx, environmentRef(id), name)
+	}); err != nil {
+		return nil, nil, err
+	}
+	if state == "" {
+		return nil, config, nil
+	}
+	return []byte(state), config, nil
+}
+
+// readBlob reads the file at name in revision of the fork repository. Missing files are reported
+// with an error satisfying os.IsNotExist.
+func (r *Repository) readBlob(ctx context.Context, revision, name string) ([]byte, error) {
+	return r.git.ReadBlob(ctx, r.forkRepoPath, revision, name)
+}
+
+// TreeEntry is a file or directory of a revision of an environment.
+type TreeEntry struct {
+	Name string
+	Dir  bool
+	Size int64
+}
+
+// resolveRevision returns the commit of revision in the history of environment id. An empty revision is
+// the latest commit of the environment, and revisions starting with ~ or ^ are relative to it, e.g. ~2.
+func (r *Repository) resolveRevision(ctx context.Context, id, revision string) (string, error) {
+	if err := r.exists(ctx, id); err != nil {
+		return "", err
+	}
+	if revision == "" || strings.HasPrefix(revision, "~") || strings.HasPrefix(revision, "^") {
+		revision = environmentRef(id) + revision
+	}
+	commit, err := r.git.ResolveCommit(ctx, r.forkRepoPath, revision)
+	if err != nil {
+		return "", fmt.Errorf("revision %q not found", revision)
+	}
+	if _, err := r.managedGit(ctx, r.forkRepoPath, "merge-base", "--is-ancestor", commit, environmentRef(id)); err != nil {
+		return "", fmt.Errorf("revision %q is not in the history of environment %s", revision, id)
+	}
+	return commit, nil
+}
+
+// ReadFileAt returns the contents of the file at name, relative to the workdir, in a revision of
+// environment id. See resolveRevision for the syntax of revision.
+func (r *Repository) ReadFileAt(ctx context.Context, id, revision, name string) (string, error) {
+	commit, err := r.resolveRevision(ctx, id, revision)
+	if err != nil {
+		return "", err
+	}
+	data, err := r.readBlob(ctx, commit, path.Clean(name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s at %s: %w", name, commit, err)
+	}
+	return string(data), nil
+}
+
+// ListFilesAt returns the entries of the directory at name, relative to the workdir, in a revision of
+// environment id. See resolveRevision for the syntax of revision.
+func (r *Repository) ListFilesAt(ctx context.Context, id, revision, name string) ([]TreeEntry, error) {
+	commit, err := r.resolveRevision(ctx, id, revision)
+	if err != nil {
+		return nil, err
+	}
+	tree := commit + ":"
+	if name = path.Clean(name); name != "." {
+		tree += name
+	}
+	out, err := r.managedGit(ctx, r.forkRepoPath, "ls-tree", "-l", "-z", tree)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s at %s: %w", name, commit, err)
+	}
+	return parseTree(out), nil
+}
+
+// parseTree parses the output of ls-tree -l -z.
+func parseTree(output string) []TreeEntry {
+	entries := []TreeEntry{}
+	for record := range strings.SplitSeq(output, "\x00") {
+		info, name, ok := strings.Cut(record, "\t")
+		if !ok {
+			continue
+		}
+		// <mode> <type> <object> <size>
+		fields := strings.Fields(info)
+		if len(fields) != 4 {
+			continue
+		}
+		entry := TreeEntry{Name: name, Dir: fields[1] == "tree"}
+		entry.Size, _ = strconv.ParseInt(fields[3], 10, 64)
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWithoutWorktree(t *testing.T) {
+	ctx := context.Background()
+	tempDir := t.TempDir()
+	configDir := t.TempDir()
+
+	_, err := RunGitCommand(ctx, tempDir, "init")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "config", "user.name", "Test User")
+	require.NoError(t, err)
+	writeFile(t, tempDir, "README.md", "# Test")
+	writeFile(t, tempDir, ".container-use/environment.json", `{"workdir": "/src", "base_image": "golang:1.24"}`)
+	_, err = RunGitCommand(ctx, tempDir, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "commit", "-m", "Initial commit")
+	require.NoError(t, err)
+
+	repo, err := OpenWithBasePath(ctx, tempDir, configDir)
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.email", "test@example.com")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, repo.forkRepoPath, "config", "user.name", "Test User")
+	require.NoError(t, err)
+
+	worktree, err := repo.initializeWorktree(ctx, "history-env")
+	require.NoError(t, err)
+	writeFile(t, worktree, "main.go", "package main // v1")
+	_, err = RunGitCommand(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "commit", "-m", "v1")
+	require.NoError(t, err)
+	writeFile(t, worktree, "main.go", "package main // v2")
+	writeFile(t, worktree, "cmd/tool.go", "package cmd")
+	_, err = RunGitCommand(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "commit", "-m", "v2")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "History"}`)
+	require.NoError(t, err)
+	require.NoError(t, os.RemoveAll(worktree))
+
+	info, err := repo.Info(ctx, "history-env")
+	require.NoError(t, err)
+	assert.Equal(t, "History", info.State.Title)
+	assert.Equal(t, "/src", info.Config.Workdir)
+	assert.Equal(t, "golang:1.24", info.Config.BaseImage)
+
+	content, err := repo.ReadFileAt(ctx, "history-env", "", "main.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package main // v2", content)
+	content, err = repo.ReadFileAt(ctx, "history-env", "~1", "main.go")
+	require.NoError(t, err)
+	assert.Equal(t, "package main // v1", content)
+	_, err = repo.ReadFileAt(ctx, "history-env", "~1", "cmd/tool.go")
+	assert.Error(t, err)
+
+	entries, err := repo.ListFilesAt(ctx, "history-env", "", ".")
+	require.NoError(t, err)
+	assert.Contains(t, entries, TreeEntry{Name: "cmd", Dir: true})
+	assert.Contains(t, entries, TreeEntry{Name: "main.go", Size: int64(len("package main // v2"))})
+	entries, err = repo.ListFilesAt(ctx, "history-env", "", "cmd")
+	require.NoError(t, err)
+	assert.Equal(t, []TreeEntry{{Name: "tool.go", Size: int64(len("package cmd"))}}, entries)
+
+	// Revisions outside of the history of the environment are refused
+	_, err = RunGitCommand(ctx, tempDir, "commit", "--allow-empty", "-m", "Unrelated")
+	require.NoError(t, err)
+	head, err := RunGitCommand(ctx, tempDir, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	_, err = RunGitCommand(ctx, tempDir, "push", "--no-verify", containerUseRemote, "HEAD:refs/heads/unrelated")
+	require.NoError(t, err)
+	_, err = repo.ReadFileAt(ctx, "history-env", head[:12], "README.md")
+	assert.ErrorContains(t, err, "not in the history")
+
+	assert.NoDirExists(t, worktree)
+}
+
+func TestLoadCache(t *testing.T) {
+	ctx := context.Background()
+	userRepo := t.TempDir()
+	configDir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"commit", "--allow-empty", "-m", "Initial commit"},
+	} {
+		_, err := RunGitCommand(ctx, userRepo, args...)
+		require.NoError(t, err)
+	}
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(t, err)
+	worktree, err := repo.initializeWorktree(ctx, "cached-env")
+	require.NoError(t, err)
+	_, err = repo.managedGit(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "Before"}`)
+	require.NoError(t, err)
+
+	info, err := repo.Info(ctx, "cached-env")
+	require.NoError(t, err)
+	assert.Equal(t, "Before", info.State.Title)
+	info.Config.BaseImage = "changed-by-caller"
+	info, err = repo.Info(ctx, "cached-env")
+	require.NoError(t, err)
+	assert.NotEqual(t, "changed-by-caller", info.Config.BaseImage, "callers get their own copy of the configuration")
+
+	_, err = repo.managedGit(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-f", "-m", `{"title": "After"}`)
+	require.NoError(t, err)
+	info, err = repo.Info(ctx, "cached-env")
+	require.NoError(t, err)
+	assert.Equal(t, "After", info.State.Title, "a new state is read")
+
+	writeFile(t, worktree, ".container-use/environment.json", `{"workdir": "/app", "base_image": "alpine:3.20"}`)
+	_, err = repo.managedGit(ctx, worktree, "add", ".")
+	require.NoError(t, err)
+	_, err = repo.managedGit(ctx, worktree, "commit", "-m", "Configure")
+	require.NoError(t, err)
+	_, err = repo.managedGit(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "Configured"}`)
+	require.NoError(t, err)
+	info, err = repo.Info(ctx, "cached-env")
+	require.NoError(t, err)
+	assert.Equal(t, "alpine:3.20", info.Config.BaseImage, "a new configuration is read")
+	assert.Equal(t, "Configured", info.State.Title, "the state of the new commit is read")
+
+	_, err = repo.Info(ctx, "missing-env")
+	assert.ErrorContains(t, err, `environment "missing-env" not found`)
+}
+
+// BenchmarkOpenAndInfo measures what every tool call on an environment pays before reaching its container:
+// opening the repository and loading the environment.
+func BenchmarkOpenAndInfo(b *testing.B) {
+	ctx := context.Background()
+	userRepo := b.TempDir()
+	configDir := b.TempDir()
+
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.email", "test@example.com"},
+		{"config", "user.name", "Test User"},
+		{"commit", "--allow-empty", "-m", "Initial commit"},
+	} {
+		_, err := RunGitCommand(ctx, userRepo, args...)
+		require.NoError(b, err)
+	}
+	repo, err := OpenWithBasePath(ctx, userRepo, configDir)
+	require.NoError(b, err)
+	worktree, err := repo.initializeWorktree(ctx, "bench-env")
+	require.NoError(b, err)
+	_, err = repo.managedGit(ctx, worktree, "notes", "--ref", gitNotesStateRef, "add", "-m", `{"title": "Bench"}`)
+	require.NoError(b, err)
+
+	b.Run("open", func(b *testing.B) {
+		for b.Loop() {
+			_, err := OpenWithBasePath(ctx, userRepo, configDir)
+			require.NoError(b, err)
+		}
+	})
+	b.Run("info", func(b *testing.B) {
+		for b.Loop() {
+			_, err := repo.Info(ctx, "bench-env")
+			require.NoError(b, err)
+		}
+	})
+}
@@ -7,3 +7,6 @@ var AgentRules string
 
 //go:embed cursor.mdc
 var CursorRules string
+
+//go:embed windsurf.mdc
+var WindsurfRules string